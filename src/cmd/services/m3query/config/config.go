@@ -170,6 +170,9 @@ type Configuration struct {
 	// WriteForwarding is the write forwarding options.
 	WriteForwarding WriteForwardingConfiguration `yaml:"writeForwarding"`
 
+	// PromRemoteWrite is the prometheus remote write handler options.
+	PromRemoteWrite handleroptions.PromWriteHandlerOptions `yaml:"promRemoteWrite"`
+
 	// Downsample configures how the metrics should be downsampled.
 	Downsample downsample.Configuration `yaml:"downsample"`
 
@@ -29,6 +29,8 @@
 
 	It has these top-level messages:
 		Payload
+		Exemplar
+		ExemplarLabel
 */
 package annotation
 
@@ -36,6 +38,8 @@ import proto "github.com/gogo/protobuf/proto"
 import fmt "fmt"
 import math "math"
 
+import binary "encoding/binary"
+
 import io "io"
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -148,6 +152,19 @@ type Payload struct {
 	OpenMetricsHandleValueResets bool                  `protobuf:"varint,2,opt,name=open_metrics_handle_value_resets,json=openMetricsHandleValueResets,proto3" json:"open_metrics_handle_value_resets,omitempty"`
 	// Used when source_format == GRAPHITE
 	GraphiteType GraphiteType `protobuf:"varint,4,opt,name=graphite_type,json=graphiteType,proto3,enum=annotation.GraphiteType" json:"graphite_type,omitempty"`
+	// Exemplars attached to the series, set when exemplar ingestion is enabled.
+	Exemplars []*Exemplar `protobuf:"bytes,5,rep,name=exemplars" json:"exemplars,omitempty"`
+	// Unit of the series, e.g. bytes or seconds, if known.
+	Unit string `protobuf:"bytes,6,opt,name=unit,proto3" json:"unit,omitempty"`
+	// Set when the value of a counter decreased within the samples of a
+	// single write, if counter reset detection is enabled.
+	CounterReset bool `protobuf:"varint,7,opt,name=counter_reset,json=counterReset,proto3" json:"counter_reset,omitempty"`
+	// Set when the samples are Prometheus stale markers, whose special NaN
+	// value is written as an ordinary NaN, if stale markers are annotated.
+	StaleMarker bool `protobuf:"varint,8,opt,name=stale_marker,json=staleMarker,proto3" json:"stale_marker,omitempty"`
+	// Stable hash of the label set of the series, set when label set hashing
+	// is enabled for downstream deduplication.
+	LabelsHash uint64 `protobuf:"varint,9,opt,name=labels_hash,json=labelsHash,proto3" json:"labels_hash,omitempty"`
 }
 
 func (m *Payload) Reset()                    { *m = Payload{} }
@@ -183,8 +200,101 @@ func (m *Payload) GetGraphiteType() GraphiteType {
 	return GraphiteType_GRAPHITE_UNKNOWN
 }
 
+func (m *Payload) GetExemplars() []*Exemplar {
+	if m != nil {
+		return m.Exemplars
+	}
+	return nil
+}
+
+func (m *Payload) GetUnit() string {
+	if m != nil {
+		return m.Unit
+	}
+	return ""
+}
+
+func (m *Payload) GetCounterReset() bool {
+	if m != nil {
+		return m.CounterReset
+	}
+	return false
+}
+
+func (m *Payload) GetStaleMarker() bool {
+	if m != nil {
+		return m.StaleMarker
+	}
+	return false
+}
+
+func (m *Payload) GetLabelsHash() uint64 {
+	if m != nil {
+		return m.LabelsHash
+	}
+	return 0
+}
+
+type Exemplar struct {
+	Labels         []*ExemplarLabel `protobuf:"bytes,1,rep,name=labels" json:"labels,omitempty"`
+	Value          float64          `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	TimestampNanos int64            `protobuf:"varint,3,opt,name=timestamp_nanos,json=timestampNanos,proto3" json:"timestamp_nanos,omitempty"`
+}
+
+func (m *Exemplar) Reset()                    { *m = Exemplar{} }
+func (m *Exemplar) String() string            { return proto.CompactTextString(m) }
+func (*Exemplar) ProtoMessage()               {}
+func (*Exemplar) Descriptor() ([]byte, []int) { return fileDescriptorAnnotation, []int{1} }
+
+func (m *Exemplar) GetLabels() []*ExemplarLabel {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+func (m *Exemplar) GetValue() float64 {
+	if m != nil {
+		return m.Value
+	}
+	return 0
+}
+
+func (m *Exemplar) GetTimestampNanos() int64 {
+	if m != nil {
+		return m.TimestampNanos
+	}
+	return 0
+}
+
+type ExemplarLabel struct {
+	Name  []byte `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *ExemplarLabel) Reset()                    { *m = ExemplarLabel{} }
+func (m *ExemplarLabel) String() string            { return proto.CompactTextString(m) }
+func (*ExemplarLabel) ProtoMessage()               {}
+func (*ExemplarLabel) Descriptor() ([]byte, []int) { return fileDescriptorAnnotation, []int{2} }
+
+func (m *ExemplarLabel) GetName() []byte {
+	if m != nil {
+		return m.Name
+	}
+	return nil
+}
+
+func (m *ExemplarLabel) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Payload)(nil), "annotation.Payload")
+	proto.RegisterType((*Exemplar)(nil), "annotation.Exemplar")
+	proto.RegisterType((*ExemplarLabel)(nil), "annotation.ExemplarLabel")
 	proto.RegisterEnum("annotation.SourceFormat", SourceFormat_name, SourceFormat_value)
 	proto.RegisterEnum("annotation.OpenMetricsFamilyType", OpenMetricsFamilyType_name, OpenMetricsFamilyType_value)
 	proto.RegisterEnum("annotation.GraphiteType", GraphiteType_name, GraphiteType_value)
@@ -229,6 +339,120 @@ func (m *Payload) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintAnnotation(dAtA, i, uint64(m.GraphiteType))
 	}
+	if len(m.Exemplars) > 0 {
+		for _, msg := range m.Exemplars {
+			dAtA[i] = 0x2a
+			i++
+			i = encodeVarintAnnotation(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.Unit) > 0 {
+		dAtA[i] = 0x32
+		i++
+		i = encodeVarintAnnotation(dAtA, i, uint64(len(m.Unit)))
+		i += copy(dAtA[i:], m.Unit)
+	}
+	if m.CounterReset {
+		dAtA[i] = 0x38
+		i++
+		if m.CounterReset {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.StaleMarker {
+		dAtA[i] = 0x40
+		i++
+		if m.StaleMarker {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.LabelsHash != 0 {
+		dAtA[i] = 0x48
+		i++
+		i = encodeVarintAnnotation(dAtA, i, uint64(m.LabelsHash))
+	}
+	return i, nil
+}
+
+func (m *Exemplar) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Exemplar) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Labels) > 0 {
+		for _, msg := range m.Labels {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintAnnotation(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if m.Value != 0 {
+		dAtA[i] = 0x11
+		i++
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Value))))
+		i += 8
+	}
+	if m.TimestampNanos != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintAnnotation(dAtA, i, uint64(m.TimestampNanos))
+	}
+	return i, nil
+}
+
+func (m *ExemplarLabel) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ExemplarLabel) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Name) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintAnnotation(dAtA, i, uint64(len(m.Name)))
+		i += copy(dAtA[i:], m.Name)
+	}
+	if len(m.Value) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintAnnotation(dAtA, i, uint64(len(m.Value)))
+		i += copy(dAtA[i:], m.Value)
+	}
 	return i, nil
 }
 
@@ -256,6 +480,57 @@ func (m *Payload) Size() (n int) {
 	if m.GraphiteType != 0 {
 		n += 1 + sovAnnotation(uint64(m.GraphiteType))
 	}
+	if len(m.Exemplars) > 0 {
+		for _, e := range m.Exemplars {
+			l = e.Size()
+			n += 1 + l + sovAnnotation(uint64(l))
+		}
+	}
+	l = len(m.Unit)
+	if l > 0 {
+		n += 1 + l + sovAnnotation(uint64(l))
+	}
+	if m.CounterReset {
+		n += 2
+	}
+	if m.StaleMarker {
+		n += 2
+	}
+	if m.LabelsHash != 0 {
+		n += 1 + sovAnnotation(uint64(m.LabelsHash))
+	}
+	return n
+}
+
+func (m *Exemplar) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Labels) > 0 {
+		for _, e := range m.Labels {
+			l = e.Size()
+			n += 1 + l + sovAnnotation(uint64(l))
+		}
+	}
+	if m.Value != 0 {
+		n += 9
+	}
+	if m.TimestampNanos != 0 {
+		n += 1 + sovAnnotation(uint64(m.TimestampNanos))
+	}
+	return n
+}
+
+func (m *ExemplarLabel) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovAnnotation(uint64(l))
+	}
+	l = len(m.Value)
+	if l > 0 {
+		n += 1 + l + sovAnnotation(uint64(l))
+	}
 	return n
 }
 
@@ -378,6 +653,348 @@ func (m *Payload) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Exemplars", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAnnotation
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthAnnotation
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Exemplars = append(m.Exemplars, &Exemplar{})
+			if err := m.Exemplars[len(m.Exemplars)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Unit", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAnnotation
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthAnnotation
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Unit = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CounterReset", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAnnotation
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.CounterReset = bool(v != 0)
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StaleMarker", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAnnotation
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.StaleMarker = bool(v != 0)
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LabelsHash", wireType)
+			}
+			m.LabelsHash = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAnnotation
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.LabelsHash |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipAnnotation(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthAnnotation
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Exemplar) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowAnnotation
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Exemplar: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Exemplar: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAnnotation
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthAnnotation
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Labels = append(m.Labels, &ExemplarLabel{})
+			if err := m.Labels[len(m.Labels)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.Value = float64(math.Float64frombits(v))
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TimestampNanos", wireType)
+			}
+			m.TimestampNanos = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAnnotation
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TimestampNanos |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipAnnotation(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthAnnotation
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ExemplarLabel) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowAnnotation
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ExemplarLabel: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ExemplarLabel: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAnnotation
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthAnnotation
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Name = append(m.Name[:0], dAtA[iNdEx:postIndex]...)
+			if m.Name == nil {
+				m.Name = []byte{}
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAnnotation
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthAnnotation
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Value = append(m.Value[:0], dAtA[iNdEx:postIndex]...)
+			if m.Value == nil {
+				m.Value = []byte{}
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipAnnotation(dAtA[iNdEx:])
@@ -509,33 +1126,44 @@ func init() {
 }
 
 var fileDescriptorAnnotation = []byte{
-	// 441 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x92, 0xc1, 0x6e, 0xd3, 0x40,
-	0x10, 0x86, 0xe3, 0x24, 0x6d, 0xd2, 0xc1, 0x2d, 0xab, 0x85, 0x4a, 0x3e, 0xa0, 0x28, 0x70, 0xaa,
-	0x72, 0x88, 0x25, 0x72, 0xe6, 0x60, 0x2a, 0xc7, 0xb1, 0x90, 0xed, 0x68, 0xed, 0x80, 0xe0, 0xb2,
-	0x5a, 0xc7, 0xdb, 0xc4, 0x52, 0xec, 0xb5, 0xec, 0x0d, 0x52, 0x1e, 0x80, 0x3b, 0xef, 0xc1, 0x8b,
-	0x70, 0xe4, 0x11, 0x50, 0x78, 0x11, 0xe4, 0x2d, 0x25, 0x8b, 0xe8, 0xcd, 0xf3, 0xcf, 0x37, 0xa3,
-	0x6f, 0xac, 0x05, 0x7f, 0x93, 0xcb, 0xed, 0x3e, 0x9d, 0xae, 0x45, 0x61, 0x17, 0xb3, 0x2c, 0xb5,
-	0x8b, 0x99, 0xdd, 0xd4, 0x6b, 0x3b, 0x4b, 0x4b, 0x91, 0x71, 0x7b, 0xc3, 0x4b, 0x5e, 0x33, 0xc9,
-	0x33, 0xbb, 0xaa, 0x85, 0x14, 0x36, 0x2b, 0x4b, 0x21, 0x99, 0xcc, 0x45, 0xa9, 0x7d, 0x4e, 0x55,
-	0x0f, 0xc3, 0x29, 0x79, 0xf5, 0xad, 0x0b, 0x83, 0x25, 0x3b, 0xec, 0x04, 0xcb, 0xf0, 0x27, 0xb0,
-	0x44, 0xc5, 0x4b, 0x5a, 0x70, 0x59, 0xe7, 0xeb, 0x86, 0xde, 0xb1, 0x22, 0xdf, 0x1d, 0xa8, 0x3c,
-	0x54, 0xdc, 0x32, 0xc6, 0xc6, 0xcd, 0xd5, 0xeb, 0x97, 0x53, 0x6d, 0x59, 0x54, 0xf1, 0x32, 0xb8,
-	0x47, 0xe7, 0x8a, 0x4c, 0x0e, 0x15, 0x27, 0xd7, 0xe2, 0xb1, 0x18, 0xcf, 0x61, 0xfc, 0xcf, 0xee,
-	0x2d, 0x2b, 0xb3, 0x1d, 0xa7, 0x9f, 0xd9, 0x6e, 0xcf, 0x69, 0xcd, 0x1b, 0x2e, 0x1b, 0xab, 0x3b,
-	0x36, 0x6e, 0x86, 0xe4, 0x85, 0xb6, 0x60, 0xa1, 0xa8, 0xf7, 0x2d, 0x44, 0x14, 0x83, 0xdf, 0xc0,
-	0x65, 0x23, 0xf6, 0xf5, 0x9a, 0xd3, 0x3b, 0x51, 0x17, 0x4c, 0x5a, 0x3d, 0x25, 0x66, 0xe9, 0x62,
-	0xb1, 0x02, 0xe6, 0xaa, 0x4f, 0xcc, 0x46, 0xab, 0xda, 0xf1, 0x4d, 0xcd, 0xaa, 0x6d, 0x2e, 0xf9,
-	0xfd, 0x5d, 0xfd, 0xff, 0xc7, 0xbd, 0x3f, 0x80, 0x3a, 0xc7, 0xdc, 0x68, 0xd5, 0x64, 0x0a, 0xa6,
-	0xbe, 0x1c, 0x23, 0x30, 0xa3, 0xa5, 0x1b, 0xd2, 0xc0, 0x4d, 0x88, 0x7f, 0x1b, 0xa3, 0x0e, 0x36,
-	0x61, 0xe8, 0x11, 0x67, 0xb9, 0xf0, 0x13, 0x17, 0x19, 0x93, 0x2f, 0x06, 0x5c, 0x3f, 0xfa, 0x9b,
-	0xf0, 0x13, 0x18, 0xac, 0xc2, 0x77, 0x61, 0xf4, 0x21, 0x44, 0x9d, 0xb6, 0xb8, 0x8d, 0x56, 0x61,
-	0xe2, 0x12, 0x64, 0xe0, 0x0b, 0x38, 0xf3, 0x9c, 0x95, 0xe7, 0xa2, 0x2e, 0xbe, 0x84, 0x8b, 0x85,
-	0x1f, 0x27, 0x91, 0x47, 0x9c, 0x00, 0xf5, 0xf0, 0x33, 0x78, 0xaa, 0x3a, 0xf4, 0x14, 0xf6, 0xdb,
-	0xd9, 0x78, 0x15, 0x04, 0x0e, 0xf9, 0x88, 0xce, 0xf0, 0x10, 0xfa, 0x7e, 0x38, 0x8f, 0xd0, 0x79,
-	0xeb, 0x11, 0x27, 0x4e, 0xe2, 0xc6, 0x6e, 0x82, 0x06, 0x93, 0x14, 0x4c, 0xfd, 0x2a, 0xfc, 0x1c,
-	0xd0, 0x83, 0x25, 0x3d, 0x69, 0xe8, 0xe9, 0xc9, 0x07, 0xc3, 0xd5, 0xdf, 0xf4, 0x41, 0x4c, 0xcf,
-	0x12, 0x3f, 0x70, 0x09, 0xea, 0xbd, 0x45, 0xdf, 0x8f, 0x23, 0xe3, 0xc7, 0x71, 0x64, 0xfc, 0x3c,
-	0x8e, 0x8c, 0xaf, 0xbf, 0x46, 0x9d, 0xf4, 0x5c, 0x3d, 0xb7, 0xd9, 0xef, 0x00, 0x00, 0x00, 0xff,
-	0xff, 0x36, 0xb4, 0x60, 0xa4, 0xbb, 0x02, 0x00, 0x00,
+	// 619 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x93, 0xcd, 0x6e, 0xdb, 0x38,
+	0x10, 0xc7, 0xc3, 0xf8, 0x7b, 0x2c, 0x27, 0x04, 0x37, 0x01, 0xb4, 0xc0, 0xc2, 0xeb, 0x64, 0x0f,
+	0x6b, 0xe4, 0x60, 0xa1, 0xc9, 0xa9, 0x87, 0x1e, 0xdc, 0x40, 0xfe, 0x40, 0x2b, 0x39, 0xa0, 0xe5,
+	0x16, 0xed, 0x45, 0xa0, 0x6d, 0xc6, 0x16, 0x2a, 0x91, 0x82, 0x44, 0x17, 0x35, 0xd0, 0x6b, 0xef,
+	0x7d, 0xac, 0x1e, 0xfb, 0x00, 0x3d, 0x14, 0xe9, 0x8b, 0x14, 0xa2, 0x9c, 0x58, 0x41, 0x73, 0xe3,
+	0xfc, 0xe6, 0x3f, 0xa3, 0xff, 0x70, 0x28, 0x18, 0xaf, 0x02, 0xb5, 0xde, 0xcc, 0x7b, 0x0b, 0x19,
+	0x59, 0xd1, 0xd5, 0x72, 0x6e, 0x45, 0x57, 0x56, 0x9a, 0x2c, 0xac, 0xe5, 0x5c, 0xc8, 0x25, 0xb7,
+	0x56, 0x5c, 0xf0, 0x84, 0x29, 0xbe, 0xb4, 0xe2, 0x44, 0x2a, 0x69, 0x31, 0x21, 0xa4, 0x62, 0x2a,
+	0x90, 0xa2, 0x70, 0xec, 0xe9, 0x1c, 0x81, 0x3d, 0x39, 0xff, 0x51, 0x82, 0xda, 0x0d, 0xdb, 0x86,
+	0x92, 0x2d, 0xc9, 0x7b, 0x30, 0x65, 0xcc, 0x85, 0x1f, 0x71, 0x95, 0x04, 0x8b, 0xd4, 0xbf, 0x65,
+	0x51, 0x10, 0x6e, 0x7d, 0xb5, 0x8d, 0xb9, 0x89, 0x3a, 0xa8, 0x7b, 0x74, 0x79, 0xd6, 0x2b, 0x34,
+	0x9b, 0xc4, 0x5c, 0x38, 0xb9, 0x74, 0xa0, 0x95, 0xde, 0x36, 0xe6, 0xf4, 0x54, 0x3e, 0x85, 0xc9,
+	0x00, 0x3a, 0x8f, 0x7a, 0xaf, 0x99, 0x58, 0x86, 0xdc, 0xff, 0xc8, 0xc2, 0x0d, 0xf7, 0x13, 0x9e,
+	0x72, 0x95, 0x9a, 0x87, 0x1d, 0xd4, 0xad, 0xd3, 0x7f, 0x0a, 0x0d, 0x46, 0x5a, 0xf5, 0x26, 0x13,
+	0x51, 0xad, 0x21, 0x2f, 0xa0, 0x95, 0xca, 0x4d, 0xb2, 0xe0, 0xfe, 0xad, 0x4c, 0x22, 0xa6, 0xcc,
+	0x92, 0x36, 0x66, 0x16, 0x8d, 0x4d, 0xb5, 0x60, 0xa0, 0xf3, 0xd4, 0x48, 0x0b, 0x51, 0x56, 0xbe,
+	0x4a, 0x58, 0xbc, 0x0e, 0x14, 0xcf, 0xe7, 0x2a, 0xff, 0x59, 0x3e, 0xdc, 0x09, 0xf4, 0x38, 0xc6,
+	0xaa, 0x10, 0x91, 0x4b, 0x68, 0xf0, 0x4f, 0x3c, 0x8a, 0x43, 0x96, 0xa4, 0x66, 0xa5, 0x53, 0xea,
+	0x36, 0x2f, 0x4f, 0x8a, 0xa5, 0xf6, 0x2e, 0x49, 0xf7, 0x32, 0x42, 0xa0, 0xbc, 0x11, 0x81, 0x32,
+	0xab, 0x1d, 0xd4, 0x6d, 0x50, 0x7d, 0x26, 0xff, 0x41, 0x6b, 0x21, 0x37, 0x42, 0xf1, 0x24, 0x9f,
+	0xdd, 0xac, 0xe9, 0xd1, 0x8d, 0x1d, 0xd4, 0xb3, 0x92, 0x33, 0x30, 0x52, 0xc5, 0x42, 0xee, 0x47,
+	0x2c, 0xf9, 0xc0, 0x13, 0xb3, 0xae, 0x35, 0x4d, 0xcd, 0x1c, 0x8d, 0xc8, 0xbf, 0xd0, 0x0c, 0xd9,
+	0x9c, 0x87, 0xd9, 0x7d, 0xa6, 0x6b, 0xb3, 0xd1, 0x41, 0xdd, 0x32, 0x85, 0x1c, 0x8d, 0x58, 0xba,
+	0x3e, 0xff, 0x0c, 0xf5, 0x7b, 0x4f, 0xe4, 0x19, 0x54, 0xf3, 0x8c, 0x89, 0xb4, 0xf3, 0xbf, 0x9f,
+	0x72, 0xfe, 0x3a, 0x53, 0xd0, 0x9d, 0x90, 0x9c, 0x40, 0x45, 0x6f, 0x48, 0xaf, 0x06, 0xd1, 0x3c,
+	0x20, 0xff, 0xc3, 0xb1, 0x0a, 0x22, 0x9e, 0x2a, 0x16, 0xc5, 0xbe, 0x60, 0x42, 0xa6, 0x7a, 0x0b,
+	0x25, 0x7a, 0xf4, 0x80, 0xdd, 0x8c, 0x9e, 0x3f, 0x87, 0xd6, 0xa3, 0xbe, 0xd9, 0x5d, 0x08, 0x16,
+	0xe5, 0xaf, 0xc9, 0xa0, 0xfa, 0xfc, 0xf8, 0x1b, 0xc6, 0xee, 0x1b, 0x17, 0x3d, 0x30, 0x8a, 0x6b,
+	0x24, 0x18, 0x8c, 0xc9, 0x8d, 0xed, 0xfa, 0x8e, 0xed, 0xd1, 0xf1, 0xf5, 0x14, 0x1f, 0x10, 0x03,
+	0xea, 0x43, 0xda, 0xbf, 0x19, 0x8d, 0x3d, 0x1b, 0xa3, 0x8b, 0x2f, 0x08, 0x4e, 0x9f, 0x7c, 0x90,
+	0xa4, 0x09, 0xb5, 0x99, 0xfb, 0xca, 0x9d, 0xbc, 0x75, 0xf1, 0x41, 0x16, 0x5c, 0x4f, 0x66, 0xae,
+	0x67, 0x53, 0x8c, 0x48, 0x03, 0x2a, 0xc3, 0xfe, 0x6c, 0x68, 0xe3, 0x43, 0xd2, 0x82, 0xc6, 0x68,
+	0x3c, 0xf5, 0x26, 0x43, 0xda, 0x77, 0x70, 0x89, 0xfc, 0x05, 0xc7, 0x3a, 0xe3, 0xef, 0x61, 0x39,
+	0xab, 0x9d, 0xce, 0x1c, 0xa7, 0x4f, 0xdf, 0xe1, 0x0a, 0xa9, 0x43, 0x79, 0xec, 0x0e, 0x26, 0xb8,
+	0x9a, 0xf9, 0x98, 0x7a, 0x7d, 0xcf, 0x9e, 0xda, 0x1e, 0xae, 0x5d, 0xcc, 0xc1, 0x28, 0xbe, 0x1f,
+	0x72, 0x02, 0xf8, 0xde, 0xa5, 0xbf, 0xb7, 0x51, 0xa4, 0x7b, 0x3f, 0x04, 0x8e, 0x1e, 0xe8, 0xbd,
+	0xb1, 0x22, 0xf3, 0xc6, 0x8e, 0x4d, 0x71, 0xe9, 0x25, 0xfe, 0x76, 0xd7, 0x46, 0xdf, 0xef, 0xda,
+	0xe8, 0xe7, 0x5d, 0x1b, 0x7d, 0xfd, 0xd5, 0x3e, 0x98, 0x57, 0xf5, 0x8f, 0x7d, 0xf5, 0x3b, 0x00,
+	0x00, 0xff, 0xff, 0x1b, 0x2c, 0x76, 0xe1, 0x25, 0x04, 0x00, 0x00,
 }
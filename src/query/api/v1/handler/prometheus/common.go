@@ -21,6 +21,7 @@
 package prometheus
 
 import (
+	"encoding/binary"
 	goerrors "errors"
 	"fmt"
 	"io"
@@ -55,6 +56,11 @@ const (
 	tolerance           = 0.0000001
 )
 
+// ErrTruncatedBody is returned when a snappy compressed request body ends
+// before its declared length, such as when a client connection drops mid
+// stream, as opposed to a body that is malformed.
+var ErrTruncatedBody = goerrors.New("truncated request body")
+
 // ParsePromCompressedRequestResult is the result of a
 // ParsePromCompressedRequest call.
 type ParsePromCompressedRequestResult struct {
@@ -62,9 +68,32 @@ type ParsePromCompressedRequestResult struct {
 	UncompressedBody []byte
 }
 
+// ParsePromCompressedRequestOptions is the options for parsing a snappy
+// compressed request from Prometheus.
+type ParsePromCompressedRequestOptions struct {
+	// MaxDecompressionRatio optionally rejects bodies whose decompressed size
+	// exceeds their compressed size by more than the ratio, before they are
+	// decompressed, zero disables the check.
+	MaxDecompressionRatio float64
+	// Reserve is optionally called with the decompressed size of the body
+	// before it is decompressed, rejecting the body with the error returned
+	// if any, so that memory can be budgeted before it is allocated.
+	Reserve func(decodedLen int) error
+}
+
 // ParsePromCompressedRequest parses a snappy compressed request from Prometheus.
 func ParsePromCompressedRequest(
 	r *http.Request,
+) (ParsePromCompressedRequestResult, error) {
+	return ParsePromCompressedRequestWithOptions(r,
+		ParsePromCompressedRequestOptions{})
+}
+
+// ParsePromCompressedRequestWithOptions parses a snappy compressed request
+// from Prometheus with the given options.
+func ParsePromCompressedRequestWithOptions(
+	r *http.Request,
+	opts ParsePromCompressedRequestOptions,
 ) (ParsePromCompressedRequestResult, error) {
 	body := r.Body
 	if r.Body == nil {
@@ -76,12 +105,49 @@ func ParsePromCompressedRequest(
 	defer body.Close()
 
 	compressed, err := ioutil.ReadAll(body)
+	if goerrors.Is(err, io.ErrUnexpectedEOF) {
+		err := fmt.Errorf("%w: %v", ErrTruncatedBody, err)
+		return ParsePromCompressedRequestResult{},
+			xerrors.NewInvalidParamsError(err)
+	}
 	if err != nil {
 		return ParsePromCompressedRequestResult{}, err
 	}
+	if n := int64(len(compressed)); r.ContentLength > 0 && n < r.ContentLength {
+		err := fmt.Errorf("%w: read %d of %d bytes", ErrTruncatedBody,
+			n, r.ContentLength)
+		return ParsePromCompressedRequestResult{},
+			xerrors.NewInvalidParamsError(err)
+	}
+
+	maxRatio := opts.MaxDecompressionRatio
+	if (maxRatio > 0 || opts.Reserve != nil) && len(compressed) > 0 {
+		// NB: The decompressed size is read from the block header so that
+		// bodies exceeding the ratio or the reservation are never
+		// decompressed.
+		decodedLen, err := snappy.DecodedLen(compressed)
+		if err != nil {
+			return ParsePromCompressedRequestResult{},
+				xerrors.NewInvalidParamsError(err)
+		}
+		if ratio := float64(decodedLen) / float64(len(compressed)); maxRatio > 0 && ratio > maxRatio {
+			err := fmt.Errorf("decompression ratio exceeds max: %.2f > %.2f",
+				ratio, maxRatio)
+			return ParsePromCompressedRequestResult{},
+				xerrors.NewInvalidParamsError(err)
+		}
+		if opts.Reserve != nil {
+			if err := opts.Reserve(decodedLen); err != nil {
+				return ParsePromCompressedRequestResult{}, err
+			}
+		}
+	}
 
 	reqBuf, err := snappy.Decode(nil, compressed)
 	if err != nil {
+		if isTruncatedSnappyBlock(compressed) {
+			err = fmt.Errorf("%w: %v", ErrTruncatedBody, err)
+		}
 		return ParsePromCompressedRequestResult{},
 			xerrors.NewInvalidParamsError(err)
 	}
@@ -92,6 +158,82 @@ func ParsePromCompressedRequest(
 	}, nil
 }
 
+// isTruncatedSnappyBlock returns whether the snappy block is a valid prefix
+// of a block, i.e. it runs out before producing its declared decoded length
+// without being otherwise corrupt.
+func isTruncatedSnappyBlock(src []byte) bool {
+	if len(src) == 0 {
+		return false
+	}
+	dLen, n := binary.Uvarint(src)
+	if n == 0 {
+		// The length header itself was cut short.
+		return true
+	}
+	if n < 0 {
+		return false
+	}
+
+	var d uint64
+	for s := n; s < len(src); {
+		var length, offset uint64
+		switch src[s] & 0x03 {
+		case 0x00:
+			// Literal, with its length stored in the tag or the 1-4 bytes
+			// following it.
+			x := uint64(src[s] >> 2)
+			if x >= 60 {
+				size := int(x) - 59
+				if s+1+size > len(src) {
+					return true
+				}
+				x = 0
+				for i := 0; i < size; i++ {
+					x |= uint64(src[s+1+i]) << (8 * i)
+				}
+				s += size
+			}
+			s++
+			length = x + 1
+			if length > dLen-d {
+				return false
+			}
+			if uint64(len(src)-s) < length {
+				return true
+			}
+			s += int(length)
+			d += length
+			continue
+		case 0x01:
+			if s+2 > len(src) {
+				return true
+			}
+			length = 4 + uint64(src[s]>>2&0x7)
+			offset = uint64(src[s]&0xe0)<<3 | uint64(src[s+1])
+			s += 2
+		case 0x02:
+			if s+3 > len(src) {
+				return true
+			}
+			length = 1 + uint64(src[s]>>2)
+			offset = uint64(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		default:
+			if s+5 > len(src) {
+				return true
+			}
+			length = 1 + uint64(src[s]>>2)
+			offset = uint64(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset == 0 || offset > d || length > dLen-d {
+			return false
+		}
+		d += length
+	}
+	return d < dLen
+}
+
 // TagCompletionQueries are tag completion queries.
 type TagCompletionQueries struct {
 	// Queries are the tag completion queries.
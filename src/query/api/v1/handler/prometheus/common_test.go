@@ -23,10 +23,14 @@ package prometheus
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/m3db/m3/src/query/models"
@@ -35,6 +39,7 @@ import (
 	xerrors "github.com/m3db/m3/src/x/errors"
 	xhttp "github.com/m3db/m3/src/x/net/http"
 
+	"github.com/golang/snappy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -66,6 +71,88 @@ func TestPromCompressedReadInvalidEncoding(t *testing.T) {
 	assert.True(t, xerrors.IsInvalidParams(err))
 }
 
+func TestPromCompressedReadMaxDecompressionRatio(t *testing.T) {
+	// Zeros compress far beyond the ratio, while the request body of a read
+	// does not.
+	highRatioBody := snappy.Encode(nil, make([]byte, 1<<16))
+	opts := ParsePromCompressedRequestOptions{MaxDecompressionRatio: 5}
+
+	req := httptest.NewRequest("POST", "/dummy", bytes.NewReader(highRatioBody))
+	_, err := ParsePromCompressedRequestWithOptions(req, opts)
+	require.Error(t, err)
+	assert.True(t, xerrors.IsInvalidParams(err))
+
+	req = httptest.NewRequest("POST", "/dummy", bytes.NewReader(highRatioBody))
+	result, err := ParsePromCompressedRequest(req)
+	require.NoError(t, err)
+	assert.Len(t, result.UncompressedBody, 1<<16)
+
+	req = httptest.NewRequest("POST", "/dummy", test.GeneratePromReadBody(t))
+	_, err = ParsePromCompressedRequestWithOptions(req, opts)
+	assert.NoError(t, err)
+}
+
+func TestPromCompressedReadReserve(t *testing.T) {
+	body := snappy.Encode(nil, make([]byte, 1<<16))
+	errReserve := errors.New("reserve error")
+
+	var reserved []int
+	opts := ParsePromCompressedRequestOptions{
+		Reserve: func(decodedLen int) error {
+			reserved = append(reserved, decodedLen)
+			return nil
+		},
+	}
+	req := httptest.NewRequest("POST", "/dummy", bytes.NewReader(body))
+	result, err := ParsePromCompressedRequestWithOptions(req, opts)
+	require.NoError(t, err)
+	assert.Len(t, result.UncompressedBody, 1<<16)
+	assert.Equal(t, []int{1 << 16}, reserved)
+
+	// The reservation is made before decompressing, so a corrupt body is
+	// rejected with the error of the reservation.
+	opts.Reserve = func(int) error { return errReserve }
+	corrupt := append([]byte(nil), body[:len(body)/2]...)
+	req = httptest.NewRequest("POST", "/dummy", bytes.NewReader(corrupt))
+	_, err = ParsePromCompressedRequestWithOptions(req, opts)
+	assert.Equal(t, errReserve, err)
+}
+
+func TestPromCompressedReadTruncatedBody(t *testing.T) {
+	body, err := ioutil.ReadAll(test.GeneratePromReadBody(t))
+	require.NoError(t, err)
+
+	for _, n := range []int{1, len(body) / 2, len(body) - 1} {
+		req := httptest.NewRequest("POST", "/dummy", bytes.NewReader(body[:n]))
+		_, err := ParsePromCompressedRequest(req)
+		require.Error(t, err, "n=%d", n)
+		assert.True(t, xerrors.IsInvalidParams(err))
+		assert.True(t, xerrors.Is(err, ErrTruncatedBody), "n=%d", n)
+	}
+
+	// Shorter than the declared content length.
+	req := httptest.NewRequest("POST", "/dummy", bytes.NewReader(body[:len(body)-1]))
+	req.ContentLength = int64(len(body))
+	_, err = ParsePromCompressedRequest(req)
+	require.Error(t, err)
+	assert.True(t, xerrors.Is(err, ErrTruncatedBody))
+
+	// A connection dropped mid stream.
+	req = httptest.NewRequest("POST", "/dummy", ioutil.NopCloser(io.MultiReader(
+		bytes.NewReader(body[:len(body)/2]), iotest.ErrReader(io.ErrUnexpectedEOF))))
+	_, err = ParsePromCompressedRequest(req)
+	require.Error(t, err)
+	assert.True(t, xerrors.IsInvalidParams(err))
+	assert.True(t, xerrors.Is(err, ErrTruncatedBody))
+
+	// A copy with a zero offset is corrupt rather than truncated.
+	req = httptest.NewRequest("POST", "/dummy", bytes.NewReader([]byte{0x01, 0x01, 0x00}))
+	_, err = ParsePromCompressedRequest(req)
+	require.Error(t, err)
+	assert.True(t, xerrors.IsInvalidParams(err))
+	assert.False(t, xerrors.Is(err, ErrTruncatedBody))
+}
+
 type writer struct {
 	value string
 }
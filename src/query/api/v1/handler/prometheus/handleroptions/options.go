@@ -23,7 +23,9 @@ package handleroptions
 import (
 	"time"
 
+	"github.com/m3db/m3/src/query/storage/m3/storagemetadata"
 	"github.com/m3db/m3/src/x/retry"
+	"github.com/m3db/m3/src/x/sampler"
 )
 
 // PromWriteHandlerForwardingOptions is the forwarding
@@ -34,6 +36,36 @@ type PromWriteHandlerForwardingOptions struct {
 	Timeout        time.Duration                          `yaml:"timeout"`
 	Retry          *retry.Configuration                   `yaml:"retry"`
 	Targets        []PromWriteHandlerForwardTargetOptions `yaml:"targets"`
+	// MaxLatency optionally caps the recorded forward latency, negative
+	// latencies caused by clock skew are always recorded as zero.
+	MaxLatency time.Duration `yaml:"maxLatency"`
+	// Transport optionally tunes connection reuse by the forwarding client.
+	Transport *PromWriteHandlerForwardTransportOptions `yaml:"transport"`
+	// MaxTargetsPerRequest optionally caps the number of targets a single
+	// request is forwarded to, as a safety net against misconfigured target
+	// lists. Eligible targets beyond the cap are skipped in configured order,
+	// primary targets taking precedence over fallback targets. Zero disables
+	// the cap.
+	MaxTargetsPerRequest int `yaml:"maxTargetsPerRequest"`
+	// SelectableTargets optionally names the targets that requests may
+	// select with the forward targets header, in which case the request is
+	// only forwarded to the selected targets. Requests naming any other
+	// target are rejected.
+	SelectableTargets []string `yaml:"selectableTargets"`
+}
+
+// PromWriteHandlerForwardTransportOptions is the connection reuse tuning of
+// the forwarding client, a zero value keeps the default.
+type PromWriteHandlerForwardTransportOptions struct {
+	// MaxIdleConns is the max idle connections kept both in total and per
+	// target host, defaults to 100.
+	MaxIdleConns int `yaml:"maxIdleConns"`
+	// IdleConnTimeout is how long an idle connection is kept before being
+	// closed, defaults to 60s.
+	IdleConnTimeout time.Duration `yaml:"idleConnTimeout"`
+	// MaxConnsPerHost is the max connections per target host including
+	// active connections, defaults to no limit.
+	MaxConnsPerHost int `yaml:"maxConnsPerHost"`
 }
 
 // PromWriteHandlerForwardTargetOptions is a prometheus write
@@ -51,6 +83,188 @@ type PromWriteHandlerForwardTargetOptions struct {
 	NoRetry bool `yaml:"noRetry"`
 	// Shadow defines options that are specific only to shadowing data.
 	Shadow *PromWriteHandlerForwardTargetShadowOptions `yaml:"shadow"`
+	// Timeout optionally overrides the forwarding timeout for this target.
+	Timeout time.Duration `yaml:"timeout"`
+	// MetricsType optionally restricts forwarding to only requests that
+	// resolve to the given metrics type, if unset all requests are forwarded.
+	MetricsType storagemetadata.MetricsType `yaml:"metricsType"`
+	// Transform optionally names a transform registered with the handler
+	// options that rewrites the request forwarded to this target, the
+	// locally written request is not affected.
+	Transform string `yaml:"transform"`
+	// Fallback marks the target as a fallback, which is only forwarded to
+	// when forwarding to every non-fallback target failed for the request.
+	Fallback bool `yaml:"fallback"`
+	// SampleEvery optionally keeps only every Nth sample of each series in
+	// the request forwarded to this target, starting with the first, to
+	// reduce the resolution forwarded, values of one or less forward every
+	// sample. The locally written request is not affected.
+	SampleEvery int `yaml:"sampleEvery"`
+	// ChunkSeries optionally forwards the request to this target as a
+	// sequence of requests of at most this many series each, sending each
+	// only once the previous was acknowledged and stopping at the first
+	// failure, for targets sensitive to backpressure. A retry forwards every
+	// chunk again.
+	ChunkSeries int `yaml:"chunkSeries"`
+	// Schedule optionally restricts forwarding to this target to a recurring
+	// time window, outside of which requests are not forwarded to it.
+	Schedule *PromWriteHandlerForwardScheduleOptions `yaml:"schedule"`
+	// OAuth2 optionally authenticates requests forwarded to this target with
+	// a bearer token obtained using the OAuth2 client credentials flow.
+	OAuth2 *PromWriteHandlerForwardOAuth2Options `yaml:"oauth2"`
+	// OverrideHeaders optionally replaces headers on requests forwarded to
+	// this target, including the M3 headers copied from the incoming request
+	// such as the metrics type and storage policy, so that the target can
+	// write the data differently than locally. An empty value removes the
+	// header.
+	OverrideHeaders map[string]string `yaml:"overrideHeaders"`
+	// SigV4 optionally signs requests forwarded to this target with AWS
+	// Signature Version 4, it cannot be used along with OAuth2.
+	SigV4 *PromWriteHandlerForwardSigV4Options `yaml:"sigv4"`
+	// Compression optionally recompresses the bodies forwarded to this
+	// target, which are otherwise snappy encoded, with another encoding or
+	// level, trading CPU for bandwidth.
+	Compression *PromWriteHandlerForwardCompressionOptions `yaml:"compression"`
+	// Serial forwards every request to this target by a single dedicated
+	// goroutine, bypassing the forwarding worker pool, for targets that
+	// cannot tolerate concurrent requests. Forwards are delivered strictly
+	// in order and are dropped if too many are queued.
+	Serial bool `yaml:"serial"`
+	// Name optionally names the target so that it can be selected by
+	// requests, names must be unique.
+	Name string `yaml:"name"`
+	// SelectedOnly only forwards to this target requests that select it
+	// with the forward targets header, such as for canarying a target with
+	// a specific client, it must be a selectable target.
+	SelectedOnly bool `yaml:"selectedOnly"`
+	// Retry optionally overrides the forwarding retry configuration for this
+	// target, fields that are not set keep the forwarding configuration so
+	// that, for instance, a flaky far target can retry more than a healthy
+	// near one. It is ignored if NoRetry is set.
+	Retry *retry.Configuration `yaml:"retry"`
+	// ShardGroup optionally names a group of targets that requests are
+	// sharded across, each series being forwarded to exactly one target of
+	// the group by a hash of its labels, for downstream stores that are
+	// themselves sharded. A target's shard is its position within the group
+	// in configured order, so reordering or resizing the group remaps
+	// series. Targets of a group cannot be fallbacks.
+	ShardGroup string `yaml:"shardGroup"`
+	// RateLimit optionally limits the rate of requests forwarded to this
+	// target, such as for metered endpoints with a requests per second
+	// quota, independently of the forwarding worker pool concurrency.
+	RateLimit *PromWriteHandlerForwardRateLimitOptions `yaml:"rateLimit"`
+	// Uncompressed forwards the raw protobuf of requests to this target with
+	// the identity content encoding rather than snappy encoded, such as for
+	// debugging targets whose traffic is inspected by packet captures. It
+	// cannot be used along with Compression.
+	Uncompressed bool `yaml:"uncompressed"`
+}
+
+// PromWriteHandlerForwardRateLimitOptions is the token bucket rate limit of
+// requests forwarded to a target.
+type PromWriteHandlerForwardRateLimitOptions struct {
+	// RequestsPerSecond is the rate at which requests are forwarded.
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	// Burst is the max number of requests forwarded at once after the
+	// target was idle, defaults to 1.
+	Burst int `yaml:"burst"`
+	// Mode is the action taken with requests exceeding the rate, defaults
+	// to drop.
+	Mode PromWriteHandlerForwardRateLimitMode `yaml:"mode"`
+	// MaxDelay is the max time a request is delayed in queue mode, requests
+	// that would be delayed longer are dropped, defaults to 10s.
+	MaxDelay time.Duration `yaml:"maxDelay"`
+}
+
+// PromWriteHandlerForwardRateLimitMode is the action taken with requests
+// forwarded to a target in excess of its rate limit.
+type PromWriteHandlerForwardRateLimitMode string
+
+const (
+	// PromWriteHandlerForwardRateLimitModeDrop drops the requests.
+	PromWriteHandlerForwardRateLimitModeDrop PromWriteHandlerForwardRateLimitMode = "drop"
+	// PromWriteHandlerForwardRateLimitModeQueue delays the requests until
+	// they are within the rate, occupying a forwarding worker while delayed.
+	PromWriteHandlerForwardRateLimitModeQueue PromWriteHandlerForwardRateLimitMode = "queue"
+)
+
+// PromWriteHandlerForwardCompressionOptions is the compression of the bodies
+// forwarded to a target.
+type PromWriteHandlerForwardCompressionOptions struct {
+	// Encoding is the content encoding of the forwarded bodies, defaults to
+	// snappy.
+	Encoding PromWriteHandlerForwardCompressionEncoding `yaml:"encoding"`
+	// Level is the compression level of the encoding, from 1 to 9 for gzip
+	// and from 1 to 22 for zstd, defaults to the default level of the
+	// encoding. Snappy has no levels.
+	Level int `yaml:"level"`
+}
+
+// PromWriteHandlerForwardCompressionEncoding is the content encoding of the
+// bodies forwarded to a target.
+type PromWriteHandlerForwardCompressionEncoding string
+
+const (
+	// PromWriteHandlerForwardCompressionEncodingSnappy is snappy block
+	// encoding, as specified by the Prometheus remote write protocol.
+	PromWriteHandlerForwardCompressionEncodingSnappy PromWriteHandlerForwardCompressionEncoding = "snappy"
+	// PromWriteHandlerForwardCompressionEncodingGzip is gzip encoding.
+	PromWriteHandlerForwardCompressionEncodingGzip PromWriteHandlerForwardCompressionEncoding = "gzip"
+	// PromWriteHandlerForwardCompressionEncodingZstd is zstd encoding.
+	PromWriteHandlerForwardCompressionEncodingZstd PromWriteHandlerForwardCompressionEncoding = "zstd"
+)
+
+// PromWriteHandlerForwardSigV4Options is the AWS Signature Version 4 signing
+// configuration of requests forwarded to a target.
+type PromWriteHandlerForwardSigV4Options struct {
+	// Region is the AWS region of the target.
+	Region string `yaml:"region"`
+	// Service is the AWS service name of the target, defaults to "aps", the
+	// service name of Amazon Managed Service for Prometheus.
+	Service string `yaml:"service"`
+	// AccessKeyID optionally sets static credentials along with the secret
+	// access key, otherwise credentials are taken from the environment or
+	// else the shared credentials file.
+	AccessKeyID string `yaml:"accessKeyID"`
+	// SecretAccessKey is the secret access key of the static credentials.
+	SecretAccessKey string `yaml:"secretAccessKey"`
+	// SessionToken is the optional session token of the static credentials.
+	SessionToken string `yaml:"sessionToken"`
+	// Profile is the profile used from the shared credentials file, defaults
+	// to the AWS_PROFILE environment variable or else "default".
+	Profile string `yaml:"profile"`
+}
+
+// PromWriteHandlerForwardOAuth2Options is the OAuth2 client credentials
+// configuration used to authenticate requests forwarded to a target, the
+// token is cached and only fetched again once it expires.
+type PromWriteHandlerForwardOAuth2Options struct {
+	// TokenURL is the URL of the token endpoint.
+	TokenURL string `yaml:"tokenURL"`
+	// ClientID is the client ID.
+	ClientID string `yaml:"clientID"`
+	// ClientSecret is the client secret.
+	ClientSecret string `yaml:"clientSecret"`
+	// Scopes optionally requests the given scopes.
+	Scopes []string `yaml:"scopes"`
+}
+
+// PromWriteHandlerForwardScheduleOptions is a recurring time window during
+// which requests are forwarded to a target.
+type PromWriteHandlerForwardScheduleOptions struct {
+	// Days is the days of the week the window starts on, e.g. "monday",
+	// defaults to every day.
+	Days []string `yaml:"days"`
+	// Start is the time of day the window starts at in 24 hour "15:04"
+	// format, defaults to the start of the day.
+	Start string `yaml:"start"`
+	// End is the time of day the window ends at in 24 hour "15:04" format,
+	// defaults to the end of the day. An end before the start spans
+	// midnight into the next day.
+	End string `yaml:"end"`
+	// Timezone is the IANA time zone the window is evaluated in, defaults
+	// to UTC.
+	Timezone string `yaml:"timezone"`
 }
 
 // PromWriteHandlerForwardTargetShadowOptions is a prometheus write
@@ -62,3 +276,959 @@ type PromWriteHandlerForwardTargetShadowOptions struct {
 	// Accepted values are: "xxhash" and "murmur3"
 	Hash string `yaml:"hash"`
 }
+
+// PromWriteHandlerOptions is the options for prometheus write handler.
+type PromWriteHandlerOptions struct {
+	// TimestampFloor optionally validates that sample timestamps are
+	// plausibly in milliseconds rather than seconds.
+	TimestampFloor *PromWriteHandlerTimestampFloorOptions `yaml:"timestampFloor"`
+	// MaxSamplesPerRequest optionally caps the total number of samples summed
+	// across all series in a single request.
+	MaxSamplesPerRequest *PromWriteHandlerMaxSamplesOptions `yaml:"maxSamplesPerRequest"`
+	// LabelCardinality optionally tracks the approximate number of distinct
+	// values for a set of watched label names.
+	LabelCardinality *PromWriteHandlerLabelCardinalityOptions `yaml:"labelCardinality"`
+	// PausedRetryAfter is the retry after duration returned to clients while
+	// writes are paused via the admin endpoint, defaults to 30s.
+	PausedRetryAfter time.Duration `yaml:"pausedRetryAfter"`
+	// StrictM3Headers rejects requests carrying M3 headers that are not
+	// recognized by the write handler, to catch client typos.
+	StrictM3Headers bool `yaml:"strictM3Headers"`
+	// StrictContentType rejects with a 415 requests whose content type is
+	// missing or is not protobuf, by default the content type is ignored and
+	// the body is decoded regardless.
+	StrictContentType bool `yaml:"strictContentType"`
+	// Exemplars enables decoding exemplars sent alongside samples, which are
+	// attached to the series annotation since there is no dedicated
+	// exemplar write path.
+	Exemplars bool `yaml:"exemplars"`
+	// DebugResponseDelay optionally allows injecting an artificial delay
+	// before responding, for load testing client backoff behavior.
+	DebugResponseDelay *PromWriteHandlerDebugResponseDelayOptions `yaml:"debugResponseDelay"`
+	// MissingName is the action taken for series without a metric name
+	// label, by default they are not checked.
+	MissingName PromWriteHandlerMissingNameMode `yaml:"missingName"`
+	// Idempotency optionally dedups retried writes carrying the same
+	// idempotency key header.
+	Idempotency *PromWriteHandlerIdempotencyOptions `yaml:"idempotency"`
+	// MetricsPush optionally reports the handler metrics to a pushgateway
+	// when the handler is closed rather than to the scraped metrics scope,
+	// for short-lived jobs that are not scraped.
+	MetricsPush *PromWriteHandlerMetricsPushOptions `yaml:"metricsPush"`
+	// ResourceExhaustedPartialSuccess optionally responds with a partial
+	// success rather than a 429 when only a small fraction of the series in
+	// a request failed to write due to resource exhaustion.
+	ResourceExhaustedPartialSuccess *PromWriteHandlerResourceExhaustedPartialSuccessOptions `yaml:"resourceExhaustedPartialSuccess"`
+	// WritePools optionally isolates the writes of series matching a label
+	// set to a named pool with its own concurrency limit, so that a high
+	// volume tenant cannot starve others. Series matching no pool are
+	// written by the default shared write path.
+	WritePools []PromWriteHandlerWritePoolOptions `yaml:"writePools"`
+	// DeadLetter optionally posts requests that failed to write to a dead
+	// letter collector for later reprocessing.
+	DeadLetter *PromWriteHandlerDeadLetterOptions `yaml:"deadLetter"`
+	// LabelNormalization optionally sorts the labels of each series and
+	// removes duplicate label names when parsing, so series IDs are stable
+	// regardless of client label ordering.
+	LabelNormalization *PromWriteHandlerLabelNormalizationOptions `yaml:"labelNormalization"`
+	// MinStoragePolicyResolution optionally rejects storage policy overrides
+	// with a resolution finer than supported by the aggregation tier.
+	MinStoragePolicyResolution time.Duration `yaml:"minStoragePolicyResolution"`
+	// InvalidStoragePolicy is the action taken for requests whose storage
+	// policy header cannot be parsed, defaults to reject.
+	InvalidStoragePolicy PromWriteHandlerInvalidStoragePolicyMode `yaml:"invalidStoragePolicy"`
+	// LabelNameValidation optionally validates label names against a
+	// pattern when parsing, rejecting or sanitizing invalid names that would
+	// otherwise fail obscurely downstream.
+	LabelNameValidation *PromWriteHandlerLabelNameValidationOptions `yaml:"labelNameValidation"`
+	// UTF8Validation is the action taken for label names and values that
+	// are not valid UTF-8, which otherwise break the JSON serialization of
+	// series downstream, by default they are not checked.
+	UTF8Validation PromWriteHandlerUTF8ValidationMode `yaml:"utf8Validation"`
+	// ClientCertificate optionally restricts writes to clients presenting a
+	// TLS client certificate with an allowed common name.
+	ClientCertificate *PromWriteHandlerClientCertificateOptions `yaml:"clientCertificate"`
+	// MaxRequestMemoryBytes optionally rejects requests for which the memory
+	// estimated to be allocated when writing exceeds the budget, zero
+	// disables the budget.
+	MaxRequestMemoryBytes int64 `yaml:"maxRequestMemoryBytes"`
+	// MaxInFlightBytes optionally bounds the sum of the decompressed body
+	// sizes of the requests being written concurrently, further requests
+	// are rejected with a 429 until enough in-flight requests are responded
+	// to. Zero disables the bound.
+	MaxInFlightBytes int64 `yaml:"maxInFlightBytes"`
+	// StatusCodes optionally overrides the HTTP status codes responded with
+	// for each class of write error.
+	StatusCodes *PromWriteHandlerStatusCodeOptions `yaml:"statusCodes"`
+	// SecondaryWrite optionally also writes each request synchronously to
+	// the secondary downsampler and writer set on the handler options, as a
+	// hot standby.
+	SecondaryWrite *PromWriteHandlerSecondaryWriteOptions `yaml:"secondaryWrite"`
+	// MaxLabelNameLength optionally limits the length of label names
+	// separately from label values, defaults to the max tag literal length
+	// and must not exceed it.
+	MaxLabelNameLength int `yaml:"maxLabelNameLength"`
+	// MaxLabelValueLength optionally limits the length of label values
+	// separately from label names, defaults to the max tag literal length
+	// and must not exceed it.
+	MaxLabelValueLength int `yaml:"maxLabelValueLength"`
+	// WriteRetry optionally retries writes that fail with only transient
+	// errors before responding, retries must be bounded. Writes failing with
+	// any bad request or resource exhausted errors are never retried.
+	WriteRetry *retry.Configuration `yaml:"writeRetry"`
+	// AccessLog optionally logs a structured access log line for each
+	// write request, regardless of whether it succeeds.
+	AccessLog *PromWriteHandlerAccessLogOptions `yaml:"accessLog"`
+	// Metadata is the action taken with metric metadata sent with a request,
+	// defaults to noop.
+	Metadata PromWriteHandlerMetadataMode `yaml:"metadata"`
+	// DuplicateLabelNames is the action taken for series carrying the same
+	// label name more than once, by default they are not checked.
+	DuplicateLabelNames PromWriteHandlerDuplicateLabelNamesMode `yaml:"duplicateLabelNames"`
+	// RemoteWriteVersion optionally rejects requests that do not declare an
+	// accepted remote write protocol version header.
+	RemoteWriteVersion *PromWriteHandlerRemoteWriteVersionOptions `yaml:"remoteWriteVersion"`
+	// DeprecatedHeaders optionally warns clients sending any of the headers
+	// with a Warning response header, without rejecting the request.
+	DeprecatedHeaders []PromWriteHandlerDeprecatedHeaderOptions `yaml:"deprecatedHeaders"`
+	// LabelSplits optionally splits the values of labels that encode
+	// several values into separate labels when parsing.
+	LabelSplits []PromWriteHandlerLabelSplitOptions `yaml:"labelSplits"`
+	// RelabeledEmptyName is the action taken for series whose metric name
+	// is emptied or removed by server side relabeling, such as label splits
+	// or tag mappers, by default they are not checked. Series sent without
+	// a metric name are handled by MissingName instead.
+	RelabeledEmptyName PromWriteHandlerRelabeledEmptyNameMode `yaml:"relabeledEmptyName"`
+	// CounterResetDetection enables marking the annotation of counter series
+	// whose value decreased within the samples of a single request, to help
+	// downstream rate calculations with sources that reset counters.
+	CounterResetDetection bool `yaml:"counterResetDetection"`
+	// StaleMarkers is the action taken with Prometheus stale marker samples,
+	// by default they are written as is.
+	StaleMarkers PromWriteHandlerStaleMarkersMode `yaml:"staleMarkers"`
+	// LabelsHash enables storing a stable hash of the label set of each
+	// series in its annotation, for downstream deduplication.
+	LabelsHash bool `yaml:"labelsHash"`
+	// MalformedSeries is the action taken for series that are malformed,
+	// such as from partial or corrupt payloads, by default they are not
+	// checked.
+	MalformedSeries PromWriteHandlerMalformedSeriesMode `yaml:"malformedSeries"`
+	// StrictSampleOrder also treats series whose sample timestamps are not
+	// strictly increasing as malformed when malformed series are checked,
+	// which remote write otherwise allows.
+	StrictSampleOrder bool `yaml:"strictSampleOrder"`
+	// TenantMetrics enables also emitting the write success and error
+	// metrics tagged with the tenant of each request.
+	TenantMetrics *PromWriteHandlerTenantMetricsOptions `yaml:"tenantMetrics"`
+	// MonotonicTimestamps optionally checks that the sample timestamps of
+	// append-only series advance across requests, to catch replays and
+	// backfills of such series.
+	MonotonicTimestamps *PromWriteHandlerMonotonicTimestampsOptions `yaml:"monotonicTimestamps"`
+	// PackedHistograms optionally expands series carrying packed histogram
+	// buckets into the bucket, sum and count series of Prometheus
+	// histograms, for sources that do not write separate bucket series.
+	PackedHistograms *PromWriteHandlerPackedHistogramsOptions `yaml:"packedHistograms"`
+	// Coalescing optionally buffers the series of requests and writes them
+	// as a single larger batch, amortizing the per batch overhead for
+	// senders writing many small requests. Only requests that do not
+	// override the write options are coalesced.
+	Coalescing *PromWriteHandlerCoalescingOptions `yaml:"coalescing"`
+	// JWT optionally requires each request to carry a signed JWT, deriving
+	// the write options and tenant of the request from its claims rather
+	// than from the spoofable request headers, which are ignored.
+	JWT *PromWriteHandlerJWTOptions `yaml:"jwt"`
+	// SamplingLabel optionally names a label, such as "__m3_sample__", with
+	// which clients set the fraction in [0,1] of the series carrying it that
+	// are written, the label is removed before writing. The series kept are
+	// chosen deterministically by hashing their labels. Empty disables
+	// sampling.
+	SamplingLabel string `yaml:"samplingLabel"`
+	// MaxDecompressionRatio optionally rejects request bodies whose
+	// decompressed size exceeds their compressed size by more than the
+	// ratio, before they are decompressed, to guard against highly
+	// compressible bodies exhausting memory. Zero disables the check.
+	MaxDecompressionRatio float64 `yaml:"maxDecompressionRatio"`
+	// AsyncWrite optionally allows requests to be responded to with a 202 as
+	// soon as they are queued for a bounded pool of workers, before they
+	// are written, for clients favoring latency over durability. Errors
+	// writing such requests are only reflected in the metrics and logs.
+	AsyncWrite *PromWriteHandlerAsyncWriteOptions `yaml:"asyncWrite"`
+	// TopMetricNames optionally tracks the metric names written with the
+	// most samples, with bounded memory, which are listed by an admin
+	// endpoint to find the noisiest metrics.
+	TopMetricNames *PromWriteHandlerTopMetricNamesOptions `yaml:"topMetricNames"`
+	// UnsupportedSeries is the action taken for series that cannot be
+	// written as is, such as series of unknown types or carrying packed
+	// histograms that are not expanded, by default they are not checked.
+	UnsupportedSeries PromWriteHandlerUnsupportedSeriesMode `yaml:"unsupportedSeries"`
+	// Heartbeat optionally periodically writes a heartbeat series through
+	// the write path, whose value is the time it was written, so that
+	// dashboards can measure the delay between ingesting and querying it.
+	Heartbeat *PromWriteHandlerHeartbeatOptions `yaml:"heartbeat"`
+	// ServerTiming enables responding with a Server-Timing header breaking
+	// down the time spent parsing, dispatching forwards and writing each
+	// request, for admin tools and client side tracing. Forwards are
+	// asynchronous so only the time to dispatch them is included.
+	ServerTiming bool `yaml:"serverTiming"`
+	// TenantSeriesLimit optionally caps the active series of each tenant so
+	// that a single tenant cannot monopolize the index.
+	TenantSeriesLimit *PromWriteHandlerTenantSeriesLimitOptions `yaml:"tenantSeriesLimit"`
+	// NewMetricNameWebhook optionally notifies a webhook in the background
+	// when a metric name not seen recently is written, for governance of
+	// the metric names in use.
+	NewMetricNameWebhook *PromWriteHandlerNewMetricNameWebhookOptions `yaml:"newMetricNameWebhook"`
+	// LabelTrimming optionally trims leading and trailing whitespace from
+	// label values when parsing, before series IDs are derived, so that
+	// stray whitespace emitted by some exporters does not fragment series.
+	LabelTrimming *PromWriteHandlerLabelTrimmingOptions `yaml:"labelTrimming"`
+	// PriorityPools optionally admits each request by the pool selected with
+	// the write priority header, each with its own concurrency limit, so
+	// that bulk writes such as backfills cannot starve latency critical
+	// writes. Requests beyond the limit of their pool are rejected with a
+	// 429.
+	PriorityPools *PromWriteHandlerPriorityPoolsOptions `yaml:"priorityPools"`
+	// AuditLog optionally keeps a summary of the most recent requests in
+	// memory, which are listed by an admin endpoint for forensic debugging
+	// without external logging.
+	AuditLog *PromWriteHandlerAuditLogOptions `yaml:"auditLog"`
+	// SeriesMerging optionally merges the samples of entries of a request
+	// with the same labels into a single series, for clients that split a
+	// series across multiple entries, deduplicating samples with the same
+	// timestamp.
+	SeriesMerging *PromWriteHandlerSeriesMergingOptions `yaml:"seriesMerging"`
+	// HistogramValidation optionally checks that the cumulative counts of
+	// the bucket series of each histogram do not decrease as the le label
+	// increases at each timestamp of a request, to catch broken exporters.
+	HistogramValidation *PromWriteHandlerHistogramValidationOptions `yaml:"histogramValidation"`
+	// TypeInference optionally infers the type of series written without
+	// one, by neither the series nor the type headers, from the suffix of
+	// their metric name, such as counter for names ending with _total.
+	TypeInference *PromWriteHandlerTypeInferenceOptions `yaml:"typeInference"`
+	// TruncatedBody is the action taken for requests whose compressed body
+	// ends before its declared length, such as when a client connection
+	// drops mid stream, defaults to reject.
+	TruncatedBody PromWriteHandlerTruncatedBodyMode `yaml:"truncatedBody"`
+	// SeriesDenylist optionally drops or rejects series matching a
+	// combination of labels, such as job=debug and env=prod.
+	SeriesDenylist *PromWriteHandlerSeriesDenylistOptions `yaml:"seriesDenylist"`
+	// LabelCounts optionally records the number of labels of each series
+	// written in a histogram, to track the schema health of clients.
+	LabelCounts *PromWriteHandlerLabelCountsOptions `yaml:"labelCounts"`
+}
+
+// PromWriteHandlerHeartbeatOptions is the options for writing a heartbeat
+// series.
+type PromWriteHandlerHeartbeatOptions struct {
+	// Interval is the interval at which the heartbeat is written, defaults
+	// to 10s.
+	Interval time.Duration `yaml:"interval"`
+	// MetricName is the metric name of the heartbeat series, defaults to
+	// "m3coordinator_write_heartbeat".
+	MetricName string `yaml:"metricName"`
+	// Labels are additional labels of the heartbeat series, such as one
+	// identifying the coordinator so that each writes its own series.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// PromWriteHandlerTopMetricNamesOptions is the options for tracking the
+// metric names written with the most samples.
+type PromWriteHandlerTopMetricNamesOptions struct {
+	// Capacity is the max metric names tracked, the counts of names outside
+	// of the heaviest are approximate, defaults to 1000.
+	Capacity int `yaml:"capacity"`
+}
+
+// PromWriteHandlerAuditLogOptions is the options for keeping a summary of
+// the most recent requests.
+type PromWriteHandlerAuditLogOptions struct {
+	// Size is the number of most recent requests kept, memory used is
+	// bounded by the size, defaults to 1000.
+	Size int `yaml:"size"`
+}
+
+// PromWriteHandlerAsyncWriteOptions is the options for writing requests
+// asynchronously.
+type PromWriteHandlerAsyncWriteOptions struct {
+	// Always writes every request asynchronously unless the request sets the
+	// async write header to false, otherwise only requests setting the
+	// header to true are written asynchronously.
+	Always bool `yaml:"always"`
+	// Workers is the number of requests written concurrently, defaults to
+	// 16.
+	Workers int `yaml:"workers"`
+	// QueueSize is the max requests queued to be written, further requests
+	// are rejected with a 429 until the queue drains, defaults to 1000.
+	QueueSize int `yaml:"queueSize"`
+	// DrainTimeout is the max time waited on shutdown for the queued
+	// requests to be written, requests still queued after it are lost,
+	// defaults to 30s.
+	DrainTimeout time.Duration `yaml:"drainTimeout"`
+}
+
+// PromWriteHandlerJWTOptions is the options for verifying the JWT of each
+// request and deriving the write options and tenant of the request from its
+// claims. The metrics type, storage policy and write type are read from the
+// "metrics_type", "storage_policy" and "write_type" claims, which take the
+// same values as the equivalent headers.
+type PromWriteHandlerJWTOptions struct {
+	// Header is the request header carrying the token as a bearer token,
+	// defaults to Authorization.
+	Header string `yaml:"header"`
+	// HMACSecret is the secret of tokens signed with HMAC, it cannot be set
+	// along with PublicKeyPEM.
+	HMACSecret string `yaml:"hmacSecret"`
+	// PublicKeyPEM is the PEM encoded public key of tokens signed with RSA
+	// or ECDSA.
+	PublicKeyPEM string `yaml:"publicKeyPEM"`
+	// Issuer is the issuer tokens must be issued by, if set.
+	Issuer string `yaml:"issuer"`
+	// Audience is the audience tokens must be issued for, if set.
+	Audience string `yaml:"audience"`
+	// TenantClaim is the claim with the tenant of the request, which is set
+	// as the tenant header of the access log and tenant metrics, defaults to
+	// "tenant".
+	TenantClaim string `yaml:"tenantClaim"`
+}
+
+// PromWriteHandlerCoalescingOptions is the options for coalescing the series
+// of requests into larger write batches.
+type PromWriteHandlerCoalescingOptions struct {
+	// Window is the max time the series of a request are buffered before
+	// they are written, defaults to 10ms.
+	Window time.Duration `yaml:"window"`
+	// MaxSeries is the number of buffered series that triggers a write
+	// before the window elapses, defaults to 1000.
+	MaxSeries int `yaml:"maxSeries"`
+	// MaxConcurrency is the max number of concurrent batch writes, batches
+	// beyond which wait for a write to complete, defaults to 4.
+	MaxConcurrency int `yaml:"maxConcurrency"`
+	// Respond is when coalesced requests are responded to, defaults to
+	// after the batch with their series is written.
+	Respond PromWriteHandlerCoalescingRespondMode `yaml:"respond"`
+}
+
+// PromWriteHandlerCoalescingRespondMode is when coalesced requests are
+// responded to.
+type PromWriteHandlerCoalescingRespondMode string
+
+const (
+	// PromWriteHandlerCoalescingRespondModeFlush responds once the batch is
+	// written. Since batch errors are not attributed to series, they are
+	// returned to a request only if it is alone in the batch, the requests
+	// of a failed batch otherwise get a generic retryable error.
+	PromWriteHandlerCoalescingRespondModeFlush PromWriteHandlerCoalescingRespondMode = "flush"
+	// PromWriteHandlerCoalescingRespondModeAccepted responds with a 202 as
+	// soon as the series are buffered, batch errors are only logged.
+	PromWriteHandlerCoalescingRespondModeAccepted PromWriteHandlerCoalescingRespondMode = "accepted"
+)
+
+// PromWriteHandlerPackedHistogramsOptions is the options for expanding
+// packed histograms.
+type PromWriteHandlerPackedHistogramsOptions struct {
+	// MatchLabels are the labels of the series whose packed histograms are
+	// expanded, by default every series carrying packed histograms is
+	// expanded. The packed histograms of other series are ignored.
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+// PromWriteHandlerTenantMetricsOptions is the options for emitting write
+// metrics tagged with the tenant of each request.
+type PromWriteHandlerTenantMetricsOptions struct {
+	// Header is the request header with the tenant of the request, requests
+	// without it are only counted in the global metrics.
+	Header string `yaml:"header"`
+	// MaxTenants is the max distinct tenants tagged, requests of further
+	// tenants are tagged as "other", defaults to 100.
+	MaxTenants int `yaml:"maxTenants"`
+}
+
+// PromWriteHandlerTenantSeriesLimitOptions is the options for capping the
+// active series of each tenant. Series are tracked approximately by a hash
+// of their labels in process, so the limit applies to each coordinator.
+type PromWriteHandlerTenantSeriesLimitOptions struct {
+	// Header is the request header with the tenant of the request, requests
+	// without it are rejected with a 400. The header is set from the tenant
+	// claim when JWT verification is enabled.
+	Header string `yaml:"header"`
+	// Limit is the max active series of each tenant. Active series are
+	// always written while new series beyond the limit are not, with
+	// requests writing any such series rejected with a 429 once their other
+	// series are written.
+	Limit int `yaml:"limit"`
+	// Limits optionally overrides the limit of specific tenants.
+	Limits map[string]int `yaml:"limits"`
+	// TTL is how long a series stays active since it was last written,
+	// defaults to 1h.
+	TTL time.Duration `yaml:"ttl"`
+	// MaxTenants is the max distinct tenants tracked, requests of further
+	// tenants are rejected with a 429 until tracked tenants expire, defaults
+	// to 1000.
+	MaxTenants int `yaml:"maxTenants"`
+}
+
+// PromWriteHandlerRelabeledEmptyNameMode is the action taken when server
+// side relabeling empties the metric name of a series.
+type PromWriteHandlerRelabeledEmptyNameMode string
+
+const (
+	// PromWriteHandlerRelabeledEmptyNameModeReject rejects the request.
+	PromWriteHandlerRelabeledEmptyNameModeReject PromWriteHandlerRelabeledEmptyNameMode = "reject"
+	// PromWriteHandlerRelabeledEmptyNameModeDrop drops the series and
+	// continues writing the request, the series are counted as dropped for
+	// having no metric name.
+	PromWriteHandlerRelabeledEmptyNameModeDrop PromWriteHandlerRelabeledEmptyNameMode = "drop"
+)
+
+// PromWriteHandlerLabelSplitOptions is a rule splitting the value of a label
+// into several labels.
+type PromWriteHandlerLabelSplitOptions struct {
+	// Label is the name of the label split, which is replaced by the target
+	// labels.
+	Label string `yaml:"label"`
+	// Delimiter separates the values in the label value.
+	Delimiter string `yaml:"delimiter"`
+	// TargetLabels is the names of the labels the split values are written
+	// to in order. A value without the delimiter is written to the first
+	// target label only, and the last target label takes the remainder of a
+	// value with more delimiters than target labels. Empty values are
+	// omitted.
+	TargetLabels []string `yaml:"targetLabels"`
+}
+
+// PromWriteHandlerDeprecatedHeaderOptions is a deprecated request header.
+type PromWriteHandlerDeprecatedHeaderOptions struct {
+	// Name is the name of the deprecated header.
+	Name string `yaml:"name"`
+	// Message is the warning returned when the header is used, defaults to
+	// stating the header is deprecated.
+	Message string `yaml:"message"`
+}
+
+// PromWriteHandlerRemoteWriteVersionOptions is the options for requiring the
+// remote write protocol version header.
+type PromWriteHandlerRemoteWriteVersionOptions struct {
+	// AcceptedVersions is the set of accepted versions, any version is
+	// accepted if empty as long as the header is present.
+	AcceptedVersions []string `yaml:"acceptedVersions"`
+}
+
+// PromWriteHandlerDuplicateLabelNamesMode is the action taken when a series
+// carries the same label name more than once.
+type PromWriteHandlerDuplicateLabelNamesMode string
+
+const (
+	// PromWriteHandlerDuplicateLabelNamesModeReject rejects the request.
+	PromWriteHandlerDuplicateLabelNamesModeReject PromWriteHandlerDuplicateLabelNamesMode = "reject"
+	// PromWriteHandlerDuplicateLabelNamesModeDedupe keeps the first label
+	// with each name and continues writing the request.
+	PromWriteHandlerDuplicateLabelNamesModeDedupe PromWriteHandlerDuplicateLabelNamesMode = "dedupe"
+)
+
+// PromWriteHandlerInvalidStoragePolicyMode is the action taken when the
+// storage policy header of a request cannot be parsed.
+type PromWriteHandlerInvalidStoragePolicyMode string
+
+const (
+	// PromWriteHandlerInvalidStoragePolicyModeReject rejects the request.
+	PromWriteHandlerInvalidStoragePolicyModeReject PromWriteHandlerInvalidStoragePolicyMode = "reject"
+	// PromWriteHandlerInvalidStoragePolicyModeFallback ignores the metrics
+	// type and storage policy headers and writes the request with the
+	// default rules, as if the headers were not set.
+	PromWriteHandlerInvalidStoragePolicyModeFallback PromWriteHandlerInvalidStoragePolicyMode = "fallback"
+)
+
+// PromWriteHandlerLabelCountsOptions is the options for recording the
+// distribution of the number of labels per series.
+type PromWriteHandlerLabelCountsOptions struct {
+	// Buckets are the upper bounds of the histogram buckets in increasing
+	// order, defaults to buckets of width 4 up to 64 labels.
+	Buckets []float64 `yaml:"buckets"`
+}
+
+// PromWriteHandlerSeriesDenylistOptions is the options for denying series
+// by their labels.
+type PromWriteHandlerSeriesDenylistOptions struct {
+	// Entries are the denied label sets, a series is denied when it matches
+	// every matcher of any entry.
+	Entries []PromWriteHandlerSeriesDenylistEntry `yaml:"entries"`
+	// Mode is the action taken for denied series, defaults to drop.
+	Mode PromWriteHandlerSeriesDenylistMode `yaml:"mode"`
+}
+
+// PromWriteHandlerSeriesDenylistEntry is a set of label matchers that must
+// all match for a series to be denied.
+type PromWriteHandlerSeriesDenylistEntry struct {
+	Matchers []PromWriteHandlerLabelMatcher `yaml:"matchers"`
+}
+
+// PromWriteHandlerLabelMatcher matches the value of a label, a series
+// without the label is matched as if its value was empty.
+type PromWriteHandlerLabelMatcher struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+	// Regex matches the value as an anchored regular expression rather than
+	// exactly.
+	Regex bool `yaml:"regex"`
+}
+
+// PromWriteHandlerSeriesDenylistMode is the action taken when a series
+// matches the series denylist.
+type PromWriteHandlerSeriesDenylistMode string
+
+const (
+	// PromWriteHandlerSeriesDenylistModeDrop drops the denied series and
+	// continues writing the rest of the request.
+	PromWriteHandlerSeriesDenylistModeDrop PromWriteHandlerSeriesDenylistMode = "drop"
+	// PromWriteHandlerSeriesDenylistModeReject rejects the request.
+	PromWriteHandlerSeriesDenylistModeReject PromWriteHandlerSeriesDenylistMode = "reject"
+)
+
+// PromWriteHandlerTruncatedBodyMode is the action taken when the compressed
+// body of a request is truncated rather than malformed.
+type PromWriteHandlerTruncatedBodyMode string
+
+const (
+	// PromWriteHandlerTruncatedBodyModeReject rejects the request as a bad
+	// request with the truncated body error code.
+	PromWriteHandlerTruncatedBodyModeReject PromWriteHandlerTruncatedBodyMode = "reject"
+	// PromWriteHandlerTruncatedBodyModeRetry rejects the request as service
+	// unavailable with the truncated body error code, so that clients which
+	// do not retry bad requests resend the body.
+	PromWriteHandlerTruncatedBodyModeRetry PromWriteHandlerTruncatedBodyMode = "retry"
+)
+
+// PromWriteHandlerMalformedSeriesMode is the action taken when a series is
+// malformed, i.e. it has no labels or duplicate label names, or samples
+// whose timestamps are not strictly increasing if the order is strict.
+type PromWriteHandlerMalformedSeriesMode string
+
+const (
+	// PromWriteHandlerMalformedSeriesModeReject rejects the request.
+	PromWriteHandlerMalformedSeriesModeReject PromWriteHandlerMalformedSeriesMode = "reject"
+	// PromWriteHandlerMalformedSeriesModeDrop drops the malformed series and
+	// continues writing the rest of the request.
+	PromWriteHandlerMalformedSeriesModeDrop PromWriteHandlerMalformedSeriesMode = "drop"
+)
+
+// PromWriteHandlerUnsupportedSeriesMode is the action taken when a series
+// cannot be written as is.
+type PromWriteHandlerUnsupportedSeriesMode string
+
+const (
+	// PromWriteHandlerUnsupportedSeriesModeReject rejects the request.
+	PromWriteHandlerUnsupportedSeriesModeReject PromWriteHandlerUnsupportedSeriesMode = "reject"
+	// PromWriteHandlerUnsupportedSeriesModePartial writes the supported
+	// series and responds with the number of unsupported series by reason.
+	PromWriteHandlerUnsupportedSeriesModePartial PromWriteHandlerUnsupportedSeriesMode = "partial"
+)
+
+// PromWriteHandlerStaleMarkersMode is the action taken with Prometheus stale
+// marker samples, which carry a special NaN value distinct from ordinary NaN
+// values.
+type PromWriteHandlerStaleMarkersMode string
+
+const (
+	// PromWriteHandlerStaleMarkersModePassthrough writes stale markers as is.
+	PromWriteHandlerStaleMarkersModePassthrough PromWriteHandlerStaleMarkersMode = "passthrough"
+	// PromWriteHandlerStaleMarkersModeDrop drops stale markers, along with
+	// series left without samples.
+	PromWriteHandlerStaleMarkersModeDrop PromWriteHandlerStaleMarkersMode = "drop"
+	// PromWriteHandlerStaleMarkersModeAnnotate writes stale markers as
+	// ordinary NaN values with a stale marker series annotation.
+	PromWriteHandlerStaleMarkersModeAnnotate PromWriteHandlerStaleMarkersMode = "annotate"
+)
+
+// PromWriteHandlerMetadataMode is the action taken with metric metadata
+// sent with a write request.
+type PromWriteHandlerMetadataMode string
+
+const (
+	// PromWriteHandlerMetadataModeNoop ignores the metadata.
+	PromWriteHandlerMetadataModeNoop PromWriteHandlerMetadataMode = "noop"
+	// PromWriteHandlerMetadataModeStore writes the metadata to the metadata
+	// store set on the handler options.
+	PromWriteHandlerMetadataModeStore PromWriteHandlerMetadataMode = "store"
+)
+
+// PromWriteHandlerAccessLogOptions is the options for the write access log.
+type PromWriteHandlerAccessLogOptions struct {
+	// SampleRate is the rate of requests logged, defaults to logging every
+	// request.
+	SampleRate *sampler.Rate `yaml:"sampleRate"`
+	// TenantHeader is the request header logged as the tenant of the request,
+	// if any.
+	TenantHeader string `yaml:"tenantHeader"`
+}
+
+// PromWriteHandlerSecondaryWriteOptions is the options for writing to a
+// secondary downsampler and writer.
+type PromWriteHandlerSecondaryWriteOptions struct {
+	// NonFatal ignores errors writing to the secondary when responding, by
+	// default they fail the write along with errors writing to the primary.
+	NonFatal bool `yaml:"nonFatal"`
+}
+
+// PromWriteHandlerStatusCodeOptions is the HTTP status codes responded with
+// for each class of write error, a zero status code keeps the default.
+type PromWriteHandlerStatusCodeOptions struct {
+	// BadRequest is the status code when every error is a bad request,
+	// defaults to 400.
+	BadRequest int `yaml:"badRequest"`
+	// ResourceExhausted is the status code when any error is due to resource
+	// exhaustion, defaults to 429.
+	ResourceExhausted int `yaml:"resourceExhausted"`
+	// IndexFull is the status code when any error is due to the index being
+	// at capacity and unable to insert new series, defaults to 507.
+	IndexFull int `yaml:"indexFull"`
+	// Retryable is the status code for any other errors, defaults to 500.
+	Retryable int `yaml:"retryable"`
+}
+
+// PromWriteHandlerClientCertificateOptions is the options for restricting
+// writes to clients presenting an allowed TLS client certificate.
+type PromWriteHandlerClientCertificateOptions struct {
+	// AllowedCommonNames is the set of common names of client certificates
+	// allowed to write, requests without a client certificate are rejected.
+	AllowedCommonNames []string `yaml:"allowedCommonNames"`
+}
+
+// PromWriteHandlerLabelNameValidationMode is the action taken when a label
+// name does not match the validation pattern.
+type PromWriteHandlerLabelNameValidationMode string
+
+const (
+	// PromWriteHandlerLabelNameValidationModeReject rejects the request.
+	PromWriteHandlerLabelNameValidationModeReject PromWriteHandlerLabelNameValidationMode = "reject"
+	// PromWriteHandlerLabelNameValidationModeSanitize replaces the characters
+	// of the label name outside of [a-zA-Z0-9_] with underscores, and
+	// prefixes an underscore to a label name starting with a digit. The
+	// request is rejected if the sanitized name still does not match the
+	// pattern or collides with another label name of the series.
+	PromWriteHandlerLabelNameValidationModeSanitize PromWriteHandlerLabelNameValidationMode = "sanitize"
+)
+
+// PromWriteHandlerLabelNameValidationOptions is the options for validating
+// label names.
+type PromWriteHandlerLabelNameValidationOptions struct {
+	// Pattern is the regular expression that the whole of each label name
+	// must match, defaults to [a-zA-Z_][a-zA-Z0-9_]*.
+	Pattern string `yaml:"pattern"`
+	// Mode is the action to take for invalid label names, defaults to reject.
+	Mode PromWriteHandlerLabelNameValidationMode `yaml:"mode"`
+}
+
+// PromWriteHandlerUTF8ValidationMode is the action taken when a label name
+// or value is not valid UTF-8.
+type PromWriteHandlerUTF8ValidationMode string
+
+const (
+	// PromWriteHandlerUTF8ValidationModeReject rejects the request.
+	PromWriteHandlerUTF8ValidationModeReject PromWriteHandlerUTF8ValidationMode = "reject"
+	// PromWriteHandlerUTF8ValidationModeSanitize replaces each run of
+	// invalid bytes with the Unicode replacement character. The request is
+	// rejected if a sanitized label name collides with another label name
+	// of the series.
+	PromWriteHandlerUTF8ValidationModeSanitize PromWriteHandlerUTF8ValidationMode = "sanitize"
+)
+
+// PromWriteHandlerDuplicateLabelMode is the label kept when a series carries
+// the same label name more than once.
+type PromWriteHandlerDuplicateLabelMode string
+
+const (
+	// PromWriteHandlerDuplicateLabelModeKeepFirst keeps the first occurrence
+	// of a label name in the order sent by the client.
+	PromWriteHandlerDuplicateLabelModeKeepFirst PromWriteHandlerDuplicateLabelMode = "keep-first"
+	// PromWriteHandlerDuplicateLabelModeKeepLast keeps the last occurrence of
+	// a label name in the order sent by the client.
+	PromWriteHandlerDuplicateLabelModeKeepLast PromWriteHandlerDuplicateLabelMode = "keep-last"
+)
+
+// PromWriteHandlerLabelNormalizationOptions is the options for normalizing
+// the labels of each series.
+type PromWriteHandlerLabelNormalizationOptions struct {
+	// Duplicates is the label kept for duplicate label names, defaults to
+	// keep-first.
+	Duplicates PromWriteHandlerDuplicateLabelMode `yaml:"duplicates"`
+	// BucketValues normalizes the numeric values of the le and quantile
+	// labels to a canonical form, e.g. 1.0 to 1, so histogram and summary
+	// series do not split when clients format bucket boundaries differently.
+	BucketValues bool `yaml:"bucketValues"`
+}
+
+// PromWriteHandlerDuplicateSampleMode is the sample kept when a series
+// carries more than one sample with the same timestamp.
+type PromWriteHandlerDuplicateSampleMode string
+
+const (
+	// PromWriteHandlerDuplicateSampleModeKeepFirst keeps the first sample
+	// with a timestamp in the order sent by the client.
+	PromWriteHandlerDuplicateSampleModeKeepFirst PromWriteHandlerDuplicateSampleMode = "keep-first"
+	// PromWriteHandlerDuplicateSampleModeKeepLast keeps the last sample with
+	// a timestamp in the order sent by the client.
+	PromWriteHandlerDuplicateSampleModeKeepLast PromWriteHandlerDuplicateSampleMode = "keep-last"
+)
+
+// PromWriteHandlerSeriesMergingOptions is the options for merging entries
+// of a request with the same labels.
+type PromWriteHandlerSeriesMergingOptions struct {
+	// Duplicates is the sample kept for samples of the merged series with
+	// the same timestamp, defaults to keep-first.
+	Duplicates PromWriteHandlerDuplicateSampleMode `yaml:"duplicates"`
+}
+
+// PromWriteHandlerHistogramValidationOptions is the options for validating
+// the bucket series of histograms.
+type PromWriteHandlerHistogramValidationOptions struct {
+	// Reject rejects requests with inconsistent histograms, by default they
+	// are only counted.
+	Reject bool `yaml:"reject"`
+}
+
+// PromWriteHandlerTypeInferenceOptions is the options for inferring the
+// type of series from their metric name.
+type PromWriteHandlerTypeInferenceOptions struct {
+	// Rules are the rules tried in order, the first whose suffix ends the
+	// metric name sets the type of the series. Defaults to counter for the
+	// _total and _count suffixes and histogram for the _bucket suffix.
+	Rules []PromWriteHandlerTypeInferenceRule `yaml:"rules"`
+	// Default is the type of series whose metric name matches no rule, such
+	// as gauge, by default they are left without a type.
+	Default string `yaml:"default"`
+}
+
+// PromWriteHandlerTypeInferenceRule is a rule inferring the type of series
+// by the suffix of their metric name.
+type PromWriteHandlerTypeInferenceRule struct {
+	// Suffix of the metric name.
+	Suffix string `yaml:"suffix"`
+	// Type of the series, any of the values of the Prometheus type header
+	// such as counter, gauge or histogram.
+	Type string `yaml:"type"`
+}
+
+// PromWriteHandlerLabelTrimmingOptions is the options for trimming
+// whitespace from labels.
+type PromWriteHandlerLabelTrimmingOptions struct {
+	// Names also trims label names, requests in which a trimmed name
+	// collides with another label name of the series are rejected.
+	Names bool `yaml:"names"`
+}
+
+// PromWriteHandlerDeadLetterOptions is the options for posting failed
+// writes to a dead letter collector.
+type PromWriteHandlerDeadLetterOptions struct {
+	// URL of the dead letter collector to post to.
+	URL string `yaml:"url"`
+	// Timeout is the timeout for posting to the collector, defaults to 15s.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxConcurrency is the max number of concurrent posts to the collector,
+	// failed writes beyond which are not posted, defaults to 16.
+	MaxConcurrency int `yaml:"maxConcurrency"`
+}
+
+// PromWriteHandlerNewMetricNameWebhookOptions is the options for notifying a
+// webhook of new metric names.
+type PromWriteHandlerNewMetricNameWebhookOptions struct {
+	// URL of the webhook to post to.
+	URL string `yaml:"url"`
+	// Timeout is the timeout for posting to the webhook, defaults to 15s.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxConcurrency is the max number of concurrent posts to the webhook,
+	// new names beyond which are not notified until seen again, defaults
+	// to 4.
+	MaxConcurrency int `yaml:"maxConcurrency"`
+	// MaxPerSecond is the max number of names notified per second, new
+	// names beyond which are not notified until seen again, defaults to 10.
+	MaxPerSecond int `yaml:"maxPerSecond"`
+	// Window is how long a notified name is not notified again, defaults
+	// to 24h.
+	Window time.Duration `yaml:"window"`
+	// MaxNames is the max number of notified names remembered, the least
+	// recently seen of which are notified again once forgotten, defaults
+	// to 100000.
+	MaxNames int `yaml:"maxNames"`
+}
+
+// PromWriteHandlerPriorityPoolsOptions is the options for admitting
+// requests by priority pools.
+type PromWriteHandlerPriorityPoolsOptions struct {
+	// Pools are the pools requests may select by name, such as high and low.
+	Pools []PromWriteHandlerPriorityPoolOptions `yaml:"pools"`
+	// DefaultMaxConcurrency is the max number of concurrent requests that
+	// select no pool, zero is unlimited.
+	DefaultMaxConcurrency int `yaml:"defaultMaxConcurrency"`
+}
+
+// PromWriteHandlerPriorityPoolOptions is the options for a priority pool.
+type PromWriteHandlerPriorityPoolOptions struct {
+	// Name of the pool, selected by the write priority header and used to
+	// tag the pool metrics.
+	Name string `yaml:"name"`
+	// MaxConcurrency is the max number of concurrent requests admitted by
+	// the pool.
+	MaxConcurrency int `yaml:"maxConcurrency"`
+}
+
+// PromWriteHandlerWritePoolOptions is the options for a write pool.
+type PromWriteHandlerWritePoolOptions struct {
+	// Name of the pool, used to tag the pool metrics.
+	Name string `yaml:"name"`
+	// MatchLabels is the label set a series must carry all of to be written
+	// by the pool, the first matching pool is used.
+	MatchLabels map[string]string `yaml:"matchLabels"`
+	// MaxConcurrency is the max number of concurrent writes by the pool.
+	MaxConcurrency int `yaml:"maxConcurrency"`
+}
+
+// PromWriteHandlerResourceExhaustedPartialSuccessOptions is the options for
+// tolerating resource exhausted errors in a mostly successful request.
+type PromWriteHandlerResourceExhaustedPartialSuccessOptions struct {
+	// MaxFraction is the fraction of series in a request, between [0,1],
+	// below which resource exhausted errors are reported as a partial success.
+	MaxFraction float64 `yaml:"maxFraction"`
+}
+
+// PromWriteHandlerMetricsPushOptions is the options for pushing the handler
+// metrics to a pushgateway.
+type PromWriteHandlerMetricsPushOptions struct {
+	// URL of the pushgateway to push to.
+	URL string `yaml:"url"`
+	// Job is the job name the metrics are pushed under, defaults to
+	// m3coordinator.
+	Job string `yaml:"job"`
+	// Timeout is the timeout for the push request, defaults to 10s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// PromWriteHandlerIdempotencyOptions is the options for deduping writes by
+// their idempotency key.
+type PromWriteHandlerIdempotencyOptions struct {
+	// TTL is how long a successfully written key is remembered, defaults
+	// to 10m.
+	TTL time.Duration `yaml:"ttl"`
+	// MaxKeys is the max number of keys remembered, defaults to 10000.
+	MaxKeys int `yaml:"maxKeys"`
+}
+
+// PromWriteHandlerMonotonicTimestampsOptions is the options for checking
+// that the sample timestamps of series advance across requests.
+type PromWriteHandlerMonotonicTimestampsOptions struct {
+	// Mode is the action taken for samples whose timestamp does not advance
+	// past the last written for the series, defaults to reject.
+	Mode PromWriteHandlerMonotonicTimestampsMode `yaml:"mode"`
+	// MatchLabels are the labels of the series checked, by default every
+	// series is checked.
+	MatchLabels map[string]string `yaml:"matchLabels"`
+	// MaxSeries is the max number of series whose last timestamp is
+	// remembered, the least recently written are forgotten first, defaults
+	// to 100000.
+	MaxSeries int `yaml:"maxSeries"`
+	// TTL is how long the last timestamp of a series is remembered,
+	// defaults to 1h.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// PromWriteHandlerMonotonicTimestampsMode is the action taken for samples
+// whose timestamp does not advance past the last written for the series.
+type PromWriteHandlerMonotonicTimestampsMode string
+
+const (
+	// PromWriteHandlerMonotonicTimestampsModeReject rejects the request.
+	PromWriteHandlerMonotonicTimestampsModeReject PromWriteHandlerMonotonicTimestampsMode = "reject"
+	// PromWriteHandlerMonotonicTimestampsModeDrop drops the samples and
+	// continues writing the rest of the request.
+	PromWriteHandlerMonotonicTimestampsModeDrop PromWriteHandlerMonotonicTimestampsMode = "drop"
+)
+
+// PromWriteHandlerMissingNameMode is the action taken when a series lacks
+// a metric name label.
+type PromWriteHandlerMissingNameMode string
+
+const (
+	// PromWriteHandlerMissingNameModeReject rejects the request.
+	PromWriteHandlerMissingNameModeReject PromWriteHandlerMissingNameMode = "reject"
+	// PromWriteHandlerMissingNameModeDrop drops the series without a metric
+	// name and continues writing the rest of the request.
+	PromWriteHandlerMissingNameModeDrop PromWriteHandlerMissingNameMode = "drop"
+)
+
+// PromWriteHandlerDebugResponseDelayOptions is the options for injecting an
+// artificial delay before responding. This is for debugging only and must
+// not be enabled in production.
+type PromWriteHandlerDebugResponseDelayOptions struct {
+	// Enabled must be explicitly set for any delay to be injected, otherwise
+	// both the configured delay and the delay header are ignored.
+	Enabled bool `yaml:"enabled"`
+	// Delay is injected before responding to every request, unless the
+	// request specifies its own delay with the delay header.
+	Delay time.Duration `yaml:"delay"`
+	// MaxDelay caps any injected delay, defaults to 10s.
+	MaxDelay time.Duration `yaml:"maxDelay"`
+}
+
+// PromWriteHandlerTimestampFloorMode is the action taken when a sample
+// timestamp falls below the configured floor.
+type PromWriteHandlerTimestampFloorMode string
+
+const (
+	// PromWriteHandlerTimestampFloorModeReject rejects the request.
+	PromWriteHandlerTimestampFloorModeReject PromWriteHandlerTimestampFloorMode = "reject"
+	// PromWriteHandlerTimestampFloorModeWarn logs a sampled warning and
+	// continues writing the request.
+	PromWriteHandlerTimestampFloorModeWarn PromWriteHandlerTimestampFloorMode = "warn"
+)
+
+// PromWriteHandlerTimestampFloorOptions is the options for validating
+// sample timestamps are above a plausible floor.
+type PromWriteHandlerTimestampFloorOptions struct {
+	// MinTimestampMillis is the smallest plausible sample timestamp in Unix
+	// milliseconds, defaults to 2001-09-09 (1e12 ms) which is far larger
+	// than any present day timestamp expressed in seconds.
+	MinTimestampMillis int64 `yaml:"minTimestampMillis"`
+	// Mode is the action to take when a sample falls below the floor,
+	// defaults to reject.
+	Mode PromWriteHandlerTimestampFloorMode `yaml:"mode"`
+}
+
+// PromWriteHandlerMaxSamplesMode is the action taken when a request exceeds
+// the max samples per request.
+type PromWriteHandlerMaxSamplesMode string
+
+const (
+	// PromWriteHandlerMaxSamplesModeReject rejects the request.
+	PromWriteHandlerMaxSamplesModeReject PromWriteHandlerMaxSamplesMode = "reject"
+	// PromWriteHandlerMaxSamplesModeTruncate drops the tail series of the
+	// request that would cause the limit to be exceeded, rejecting the
+	// request if no samples would be left.
+	PromWriteHandlerMaxSamplesModeTruncate PromWriteHandlerMaxSamplesMode = "truncate"
+)
+
+// PromWriteHandlerMaxSamplesOptions is the options for capping the number of
+// samples per request.
+type PromWriteHandlerMaxSamplesOptions struct {
+	// Limit is the max number of samples per request, zero disables the limit.
+	Limit int `yaml:"limit"`
+	// Mode is the action to take when the limit is exceeded, defaults to reject.
+	Mode PromWriteHandlerMaxSamplesMode `yaml:"mode"`
+}
+
+// PromWriteHandlerLabelCardinalityOptions is the options for tracking the
+// approximate cardinality of label values for watched label names.
+type PromWriteHandlerLabelCardinalityOptions struct {
+	// Labels is the set of label names to track, memory used is bounded by
+	// the number of label names watched.
+	Labels []string `yaml:"labels"`
+	// Threshold is the approximate number of distinct values for a label name
+	// above which the label is considered to be exceeding its cardinality.
+	// With a label cardinality backend set in the handler options it is the
+	// exact max number of distinct values shared across coordinators, and
+	// writes are not limited while the backend is unreachable.
+	Threshold uint64 `yaml:"threshold"`
+	// Reject rejects requests carrying new values for a label name which is
+	// exceeding its cardinality threshold.
+	Reject bool `yaml:"reject"`
+	// Window optionally resets the tracked values every window, if zero the
+	// values are tracked forever.
+	Window time.Duration `yaml:"window"`
+}
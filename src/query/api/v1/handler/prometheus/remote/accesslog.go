@@ -0,0 +1,138 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/metrics/policy"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/query/util/logging"
+	"github.com/m3db/m3/src/x/sampler"
+
+	"go.uber.org/zap"
+)
+
+const defaultAccessLogSampleRate = sampler.Rate(1)
+
+// promWriteAccessLogger logs a structured line for each sampled write.
+type promWriteAccessLogger struct {
+	logger       *zap.Logger
+	sampler      *sampler.Sampler
+	tenantHeader string
+}
+
+func newPromWriteAccessLogger(
+	opts handleroptions.PromWriteHandlerAccessLogOptions,
+	logger *zap.Logger,
+) (*promWriteAccessLogger, error) {
+	sampleRate := defaultAccessLogSampleRate
+	if opts.SampleRate != nil {
+		sampleRate = *opts.SampleRate
+	}
+
+	s, err := sampler.NewSampler(sampleRate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &promWriteAccessLogger{
+		logger:       logger.Named("access-log"),
+		sampler:      s,
+		tenantHeader: opts.TenantHeader,
+	}, nil
+}
+
+// promWriteAccessLogEntry is the details of a write resolved while serving it.
+type promWriteAccessLogEntry struct {
+	numSeries       int
+	numSamples      int
+	storagePolicies policy.StoragePolicies
+}
+
+type promWriteAccessLogEntryKey struct{}
+
+// withAccessLogEntry returns the request with an access log entry attached,
+// which is filled in as the write is served.
+func withAccessLogEntry(r *http.Request) (*http.Request, *promWriteAccessLogEntry) {
+	entry := &promWriteAccessLogEntry{}
+	ctx := context.WithValue(r.Context(), promWriteAccessLogEntryKey{}, entry)
+	return r.WithContext(ctx), entry
+}
+
+// setAccessLogRequest records the parsed request on the access log entry
+// attached to the context, if any.
+func setAccessLogRequest(
+	ctx context.Context,
+	req *prompb.WriteRequest,
+	opts ingest.WriteOptions,
+) {
+	entry, ok := ctx.Value(promWriteAccessLogEntryKey{}).(*promWriteAccessLogEntry)
+	if !ok {
+		return
+	}
+
+	entry.numSeries = len(req.Timeseries)
+	entry.numSamples = 0
+	for _, series := range req.Timeseries {
+		entry.numSamples += len(series.Samples)
+	}
+	entry.storagePolicies = opts.WriteStoragePolicies
+}
+
+func (l *promWriteAccessLogger) sample() bool {
+	return l.sampler.Sample()
+}
+
+func (l *promWriteAccessLogger) log(
+	r *http.Request,
+	entry *promWriteAccessLogEntry,
+	status int,
+	latency time.Duration,
+) {
+	if status == 0 {
+		// Nothing was explicitly written, which is an implicit success.
+		status = http.StatusOK
+	}
+
+	storagePolicies := make([]string, 0, len(entry.storagePolicies))
+	for _, sp := range entry.storagePolicies {
+		storagePolicies = append(storagePolicies, sp.String())
+	}
+
+	fields := []zap.Field{
+		zap.String("requestID", logging.ReadContextID(r.Context())),
+		zap.String("remoteAddr", r.RemoteAddr),
+		zap.Int("numSeries", entry.numSeries),
+		zap.Int("numSamples", entry.numSamples),
+		zap.Strings("storagePolicies", storagePolicies),
+		zap.Int("status", status),
+		zap.Duration("latency", latency),
+	}
+	if l.tenantHeader != "" {
+		fields = append(fields, zap.String("tenant", r.Header.Get(l.tenantHeader)))
+	}
+	l.logger.Info("write access", fields...)
+}
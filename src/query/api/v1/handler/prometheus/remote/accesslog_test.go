@@ -0,0 +1,201 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/storage/m3/storagemetadata"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/headers"
+	"github.com/m3db/m3/src/x/instrument"
+	"github.com/m3db/m3/src/x/sampler"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const testTenantHeader = "X-Tenant"
+
+func newAccessLogTestHandler(
+	t *testing.T,
+	ds ingest.DownsamplerAndWriter,
+	accessLog *handleroptions.PromWriteHandlerAccessLogOptions,
+) (http.Handler, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	iopts := instrument.NewOptions().SetLogger(zap.New(core))
+	opts := makeOptions(ds).SetInstrumentOpts(iopts)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.AccessLog = accessLog
+	})
+	return handler, logs
+}
+
+func TestPromWriteAccessLog(t *testing.T) {
+	promReq := test.GeneratePromWriteRequest()
+	numSamples := 0
+	for _, series := range promReq.Timeseries {
+		numSamples += len(series.Samples)
+	}
+
+	tests := []struct {
+		name                    string
+		writeErr                error
+		storagePolicy           string
+		expectedStatus          int
+		expectedStoragePolicies []interface{}
+		expectedErrorLogs       int
+	}{
+		{
+			name:                    "success",
+			storagePolicy:           "1m:21d",
+			expectedStatus:          http.StatusOK,
+			expectedStoragePolicies: []interface{}{"1m:21d"},
+		},
+		{
+			name:                    "failure",
+			writeErr:                errors.New("an error"),
+			expectedStatus:          http.StatusInternalServerError,
+			expectedStoragePolicies: []interface{}{},
+			expectedErrorLogs:       1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			var batchErr ingest.BatchError
+			if tt.writeErr != nil {
+				batchErr = xerrors.NewMultiError().Add(tt.writeErr)
+			}
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.
+				EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(batchErr)
+
+			handler, logs := newAccessLogTestHandler(t, mockDownsamplerAndWriter,
+				&handleroptions.PromWriteHandlerAccessLogOptions{
+					TenantHeader: testTenantHeader,
+				})
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			req.Header.Set(headers.RequestIDHeader, "test-request-id")
+			req.Header.Set(testTenantHeader, "test-tenant")
+			if tt.storagePolicy != "" {
+				req.Header.Set(headers.MetricsTypeHeader,
+					storagemetadata.AggregatedMetricsType.String())
+				req.Header.Set(headers.MetricsStoragePolicyHeader, tt.storagePolicy)
+			}
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, tt.expectedStatus, writer.Result().StatusCode)
+
+			accessLogs := logs.FilterMessage("write access").All()
+			require.Len(t, accessLogs, 1)
+			fields := accessLogs[0].ContextMap()
+			require.Equal(t, "test-request-id", fields["requestID"])
+			require.Equal(t, req.RemoteAddr, fields["remoteAddr"])
+			require.Equal(t, "test-tenant", fields["tenant"])
+			require.Equal(t, int64(len(promReq.Timeseries)), fields["numSeries"])
+			require.Equal(t, int64(numSamples), fields["numSamples"])
+			require.Equal(t, tt.expectedStoragePolicies, fields["storagePolicies"])
+			require.Equal(t, int64(tt.expectedStatus), fields["status"])
+			require.Contains(t, fields, "latency")
+
+			// The access log does not duplicate the error log.
+			require.Len(t, logs.FilterMessage("write error").All(), tt.expectedErrorLogs)
+			require.Equal(t, len(accessLogs)+tt.expectedErrorLogs, logs.Len())
+		})
+	}
+}
+
+func TestPromWriteAccessLogParseError(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, logs := newAccessLogTestHandler(t, ingest.NewMockDownsamplerAndWriter(ctrl),
+		&handleroptions.PromWriteHandlerAccessLogOptions{})
+
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, nil)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+
+	accessLogs := logs.FilterMessage("write access").All()
+	require.Len(t, accessLogs, 1)
+	fields := accessLogs[0].ContextMap()
+	require.Equal(t, int64(0), fields["numSeries"])
+	require.Equal(t, int64(http.StatusBadRequest), fields["status"])
+	require.NotContains(t, fields, "tenant")
+}
+
+func TestPromWriteAccessLogSampled(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(4)
+
+	sampleRate := sampler.Rate(0.5)
+	handler, logs := newAccessLogTestHandler(t, mockDownsamplerAndWriter,
+		&handleroptions.PromWriteHandlerAccessLogOptions{SampleRate: &sampleRate})
+
+	for i := 0; i < 4; i++ {
+		promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	}
+	require.Len(t, logs.FilterMessage("write access").All(), 2)
+}
+
+func TestPromWriteAccessLogInvalidSampleRate(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	sampleRate := sampler.Rate(2)
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.AccessLog = &handleroptions.PromWriteHandlerAccessLogOptions{
+		SampleRate: &sampleRate,
+	}
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.Error(t, err)
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/query/util/logging"
+	"github.com/m3db/m3/src/x/headers"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAsyncWriteWorkers   = 16
+	defaultAsyncWriteQueueSize = 1000
+	// defaultAsyncWriteDrainTimeout bounds the time shutdown waits for the
+	// queued writes.
+	defaultAsyncWriteDrainTimeout = 30 * time.Second
+)
+
+var errAsyncWriteQueueFull = xhttp.NewError(
+	errors.New("async write queue full"), http.StatusTooManyRequests)
+
+// asyncWriter writes requests in the background by a bounded pool of
+// workers, so that requests can be responded to before they are written.
+type asyncWriter struct {
+	sync.RWMutex
+
+	always       bool
+	queue        chan func()
+	drainTimeout time.Duration
+	closed       bool
+	wg           sync.WaitGroup
+
+	enqueued  tally.Counter
+	queueFull tally.Counter
+	success   tally.Counter
+	errors    tally.Counter
+}
+
+func newAsyncWriter(
+	opts handleroptions.PromWriteHandlerAsyncWriteOptions,
+	scope tally.Scope,
+) (*asyncWriter, error) {
+	if opts.Workers < 0 {
+		return nil, fmt.Errorf("async write workers must not be negative: %d",
+			opts.Workers)
+	}
+	if opts.QueueSize < 0 {
+		return nil, fmt.Errorf("async write queue size must not be negative: %d",
+			opts.QueueSize)
+	}
+
+	workers := defaultAsyncWriteWorkers
+	if opts.Workers > 0 {
+		workers = opts.Workers
+	}
+
+	if opts.DrainTimeout < 0 {
+		return nil, fmt.Errorf("async write drain timeout must not be negative: %s",
+			opts.DrainTimeout)
+	}
+
+	queueSize := defaultAsyncWriteQueueSize
+	if opts.QueueSize > 0 {
+		queueSize = opts.QueueSize
+	}
+
+	drainTimeout := defaultAsyncWriteDrainTimeout
+	if opts.DrainTimeout > 0 {
+		drainTimeout = opts.DrainTimeout
+	}
+
+	scope = scope.SubScope("write").SubScope("async")
+	w := &asyncWriter{
+		always:       opts.Always,
+		queue:        make(chan func(), queueSize),
+		drainTimeout: drainTimeout,
+		enqueued:     scope.Counter("enqueued"),
+		queueFull:    scope.Counter("queue-full"),
+		success:      scope.Counter("success"),
+		errors:       scope.Counter("errors"),
+	}
+	w.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go w.work()
+	}
+	return w, nil
+}
+
+func (w *asyncWriter) work() {
+	defer w.wg.Done()
+	for write := range w.queue {
+		write()
+	}
+}
+
+// async returns true if the request is written asynchronously, requests can
+// opt in or out with the async write header.
+func (w *asyncWriter) async(r *http.Request) (bool, error) {
+	if v := r.Header.Get(headers.AsyncWriteHeader); v != "" {
+		return parseBoolHeader(r, headers.AsyncWriteHeader)
+	}
+	return w.always, nil
+}
+
+// enqueue queues the write for a worker, returning a 429 error if the queue
+// is full.
+func (w *asyncWriter) enqueue(write func()) error {
+	w.RLock()
+	defer w.RUnlock()
+
+	if w.closed {
+		return errAsyncWriteQueueFull
+	}
+
+	select {
+	case w.queue <- write:
+		w.enqueued.Inc(1)
+		return nil
+	default:
+		w.queueFull.Inc(1)
+		return errAsyncWriteQueueFull
+	}
+}
+
+// Close waits up to the drain timeout for the queued writes to be written,
+// returning an error with the number of writes not yet written if it times
+// out. Further writes are rejected.
+func (w *asyncWriter) Close() error {
+	w.Lock()
+	if w.closed {
+		w.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(drained)
+	}()
+
+	timer := time.NewTimer(w.drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("async write drain timed out after %s: queued=%d",
+			w.drainTimeout, len(w.queue))
+	}
+}
+
+// writeAsync queues the request to be written by the async writer, errors
+// writing it are only reflected in the metrics and logs.
+func (h *PromWriteHandler) writeAsync(
+	r *http.Request,
+	req *prompb.WriteRequest,
+	opts ingest.WriteOptions,
+) error {
+	var (
+		logger     = logging.WithContext(r.Context(), h.instrumentOpts)
+		remoteAddr = r.RemoteAddr
+	)
+	return h.asyncWriter.enqueue(func() {
+		// NB: The request is responded to before it is written, so it is not
+		// written with the request context.
+		batchErr := h.writeWithRetry(context.Background(), req, opts)
+		if batchErr != nil {
+			h.asyncWriter.errors.Inc(1)
+			logger.Error("async write error",
+				zap.String("remoteAddr", remoteAddr),
+				zap.Int("numSeries", len(req.Timeseries)),
+				zap.Error(batchErr))
+			return
+		}
+		h.asyncWriter.success.Inc(1)
+	})
+}
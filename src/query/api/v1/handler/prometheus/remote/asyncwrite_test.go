@@ -0,0 +1,230 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/headers"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+// newAsyncWriteTestHandler returns a handler whose writes signal on started
+// once they begin and block until release is closed.
+func newAsyncWriteTestHandler(
+	t *testing.T,
+	ctrl *gomock.Controller,
+	asyncWrite handleroptions.PromWriteHandlerAsyncWriteOptions,
+	scope tally.Scope,
+	batchErr ingest.BatchError,
+) (*PromWriteHandler, chan struct{}, chan struct{}) {
+	var (
+		started = make(chan struct{}, 16)
+		release = make(chan struct{})
+	)
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, ingest.DownsampleAndWriteIter, ingest.WriteOptions) ingest.BatchError {
+			started <- struct{}{}
+			<-release
+			return batchErr
+		}).
+		AnyTimes()
+
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.AsyncWrite = &asyncWrite
+	})
+	return handler, started, release
+}
+
+func serveAsyncWriteRequest(t *testing.T, handler http.Handler, async string) int {
+	body := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+	if async != "" {
+		req.Header.Set(headers.AsyncWriteHeader, async)
+	}
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	return writer.Result().StatusCode
+}
+
+func waitForAsyncWrite(t *testing.T, started chan struct{}) {
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for write")
+	}
+}
+
+func TestPromWriteAsyncWriteRespondsBeforeWrite(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	scope := tally.NewTestScope("", nil)
+	handler, started, release := newAsyncWriteTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerAsyncWriteOptions{}, scope,
+		xerrors.NewMultiError().Add(errors.New("write error")))
+
+	// The write blocks until released, so the response must not wait for it.
+	require.Equal(t, http.StatusAccepted, serveAsyncWriteRequest(t, handler, "true"))
+	waitForAsyncWrite(t, started)
+	close(release)
+	require.NoError(t, handler.Close())
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(1), counters["write.async.enqueued+handler=remote-write"].Value())
+	require.Equal(t, int64(1), counters["write.async.errors+handler=remote-write"].Value())
+}
+
+func TestPromWriteAsyncWriteQueueFull(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	scope := tally.NewTestScope("", nil)
+	handler, started, release := newAsyncWriteTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerAsyncWriteOptions{
+			Always:    true,
+			Workers:   1,
+			QueueSize: 1,
+		}, scope, nil)
+
+	// The first request occupies the only worker and the second the only
+	// queue slot.
+	require.Equal(t, http.StatusAccepted, serveAsyncWriteRequest(t, handler, ""))
+	waitForAsyncWrite(t, started)
+	require.Equal(t, http.StatusAccepted, serveAsyncWriteRequest(t, handler, ""))
+	require.Equal(t, http.StatusTooManyRequests, serveAsyncWriteRequest(t, handler, ""))
+
+	close(release)
+	require.NoError(t, handler.Close())
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(2), counters["write.async.success+handler=remote-write"].Value())
+	require.Equal(t, int64(1), counters["write.async.queue-full+handler=remote-write"].Value())
+}
+
+func TestPromWriteAsyncWriteCloseFlushesQueued(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	scope := tally.NewTestScope("", nil)
+	handler, started, release := newAsyncWriteTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerAsyncWriteOptions{
+			Always:    true,
+			Workers:   1,
+			QueueSize: 4,
+		}, scope, nil)
+
+	// One request occupies the only worker and the rest stay queued.
+	for i := 0; i < 4; i++ {
+		require.Equal(t, http.StatusAccepted, serveAsyncWriteRequest(t, handler, ""))
+	}
+	waitForAsyncWrite(t, started)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	require.NoError(t, handler.Close())
+
+	// Close only returns once every queued request is written.
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(4), counters["write.async.success+handler=remote-write"].Value())
+
+	// Requests are rejected once closed.
+	require.Equal(t, http.StatusTooManyRequests, serveAsyncWriteRequest(t, handler, ""))
+}
+
+func TestPromWriteAsyncWriteCloseDrainTimeout(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, started, release := newAsyncWriteTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerAsyncWriteOptions{
+			Always:       true,
+			Workers:      1,
+			DrainTimeout: 50 * time.Millisecond,
+		}, tally.NoopScope, nil)
+	defer close(release)
+
+	require.Equal(t, http.StatusAccepted, serveAsyncWriteRequest(t, handler, ""))
+	waitForAsyncWrite(t, started)
+	require.Equal(t, http.StatusAccepted, serveAsyncWriteRequest(t, handler, ""))
+
+	require.EqualError(t, handler.Close(),
+		"async write drain timed out after 50ms: queued=1")
+}
+
+func TestPromWriteAsyncWriteNegativeDrainTimeout(t *testing.T) {
+	_, err := newAsyncWriter(handleroptions.PromWriteHandlerAsyncWriteOptions{
+		DrainTimeout: -time.Second,
+	}, tally.NoopScope)
+	require.EqualError(t, err, "async write drain timeout must not be negative: -1s")
+}
+
+func TestPromWriteAsyncWriteOptOut(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, started, release := newAsyncWriteTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerAsyncWriteOptions{Always: true},
+		tally.NoopScope, nil)
+	close(release)
+
+	require.Equal(t, http.StatusOK, serveAsyncWriteRequest(t, handler, "false"))
+	require.Len(t, started, 1)
+	require.Equal(t, http.StatusBadRequest, serveAsyncWriteRequest(t, handler, "maybe"))
+	require.NoError(t, handler.Close())
+}
+
+func TestPromWriteAsyncWriteNotEnabled(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+	handler, err := NewPromWriteHandler(makeOptions(mockDownsamplerAndWriter))
+	require.NoError(t, err)
+
+	// The header is ignored unless async writes are enabled.
+	require.Equal(t, http.StatusOK, serveAsyncWriteRequest(t, handler, "true"))
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/util/logging"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+)
+
+const (
+	// PromWriteAuditLogURL is the url for the prom write audit log handler.
+	PromWriteAuditLogURL = PromWriteURL + "/audit-log"
+
+	// PromWriteAuditLogHTTPMethod is the HTTP method used with this
+	// resource.
+	PromWriteAuditLogHTTPMethod = http.MethodGet
+
+	defaultAuditLogSize = 1000
+)
+
+var errAuditLogNotEnabled = xhttp.NewError(
+	errors.New("audit log not enabled"), http.StatusNotFound)
+
+// PromWriteAuditLogResponse is the response listing the summaries of the
+// most recent requests, oldest first.
+type PromWriteAuditLogResponse struct {
+	Records []PromWriteAuditRecord `json:"records"`
+}
+
+// PromWriteAuditRecord is the summary of a request.
+type PromWriteAuditRecord struct {
+	Timestamp  time.Time `json:"timestamp"`
+	RemoteAddr string    `json:"remoteAddr"`
+	NumSeries  int       `json:"numSeries"`
+	NumSamples int       `json:"numSamples"`
+	Status     int       `json:"status"`
+	Latency    string    `json:"latency"`
+}
+
+// auditRecord is the compact form of a request summary kept in memory.
+type auditRecord struct {
+	unixNanos  int64
+	latency    time.Duration
+	remoteAddr string
+	numSeries  int32
+	numSamples int32
+	status     uint16
+}
+
+// auditLog is a ring buffer of the summaries of the most recent requests,
+// overwriting the oldest summary once full.
+type auditLog struct {
+	sync.Mutex
+
+	records []auditRecord
+	next    int
+	full    bool
+}
+
+func newAuditLog(opts handleroptions.PromWriteHandlerAuditLogOptions) (*auditLog, error) {
+	if opts.Size < 0 {
+		return nil, fmt.Errorf("audit log size must not be negative: %d", opts.Size)
+	}
+
+	size := defaultAuditLogSize
+	if opts.Size > 0 {
+		size = opts.Size
+	}
+	return &auditLog{records: make([]auditRecord, size)}, nil
+}
+
+func (l *auditLog) record(
+	r *http.Request,
+	entry *promWriteAccessLogEntry,
+	status int,
+	now time.Time,
+	latency time.Duration,
+) {
+	if status == 0 {
+		// Nothing was explicitly written, which is an implicit success.
+		status = http.StatusOK
+	}
+
+	record := auditRecord{
+		unixNanos:  now.UnixNano(),
+		latency:    latency,
+		remoteAddr: r.RemoteAddr,
+		numSeries:  int32(entry.numSeries),
+		numSamples: int32(entry.numSamples),
+		status:     uint16(status),
+	}
+
+	l.Lock()
+	l.records[l.next] = record
+	l.next++
+	if l.next == len(l.records) {
+		l.next = 0
+		l.full = true
+	}
+	l.Unlock()
+}
+
+// list returns the summaries kept, oldest first.
+func (l *auditLog) list() []PromWriteAuditRecord {
+	l.Lock()
+	records := make([]auditRecord, 0, len(l.records))
+	if l.full {
+		records = append(records, l.records[l.next:]...)
+	}
+	records = append(records, l.records[:l.next]...)
+	l.Unlock()
+
+	result := make([]PromWriteAuditRecord, 0, len(records))
+	for _, record := range records {
+		result = append(result, PromWriteAuditRecord{
+			Timestamp:  time.Unix(0, record.unixNanos).UTC(),
+			RemoteAddr: record.remoteAddr,
+			NumSeries:  int(record.numSeries),
+			NumSamples: int(record.numSamples),
+			Status:     int(record.status),
+			Latency:    record.latency.String(),
+		})
+	}
+	return result
+}
+
+// AuditLogHandler returns the admin handler listing the summaries of the
+// most recent requests.
+func (h *PromWriteHandler) AuditLogHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.auditLog == nil {
+			xhttp.WriteError(w, errAuditLogNotEnabled)
+			return
+		}
+
+		logger := logging.WithContext(r.Context(), h.instrumentOpts)
+		xhttp.WriteJSONResponse(w, PromWriteAuditLogResponse{
+			Records: h.auditLog.list(),
+		}, logger)
+	})
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLogEvictsOldest(t *testing.T) {
+	log, err := newAuditLog(handleroptions.PromWriteHandlerAuditLogOptions{Size: 3})
+	require.NoError(t, err)
+	require.Empty(t, log.list())
+
+	start := time.Unix(1700000000, 0)
+	record := func(i int) {
+		r := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, nil)
+		entry := &promWriteAccessLogEntry{numSeries: i, numSamples: 2 * i}
+		log.record(r, entry, http.StatusOK, start.Add(time.Duration(i)*time.Second),
+			time.Millisecond)
+	}
+	numSeries := func() []int {
+		var result []int
+		for _, record := range log.list() {
+			result = append(result, record.NumSeries)
+		}
+		return result
+	}
+
+	record(1)
+	record(2)
+	require.Equal(t, []int{1, 2}, numSeries())
+
+	record(3)
+	require.Equal(t, []int{1, 2, 3}, numSeries())
+
+	record(4)
+	record(5)
+	require.Equal(t, []int{3, 4, 5}, numSeries())
+
+	require.Equal(t, PromWriteAuditRecord{
+		Timestamp:  start.Add(5 * time.Second).UTC(),
+		RemoteAddr: "192.0.2.1:1234",
+		NumSeries:  5,
+		NumSamples: 10,
+		Status:     http.StatusOK,
+		Latency:    "1ms",
+	}, log.list()[2])
+}
+
+func TestNewAuditLogNegativeSize(t *testing.T) {
+	_, err := newAuditLog(handleroptions.PromWriteHandlerAuditLogOptions{Size: -1})
+	require.Error(t, err)
+}
+
+func TestPromWriteAuditLogHandler(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(2)
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.AuditLog = &handleroptions.PromWriteHandlerAuditLogOptions{Size: 2}
+	})
+
+	write := func(numSeries int) int {
+		promReq := &prompb.WriteRequest{}
+		for i := 0; i < numSeries; i++ {
+			promReq.Timeseries = append(promReq.Timeseries, prompb.TimeSeries{
+				Labels:  testLabels("__name__", "up", "instance", string(rune('a'+i))),
+				Samples: []prompb.Sample{{Value: 1, Timestamp: time.Now().UnixMilli()}},
+			})
+		}
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+			test.GeneratePromWriteRequestBody(t, promReq))
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		return writer.Result().StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, write(1))
+	require.Equal(t, http.StatusOK, write(2))
+
+	// A request that fails to parse is recorded too.
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, nil)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+
+	req = httptest.NewRequest(PromWriteAuditLogHTTPMethod, PromWriteAuditLogURL, nil)
+	writer = httptest.NewRecorder()
+	handler.AuditLogHandler().ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	var resp PromWriteAuditLogResponse
+	require.NoError(t, json.NewDecoder(writer.Result().Body).Decode(&resp))
+	require.Len(t, resp.Records, 2)
+	require.Equal(t, 2, resp.Records[0].NumSeries)
+	require.Equal(t, 2, resp.Records[0].NumSamples)
+	require.Equal(t, http.StatusOK, resp.Records[0].Status)
+	require.Equal(t, 0, resp.Records[1].NumSeries)
+	require.Equal(t, http.StatusBadRequest, resp.Records[1].Status)
+	require.Equal(t, "192.0.2.1:1234", resp.Records[1].RemoteAddr)
+}
+
+func TestPromWriteAuditLogHandlerNotEnabled(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, err := NewPromWriteHandler(makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(PromWriteAuditLogHTTPMethod, PromWriteAuditLogURL, nil)
+	writer := httptest.NewRecorder()
+	handler.(*PromWriteHandler).AuditLogHandler().ServeHTTP(writer, req)
+	require.Equal(t, http.StatusNotFound, writer.Result().StatusCode)
+}
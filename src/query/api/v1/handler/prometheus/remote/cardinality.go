@@ -0,0 +1,327 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/options"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/clock"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+const (
+	// hyperLogLogPrecision is the number of bits used to select a register,
+	// yielding 2^14 one byte registers (16kb) per watched label name with a
+	// standard error of roughly 0.8%.
+	hyperLogLogPrecision = 14
+)
+
+var errNoLabelCardinalityThreshold = errors.New("label cardinality threshold must be set")
+
+// hyperLogLog is a minimal HyperLogLog cardinality estimator, it is not safe
+// for concurrent use.
+type hyperLogLog struct {
+	registers []uint8
+	// sum and zeros are maintained incrementally so that an estimate can be
+	// computed in constant time rather than scanning every register.
+	sum   float64
+	zeros int
+}
+
+func newHyperLogLog() *hyperLogLog {
+	h := &hyperLogLog{registers: make([]uint8, 1<<hyperLogLogPrecision)}
+	h.reset()
+	return h
+}
+
+func (h *hyperLogLog) reset() {
+	for i := range h.registers {
+		h.registers[i] = 0
+	}
+	h.sum = float64(len(h.registers))
+	h.zeros = len(h.registers)
+}
+
+func (h *hyperLogLog) position(hash uint64) (uint64, uint8) {
+	idx := hash >> (64 - hyperLogLogPrecision)
+	// Set a sentinel bit so the rank is bounded when remaining bits are zero.
+	w := hash<<hyperLogLogPrecision | 1<<(hyperLogLogPrecision-1)
+	return idx, uint8(bits.LeadingZeros64(w) + 1)
+}
+
+// wouldChange returns whether adding the hash would change the estimator,
+// which approximates whether the value has not been seen before.
+func (h *hyperLogLog) wouldChange(hash uint64) bool {
+	idx, rank := h.position(hash)
+	return rank > h.registers[idx]
+}
+
+// add adds the hash and returns whether the estimator changed.
+func (h *hyperLogLog) add(hash uint64) bool {
+	idx, rank := h.position(hash)
+	curr := h.registers[idx]
+	if rank <= curr {
+		return false
+	}
+	if curr == 0 {
+		h.zeros--
+	}
+	h.sum += math.Ldexp(1, -int(rank)) - math.Ldexp(1, -int(curr))
+	h.registers[idx] = rank
+	return true
+}
+
+func (h *hyperLogLog) estimate() uint64 {
+	m := float64(len(h.registers))
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / h.sum
+	if estimate <= 2.5*m && h.zeros > 0 {
+		// Use linear counting for small cardinalities.
+		estimate = m * math.Log(m/float64(h.zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// labelCardinalityGuard tracks the approximate number of distinct values for
+// a fixed set of watched label names, either in process or in a backend
+// shared across coordinators.
+type labelCardinalityGuard struct {
+	threshold uint64
+	reject    bool
+	window    time.Duration
+	nowFn     clock.NowFn
+	logger    *zap.Logger
+	labels    map[string]*labelCardinality
+	backend   options.PromWriteLabelCardinalityBackend
+}
+
+type labelCardinality struct {
+	sync.Mutex
+
+	name          string
+	hll           *hyperLogLog
+	windowStart   time.Time
+	exceeding     bool
+	estimateGauge tally.Gauge
+	exceeded      tally.Counter
+	rejected      tally.Counter
+	backendErrors tally.Counter
+}
+
+func newLabelCardinalityGuard(
+	opts handleroptions.PromWriteHandlerLabelCardinalityOptions,
+	backend options.PromWriteLabelCardinalityBackend,
+	nowFn clock.NowFn,
+	scope tally.Scope,
+	logger *zap.Logger,
+) (*labelCardinalityGuard, error) {
+	if opts.Threshold == 0 {
+		return nil, errNoLabelCardinalityThreshold
+	}
+
+	labels := make(map[string]*labelCardinality, len(opts.Labels))
+	for _, name := range opts.Labels {
+		labelScope := scope.SubScope("label-cardinality").
+			Tagged(map[string]string{"label": name})
+		label := &labelCardinality{
+			name:          name,
+			windowStart:   nowFn(),
+			estimateGauge: labelScope.Gauge("estimate"),
+			exceeded:      labelScope.Counter("exceeded"),
+			rejected:      labelScope.Counter("rejected"),
+			backendErrors: labelScope.Counter("backend-errors"),
+		}
+		if backend == nil {
+			label.hll = newHyperLogLog()
+		}
+		labels[name] = label
+	}
+
+	return &labelCardinalityGuard{
+		threshold: opts.Threshold,
+		reject:    opts.Reject,
+		window:    opts.Window,
+		nowFn:     nowFn,
+		logger:    logger,
+		labels:    labels,
+		backend:   backend,
+	}, nil
+}
+
+// observe tracks the values of watched labels in the series, returning an
+// error if rejection is enabled and a series carries a new value for a label
+// already exceeding its threshold.
+func (g *labelCardinalityGuard) observe(
+	ctx context.Context,
+	series []prompb.TimeSeries,
+) error {
+	if g.backend != nil {
+		return g.observeBackend(ctx, series)
+	}
+
+	now := g.nowFn()
+	for _, ts := range series {
+		for _, l := range ts.Labels {
+			label, ok := g.labels[string(l.Name)]
+			if !ok {
+				continue
+			}
+			if err := g.observeValue(label, l.Value, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (g *labelCardinalityGuard) observeValue(
+	label *labelCardinality,
+	value []byte,
+	now time.Time,
+) error {
+	hash := xxhash.Sum64(value)
+
+	label.Lock()
+	defer label.Unlock()
+
+	if g.window > 0 && now.Sub(label.windowStart) >= g.window {
+		label.hll.reset()
+		label.windowStart = now
+		label.exceeding = false
+	}
+
+	if label.exceeding && g.reject && label.hll.wouldChange(hash) {
+		label.rejected.Inc(1)
+		return fmt.Errorf("label cardinality exceeded: label=%s, threshold=%d",
+			label.name, g.threshold)
+	}
+
+	if !label.hll.add(hash) {
+		return nil
+	}
+
+	estimate := label.hll.estimate()
+	label.estimateGauge.Update(float64(estimate))
+	if !label.exceeding && estimate > g.threshold {
+		label.exceeding = true
+		label.exceeded.Inc(1)
+		g.logger.Warn("label cardinality exceeded threshold",
+			zap.String("label", label.name),
+			zap.Uint64("estimate", estimate),
+			zap.Uint64("threshold", g.threshold))
+	}
+
+	return nil
+}
+
+// observeBackend admits the values of watched labels in the series with the
+// shared backend, in a single call for all the watched label names in the
+// request.
+func (g *labelCardinalityGuard) observeBackend(
+	ctx context.Context,
+	series []prompb.TimeSeries,
+) error {
+	var values map[string][][]byte
+	for _, ts := range series {
+		for _, l := range ts.Labels {
+			label, ok := g.labels[string(l.Name)]
+			if !ok {
+				continue
+			}
+			if values == nil {
+				values = make(map[string][][]byte, len(g.labels))
+			}
+			values[label.name] = append(values[label.name], l.Value)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	admitted, err := g.backend.Admit(ctx, values, g.threshold)
+	if err != nil {
+		// Fail open so an unreachable backend does not fail every write.
+		for name := range values {
+			g.labels[name].backendErrors.Inc(1)
+		}
+		g.logger.Debug("label cardinality backend error", zap.Error(err))
+		return nil
+	}
+
+	now := g.nowFn()
+	for name, labelAdmitted := range admitted {
+		label, ok := g.labels[name]
+		if !ok {
+			continue
+		}
+		for _, ok := range labelAdmitted {
+			if ok {
+				continue
+			}
+			if err := g.observeNotAdmitted(label, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (g *labelCardinalityGuard) observeNotAdmitted(
+	label *labelCardinality,
+	now time.Time,
+) error {
+	label.Lock()
+	defer label.Unlock()
+
+	// NB: The backend expires the tracked values itself, the window is only
+	// used here to warn again once per window.
+	if g.window > 0 && now.Sub(label.windowStart) >= g.window {
+		label.windowStart = now
+		label.exceeding = false
+	}
+
+	if !label.exceeding {
+		label.exceeding = true
+		label.exceeded.Inc(1)
+		g.logger.Warn("label cardinality exceeded shared threshold",
+			zap.String("label", label.name),
+			zap.Uint64("threshold", g.threshold))
+	}
+
+	if !g.reject {
+		return nil
+	}
+	label.rejected.Inc(1)
+	return fmt.Errorf("label cardinality exceeded: label=%s, threshold=%d",
+		label.name, g.threshold)
+}
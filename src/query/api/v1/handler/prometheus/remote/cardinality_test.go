@@ -0,0 +1,349 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		hll := newHyperLogLog()
+		for i := 0; i < n; i++ {
+			hll.add(xxhash.Sum64String(fmt.Sprintf("value-%d", i)))
+		}
+		// Adding the same values again must not change the estimate.
+		for i := 0; i < n; i++ {
+			require.False(t, hll.wouldChange(xxhash.Sum64String(fmt.Sprintf("value-%d", i))))
+		}
+		if n == 0 {
+			require.Equal(t, uint64(0), hll.estimate())
+			continue
+		}
+		assert.InEpsilon(t, n, hll.estimate(), 0.03, fmt.Sprintf("n=%d", n))
+	}
+}
+
+func newTestCardinalitySeries(name, value string) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels: []prompb.Label{
+			{Name: []byte("__name__"), Value: []byte("foo")},
+			{Name: []byte(name), Value: []byte(value)},
+		},
+		Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+	}
+}
+
+func TestLabelCardinalityGuard(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	guard, err := newLabelCardinalityGuard(handleroptions.PromWriteHandlerLabelCardinalityOptions{
+		Labels:    []string{"user_id", "region"},
+		Threshold: 100,
+		Reject:    true,
+	}, nil, time.Now, scope, zap.NewNop())
+	require.NoError(t, err)
+
+	// Low cardinality label never exceeds threshold.
+	for i := 0; i < 1000; i++ {
+		series := newTestCardinalitySeries("region", fmt.Sprintf("region-%d", i%5))
+		require.NoError(t, guard.observe(context.Background(), []prompb.TimeSeries{series}))
+	}
+
+	// High cardinality label exceeds the threshold and then rejects new values.
+	var rejected int
+	for i := 0; i < 1000; i++ {
+		series := newTestCardinalitySeries("user_id", fmt.Sprintf("user-%d", i))
+		if err := guard.observe(context.Background(), []prompb.TimeSeries{series}); err != nil {
+			rejected++
+		}
+	}
+	assert.True(t, rejected > 800, fmt.Sprintf("rejected=%d", rejected))
+
+	// Existing values are still accepted.
+	require.NoError(t, guard.observe(context.Background(), []prompb.TimeSeries{
+		newTestCardinalitySeries("user_id", "user-1"),
+	}))
+
+	// Unwatched labels are ignored.
+	for i := 0; i < 1000; i++ {
+		series := newTestCardinalitySeries("other", fmt.Sprintf("other-%d", i))
+		require.NoError(t, guard.observe(context.Background(), []prompb.TimeSeries{series}))
+	}
+
+	counters := scope.Snapshot().Counters()
+	exceeded, ok := counters["label-cardinality.exceeded+label=user_id"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), exceeded.Value())
+	exceeded, ok = counters["label-cardinality.exceeded+label=region"]
+	require.True(t, ok)
+	require.Equal(t, int64(0), exceeded.Value())
+
+	gauges := scope.Snapshot().Gauges()
+	region, ok := gauges["label-cardinality.estimate+label=region"]
+	require.True(t, ok)
+	require.Equal(t, float64(5), region.Value())
+	require.Len(t, guard.labels, 2)
+}
+
+func TestLabelCardinalityGuardWindow(t *testing.T) {
+	now := time.Now()
+	nowFn := func() time.Time { return now }
+	guard, err := newLabelCardinalityGuard(handleroptions.PromWriteHandlerLabelCardinalityOptions{
+		Labels:    []string{"user_id"},
+		Threshold: 10,
+		Reject:    true,
+		Window:    time.Minute,
+	}, nil, nowFn, tally.NoopScope, zap.NewNop())
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		_ = guard.observe(context.Background(), []prompb.TimeSeries{
+			newTestCardinalitySeries("user_id", fmt.Sprintf("user-%d", i)),
+		})
+	}
+	require.Error(t, guard.observe(context.Background(), []prompb.TimeSeries{
+		newTestCardinalitySeries("user_id", "new-user"),
+	}))
+
+	// After the window elapses values are tracked afresh.
+	now = now.Add(time.Minute)
+	require.NoError(t, guard.observe(context.Background(), []prompb.TimeSeries{
+		newTestCardinalitySeries("user_id", "new-user"),
+	}))
+}
+
+func TestPromWriteLabelCardinalityReject(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.LabelCardinality = &handleroptions.PromWriteHandlerLabelCardinalityOptions{
+			Labels:    []string{"user_id"},
+			Threshold: 10,
+			Reject:    true,
+		}
+	})
+
+	promReq := &prompb.WriteRequest{}
+	for i := 0; i < 5; i++ {
+		promReq.Timeseries = append(promReq.Timeseries,
+			newTestCardinalitySeries("user_id", fmt.Sprintf("user-%d", i)))
+	}
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	promReq = &prompb.WriteRequest{}
+	for i := 0; i < 100; i++ {
+		promReq.Timeseries = append(promReq.Timeseries,
+			newTestCardinalitySeries("user_id", fmt.Sprintf("other-user-%d", i)))
+	}
+	promReqBody = test.GeneratePromWriteRequestBody(t, promReq)
+	req = httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer = httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+}
+
+type fakeLabelCardinalityBackend struct {
+	sync.Mutex
+
+	values map[string]map[string]struct{}
+	calls  int
+	err    error
+}
+
+func newFakeLabelCardinalityBackend() *fakeLabelCardinalityBackend {
+	return &fakeLabelCardinalityBackend{values: make(map[string]map[string]struct{})}
+}
+
+func (b *fakeLabelCardinalityBackend) Admit(
+	_ context.Context,
+	values map[string][][]byte,
+	limit uint64,
+) (map[string][]bool, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.calls++
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	admitted := make(map[string][]bool, len(values))
+	for name, labelValues := range values {
+		set, ok := b.values[name]
+		if !ok {
+			set = make(map[string]struct{})
+			b.values[name] = set
+		}
+		for _, v := range labelValues {
+			_, ok := set[string(v)]
+			if !ok && uint64(len(set)) < limit {
+				set[string(v)] = struct{}{}
+				ok = true
+			}
+			admitted[name] = append(admitted[name], ok)
+		}
+	}
+	return admitted, nil
+}
+
+func newTestCardinalityRequest(t *testing.T, prefix string, n int) *http.Request {
+	promReq := &prompb.WriteRequest{}
+	for i := 0; i < n; i++ {
+		promReq.Timeseries = append(promReq.Timeseries,
+			newTestCardinalitySeries("user_id", fmt.Sprintf("%s-%d", prefix, i)))
+	}
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	return httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+}
+
+func TestPromWriteLabelCardinalitySharedBackend(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(3)
+
+	// Two coordinators sharing a backend enforce a single shared limit.
+	backend := newFakeLabelCardinalityBackend()
+	newHandler := func(scope tally.Scope) http.Handler {
+		opts := makeOptions(mockDownsamplerAndWriter).
+			SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope)).
+			SetPromWriteLabelCardinalityBackend(backend)
+		handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+			cfg.PromRemoteWrite.LabelCardinality = &handleroptions.PromWriteHandlerLabelCardinalityOptions{
+				Labels:    []string{"user_id"},
+				Threshold: 10,
+				Reject:    true,
+			}
+		})
+		return handler
+	}
+	scope := tally.NewTestScope("", map[string]string{"test": "shared-cardinality-test"})
+	first := newHandler(tally.NoopScope)
+	second := newHandler(scope)
+
+	writer := httptest.NewRecorder()
+	first.ServeHTTP(writer, newTestCardinalityRequest(t, "user", 8))
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	writer = httptest.NewRecorder()
+	second.ServeHTTP(writer, newTestCardinalityRequest(t, "other-user", 3))
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+	require.Len(t, backend.values["user_id"], 10)
+
+	// Values already tracked by the other coordinator are still accepted.
+	writer = httptest.NewRecorder()
+	second.ServeHTTP(writer, newTestCardinalityRequest(t, "user", 8))
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	// Writes are not limited while the backend is unreachable.
+	backend.err = errors.New("connection refused")
+	writer = httptest.NewRecorder()
+	second.ServeHTTP(writer, newTestCardinalityRequest(t, "new-user", 20))
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	counters := scope.Snapshot().Counters()
+	rejected, ok := counters["label-cardinality.rejected+handler=remote-write,label=user_id,test=shared-cardinality-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), rejected.Value())
+	backendErrors, ok := counters["label-cardinality.backend-errors+handler=remote-write,label=user_id,test=shared-cardinality-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), backendErrors.Value())
+}
+
+func TestPromWriteLabelCardinalitySharedBackendSingleCall(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	backend := newFakeLabelCardinalityBackend()
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetPromWriteLabelCardinalityBackend(backend)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.LabelCardinality = &handleroptions.PromWriteHandlerLabelCardinalityOptions{
+			Labels:    []string{"user_id", "session_id"},
+			Threshold: 10,
+		}
+	})
+
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  testLabels("__name__", "requests", "session_id", "a", "user_id", "a"),
+				Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+			},
+			{
+				Labels:  testLabels("__name__", "requests", "session_id", "b", "user_id", "b"),
+				Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+			},
+		},
+	}
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+		test.GeneratePromWriteRequestBody(t, promReq))
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	// Every watched label name in the request is admitted in a single call.
+	require.Equal(t, 1, backend.calls)
+	require.Len(t, backend.values["user_id"], 2)
+	require.Len(t, backend.values["session_id"], 2)
+}
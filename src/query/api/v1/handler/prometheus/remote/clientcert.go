@@ -0,0 +1,71 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+)
+
+var (
+	errNoAllowedClientCommonNames = errors.New("client certificate allowed common names must be set")
+	errNoClientCertificate        = errors.New("client certificate required")
+)
+
+func newAllowedClientCommonNames(
+	opts handleroptions.PromWriteHandlerClientCertificateOptions,
+) (map[string]struct{}, error) {
+	if len(opts.AllowedCommonNames) == 0 {
+		return nil, errNoAllowedClientCommonNames
+	}
+	allowed := make(map[string]struct{}, len(opts.AllowedCommonNames))
+	for _, cn := range opts.AllowedCommonNames {
+		allowed[cn] = struct{}{}
+	}
+	return allowed, nil
+}
+
+// checkClientCertificate returns a forbidden error if client certificates
+// are required and the request does not carry a client certificate with an
+// allowed common name. The certificate chain itself is verified by the TLS
+// server config.
+func (h *PromWriteHandler) checkClientCertificate(r *http.Request) error {
+	if h.allowedClientCNs == nil {
+		return nil
+	}
+
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		h.metrics.clientCertRejected.Inc(1)
+		return xhttp.NewError(errNoClientCertificate, http.StatusForbidden)
+	}
+
+	cn := r.TLS.PeerCertificates[0].Subject.CommonName
+	if _, ok := h.allowedClientCNs[cn]; !ok {
+		h.metrics.clientCertRejected.Inc(1)
+		return xhttp.NewError(fmt.Errorf("client certificate common name not allowed: %s", cn),
+			http.StatusForbidden)
+	}
+	return nil
+}
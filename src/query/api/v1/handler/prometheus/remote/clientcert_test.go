@@ -0,0 +1,133 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func newTestClientCertState(cn string) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{
+			{Subject: pkix.Name{CommonName: cn}},
+		},
+	}
+}
+
+func TestPromWriteClientCertificate(t *testing.T) {
+	tests := []struct {
+		name           string
+		tls            *tls.ConnectionState
+		expectedStatus int
+	}{
+		{
+			name:           "allowed common name",
+			tls:            newTestClientCertState("writer"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "disallowed common name",
+			tls:            newTestClientCertState("reader"),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "no client certificate",
+			tls:            &tls.ConnectionState{},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "no tls",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedStatus == http.StatusOK {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+			}
+
+			scope := tally.NewTestScope("", map[string]string{"test": "client-cert-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.ClientCertificate = &handleroptions.PromWriteHandlerClientCertificateOptions{
+					AllowedCommonNames: []string{"writer", "other-writer"},
+				}
+			})
+
+			var req *http.Request
+			if tt.expectedStatus == http.StatusOK {
+				promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+				req = httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			} else {
+				// Rejected requests must not have their body read.
+				req = httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, failOnReadBody{t: t})
+			}
+			req.TLS = tt.tls
+
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, tt.expectedStatus, writer.Result().StatusCode)
+
+			var expectedRejected int64
+			if tt.expectedStatus != http.StatusOK {
+				expectedRejected = 1
+			}
+			rejected, ok := scope.Snapshot().Counters()["write.client-cert-rejected+handler=remote-write,test=client-cert-test"]
+			require.True(t, ok)
+			require.Equal(t, expectedRejected, rejected.Value())
+		})
+	}
+}
+
+func TestPromWriteClientCertificateNoAllowedCommonNames(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.ClientCertificate = &handleroptions.PromWriteHandlerClientCertificateOptions{}
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.Equal(t, errNoAllowedClientCommonNames, err)
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	xsync "github.com/m3db/m3/src/x/sync"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCoalescingWindow         = 10 * time.Millisecond
+	defaultCoalescingMaxSeries      = 1000
+	defaultCoalescingMaxConcurrency = 4
+)
+
+type coalescedWriteFn func(
+	ctx context.Context,
+	r *prompb.WriteRequest,
+	opts ingest.WriteOptions,
+) ingest.BatchError
+
+// writeCoalescer buffers the series of requests for up to a window, or until
+// enough series are buffered, and writes them as a single batch.
+type writeCoalescer struct {
+	sync.Mutex
+
+	window          time.Duration
+	maxSeries       int
+	respondAccepted bool
+	writeFn         coalescedWriteFn
+	workers         xsync.WorkerPool
+	logger          *zap.Logger
+	pending         *coalescedBatch
+
+	requests    tally.Counter
+	flushes     tally.Counter
+	flushErrors tally.Counter
+	batchSize   tally.Histogram
+}
+
+// coalescedBatch is the buffered series of one or more requests, its error
+// is set and done closed once it is written.
+type coalescedBatch struct {
+	series      []prompb.TimeSeries
+	numRequests int
+	timer       *time.Timer
+	done        chan struct{}
+	err         ingest.BatchError
+}
+
+func newWriteCoalescer(
+	opts handleroptions.PromWriteHandlerCoalescingOptions,
+	writeFn coalescedWriteFn,
+	logger *zap.Logger,
+	scope tally.Scope,
+) (*writeCoalescer, error) {
+	switch opts.Respond {
+	case "", handleroptions.PromWriteHandlerCoalescingRespondModeFlush,
+		handleroptions.PromWriteHandlerCoalescingRespondModeAccepted:
+	default:
+		return nil, fmt.Errorf("unknown coalescing respond mode: %s", opts.Respond)
+	}
+
+	window := defaultCoalescingWindow
+	if opts.Window > 0 {
+		window = opts.Window
+	}
+
+	maxSeries := defaultCoalescingMaxSeries
+	if opts.MaxSeries > 0 {
+		maxSeries = opts.MaxSeries
+	}
+
+	maxConcurrency := defaultCoalescingMaxConcurrency
+	if opts.MaxConcurrency > 0 {
+		maxConcurrency = opts.MaxConcurrency
+	}
+	workers := xsync.NewWorkerPool(maxConcurrency)
+	workers.Init()
+
+	scope = scope.SubScope("write").SubScope("coalesce")
+	return &writeCoalescer{
+		window:          window,
+		maxSeries:       maxSeries,
+		respondAccepted: opts.Respond == handleroptions.PromWriteHandlerCoalescingRespondModeAccepted,
+		writeFn:         writeFn,
+		workers:         workers,
+		logger:          logger,
+		requests:        scope.Counter("requests"),
+		flushes:         scope.Counter("flushes"),
+		flushErrors:     scope.Counter("flush-errors"),
+		batchSize: scope.Histogram("batch-size",
+			tally.MustMakeExponentialValueBuckets(1, 2, 16)),
+	}, nil
+}
+
+// coalesces returns true if requests with the write options are coalesced,
+// requests overriding the write options cannot share a batch.
+func (c *writeCoalescer) coalesces(opts ingest.WriteOptions) bool {
+	return !opts.DownsampleOverride && !opts.WriteOverride
+}
+
+// write buffers the series of the request and, unless requests are responded
+// to as soon as they are accepted, waits for the batch to be written and
+// returns the error of the request. Returns true if the request was only
+// accepted. Requests filling a batch wait for a worker to write it, which
+// bounds the number of concurrent batch writes.
+func (c *writeCoalescer) write(
+	ctx context.Context,
+	r *prompb.WriteRequest,
+) (ingest.BatchError, bool) {
+	c.Lock()
+	b := c.pending
+	if b == nil {
+		b = &coalescedBatch{done: make(chan struct{})}
+		b.timer = time.AfterFunc(c.window, func() {
+			c.flushPending(b)
+		})
+		c.pending = b
+	}
+	start := len(b.series)
+	b.series = append(b.series, r.Timeseries...)
+	end := len(b.series)
+	b.numRequests++
+	full := len(b.series) >= c.maxSeries
+	if full {
+		c.pending = nil
+	}
+	c.Unlock()
+
+	c.requests.Inc(1)
+	if full {
+		b.timer.Stop()
+		c.workers.Go(func() {
+			c.flush(b)
+		})
+	}
+
+	if c.respondAccepted {
+		return nil, true
+	}
+
+	select {
+	case <-b.done:
+		return b.requestError(start, end), false
+	case <-ctx.Done():
+		return xerrors.NewMultiError().Add(ctx.Err()), false
+	}
+}
+
+// flushPending flushes the batch if it is still pending, i.e. it was not
+// already flushed for being full.
+func (c *writeCoalescer) flushPending(b *coalescedBatch) {
+	c.Lock()
+	if c.pending != b {
+		c.Unlock()
+		return
+	}
+	c.pending = nil
+	c.Unlock()
+
+	c.workers.Go(func() {
+		c.flush(b)
+	})
+}
+
+func (c *writeCoalescer) flush(b *coalescedBatch) {
+	defer close(b.done)
+
+	// NB: The batch outlives the requests coalesced into it, so it is not
+	// written with the context of any of them.
+	b.err = c.writeFn(context.Background(),
+		&prompb.WriteRequest{Timeseries: b.series}, ingest.WriteOptions{})
+
+	c.flushes.Inc(1)
+	c.batchSize.RecordValue(float64(b.numRequests))
+	if b.err != nil {
+		c.flushErrors.Inc(1)
+		c.logger.Error("coalesced write error",
+			zap.Int("numRequests", b.numRequests),
+			zap.Int("numSeries", len(b.series)),
+			zap.Error(b.err))
+	}
+}
+
+// requestError returns the error of the request with the series at the range
+// of the batch series. The errors of a batch write are not attributed to
+// series, so unless the request has all the series of the batch it gets a
+// generic retryable error rather than errors that may belong to the other
+// requests.
+func (b *coalescedBatch) requestError(start, end int) ingest.BatchError {
+	if b.err == nil {
+		return nil
+	}
+	if start == 0 && end == len(b.series) {
+		return b.err
+	}
+	return xerrors.NewMultiError().Add(fmt.Errorf(
+		"coalesced write failed, errors cannot be attributed to requests: requests=%d, errors=%d",
+		b.numRequests, len(b.err.Errors())))
+}
+
+// Close writes any pending batch.
+func (c *writeCoalescer) Close() {
+	c.Lock()
+	b := c.pending
+	c.pending = nil
+	c.Unlock()
+
+	if b != nil {
+		b.timer.Stop()
+		c.flush(b)
+	}
+}
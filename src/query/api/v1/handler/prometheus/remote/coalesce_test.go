@@ -0,0 +1,268 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xclock "github.com/m3db/m3/src/x/clock"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/headers"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+func newCoalescingTestHandler(
+	t *testing.T,
+	ctrl *gomock.Controller,
+	coalescing handleroptions.PromWriteHandlerCoalescingOptions,
+	batchErr ingest.BatchError,
+) (*PromWriteHandler, *[]int) {
+	var (
+		lock    sync.Mutex
+		batches []int
+	)
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			numSeries := 0
+			for iter.Next() {
+				numSeries++
+			}
+			lock.Lock()
+			batches = append(batches, numSeries)
+			lock.Unlock()
+			return batchErr
+		}).
+		AnyTimes()
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.Coalescing = &coalescing
+	})
+	return handler, &batches
+}
+
+// serveConcurrently serves the requests concurrently and returns the status
+// code of each.
+func serveConcurrently(t *testing.T, handler http.Handler, numRequests int) []int {
+	var (
+		wg    sync.WaitGroup
+		codes = make([]int, numRequests)
+	)
+	for i := 0; i < numRequests; i++ {
+		i := i
+		body := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			codes[i] = writer.Result().StatusCode
+		}()
+	}
+	wg.Wait()
+	return codes
+}
+
+func TestPromWriteCoalescingFlushesWindow(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, batches := newCoalescingTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerCoalescingOptions{
+			Window:    time.Second,
+			MaxSeries: 1 << 20,
+		}, nil)
+
+	codes := serveConcurrently(t, handler, 3)
+	require.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK}, codes)
+
+	numSeries := len(test.GeneratePromWriteRequest().Timeseries)
+	require.Equal(t, []int{3 * numSeries}, *batches)
+}
+
+func TestPromWriteCoalescingFlushesMaxSeries(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	numSeries := len(test.GeneratePromWriteRequest().Timeseries)
+	handler, batches := newCoalescingTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerCoalescingOptions{
+			// NB: The window is long enough that the test would time out if
+			// the batch was not flushed for being full.
+			Window:    time.Hour,
+			MaxSeries: 2 * numSeries,
+		}, nil)
+
+	codes := serveConcurrently(t, handler, 2)
+	require.Equal(t, []int{http.StatusOK, http.StatusOK}, codes)
+	require.Equal(t, []int{2 * numSeries}, *batches)
+}
+
+func TestPromWriteCoalescingErrorAttributed(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, batches := newCoalescingTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerCoalescingOptions{
+			Window:    10 * time.Millisecond,
+			MaxSeries: 1 << 20,
+		}, xerrors.NewMultiError().Add(xerrors.NewInvalidParamsError(errors.New("bad series"))))
+
+	// The request alone in the batch gets the batch error.
+	codes := serveConcurrently(t, handler, 1)
+	require.Equal(t, []int{http.StatusBadRequest}, codes)
+	require.Len(t, *batches, 1)
+}
+
+func TestPromWriteCoalescingErrorNotAttributed(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, batches := newCoalescingTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerCoalescingOptions{
+			Window:    time.Second,
+			MaxSeries: 1 << 20,
+		}, xerrors.NewMultiError().Add(xerrors.NewInvalidParamsError(errors.New("bad series"))))
+
+	// The batch error may belong to either request, so both get a generic
+	// retryable error.
+	codes := serveConcurrently(t, handler, 2)
+	require.Equal(t, []int{http.StatusInternalServerError, http.StatusInternalServerError}, codes)
+	require.Len(t, *batches, 1)
+}
+
+func TestPromWriteCoalescingAccepted(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, batches := newCoalescingTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerCoalescingOptions{
+			Window:    time.Hour,
+			MaxSeries: 1 << 20,
+			Respond:   handleroptions.PromWriteHandlerCoalescingRespondModeAccepted,
+		}, nil)
+
+	codes := serveConcurrently(t, handler, 2)
+	require.Equal(t, []int{http.StatusAccepted, http.StatusAccepted}, codes)
+	require.Empty(t, *batches)
+
+	// Closing the handler writes the pending batch.
+	require.NoError(t, handler.Close())
+	numSeries := len(test.GeneratePromWriteRequest().Timeseries)
+	require.Equal(t, []int{2 * numSeries}, *batches)
+}
+
+func TestPromWriteCoalescingWriteOverrideNotCoalesced(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, batches := newCoalescingTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerCoalescingOptions{
+			Window:  time.Hour,
+			Respond: handleroptions.PromWriteHandlerCoalescingRespondModeAccepted,
+		}, nil)
+
+	body := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+	req.Header.Set(headers.MetricsTypeHeader, "unaggregated")
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	require.Len(t, *batches, 1)
+}
+
+func TestWriteCoalescerMaxConcurrency(t *testing.T) {
+	var (
+		lock        sync.Mutex
+		inFlight    int
+		maxInFlight int
+		release     = make(chan struct{})
+	)
+	writeFn := func(context.Context, *prompb.WriteRequest, ingest.WriteOptions) ingest.BatchError {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+
+		<-release
+
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+		return nil
+	}
+	c, err := newWriteCoalescer(handleroptions.PromWriteHandlerCoalescingOptions{
+		Window:         time.Hour,
+		MaxSeries:      1,
+		MaxConcurrency: 2,
+	}, writeFn, zap.NewNop(), tally.NoopScope)
+	require.NoError(t, err)
+
+	// Every request fills a batch.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batchErr, accepted := c.write(context.Background(), test.GeneratePromWriteRequest())
+			require.NoError(t, batchErr)
+			require.False(t, accepted)
+		}()
+	}
+	require.True(t, xclock.WaitUntil(func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return inFlight == 2
+	}, time.Second))
+
+	close(release)
+	wg.Wait()
+	require.Equal(t, 2, maxInFlight)
+}
+
+func TestNewWriteCoalescerUnknownRespondMode(t *testing.T) {
+	_, err := newWriteCoalescer(handleroptions.PromWriteHandlerCoalescingOptions{
+		Respond: "unknown",
+	}, nil, nil, tally.NoopScope)
+	require.Error(t, err)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	acceptEncodingHeader  = "Accept-Encoding"
+	contentEncodingHeader = "Content-Encoding"
+	contentLengthHeader   = "Content-Length"
+	varyHeader            = "Vary"
+	gzipEncoding          = "gzip"
+)
+
+// gzipResponseWriter gzip compresses the response body, the status code is
+// held until the body is first written so that responses without a body,
+// such as the empty body of a successful write, are left uncompressed.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+
+	gzipWriter  *gzip.Writer
+	status      int
+	wroteHeader bool
+}
+
+// withResponseCompression returns a response writer that gzip compresses
+// response bodies if the request accepts gzip encoded responses, along with
+// a function that must be called once the response is written.
+func withResponseCompression(
+	w http.ResponseWriter,
+	r *http.Request,
+) (http.ResponseWriter, func()) {
+	if !acceptsGzipEncoding(r) {
+		return w, func() {}
+	}
+	gw := &gzipResponseWriter{ResponseWriter: w}
+	return gw, gw.close
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		header := w.Header()
+		header.Set(contentEncodingHeader, gzipEncoding)
+		header.Add(varyHeader, acceptEncodingHeader)
+		header.Del(contentLengthHeader)
+		w.writeHeader()
+		w.gzipWriter = gzip.NewWriter(w.ResponseWriter)
+	}
+	return w.gzipWriter.Write(p)
+}
+
+func (w *gzipResponseWriter) writeHeader() {
+	w.wroteHeader = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gzipWriter != nil {
+		w.gzipWriter.Close() //nolint:errcheck
+		return
+	}
+	if !w.wroteHeader {
+		w.writeHeader()
+	}
+}
+
+// acceptsGzipEncoding returns whether the request accepts gzip encoded
+// responses, i.e. it lists gzip in the Accept-Encoding header without a
+// zero quality value.
+func acceptsGzipEncoding(r *http.Request) bool {
+	for _, value := range r.Header.Values(acceptEncodingHeader) {
+		for _, encoding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(encoding, ";")
+			if !strings.EqualFold(strings.TrimSpace(name), gzipEncoding) {
+				continue
+			}
+			param := strings.TrimSpace(params)
+			if !strings.HasPrefix(param, "q=") {
+				return true
+			}
+			quality, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			return err == nil && quality > 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromWriteErrorResponseCompression(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		expectGzip     bool
+	}{
+		{
+			name: "no accept encoding",
+		},
+		{
+			name:           "gzip accepted",
+			acceptEncoding: "gzip",
+			expectGzip:     true,
+		},
+		{
+			name:           "gzip accepted among others",
+			acceptEncoding: "deflate, gzip;q=0.5",
+			expectGzip:     true,
+		},
+		{
+			name:           "gzip refused",
+			acceptEncoding: "gzip;q=0",
+		},
+		{
+			name:           "other encoding accepted",
+			acceptEncoding: "deflate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			handler, err := NewPromWriteHandler(makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)))
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+				strings.NewReader("not a snappy compressed body"))
+			if tt.acceptEncoding != "" {
+				req.Header.Set(acceptEncodingHeader, tt.acceptEncoding)
+			}
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+
+			resp := writer.Result()
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			body := resp.Body
+			if tt.expectGzip {
+				require.Equal(t, gzipEncoding, resp.Header.Get(contentEncodingHeader))
+				require.Equal(t, acceptEncodingHeader, resp.Header.Get(varyHeader))
+				body, err = gzip.NewReader(resp.Body)
+				require.NoError(t, err)
+			} else {
+				require.Empty(t, resp.Header.Get(contentEncodingHeader))
+			}
+
+			data, err := ioutil.ReadAll(body)
+			require.NoError(t, err)
+			assert.Contains(t, string(data), `"status":"error"`)
+		})
+	}
+}
+
+func TestPromWriteSuccessResponseNotCompressed(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	handler, err := NewPromWriteHandler(makeOptions(mockDownsamplerAndWriter))
+	require.NoError(t, err)
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(acceptEncodingHeader, gzipEncoding)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+
+	resp := writer.Result()
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.Header.Get(contentEncodingHeader))
+	require.Empty(t, writer.Body.Bytes())
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/util/logging"
+	"github.com/m3db/m3/src/x/headers"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+	xsync "github.com/m3db/m3/src/x/sync"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDeadLetterTimeout        = 15 * time.Second
+	defaultDeadLetterMaxConcurrency = 16
+)
+
+var errNoDeadLetterURL = errors.New("dead letter url must be set")
+
+// PromWriteDeadLetter is the body posted to the dead letter collector for a
+// request that failed to write.
+type PromWriteDeadLetter struct {
+	RequestID string            `json:"requestID"`
+	Headers   map[string]string `json:"headers"`
+	NumErrors int               `json:"numErrors"`
+	LastError string            `json:"lastError"`
+	// Body is the original snappy compressed request body.
+	Body []byte `json:"body"`
+}
+
+// deadLetterPoster posts failed writes to a dead letter collector in the
+// background, bounded by its worker pool.
+type deadLetterPoster struct {
+	url     string
+	timeout time.Duration
+	client  *http.Client
+	workers xsync.WorkerPool
+}
+
+func newDeadLetterPoster(
+	opts handleroptions.PromWriteHandlerDeadLetterOptions,
+) (*deadLetterPoster, error) {
+	if opts.URL == "" {
+		return nil, errNoDeadLetterURL
+	}
+
+	timeout := defaultDeadLetterTimeout
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+
+	maxConcurrency := defaultDeadLetterMaxConcurrency
+	if opts.MaxConcurrency > 0 {
+		maxConcurrency = opts.MaxConcurrency
+	}
+	workers := xsync.NewWorkerPool(maxConcurrency)
+	workers.Init()
+
+	httpOpts := xhttp.DefaultHTTPClientOptions()
+	httpOpts.RequestTimeout = timeout
+
+	return &deadLetterPoster{
+		url:     opts.URL,
+		timeout: timeout,
+		client:  xhttp.NewHTTPClient(httpOpts),
+		workers: workers,
+	}, nil
+}
+
+// postDeadLetter asynchronously posts the failed request to the dead letter
+// collector if configured, it is dropped if no worker is available.
+func (h *PromWriteHandler) postDeadLetter(
+	r *http.Request,
+	checkedReq parseRequestResult,
+	batchErr ingest.BatchError,
+) {
+	if h.deadLetter == nil {
+		return
+	}
+
+	deadLetter := PromWriteDeadLetter{
+		RequestID: logging.ReadContextID(r.Context()),
+		Headers:   make(map[string]string),
+		NumErrors: len(batchErr.Errors()),
+		Body:      checkedReq.CompressResult.CompressedBody,
+	}
+	if err := batchErr.LastError(); err != nil {
+		deadLetter.LastError = err.Error()
+	}
+	// Keep the M3 headers so the request can be reprocessed with the
+	// same behavior.
+	for name := range r.Header {
+		if strings.HasPrefix(name, headers.M3HeaderPrefix) {
+			deadLetter.Headers[name] = r.Header.Get(name)
+		}
+	}
+
+	post := func() {
+		if err := h.deadLetter.post(deadLetter); err != nil {
+			h.metrics.deadLetterErrors.Inc(1)
+			logger := logging.WithContext(r.Context(), h.instrumentOpts)
+			logger.Error("dead letter post error", zap.Error(err))
+			return
+		}
+		h.metrics.deadLetterSuccess.Inc(1)
+	}
+	if !h.deadLetter.workers.GoIfAvailable(post) {
+		h.metrics.deadLetterDropped.Inc(1)
+	}
+}
+
+func (p *deadLetterPoster) post(deadLetter PromWriteDeadLetter) error {
+	return postJSON(p.client, p.url, p.timeout, deadLetter)
+}
+
+// postJSON posts the value encoded as JSON to the url, returning an error
+// unless the response is a 2XX.
+func postJSON(
+	client *http.Client,
+	url string,
+	timeout time.Duration,
+	value interface{},
+) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(xhttp.HeaderContentType, xhttp.ContentTypeJSON)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		response, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			response = []byte(fmt.Sprintf("error reading body: %v", err))
+		}
+		return fmt.Errorf("expected status code 2XX: actual=%v, url=%v, resp=%s",
+			resp.StatusCode, url, response)
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	xclock "github.com/m3db/m3/src/x/clock"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/headers"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestPromWriteDeadLetter(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	deadLetterCh := make(chan PromWriteDeadLetter, 2)
+	collector := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var deadLetter PromWriteDeadLetter
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&deadLetter))
+			deadLetterCh <- deadLetter
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer collector.Close()
+
+	batchErr := ingest.BatchError(xerrors.NewMultiError().Add(errors.New("an error")))
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	gomock.InOrder(
+		mockDownsamplerAndWriter.EXPECT().
+			WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()),
+		mockDownsamplerAndWriter.EXPECT().
+			WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(batchErr),
+	)
+
+	scope := tally.NewTestScope("", map[string]string{"test": "dead-letter-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.DeadLetter = &handleroptions.PromWriteHandlerDeadLetterOptions{
+			URL: collector.URL,
+		}
+	})
+
+	write := func(requestID string) (int, []byte) {
+		body, err := ioutil.ReadAll(
+			test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest()))
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, bytes.NewReader(body))
+		req.Header.Set(headers.RequestIDHeader, requestID)
+		req.Header.Set(headers.MetricsTypeHeader, "unaggregated")
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		return writer.Result().StatusCode, body
+	}
+
+	// Successful writes are not posted.
+	status, _ := write("succeeded")
+	require.Equal(t, http.StatusOK, status)
+
+	status, body := write("failed")
+	require.Equal(t, http.StatusInternalServerError, status)
+
+	var deadLetter PromWriteDeadLetter
+	select {
+	case deadLetter = <-deadLetterCh:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for dead letter")
+	}
+	require.Equal(t, PromWriteDeadLetter{
+		RequestID: "failed",
+		Headers: map[string]string{
+			headers.MetricsTypeHeader: "unaggregated",
+		},
+		NumErrors: 1,
+		LastError: "an error",
+		Body:      body,
+	}, deadLetter)
+
+	require.True(t, xclock.WaitUntil(func() bool {
+		success, ok := scope.Snapshot().Counters()["dead-letter.success+handler=remote-write,test=dead-letter-test"]
+		return ok && success.Value() == 1
+	}, 10*time.Second))
+	require.Len(t, deadLetterCh, 0)
+}
+
+func TestPromWriteDeadLetterNoURL(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.DeadLetter = &handleroptions.PromWriteHandlerDeadLetterOptions{}
+	opts = opts.SetConfig(cfg)
+
+	_, err := NewPromWriteHandler(opts)
+	require.Equal(t, errNoDeadLetterURL, err)
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/headers"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+)
+
+const (
+	// maxDebugTextExpositionSeries is the max number of series in a request
+	// that can be rendered as text exposition, to keep debug responses small.
+	maxDebugTextExpositionSeries = 1000
+
+	textExpositionContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// debugTextExposition returns true if the request asks for the decoded
+// series to be rendered as text exposition rather than written.
+func debugTextExposition(r *http.Request) (bool, error) {
+	return parseBoolHeader(r, headers.DebugTextExpositionHeader)
+}
+
+// parseBoolHeader returns the value of an optional boolean header, which is
+// false if the header is not set.
+func parseBoolHeader(r *http.Request, name string) (bool, error) {
+	v := strings.TrimSpace(r.Header.Get(name))
+	if v == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, xerrors.NewInvalidParamsError(
+			fmt.Errorf("invalid %s header: %v", name, err))
+	}
+	return enabled, nil
+}
+
+// writeDebugTextExposition responds with the decoded series of the request
+// rendered in the Prometheus text exposition format, nothing is written.
+func (h *PromWriteHandler) writeDebugTextExposition(
+	w http.ResponseWriter,
+	req *prompb.WriteRequest,
+) error {
+	if n := len(req.Timeseries); n > maxDebugTextExpositionSeries {
+		return xerrors.NewInvalidParamsError(fmt.Errorf(
+			"too many series for text exposition: series=%d, max=%d",
+			n, maxDebugTextExpositionSeries))
+	}
+
+	w.Header().Set(xhttp.HeaderContentType, textExpositionContentType)
+	_, err := w.Write(renderTextExposition(req))
+	return err
+}
+
+// renderTextExposition renders the series of a request in the Prometheus
+// text exposition format, one line per sample in request order.
+func renderTextExposition(req *prompb.WriteRequest) []byte {
+	var (
+		buf    bytes.Buffer
+		series []byte
+	)
+	for _, ts := range req.Timeseries {
+		series = appendTextExpositionSeries(series[:0], ts.Labels)
+		for _, sample := range ts.Samples {
+			buf.Write(series)
+			buf.WriteByte(' ')
+			buf.WriteString(formatTextExpositionValue(sample.Value))
+			buf.WriteByte(' ')
+			buf.WriteString(strconv.FormatInt(sample.Timestamp, 10))
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+func appendTextExpositionSeries(dst []byte, labels []prompb.Label) []byte {
+	for _, l := range labels {
+		if bytes.Equal(l.Name, promMetricNameLabel) {
+			dst = append(dst, l.Value...)
+			break
+		}
+	}
+
+	first := true
+	for _, l := range labels {
+		if bytes.Equal(l.Name, promMetricNameLabel) {
+			continue
+		}
+		if first {
+			dst = append(dst, '{')
+			first = false
+		} else {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, l.Name...)
+		dst = append(dst, `="`...)
+		dst = append(dst, labelValueEscaper.Replace(string(l.Value))...)
+		dst = append(dst, '"')
+	}
+	if !first {
+		dst = append(dst, '}')
+	}
+	return dst
+}
+
+func formatTextExpositionValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
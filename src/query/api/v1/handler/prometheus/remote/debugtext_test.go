@@ -0,0 +1,192 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/headers"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestPromWriteDebugTextExposition(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	// NB: No writes are expected.
+	handler, err := NewPromWriteHandler(makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)))
+	require.NoError(t, err)
+
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{
+					{Name: []byte("job"), Value: []byte("api")},
+					{Name: []byte("__name__"), Value: []byte("http_requests_total")},
+					{Name: []byte("path"), Value: []byte(`C:\dir "quoted"` + "\n")},
+				},
+				Samples: []prompb.Sample{
+					{Value: 1027, Timestamp: 1395066363000},
+					{Value: 1.5e-3, Timestamp: 1395066364000},
+				},
+			},
+			{
+				Labels: []prompb.Label{
+					{Name: []byte("__name__"), Value: []byte("up")},
+				},
+				Samples: []prompb.Sample{
+					{Value: math.Inf(1), Timestamp: 1395066363000},
+					{Value: math.NaN(), Timestamp: 1395066364000},
+				},
+			},
+		},
+	}
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(headers.DebugTextExpositionHeader, "true")
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+
+	resp := writer.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, textExpositionContentType, resp.Header.Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	expected := `http_requests_total{job="api",path="C:\\dir \"quoted\"\n"} 1027 1395066363000
+http_requests_total{job="api",path="C:\\dir \"quoted\"\n"} 0.0015 1395066364000
+up +Inf 1395066363000
+up NaN 1395066364000
+`
+	require.Equal(t, expected, string(body))
+}
+
+func TestPromWriteDebugTextExpositionStateless(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(1)
+
+	scope := tally.NewTestScope("", map[string]string{"test": "debug-text-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.TenantSeriesLimit = &handleroptions.PromWriteHandlerTenantSeriesLimitOptions{
+			Header: tenantSeriesTestHeader,
+			Limit:  1,
+		}
+		cfg.PromRemoteWrite.LabelCardinality = &handleroptions.PromWriteHandlerLabelCardinalityOptions{
+			Labels:    []string{"foo"},
+			Threshold: 1,
+			Reject:    true,
+		}
+		cfg.PromRemoteWrite.LabelCounts = &handleroptions.PromWriteHandlerLabelCountsOptions{}
+	})
+
+	write := func(debug bool, name, foo string) int {
+		promReq := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+			Labels:  testLabels("__name__", name, "foo", foo),
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		}}}
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+			test.GeneratePromWriteRequestBody(t, promReq))
+		req.Header.Set(tenantSeriesTestHeader, "tenant")
+		if debug {
+			req.Header.Set(headers.DebugTextExpositionHeader, "true")
+		}
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		return writer.Result().StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, write(true, "a", "bar"))
+
+	snapshot := scope.Snapshot()
+	for name, counter := range snapshot.Counters() {
+		require.Equal(t, int64(0), counter.Value(), name)
+	}
+	for name, histogram := range snapshot.Histograms() {
+		for _, n := range histogram.Values() {
+			require.Equal(t, int64(0), n, name)
+		}
+	}
+
+	// Neither the tenant series limit nor the label cardinality observed the
+	// debug request, so a different series and label value still fit.
+	require.Equal(t, http.StatusOK, write(false, "b", "baz"))
+}
+
+func TestPromWriteDebugTextExpositionTooManySeries(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, err := NewPromWriteHandler(makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)))
+	require.NoError(t, err)
+
+	promReq := &prompb.WriteRequest{}
+	for i := 0; i <= maxDebugTextExpositionSeries; i++ {
+		promReq.Timeseries = append(promReq.Timeseries, prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: []byte("__name__"), Value: []byte("up")}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1395066363000}},
+		})
+	}
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(headers.DebugTextExpositionHeader, "true")
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+}
+
+func TestPromWriteDebugTextExpositionInvalidHeader(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, err := NewPromWriteHandler(makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)))
+	require.NoError(t, err)
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(headers.DebugTextExpositionHeader, "not-a-bool")
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+}
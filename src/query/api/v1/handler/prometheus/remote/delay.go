@@ -0,0 +1,86 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/headers"
+)
+
+// defaultMaxDebugResponseDelay is the default cap of an injected debug delay.
+const defaultMaxDebugResponseDelay = 10 * time.Second
+
+// debugResponseDelay returns the debug delay to inject before responding to
+// the request, which is always zero unless enabled by the server.
+func (h *PromWriteHandler) debugResponseDelay(r *http.Request) (time.Duration, error) {
+	delayOpts := h.handlerOpts.DebugResponseDelay
+	if delayOpts == nil || !delayOpts.Enabled {
+		return 0, nil
+	}
+
+	delay := delayOpts.Delay
+	if v := strings.TrimSpace(r.Header.Get(headers.DebugResponseDelayHeader)); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, xerrors.NewInvalidParamsError(
+				fmt.Errorf("invalid %s header: %v", headers.DebugResponseDelayHeader, err))
+		}
+		if parsed < 0 {
+			return 0, xerrors.NewInvalidParamsError(
+				fmt.Errorf("invalid %s header: negative delay %s",
+					headers.DebugResponseDelayHeader, v))
+		}
+		delay = parsed
+	}
+
+	maxDelay := defaultMaxDebugResponseDelay
+	if v := delayOpts.MaxDelay; v > 0 {
+		maxDelay = v
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return delay, nil
+}
+
+// injectDebugResponseDelay blocks for the debug delay of the request, or
+// until the request is cancelled.
+func (h *PromWriteHandler) injectDebugResponseDelay(r *http.Request) error {
+	delay, err := h.debugResponseDelay(r)
+	if err != nil || delay <= 0 {
+		return err
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	return nil
+}
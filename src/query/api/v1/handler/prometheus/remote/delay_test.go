@@ -0,0 +1,207 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/x/headers"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDebugResponseDelayTestHandler(
+	t *testing.T,
+	ctrl *gomock.Controller,
+	delayOpts *handleroptions.PromWriteHandlerDebugResponseDelayOptions,
+) *PromWriteHandler {
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes()
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.DebugResponseDelay = delayOpts
+	})
+	return handler
+}
+
+func TestDebugResponseDelay(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		name      string
+		delayOpts *handleroptions.PromWriteHandlerDebugResponseDelayOptions
+		header    string
+		expected  time.Duration
+		expectErr bool
+	}{
+		{
+			name:     "no options",
+			header:   "1s",
+			expected: 0,
+		},
+		{
+			name: "disabled",
+			delayOpts: &handleroptions.PromWriteHandlerDebugResponseDelayOptions{
+				Delay: time.Second,
+			},
+			header:   "1s",
+			expected: 0,
+		},
+		{
+			name: "disabled ignores invalid header",
+			delayOpts: &handleroptions.PromWriteHandlerDebugResponseDelayOptions{
+				Delay: time.Second,
+			},
+			header:   "invalid",
+			expected: 0,
+		},
+		{
+			name: "enabled default delay",
+			delayOpts: &handleroptions.PromWriteHandlerDebugResponseDelayOptions{
+				Enabled: true,
+				Delay:   time.Second,
+			},
+			expected: time.Second,
+		},
+		{
+			name: "enabled header delay",
+			delayOpts: &handleroptions.PromWriteHandlerDebugResponseDelayOptions{
+				Enabled: true,
+				Delay:   time.Second,
+			},
+			header:   "250ms",
+			expected: 250 * time.Millisecond,
+		},
+		{
+			name: "enabled header delay capped",
+			delayOpts: &handleroptions.PromWriteHandlerDebugResponseDelayOptions{
+				Enabled:  true,
+				MaxDelay: 2 * time.Second,
+			},
+			header:   "1h",
+			expected: 2 * time.Second,
+		},
+		{
+			name: "enabled header delay capped by default",
+			delayOpts: &handleroptions.PromWriteHandlerDebugResponseDelayOptions{
+				Enabled: true,
+			},
+			header:   "1h",
+			expected: defaultMaxDebugResponseDelay,
+		},
+		{
+			name: "enabled invalid header",
+			delayOpts: &handleroptions.PromWriteHandlerDebugResponseDelayOptions{
+				Enabled: true,
+			},
+			header:    "invalid",
+			expectErr: true,
+		},
+		{
+			name: "enabled negative header",
+			delayOpts: &handleroptions.PromWriteHandlerDebugResponseDelayOptions{
+				Enabled: true,
+			},
+			header:    "-1s",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := newDebugResponseDelayTestHandler(t, ctrl, tt.delayOpts)
+
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, nil)
+			if tt.header != "" {
+				req.Header.Set(headers.DebugResponseDelayHeader, tt.header)
+			}
+
+			delay, err := handler.debugResponseDelay(req)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, delay)
+		})
+	}
+}
+
+func TestPromWriteDebugResponseDelay(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	delay := 100 * time.Millisecond
+	handler := newDebugResponseDelayTestHandler(t, ctrl,
+		&handleroptions.PromWriteHandlerDebugResponseDelayOptions{
+			Enabled: true,
+		})
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(headers.DebugResponseDelayHeader, delay.String())
+
+	start := time.Now()
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	require.True(t, time.Since(start) >= delay, "delay should be applied")
+
+	req = httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, nil)
+	req.Header.Set(headers.DebugResponseDelayHeader, "invalid")
+	writer = httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+}
+
+func TestPromWriteDebugResponseDelayDisabled(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler := newDebugResponseDelayTestHandler(t, ctrl,
+		&handleroptions.PromWriteHandlerDebugResponseDelayOptions{
+			Delay: time.Hour,
+		})
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(headers.DebugResponseDelayHeader, "1h")
+
+	// Would block for an hour if the delay were applied.
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+
+	"github.com/uber-go/tally"
+)
+
+// seriesDenylist drops or rejects series matching every label matcher of
+// any of its entries.
+type seriesDenylist struct {
+	entries [][]seriesLabelMatcher
+	reject  bool
+	denied  tally.Counter
+}
+
+// seriesLabelMatcher matches the value of a label exactly, or against a
+// pattern if it has one.
+type seriesLabelMatcher struct {
+	name    []byte
+	value   []byte
+	pattern *regexp.Regexp
+}
+
+func newSeriesDenylist(
+	opts handleroptions.PromWriteHandlerSeriesDenylistOptions,
+	scope tally.Scope,
+) (*seriesDenylist, error) {
+	switch opts.Mode {
+	case "", handleroptions.PromWriteHandlerSeriesDenylistModeDrop,
+		handleroptions.PromWriteHandlerSeriesDenylistModeReject:
+	default:
+		return nil, fmt.Errorf("unknown series denylist mode: %s", opts.Mode)
+	}
+
+	entries := make([][]seriesLabelMatcher, 0, len(opts.Entries))
+	for i, entry := range opts.Entries {
+		if len(entry.Matchers) == 0 {
+			return nil, fmt.Errorf("series denylist entry has no matchers: index=%d", i)
+		}
+
+		matchers := make([]seriesLabelMatcher, 0, len(entry.Matchers))
+		for _, m := range entry.Matchers {
+			if m.Name == "" {
+				return nil, fmt.Errorf("series denylist matcher has no label name: "+
+					"index=%d", i)
+			}
+			matcher := seriesLabelMatcher{
+				name:  []byte(m.Name),
+				value: []byte(m.Value),
+			}
+			if m.Regex {
+				// Anchor the pattern so that it must match the whole value.
+				re, err := regexp.Compile("^(?:" + m.Value + ")$")
+				if err != nil {
+					return nil, fmt.Errorf("invalid series denylist pattern: "+
+						"name=%s, %w", m.Name, err)
+				}
+				matcher.pattern = re
+			}
+			matchers = append(matchers, matcher)
+		}
+		entries = append(entries, matchers)
+	}
+
+	return &seriesDenylist{
+		entries: entries,
+		reject:  opts.Mode == handleroptions.PromWriteHandlerSeriesDenylistModeReject,
+		denied:  scope.SubScope("write").Counter("series-denied"),
+	}, nil
+}
+
+// apply drops the denied series of the request, returning the number of
+// series dropped, or returns an error for the first denied series when
+// rejecting.
+func (d *seriesDenylist) apply(req *prompb.WriteRequest) (int, error) {
+	var (
+		kept       = req.Timeseries[:0]
+		numDropped int
+	)
+	for _, ts := range req.Timeseries {
+		if !d.denies(ts.Labels) {
+			kept = append(kept, ts)
+			continue
+		}
+
+		d.denied.Inc(1)
+		if d.reject {
+			return 0, fmt.Errorf("series denied: labels=%s",
+				formatPromLabels(ts.Labels))
+		}
+		numDropped++
+	}
+
+	if numDropped > 0 {
+		req.Timeseries = kept
+	}
+	return numDropped, nil
+}
+
+// denies returns whether the labels match every matcher of any entry.
+func (d *seriesDenylist) denies(labels []prompb.Label) bool {
+	for _, matchers := range d.entries {
+		if matchAllLabels(matchers, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAllLabels(matchers []seriesLabelMatcher, labels []prompb.Label) bool {
+	for _, m := range matchers {
+		if !m.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m seriesLabelMatcher) matches(labels []prompb.Label) bool {
+	var value []byte
+	for _, l := range labels {
+		if bytes.Equal(l.Name, m.name) {
+			value = l.Value
+			break
+		}
+	}
+	if m.pattern != nil {
+		return m.pattern.Match(value)
+	}
+	return bytes.Equal(value, m.value)
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/headers"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func testSeriesDenylistOptions(
+	mode handleroptions.PromWriteHandlerSeriesDenylistMode,
+) handleroptions.PromWriteHandlerSeriesDenylistOptions {
+	return handleroptions.PromWriteHandlerSeriesDenylistOptions{
+		Mode: mode,
+		Entries: []handleroptions.PromWriteHandlerSeriesDenylistEntry{
+			{
+				Matchers: []handleroptions.PromWriteHandlerLabelMatcher{
+					{Name: "job", Value: "debug"},
+					{Name: "env", Value: "prod"},
+				},
+			},
+			{
+				Matchers: []handleroptions.PromWriteHandlerLabelMatcher{
+					{Name: "__name__", Value: "test_.*", Regex: true},
+				},
+			},
+		},
+	}
+}
+
+func testDenylistSeries(labels ...string) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels:  testLabels(labels...),
+		Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}},
+	}
+}
+
+func TestSeriesDenylist(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []string
+		denied bool
+	}{
+		{
+			name:   "full combination",
+			labels: []string{"__name__", "up", "job", "debug", "env", "prod"},
+			denied: true,
+		},
+		{
+			name:   "full combination with other labels",
+			labels: []string{"__name__", "up", "env", "prod", "instance", "a", "job", "debug"},
+			denied: true,
+		},
+		{
+			name:   "part of combination",
+			labels: []string{"__name__", "up", "job", "debug", "env", "dev"},
+		},
+		{
+			name:   "one label of combination",
+			labels: []string{"__name__", "up", "job", "debug"},
+		},
+		{
+			name:   "regex match",
+			labels: []string{"__name__", "test_metric", "job", "api"},
+			denied: true,
+		},
+		{
+			name:   "regex matches whole value only",
+			labels: []string{"__name__", "my_test_metric", "job", "api"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			denylist, err := newSeriesDenylist(testSeriesDenylistOptions(""),
+				tally.NoopScope)
+			require.NoError(t, err)
+			require.Equal(t, tt.denied, denylist.denies(testLabels(tt.labels...)))
+		})
+	}
+}
+
+func TestSeriesDenylistApply(t *testing.T) {
+	newRequest := func() *prompb.WriteRequest {
+		return &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				testDenylistSeries("__name__", "up", "job", "debug", "env", "prod"),
+				testDenylistSeries("__name__", "up", "job", "debug", "env", "dev"),
+				testDenylistSeries("__name__", "test_metric"),
+			},
+		}
+	}
+
+	scope := tally.NewTestScope("", nil)
+	denylist, err := newSeriesDenylist(testSeriesDenylistOptions(
+		handleroptions.PromWriteHandlerSeriesDenylistModeDrop), scope)
+	require.NoError(t, err)
+
+	req := newRequest()
+	numDropped, err := denylist.apply(req)
+	require.NoError(t, err)
+	require.Equal(t, 2, numDropped)
+	require.Equal(t, []prompb.TimeSeries{
+		testDenylistSeries("__name__", "up", "job", "debug", "env", "dev"),
+	}, req.Timeseries)
+
+	denied := scope.Snapshot().Counters()["write.series-denied+"]
+	require.NotNil(t, denied)
+	require.Equal(t, int64(2), denied.Value())
+
+	denylist, err = newSeriesDenylist(testSeriesDenylistOptions(
+		handleroptions.PromWriteHandlerSeriesDenylistModeReject), tally.NoopScope)
+	require.NoError(t, err)
+
+	_, err = denylist.apply(newRequest())
+	require.EqualError(t, err, `series denied: labels={__name__="up",job="debug",env="prod"}`)
+}
+
+func TestSeriesDenylistInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        handleroptions.PromWriteHandlerSeriesDenylistOptions
+		expectedErr string
+	}{
+		{
+			name: "unknown mode",
+			opts: handleroptions.PromWriteHandlerSeriesDenylistOptions{
+				Mode: "ignore",
+			},
+			expectedErr: "unknown series denylist mode: ignore",
+		},
+		{
+			name: "no matchers",
+			opts: handleroptions.PromWriteHandlerSeriesDenylistOptions{
+				Entries: []handleroptions.PromWriteHandlerSeriesDenylistEntry{{}},
+			},
+			expectedErr: "series denylist entry has no matchers: index=0",
+		},
+		{
+			name: "no label name",
+			opts: handleroptions.PromWriteHandlerSeriesDenylistOptions{
+				Entries: []handleroptions.PromWriteHandlerSeriesDenylistEntry{
+					{Matchers: []handleroptions.PromWriteHandlerLabelMatcher{{Value: "a"}}},
+				},
+			},
+			expectedErr: "series denylist matcher has no label name: index=0",
+		},
+		{
+			name: "invalid pattern",
+			opts: handleroptions.PromWriteHandlerSeriesDenylistOptions{
+				Entries: []handleroptions.PromWriteHandlerSeriesDenylistEntry{
+					{Matchers: []handleroptions.PromWriteHandlerLabelMatcher{
+						{Name: "job", Value: "(", Regex: true},
+					}},
+				},
+			},
+			expectedErr: "invalid series denylist pattern: name=job, " +
+				"error parsing regexp: missing closing ): `^(?:()$`",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newSeriesDenylist(tt.opts, tally.NoopScope)
+			require.EqualError(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestPromWriteSeriesDenylist(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var written []prompb.Label
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			require.True(t, iter.Next())
+			for _, tag := range iter.Current().Tags.Tags {
+				written = append(written, prompb.Label{Name: tag.Name, Value: tag.Value})
+			}
+			require.False(t, iter.Next())
+			return nil
+		})
+
+	scope := tally.NewTestScope("", map[string]string{"test": "series-denylist-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	denylistOpts := testSeriesDenylistOptions(handleroptions.PromWriteHandlerSeriesDenylistModeDrop)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.SeriesDenylist = &denylistOpts
+	})
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			testDenylistSeries("__name__", "up", "env", "prod", "job", "debug"),
+			testDenylistSeries("__name__", "up", "env", "prod", "job", "api"),
+		},
+	})
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(headers.DebugDropCountsHeader, "true")
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	resp := writer.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get(headers.DroppedDeniedHeader))
+
+	require.Contains(t, written, prompb.Label{Name: []byte("job"), Value: []byte("api")})
+
+	denied, ok := scope.Snapshot().Counters()["write.series-denied+handler=remote-write,test=series-denylist-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), denied.Value())
+}
+
+func TestPromWriteSeriesDenylistReject(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	denylistOpts := testSeriesDenylistOptions(handleroptions.PromWriteHandlerSeriesDenylistModeReject)
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.SeriesDenylist = &denylistOpts
+	})
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			testDenylistSeries("__name__", "up", "env", "prod", "job", "api"),
+			testDenylistSeries("__name__", "up", "env", "prod", "job", "debug"),
+		},
+	})
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	resp := writer.Result()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, string(PromWriteErrorCodeSeriesDenied),
+		resp.Header.Get(headers.ErrorCodeHeader))
+}
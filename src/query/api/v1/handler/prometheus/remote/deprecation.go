@@ -0,0 +1,96 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/util/logging"
+
+	"go.uber.org/zap"
+)
+
+const (
+	warningHeader = "Warning"
+	// miscPersistentWarningCode is the RFC 7234 warn code for arbitrary
+	// warnings that are not removed after validation.
+	miscPersistentWarningCode = "299"
+)
+
+var errDeprecatedHeaderNoName = errors.New("deprecated header must have a name")
+
+// promWriteDeprecatedHeader is a deprecated request header and the warning
+// returned when it is used.
+type promWriteDeprecatedHeader struct {
+	name    string
+	warning string
+}
+
+func newPromWriteDeprecatedHeaders(
+	opts []handleroptions.PromWriteHandlerDeprecatedHeaderOptions,
+) ([]promWriteDeprecatedHeader, error) {
+	deprecated := make([]promWriteDeprecatedHeader, 0, len(opts))
+	for _, v := range opts {
+		if v.Name == "" {
+			return nil, errDeprecatedHeaderNoName
+		}
+		name := http.CanonicalHeaderKey(v.Name)
+		message := v.Message
+		if message == "" {
+			message = fmt.Sprintf("%s header is deprecated", name)
+		}
+		deprecated = append(deprecated, promWriteDeprecatedHeader{
+			name:    name,
+			warning: miscPersistentWarningCode + " - " + strconv.Quote(message),
+		})
+	}
+	return deprecated, nil
+}
+
+// warnDeprecatedHeaders adds a Warning response header for each deprecated
+// header the request uses.
+func (h *PromWriteHandler) warnDeprecatedHeaders(w http.ResponseWriter, r *http.Request) {
+	for _, deprecated := range h.deprecatedHeaders {
+		if _, ok := r.Header[deprecated.name]; !ok {
+			continue
+		}
+		w.Header().Add(warningHeader, deprecated.warning)
+		h.metrics.deprecatedHeaderUsed.Inc(1)
+		h.maybeLogDeprecatedHeader(r, deprecated.name)
+	}
+}
+
+func (h *PromWriteHandler) maybeLogDeprecatedHeader(r *http.Request, name string) {
+	if atomic.AddUint32(&h.numDeprecatedHeaderUsed, 1) > maxDeprecatedHeaderLogCount {
+		return
+	}
+
+	logger := logging.WithContext(r.Context(), h.instrumentOpts)
+	logger.Warn("request used deprecated header",
+		zap.String("header", name),
+		zap.String("remoteAddr", r.RemoteAddr),
+		zap.String("userAgent", r.UserAgent()))
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/x/headers"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestPromWriteDeprecatedHeaders(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(maxDeprecatedHeaderLogCount + 2)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetLogger(zap.New(core)))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.DeprecatedHeaders = []handleroptions.PromWriteHandlerDeprecatedHeaderOptions{
+			{Name: headers.WriteTypeHeader},
+			{Name: "x-legacy-header", Message: `use "M3-New-Header" instead`},
+		}
+	})
+
+	write := func(header http.Header) http.Header {
+		promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		resp := writer.Result()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return resp.Header
+	}
+
+	// No deprecated header used.
+	respHeader := write(nil)
+	require.Empty(t, respHeader.Values(warningHeader))
+
+	// Both deprecated headers used.
+	respHeader = write(http.Header{
+		headers.WriteTypeHeader: []string{headers.DefaultWriteType},
+		"X-Legacy-Header":       []string{"1"},
+	})
+	require.Equal(t, []string{
+		`299 - "M3-Write-Type header is deprecated"`,
+		`299 - "use \"M3-New-Header\" instead"`,
+	}, respHeader.Values(warningHeader))
+
+	// Logging is sampled while the warning header is always set.
+	for i := 0; i < maxDeprecatedHeaderLogCount; i++ {
+		respHeader = write(http.Header{
+			headers.WriteTypeHeader: []string{headers.DefaultWriteType},
+		})
+		require.Equal(t, []string{`299 - "M3-Write-Type header is deprecated"`},
+			respHeader.Values(warningHeader))
+	}
+	require.Equal(t, maxDeprecatedHeaderLogCount,
+		logs.FilterMessage("request used deprecated header").Len())
+}
+
+func TestPromWriteDeprecatedHeadersNoName(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.DeprecatedHeaders = []handleroptions.PromWriteHandlerDeprecatedHeaderOptions{
+		{Message: "deprecated"},
+	}
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.Equal(t, errDeprecatedHeaderNoName, err)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/m3db/m3/src/x/headers"
+)
+
+// promWriteDropCounts is the number of series or labels dropped from a single
+// write request for each reason.
+type promWriteDropCounts struct {
+	noName          int
+	truncated       int
+	duplicateLabels int
+	malformed       int
+	denied          int
+}
+
+// debugDropCounts returns true if the request asks for the drop counts of the
+// request to be returned in the response headers.
+func debugDropCounts(r *http.Request) (bool, error) {
+	return parseBoolHeader(r, headers.DebugDropCountsHeader)
+}
+
+// setHeaders sets a response header for each drop reason.
+func (c promWriteDropCounts) setHeaders(h http.Header) {
+	h.Set(headers.DroppedNoNameHeader, strconv.Itoa(c.noName))
+	h.Set(headers.DroppedTruncatedHeader, strconv.Itoa(c.truncated))
+	h.Set(headers.DroppedDuplicateLabelsHeader, strconv.Itoa(c.duplicateLabels))
+	h.Set(headers.DroppedMalformedHeader, strconv.Itoa(c.malformed))
+	h.Set(headers.DroppedDeniedHeader, strconv.Itoa(c.denied))
+}
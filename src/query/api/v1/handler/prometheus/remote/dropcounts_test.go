@@ -0,0 +1,144 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/headers"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromWriteDebugDropCounts(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(2)
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.MissingName = handleroptions.PromWriteHandlerMissingNameModeDrop
+		cfg.PromRemoteWrite.LabelNormalization = &handleroptions.PromWriteHandlerLabelNormalizationOptions{}
+		cfg.PromRemoteWrite.MaxSamplesPerRequest = &handleroptions.PromWriteHandlerMaxSamplesOptions{
+			Limit: 2,
+			Mode:  handleroptions.PromWriteHandlerMaxSamplesModeTruncate,
+		}
+	})
+
+	now := time.Now().UnixMilli()
+	newRequest := func() *http.Request {
+		promReq := &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "first", "job", "a", "job", "b"),
+					Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+				},
+				{
+					Labels:  testLabels("job", "a"),
+					Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+				},
+				{
+					Labels: testLabels("__name__", "second"),
+					Samples: []prompb.Sample{
+						{Timestamp: now, Value: 1},
+						{Timestamp: now + 1, Value: 2},
+					},
+				},
+			},
+		}
+		promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+		return httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	}
+
+	req := newRequest()
+	req.Header.Set(headers.DebugDropCountsHeader, "true")
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	resp := writer.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get(headers.DroppedNoNameHeader))
+	require.Equal(t, "1", resp.Header.Get(headers.DroppedTruncatedHeader))
+	require.Equal(t, "1", resp.Header.Get(headers.DroppedDuplicateLabelsHeader))
+
+	// Drop counts are only returned when asked for.
+	writer = httptest.NewRecorder()
+	handler.ServeHTTP(writer, newRequest())
+	resp = writer.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.Header.Get(headers.DroppedNoNameHeader))
+	require.Empty(t, resp.Header.Get(headers.DroppedTruncatedHeader))
+	require.Empty(t, resp.Header.Get(headers.DroppedDuplicateLabelsHeader))
+}
+
+func TestPromWriteDebugDropCountsNoDrops(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	handler, err := NewPromWriteHandler(makeOptions(mockDownsamplerAndWriter))
+	require.NoError(t, err)
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(headers.DebugDropCountsHeader, "true")
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	resp := writer.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "0", resp.Header.Get(headers.DroppedNoNameHeader))
+	require.Equal(t, "0", resp.Header.Get(headers.DroppedTruncatedHeader))
+	require.Equal(t, "0", resp.Header.Get(headers.DroppedDuplicateLabelsHeader))
+	require.Equal(t, "0", resp.Header.Get(headers.DroppedMalformedHeader))
+}
+
+func TestPromWriteDebugDropCountsInvalidHeader(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, err := NewPromWriteHandler(makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)))
+	require.NoError(t, err)
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(headers.DebugDropCountsHeader, "maybe")
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+}
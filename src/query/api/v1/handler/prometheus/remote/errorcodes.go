@@ -0,0 +1,204 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/headers"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+)
+
+// PromWriteErrorCode is a stable, machine readable code identifying the
+// reason a write request was rejected, returned in the error code header
+// and the error response body so that clients need not parse error messages.
+// Codes are never renamed or reused for another reason.
+type PromWriteErrorCode string
+
+const (
+	// PromWriteErrorCodeInvalidRequest is the code of a rejected request that
+	// has no more specific code.
+	PromWriteErrorCodeInvalidRequest PromWriteErrorCode = "INVALID_REQUEST"
+	// PromWriteErrorCodeInvalidHeader is the code of a request with a header
+	// that has an invalid value.
+	PromWriteErrorCodeInvalidHeader PromWriteErrorCode = "INVALID_HEADER"
+	// PromWriteErrorCodeUnknownHeader is the code of a request with an M3
+	// header that is not known, when strict M3 headers are enabled.
+	PromWriteErrorCodeUnknownHeader PromWriteErrorCode = "UNKNOWN_HEADER"
+	// PromWriteErrorCodeHeaderConflict is the code of a request with headers
+	// that conflict with each other.
+	PromWriteErrorCodeHeaderConflict PromWriteErrorCode = "HEADER_CONFLICT"
+	// PromWriteErrorCodeUnsupportedVersion is the code of a request with a
+	// remote write version that is not accepted.
+	PromWriteErrorCodeUnsupportedVersion PromWriteErrorCode = "UNSUPPORTED_VERSION"
+	// PromWriteErrorCodeUnsupportedContentType is the code of a request with
+	// a content type that is not accepted, when strict content types are
+	// enabled.
+	PromWriteErrorCodeUnsupportedContentType PromWriteErrorCode = "UNSUPPORTED_CONTENT_TYPE"
+	// PromWriteErrorCodeInvalidBody is the code of a request with a body that
+	// cannot be decompressed or decoded.
+	PromWriteErrorCodeInvalidBody PromWriteErrorCode = "INVALID_BODY"
+	// PromWriteErrorCodeTruncatedBody is the code of a request with a
+	// compressed body that ends before its declared length, such as when the
+	// client connection drops mid stream.
+	PromWriteErrorCodeTruncatedBody PromWriteErrorCode = "TRUNCATED_BODY"
+	// PromWriteErrorCodeInvalidUTF8 is the code of a request with a label
+	// name or value that is not valid UTF-8.
+	PromWriteErrorCodeInvalidUTF8 PromWriteErrorCode = "INVALID_UTF8"
+	// PromWriteErrorCodeInvalidLabelName is the code of a request with a
+	// label name that is not valid.
+	PromWriteErrorCodeInvalidLabelName PromWriteErrorCode = "INVALID_LABEL_NAME"
+	// PromWriteErrorCodeDuplicateLabelName is the code of a request with a
+	// series carrying a label name more than once, including once labels are
+	// trimmed or split.
+	PromWriteErrorCodeDuplicateLabelName PromWriteErrorCode = "DUPLICATE_LABEL_NAME"
+	// PromWriteErrorCodeLabelTooLong is the code of a request with a label
+	// name or value exceeding its max length.
+	PromWriteErrorCodeLabelTooLong PromWriteErrorCode = "LABEL_TOO_LONG"
+	// PromWriteErrorCodeMissingMetricName is the code of a request with a
+	// series that has no metric name.
+	PromWriteErrorCodeMissingMetricName PromWriteErrorCode = "MISSING_METRIC_NAME"
+	// PromWriteErrorCodeSeriesDenied is the code of a request with a series
+	// matching the series denylist, when rejecting denied series.
+	PromWriteErrorCodeSeriesDenied PromWriteErrorCode = "SERIES_DENIED"
+	// PromWriteErrorCodeTimestampTooOld is the code of a request with a
+	// sample older than the timestamp floor.
+	PromWriteErrorCodeTimestampTooOld PromWriteErrorCode = "TIMESTAMP_TOO_OLD"
+	// PromWriteErrorCodeMalformedSeries is the code of a request with a
+	// malformed series.
+	PromWriteErrorCodeMalformedSeries PromWriteErrorCode = "MALFORMED_SERIES"
+	// PromWriteErrorCodeInconsistentHistogram is the code of a request with
+	// a histogram whose bucket counts are inconsistent.
+	PromWriteErrorCodeInconsistentHistogram PromWriteErrorCode = "INCONSISTENT_HISTOGRAM"
+	// PromWriteErrorCodeUnsupportedSeries is the code of a request with a
+	// series that cannot be written.
+	PromWriteErrorCodeUnsupportedSeries PromWriteErrorCode = "UNSUPPORTED_SERIES"
+	// PromWriteErrorCodeNonMonotonicTimestamp is the code of a request with a
+	// sample that does not advance past the last sample written for its
+	// series.
+	PromWriteErrorCodeNonMonotonicTimestamp PromWriteErrorCode = "NON_MONOTONIC_TIMESTAMP"
+	// PromWriteErrorCodeTooManySamples is the code of a request with more
+	// samples than the max samples per request.
+	PromWriteErrorCodeTooManySamples PromWriteErrorCode = "TOO_MANY_SAMPLES"
+	// PromWriteErrorCodeTooManySeries is the code of a request that would
+	// exceed the active series limit of its tenant.
+	PromWriteErrorCodeTooManySeries PromWriteErrorCode = "TOO_MANY_SERIES"
+	// PromWriteErrorCodeCardinalityLimitExceeded is the code of a request
+	// that would exceed the cardinality limit of a label.
+	PromWriteErrorCodeCardinalityLimitExceeded PromWriteErrorCode = "CARDINALITY_LIMIT_EXCEEDED"
+	// PromWriteErrorCodeRequestRejected is the code of a request rejected by
+	// the request validator.
+	PromWriteErrorCodeRequestRejected PromWriteErrorCode = "REQUEST_REJECTED"
+	// PromWriteErrorCodeOverloaded is the code of a request rejected since
+	// the server lacks capacity for it at the time, such as a saturated
+	// priority pool, in-flight bytes budget or async write queue.
+	PromWriteErrorCodeOverloaded PromWriteErrorCode = "OVERLOADED"
+	// PromWriteErrorCodeRequestTooLarge is the code of a request too large
+	// to be written, such as exceeding the in-flight bytes or memory budget
+	// on its own.
+	PromWriteErrorCodeRequestTooLarge PromWriteErrorCode = "REQUEST_TOO_LARGE"
+	// PromWriteErrorCodeWriteRejected is the code of a request whose series
+	// were all rejected by storage as invalid.
+	PromWriteErrorCodeWriteRejected PromWriteErrorCode = "WRITE_REJECTED"
+	// PromWriteErrorCodeUnauthorized is the code of a request rejected by
+	// client certificate or JWT authentication.
+	PromWriteErrorCodeUnauthorized PromWriteErrorCode = "UNAUTHORIZED"
+	// PromWriteErrorCodeWritesPaused is the code of a request rejected while
+	// writes are paused.
+	PromWriteErrorCodeWritesPaused PromWriteErrorCode = "WRITES_PAUSED"
+)
+
+// PromWriteErrorResponse is the response returned when a write request is
+// rejected with an error code.
+type PromWriteErrorResponse struct {
+	Status string             `json:"status"`
+	Error  string             `json:"error"`
+	Code   PromWriteErrorCode `json:"code"`
+}
+
+// codedError attaches an error code to an error.
+type codedError struct {
+	err  error
+	code PromWriteErrorCode
+}
+
+func (e codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e codedError) InnerError() error {
+	return e.err
+}
+
+// withErrorCode attaches the code to the error, keeping the status code of
+// HTTP errors.
+func withErrorCode(err error, code PromWriteErrorCode) error {
+	coded := codedError{err: err, code: code}
+	if httpErr, ok := err.(xhttp.Error); ok { //nolint:errorlint
+		return xhttp.NewError(coded, httpErr.Code())
+	}
+	return coded
+}
+
+// errorCode returns the outermost code attached to the error.
+func errorCode(err error) (PromWriteErrorCode, bool) {
+	for ; err != nil; err = innerError(err) {
+		if coded, ok := err.(codedError); ok { //nolint:errorlint
+			return coded.code, true
+		}
+	}
+	return "", false
+}
+
+// innerError returns the error contained or wrapped by the error.
+func innerError(err error) error {
+	if inner := xerrors.InnerError(err); inner != nil {
+		return inner
+	}
+	return errors.Unwrap(err)
+}
+
+// writeError writes the error, along with its code in the error code header
+// and the response body if it has one.
+func writeError(w http.ResponseWriter, err error) {
+	code, ok := errorCode(err)
+	if !ok {
+		xhttp.WriteError(w, err)
+		return
+	}
+
+	resp, marshalErr := json.Marshal(PromWriteErrorResponse{
+		Status: "error",
+		Error:  err.Error(),
+		Code:   code,
+	})
+	if marshalErr != nil {
+		xhttp.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set(headers.ErrorCodeHeader, string(code))
+	w.Header().Set(xhttp.HeaderContentType, xhttp.ContentTypeJSON)
+	xhttp.WriteError(w, err, xhttp.WithErrorResponse(resp))
+}
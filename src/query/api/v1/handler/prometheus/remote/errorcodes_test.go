@@ -0,0 +1,286 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/headers"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithErrorCode(t *testing.T) {
+	err := withErrorCode(xhttp.NewError(errors.New("saturated"), http.StatusTooManyRequests),
+		PromWriteErrorCodeOverloaded)
+	require.EqualError(t, err, "saturated")
+	httpErr, ok := err.(xhttp.Error) //nolint:errorlint
+	require.True(t, ok)
+	require.Equal(t, http.StatusTooManyRequests, httpErr.Code())
+
+	code, ok := errorCode(err)
+	require.True(t, ok)
+	require.Equal(t, PromWriteErrorCodeOverloaded, code)
+
+	// The outermost code wins and codes are found through wrapped errors.
+	err = xerrors.NewInvalidParamsError(withErrorCode(
+		withErrorCode(errors.New("bad"), PromWriteErrorCodeMalformedSeries),
+		PromWriteErrorCodeInvalidRequest))
+	require.True(t, xerrors.IsInvalidParams(err))
+	code, ok = errorCode(err)
+	require.True(t, ok)
+	require.Equal(t, PromWriteErrorCodeInvalidRequest, code)
+
+	_, ok = errorCode(errors.New("bad"))
+	require.False(t, ok)
+}
+
+func TestPromWriteErrorCodes(t *testing.T) {
+	now := time.Now().UnixMilli()
+	series := func(labels ...string) prompb.TimeSeries {
+		return prompb.TimeSeries{
+			Labels:  testLabels(labels...),
+			Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+		}
+	}
+
+	tests := []struct {
+		name           string
+		configure      func(*handleroptions.PromWriteHandlerOptions)
+		series         []prompb.TimeSeries
+		body           []byte
+		headers        map[string]string
+		paused         bool
+		expectedStatus int
+		expectedCode   PromWriteErrorCode
+	}{
+		{
+			name:           "invalid body",
+			body:           []byte("not snappy"),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   PromWriteErrorCodeInvalidBody,
+		},
+		{
+			name:           "invalid header",
+			headers:        map[string]string{headers.MetricsTypeHeader: "sometimes"},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   PromWriteErrorCodeInvalidHeader,
+		},
+		{
+			name: "unknown header",
+			configure: func(opts *handleroptions.PromWriteHandlerOptions) {
+				opts.StrictM3Headers = true
+			},
+			headers:        map[string]string{headers.M3HeaderPrefix + "Unknown": "1"},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   PromWriteErrorCodeUnknownHeader,
+		},
+		{
+			name: "header conflict",
+			headers: map[string]string{
+				headers.WriteTypeHeader:            headers.AggregateWriteType,
+				headers.MetricsStoragePolicyHeader: "1m:21d",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   PromWriteErrorCodeHeaderConflict,
+		},
+		{
+			name: "unsupported content type",
+			configure: func(opts *handleroptions.PromWriteHandlerOptions) {
+				opts.StrictContentType = true
+			},
+			headers:        map[string]string{xhttp.HeaderContentType: xhttp.ContentTypeJSON},
+			expectedStatus: http.StatusUnsupportedMediaType,
+			expectedCode:   PromWriteErrorCodeUnsupportedContentType,
+		},
+		{
+			name: "invalid utf8",
+			configure: func(opts *handleroptions.PromWriteHandlerOptions) {
+				opts.UTF8Validation = handleroptions.PromWriteHandlerUTF8ValidationModeReject
+			},
+			series:         []prompb.TimeSeries{series("__name__", "up", "job", "\xff")},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   PromWriteErrorCodeInvalidUTF8,
+		},
+		{
+			name: "duplicate label name",
+			configure: func(opts *handleroptions.PromWriteHandlerOptions) {
+				opts.DuplicateLabelNames = handleroptions.PromWriteHandlerDuplicateLabelNamesModeReject
+			},
+			series:         []prompb.TimeSeries{series("__name__", "up", "job", "a", "job", "b")},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   PromWriteErrorCodeDuplicateLabelName,
+		},
+		{
+			name: "label too long",
+			configure: func(opts *handleroptions.PromWriteHandlerOptions) {
+				opts.MaxLabelValueLength = 4
+			},
+			series:         []prompb.TimeSeries{series("__name__", "up", "job", "too-long")},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   PromWriteErrorCodeLabelTooLong,
+		},
+		{
+			name: "missing metric name",
+			configure: func(opts *handleroptions.PromWriteHandlerOptions) {
+				opts.MissingName = handleroptions.PromWriteHandlerMissingNameModeReject
+			},
+			series:         []prompb.TimeSeries{series("job", "a")},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   PromWriteErrorCodeMissingMetricName,
+		},
+		{
+			name: "malformed series",
+			configure: func(opts *handleroptions.PromWriteHandlerOptions) {
+				opts.MalformedSeries = handleroptions.PromWriteHandlerMalformedSeriesModeReject
+			},
+			series: []prompb.TimeSeries{
+				{Labels: testLabels("__name__", "up", "job", "a", "job", "b")},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   PromWriteErrorCodeMalformedSeries,
+		},
+		{
+			name: "inconsistent histogram",
+			configure: func(opts *handleroptions.PromWriteHandlerOptions) {
+				opts.HistogramValidation = &handleroptions.PromWriteHandlerHistogramValidationOptions{
+					Reject: true,
+				}
+			},
+			series: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "latency_bucket", "le", "0.1"),
+					Samples: []prompb.Sample{{Timestamp: now, Value: 2}},
+				},
+				{
+					Labels:  testLabels("__name__", "latency_bucket", "le", "+Inf"),
+					Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   PromWriteErrorCodeInconsistentHistogram,
+		},
+		{
+			name: "too many samples",
+			configure: func(opts *handleroptions.PromWriteHandlerOptions) {
+				opts.MaxSamplesPerRequest = &handleroptions.PromWriteHandlerMaxSamplesOptions{
+					Limit: 1,
+				}
+			},
+			series:         []prompb.TimeSeries{series("__name__", "a"), series("__name__", "b")},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   PromWriteErrorCodeTooManySamples,
+		},
+		{
+			name: "too many series",
+			configure: func(opts *handleroptions.PromWriteHandlerOptions) {
+				opts.TenantSeriesLimit = &handleroptions.PromWriteHandlerTenantSeriesLimitOptions{
+					Header: "Tenant",
+					Limit:  1,
+				}
+			},
+			series:         []prompb.TimeSeries{series("__name__", "a"), series("__name__", "b")},
+			headers:        map[string]string{"Tenant": "a"},
+			expectedStatus: http.StatusTooManyRequests,
+			expectedCode:   PromWriteErrorCodeTooManySeries,
+		},
+		{
+			name: "unknown write priority",
+			configure: func(opts *handleroptions.PromWriteHandlerOptions) {
+				opts.PriorityPools = &handleroptions.PromWriteHandlerPriorityPoolsOptions{}
+			},
+			headers:        map[string]string{headers.WritePriorityHeader: "urgent"},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   PromWriteErrorCodeInvalidHeader,
+		},
+		{
+			name:           "writes paused",
+			paused:         true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   PromWriteErrorCodeWritesPaused,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.
+				EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(nil).
+				AnyTimes()
+
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				if tt.configure != nil {
+					tt.configure(&cfg.PromRemoteWrite)
+				}
+			})
+			handler.SetPaused(tt.paused)
+
+			body := tt.body
+			if body == nil {
+				promReq := test.GeneratePromWriteRequest()
+				if tt.series != nil {
+					promReq = &prompb.WriteRequest{Timeseries: tt.series}
+				}
+				buf := new(bytes.Buffer)
+				_, err := buf.ReadFrom(test.GeneratePromWriteRequestBody(t, promReq))
+				require.NoError(t, err)
+				body = buf.Bytes()
+			}
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, bytes.NewReader(body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+
+			resp := writer.Result()
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			require.Equal(t, string(tt.expectedCode), resp.Header.Get(headers.ErrorCodeHeader))
+
+			var errResp PromWriteErrorResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+			require.Equal(t, "error", errResp.Status)
+			require.NotEmpty(t, errResp.Error)
+			require.Equal(t, tt.expectedCode, errResp.Code)
+		})
+	}
+}
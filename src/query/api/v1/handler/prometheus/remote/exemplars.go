@@ -0,0 +1,133 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// promWriteRequestTimeseriesField is the timeseries field of a write request.
+	promWriteRequestTimeseriesField protowire.Number = 1
+	// promTimeSeriesExemplarsField is the field Prometheus encodes exemplars
+	// as, which clashes with the M3 timeseries type field.
+	promTimeSeriesExemplarsField protowire.Number = 3
+	// m3TimeSeriesExemplarsField is the field M3 decodes exemplars from.
+	m3TimeSeriesExemplarsField protowire.Number = 103
+)
+
+// remapPromExemplars rewrites exemplars encoded by Prometheus in an
+// uncompressed write request body to the field M3 decodes exemplars from.
+// The body is returned as is if no series carries exemplars.
+func remapPromExemplars(body []byte) ([]byte, error) {
+	return remapExemplarsField(body, promTimeSeriesExemplarsField,
+		m3TimeSeriesExemplarsField)
+}
+
+// restorePromExemplars rewrites exemplars encoded by M3 in an uncompressed
+// write request body back to the field Prometheus decodes exemplars from,
+// so that bodies re-encoded for forwarding carry exemplars any remote write
+// receiver understands. The body is returned as is if no series carries
+// exemplars.
+func restorePromExemplars(body []byte) ([]byte, error) {
+	return remapExemplarsField(body, m3TimeSeriesExemplarsField,
+		promTimeSeriesExemplarsField)
+}
+
+// remapExemplarsField rewrites the exemplars of each series of an
+// uncompressed write request body from one field to another.
+func remapExemplarsField(body []byte, from, to protowire.Number) ([]byte, error) {
+	var out []byte
+	for b := body; len(b) > 0; {
+		start := len(body) - len(b)
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if m < 0 {
+			return nil, protowire.ParseError(m)
+		}
+		field := b[:n+m]
+		b = b[n+m:]
+
+		if num == promWriteRequestTimeseriesField && typ == protowire.BytesType {
+			series, _ := protowire.ConsumeBytes(field[n:])
+			remapped, ok, err := remapSeriesExemplarsField(series, from, to)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				if out == nil {
+					out = append(make([]byte, 0, 2*len(body)), body[:start]...)
+				}
+				out = protowire.AppendTag(out, num, typ)
+				out = protowire.AppendBytes(out, remapped)
+				continue
+			}
+		}
+
+		if out != nil {
+			out = append(out, field...)
+		}
+	}
+
+	if out == nil {
+		return body, nil
+	}
+	return out, nil
+}
+
+// remapSeriesExemplarsField rewrites the exemplars of a single encoded
+// timeseries from one field to another, returning false if the series has
+// no exemplars.
+func remapSeriesExemplarsField(series []byte, from, to protowire.Number) ([]byte, bool, error) {
+	var out []byte
+	for b := series; len(b) > 0; {
+		start := len(series) - len(b)
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, false, protowire.ParseError(n)
+		}
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if m < 0 {
+			return nil, false, protowire.ParseError(m)
+		}
+		field := b[:n+m]
+		b = b[n+m:]
+
+		if num == from && typ == protowire.BytesType {
+			if out == nil {
+				out = append(make([]byte, 0, 2*len(series)), series[:start]...)
+			}
+			// The length prefixed exemplar is kept as is, only the tag changes.
+			out = protowire.AppendTag(out, to, typ)
+			out = append(out, field[n:]...)
+			continue
+		}
+
+		if out != nil {
+			out = append(out, field...)
+		}
+	}
+
+	return out, out != nil, nil
+}
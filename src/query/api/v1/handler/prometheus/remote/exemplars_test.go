@@ -0,0 +1,325 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/dbnode/generated/proto/annotation"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xclock "github.com/m3db/m3/src/x/clock"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/mock/gomock"
+	"github.com/golang/snappy"
+	promprompb "github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func newPromWriteRequestWithExemplars() *promprompb.WriteRequest {
+	return &promprompb.WriteRequest{
+		Timeseries: []promprompb.TimeSeries{
+			{
+				Labels: []promprompb.Label{
+					{Name: "__name__", Value: "with_exemplars"},
+				},
+				Samples: []promprompb.Sample{
+					{Value: 1, Timestamp: 1600000000000},
+				},
+				Exemplars: []promprompb.Exemplar{
+					{
+						Labels: []promprompb.Label{
+							{Name: "trace_id", Value: "abc"},
+						},
+						Value:     1,
+						Timestamp: 1600000000000,
+					},
+					{
+						Labels: []promprompb.Label{
+							{Name: "trace_id", Value: "def"},
+						},
+						Value:     2,
+						Timestamp: 1600000001000,
+					},
+				},
+			},
+			{
+				Labels: []promprompb.Label{
+					{Name: "__name__", Value: "without_exemplars"},
+				},
+				Samples: []promprompb.Sample{
+					{Value: 3, Timestamp: 1600000000000},
+				},
+			},
+		},
+	}
+}
+
+func TestRemapPromExemplars(t *testing.T) {
+	body, err := newPromWriteRequestWithExemplars().Marshal()
+	require.NoError(t, err)
+
+	remapped, err := remapPromExemplars(body)
+	require.NoError(t, err)
+
+	var req prompb.WriteRequest
+	require.NoError(t, proto.Unmarshal(remapped, &req))
+	require.Len(t, req.Timeseries, 2)
+
+	first := req.Timeseries[0]
+	assert.Equal(t, []prompb.Label{
+		{Name: []byte("__name__"), Value: []byte("with_exemplars")},
+	}, first.Labels)
+	assert.Equal(t, []prompb.Sample{{Value: 1, Timestamp: 1600000000000}}, first.Samples)
+	assert.Equal(t, []prompb.Exemplar{
+		{
+			Labels:    []prompb.Label{{Name: []byte("trace_id"), Value: []byte("abc")}},
+			Value:     1,
+			Timestamp: 1600000000000,
+		},
+		{
+			Labels:    []prompb.Label{{Name: []byte("trace_id"), Value: []byte("def")}},
+			Value:     2,
+			Timestamp: 1600000001000,
+		},
+	}, first.Exemplars)
+
+	second := req.Timeseries[1]
+	assert.Equal(t, []prompb.Sample{{Value: 3, Timestamp: 1600000000000}}, second.Samples)
+	assert.Empty(t, second.Exemplars)
+}
+
+func TestRemapPromExemplarsNoExemplars(t *testing.T) {
+	body, err := proto.Marshal(&prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: []byte("__name__"), Value: []byte("foo")}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1600000000000}},
+				Type:    prompb.MetricType_COUNTER,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	remapped, err := remapPromExemplars(body)
+	require.NoError(t, err)
+	assert.Equal(t, &body[0], &remapped[0], "body should not be copied")
+}
+
+func TestRestorePromExemplars(t *testing.T) {
+	expected := newPromWriteRequestWithExemplars()
+	body, err := expected.Marshal()
+	require.NoError(t, err)
+
+	remapped, err := remapPromExemplars(body)
+	require.NoError(t, err)
+	restored, err := restorePromExemplars(remapped)
+	require.NoError(t, err)
+	assert.Equal(t, body, restored)
+
+	var req promprompb.WriteRequest
+	require.NoError(t, req.Unmarshal(restored))
+	assert.Equal(t, expected.Timeseries, req.Timeseries)
+}
+
+func TestRemapPromExemplarsInvalidBody(t *testing.T) {
+	_, err := remapPromExemplars([]byte{0x0a, 0xff})
+	require.Error(t, err)
+}
+
+func TestPromWriteExemplars(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var capturedIter ingest.DownsampleAndWriteIter
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			capturedIter = iter
+			return nil
+		})
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.Exemplars = true
+	})
+
+	body, err := newPromWriteRequestWithExemplars().Marshal()
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+		bytes.NewReader(snappy.Encode(nil, body)))
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	require.True(t, capturedIter.Next())
+	assert.Equal(t, annotation.Payload{
+		SourceFormat:          annotation.SourceFormat_OPEN_METRICS,
+		OpenMetricsFamilyType: annotation.OpenMetricsFamilyType_UNKNOWN,
+		Exemplars: []*annotation.Exemplar{
+			{
+				Labels: []*annotation.ExemplarLabel{
+					{Name: []byte("trace_id"), Value: []byte("abc")},
+				},
+				Value:          1,
+				TimestampNanos: 1600000000000000000,
+			},
+			{
+				Labels: []*annotation.ExemplarLabel{
+					{Name: []byte("trace_id"), Value: []byte("def")},
+				},
+				Value:          2,
+				TimestampNanos: 1600000001000000000,
+			},
+		},
+	}, unmarshalAnnotation(t, capturedIter.Current().Annotation))
+
+	// Series without exemplars are unaffected.
+	verifyIterValueNoAnnotation(t, capturedIter)
+
+	require.False(t, capturedIter.Next())
+	require.NoError(t, capturedIter.Error())
+}
+
+func TestPromWriteExemplarsDisabled(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var capturedIter ingest.DownsampleAndWriteIter
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			capturedIter = iter
+			return nil
+		})
+
+	opts := makeOptions(mockDownsamplerAndWriter).SetStoreMetricsType(false)
+
+	executeWriteRequest(t, opts, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: []byte("__name__"), Value: []byte("foo")}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1600000000000}},
+				Exemplars: []prompb.Exemplar{
+					{Value: 1, Timestamp: 1600000000000},
+				},
+			},
+		},
+	})
+
+	verifyIterValueNoAnnotation(t, capturedIter)
+	require.False(t, capturedIter.Next())
+}
+
+func TestPromWriteForwardExemplars(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	scope := tally.NewTestScope("", map[string]string{"test": "forward-exemplars-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.Exemplars = true
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: "http://chunked", NoRetry: true, ChunkSeries: 1},
+			{
+				URL:     "http://shadow",
+				NoRetry: true,
+				Shadow:  &handleroptions.PromWriteHandlerForwardTargetShadowOptions{Percent: 0},
+			},
+		}
+	})
+
+	// Forwarded bodies re-encoded by the handler are decoded as Prometheus
+	// would decode them.
+	var (
+		lock      sync.Mutex
+		forwarded = make(map[string][]promprompb.TimeSeries)
+	)
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			compressed, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			body, err := snappy.Decode(nil, compressed)
+			require.NoError(t, err)
+			var req promprompb.WriteRequest
+			require.NoError(t, req.Unmarshal(body))
+
+			lock.Lock()
+			forwarded[r.URL.String()] = append(forwarded[r.URL.String()], req.Timeseries...)
+			lock.Unlock()
+			return newOKResponse(r), nil
+		}),
+	}
+
+	expected := newPromWriteRequestWithExemplars()
+	body, err := expected.Marshal()
+	require.NoError(t, err)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+		bytes.NewReader(snappy.Encode(nil, body)))
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	require.True(t, xclock.WaitUntil(func() bool {
+		c, ok := scope.Snapshot().Counters()["forward.success+handler=remote-write,test=forward-exemplars-test"]
+		return ok && c.Value() == 2
+	}, 10*time.Second))
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, url := range []string{"http://chunked", "http://shadow"} {
+		series := forwarded[url]
+		require.Len(t, series, 2, url)
+		for i := range series {
+			// NB: Prometheus decodes missing exemplars as nil.
+			if len(expected.Timeseries[i].Exemplars) == 0 {
+				require.Empty(t, series[i].Exemplars, url)
+				continue
+			}
+			require.Equal(t, expected.Timeseries[i].Exemplars, series[i].Exemplars, url)
+		}
+	}
+}
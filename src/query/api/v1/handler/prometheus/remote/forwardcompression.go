@@ -0,0 +1,175 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	minForwardZstdLevel = 1
+	maxForwardZstdLevel = 22
+
+	// identityContentEncoding is the content encoding of bodies forwarded
+	// uncompressed.
+	identityContentEncoding = "identity"
+)
+
+// forwardCompressor recompresses the snappy encoded bodies forwarded to a
+// target.
+type forwardCompressor struct {
+	encoding  handleroptions.PromWriteHandlerForwardCompressionEncoding
+	gzipLevel int
+	zstd      *zstd.Encoder
+}
+
+// forwardCompressors is the compressors of the forwarding targets whose
+// bodies are recompressed, keyed by the target compression options which are
+// shared by every copy of the target.
+type forwardCompressors map[*handleroptions.PromWriteHandlerForwardCompressionOptions]forwardCompressor
+
+func newForwardCompressors(
+	targets []handleroptions.PromWriteHandlerForwardTargetOptions,
+) (forwardCompressors, error) {
+	var compressors forwardCompressors
+	for _, target := range targets {
+		opts := target.Compression
+		if opts == nil {
+			continue
+		}
+		if target.Uncompressed {
+			return nil, fmt.Errorf("forwarding target cannot be both uncompressed "+
+				"and recompressed: url=%s", target.URL)
+		}
+
+		compressor := forwardCompressor{encoding: opts.Encoding}
+		switch opts.Encoding {
+		case "", handleroptions.PromWriteHandlerForwardCompressionEncodingSnappy:
+			if opts.Level != 0 {
+				return nil, fmt.Errorf("forwarding snappy compression has no levels: %d",
+					opts.Level)
+			}
+			// NB: Bodies are already snappy encoded.
+			continue
+		case handleroptions.PromWriteHandlerForwardCompressionEncodingGzip:
+			compressor.gzipLevel = gzip.DefaultCompression
+			if opts.Level != 0 {
+				if opts.Level < gzip.BestSpeed || opts.Level > gzip.BestCompression {
+					return nil, fmt.Errorf("forwarding gzip compression level out of "+
+						"range [%d,%d]: %d", gzip.BestSpeed, gzip.BestCompression, opts.Level)
+				}
+				compressor.gzipLevel = opts.Level
+			}
+		case handleroptions.PromWriteHandlerForwardCompressionEncodingZstd:
+			level := zstd.SpeedDefault
+			if opts.Level != 0 {
+				if opts.Level < minForwardZstdLevel || opts.Level > maxForwardZstdLevel {
+					return nil, fmt.Errorf("forwarding zstd compression level out of "+
+						"range [%d,%d]: %d", minForwardZstdLevel, maxForwardZstdLevel, opts.Level)
+				}
+				level = zstd.EncoderLevelFromZstd(opts.Level)
+			}
+			encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+			if err != nil {
+				return nil, err
+			}
+			compressor.zstd = encoder
+		default:
+			return nil, fmt.Errorf("unknown forwarding compression encoding: %s",
+				opts.Encoding)
+		}
+
+		if compressors == nil {
+			compressors = make(forwardCompressors)
+		}
+		compressors[opts] = compressor
+	}
+	return compressors, nil
+}
+
+// compress returns the body forwarded to the target recompressed along with
+// its content encoding, or the body as is with no encoding if the target
+// bodies are not recompressed.
+func (c forwardCompressors) compress(
+	body io.Reader,
+	target handleroptions.PromWriteHandlerForwardTargetOptions,
+) (io.Reader, string, error) {
+	compressor, ok := c[target.Compression]
+	if !ok {
+		return body, "", nil
+	}
+
+	decoded, err := decodeForwardBody(body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var recompressed []byte
+	switch compressor.encoding {
+	case handleroptions.PromWriteHandlerForwardCompressionEncodingGzip:
+		var buffer bytes.Buffer
+		w, err := gzip.NewWriterLevel(&buffer, compressor.gzipLevel)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := w.Write(decoded); err != nil {
+			return nil, "", err
+		}
+		if err := w.Close(); err != nil {
+			return nil, "", err
+		}
+		recompressed = buffer.Bytes()
+	case handleroptions.PromWriteHandlerForwardCompressionEncodingZstd:
+		recompressed = compressor.zstd.EncodeAll(decoded, nil)
+	}
+	return bytes.NewReader(recompressed), string(compressor.encoding), nil
+}
+
+// decompressForwardBody returns the body forwarded to a target that is forwarded
+// uncompressed, i.e. the raw protobuf, along with its content encoding.
+func decompressForwardBody(body io.Reader) (io.Reader, string, error) {
+	decoded, err := decodeForwardBody(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return bytes.NewReader(decoded), identityContentEncoding, nil
+}
+
+func decodeForwardBody(body io.Reader) ([]byte, error) {
+	compressed, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress forwarding request: %w", err)
+	}
+	return decoded, nil
+}
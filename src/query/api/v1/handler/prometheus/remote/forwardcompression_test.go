@@ -0,0 +1,240 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+// newForwardCompressionTestPayload returns a fixed payload compressible
+// enough for compression levels to make a difference to its size.
+func newForwardCompressionTestPayload(t *testing.T) []byte {
+	var (
+		rnd = rand.New(rand.NewSource(1)) //nolint:gosec
+		req prompb.WriteRequest
+	)
+	for i := 0; i < 2000; i++ {
+		req.Timeseries = append(req.Timeseries, prompb.TimeSeries{
+			Labels: testLabels(
+				"__name__", fmt.Sprintf("metric_%d", rnd.Intn(20)),
+				"instance", fmt.Sprintf("host-%d.example.com:%d", rnd.Intn(50), 9000+rnd.Intn(10)),
+				"job", "job"),
+			Samples: []prompb.Sample{{Timestamp: int64(1600000000000 + i), Value: float64(rnd.Intn(100))}},
+		})
+	}
+	payload, err := proto.Marshal(&req)
+	require.NoError(t, err)
+	return payload
+}
+
+func TestPromWriteForwardCompression(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	type forwardedRequest struct {
+		encoding string
+		body     []byte
+	}
+	received := make(map[string]forwardedRequest)
+	targetSvr := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			received[r.URL.Path] = forwardedRequest{
+				encoding: r.Header.Get("Content-Encoding"),
+				body:     body,
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer targetSvr.Close()
+
+	compression := func(
+		encoding handleroptions.PromWriteHandlerForwardCompressionEncoding,
+		level int,
+	) *handleroptions.PromWriteHandlerForwardCompressionOptions {
+		return &handleroptions.PromWriteHandlerForwardCompressionOptions{
+			Encoding: encoding,
+			Level:    level,
+		}
+	}
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	h := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: targetSvr.URL + "/default"},
+			{URL: targetSvr.URL + "/snappy", Compression: compression("snappy", 0)},
+			{URL: targetSvr.URL + "/gzip-1", Compression: compression("gzip", 1)},
+			{URL: targetSvr.URL + "/gzip-9", Compression: compression("gzip", 9)},
+			{URL: targetSvr.URL + "/zstd-1", Compression: compression("zstd", 1)},
+			{URL: targetSvr.URL + "/zstd-19", Compression: compression("zstd", 19)},
+		}
+	})
+
+	payload := newForwardCompressionTestPayload(t)
+	for _, target := range h.forwarding.Targets {
+		body := bytes.NewReader(snappy.Encode(nil, payload))
+		require.NoError(t, h.forwardBody(context.Background(), body, nil, target))
+	}
+	require.Len(t, received, len(h.forwarding.Targets))
+
+	zstdDecoder, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer zstdDecoder.Close()
+
+	for path, req := range received {
+		var (
+			body []byte
+			err  error
+		)
+		switch req.encoding {
+		case "snappy":
+			body, err = snappy.Decode(nil, req.body)
+		case "gzip":
+			var r *gzip.Reader
+			r, err = gzip.NewReader(bytes.NewReader(req.body))
+			require.NoError(t, err)
+			body, err = ioutil.ReadAll(r)
+		case "zstd":
+			body, err = zstdDecoder.DecodeAll(req.body, nil)
+		default:
+			require.FailNow(t, "unexpected encoding", req.encoding)
+		}
+		require.NoError(t, err, path)
+		require.Equal(t, payload, body, path)
+	}
+
+	require.Equal(t, "snappy", received["/default"].encoding)
+	require.Equal(t, received["/default"], received["/snappy"])
+	require.Equal(t, "gzip", received["/gzip-1"].encoding)
+	require.Equal(t, "zstd", received["/zstd-1"].encoding)
+	// Higher levels compress the fixed payload smaller.
+	require.True(t, len(received["/gzip-9"].body) < len(received["/gzip-1"].body))
+	require.True(t, len(received["/zstd-19"].body) < len(received["/zstd-1"].body))
+}
+
+func TestPromWriteForwardCompressionInvalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression handleroptions.PromWriteHandlerForwardCompressionOptions
+	}{
+		{
+			name:        "unknown encoding",
+			compression: handleroptions.PromWriteHandlerForwardCompressionOptions{Encoding: "lz4"},
+		},
+		{
+			name:        "snappy level",
+			compression: handleroptions.PromWriteHandlerForwardCompressionOptions{Level: 1},
+		},
+		{
+			name: "gzip level",
+			compression: handleroptions.PromWriteHandlerForwardCompressionOptions{
+				Encoding: "gzip",
+				Level:    10,
+			},
+		},
+		{
+			name: "zstd level",
+			compression: handleroptions.PromWriteHandlerForwardCompressionOptions{
+				Encoding: "zstd",
+				Level:    23,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compression := tt.compression
+			_, err := newForwardCompressors([]handleroptions.PromWriteHandlerForwardTargetOptions{
+				{Compression: &compression},
+			})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestPromWriteForwardUncompressed(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		encoding string
+		body     []byte
+	)
+	targetSvr := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var err error
+			body, err = ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			encoding = r.Header.Get("Content-Encoding")
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer targetSvr.Close()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	h := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: targetSvr.URL, Uncompressed: true},
+		}
+	})
+
+	payload := newForwardCompressionTestPayload(t)
+	require.NoError(t, h.forwardBody(context.Background(),
+		bytes.NewReader(snappy.Encode(nil, payload)), nil, h.forwarding.Targets[0]))
+
+	require.Equal(t, "identity", encoding)
+	require.Equal(t, payload, body)
+
+	var req prompb.WriteRequest
+	require.NoError(t, proto.Unmarshal(body, &req))
+	require.Len(t, req.Timeseries, 2000)
+}
+
+func TestPromWriteForwardUncompressedWithCompression(t *testing.T) {
+	_, err := newForwardCompressors([]handleroptions.PromWriteHandlerForwardTargetOptions{
+		{
+			URL:          "http://target",
+			Uncompressed: true,
+			Compression: &handleroptions.PromWriteHandlerForwardCompressionOptions{
+				Encoding: "gzip",
+			},
+		},
+	})
+	require.EqualError(t, err, "forwarding target cannot be both uncompressed "+
+		"and recompressed: url=http://target")
+}
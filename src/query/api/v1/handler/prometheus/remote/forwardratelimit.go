@@ -0,0 +1,151 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/x/clock"
+)
+
+const defaultForwardRateLimitMaxDelay = 10 * time.Second
+
+var errForwardRateLimited = errors.New("forward dropped, target rate limit exceeded")
+
+// forwardRateLimiter is a token bucket limiting the rate of requests
+// forwarded to a target. In queue mode the bucket goes into debt for
+// requests that are delayed, so that later requests queue behind them.
+type forwardRateLimiter struct {
+	sync.Mutex
+
+	rate     float64
+	burst    float64
+	queue    bool
+	maxDelay time.Duration
+	nowFn    clock.NowFn
+
+	tokens float64
+	last   time.Time
+}
+
+func newForwardRateLimiter(
+	opts handleroptions.PromWriteHandlerForwardRateLimitOptions,
+	nowFn clock.NowFn,
+) (*forwardRateLimiter, error) {
+	if opts.RequestsPerSecond <= 0 {
+		return nil, fmt.Errorf("forward rate limit must be positive: requestsPerSecond=%v",
+			opts.RequestsPerSecond)
+	}
+
+	switch opts.Mode {
+	case "", handleroptions.PromWriteHandlerForwardRateLimitModeDrop,
+		handleroptions.PromWriteHandlerForwardRateLimitModeQueue:
+	default:
+		return nil, fmt.Errorf("unknown forward rate limit mode: %s", opts.Mode)
+	}
+
+	burst := 1
+	if opts.Burst > 0 {
+		burst = opts.Burst
+	}
+	maxDelay := defaultForwardRateLimitMaxDelay
+	if opts.MaxDelay > 0 {
+		maxDelay = opts.MaxDelay
+	}
+
+	return &forwardRateLimiter{
+		rate:     opts.RequestsPerSecond,
+		burst:    float64(burst),
+		queue:    opts.Mode == handleroptions.PromWriteHandlerForwardRateLimitModeQueue,
+		maxDelay: maxDelay,
+		nowFn:    nowFn,
+		tokens:   float64(burst),
+		last:     nowFn(),
+	}, nil
+}
+
+// reserve takes a token for a request, returning how long to delay the
+// request before forwarding it, or false if it is to be dropped.
+func (l *forwardRateLimiter) reserve() (time.Duration, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.nowFn()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+elapsed.Seconds()*l.rate)
+		l.last = now
+	}
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+	if !l.queue {
+		return 0, false
+	}
+
+	delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	if delay > l.maxDelay {
+		return 0, false
+	}
+	l.tokens--
+	return delay, true
+}
+
+// forwardRateLimiters are the rate limiters indexed by target, nil for
+// targets that are not rate limited.
+type forwardRateLimiters []*forwardRateLimiter
+
+func newForwardRateLimiters(
+	targets []handleroptions.PromWriteHandlerForwardTargetOptions,
+	nowFn clock.NowFn,
+) (forwardRateLimiters, error) {
+	var limiters forwardRateLimiters
+	for i, target := range targets {
+		if target.RateLimit == nil {
+			continue
+		}
+		limiter, err := newForwardRateLimiter(*target.RateLimit, nowFn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid forwarding target rate limit: url=%s: %w",
+				target.URL, err)
+		}
+		if limiters == nil {
+			limiters = make(forwardRateLimiters, len(targets))
+		}
+		limiters[i] = limiter
+	}
+	return limiters, nil
+}
+
+// get returns the rate limiter of the target, or nil if it is not rate
+// limited.
+func (l forwardRateLimiters) get(targetIdx int) *forwardRateLimiter {
+	if targetIdx < len(l) {
+		return l[targetIdx]
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestForwardRateLimiterDrop(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter, err := newForwardRateLimiter(handleroptions.PromWriteHandlerForwardRateLimitOptions{
+		RequestsPerSecond: 2,
+		Burst:             2,
+	}, func() time.Time { return now })
+	require.NoError(t, err)
+
+	reserve := func() bool {
+		delay, ok := limiter.reserve()
+		require.Equal(t, time.Duration(0), delay)
+		return ok
+	}
+
+	// The burst is available at once, then a token every 500ms.
+	require.True(t, reserve())
+	require.True(t, reserve())
+	require.False(t, reserve())
+
+	now = now.Add(250 * time.Millisecond)
+	require.False(t, reserve())
+
+	now = now.Add(250 * time.Millisecond)
+	require.True(t, reserve())
+	require.False(t, reserve())
+
+	// Tokens accrue up to the burst while idle.
+	now = now.Add(time.Minute)
+	require.True(t, reserve())
+	require.True(t, reserve())
+	require.False(t, reserve())
+}
+
+func TestForwardRateLimiterQueue(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter, err := newForwardRateLimiter(handleroptions.PromWriteHandlerForwardRateLimitOptions{
+		RequestsPerSecond: 10,
+		Mode:              handleroptions.PromWriteHandlerForwardRateLimitModeQueue,
+		MaxDelay:          300 * time.Millisecond,
+	}, func() time.Time { return now })
+	require.NoError(t, err)
+
+	// Requests queue behind each other at the configured rate.
+	var delays []time.Duration
+	for i := 0; i < 4; i++ {
+		delay, ok := limiter.reserve()
+		require.True(t, ok)
+		delays = append(delays, delay)
+	}
+	require.Equal(t, []time.Duration{
+		0, 100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond,
+	}, delays)
+
+	// Requests delayed beyond the max delay are dropped.
+	_, ok := limiter.reserve()
+	require.False(t, ok)
+
+	now = now.Add(100 * time.Millisecond)
+	delay, ok := limiter.reserve()
+	require.True(t, ok)
+	require.Equal(t, 300*time.Millisecond, delay)
+}
+
+func TestNewForwardRateLimitersInvalid(t *testing.T) {
+	_, err := newForwardRateLimiters([]handleroptions.PromWriteHandlerForwardTargetOptions{
+		{URL: "http://target", RateLimit: &handleroptions.PromWriteHandlerForwardRateLimitOptions{}},
+	}, time.Now)
+	require.EqualError(t, err, "invalid forwarding target rate limit: url=http://target: "+
+		"forward rate limit must be positive: requestsPerSecond=0")
+
+	_, err = newForwardRateLimiters([]handleroptions.PromWriteHandlerForwardTargetOptions{
+		{URL: "http://target", RateLimit: &handleroptions.PromWriteHandlerForwardRateLimitOptions{
+			RequestsPerSecond: 1,
+			Mode:              "block",
+		}},
+	}, time.Now)
+	require.EqualError(t, err, "invalid forwarding target rate limit: url=http://target: "+
+		"unknown forward rate limit mode: block")
+}
+
+func TestPromWriteForwardRateLimit(t *testing.T) {
+	tests := []struct {
+		name              string
+		rateLimit         handleroptions.PromWriteHandlerForwardRateLimitOptions
+		expectedForwarded int
+		expectedLimited   int64
+	}{
+		{
+			name: "drop",
+			rateLimit: handleroptions.PromWriteHandlerForwardRateLimitOptions{
+				RequestsPerSecond: 0.001,
+			},
+			expectedForwarded: 1,
+			expectedLimited:   4,
+		},
+		{
+			name: "queue",
+			rateLimit: handleroptions.PromWriteHandlerForwardRateLimitOptions{
+				RequestsPerSecond: 20,
+				Mode:              handleroptions.PromWriteHandlerForwardRateLimitModeQueue,
+			},
+			expectedForwarded: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			const numRequests = 5
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.
+				EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				Times(numRequests)
+
+			scope := tally.NewTestScope("", map[string]string{"test": "forward-rate-limit-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			rateLimit := tt.rateLimit
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+					{URL: "http://limited", NoRetry: true, RateLimit: &rateLimit},
+				}
+			})
+
+			var (
+				lock      sync.Mutex
+				forwarded []time.Time
+			)
+			handler.forwardHTTPClient = &http.Client{
+				Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+					lock.Lock()
+					forwarded = append(forwarded, time.Now())
+					lock.Unlock()
+					return newOKResponse(r), nil
+				}),
+			}
+
+			for i := 0; i < numRequests; i++ {
+				promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+				req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+				writer := httptest.NewRecorder()
+				handler.ServeHTTP(writer, req)
+				require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+			}
+
+			numForwarded := func() int {
+				lock.Lock()
+				defer lock.Unlock()
+				return len(forwarded)
+			}
+			for deadline := time.Now().Add(5 * time.Second); numForwarded() < tt.expectedForwarded; {
+				require.True(t, time.Now().Before(deadline), "timed out waiting for forwards")
+				time.Sleep(10 * time.Millisecond)
+			}
+			// Allow any forwards that should not happen to be observed.
+			time.Sleep(50 * time.Millisecond)
+			require.Equal(t, tt.expectedForwarded, numForwarded())
+
+			if tt.rateLimit.Mode == handleroptions.PromWriteHandlerForwardRateLimitModeQueue {
+				// Forwards are paced at 20 per second, i.e. 50ms apart.
+				lock.Lock()
+				first, last := forwarded[0], forwarded[0]
+				for _, at := range forwarded {
+					if at.Before(first) {
+						first = at
+					}
+					if at.After(last) {
+						last = at
+					}
+				}
+				lock.Unlock()
+				require.True(t, last.Sub(first) >= 150*time.Millisecond,
+					"forwards not paced: %v", last.Sub(first))
+			}
+
+			limited := scope.Snapshot().Counters()["forward.rate-limited+handler=remote-write,test=forward-rate-limit-test"]
+			require.NotNil(t, limited)
+			require.Equal(t, tt.expectedLimited, limited.Value())
+		})
+	}
+}
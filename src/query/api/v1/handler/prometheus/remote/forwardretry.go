@@ -0,0 +1,86 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/x/retry"
+
+	"github.com/uber-go/tally"
+)
+
+// forwardRetriers are the retriers of the targets overriding the forwarding
+// retry configuration indexed by target, nil for targets using the shared
+// forwarding retrier.
+type forwardRetriers []retry.Retrier
+
+func newForwardRetriers(
+	targets []handleroptions.PromWriteHandlerForwardTargetOptions,
+	base retry.Configuration,
+	scope tally.Scope,
+) forwardRetriers {
+	var retriers forwardRetriers
+	for i, target := range targets {
+		if target.Retry == nil {
+			continue
+		}
+		if retriers == nil {
+			retriers = make(forwardRetriers, len(targets))
+		}
+		config := mergeForwardRetryConfig(base, *target.Retry)
+		retriers[i] = retry.NewRetrier(config.NewOptions(scope))
+	}
+	return retriers
+}
+
+// mergeForwardRetryConfig returns the base configuration with the fields set
+// by the override replaced, so that a target only needs to set the fields it
+// overrides.
+func mergeForwardRetryConfig(base, override retry.Configuration) retry.Configuration {
+	merged := base
+	if override.InitialBackoff != 0 {
+		merged.InitialBackoff = override.InitialBackoff
+	}
+	if override.BackoffFactor != 0 {
+		merged.BackoffFactor = override.BackoffFactor
+	}
+	if override.MaxBackoff != 0 {
+		merged.MaxBackoff = override.MaxBackoff
+	}
+	if override.MaxRetries != 0 {
+		merged.MaxRetries = override.MaxRetries
+	}
+	if override.Forever != nil {
+		merged.Forever = override.Forever
+	}
+	if override.Jitter != nil {
+		merged.Jitter = override.Jitter
+	}
+	return merged
+}
+
+// forwardRetrierFor returns the retrier of the target.
+func (h *PromWriteHandler) forwardRetrierFor(targetIdx int) retry.Retrier {
+	if targetIdx < len(h.forwardRetriers) && h.forwardRetriers[targetIdx] != nil {
+		return h.forwardRetriers[targetIdx]
+	}
+	return h.forwardRetrier
+}
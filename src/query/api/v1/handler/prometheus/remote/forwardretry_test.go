@@ -0,0 +1,130 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/x/retry"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeForwardRetryConfig(t *testing.T) {
+	forever := true
+	base := retry.Configuration{
+		InitialBackoff: time.Second,
+		BackoffFactor:  2,
+		MaxBackoff:     time.Minute,
+		MaxRetries:     1,
+	}
+
+	require.Equal(t, base, mergeForwardRetryConfig(base, retry.Configuration{}))
+	require.Equal(t, retry.Configuration{
+		InitialBackoff: time.Millisecond,
+		BackoffFactor:  2,
+		MaxBackoff:     time.Minute,
+		MaxRetries:     5,
+		Forever:        &forever,
+	}, mergeForwardRetryConfig(base, retry.Configuration{
+		InitialBackoff: time.Millisecond,
+		MaxRetries:     5,
+		Forever:        &forever,
+	}))
+}
+
+func TestPromWriteForwardPerTargetRetry(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	jitter := false
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Retry = &retry.Configuration{
+			InitialBackoff: time.Millisecond,
+			MaxRetries:     1,
+			Jitter:         &jitter,
+		}
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: "http://near"},
+			{URL: "http://far", Retry: &retry.Configuration{MaxRetries: 4}},
+			{URL: "http://none", NoRetry: true, Retry: &retry.Configuration{MaxRetries: 4}},
+		}
+	})
+
+	var (
+		mu       sync.Mutex
+		attempts = make(map[string]int)
+		wg       sync.WaitGroup
+	)
+	// Every target fails, the last attempt of each target is expected after
+	// its retries are exhausted.
+	wg.Add(2 + 5 + 1)
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			mu.Lock()
+			attempts[r.URL.Host]++
+			mu.Unlock()
+			wg.Done()
+			return &http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Body:       http.NoBody,
+				Request:    r,
+			}, nil
+		}),
+	}
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	doneCh := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for fwd attempts")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, map[string]int{"near": 2, "far": 5, "none": 1}, attempts)
+}
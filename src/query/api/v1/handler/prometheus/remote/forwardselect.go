@@ -0,0 +1,105 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/x/headers"
+)
+
+// forwardSelectableTargets are the indexes of the targets that requests may
+// select with the forward targets header, keyed by target name.
+type forwardSelectableTargets map[string]int
+
+func newForwardSelectableTargets(
+	forwarding handleroptions.PromWriteHandlerForwardingOptions,
+) (forwardSelectableTargets, error) {
+	byName := make(map[string]int, len(forwarding.Targets))
+	for i, target := range forwarding.Targets {
+		if target.Name == "" {
+			continue
+		}
+		if _, ok := byName[target.Name]; ok {
+			return nil, fmt.Errorf("duplicate forwarding target name: %s", target.Name)
+		}
+		byName[target.Name] = i
+	}
+
+	var selectable forwardSelectableTargets
+	for _, name := range forwarding.SelectableTargets {
+		i, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown selectable forwarding target: %s", name)
+		}
+		if selectable == nil {
+			selectable = make(forwardSelectableTargets, len(forwarding.SelectableTargets))
+		}
+		selectable[name] = i
+	}
+
+	for _, target := range forwarding.Targets {
+		if !target.SelectedOnly {
+			continue
+		}
+		if _, ok := selectable[target.Name]; !ok || target.Name == "" {
+			return nil, fmt.Errorf("selected only forwarding target is not "+
+				"selectable: url=%s, name=%s", target.URL, target.Name)
+		}
+	}
+
+	return selectable, nil
+}
+
+// parse returns the indexes of the targets selected by the forward targets
+// header, or nil if the header is not set. Naming a target that is not
+// selectable is an error.
+func (s forwardSelectableTargets) parse(header http.Header) ([]int, error) {
+	v := strings.TrimSpace(header.Get(headers.ForwardTargetsHeader))
+	if v == "" {
+		return nil, nil
+	}
+
+	var selected []int
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		i, ok := s[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown forward target: %s", name)
+		}
+		if !containsInt(selected, i) {
+			selected = append(selected, i)
+		}
+	}
+	return selected, nil
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/x/headers"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func newForwardSelectTestHandler(
+	t *testing.T,
+	ctrl *gomock.Controller,
+	forwardedCh chan<- string,
+) http.Handler {
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes()
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: "http://default", NoRetry: true, Name: "default"},
+			{URL: "http://canary", NoRetry: true, Name: "canary", SelectedOnly: true},
+			{URL: "http://internal", NoRetry: true, Name: "internal"},
+		}
+		cfg.WriteForwarding.PromRemoteWrite.SelectableTargets = []string{"default", "canary"}
+	})
+
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			// The selection is not forwarded to the target.
+			require.Empty(t, r.Header.Get(headers.ForwardTargetsHeader))
+			forwardedCh <- r.URL.Host
+			return newOKResponse(r), nil
+		}),
+	}
+	return handler
+}
+
+func receiveForwarded(t *testing.T, forwardedCh <-chan string, n int) []string {
+	var forwarded []string
+	for i := 0; i < n; i++ {
+		select {
+		case host := <-forwardedCh:
+			forwarded = append(forwarded, host)
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "timeout waiting for fwd request")
+		}
+	}
+	sort.Strings(forwarded)
+	return forwarded
+}
+
+func TestPromWriteForwardSelectTargets(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	forwardedCh := make(chan string, 10)
+	handler := newForwardSelectTestHandler(t, ctrl, forwardedCh)
+
+	write := func(selection string) int {
+		promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		if selection != "" {
+			req.Header.Set(headers.ForwardTargetsHeader, selection)
+		}
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		return writer.Result().StatusCode
+	}
+
+	// Without a selection the selected only target is not forwarded to.
+	require.Equal(t, http.StatusOK, write(""))
+	require.Equal(t, []string{"default", "internal"}, receiveForwarded(t, forwardedCh, 2))
+	require.Len(t, forwardedCh, 0)
+
+	// A selection replaces the configured targets.
+	require.Equal(t, http.StatusOK, write("canary"))
+	require.Equal(t, []string{"canary"}, receiveForwarded(t, forwardedCh, 1))
+	require.Len(t, forwardedCh, 0)
+
+	require.Equal(t, http.StatusOK, write("canary, default,canary"))
+	require.Equal(t, []string{"canary", "default"}, receiveForwarded(t, forwardedCh, 2))
+	require.Len(t, forwardedCh, 0)
+}
+
+func TestPromWriteForwardSelectTargetsUnknown(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	forwardedCh := make(chan string, 10)
+	handler := newForwardSelectTestHandler(t, ctrl, forwardedCh)
+
+	// Unknown names and named targets that are not selectable are rejected.
+	for _, selection := range []string{"unknown", "internal", "default,unknown", "http://evil"} {
+		promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		req.Header.Set(headers.ForwardTargetsHeader, selection)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode, selection)
+	}
+	require.Len(t, forwardedCh, 0)
+}
+
+func TestNewForwardSelectableTargetsInvalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		forwarding  handleroptions.PromWriteHandlerForwardingOptions
+		expectedErr string
+	}{
+		{
+			name: "duplicate name",
+			forwarding: handleroptions.PromWriteHandlerForwardingOptions{
+				Targets: []handleroptions.PromWriteHandlerForwardTargetOptions{
+					{URL: "http://a", Name: "a"},
+					{URL: "http://b", Name: "a"},
+				},
+			},
+			expectedErr: "duplicate forwarding target name: a",
+		},
+		{
+			name: "unknown selectable",
+			forwarding: handleroptions.PromWriteHandlerForwardingOptions{
+				Targets: []handleroptions.PromWriteHandlerForwardTargetOptions{
+					{URL: "http://a", Name: "a"},
+				},
+				SelectableTargets: []string{"b"},
+			},
+			expectedErr: "unknown selectable forwarding target: b",
+		},
+		{
+			name: "selected only not selectable",
+			forwarding: handleroptions.PromWriteHandlerForwardingOptions{
+				Targets: []handleroptions.PromWriteHandlerForwardTargetOptions{
+					{URL: "http://a", Name: "a", SelectedOnly: true},
+				},
+			},
+			expectedErr: "selected only forwarding target is not selectable: url=http://a, name=a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newForwardSelectableTargets(tt.forwarding)
+			require.EqualError(t, err, tt.expectedErr)
+		})
+	}
+}
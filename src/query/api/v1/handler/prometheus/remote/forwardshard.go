@@ -0,0 +1,120 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// forwardShard is the shard of a target within its shard group.
+type forwardShard struct {
+	index int
+	count int
+}
+
+// owns returns whether the series with the labels belongs to the shard.
+func (s *forwardShard) owns(labels []prompb.Label) bool {
+	return forwardShardIndex(labels, s.count) == s.index
+}
+
+// forwardShardIndex returns the shard of the series with the labels among
+// count shards, regardless of the order of the labels.
+func forwardShardIndex(labels []prompb.Label, count int) int {
+	// NB: Hash a copy of the labels since building the pseudo ID may sort
+	// them, the forwarded labels keep the order sent by the client.
+	sorted := append([]prompb.Label(nil), labels...)
+	hash := xxhash.Sum64(buildPseudoIDWithLabelsLikelySorted(sorted, nil))
+	return int(hash % uint64(count))
+}
+
+// forwardShards are the shards of the targets, indexed by target and nil
+// for targets that are not in a shard group.
+type forwardShards []*forwardShard
+
+func newForwardShards(
+	targets []handleroptions.PromWriteHandlerForwardTargetOptions,
+) (forwardShards, error) {
+	groups := make(map[string][]int)
+	for i, target := range targets {
+		if target.ShardGroup == "" {
+			continue
+		}
+		if target.Fallback {
+			return nil, fmt.Errorf("sharded forwarding target cannot be a fallback: "+
+				"url=%s, shardGroup=%s", target.URL, target.ShardGroup)
+		}
+		groups[target.ShardGroup] = append(groups[target.ShardGroup], i)
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	shards := make(forwardShards, len(targets))
+	for _, group := range groups {
+		for shard, i := range group {
+			shards[i] = &forwardShard{index: shard, count: len(group)}
+		}
+	}
+	return shards, nil
+}
+
+func (s forwardShards) get(targetIdx int) *forwardShard {
+	if targetIdx >= len(s) {
+		return nil
+	}
+	return s[targetIdx]
+}
+
+// buildForwardShardRequestBody builds the body forwarded to a target of a
+// shard group, keeping only the series that belong to the target's shard and
+// returning the number of series kept.
+func (h *PromWriteHandler) buildForwardShardRequestBody(
+	body io.Reader,
+	shard *forwardShard,
+) ([]byte, int, error) {
+	req, err := h.decodeForwardRequestBody(body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	kept := req.Timeseries[:0]
+	for _, series := range req.Timeseries {
+		if shard.owns(series.Labels) {
+			kept = append(kept, series)
+		}
+	}
+	req.Timeseries = kept
+	if len(kept) == 0 {
+		return nil, 0, nil
+	}
+
+	buffer, err := encodeForwardRequestBody(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	return buffer, len(kept), nil
+}
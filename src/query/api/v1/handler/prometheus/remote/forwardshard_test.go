@@ -0,0 +1,176 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardShardIndexDeterministic(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		labels := testLabels("__name__", "up", "instance", fmt.Sprintf("host-%d", i))
+		reversed := testLabels("instance", fmt.Sprintf("host-%d", i), "__name__", "up")
+
+		shard := forwardShardIndex(labels, 3)
+		require.True(t, shard >= 0 && shard < 3)
+		require.Equal(t, shard, forwardShardIndex(labels, 3))
+		require.Equal(t, shard, forwardShardIndex(reversed, 3))
+		// Hashing must not reorder the labels forwarded.
+		require.Equal(t, "instance", string(reversed[0].Name))
+	}
+}
+
+func TestNewForwardShards(t *testing.T) {
+	shards, err := newForwardShards([]handleroptions.PromWriteHandlerForwardTargetOptions{
+		{URL: "http://a", ShardGroup: "store"},
+		{URL: "http://full"},
+		{URL: "http://b", ShardGroup: "store"},
+		{URL: "http://other", ShardGroup: "other"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, &forwardShard{index: 0, count: 2}, shards.get(0))
+	require.Nil(t, shards.get(1))
+	require.Equal(t, &forwardShard{index: 1, count: 2}, shards.get(2))
+	require.Equal(t, &forwardShard{index: 0, count: 1}, shards.get(3))
+
+	shards, err = newForwardShards([]handleroptions.PromWriteHandlerForwardTargetOptions{
+		{URL: "http://a"},
+	})
+	require.NoError(t, err)
+	require.Nil(t, shards.get(0))
+
+	_, err = newForwardShards([]handleroptions.PromWriteHandlerForwardTargetOptions{
+		{URL: "http://a", ShardGroup: "store", Fallback: true},
+	})
+	require.Error(t, err)
+}
+
+func TestPromWriteForwardShardGroup(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	const numSeries = 60
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: "http://shard-0", NoRetry: true, ShardGroup: "store"},
+			{URL: "http://shard-1", NoRetry: true, ShardGroup: "store"},
+			{URL: "http://shard-2", NoRetry: true, ShardGroup: "store"},
+			{URL: "http://full", NoRetry: true},
+		}
+	})
+
+	var (
+		lock      sync.Mutex
+		forwarded = make(map[string][]string)
+		total     int
+	)
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			req, err := handler.decodeForwardRequestBody(r.Body)
+			require.NoError(t, err)
+
+			lock.Lock()
+			defer lock.Unlock()
+			for _, series := range req.Timeseries {
+				instance := string(series.Labels[1].Value)
+				forwarded[r.URL.Host] = append(forwarded[r.URL.Host], instance)
+				total++
+			}
+			return newOKResponse(r), nil
+		}),
+	}
+
+	var (
+		promReq  = &prompb.WriteRequest{}
+		expected []string
+	)
+	for i := 0; i < numSeries; i++ {
+		instance := fmt.Sprintf("host-%d", i)
+		promReq.Timeseries = append(promReq.Timeseries, prompb.TimeSeries{
+			Labels:  testLabels("__name__", "up", "instance", instance),
+			Samples: []prompb.Sample{{Value: 1, Timestamp: time.Now().UnixNano() / int64(time.Millisecond)}},
+		})
+		expected = append(expected, instance)
+	}
+	sort.Strings(expected)
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	// Every series is forwarded once to the full target and once to the
+	// shard group.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		lock.Lock()
+		done := total == 2*numSeries
+		lock.Unlock()
+		if done {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "timed out waiting for forwards")
+		time.Sleep(time.Millisecond)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	full := append([]string(nil), forwarded["full"]...)
+	sort.Strings(full)
+	require.Equal(t, expected, full)
+
+	var union []string
+	for shard := 0; shard < 3; shard++ {
+		instances := forwarded[fmt.Sprintf("shard-%d", shard)]
+		require.NotEmpty(t, instances)
+		for _, instance := range instances {
+			labels := testLabels("__name__", "up", "instance", instance)
+			require.Equal(t, shard, forwardShardIndex(labels, 3), instance)
+		}
+		union = append(union, instances...)
+	}
+	sort.Strings(union)
+	require.Equal(t, expected, union)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/m3db/m3/src/x/headers"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+)
+
+// PromWriteHeaderConflictResponse is the response returned when a write
+// request sets headers that conflict with each other.
+type PromWriteHeaderConflictResponse struct {
+	Status             string             `json:"status"`
+	Error              string             `json:"error"`
+	Code               PromWriteErrorCode `json:"code"`
+	ConflictingHeaders map[string]string  `json:"conflictingHeaders"`
+}
+
+type headerConflictError struct {
+	headers map[string]string
+	reason  string
+}
+
+func (e *headerConflictError) Error() string {
+	return fmt.Sprintf("conflicting headers: %s", e.reason)
+}
+
+// checkHeaderConflicts returns an error if the write type header discards
+// the storage policies that the storage policy header explicitly requests,
+// rather than letting one silently override the other.
+func checkHeaderConflicts(header http.Header) error {
+	writeType := strings.TrimSpace(header.Get(headers.WriteTypeHeader))
+	strPolicy := strings.TrimSpace(header.Get(headers.MetricsStoragePolicyHeader))
+	if writeType != headers.AggregateWriteType || strPolicy == "" {
+		return nil
+	}
+
+	return &headerConflictError{
+		headers: map[string]string{
+			headers.WriteTypeHeader:            writeType,
+			headers.MetricsStoragePolicyHeader: strPolicy,
+		},
+		reason: fmt.Sprintf("%s=%s writes with no storage policies but %s=%s "+
+			"explicitly sets a storage policy",
+			headers.WriteTypeHeader, writeType,
+			headers.MetricsStoragePolicyHeader, strPolicy),
+	}
+}
+
+// writeParseError writes the parse error, including the conflicting headers
+// in the response for a header conflict.
+func writeParseError(w http.ResponseWriter, err error) {
+	var conflict *headerConflictError
+	for inner := err; inner != nil && conflict == nil; inner = innerError(inner) {
+		conflict, _ = inner.(*headerConflictError) //nolint:errorlint
+	}
+	if conflict == nil {
+		writeError(w, err)
+		return
+	}
+
+	code, _ := errorCode(err)
+	resp, marshalErr := json.Marshal(PromWriteHeaderConflictResponse{
+		Status:             "error",
+		Error:              err.Error(),
+		Code:               code,
+		ConflictingHeaders: conflict.headers,
+	})
+	if marshalErr != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set(headers.ErrorCodeHeader, string(code))
+	w.Header().Set(xhttp.HeaderContentType, xhttp.ContentTypeJSON)
+	xhttp.WriteError(w, err, xhttp.WithErrorResponse(resp))
+}
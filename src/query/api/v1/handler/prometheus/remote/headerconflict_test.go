@@ -0,0 +1,121 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/storage/m3/storagemetadata"
+	"github.com/m3db/m3/src/x/headers"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromWriteHeaderConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		conflict bool
+	}{
+		{
+			name: "aggregate write type alone",
+			headers: map[string]string{
+				headers.WriteTypeHeader: headers.AggregateWriteType,
+			},
+		},
+		{
+			name: "storage policy alone",
+			headers: map[string]string{
+				headers.MetricsTypeHeader:          storagemetadata.AggregatedMetricsType.String(),
+				headers.MetricsStoragePolicyHeader: "1m:21d",
+			},
+		},
+		{
+			name: "default write type with storage policy",
+			headers: map[string]string{
+				headers.MetricsTypeHeader:          storagemetadata.AggregatedMetricsType.String(),
+				headers.MetricsStoragePolicyHeader: "1m:21d",
+				headers.WriteTypeHeader:            headers.DefaultWriteType,
+			},
+		},
+		{
+			name: "aggregate write type with storage policy",
+			headers: map[string]string{
+				headers.MetricsTypeHeader:          storagemetadata.AggregatedMetricsType.String(),
+				headers.MetricsStoragePolicyHeader: "1m:21d",
+				headers.WriteTypeHeader:            headers.AggregateWriteType,
+			},
+			conflict: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if !tt.conflict {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+			}
+
+			handler, err := NewPromWriteHandler(makeOptions(mockDownsamplerAndWriter))
+			require.NoError(t, err)
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+
+			resp := writer.Result()
+			defer resp.Body.Close()
+			if !tt.conflict {
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				return
+			}
+
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			require.Equal(t, xhttp.ContentTypeJSON, resp.Header.Get(xhttp.HeaderContentType))
+
+			var body PromWriteHeaderConflictResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			require.Equal(t, "error", body.Status)
+			require.Contains(t, body.Error, "conflicting headers")
+			require.Equal(t, map[string]string{
+				headers.WriteTypeHeader:            headers.AggregateWriteType,
+				headers.MetricsStoragePolicyHeader: "1m:21d",
+			}, body.ConflictingHeaders)
+		})
+	}
+}
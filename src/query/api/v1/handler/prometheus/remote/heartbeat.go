@@ -0,0 +1,164 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/query/storage"
+	"github.com/m3db/m3/src/x/clock"
+	xtime "github.com/m3db/m3/src/x/time"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHeartbeatInterval   = 10 * time.Second
+	defaultHeartbeatMetricName = "m3coordinator_write_heartbeat"
+)
+
+// heartbeatWriter periodically writes a heartbeat series through the write
+// path, whose value is the unix time in seconds at which it was written, so
+// that the delay between ingesting and querying it can be measured.
+type heartbeatWriter struct {
+	interval time.Duration
+	labels   []prompb.Label
+	writeFn  coalescedWriteFn
+	nowFn    clock.NowFn
+	logger   *zap.Logger
+	closeCh  chan struct{}
+	wg       sync.WaitGroup
+
+	success tally.Counter
+	errors  tally.Counter
+}
+
+func newHeartbeatWriter(
+	opts handleroptions.PromWriteHandlerHeartbeatOptions,
+	writeFn coalescedWriteFn,
+	nowFn clock.NowFn,
+	logger *zap.Logger,
+	scope tally.Scope,
+) (*heartbeatWriter, error) {
+	if opts.Interval < 0 {
+		return nil, fmt.Errorf("heartbeat interval must not be negative: %s",
+			opts.Interval)
+	}
+
+	interval := defaultHeartbeatInterval
+	if opts.Interval > 0 {
+		interval = opts.Interval
+	}
+
+	metricName := defaultHeartbeatMetricName
+	if opts.MetricName != "" {
+		metricName = opts.MetricName
+	}
+
+	labels := make([]prompb.Label, 0, 1+len(opts.Labels))
+	labels = append(labels, prompb.Label{
+		Name:  promMetricNameLabel,
+		Value: []byte(metricName),
+	})
+	for name, value := range opts.Labels {
+		if name == string(promMetricNameLabel) {
+			return nil, fmt.Errorf("heartbeat labels must not set %s",
+				promMetricNameLabel)
+		}
+		labels = append(labels, prompb.Label{
+			Name:  []byte(name),
+			Value: []byte(value),
+		})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return string(labels[i].Name) < string(labels[j].Name)
+	})
+
+	scope = scope.SubScope("write").SubScope("heartbeat")
+	w := &heartbeatWriter{
+		interval: interval,
+		labels:   labels,
+		writeFn:  writeFn,
+		nowFn:    nowFn,
+		logger:   logger,
+		closeCh:  make(chan struct{}),
+		success:  scope.Counter("success"),
+		errors:   scope.Counter("errors"),
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+func (w *heartbeatWriter) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.write()
+		case <-w.closeCh:
+			return
+		}
+	}
+}
+
+// write writes a single heartbeat, each write times out after an interval so
+// that a slow write does not delay the next heartbeat indefinitely.
+func (w *heartbeatWriter) write() {
+	now := w.nowFn()
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels: w.labels,
+			Samples: []prompb.Sample{{
+				Value:     float64(now.UnixNano()) / float64(time.Second),
+				Timestamp: storage.TimeToPromTimestamp(xtime.ToUnixNano(now)),
+			}},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.interval)
+	defer cancel()
+
+	if err := w.writeFn(ctx, req, ingest.WriteOptions{}); err != nil {
+		w.errors.Inc(1)
+		w.logger.Error("heartbeat write error", zap.Error(err))
+		return
+	}
+	w.success.Inc(1)
+}
+
+// Close stops writing heartbeats, waiting for any in progress write.
+func (w *heartbeatWriter) Close() {
+	close(w.closeCh)
+	w.wg.Wait()
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/query/models"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+func TestHeartbeatWriterCadence(t *testing.T) {
+	const interval = 50 * time.Millisecond
+
+	var (
+		mu      sync.Mutex
+		written []time.Time
+		series  []prompb.TimeSeries
+		doneCh  = make(chan struct{})
+	)
+	writeFn := func(
+		_ context.Context,
+		r *prompb.WriteRequest,
+		_ ingest.WriteOptions,
+	) ingest.BatchError {
+		mu.Lock()
+		defer mu.Unlock()
+		written = append(written, time.Now())
+		series = append(series, r.Timeseries...)
+		if len(written) == 3 {
+			close(doneCh)
+		}
+		return nil
+	}
+
+	var (
+		now   = time.Unix(1700000000, 500*int64(time.Millisecond))
+		nowFn = func() time.Time { return now }
+		scope = tally.NewTestScope("", nil)
+	)
+	w, err := newHeartbeatWriter(handleroptions.PromWriteHandlerHeartbeatOptions{
+		Interval: interval,
+		Labels:   map[string]string{"instance": "coordinator-0"},
+	}, writeFn, nowFn, zap.NewNop(), scope)
+	require.NoError(t, err)
+
+	start := time.Now()
+	select {
+	case <-doneCh:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for heartbeats")
+	}
+	w.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Heartbeats are written once per interval.
+	prev := start
+	for _, at := range written[:3] {
+		require.True(t, at.Sub(prev) >= interval/2,
+			"heartbeat written %s after previous", at.Sub(prev))
+		prev = at
+	}
+
+	require.Equal(t, testLabels("__name__", "m3coordinator_write_heartbeat",
+		"instance", "coordinator-0"), series[0].Labels)
+	require.Equal(t, []prompb.Sample{{
+		Value:     1700000000.5,
+		Timestamp: 1700000000500,
+	}}, series[0].Samples)
+
+	require.True(t, scope.Snapshot().Counters()["write.heartbeat.success+"].Value() >= 3)
+}
+
+func TestHeartbeatWriterInvalidOptions(t *testing.T) {
+	writeFn := func(
+		context.Context,
+		*prompb.WriteRequest,
+		ingest.WriteOptions,
+	) ingest.BatchError {
+		return nil
+	}
+
+	_, err := newHeartbeatWriter(handleroptions.PromWriteHandlerHeartbeatOptions{
+		Interval: -time.Second,
+	}, writeFn, time.Now, zap.NewNop(), tally.NoopScope)
+	require.Error(t, err)
+
+	_, err = newHeartbeatWriter(handleroptions.PromWriteHandlerHeartbeatOptions{
+		Labels: map[string]string{"__name__": "foo"},
+	}, writeFn, time.Now, zap.NewNop(), tally.NoopScope)
+	require.Error(t, err)
+}
+
+func TestPromWriteHeartbeat(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	writtenCh := make(chan models.Tags, 10)
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			for iter.Next() {
+				select {
+				case writtenCh <- iter.Current().Tags.Clone():
+				default:
+				}
+			}
+			return nil
+		}).
+		MinTimes(1)
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.Heartbeat = &handleroptions.PromWriteHandlerHeartbeatOptions{
+			Interval:   10 * time.Millisecond,
+			MetricName: "heartbeat",
+		}
+	})
+
+	var tags models.Tags
+	select {
+	case tags = <-writtenCh:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for heartbeat")
+	}
+	require.NoError(t, handler.Close())
+
+	name, ok := tags.Name()
+	require.True(t, ok)
+	require.Equal(t, "heartbeat", string(name))
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+
+	"github.com/uber-go/tally"
+)
+
+// histogramValidator checks that the cumulative counts of the bucket series
+// of each histogram do not decrease as the upper bound increases.
+type histogramValidator struct {
+	reject       bool
+	inconsistent tally.Counter
+}
+
+func newHistogramValidator(
+	opts handleroptions.PromWriteHandlerHistogramValidationOptions,
+	scope tally.Scope,
+) *histogramValidator {
+	return &histogramValidator{
+		reject:       opts.Reject,
+		inconsistent: scope.SubScope("write").Counter("inconsistent-histograms"),
+	}
+}
+
+// histogramBuckets are the bucket samples of a histogram, identified by the
+// labels of its bucket series other than the le label.
+type histogramBuckets struct {
+	id      string
+	samples []histogramBucketSample
+	err     error
+}
+
+type histogramBucketSample struct {
+	upperBound float64
+	timestamp  int64
+	value      float64
+}
+
+// validate groups the bucket series of the request by histogram and checks
+// the bucket counts of each histogram at each timestamp, counting the
+// inconsistent histograms and returning an error for the first of them
+// when rejecting.
+func (v *histogramValidator) validate(series []prompb.TimeSeries) error {
+	var (
+		histograms []*histogramBuckets
+		byID       map[string]*histogramBuckets
+		buffer     []prompb.Label
+		id         []byte
+	)
+	for _, ts := range series {
+		le, ok := histogramBucketUpperBound(ts.Labels)
+		if !ok {
+			continue
+		}
+
+		buffer = buffer[:0]
+		for _, l := range ts.Labels {
+			if !bytes.Equal(l.Name, histogramBucketLabel) {
+				buffer = append(buffer, l)
+			}
+		}
+		id = buildPseudoIDWithLabelsLikelySorted(buffer, id[:0])
+
+		if byID == nil {
+			byID = make(map[string]*histogramBuckets)
+		}
+		h, ok := byID[string(id)]
+		if !ok {
+			h = &histogramBuckets{id: string(id)}
+			byID[h.id] = h
+			histograms = append(histograms, h)
+		}
+		if h.err != nil {
+			continue
+		}
+
+		upperBound, err := strconv.ParseFloat(string(le), 64)
+		if err != nil {
+			h.err = fmt.Errorf("inconsistent histogram: invalid %s label: "+
+				"series=%s, %s=%q", histogramBucketLabel, h.id, histogramBucketLabel, le)
+			continue
+		}
+		for _, sample := range ts.Samples {
+			h.samples = append(h.samples, histogramBucketSample{
+				upperBound: upperBound,
+				timestamp:  sample.Timestamp,
+				value:      sample.Value,
+			})
+		}
+	}
+
+	var (
+		numInconsistent int64
+		firstErr        error
+	)
+	for _, h := range histograms {
+		err := h.err
+		if err == nil {
+			err = h.check()
+		}
+		if err == nil {
+			continue
+		}
+		numInconsistent++
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	v.inconsistent.Inc(numInconsistent)
+	if v.reject {
+		return firstErr
+	}
+	return nil
+}
+
+// check returns an error if the count of a bucket is less than the count of
+// a bucket with a lower upper bound at the same timestamp.
+func (h *histogramBuckets) check() error {
+	samples := h.samples
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].timestamp != samples[j].timestamp {
+			return samples[i].timestamp < samples[j].timestamp
+		}
+		return samples[i].upperBound < samples[j].upperBound
+	})
+	for i := 1; i < len(samples); i++ {
+		curr, prev := samples[i], samples[i-1]
+		if curr.timestamp != prev.timestamp || curr.value >= prev.value {
+			continue
+		}
+		return fmt.Errorf("inconsistent histogram: bucket count decreases: "+
+			"series=%s, timestamp=%d, %s=%g, count=%g, previous %s=%g, previous count=%g",
+			h.id, curr.timestamp, histogramBucketLabel, curr.upperBound, curr.value,
+			histogramBucketLabel, prev.upperBound, prev.value)
+	}
+	return nil
+}
+
+// histogramBucketUpperBound returns the value of the le label of a series
+// whose metric name has the bucket suffix.
+func histogramBucketUpperBound(labels []prompb.Label) ([]byte, bool) {
+	var (
+		le              []byte
+		hasLE, isBucket bool
+	)
+	for _, l := range labels {
+		switch {
+		case bytes.Equal(l.Name, promMetricNameLabel):
+			isBucket = bytes.HasSuffix(l.Value, histogramBucketSuffix)
+		case bytes.Equal(l.Name, histogramBucketLabel):
+			le, hasLE = l.Value, true
+		}
+	}
+	return le, hasLE && isBucket
+}
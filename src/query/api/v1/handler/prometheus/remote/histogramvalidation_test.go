@@ -0,0 +1,174 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func testBucketSeries(le string, samples ...prompb.Sample) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels:  testLabels("__name__", "latency_bucket", "job", "a", "le", le),
+		Samples: samples,
+	}
+}
+
+func TestHistogramValidator(t *testing.T) {
+	tests := []struct {
+		name         string
+		series       []prompb.TimeSeries
+		inconsistent int64
+		expectedErr  string
+	}{
+		{
+			name: "consistent",
+			series: []prompb.TimeSeries{
+				testBucketSeries("+Inf", prompb.Sample{Timestamp: 1, Value: 5},
+					prompb.Sample{Timestamp: 2, Value: 7}),
+				testBucketSeries("0.5", prompb.Sample{Timestamp: 1, Value: 3},
+					prompb.Sample{Timestamp: 2, Value: 3}),
+				testBucketSeries("0.1", prompb.Sample{Timestamp: 1, Value: 1},
+					prompb.Sample{Timestamp: 2, Value: 2}),
+			},
+		},
+		{
+			name: "decreasing bucket at same timestamp",
+			series: []prompb.TimeSeries{
+				testBucketSeries("0.1", prompb.Sample{Timestamp: 1, Value: 4}),
+				testBucketSeries("0.5", prompb.Sample{Timestamp: 1, Value: 3}),
+				testBucketSeries("+Inf", prompb.Sample{Timestamp: 1, Value: 5}),
+			},
+			inconsistent: 1,
+			expectedErr: "inconsistent histogram: bucket count decreases: " +
+				"series=__name__=latency_bucket,job=a, timestamp=1, le=0.5, count=3, " +
+				"previous le=0.1, previous count=4",
+		},
+		{
+			name: "decreasing across timestamps",
+			series: []prompb.TimeSeries{
+				testBucketSeries("0.1", prompb.Sample{Timestamp: 1, Value: 4}),
+				testBucketSeries("0.5", prompb.Sample{Timestamp: 2, Value: 3}),
+			},
+		},
+		{
+			name: "invalid le",
+			series: []prompb.TimeSeries{
+				testBucketSeries("fast", prompb.Sample{Timestamp: 1, Value: 4}),
+			},
+			inconsistent: 1,
+			expectedErr: `inconsistent histogram: invalid le label: ` +
+				`series=__name__=latency_bucket,job=a, le="fast"`,
+		},
+		{
+			name: "not bucket series",
+			series: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "latency", "le", "0.1"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 4}},
+				},
+				{
+					Labels:  testLabels("__name__", "latency", "le", "0.5"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 3}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, reject := range []bool{false, true} {
+				scope := tally.NewTestScope("", nil)
+				validator := newHistogramValidator(handleroptions.PromWriteHandlerHistogramValidationOptions{
+					Reject: reject,
+				}, scope)
+
+				err := validator.validate(tt.series)
+				if reject && tt.expectedErr != "" {
+					require.EqualError(t, err, tt.expectedErr)
+				} else {
+					require.NoError(t, err)
+				}
+
+				inconsistent := scope.Snapshot().Counters()["write.inconsistent-histograms+"]
+				require.NotNil(t, inconsistent)
+				require.Equal(t, tt.inconsistent, inconsistent.Value())
+			}
+		})
+	}
+}
+
+func TestPromWriteHistogramValidation(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil).
+		Times(1)
+
+	scope := tally.NewTestScope("", map[string]string{"test": "histogram-validation-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.HistogramValidation = &handleroptions.PromWriteHandlerHistogramValidationOptions{
+			Reject: true,
+		}
+	})
+
+	write := func(series ...prompb.TimeSeries) int {
+		promReqBody := test.GeneratePromWriteRequestBody(t, &prompb.WriteRequest{
+			Timeseries: series,
+		})
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		return writer.Result().StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, write(
+		testBucketSeries("0.1", prompb.Sample{Timestamp: 1000, Value: 1}),
+		testBucketSeries("+Inf", prompb.Sample{Timestamp: 1000, Value: 2}),
+	))
+	require.Equal(t, http.StatusBadRequest, write(
+		testBucketSeries("0.1", prompb.Sample{Timestamp: 1000, Value: 3}),
+		testBucketSeries("+Inf", prompb.Sample{Timestamp: 1000, Value: 2}),
+	))
+
+	inconsistent, ok := scope.Snapshot().Counters()["write.inconsistent-histograms+handler=remote-write,test=histogram-validation-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), inconsistent.Value())
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/util/logging"
+	"github.com/m3db/m3/src/x/cache"
+	"github.com/m3db/m3/src/x/clock"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/headers"
+	xhttpstatus "github.com/m3db/m3/src/x/http"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultIdempotencyTTL     = 10 * time.Minute
+	defaultIdempotencyMaxKeys = 10000
+	maxIdempotencyKeyLength   = 256
+)
+
+var errIdempotentWriteFailed = errors.New("idempotent write failed")
+
+func newIdempotencyKeys(
+	opts handleroptions.PromWriteHandlerIdempotencyOptions,
+	nowFn clock.NowFn,
+	scope tally.Scope,
+) *cache.LRU {
+	ttl := defaultIdempotencyTTL
+	if opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+
+	maxKeys := defaultIdempotencyMaxKeys
+	if opts.MaxKeys > 0 {
+		maxKeys = opts.MaxKeys
+	}
+
+	return cache.NewLRU(&cache.LRUOptions{
+		TTL:        ttl,
+		MaxEntries: maxKeys,
+		Metrics:    scope.SubScope("idempotency"),
+		Now:        nowFn,
+	})
+}
+
+// serveIdempotentWrite serves the write unless a write with the same key has
+// already succeeded, in which case the success is returned without writing.
+// Concurrent writes with the same key wait for the first to complete, and
+// are only written if it fails.
+func (h *PromWriteHandler) serveIdempotentWrite(
+	w http.ResponseWriter,
+	r *http.Request,
+	key string,
+) {
+	if len(key) > maxIdempotencyKeyLength {
+		err := xerrors.NewInvalidParamsError(fmt.Errorf(
+			"%s header too long: length=%d, maxLength=%d",
+			headers.IdempotencyKeyHeader, len(key), maxIdempotencyKeyLength))
+		h.metrics.incError(r, err)
+		writeError(w, withErrorCode(err, PromWriteErrorCodeInvalidHeader))
+		return
+	}
+
+	served := false
+	_, err := h.idempotencyKeys.Get(r.Context(), key, func(context.Context, string) (interface{}, error) {
+		served = true
+		tracker := &xhttpstatus.StatusCodeTracker{ResponseWriter: w}
+		h.serveWrite(tracker, r)
+		if tracker.Status != http.StatusOK {
+			// Failed writes are not remembered so that retries are written.
+			return nil, errIdempotentWriteFailed
+		}
+		return struct{}{}, nil
+	})
+	if served {
+		return
+	}
+
+	if err != nil {
+		if r.Context().Err() != nil {
+			h.metrics.incError(r, err)
+			xhttp.WriteError(w, err)
+			return
+		}
+
+		// The key could not be tracked, e.g. the cache is full of in flight
+		// writes, so write without deduping.
+		logger := logging.WithContext(r.Context(), h.instrumentOpts)
+		logger.Debug("could not dedup idempotent write", zap.Error(err))
+		h.serveWrite(w, r)
+		return
+	}
+
+	h.metrics.writeIdempotentDedup.Inc(1)
+	w.WriteHeader(http.StatusOK)
+}
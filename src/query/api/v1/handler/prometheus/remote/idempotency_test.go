@@ -0,0 +1,218 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/headers"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	"go.uber.org/atomic"
+)
+
+type idempotencyTestSetup struct {
+	handler http.Handler
+	scope   tally.TestScope
+	now     *atomic.Time
+}
+
+func newIdempotencyTestSetup(
+	t *testing.T,
+	ds ingest.DownsamplerAndWriter,
+	idempotencyOpts *handleroptions.PromWriteHandlerIdempotencyOptions,
+) idempotencyTestSetup {
+	var (
+		scope = tally.NewTestScope("", map[string]string{"test": "idempotency-test"})
+		now   = atomic.NewTime(time.Now())
+	)
+	opts := makeOptions(ds).
+		SetNowFn(now.Load).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.Idempotency = idempotencyOpts
+	})
+
+	return idempotencyTestSetup{handler: handler, scope: scope, now: now}
+}
+
+func (s idempotencyTestSetup) write(t *testing.T, key string) int {
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	if key != "" {
+		req.Header.Set(headers.IdempotencyKeyHeader, key)
+	}
+
+	writer := httptest.NewRecorder()
+	s.handler.ServeHTTP(writer, req)
+	return writer.Result().StatusCode
+}
+
+func (s idempotencyTestSetup) numDeduped(t *testing.T) int64 {
+	dedup, ok := s.scope.Snapshot().Counters()["write.idempotent-dedup+handler=remote-write,test=idempotency-test"]
+	require.True(t, ok)
+	return dedup.Value()
+}
+
+func TestPromWriteIdempotencyKey(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	setup := newIdempotencyTestSetup(t, mockDownsamplerAndWriter,
+		&handleroptions.PromWriteHandlerIdempotencyOptions{TTL: time.Minute})
+
+	// First request writes.
+	mockDownsamplerAndWriter.EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+	require.Equal(t, http.StatusOK, setup.write(t, "key-a"))
+	require.Equal(t, int64(0), setup.numDeduped(t))
+
+	// Duplicate request within the TTL skips the write.
+	require.Equal(t, http.StatusOK, setup.write(t, "key-a"))
+	require.Equal(t, int64(1), setup.numDeduped(t))
+
+	// A different key writes.
+	mockDownsamplerAndWriter.EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+	require.Equal(t, http.StatusOK, setup.write(t, "key-b"))
+	require.Equal(t, int64(1), setup.numDeduped(t))
+
+	// No key always writes.
+	mockDownsamplerAndWriter.EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(2)
+	require.Equal(t, http.StatusOK, setup.write(t, ""))
+	require.Equal(t, http.StatusOK, setup.write(t, ""))
+
+	// Duplicate request after the TTL writes again.
+	setup.now.Store(setup.now.Load().Add(2 * time.Minute))
+	mockDownsamplerAndWriter.EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+	require.Equal(t, http.StatusOK, setup.write(t, "key-a"))
+	require.Equal(t, int64(1), setup.numDeduped(t))
+
+	// Too long keys are rejected.
+	require.Equal(t, http.StatusBadRequest,
+		setup.write(t, strings.Repeat("a", maxIdempotencyKeyLength+1)))
+}
+
+func TestPromWriteIdempotencyKeyFailedWriteNotDeduped(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	setup := newIdempotencyTestSetup(t, mockDownsamplerAndWriter,
+		&handleroptions.PromWriteHandlerIdempotencyOptions{})
+
+	batchErr := ingest.BatchError(xerrors.NewMultiError().Add(errors.New("an error")))
+	gomock.InOrder(
+		mockDownsamplerAndWriter.EXPECT().
+			WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(batchErr),
+		mockDownsamplerAndWriter.EXPECT().
+			WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()),
+	)
+
+	require.Equal(t, http.StatusInternalServerError, setup.write(t, "key"))
+	require.Equal(t, http.StatusOK, setup.write(t, "key"))
+	require.Equal(t, http.StatusOK, setup.write(t, "key"))
+	require.Equal(t, int64(1), setup.numDeduped(t))
+}
+
+func TestPromWriteIdempotencyKeyConcurrent(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		started = make(chan struct{})
+		unblock = make(chan struct{})
+	)
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, ingest.DownsampleAndWriteIter, ingest.WriteOptions) ingest.BatchError {
+			close(started)
+			<-unblock
+			return nil
+		})
+
+	setup := newIdempotencyTestSetup(t, mockDownsamplerAndWriter,
+		&handleroptions.PromWriteHandlerIdempotencyOptions{})
+
+	var (
+		wg       sync.WaitGroup
+		statuses = make([]int, 4)
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		statuses[0] = setup.write(t, "key")
+	}()
+
+	// Retries arriving while the first write is in flight wait for it.
+	<-started
+	for i := 1; i < len(statuses); i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = setup.write(t, "key")
+		}()
+	}
+	close(unblock)
+	wg.Wait()
+
+	for _, status := range statuses {
+		require.Equal(t, http.StatusOK, status)
+	}
+	require.Equal(t, int64(len(statuses)-1), setup.numDeduped(t))
+}
+
+func TestPromWriteIdempotencyKeyDisabled(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(2)
+	setup := newIdempotencyTestSetup(t, mockDownsamplerAndWriter, nil)
+
+	require.Equal(t, http.StatusOK, setup.write(t, "key"))
+	require.Equal(t, http.StatusOK, setup.write(t, "key"))
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	xhttp "github.com/m3db/m3/src/x/net/http"
+
+	"github.com/uber-go/tally"
+)
+
+// inFlightBytes bounds the sum of the decompressed body sizes of the
+// requests being written concurrently, since bounding the number of
+// concurrent requests does not bound memory when their sizes vary.
+type inFlightBytes struct {
+	sync.Mutex
+
+	max     int64
+	current int64
+
+	bytes    tally.Gauge
+	exceeded tally.Counter
+}
+
+func newInFlightBytes(max int64, scope tally.Scope) *inFlightBytes {
+	scope = scope.SubScope("write")
+	return &inFlightBytes{
+		max:      max,
+		bytes:    scope.Gauge("in-flight-bytes"),
+		exceeded: scope.Counter("in-flight-bytes-exceeded"),
+	}
+}
+
+// acquire reserves n bytes of the budget, returning a 429 error if the
+// budget is exhausted by concurrent requests or a 413 error if n exceeds the
+// whole budget. The bytes must be released once the request is responded to.
+func (b *inFlightBytes) acquire(n int64) error {
+	if n > b.max {
+		b.exceeded.Inc(1)
+		err := xhttp.NewError(fmt.Errorf("request exceeds max in-flight bytes: "+
+			"size=%d, max=%d", n, b.max), http.StatusRequestEntityTooLarge)
+		return withErrorCode(err, PromWriteErrorCodeRequestTooLarge)
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.current+n > b.max {
+		b.exceeded.Inc(1)
+		err := xhttp.NewError(fmt.Errorf("max in-flight bytes exceeded: "+
+			"size=%d, in-flight=%d, max=%d", n, b.current, b.max),
+			http.StatusTooManyRequests)
+		return withErrorCode(err, PromWriteErrorCodeOverloaded)
+	}
+	b.current += n
+	b.bytes.Update(float64(b.current))
+	return nil
+}
+
+// release returns n bytes acquired to the budget.
+func (b *inFlightBytes) release(n int64) {
+	b.Lock()
+	b.current -= n
+	b.bytes.Update(float64(b.current))
+	b.Unlock()
+}
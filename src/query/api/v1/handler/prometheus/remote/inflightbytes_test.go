@@ -0,0 +1,185 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func newInFlightBytesTestRequest(numSeries int) *prompb.WriteRequest {
+	req := &prompb.WriteRequest{}
+	for i := 0; i < numSeries; i++ {
+		req.Timeseries = append(req.Timeseries, prompb.TimeSeries{
+			Labels: testLabels("__name__", fmt.Sprintf("series_%d", i),
+				"foo", "bar"),
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		})
+	}
+	return req
+}
+
+func TestPromWriteMaxInFlightBytes(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		large = newInFlightBytesTestRequest(100)
+		small = newInFlightBytesTestRequest(1)
+		huge  = newInFlightBytesTestRequest(300)
+	)
+
+	// The first large request blocks being written until released.
+	var (
+		startedCh = make(chan struct{})
+		releaseCh = make(chan struct{})
+		blockOnce sync.Once
+	)
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			n := 0
+			for iter.Next() {
+				n++
+			}
+			if n == len(large.Timeseries) {
+				blockOnce.Do(func() {
+					close(startedCh)
+					<-releaseCh
+				})
+			}
+			return nil
+		}).
+		Times(3)
+
+	scope := tally.NewTestScope("", map[string]string{"test": "in-flight-bytes"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.MaxInFlightBytes = int64(large.Size() + small.Size())
+	})
+
+	write := func(req *prompb.WriteRequest) int {
+		body := test.GeneratePromWriteRequestBody(t, req)
+		httpReq := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, httpReq)
+		return writer.Result().StatusCode
+	}
+
+	largeDoneCh := make(chan int, 1)
+	go func() {
+		largeDoneCh <- write(large)
+	}()
+	select {
+	case <-startedCh:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for large write")
+	}
+
+	// Another large request exceeds the budget while the first is in-flight,
+	// a small request still fits.
+	require.Equal(t, http.StatusTooManyRequests, write(large))
+	require.Equal(t, http.StatusOK, write(small))
+
+	// A request larger than the whole budget can never fit.
+	require.Equal(t, http.StatusRequestEntityTooLarge, write(huge))
+
+	close(releaseCh)
+	require.Equal(t, http.StatusOK, <-largeDoneCh)
+
+	// The budget is released once requests are responded to.
+	require.Equal(t, http.StatusOK, write(large))
+
+	snapshot := scope.Snapshot()
+	exceeded, ok := snapshot.Counters()["write.in-flight-bytes-exceeded+handler=remote-write,test=in-flight-bytes"]
+	require.True(t, ok)
+	require.Equal(t, int64(2), exceeded.Value())
+	inFlight, ok := snapshot.Gauges()["write.in-flight-bytes+handler=remote-write,test=in-flight-bytes"]
+	require.True(t, ok)
+	require.Equal(t, float64(0), inFlight.Value())
+}
+
+func TestPromWriteMaxInFlightBytesBeforeDecompression(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	scope := tally.NewTestScope("", map[string]string{"test": "in-flight-bytes"})
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.MaxInFlightBytes = 1 << 20
+	})
+
+	write := func(body []byte) int {
+		httpReq := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, bytes.NewReader(body))
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, httpReq)
+		return writer.Result().StatusCode
+	}
+
+	// A body declaring a decompressed size over the budget is rejected by
+	// its header alone, it is never decompressed since it is not valid.
+	header := binary.AppendUvarint(nil, 1<<30)
+	require.Equal(t, http.StatusRequestEntityTooLarge, write(append(header, 0xff)))
+
+	// The budget reserved is released when the body fails to parse once
+	// decompressed.
+	require.Equal(t, http.StatusBadRequest, write(snappy.Encode(nil, []byte{0xff})))
+
+	inFlight, ok := scope.Snapshot().Gauges()["write.in-flight-bytes+handler=remote-write,test=in-flight-bytes"]
+	require.True(t, ok)
+	require.Equal(t, float64(0), inFlight.Value())
+}
+
+func TestPromWriteMaxInFlightBytesNegative(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.MaxInFlightBytes = -1
+	opts = opts.SetConfig(cfg)
+
+	_, err := NewPromWriteHandler(opts)
+	require.Error(t, err)
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/x/clock"
+	"github.com/m3db/m3/src/x/headers"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	defaultJWTHeader      = "Authorization"
+	defaultJWTTenantClaim = "tenant"
+	bearerTokenPrefix     = "Bearer "
+)
+
+var (
+	errJWTNoKey       = errors.New("jwt must have either an hmac secret or a public key")
+	errJWTBothKeys    = errors.New("jwt cannot have both an hmac secret and a public key")
+	errJWTNoToken     = errors.New("missing jwt bearer token")
+	errJWTBadIssuer   = errors.New("jwt has unexpected issuer")
+	errJWTBadAudience = errors.New("jwt has unexpected audience")
+)
+
+// jwtWriteOptionClaims is the claims the write options are derived from,
+// each set as the header the write options are otherwise read from.
+var jwtWriteOptionClaims = []struct {
+	claim  string
+	header string
+}{
+	{claim: "metrics_type", header: headers.MetricsTypeHeader},
+	{claim: "storage_policy", header: headers.MetricsStoragePolicyHeader},
+	{claim: "write_type", header: headers.WriteTypeHeader},
+}
+
+// jwtVerifier verifies the JWT of each request and replaces the headers the
+// write options and tenant are read from with the claims of the token.
+type jwtVerifier struct {
+	header        string
+	tenantClaim   string
+	tenantHeaders []string
+	issuer        string
+	audience      string
+	keyFunc       jwt.Keyfunc
+	nowFn         clock.NowFn
+	parser        *jwt.Parser
+}
+
+func newJWTVerifier(
+	opts handleroptions.PromWriteHandlerJWTOptions,
+	tenantHeaders []string,
+	nowFn clock.NowFn,
+) (*jwtVerifier, error) {
+	var keyFunc jwt.Keyfunc
+	switch {
+	case opts.HMACSecret != "" && opts.PublicKeyPEM != "":
+		return nil, errJWTBothKeys
+	case opts.HMACSecret != "":
+		secret := []byte(opts.HMACSecret)
+		keyFunc = func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected jwt signing method: %s", token.Method.Alg())
+			}
+			return secret, nil
+		}
+	case opts.PublicKeyPEM != "":
+		key, err := parseJWTPublicKey(opts.PublicKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		keyFunc = func(token *jwt.Token) (interface{}, error) {
+			switch key.(type) {
+			case *rsa.PublicKey:
+				if _, ok := token.Method.(*jwt.SigningMethodRSA); ok {
+					return key, nil
+				}
+			case *ecdsa.PublicKey:
+				if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+					return key, nil
+				}
+			}
+			return nil, fmt.Errorf("unexpected jwt signing method: %s", token.Method.Alg())
+		}
+	default:
+		return nil, errJWTNoKey
+	}
+
+	header := opts.Header
+	if header == "" {
+		header = defaultJWTHeader
+	}
+
+	tenantClaim := opts.TenantClaim
+	if tenantClaim == "" {
+		tenantClaim = defaultJWTTenantClaim
+	}
+
+	return &jwtVerifier{
+		header:        header,
+		tenantClaim:   tenantClaim,
+		tenantHeaders: tenantHeaders,
+		issuer:        opts.Issuer,
+		audience:      opts.Audience,
+		keyFunc:       keyFunc,
+		nowFn:         nowFn,
+		// NB: The time based claims are validated with the handler clock.
+		parser: &jwt.Parser{SkipClaimsValidation: true},
+	}, nil
+}
+
+func parseJWTPublicKey(str string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(str))
+	if block == nil {
+		return nil, errors.New("jwt public key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse jwt public key: %w", err)
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported jwt public key type: %T", key)
+	}
+}
+
+// verify verifies the token of the request and replaces the headers of the
+// request that the write options and tenant are read from with the claims
+// of the token, so that headers set by the client are ignored.
+func (v *jwtVerifier) verify(r *http.Request) error {
+	claims, err := v.claims(r)
+	if err != nil {
+		// NB: The tenant of rejected requests is unverified.
+		for _, header := range v.tenantHeaders {
+			r.Header.Del(header)
+		}
+		return xhttp.NewError(err, http.StatusUnauthorized)
+	}
+
+	for _, c := range jwtWriteOptionClaims {
+		if err := setClaimHeader(r, claims, c.claim, c.header); err != nil {
+			return xhttp.NewError(err, http.StatusUnauthorized)
+		}
+	}
+	for _, header := range v.tenantHeaders {
+		if err := setClaimHeader(r, claims, v.tenantClaim, header); err != nil {
+			return xhttp.NewError(err, http.StatusUnauthorized)
+		}
+	}
+	return nil
+}
+
+func (v *jwtVerifier) claims(r *http.Request) (jwt.MapClaims, error) {
+	value := r.Header.Get(v.header)
+	if !strings.HasPrefix(value, bearerTokenPrefix) {
+		return nil, errJWTNoToken
+	}
+
+	var claims jwt.MapClaims
+	_, err := v.parser.ParseWithClaims(strings.TrimPrefix(value, bearerTokenPrefix),
+		&claims, v.keyFunc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwt: %w", err)
+	}
+
+	now := v.nowFn().Unix()
+	if !claims.VerifyExpiresAt(now, false) {
+		return nil, errors.New("invalid jwt: token is expired")
+	}
+	if !claims.VerifyNotBefore(now, false) {
+		return nil, errors.New("invalid jwt: token is not valid yet")
+	}
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return nil, errJWTBadIssuer
+	}
+	if v.audience != "" && !claims.VerifyAudience(v.audience, true) {
+		return nil, errJWTBadAudience
+	}
+	return claims, nil
+}
+
+// setClaimHeader sets the header to the string claim, or removes it if the
+// token has no such claim.
+func setClaimHeader(r *http.Request, claims jwt.MapClaims, claim, header string) error {
+	value, ok := claims[claim]
+	if !ok {
+		r.Header.Del(header)
+		return nil
+	}
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("jwt claim %s is not a string", claim)
+	}
+	r.Header.Set(header, str)
+	return nil
+}
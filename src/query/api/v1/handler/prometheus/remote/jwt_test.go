@@ -0,0 +1,285 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/metrics/policy"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/storage/m3/storagemetadata"
+	"github.com/m3db/m3/src/x/headers"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const (
+	testJWTSecret = "secret"
+	testJWTIssuer = "issuer"
+)
+
+var testJWTNow = time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestJWT(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).
+		SignedString([]byte(testJWTSecret))
+	require.NoError(t, err)
+	return token
+}
+
+func newTestJWTClaims(extra jwt.MapClaims) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"iss": testJWTIssuer,
+		"exp": testJWTNow.Add(time.Hour).Unix(),
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return claims
+}
+
+func TestPromWriteJWT(t *testing.T) {
+	aggregatedClaims := newTestJWTClaims(jwt.MapClaims{
+		"metrics_type":   storagemetadata.AggregatedMetricsType.String(),
+		"storage_policy": "1m:21d",
+		"tenant":         "a",
+	})
+	tamperedToken := func(t *testing.T) string {
+		// Swap the payload for one claiming another tenant while keeping the
+		// signature of the original payload.
+		parts := strings.Split(newTestJWT(t, aggregatedClaims), ".")
+		other := strings.Split(newTestJWT(t, newTestJWTClaims(jwt.MapClaims{
+			"tenant": "b",
+		})), ".")
+		parts[1] = other[1]
+		return strings.Join(parts, ".")
+	}
+
+	tests := []struct {
+		name           string
+		authorization  func(t *testing.T) string
+		headers        map[string]string
+		expectedCode   int
+		expectedOpts   ingest.WriteOptions
+		expectedTenant string
+	}{
+		{
+			name: "valid",
+			authorization: func(t *testing.T) string {
+				return "Bearer " + newTestJWT(t, aggregatedClaims)
+			},
+			// NB: Headers are ignored in favor of the claims.
+			headers: map[string]string{
+				headers.MetricsTypeHeader: storagemetadata.UnaggregatedMetricsType.String(),
+				testTenantMetricsHeader:   "spoofed",
+			},
+			expectedCode: http.StatusOK,
+			expectedOpts: ingest.WriteOptions{
+				DownsampleOverride: true,
+				WriteOverride:      true,
+				WriteStoragePolicies: policy.StoragePolicies{
+					policy.MustParseStoragePolicy("1m:21d"),
+				},
+			},
+			expectedTenant: "a",
+		},
+		{
+			name: "valid without write options claims",
+			authorization: func(t *testing.T) string {
+				return "Bearer " + newTestJWT(t, newTestJWTClaims(nil))
+			},
+			headers: map[string]string{
+				headers.MetricsTypeHeader: storagemetadata.UnaggregatedMetricsType.String(),
+			},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name: "expired",
+			authorization: func(t *testing.T) string {
+				return "Bearer " + newTestJWT(t, newTestJWTClaims(jwt.MapClaims{
+					"exp": testJWTNow.Add(-time.Minute).Unix(),
+				}))
+			},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name: "tampered",
+			authorization: func(t *testing.T) string {
+				return "Bearer " + tamperedToken(t)
+			},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong issuer",
+			authorization: func(t *testing.T) string {
+				return "Bearer " + newTestJWT(t, newTestJWTClaims(jwt.MapClaims{
+					"iss": "other",
+				}))
+			},
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name: "missing",
+			authorization: func(t *testing.T) string {
+				return ""
+			},
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			var writtenOpts []ingest.WriteOptions
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.
+				EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				Do(func(_ context.Context, _ ingest.DownsampleAndWriteIter, opts ingest.WriteOptions) ingest.BatchError {
+					writtenOpts = append(writtenOpts, opts)
+					return nil
+				}).
+				AnyTimes()
+
+			scope := tally.NewTestScope("", map[string]string{"test": "jwt-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope)).
+				SetNowFn(func() time.Time { return testJWTNow })
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.JWT = &handleroptions.PromWriteHandlerJWTOptions{
+					HMACSecret: testJWTSecret,
+					Issuer:     testJWTIssuer,
+				}
+				cfg.PromRemoteWrite.TenantMetrics = &handleroptions.PromWriteHandlerTenantMetricsOptions{
+					Header: testTenantMetricsHeader,
+				}
+			})
+
+			body := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+			if authorization := tt.authorization(t); authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, tt.expectedCode, writer.Result().StatusCode)
+
+			counters := scope.Snapshot().Counters()
+			if tt.expectedCode != http.StatusOK {
+				require.Empty(t, writtenOpts)
+				rejected, ok := counters["write.jwt-rejected+handler=remote-write,test=jwt-test"]
+				require.True(t, ok)
+				require.Equal(t, int64(1), rejected.Value())
+				return
+			}
+
+			require.Equal(t, []ingest.WriteOptions{tt.expectedOpts}, writtenOpts)
+			if tt.expectedTenant != "" {
+				_, ok := counters["write.success+handler=remote-write,path=override,"+
+					"tenant="+tt.expectedTenant+",test=jwt-test"]
+				require.True(t, ok)
+			}
+		})
+	}
+}
+
+func TestPromWriteJWTPublicKey(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetNowFn(func() time.Time { return testJWTNow })
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.JWT = &handleroptions.PromWriteHandlerJWTOptions{
+			PublicKeyPEM: string(publicKeyPEM),
+		}
+	})
+
+	rsaToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, newTestJWTClaims(nil)).
+		SignedString(key)
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		token        string
+		expectedCode int
+	}{
+		{token: rsaToken, expectedCode: http.StatusOK},
+		// Tokens signed with HMAC using the public key as the secret must
+		// not be accepted.
+		{token: func() string {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, newTestJWTClaims(nil)).
+				SignedString(publicKeyPEM)
+			require.NoError(t, err)
+			return token
+		}(), expectedCode: http.StatusUnauthorized},
+	} {
+		body := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		require.Equal(t, tt.expectedCode, writer.Result().StatusCode)
+	}
+}
+
+func TestNewJWTVerifierInvalid(t *testing.T) {
+	for _, opts := range []handleroptions.PromWriteHandlerJWTOptions{
+		{},
+		{HMACSecret: "secret", PublicKeyPEM: "key"},
+		{PublicKeyPEM: "not pem"},
+	} {
+		_, err := newJWTVerifier(opts, nil, time.Now)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+
+	"github.com/uber-go/tally"
+)
+
+var defaultLabelCountBuckets = tally.MustMakeLinearValueBuckets(0, 4, 17)
+
+// labelCountRecorder records the number of labels of each series in a
+// histogram.
+type labelCountRecorder struct {
+	labelsPerSeries tally.Histogram
+}
+
+func newLabelCountRecorder(
+	opts handleroptions.PromWriteHandlerLabelCountsOptions,
+	scope tally.Scope,
+) (*labelCountRecorder, error) {
+	buckets := tally.ValueBuckets(opts.Buckets)
+	if len(buckets) == 0 {
+		buckets = defaultLabelCountBuckets
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return nil, fmt.Errorf("label count buckets not increasing: "+
+				"index=%d, bucket=%g, previous=%g", i, buckets[i], buckets[i-1])
+		}
+	}
+
+	return &labelCountRecorder{
+		labelsPerSeries: scope.SubScope("write").Histogram("labels-per-series", buckets),
+	}, nil
+}
+
+// record records the number of labels of each series.
+func (r *labelCountRecorder) record(series []prompb.TimeSeries) {
+	for _, ts := range series {
+		r.labelsPerSeries.RecordValue(float64(len(ts.Labels)))
+	}
+}
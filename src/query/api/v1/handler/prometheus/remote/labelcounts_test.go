@@ -0,0 +1,121 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestLabelCountRecorderDefaultBuckets(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	recorder, err := newLabelCountRecorder(
+		handleroptions.PromWriteHandlerLabelCountsOptions{}, scope)
+	require.NoError(t, err)
+
+	recorder.record([]prompb.TimeSeries{
+		{Labels: testLabels("__name__", "a", "job", "b", "env", "c")},
+		{Labels: testLabels("__name__", "a", "job", "b", "env", "c", "instance", "d",
+			"region", "e")},
+	})
+
+	values, found := scope.Snapshot().Histograms()["write.labels-per-series+"]
+	require.True(t, found)
+	for upper, count := range values.Values() {
+		switch upper {
+		case 4, 8:
+			require.Equal(t, int64(1), count, "upper=%g", upper)
+		default:
+			require.Equal(t, int64(0), count, "upper=%g", upper)
+		}
+	}
+}
+
+func TestLabelCountRecorderBucketsNotIncreasing(t *testing.T) {
+	_, err := newLabelCountRecorder(handleroptions.PromWriteHandlerLabelCountsOptions{
+		Buckets: []float64{1, 4, 4},
+	}, tally.NoopScope)
+	require.EqualError(t, err, "label count buckets not increasing: "+
+		"index=2, bucket=4, previous=4")
+}
+
+func TestPromWriteLabelCounts(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil).
+		Times(1)
+
+	scope := tally.NewTestScope("", map[string]string{"test": "label-counts-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.LabelCounts = &handleroptions.PromWriteHandlerLabelCountsOptions{
+			Buckets: []float64{1, 2, 4, 8},
+		}
+	})
+
+	samples := []prompb.Sample{{Timestamp: 1000, Value: 1}}
+	promReqBody := test.GeneratePromWriteRequestBody(t, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{Labels: testLabels("__name__", "a"), Samples: samples},
+			{Labels: testLabels("__name__", "b", "job", "x", "env", "y"), Samples: samples},
+			{Labels: testLabels("__name__", "c", "job", "x", "env", "z"), Samples: samples},
+			{
+				Labels: testLabels("__name__", "d", "job", "x", "env", "y",
+					"instance", "i", "region", "r", "zone", "z"),
+				Samples: samples,
+			},
+		},
+	})
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	values, found := scope.Snapshot().Histograms()["write.labels-per-series+handler=remote-write,test=label-counts-test"]
+	require.True(t, found)
+	require.Equal(t, map[float64]int64{
+		1:               1,
+		2:               0,
+		4:               2,
+		8:               1,
+		math.MaxFloat64: 0,
+	}, values.Values())
+}
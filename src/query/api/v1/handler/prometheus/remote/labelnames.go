@@ -0,0 +1,139 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+
+	"github.com/uber-go/tally"
+)
+
+const defaultLabelNamePattern = "[a-zA-Z_][a-zA-Z0-9_]*"
+
+// labelNameValidator validates label names against a pattern, optionally
+// sanitizing invalid names.
+type labelNameValidator struct {
+	pattern   *regexp.Regexp
+	sanitize  bool
+	invalid   tally.Counter
+	sanitized tally.Counter
+}
+
+func newLabelNameValidator(
+	opts handleroptions.PromWriteHandlerLabelNameValidationOptions,
+	scope tally.Scope,
+) (*labelNameValidator, error) {
+	switch opts.Mode {
+	case "", handleroptions.PromWriteHandlerLabelNameValidationModeReject,
+		handleroptions.PromWriteHandlerLabelNameValidationModeSanitize:
+	default:
+		return nil, fmt.Errorf("unknown label name validation mode: %s", opts.Mode)
+	}
+
+	pattern := opts.Pattern
+	if pattern == "" {
+		pattern = defaultLabelNamePattern
+	}
+	// Anchor the pattern so that it must match the whole label name.
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid label name validation pattern: %w", err)
+	}
+
+	writeScope := scope.SubScope("write")
+	return &labelNameValidator{
+		pattern:   re,
+		sanitize:  opts.Mode == handleroptions.PromWriteHandlerLabelNameValidationModeSanitize,
+		invalid:   writeScope.Counter("invalid-label-names"),
+		sanitized: writeScope.Counter("sanitized-label-names"),
+	}, nil
+}
+
+// validate checks the label names of each series, returning an error for an
+// invalid label name unless it can be sanitized without a collision.
+func (v *labelNameValidator) validate(series []prompb.TimeSeries) error {
+	for i := range series {
+		if err := v.validateLabels(series[i].Labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *labelNameValidator) validateLabels(labels []prompb.Label) error {
+	var sanitized []int
+	for i, l := range labels {
+		if v.pattern.Match(l.Name) {
+			continue
+		}
+
+		v.invalid.Inc(1)
+		if !v.sanitize {
+			return fmt.Errorf("invalid label name: name=%s", l.Name)
+		}
+
+		// NB: Allocate the sanitized name since the label name may alias the
+		// request body.
+		name := sanitizeLabelName(l.Name)
+		if !v.pattern.Match(name) {
+			return fmt.Errorf("invalid label name after sanitizing: name=%s, sanitized=%s",
+				l.Name, name)
+		}
+		labels[i].Name = name
+		sanitized = append(sanitized, i)
+	}
+
+	for _, i := range sanitized {
+		for j := range labels {
+			if i != j && bytes.Equal(labels[i].Name, labels[j].Name) {
+				return fmt.Errorf("sanitized label name collides with another label: "+
+					"sanitized=%s", labels[i].Name)
+			}
+		}
+	}
+
+	v.sanitized.Inc(int64(len(sanitized)))
+	return nil
+}
+
+// sanitizeLabelName returns a copy of the label name with characters outside
+// of [a-zA-Z0-9_] replaced with underscores, prefixed with an underscore if
+// it starts with a digit.
+func sanitizeLabelName(name []byte) []byte {
+	sanitized := make([]byte, 0, len(name)+1)
+	if len(name) == 0 || (name[0] >= '0' && name[0] <= '9') {
+		sanitized = append(sanitized, '_')
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_':
+			sanitized = append(sanitized, c)
+		default:
+			sanitized = append(sanitized, '_')
+		}
+	}
+	return sanitized
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestSanitizeLabelName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "valid_name", expected: "valid_name"},
+		{name: "with-dash.and.dots", expected: "with_dash_and_dots"},
+		{name: "1starts_with_digit", expected: "_1starts_with_digit"},
+		{name: "ünicode", expected: "__nicode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, string(sanitizeLabelName([]byte(tt.name))))
+		})
+	}
+}
+
+func TestLabelNameValidator(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        handleroptions.PromWriteHandlerLabelNameValidationMode
+		labels      []prompb.Label
+		expected    []prompb.Label
+		expectedErr string
+	}{
+		{
+			name:     "valid",
+			labels:   testLabels("__name__", "up", "job", "api", "Instance_1", "a"),
+			expected: testLabels("__name__", "up", "job", "api", "Instance_1", "a"),
+		},
+		{
+			name:        "invalid chars reject",
+			labels:      testLabels("__name__", "up", "k8s.pod", "a"),
+			expectedErr: "invalid label name: name=k8s.pod",
+		},
+		{
+			name:        "leading digit reject",
+			labels:      testLabels("__name__", "up", "1job", "a"),
+			expectedErr: "invalid label name: name=1job",
+		},
+		{
+			name:     "invalid chars sanitize",
+			mode:     handleroptions.PromWriteHandlerLabelNameValidationModeSanitize,
+			labels:   testLabels("__name__", "up", "k8s.pod", "a", "1job", "b"),
+			expected: testLabels("__name__", "up", "k8s_pod", "a", "_1job", "b"),
+		},
+		{
+			name:        "sanitize collision",
+			mode:        handleroptions.PromWriteHandlerLabelNameValidationModeSanitize,
+			labels:      testLabels("__name__", "up", "k8s_pod", "a", "k8s.pod", "b"),
+			expectedErr: "sanitized label name collides with another label: sanitized=k8s_pod",
+		},
+		{
+			name:        "sanitize collision between sanitized names",
+			mode:        handleroptions.PromWriteHandlerLabelNameValidationModeSanitize,
+			labels:      testLabels("__name__", "up", "k8s-pod", "a", "k8s.pod", "b"),
+			expectedErr: "sanitized label name collides with another label: sanitized=k8s_pod",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator, err := newLabelNameValidator(handleroptions.PromWriteHandlerLabelNameValidationOptions{
+				Mode: tt.mode,
+			}, tally.NoopScope)
+			require.NoError(t, err)
+
+			series := []prompb.TimeSeries{{Labels: tt.labels}}
+			err = validator.validate(series)
+			if tt.expectedErr != "" {
+				require.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, series[0].Labels)
+		})
+	}
+}
+
+func TestLabelNameValidatorCustomPattern(t *testing.T) {
+	validator, err := newLabelNameValidator(handleroptions.PromWriteHandlerLabelNameValidationOptions{
+		Pattern: "[a-z_]+",
+		Mode:    handleroptions.PromWriteHandlerLabelNameValidationModeSanitize,
+	}, tally.NoopScope)
+	require.NoError(t, err)
+
+	// The pattern must match the whole label name.
+	require.NoError(t, validator.validate([]prompb.TimeSeries{
+		{Labels: testLabels("__name__", "up", "job", "a")},
+	}))
+	require.EqualError(t, validator.validate([]prompb.TimeSeries{
+		{Labels: testLabels("__name__", "up", "Job", "a")},
+	}), "invalid label name after sanitizing: name=Job, sanitized=Job")
+}
+
+func TestLabelNameValidatorInvalidOptions(t *testing.T) {
+	_, err := newLabelNameValidator(handleroptions.PromWriteHandlerLabelNameValidationOptions{
+		Mode: "fix",
+	}, tally.NoopScope)
+	require.EqualError(t, err, "unknown label name validation mode: fix")
+
+	_, err = newLabelNameValidator(handleroptions.PromWriteHandlerLabelNameValidationOptions{
+		Pattern: "[a-z",
+	}, tally.NoopScope)
+	require.Error(t, err)
+}
+
+func TestPromWriteLabelNameValidation(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var written []prompb.Label
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			require.True(t, iter.Next())
+			for _, tag := range iter.Current().Tags.Tags {
+				written = append(written, prompb.Label{Name: tag.Name, Value: tag.Value})
+			}
+			require.False(t, iter.Next())
+			return nil
+		})
+
+	scope := tally.NewTestScope("", map[string]string{"test": "label-name-validation-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.LabelNameValidation = &handleroptions.PromWriteHandlerLabelNameValidationOptions{
+			Mode: handleroptions.PromWriteHandlerLabelNameValidationModeSanitize,
+		}
+	})
+
+	writeLabels := func(labels []prompb.Label) int {
+		promReq := &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels:  labels,
+					Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+				},
+			},
+		}
+		promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		return writer.Result().StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, writeLabels(testLabels("__name__", "up", "k8s.pod", "a")))
+	require.Equal(t, testLabels("__name__", "up", "k8s_pod", "a"), written)
+
+	require.Equal(t, http.StatusBadRequest,
+		writeLabels(testLabels("__name__", "up", "k8s_pod", "a", "k8s.pod", "b")))
+
+	counters := scope.Snapshot().Counters()
+	invalid, ok := counters["write.invalid-label-names+handler=remote-write,test=label-name-validation-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(2), invalid.Value())
+	sanitized, ok := counters["write.sanitized-label-names+handler=remote-write,test=label-name-validation-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), sanitized.Value())
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+)
+
+var (
+	errLabelSplitNoLabel        = errors.New("label split must have a label")
+	errLabelSplitNoDelimiter    = errors.New("label split must have a delimiter")
+	errLabelSplitNoTargetLabels = errors.New("label split must have target labels")
+)
+
+// labelSplit splits the value of a label into several labels.
+type labelSplit struct {
+	label        []byte
+	delimiter    []byte
+	targetLabels [][]byte
+}
+
+func newLabelSplits(opts []handleroptions.PromWriteHandlerLabelSplitOptions) ([]labelSplit, error) {
+	splits := make([]labelSplit, 0, len(opts))
+	for _, v := range opts {
+		switch {
+		case v.Label == "":
+			return nil, errLabelSplitNoLabel
+		case v.Delimiter == "":
+			return nil, errLabelSplitNoDelimiter
+		case len(v.TargetLabels) == 0:
+			return nil, errLabelSplitNoTargetLabels
+		}
+
+		split := labelSplit{
+			label:        []byte(v.Label),
+			delimiter:    []byte(v.Delimiter),
+			targetLabels: make([][]byte, 0, len(v.TargetLabels)),
+		}
+		seen := make(map[string]struct{}, len(v.TargetLabels))
+		for _, target := range v.TargetLabels {
+			if target == "" {
+				return nil, fmt.Errorf("label split of %s has an empty target label", v.Label)
+			}
+			if _, ok := seen[target]; ok {
+				return nil, fmt.Errorf("label split of %s has duplicate target label: %s",
+					v.Label, target)
+			}
+			seen[target] = struct{}{}
+			split.targetLabels = append(split.targetLabels, []byte(target))
+		}
+		splits = append(splits, split)
+	}
+	return splits, nil
+}
+
+// splitLabels applies the label splits to each series.
+func (h *PromWriteHandler) splitLabels(series []prompb.TimeSeries) error {
+	for _, split := range h.labelSplits {
+		for i := range series {
+			labels, err := split.apply(series[i].Labels)
+			if err != nil {
+				return err
+			}
+			series[i].Labels = labels
+		}
+	}
+	return nil
+}
+
+// apply replaces the split label with the target labels, returning the
+// labels as is if the series does not carry the split label.
+func (s labelSplit) apply(labels []prompb.Label) ([]prompb.Label, error) {
+	idx := -1
+	for i, l := range labels {
+		if bytes.Equal(l.Name, s.label) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return labels, nil
+	}
+
+	values := bytes.SplitN(labels[idx].Value, s.delimiter, len(s.targetLabels))
+	result := make([]prompb.Label, 0, len(labels)+len(values)-1)
+	result = append(result, labels[:idx]...)
+	result = append(result, labels[idx+1:]...)
+	for i, value := range values {
+		if len(value) == 0 {
+			continue
+		}
+		if hasLabelName(result, s.targetLabels[i]) {
+			return nil, fmt.Errorf("label split of %s target label already exists: %s",
+				s.label, s.targetLabels[i])
+		}
+		result = append(result, prompb.Label{
+			Name:  s.targetLabels[i],
+			Value: value,
+		})
+	}
+	return result, nil
+}
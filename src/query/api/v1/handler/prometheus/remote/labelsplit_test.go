@@ -0,0 +1,152 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromWriteLabelSplits(t *testing.T) {
+	split := handleroptions.PromWriteHandlerLabelSplitOptions{
+		Label:        "counter_ts",
+		Delimiter:    "@",
+		TargetLabels: []string{"counter", "ts"},
+	}
+
+	tests := []struct {
+		name        string
+		labels      []prompb.Label
+		expected    []prompb.Label
+		expectedErr string
+	}{
+		{
+			name:     "with delimiter",
+			labels:   testLabels("__name__", "up", "counter_ts", "42@1700000000", "a", "1"),
+			expected: testLabels("__name__", "up", "a", "1", "counter", "42", "ts", "1700000000"),
+		},
+		{
+			name:     "without delimiter",
+			labels:   testLabels("__name__", "up", "counter_ts", "42"),
+			expected: testLabels("__name__", "up", "counter", "42"),
+		},
+		{
+			name:     "multiple delimiters",
+			labels:   testLabels("__name__", "up", "counter_ts", "42@1700000000@extra"),
+			expected: testLabels("__name__", "up", "counter", "42", "ts", "1700000000@extra"),
+		},
+		{
+			name:     "empty values omitted",
+			labels:   testLabels("__name__", "up", "counter_ts", "@1700000000"),
+			expected: testLabels("__name__", "up", "ts", "1700000000"),
+		},
+		{
+			name:     "label absent",
+			labels:   testLabels("__name__", "up", "a", "1"),
+			expected: testLabels("__name__", "up", "a", "1"),
+		},
+		{
+			name:        "target label exists",
+			labels:      testLabels("__name__", "up", "counter_ts", "42@1700000000", "ts", "1"),
+			expectedErr: "label split of counter_ts target label already exists: ts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.LabelSplits = []handleroptions.PromWriteHandlerLabelSplitOptions{split}
+			})
+
+			series := []prompb.TimeSeries{{Labels: tt.labels}}
+			err := handler.splitLabels(series)
+			if tt.expectedErr != "" {
+				require.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, series[0].Labels)
+		})
+	}
+}
+
+func TestPromWriteLabelSplitsInvalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		split       handleroptions.PromWriteHandlerLabelSplitOptions
+		expectedErr string
+	}{
+		{
+			name:        "no label",
+			split:       handleroptions.PromWriteHandlerLabelSplitOptions{Delimiter: "@", TargetLabels: []string{"a"}},
+			expectedErr: errLabelSplitNoLabel.Error(),
+		},
+		{
+			name:        "no delimiter",
+			split:       handleroptions.PromWriteHandlerLabelSplitOptions{Label: "a", TargetLabels: []string{"b"}},
+			expectedErr: errLabelSplitNoDelimiter.Error(),
+		},
+		{
+			name:        "no target labels",
+			split:       handleroptions.PromWriteHandlerLabelSplitOptions{Label: "a", Delimiter: "@"},
+			expectedErr: errLabelSplitNoTargetLabels.Error(),
+		},
+		{
+			name: "empty target label",
+			split: handleroptions.PromWriteHandlerLabelSplitOptions{
+				Label: "a", Delimiter: "@", TargetLabels: []string{"b", ""},
+			},
+			expectedErr: "label split of a has an empty target label",
+		},
+		{
+			name: "duplicate target label",
+			split: handleroptions.PromWriteHandlerLabelSplitOptions{
+				Label: "a", Delimiter: "@", TargetLabels: []string{"b", "b"},
+			},
+			expectedErr: "label split of a has duplicate target label: b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+			cfg := opts.Config()
+			cfg.PromRemoteWrite.LabelSplits = []handleroptions.PromWriteHandlerLabelSplitOptions{tt.split}
+			_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+			require.EqualError(t, err, tt.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+
+	"github.com/uber-go/tally"
+)
+
+// labelTrimmer trims leading and trailing whitespace from label values and
+// optionally names.
+type labelTrimmer struct {
+	names   bool
+	trimmed tally.Counter
+}
+
+func newLabelTrimmer(
+	opts handleroptions.PromWriteHandlerLabelTrimmingOptions,
+	scope tally.Scope,
+) *labelTrimmer {
+	return &labelTrimmer{
+		names:   opts.Names,
+		trimmed: scope.SubScope("write").Counter("trimmed-labels"),
+	}
+}
+
+// trim trims the labels of each series in place, returning an error if a
+// trimmed label name collides with another label name of its series.
+func (t *labelTrimmer) trim(series []prompb.TimeSeries) error {
+	var numTrimmed int64
+	defer func() {
+		t.trimmed.Inc(numTrimmed)
+	}()
+
+	for i := range series {
+		labels := series[i].Labels
+		for j := range labels {
+			l := &labels[j]
+			trimmed := false
+			if v := bytes.TrimSpace(l.Value); len(v) != len(l.Value) {
+				l.Value = v
+				trimmed = true
+			}
+			if t.names {
+				if name := bytes.TrimSpace(l.Name); len(name) != len(l.Name) {
+					if hasLabelName(labels, name) {
+						return fmt.Errorf("trimmed label name collides with another "+
+							"label: name=%q, labels=%s", l.Name, formatPromLabels(labels))
+					}
+					l.Name = name
+					trimmed = true
+				}
+			}
+			if trimmed {
+				numTrimmed++
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestLabelTrimmer(t *testing.T) {
+	tests := []struct {
+		name        string
+		names       bool
+		labels      []prompb.Label
+		expected    []prompb.Label
+		expectedErr string
+	}{
+		{
+			name:     "leading and trailing spaces",
+			labels:   testLabels("__name__", "up", "region", " us-east \t", "zone", "\na"),
+			expected: testLabels("__name__", "up", "region", "us-east", "zone", "a"),
+		},
+		{
+			name:     "internal spaces untouched",
+			labels:   testLabels("__name__", "up", "region", "us east", "job", " my  job "),
+			expected: testLabels("__name__", "up", "region", "us east", "job", "my  job"),
+		},
+		{
+			name:     "names untouched by default",
+			labels:   testLabels("__name__", "up", " region ", " us-east"),
+			expected: testLabels("__name__", "up", " region ", "us-east"),
+		},
+		{
+			name:     "names",
+			names:    true,
+			labels:   testLabels("__name__", "up", " region ", " us-east"),
+			expected: testLabels("__name__", "up", "region", "us-east"),
+		},
+		{
+			name:        "names collision",
+			names:       true,
+			labels:      testLabels("__name__", "up", "region", "a", "region ", "b"),
+			expectedErr: `trimmed label name collides with another label: name="region ", labels={__name__="up",region="a",region ="b"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trimmer := newLabelTrimmer(handleroptions.PromWriteHandlerLabelTrimmingOptions{
+				Names: tt.names,
+			}, tally.NoopScope)
+
+			series := []prompb.TimeSeries{{Labels: tt.labels}}
+			err := trimmer.trim(series)
+			if tt.expectedErr != "" {
+				require.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, series[0].Labels)
+		})
+	}
+}
+
+func TestPromWriteLabelTrimming(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var written []prompb.Label
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			require.True(t, iter.Next())
+			for _, tag := range iter.Current().Tags.Tags {
+				written = append(written, prompb.Label{Name: tag.Name, Value: tag.Value})
+			}
+			require.False(t, iter.Next())
+			return nil
+		})
+
+	scope := tally.NewTestScope("", map[string]string{"test": "label-trimming-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.LabelTrimming = &handleroptions.PromWriteHandlerLabelTrimmingOptions{
+			Names: true,
+		}
+	})
+
+	writeLabels := func(labels []prompb.Label) int {
+		promReq := &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels:  labels,
+					Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+				},
+			},
+		}
+		promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		return writer.Result().StatusCode
+	}
+
+	require.Equal(t, http.StatusOK,
+		writeLabels(testLabels("__name__", "up", "region ", " us-east ")))
+	require.Equal(t, testLabels("__name__", "up", "region", "us-east"), written)
+
+	require.Equal(t, http.StatusBadRequest,
+		writeLabels(testLabels("__name__", "up", "region", "a", " region", "b")))
+
+	trimmed, ok := scope.Snapshot().Counters()["write.trimmed-labels+handler=remote-write,test=label-trimming-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), trimmed.Value())
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"net/http"
+	"unsafe"
+
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/query/models"
+	"github.com/m3db/m3/src/query/ts"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+)
+
+const (
+	// promTSIterSeriesBytes is the size of the tags, datapoints and
+	// attributes preallocated per series by the write iterator.
+	promTSIterSeriesBytes = int64(unsafe.Sizeof(models.Tags{}) +
+		unsafe.Sizeof(ts.Datapoints{}) + unsafe.Sizeof(ts.SeriesAttributes{}))
+	promTSIterLabelBytes  = int64(unsafe.Sizeof(models.Tag{}))
+	promTSIterSampleBytes = int64(unsafe.Sizeof(ts.Datapoint{}))
+)
+
+// estimatePromTSIterBytes estimates the memory allocated by the write
+// iterator for the series, the label literals are not counted since the
+// tags reference the request rather than copying them.
+func estimatePromTSIterBytes(series []prompb.TimeSeries) int64 {
+	total := int64(len(series)) * promTSIterSeriesBytes
+	for _, s := range series {
+		total += int64(len(s.Labels))*promTSIterLabelBytes +
+			int64(len(s.Samples))*promTSIterSampleBytes
+	}
+	return total
+}
+
+// checkMemoryBudget returns a request entity too large error if the memory
+// estimated to be allocated writing the request exceeds the budget.
+func (h *PromWriteHandler) checkMemoryBudget(req *prompb.WriteRequest) error {
+	budget := h.handlerOpts.MaxRequestMemoryBytes
+	if budget <= 0 {
+		return nil
+	}
+
+	estimate := estimatePromTSIterBytes(req.Timeseries)
+	if estimate <= budget {
+		return nil
+	}
+
+	h.metrics.memoryBudgetExceeded.Inc(1)
+	return xhttp.NewError(fmt.Errorf("request exceeds memory budget: "+
+		"estimate=%d, budget=%d", estimate, budget), http.StatusRequestEntityTooLarge)
+}
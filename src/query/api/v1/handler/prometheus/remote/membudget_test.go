@@ -0,0 +1,111 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestEstimatePromTSIterBytes(t *testing.T) {
+	series := []prompb.TimeSeries{
+		{
+			Labels:  testLabels("__name__", "first", "job", "a"),
+			Samples: make([]prompb.Sample, 3),
+		},
+		{
+			Labels:  testLabels("__name__", "second"),
+			Samples: make([]prompb.Sample, 1),
+		},
+	}
+	expected := 2*promTSIterSeriesBytes + 3*promTSIterLabelBytes + 4*promTSIterSampleBytes
+	require.Equal(t, expected, estimatePromTSIterBytes(series))
+	require.Equal(t, int64(0), estimatePromTSIterBytes(nil))
+}
+
+func TestPromWriteMemoryBudget(t *testing.T) {
+	promReq := test.GeneratePromWriteRequest()
+	estimate := estimatePromTSIterBytes(promReq.Timeseries)
+
+	tests := []struct {
+		name           string
+		budget         int64
+		expectedStatus int
+	}{
+		{
+			name:           "under budget",
+			budget:         estimate,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "over budget",
+			budget:         estimate - 1,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedStatus == http.StatusOK {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+			}
+
+			scope := tally.NewTestScope("", map[string]string{"test": "memory-budget-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.MaxRequestMemoryBytes = tt.budget
+			})
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, tt.expectedStatus, writer.Result().StatusCode)
+
+			var expectedExceeded int64
+			if tt.expectedStatus != http.StatusOK {
+				expectedExceeded = 1
+			}
+			exceeded, ok := scope.Snapshot().Counters()["write.memory-budget-exceeded+handler=remote-write,test=memory-budget-test"]
+			require.True(t, ok)
+			require.Equal(t, expectedExceeded, exceeded.Value())
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/options"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// promWriteRequestMetadataField is the metadata field of a write request,
+	// which M3 does not decode as part of the request.
+	promWriteRequestMetadataField protowire.Number = 3
+
+	promMetricMetadataTypeField             protowire.Number = 1
+	promMetricMetadataMetricFamilyNameField protowire.Number = 2
+	promMetricMetadataHelpField             protowire.Number = 4
+	promMetricMetadataUnitField             protowire.Number = 5
+)
+
+// decodePromMetadata decodes the metric metadata of an uncompressed write
+// request body, returning nil if the request carries no metadata.
+func decodePromMetadata(body []byte) ([]options.PromWriteMetricMetadata, error) {
+	var metadata []options.PromWriteMetricMetadata
+	for b := body; len(b) > 0; {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if num != promWriteRequestMetadataField || typ != protowire.BytesType {
+			m := protowire.ConsumeFieldValue(num, typ, b)
+			if m < 0 {
+				return nil, protowire.ParseError(m)
+			}
+			b = b[m:]
+			continue
+		}
+
+		value, m := protowire.ConsumeBytes(b)
+		if m < 0 {
+			return nil, protowire.ParseError(m)
+		}
+		b = b[m:]
+
+		decoded, err := decodePromMetricMetadata(value)
+		if err != nil {
+			return nil, err
+		}
+		metadata = append(metadata, decoded)
+	}
+	return metadata, nil
+}
+
+func decodePromMetricMetadata(b []byte) (options.PromWriteMetricMetadata, error) {
+	var metadata options.PromWriteMetricMetadata
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return options.PromWriteMetricMetadata{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == promMetricMetadataTypeField && typ == protowire.VarintType:
+			value, m := protowire.ConsumeVarint(b)
+			if m < 0 {
+				return options.PromWriteMetricMetadata{}, protowire.ParseError(m)
+			}
+			metadata.Type = prompb.MetricType(value)
+			n = m
+		case typ == protowire.BytesType &&
+			(num == promMetricMetadataMetricFamilyNameField ||
+				num == promMetricMetadataHelpField ||
+				num == promMetricMetadataUnitField):
+			value, m := protowire.ConsumeString(b)
+			if m < 0 {
+				return options.PromWriteMetricMetadata{}, protowire.ParseError(m)
+			}
+			switch num {
+			case promMetricMetadataMetricFamilyNameField:
+				metadata.MetricFamilyName = value
+			case promMetricMetadataHelpField:
+				metadata.Help = value
+			default:
+				metadata.Unit = value
+			}
+			n = m
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return options.PromWriteMetricMetadata{}, protowire.ParseError(n)
+			}
+		}
+		b = b[n:]
+	}
+	return metadata, nil
+}
+
+// writeMetadata routes the metric metadata sent with a request according to
+// the configured metadata mode.
+func (h *PromWriteHandler) writeMetadata(
+	ctx context.Context,
+	metadata []options.PromWriteMetricMetadata,
+) error {
+	if h.handlerOpts.Metadata != handleroptions.PromWriteHandlerMetadataModeStore {
+		h.metrics.metadataIgnored.Inc(int64(len(metadata)))
+		return nil
+	}
+
+	if err := h.metadataStore.WriteMetadata(ctx, metadata); err != nil {
+		h.metrics.metadataErrors.Inc(1)
+		return err
+	}
+	h.metrics.metadataStored.Inc(int64(len(metadata)))
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/options"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/golang/snappy"
+	promprompb "github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+type testMetadataStore struct {
+	written [][]options.PromWriteMetricMetadata
+	err     error
+}
+
+func (s *testMetadataStore) WriteMetadata(
+	_ context.Context,
+	metadata []options.PromWriteMetricMetadata,
+) error {
+	s.written = append(s.written, metadata)
+	return s.err
+}
+
+var (
+	testPromMetadata = []promprompb.MetricMetadata{
+		{
+			Type:             promprompb.MetricMetadata_COUNTER,
+			MetricFamilyName: "http_requests_total",
+			Help:             "Total HTTP requests.",
+		},
+		{
+			Type:             promprompb.MetricMetadata_GAUGE,
+			MetricFamilyName: "memory_usage",
+			Help:             "Memory usage.",
+			Unit:             "bytes",
+		},
+	}
+	testExpectedMetadata = []options.PromWriteMetricMetadata{
+		{
+			Type:             prompb.MetricType_COUNTER,
+			MetricFamilyName: "http_requests_total",
+			Help:             "Total HTTP requests.",
+		},
+		{
+			Type:             prompb.MetricType_GAUGE,
+			MetricFamilyName: "memory_usage",
+			Help:             "Memory usage.",
+			Unit:             "bytes",
+		},
+	}
+	testPromMetadataSeries = []promprompb.TimeSeries{
+		{
+			Labels:  []promprompb.Label{{Name: "__name__", Value: "memory_usage"}},
+			Samples: []promprompb.Sample{{Value: 1, Timestamp: 1600000000000}},
+		},
+	}
+)
+
+func TestDecodePromMetadata(t *testing.T) {
+	body, err := (&promprompb.WriteRequest{
+		Timeseries: testPromMetadataSeries,
+		Metadata:   testPromMetadata,
+	}).Marshal()
+	require.NoError(t, err)
+
+	metadata, err := decodePromMetadata(body)
+	require.NoError(t, err)
+	require.Equal(t, testExpectedMetadata, metadata)
+
+	body, err = (&promprompb.WriteRequest{Timeseries: testPromMetadataSeries}).Marshal()
+	require.NoError(t, err)
+
+	metadata, err = decodePromMetadata(body)
+	require.NoError(t, err)
+	require.Nil(t, metadata)
+
+	_, err = decodePromMetadata([]byte{0x1a, 0x10})
+	require.Error(t, err)
+}
+
+func TestPromWriteMetadata(t *testing.T) {
+	tests := []struct {
+		name             string
+		mode             handleroptions.PromWriteHandlerMetadataMode
+		series           []promprompb.TimeSeries
+		metadata         []promprompb.MetricMetadata
+		expectedWrite    bool
+		expectedStored   [][]options.PromWriteMetricMetadata
+		expectedCounters map[string]int64
+	}{
+		{
+			name:           "metadata only",
+			mode:           handleroptions.PromWriteHandlerMetadataModeStore,
+			metadata:       testPromMetadata,
+			expectedStored: [][]options.PromWriteMetricMetadata{testExpectedMetadata},
+			expectedCounters: map[string]int64{
+				"write.metadata-only+handler=remote-write":   1,
+				"write.metadata.stored+handler=remote-write": 2,
+			},
+		},
+		{
+			name:          "data only",
+			mode:          handleroptions.PromWriteHandlerMetadataModeStore,
+			series:        testPromMetadataSeries,
+			expectedWrite: true,
+			expectedCounters: map[string]int64{
+				"write.success+handler=remote-write,path=rules": 1,
+			},
+		},
+		{
+			name:           "mixed",
+			mode:           handleroptions.PromWriteHandlerMetadataModeStore,
+			series:         testPromMetadataSeries,
+			metadata:       testPromMetadata,
+			expectedWrite:  true,
+			expectedStored: [][]options.PromWriteMetricMetadata{testExpectedMetadata},
+			expectedCounters: map[string]int64{
+				"write.metadata.stored+handler=remote-write":    2,
+				"write.success+handler=remote-write,path=rules": 1,
+			},
+		},
+		{
+			name:     "metadata only noop",
+			metadata: testPromMetadata,
+			expectedCounters: map[string]int64{
+				"write.metadata-only+handler=remote-write":    1,
+				"write.metadata.ignored+handler=remote-write": 2,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedWrite {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+			}
+
+			store := &testMetadataStore{}
+			scope := tally.NewTestScope("", nil)
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetPromWriteMetadataStore(store).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.Metadata = tt.mode
+			})
+
+			body, err := (&promprompb.WriteRequest{
+				Timeseries: tt.series,
+				Metadata:   tt.metadata,
+			}).Marshal()
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+				bytes.NewReader(snappy.Encode(nil, body)))
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+			require.Equal(t, tt.expectedStored, store.written)
+
+			counters := scope.Snapshot().Counters()
+			for name, expected := range tt.expectedCounters {
+				counter, ok := counters[name]
+				require.True(t, ok, name)
+				require.Equal(t, expected, counter.Value(), name)
+			}
+		})
+	}
+}
+
+func TestPromWriteMetadataStoreError(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	store := &testMetadataStore{err: errors.New("store failed")}
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)).
+		SetPromWriteMetadataStore(store)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.Metadata = handleroptions.PromWriteHandlerMetadataModeStore
+	})
+
+	body, err := (&promprompb.WriteRequest{
+		Timeseries: testPromMetadataSeries,
+		Metadata:   testPromMetadata,
+	}).Marshal()
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+		bytes.NewReader(snappy.Encode(nil, body)))
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusInternalServerError, writer.Result().StatusCode)
+}
+
+func TestPromWriteMetadataInvalidOptions(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.Metadata = handleroptions.PromWriteHandlerMetadataModeStore
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.Equal(t, errNoMetadataStore, err)
+
+	cfg.PromRemoteWrite.Metadata = "unknown"
+	_, err = NewPromWriteHandler(opts.SetConfig(cfg))
+	require.EqualError(t, err, "unknown metadata mode: unknown")
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/cache"
+	"github.com/m3db/m3/src/x/clock"
+
+	"github.com/uber-go/tally"
+)
+
+const (
+	defaultMonotonicTimestampsMaxSeries = 100000
+	defaultMonotonicTimestampsTTL       = time.Hour
+)
+
+// nonMonotonicReason is why a sample timestamp did not advance past the last
+// timestamp written for its series.
+type nonMonotonicReason string
+
+const (
+	nonMonotonicReasonEqual      nonMonotonicReason = "equal"
+	nonMonotonicReasonRegressing nonMonotonicReason = "regressing"
+)
+
+// monotonicTimestamps remembers the last timestamp written for each matching
+// series, bounded by an LRU, to check that the samples of later requests
+// advance past it.
+type monotonicTimestamps struct {
+	mode           handleroptions.PromWriteHandlerMonotonicTimestampsMode
+	matchLabels    []prompb.Label
+	lastTimestamps *cache.LRU
+	rejected       map[nonMonotonicReason]tally.Counter
+	dropped        map[nonMonotonicReason]tally.Counter
+}
+
+func newMonotonicTimestamps(
+	opts handleroptions.PromWriteHandlerMonotonicTimestampsOptions,
+	nowFn clock.NowFn,
+	scope tally.Scope,
+) (*monotonicTimestamps, error) {
+	switch opts.Mode {
+	case "", handleroptions.PromWriteHandlerMonotonicTimestampsModeReject,
+		handleroptions.PromWriteHandlerMonotonicTimestampsModeDrop:
+	default:
+		return nil, fmt.Errorf("unknown monotonic timestamps mode: %s", opts.Mode)
+	}
+
+	maxSeries := defaultMonotonicTimestampsMaxSeries
+	if opts.MaxSeries > 0 {
+		maxSeries = opts.MaxSeries
+	}
+
+	ttl := defaultMonotonicTimestampsTTL
+	if opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+
+	var (
+		subScope = scope.SubScope("write").SubScope("non-monotonic-samples")
+		rejected = make(map[nonMonotonicReason]tally.Counter)
+		dropped  = make(map[nonMonotonicReason]tally.Counter)
+	)
+	for _, reason := range []nonMonotonicReason{
+		nonMonotonicReasonEqual,
+		nonMonotonicReasonRegressing,
+	} {
+		tagged := subScope.Tagged(map[string]string{"reason": string(reason)})
+		rejected[reason] = tagged.Counter("rejected")
+		dropped[reason] = tagged.Counter("dropped")
+	}
+
+	return &monotonicTimestamps{
+		mode:        opts.Mode,
+		matchLabels: newMatchLabels(opts.MatchLabels),
+		lastTimestamps: cache.NewLRU(&cache.LRUOptions{
+			TTL:        ttl,
+			MaxEntries: maxSeries,
+			Metrics:    scope.SubScope("monotonic-timestamps"),
+			Now:        nowFn,
+		}),
+		rejected: rejected,
+		dropped:  dropped,
+	}, nil
+}
+
+// check verifies that the samples of every matching series advance past the
+// last timestamp written for the series, either rejecting the request or
+// dropping the samples that do not. Series left without samples are dropped.
+func (m *monotonicTimestamps) check(req *prompb.WriteRequest) error {
+	var (
+		kept   = req.Timeseries[:0]
+		buffer []prompb.Label
+		id     []byte
+	)
+	for _, ts := range req.Timeseries {
+		if !matchesLabels(ts.Labels, m.matchLabels) {
+			kept = append(kept, ts)
+			continue
+		}
+
+		buffer = append(buffer[:0], ts.Labels...)
+		id = buildPseudoIDWithLabelsLikelySorted(buffer, id[:0])
+		value, ok := m.lastTimestamps.TryGet(string(id))
+		if !ok {
+			kept = append(kept, ts)
+			continue
+		}
+
+		var (
+			last    = value.(int64)
+			samples = ts.Samples[:0]
+		)
+		for _, sample := range ts.Samples {
+			if sample.Timestamp > last {
+				last = sample.Timestamp
+				samples = append(samples, sample)
+				continue
+			}
+
+			reason := nonMonotonicReasonRegressing
+			if sample.Timestamp == last {
+				reason = nonMonotonicReasonEqual
+			}
+			if m.mode != handleroptions.PromWriteHandlerMonotonicTimestampsModeDrop {
+				m.rejected[reason].Inc(1)
+				return fmt.Errorf("sample timestamp not advancing: "+
+					"reason=%s, series=%s, timestamp=%d, last=%d",
+					reason, id, sample.Timestamp, last)
+			}
+			m.dropped[reason].Inc(1)
+		}
+
+		if len(samples) > 0 {
+			ts.Samples = samples
+			kept = append(kept, ts)
+		}
+	}
+	req.Timeseries = kept
+	return nil
+}
+
+// record remembers the last timestamp of every matching series once the
+// request is written, so that failed writes can be retried.
+func (m *monotonicTimestamps) record(req *prompb.WriteRequest) {
+	var (
+		buffer []prompb.Label
+		id     []byte
+	)
+	for _, ts := range req.Timeseries {
+		if len(ts.Samples) == 0 || !matchesLabels(ts.Labels, m.matchLabels) {
+			continue
+		}
+
+		last := ts.Samples[0].Timestamp
+		for _, sample := range ts.Samples[1:] {
+			if sample.Timestamp > last {
+				last = sample.Timestamp
+			}
+		}
+
+		buffer = append(buffer[:0], ts.Labels...)
+		id = buildPseudoIDWithLabelsLikelySorted(buffer, id[:0])
+		key := string(id)
+		if value, ok := m.lastTimestamps.TryGet(key); ok && value.(int64) >= last {
+			continue
+		}
+		m.lastTimestamps.Put(key, last)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func newMonotonicTimestampsTestRequest(timestamps ...int64) *prompb.WriteRequest {
+	samples := make([]prompb.Sample, 0, len(timestamps))
+	for _, timestamp := range timestamps {
+		samples = append(samples, prompb.Sample{Timestamp: timestamp, Value: 1})
+	}
+	return &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  testLabels("__name__", "append_only", "kind", "append-only"),
+				Samples: samples,
+			},
+			{
+				Labels:  testLabels("__name__", "other"),
+				Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+			},
+		},
+	}
+}
+
+func TestPromWriteMonotonicTimestamps(t *testing.T) {
+	tests := []struct {
+		name               string
+		mode               handleroptions.PromWriteHandlerMonotonicTimestampsMode
+		timestamps         []int64
+		expectedCode       int
+		expectedTimestamps []int64
+		expectedCounter    string
+	}{
+		{
+			name:               "advancing",
+			timestamps:         []int64{1001, 1002},
+			expectedCode:       http.StatusOK,
+			expectedTimestamps: []int64{1001, 1002},
+		},
+		{
+			name:            "equal rejected",
+			timestamps:      []int64{1000},
+			expectedCode:    http.StatusBadRequest,
+			expectedCounter: "write.non-monotonic-samples.rejected+handler=remote-write,reason=equal,test=monotonic-test",
+		},
+		{
+			name:            "regressing rejected",
+			mode:            handleroptions.PromWriteHandlerMonotonicTimestampsModeReject,
+			timestamps:      []int64{999, 1001},
+			expectedCode:    http.StatusBadRequest,
+			expectedCounter: "write.non-monotonic-samples.rejected+handler=remote-write,reason=regressing,test=monotonic-test",
+		},
+		{
+			name:               "equal dropped",
+			mode:               handleroptions.PromWriteHandlerMonotonicTimestampsModeDrop,
+			timestamps:         []int64{1000, 1001},
+			expectedCode:       http.StatusOK,
+			expectedTimestamps: []int64{1001},
+			expectedCounter:    "write.non-monotonic-samples.dropped+handler=remote-write,reason=equal,test=monotonic-test",
+		},
+		{
+			name:            "regressing dropped",
+			mode:            handleroptions.PromWriteHandlerMonotonicTimestampsModeDrop,
+			timestamps:      []int64{999},
+			expectedCode:    http.StatusOK,
+			expectedCounter: "write.non-monotonic-samples.dropped+handler=remote-write,reason=regressing,test=monotonic-test",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			var written [][]int64
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.
+				EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+					var timestamps []int64
+					for iter.Next() {
+						value := iter.Current()
+						if name, _ := value.Tags.Get([]byte("kind")); string(name) != "append-only" {
+							continue
+						}
+						for _, dp := range value.Datapoints {
+							timestamps = append(timestamps, dp.Timestamp.ToNormalizedTime(1e6))
+						}
+					}
+					written = append(written, timestamps)
+					return nil
+				}).
+				AnyTimes()
+
+			scope := tally.NewTestScope("", map[string]string{"test": "monotonic-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.MonotonicTimestamps = &handleroptions.PromWriteHandlerMonotonicTimestampsOptions{
+					Mode:        tt.mode,
+					MatchLabels: map[string]string{"kind": "append-only"},
+				}
+			})
+
+			write := func(promReq *prompb.WriteRequest) int {
+				body := test.GeneratePromWriteRequestBody(t, promReq)
+				req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+				writer := httptest.NewRecorder()
+				handler.ServeHTTP(writer, req)
+				return writer.Result().StatusCode
+			}
+
+			// NB: Series not matching are not checked, so the other series
+			// is written with the same timestamp by every request.
+			require.Equal(t, http.StatusOK, write(newMonotonicTimestampsTestRequest(1000)))
+			require.Equal(t, tt.expectedCode, write(newMonotonicTimestampsTestRequest(tt.timestamps...)))
+
+			if tt.expectedCode == http.StatusOK {
+				require.Len(t, written, 2)
+				require.Equal(t, tt.expectedTimestamps, written[1])
+			} else {
+				require.Len(t, written, 1)
+			}
+
+			if tt.expectedCounter != "" {
+				counter, ok := scope.Snapshot().Counters()[tt.expectedCounter]
+				require.True(t, ok)
+				require.Equal(t, int64(1), counter.Value())
+			}
+		})
+	}
+}
+
+func TestPromWriteMonotonicTimestampsFailedWriteNotRecorded(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	gomock.InOrder(
+		mockDownsamplerAndWriter.
+			EXPECT().
+			WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(ingest.BatchError(xerrors.NewMultiError().Add(errors.New("write failed")))),
+		mockDownsamplerAndWriter.
+			EXPECT().
+			WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()),
+	)
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.MonotonicTimestamps = &handleroptions.PromWriteHandlerMonotonicTimestampsOptions{}
+	})
+
+	for _, expectedCode := range []int{http.StatusInternalServerError, http.StatusOK} {
+		body := test.GeneratePromWriteRequestBody(t, newMonotonicTimestampsTestRequest(1000))
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		require.Equal(t, expectedCode, writer.Result().StatusCode)
+	}
+}
+
+func TestNewMonotonicTimestampsUnknownMode(t *testing.T) {
+	_, err := newMonotonicTimestamps(handleroptions.PromWriteHandlerMonotonicTimestampsOptions{
+		Mode: "unknown",
+	}, nil, tally.NoopScope)
+	require.Error(t, err)
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/query/util/logging"
+	"github.com/m3db/m3/src/x/cache"
+	"github.com/m3db/m3/src/x/clock"
+	"github.com/m3db/m3/src/x/instrument"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+	xsync "github.com/m3db/m3/src/x/sync"
+
+	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultNewMetricNameTimeout        = 15 * time.Second
+	defaultNewMetricNameMaxConcurrency = 4
+	defaultNewMetricNameMaxPerSecond   = 10
+	defaultNewMetricNameWindow         = 24 * time.Hour
+	defaultNewMetricNameMaxNames       = 100000
+)
+
+var errNoNewMetricNameWebhookURL = errors.New("new metric name webhook url must be set")
+
+// PromWriteNewMetricName is the body posted to the new metric name webhook
+// for a metric name not seen recently, along with the labels of a series it
+// was written with.
+type PromWriteNewMetricName struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+}
+
+// newMetricNameNotifier notifies the webhook of metric names not seen
+// recently in the background, bounded by its worker pool and rate limit so
+// that ingest is never blocked.
+type newMetricNameNotifier struct {
+	sync.Mutex
+
+	url            string
+	timeout        time.Duration
+	client         *http.Client
+	workers        xsync.WorkerPool
+	maxPerSecond   int
+	nowFn          clock.NowFn
+	instrumentOpts instrument.Options
+	// seen are the notified names, which expire after the window.
+	seen        *cache.LRU
+	secondStart time.Time
+	numInSecond int
+
+	success tally.Counter
+	errors  tally.Counter
+	dropped tally.Counter
+}
+
+func newNewMetricNameNotifier(
+	opts handleroptions.PromWriteHandlerNewMetricNameWebhookOptions,
+	nowFn clock.NowFn,
+	instrumentOpts instrument.Options,
+	scope tally.Scope,
+) (*newMetricNameNotifier, error) {
+	if opts.URL == "" {
+		return nil, errNoNewMetricNameWebhookURL
+	}
+
+	timeout := defaultNewMetricNameTimeout
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	maxConcurrency := defaultNewMetricNameMaxConcurrency
+	if opts.MaxConcurrency > 0 {
+		maxConcurrency = opts.MaxConcurrency
+	}
+	maxPerSecond := defaultNewMetricNameMaxPerSecond
+	if opts.MaxPerSecond > 0 {
+		maxPerSecond = opts.MaxPerSecond
+	}
+	window := defaultNewMetricNameWindow
+	if opts.Window > 0 {
+		window = opts.Window
+	}
+	maxNames := defaultNewMetricNameMaxNames
+	if opts.MaxNames > 0 {
+		maxNames = opts.MaxNames
+	}
+
+	workers := xsync.NewWorkerPool(maxConcurrency)
+	workers.Init()
+
+	httpOpts := xhttp.DefaultHTTPClientOptions()
+	httpOpts.RequestTimeout = timeout
+
+	scope = scope.SubScope("new-metric-name-webhook")
+	return &newMetricNameNotifier{
+		url:            opts.URL,
+		timeout:        timeout,
+		client:         xhttp.NewHTTPClient(httpOpts),
+		workers:        workers,
+		maxPerSecond:   maxPerSecond,
+		nowFn:          nowFn,
+		instrumentOpts: instrumentOpts,
+		seen: cache.NewLRU(&cache.LRUOptions{
+			TTL:        window,
+			MaxEntries: maxNames,
+			Now:        nowFn,
+		}),
+		success: scope.Counter("success"),
+		errors:  scope.Counter("errors"),
+		dropped: scope.Counter("dropped"),
+	}, nil
+}
+
+// observe notifies the webhook of the metric names of the series not seen
+// recently. Names that cannot be notified right away, due to the rate limit
+// or every worker being busy, are not remembered so that they are notified
+// once seen again.
+func (n *newMetricNameNotifier) observe(r *http.Request, series []prompb.TimeSeries) {
+	for i := range series {
+		labels := series[i].Labels
+		var name []byte
+		for _, l := range labels {
+			if bytes.Equal(l.Name, promMetricNameLabel) {
+				name = l.Value
+				break
+			}
+		}
+		if len(name) == 0 {
+			continue
+		}
+		if _, ok := n.seen.TryGet(string(name)); ok {
+			continue
+		}
+		n.maybeNotify(r, string(name), labels)
+	}
+}
+
+func (n *newMetricNameNotifier) maybeNotify(
+	r *http.Request,
+	name string,
+	labels []prompb.Label,
+) {
+	n.Lock()
+	defer n.Unlock()
+
+	// NB: Check again since a concurrent request may have notified the name.
+	if _, ok := n.seen.TryGet(name); ok {
+		return
+	}
+
+	now := n.nowFn()
+	if second := now.Truncate(time.Second); !second.Equal(n.secondStart) {
+		n.secondStart = second
+		n.numInSecond = 0
+	}
+	if n.numInSecond >= n.maxPerSecond {
+		n.dropped.Inc(1)
+		return
+	}
+
+	notification := PromWriteNewMetricName{
+		Name:   name,
+		Labels: make(map[string]string, len(labels)),
+	}
+	for _, l := range labels {
+		notification.Labels[string(l.Name)] = string(l.Value)
+	}
+
+	post := func() {
+		if err := postJSON(n.client, n.url, n.timeout, notification); err != nil {
+			n.errors.Inc(1)
+			logger := logging.WithContext(r.Context(), n.instrumentOpts)
+			logger.Error("new metric name webhook post error",
+				zap.String("name", name), zap.Error(err))
+			return
+		}
+		n.success.Inc(1)
+	}
+	if !n.workers.GoIfAvailable(post) {
+		n.dropped.Inc(1)
+		return
+	}
+
+	n.numInSecond++
+	n.seen.Put(name, struct{}{})
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func newNewMetricNameTestWebhook(t *testing.T) (*httptest.Server, chan PromWriteNewMetricName) {
+	notificationCh := make(chan PromWriteNewMetricName, 10)
+	webhook := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var notification PromWriteNewMetricName
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&notification))
+			notificationCh <- notification
+			w.WriteHeader(http.StatusOK)
+		}))
+	return webhook, notificationCh
+}
+
+func requireNewMetricNameNotification(
+	t *testing.T,
+	notificationCh chan PromWriteNewMetricName,
+) PromWriteNewMetricName {
+	select {
+	case notification := <-notificationCh:
+		return notification
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for new metric name notification")
+	}
+	return PromWriteNewMetricName{}
+}
+
+func newNewMetricNameTestSeries(names ...string) []prompb.TimeSeries {
+	series := make([]prompb.TimeSeries, 0, len(names))
+	for _, name := range names {
+		series = append(series, prompb.TimeSeries{
+			Labels:  testLabels("__name__", name, "job", "test"),
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		})
+	}
+	return series
+}
+
+func TestNewMetricNameNotifierOncePerWindow(t *testing.T) {
+	webhook, notificationCh := newNewMetricNameTestWebhook(t)
+	defer webhook.Close()
+
+	now := time.Unix(1700000000, 0)
+	notifier, err := newNewMetricNameNotifier(handleroptions.PromWriteHandlerNewMetricNameWebhookOptions{
+		URL:    webhook.URL,
+		Window: time.Hour,
+	}, func() time.Time { return now }, instrument.NewOptions(), tally.NoopScope)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, nil)
+	notifier.observe(r, newNewMetricNameTestSeries("foo", "foo"))
+	require.Equal(t, PromWriteNewMetricName{
+		Name:   "foo",
+		Labels: map[string]string{"__name__": "foo", "job": "test"},
+	}, requireNewMetricNameNotification(t, notificationCh))
+
+	// Seen names are not notified again within the window.
+	now = now.Add(30 * time.Minute)
+	notifier.observe(r, newNewMetricNameTestSeries("foo"))
+	notifier.observe(r, newNewMetricNameTestSeries("bar"))
+	require.Equal(t, "bar", requireNewMetricNameNotification(t, notificationCh).Name)
+
+	now = now.Add(time.Hour)
+	notifier.observe(r, newNewMetricNameTestSeries("foo"))
+	require.Equal(t, "foo", requireNewMetricNameNotification(t, notificationCh).Name)
+
+	time.Sleep(50 * time.Millisecond)
+	require.Len(t, notificationCh, 0)
+}
+
+func TestNewMetricNameNotifierRateLimit(t *testing.T) {
+	webhook, notificationCh := newNewMetricNameTestWebhook(t)
+	defer webhook.Close()
+
+	now := time.Unix(1700000000, 0)
+	scope := tally.NewTestScope("", nil)
+	notifier, err := newNewMetricNameNotifier(handleroptions.PromWriteHandlerNewMetricNameWebhookOptions{
+		URL:          webhook.URL,
+		MaxPerSecond: 2,
+	}, func() time.Time { return now }, instrument.NewOptions(), scope)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, nil)
+	notifier.observe(r, newNewMetricNameTestSeries("a", "b", "c"))
+	names := []string{
+		requireNewMetricNameNotification(t, notificationCh).Name,
+		requireNewMetricNameNotification(t, notificationCh).Name,
+	}
+	sort.Strings(names)
+	require.Equal(t, []string{"a", "b"}, names)
+	require.Equal(t, int64(1),
+		scope.Snapshot().Counters()["new-metric-name-webhook.dropped+"].Value())
+
+	// Names dropped by the rate limit are notified once seen again.
+	now = now.Add(time.Second)
+	notifier.observe(r, newNewMetricNameTestSeries("a", "b", "c"))
+	require.Equal(t, "c", requireNewMetricNameNotification(t, notificationCh).Name)
+}
+
+func TestPromWriteNewMetricNameWebhook(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	webhook, notificationCh := newNewMetricNameTestWebhook(t)
+	defer webhook.Close()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(2)
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.NewMetricNameWebhook = &handleroptions.PromWriteHandlerNewMetricNameWebhookOptions{
+			URL: webhook.URL,
+		}
+	})
+
+	write := func() {
+		req := &prompb.WriteRequest{Timeseries: newNewMetricNameTestSeries("foo")}
+		body := test.GeneratePromWriteRequestBody(t, req)
+		httpReq := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, httpReq)
+		require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	}
+
+	write()
+	require.Equal(t, "foo", requireNewMetricNameNotification(t, notificationCh).Name)
+
+	write()
+	time.Sleep(50 * time.Millisecond)
+	require.Len(t, notificationCh, 0)
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+
+	"github.com/prometheus/common/model"
+)
+
+var (
+	promBucketLabel   = []byte(model.BucketLabel)
+	promQuantileLabel = []byte(model.QuantileLabel)
+)
+
+// normalizeLabels sorts the labels of each series by name and removes
+// duplicate label names in place, returning the number of labels removed.
+// Bucket label values are also normalized if enabled.
+func (h *PromWriteHandler) normalizeLabels(
+	series []prompb.TimeSeries,
+	opts handleroptions.PromWriteHandlerLabelNormalizationOptions,
+) int {
+	var (
+		keepLast     = opts.Duplicates == handleroptions.PromWriteHandlerDuplicateLabelModeKeepLast
+		totalRemoved int
+	)
+	for i := range series {
+		labels, removed := normalizeLabels(series[i].Labels, keepLast)
+		series[i].Labels = labels
+		totalRemoved += removed
+		if opts.BucketValues {
+			normalizeBucketLabelValues(labels)
+		}
+	}
+	if totalRemoved > 0 {
+		h.metrics.duplicateLabelsRemoved.Inc(int64(totalRemoved))
+	}
+	return totalRemoved
+}
+
+// normalizeLabels sorts the labels by name and removes duplicate label names
+// in place, keeping either the first or last duplicate in the original order,
+// returning the normalized labels and the number of labels removed.
+func normalizeLabels(labels []prompb.Label, keepLast bool) ([]prompb.Label, int) {
+	// NB: A stable sort keeps duplicates in their original relative order.
+	sort.Stable(sortableLabels(labels))
+
+	n := 0
+	for i, l := range labels {
+		if n > 0 && bytes.Equal(labels[n-1].Name, l.Name) {
+			if keepLast {
+				labels[n-1] = labels[i]
+			}
+			continue
+		}
+		labels[n] = l
+		n++
+	}
+	return labels[:n], len(labels) - n
+}
+
+// normalizeBucketLabelValues rewrites the numeric values of the le and
+// quantile labels in place to the canonical form Prometheus clients format
+// them with, leaving values that are not numeric as is.
+func normalizeBucketLabelValues(labels []prompb.Label) {
+	for i, l := range labels {
+		if !bytes.Equal(l.Name, promBucketLabel) && !bytes.Equal(l.Name, promQuantileLabel) {
+			continue
+		}
+		v, err := strconv.ParseFloat(string(l.Value), 64)
+		if err != nil {
+			continue
+		}
+		if normalized := formatBucketLabelValue(v); normalized != string(l.Value) {
+			labels[i].Value = []byte(normalized)
+		}
+	}
+}
+
+func formatBucketLabelValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// checkDuplicateLabelNames rejects or dedupes series carrying the same label
+// name more than once, depending on the duplicate label names mode.
+func (h *PromWriteHandler) checkDuplicateLabelNames(series []prompb.TimeSeries) error {
+	mode := h.handlerOpts.DuplicateLabelNames
+	if mode == "" {
+		return nil
+	}
+
+	for i := range series {
+		if mode == handleroptions.PromWriteHandlerDuplicateLabelNamesModeReject {
+			if name, ok := duplicateLabelName(series[i].Labels); ok {
+				h.metrics.seriesDuplicateLabel.Inc(1)
+				return fmt.Errorf("series has duplicate label name: name=%s", name)
+			}
+			continue
+		}
+
+		labels, deduped := dedupeLabelNames(series[i].Labels)
+		if deduped {
+			series[i].Labels = labels
+			h.metrics.seriesDuplicateLabel.Inc(1)
+		}
+	}
+	return nil
+}
+
+// duplicateLabelName returns the first label name that is repeated.
+func duplicateLabelName(labels []prompb.Label) ([]byte, bool) {
+	for i, l := range labels {
+		if hasLabelName(labels[:i], l.Name) {
+			return l.Name, true
+		}
+	}
+	return nil, false
+}
+
+// dedupeLabelNames removes all but the first label with each name in place,
+// keeping the original label order, and returns whether any were removed.
+func dedupeLabelNames(labels []prompb.Label) ([]prompb.Label, bool) {
+	n := 0
+	for _, l := range labels {
+		if hasLabelName(labels[:n], l.Name) {
+			continue
+		}
+		labels[n] = l
+		n++
+	}
+	return labels[:n], n < len(labels)
+}
+
+// hasLabelName returns whether any of the labels has the name, series only
+// carry a handful of labels so a linear scan avoids allocating a set.
+func hasLabelName(labels []prompb.Label, name []byte) bool {
+	for _, l := range labels {
+		if bytes.Equal(l.Name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,378 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func testLabels(nameValues ...string) []prompb.Label {
+	labels := make([]prompb.Label, 0, len(nameValues)/2)
+	for i := 0; i < len(nameValues); i += 2 {
+		labels = append(labels, prompb.Label{
+			Name:  []byte(nameValues[i]),
+			Value: []byte(nameValues[i+1]),
+		})
+	}
+	return labels
+}
+
+func TestNormalizeLabels(t *testing.T) {
+	tests := []struct {
+		name            string
+		labels          []prompb.Label
+		keepLast        bool
+		expected        []prompb.Label
+		expectedRemoved int
+	}{
+		{
+			name:     "sorted",
+			labels:   testLabels("__name__", "up", "a", "1", "b", "2"),
+			expected: testLabels("__name__", "up", "a", "1", "b", "2"),
+		},
+		{
+			name:     "unsorted",
+			labels:   testLabels("c", "3", "__name__", "up", "a", "1", "b", "2"),
+			expected: testLabels("__name__", "up", "a", "1", "b", "2", "c", "3"),
+		},
+		{
+			name:            "duplicates keep first",
+			labels:          testLabels("b", "2", "a", "first", "__name__", "up", "a", "second", "a", "third"),
+			expected:        testLabels("__name__", "up", "a", "first", "b", "2"),
+			expectedRemoved: 2,
+		},
+		{
+			name:            "duplicates keep last",
+			labels:          testLabels("b", "2", "a", "first", "__name__", "up", "a", "second", "a", "third"),
+			keepLast:        true,
+			expected:        testLabels("__name__", "up", "a", "third", "b", "2"),
+			expectedRemoved: 2,
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels, removed := normalizeLabels(tt.labels, tt.keepLast)
+			require.Equal(t, tt.expected, labels)
+			require.Equal(t, tt.expectedRemoved, removed)
+		})
+	}
+}
+
+func TestPromWriteLabelNormalization(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var written []prompb.Label
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			require.True(t, iter.Next())
+			for _, tag := range iter.Current().Tags.Tags {
+				written = append(written, prompb.Label{Name: tag.Name, Value: tag.Value})
+			}
+			require.False(t, iter.Next())
+			return nil
+		})
+
+	scope := tally.NewTestScope("", map[string]string{"test": "label-normalization-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.LabelNormalization = &handleroptions.PromWriteHandlerLabelNormalizationOptions{
+			Duplicates: handleroptions.PromWriteHandlerDuplicateLabelModeKeepLast,
+		}
+	})
+
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: testLabels("job", "first", "__name__", "up", "job", "last"),
+				Samples: []prompb.Sample{
+					{Timestamp: time.Now().UnixMilli(), Value: 1},
+				},
+			},
+		},
+	}
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	require.Equal(t, testLabels("__name__", "up", "job", "last"), written)
+
+	removed, ok := scope.Snapshot().Counters()["write.duplicate-labels-removed+handler=remote-write,test=label-normalization-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), removed.Value())
+}
+
+func TestPromWriteLabelNormalizationInvalidMode(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.LabelNormalization = &handleroptions.PromWriteHandlerLabelNormalizationOptions{
+		Duplicates: "keep-middle",
+	}
+	opts = opts.SetConfig(cfg)
+
+	_, err := NewPromWriteHandler(opts)
+	require.Error(t, err)
+}
+
+func TestNormalizeBucketLabelValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   []prompb.Label
+		expected []prompb.Label
+	}{
+		{
+			name:     "integer",
+			labels:   testLabels("le", "1"),
+			expected: testLabels("le", "1"),
+		},
+		{
+			name:     "trailing zero",
+			labels:   testLabels("le", "1.0"),
+			expected: testLabels("le", "1"),
+		},
+		{
+			name:     "fraction",
+			labels:   testLabels("quantile", "0.990"),
+			expected: testLabels("quantile", "0.99"),
+		},
+		{
+			name:     "infinity",
+			labels:   testLabels("le", "+Inf"),
+			expected: testLabels("le", "+Inf"),
+		},
+		{
+			name:     "unsigned infinity",
+			labels:   testLabels("le", "Inf"),
+			expected: testLabels("le", "+Inf"),
+		},
+		{
+			name:     "scientific notation",
+			labels:   testLabels("le", "1e0"),
+			expected: testLabels("le", "1"),
+		},
+		{
+			name:     "scientific notation fraction",
+			labels:   testLabels("le", "2.5E-1"),
+			expected: testLabels("le", "0.25"),
+		},
+		{
+			name:     "not numeric",
+			labels:   testLabels("le", "high"),
+			expected: testLabels("le", "high"),
+		},
+		{
+			name:     "other labels",
+			labels:   testLabels("code", "1.0", "le", "1.0"),
+			expected: testLabels("code", "1.0", "le", "1"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizeBucketLabelValues(tt.labels)
+			require.Equal(t, tt.expected, tt.labels)
+		})
+	}
+}
+
+func TestPromWriteLabelNormalizationBucketValues(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			var written []string
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.
+				EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+					for iter.Next() {
+						le, ok := iter.Current().Tags.Get([]byte("le"))
+						require.True(t, ok)
+						written = append(written, string(le))
+					}
+					return nil
+				})
+
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.LabelNormalization = &handleroptions.PromWriteHandlerLabelNormalizationOptions{
+					BucketValues: enabled,
+				}
+			})
+
+			now := time.Now().UnixMilli()
+			promReq := &prompb.WriteRequest{}
+			for _, le := range []string{"1", "1.0", "1e0", "+Inf"} {
+				promReq.Timeseries = append(promReq.Timeseries, prompb.TimeSeries{
+					Labels:  testLabels("__name__", "latency_bucket", "le", le),
+					Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+				})
+			}
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+			if enabled {
+				require.Equal(t, []string{"1", "1", "1", "+Inf"}, written)
+			} else {
+				require.Equal(t, []string{"1", "1.0", "1e0", "+Inf"}, written)
+			}
+		})
+	}
+}
+
+func TestDedupeLabelNames(t *testing.T) {
+	labels, deduped := dedupeLabelNames(testLabels("__name__", "up", "job", "first", "a", "1", "job", "second"))
+	require.True(t, deduped)
+	require.Equal(t, testLabels("__name__", "up", "job", "first", "a", "1"), labels)
+
+	labels, deduped = dedupeLabelNames(testLabels("__name__", "up", "job", "first"))
+	require.False(t, deduped)
+	require.Equal(t, testLabels("__name__", "up", "job", "first"), labels)
+}
+
+func TestPromWriteDuplicateLabelNames(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           handleroptions.PromWriteHandlerDuplicateLabelNamesMode
+		expectedStatus int
+		expectedLabels []prompb.Label
+	}{
+		{
+			name:           "reject",
+			mode:           handleroptions.PromWriteHandlerDuplicateLabelNamesModeReject,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "dedupe",
+			mode:           handleroptions.PromWriteHandlerDuplicateLabelNamesModeDedupe,
+			expectedStatus: http.StatusOK,
+			expectedLabels: testLabels("__name__", "up", "job", "first"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			var written []prompb.Label
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedStatus == http.StatusOK {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+					Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+						require.True(t, iter.Next())
+						for _, tag := range iter.Current().Tags.Tags {
+							written = append(written, prompb.Label{Name: tag.Name, Value: tag.Value})
+						}
+						require.True(t, iter.Next())
+						require.False(t, iter.Next())
+						return nil
+					})
+			}
+
+			scope := tally.NewTestScope("", map[string]string{"test": "duplicate-label-names-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.DuplicateLabelNames = tt.mode
+			})
+
+			now := time.Now().UnixMilli()
+			promReq := &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{
+					{
+						Labels:  testLabels("__name__", "up", "job", "first", "job", "second"),
+						Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+					},
+					{
+						Labels:  testLabels("__name__", "up", "job", "other"),
+						Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+					},
+				},
+			}
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedStatus != http.StatusOK {
+				body, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Contains(t, string(body), "series has duplicate label name: name=job")
+			}
+			require.Equal(t, tt.expectedLabels, written)
+
+			duplicates, ok := scope.Snapshot().Counters()["write.series-duplicate-label+handler=remote-write,test=duplicate-label-names-test"]
+			require.True(t, ok)
+			require.Equal(t, int64(1), duplicates.Value())
+		})
+	}
+}
+
+func TestPromWriteDuplicateLabelNamesInvalidMode(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.DuplicateLabelNames = "keep-all"
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.EqualError(t, err, "unknown duplicate label names mode: keep-all")
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+var (
+	errForwardOAuth2NoTokenURL = errors.New("forwarding oauth2 must have a token URL")
+	errForwardOAuth2NoClientID = errors.New("forwarding oauth2 must have a client ID")
+)
+
+// forwardTokenSources is the token sources of the forwarding targets
+// authenticated with OAuth2, keyed by the target OAuth2 options which are
+// shared by every copy of the target.
+type forwardTokenSources map[*handleroptions.PromWriteHandlerForwardOAuth2Options]oauth2.TokenSource
+
+func newForwardTokenSources(
+	targets []handleroptions.PromWriteHandlerForwardTargetOptions,
+	client *http.Client,
+) (forwardTokenSources, error) {
+	var sources forwardTokenSources
+	for _, target := range targets {
+		opts := target.OAuth2
+		if opts == nil {
+			continue
+		}
+		if opts.TokenURL == "" {
+			return nil, errForwardOAuth2NoTokenURL
+		}
+		if opts.ClientID == "" {
+			return nil, errForwardOAuth2NoClientID
+		}
+		if sources == nil {
+			sources = make(forwardTokenSources)
+		}
+		cfg := clientcredentials.Config{
+			ClientID:     opts.ClientID,
+			ClientSecret: opts.ClientSecret,
+			TokenURL:     opts.TokenURL,
+			Scopes:       opts.Scopes,
+		}
+		// NB: The context is used for every token fetch by the token source
+		// so it must not be request scoped.
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+		sources[opts] = cfg.TokenSource(ctx)
+	}
+	return sources, nil
+}
+
+// authorize sets the Authorization header of the request forwarded to the
+// target if it is authenticated with OAuth2, fetching a token if the cached
+// token expired.
+func (s forwardTokenSources) authorize(
+	req *http.Request,
+	target handleroptions.PromWriteHandlerForwardTargetOptions,
+) error {
+	source, ok := s[target.OAuth2]
+	if !ok {
+		return nil
+	}
+	token, err := source.Token()
+	if err != nil {
+		return err
+	}
+	token.SetAuthHeader(req)
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromWriteForwardOAuth2(t *testing.T) {
+	tests := []struct {
+		name           string
+		expiresIn      int
+		expectedTokens []string
+	}{
+		{
+			name:           "token reused before expiry",
+			expiresIn:      3600,
+			expectedTokens: []string{"token-1", "token-1", "token-1"},
+		},
+		{
+			// NB: Tokens expiring within ten seconds are considered expired.
+			name:           "token refreshed after expiry",
+			expiresIn:      1,
+			expectedTokens: []string{"token-1", "token-2", "token-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			var fetched int32
+			tokenSvr := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					require.NoError(t, r.ParseForm())
+					require.Equal(t, "client_credentials", r.Form.Get("grant_type"))
+					require.Equal(t, "write", r.Form.Get("scope"))
+					clientID, clientSecret, ok := r.BasicAuth()
+					require.True(t, ok)
+					require.Equal(t, "client", clientID)
+					require.Equal(t, "secret", clientSecret)
+
+					n := atomic.AddInt32(&fetched, 1)
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`,
+						n, tt.expiresIn)
+				}))
+			defer tokenSvr.Close()
+
+			var authorizations []string
+			targetSvr := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					authorizations = append(authorizations, r.Header.Get("Authorization"))
+					w.WriteHeader(http.StatusOK)
+				}))
+			defer targetSvr.Close()
+
+			opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+			h := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+					{
+						URL: targetSvr.URL,
+						OAuth2: &handleroptions.PromWriteHandlerForwardOAuth2Options{
+							TokenURL:     tokenSvr.URL,
+							ClientID:     "client",
+							ClientSecret: "secret",
+							Scopes:       []string{"write"},
+						},
+					},
+				}
+			})
+
+			target := h.forwarding.Targets[0]
+			for range tt.expectedTokens {
+				err := h.forwardBody(context.Background(), bytes.NewReader(nil), nil, target)
+				require.NoError(t, err)
+			}
+
+			expected := make([]string, 0, len(tt.expectedTokens))
+			for _, token := range tt.expectedTokens {
+				expected = append(expected, "Bearer "+token)
+			}
+			require.Equal(t, expected, authorizations)
+		})
+	}
+}
+
+func TestPromWriteForwardOAuth2TokenError(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	tokenSvr := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+	defer tokenSvr.Close()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	h := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{
+				URL: "http://target",
+				OAuth2: &handleroptions.PromWriteHandlerForwardOAuth2Options{
+					TokenURL: tokenSvr.URL,
+					ClientID: "client",
+				},
+			},
+		}
+	})
+
+	err := h.forwardBody(context.Background(), bytes.NewReader(nil), nil,
+		h.forwarding.Targets[0])
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "forwarding oauth2 token failed")
+}
+
+func TestPromWriteForwardOAuth2Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		oauth2      handleroptions.PromWriteHandlerForwardOAuth2Options
+		expectedErr error
+	}{
+		{
+			name:        "no token URL",
+			oauth2:      handleroptions.PromWriteHandlerForwardOAuth2Options{ClientID: "client"},
+			expectedErr: errForwardOAuth2NoTokenURL,
+		},
+		{
+			name:        "no client ID",
+			oauth2:      handleroptions.PromWriteHandlerForwardOAuth2Options{TokenURL: "http://token"},
+			expectedErr: errForwardOAuth2NoClientID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			oauth2 := tt.oauth2
+			opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+			cfg := opts.Config()
+			cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+				{URL: "http://target", OAuth2: &oauth2},
+			}
+			_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+			require.Equal(t, tt.expectedErr, err)
+		})
+	}
+}
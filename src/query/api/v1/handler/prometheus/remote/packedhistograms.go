@@ -0,0 +1,205 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/uber-go/tally"
+)
+
+var (
+	histogramBucketLabel = []byte(labels.BucketLabel)
+
+	histogramBucketSuffix = []byte("_bucket")
+	histogramSumSuffix    = []byte("_sum")
+	histogramCountSuffix  = []byte("_count")
+)
+
+// packedHistograms expands matching series carrying packed histograms into
+// the bucket, sum and count series of a Prometheus histogram.
+type packedHistograms struct {
+	matchLabels []prompb.Label
+	expanded    tally.Counter
+}
+
+func newPackedHistograms(
+	opts handleroptions.PromWriteHandlerPackedHistogramsOptions,
+	scope tally.Scope,
+) *packedHistograms {
+	return &packedHistograms{
+		matchLabels: newMatchLabels(opts.MatchLabels),
+		expanded:    scope.SubScope("write").SubScope("packed-histograms").Counter("expanded"),
+	}
+}
+
+// expand replaces each matching series carrying packed histograms with the
+// series the histograms expand to, any samples of the series itself are
+// dropped.
+func (p *packedHistograms) expand(req *prompb.WriteRequest) error {
+	numPacked := 0
+	for _, ts := range req.Timeseries {
+		if p.matches(ts) {
+			numPacked++
+		}
+	}
+	if numPacked == 0 {
+		return nil
+	}
+
+	expanded := make([]prompb.TimeSeries, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		if !p.matches(ts) {
+			expanded = append(expanded, ts)
+			continue
+		}
+
+		series, err := expandPackedHistograms(ts)
+		if err != nil {
+			return err
+		}
+		expanded = append(expanded, series...)
+	}
+
+	p.expanded.Inc(int64(numPacked))
+	req.Timeseries = expanded
+	return nil
+}
+
+func (p *packedHistograms) matches(ts prompb.TimeSeries) bool {
+	return len(ts.PackedHistograms) > 0 && matchesLabels(ts.Labels, p.matchLabels)
+}
+
+// expandPackedHistograms returns the bucket series of each upper bound in
+// ascending order followed by the sum and count series of the packed
+// histograms of the series. Histograms without a +Inf bucket are given one
+// with their count, as Prometheus histograms always have one.
+func expandPackedHistograms(ts prompb.TimeSeries) ([]prompb.TimeSeries, error) {
+	var (
+		name    []byte
+		hasName bool
+	)
+	for _, l := range ts.Labels {
+		if bytes.Equal(l.Name, promMetricNameLabel) {
+			name, hasName = l.Value, true
+		}
+		if bytes.Equal(l.Name, histogramBucketLabel) {
+			return nil, fmt.Errorf("packed histogram series has %s label",
+				histogramBucketLabel)
+		}
+	}
+	if !hasName {
+		return nil, fmt.Errorf("packed histogram series has no metric name label: name=%s",
+			promMetricNameLabel)
+	}
+
+	var (
+		buckets      = make(map[float64][]prompb.Sample)
+		sumSamples   = make([]prompb.Sample, 0, len(ts.PackedHistograms))
+		countSamples = make([]prompb.Sample, 0, len(ts.PackedHistograms))
+	)
+	for _, h := range ts.PackedHistograms {
+		hasInf := false
+		for _, b := range h.Buckets {
+			hasInf = hasInf || math.IsInf(b.UpperBound, 1)
+			buckets[b.UpperBound] = append(buckets[b.UpperBound], prompb.Sample{
+				Timestamp: h.Timestamp,
+				Value:     b.Count,
+			})
+		}
+		if !hasInf {
+			inf := math.Inf(1)
+			buckets[inf] = append(buckets[inf], prompb.Sample{
+				Timestamp: h.Timestamp,
+				Value:     h.Count,
+			})
+		}
+		sumSamples = append(sumSamples, prompb.Sample{Timestamp: h.Timestamp, Value: h.Sum})
+		countSamples = append(countSamples, prompb.Sample{Timestamp: h.Timestamp, Value: h.Count})
+	}
+
+	upperBounds := make([]float64, 0, len(buckets))
+	for upperBound := range buckets {
+		upperBounds = append(upperBounds, upperBound)
+	}
+	sort.Float64s(upperBounds)
+
+	series := make([]prompb.TimeSeries, 0, len(upperBounds)+2)
+	for _, upperBound := range upperBounds {
+		bucket := expandedHistogramSeries(ts, name, histogramBucketSuffix, buckets[upperBound])
+		bucket.Labels = append(bucket.Labels, prompb.Label{
+			Name:  histogramBucketLabel,
+			Value: []byte(formatUpperBound(upperBound)),
+		})
+		series = append(series, bucket)
+	}
+	series = append(series,
+		expandedHistogramSeries(ts, name, histogramSumSuffix, sumSamples),
+		expandedHistogramSeries(ts, name, histogramCountSuffix, countSamples))
+	return series, nil
+}
+
+// expandedHistogramSeries returns a series with the labels of the packed
+// series, its name suffixed, and the samples.
+func expandedHistogramSeries(
+	ts prompb.TimeSeries,
+	name, suffix []byte,
+	samples []prompb.Sample,
+) prompb.TimeSeries {
+	suffixed := make([]byte, 0, len(name)+len(suffix))
+	suffixed = append(append(suffixed, name...), suffix...)
+
+	// NB: Leave room for the bucket label of bucket series.
+	seriesLabels := make([]prompb.Label, 0, len(ts.Labels)+1)
+	for _, l := range ts.Labels {
+		if bytes.Equal(l.Name, promMetricNameLabel) {
+			l.Value = suffixed
+		}
+		seriesLabels = append(seriesLabels, l)
+	}
+
+	return prompb.TimeSeries{
+		Labels:  seriesLabels,
+		Samples: samples,
+		Type:    ts.Type,
+		Unit:    ts.Unit,
+		Help:    ts.Help,
+		M3Type:  ts.M3Type,
+		Source:  ts.Source,
+	}
+}
+
+// formatUpperBound formats a bucket upper bound the way Prometheus client
+// libraries do.
+func formatUpperBound(upperBound float64) string {
+	if math.IsInf(upperBound, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(upperBound, 'f', -1, 64)
+}
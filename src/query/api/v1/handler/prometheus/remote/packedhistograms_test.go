@@ -0,0 +1,188 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func newPackedHistogramTestSeries() prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels: testLabels("__name__", "latency", "job", "api"),
+		Type:   prompb.MetricType_HISTOGRAM,
+		PackedHistograms: []prompb.PackedHistogram{
+			{
+				Timestamp: 1000,
+				Sum:       4.5,
+				Count:     5,
+				Buckets: []prompb.HistogramBucket{
+					{UpperBound: 1, Count: 3},
+					{UpperBound: 0.5, Count: 1},
+				},
+			},
+			{
+				Timestamp: 2000,
+				Sum:       6,
+				Count:     7,
+				Buckets: []prompb.HistogramBucket{
+					{UpperBound: 0.5, Count: 2},
+					{UpperBound: 1, Count: 4},
+					{UpperBound: math.Inf(1), Count: 7},
+				},
+			},
+		},
+	}
+}
+
+func TestPackedHistogramsExpand(t *testing.T) {
+	other := prompb.TimeSeries{
+		Labels:  testLabels("__name__", "requests", "job", "api"),
+		Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}},
+	}
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{other, newPackedHistogramTestSeries()},
+	}
+
+	scope := tally.NewTestScope("", nil)
+	p := newPackedHistograms(handleroptions.PromWriteHandlerPackedHistogramsOptions{
+		MatchLabels: map[string]string{"job": "api"},
+	}, scope)
+	require.NoError(t, p.expand(req))
+
+	expectedSeries := func(samples []prompb.Sample, nameValues ...string) prompb.TimeSeries {
+		return prompb.TimeSeries{
+			Labels:  testLabels(nameValues...),
+			Samples: samples,
+			Type:    prompb.MetricType_HISTOGRAM,
+		}
+	}
+	require.Equal(t, []prompb.TimeSeries{
+		other,
+		expectedSeries([]prompb.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}},
+			"__name__", "latency_bucket", "job", "api", "le", "0.5"),
+		expectedSeries([]prompb.Sample{{Timestamp: 1000, Value: 3}, {Timestamp: 2000, Value: 4}},
+			"__name__", "latency_bucket", "job", "api", "le", "1"),
+		expectedSeries([]prompb.Sample{{Timestamp: 1000, Value: 5}, {Timestamp: 2000, Value: 7}},
+			"__name__", "latency_bucket", "job", "api", "le", "+Inf"),
+		expectedSeries([]prompb.Sample{{Timestamp: 1000, Value: 4.5}, {Timestamp: 2000, Value: 6}},
+			"__name__", "latency_sum", "job", "api"),
+		expectedSeries([]prompb.Sample{{Timestamp: 1000, Value: 5}, {Timestamp: 2000, Value: 7}},
+			"__name__", "latency_count", "job", "api"),
+	}, req.Timeseries)
+
+	expanded, ok := scope.Snapshot().Counters()["write.packed-histograms.expanded+"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), expanded.Value())
+}
+
+func TestPackedHistogramsExpandNotMatching(t *testing.T) {
+	series := newPackedHistogramTestSeries()
+	req := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{series}}
+
+	p := newPackedHistograms(handleroptions.PromWriteHandlerPackedHistogramsOptions{
+		MatchLabels: map[string]string{"job": "other"},
+	}, tally.NoopScope)
+	require.NoError(t, p.expand(req))
+	require.Equal(t, []prompb.TimeSeries{series}, req.Timeseries)
+}
+
+func TestPackedHistogramsExpandInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []prompb.Label
+	}{
+		{
+			name:   "no metric name",
+			labels: testLabels("job", "api"),
+		},
+		{
+			name:   "bucket label",
+			labels: testLabels("__name__", "latency", "le", "1"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series := newPackedHistogramTestSeries()
+			series.Labels = tt.labels
+			req := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{series}}
+
+			p := newPackedHistograms(handleroptions.PromWriteHandlerPackedHistogramsOptions{},
+				tally.NoopScope)
+			require.Error(t, p.expand(req))
+		})
+	}
+}
+
+func TestPromWritePackedHistograms(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var names []string
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			for iter.Next() {
+				name, _ := iter.Current().Tags.Name()
+				names = append(names, string(name))
+			}
+			return nil
+		})
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.PackedHistograms = &handleroptions.PromWriteHandlerPackedHistogramsOptions{}
+	})
+
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{newPackedHistogramTestSeries()},
+	}
+	body := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	require.Equal(t, []string{
+		"latency_bucket",
+		"latency_bucket",
+		"latency_bucket",
+		"latency_sum",
+		"latency_count",
+	}, names)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/m3db/m3/src/query/util/logging"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// PromWritePauseURL is the url for the prom write pause handler.
+	PromWritePauseURL = PromWriteURL + "/pause"
+
+	// defaultPausedRetryAfter is the default retry after returned to clients
+	// while writes are paused.
+	defaultPausedRetryAfter = 30 * time.Second
+
+	retryAfterHeader = "Retry-After"
+)
+
+var (
+	// PromWritePauseHTTPMethods are the HTTP methods used with this resource.
+	PromWritePauseHTTPMethods = []string{http.MethodGet, http.MethodPost}
+
+	errWritesPaused = errors.New("writes are paused")
+)
+
+// PromWritePauseRequest is the request to pause or unpause writes.
+type PromWritePauseRequest struct {
+	Paused bool `json:"paused"`
+}
+
+// PromWritePauseResponse is the response describing if writes are paused.
+type PromWritePauseResponse struct {
+	Paused bool `json:"paused"`
+}
+
+// Paused returns whether writes are currently paused.
+func (h *PromWriteHandler) Paused() bool {
+	return atomic.LoadInt32(&h.paused) == 1
+}
+
+// SetPaused pauses or unpauses writes, while paused all writes are rejected
+// before the request body is read.
+func (h *PromWriteHandler) SetPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&h.paused, v)
+}
+
+// writePausedError writes the paused response if writes are paused and
+// returns whether the response was written.
+func (h *PromWriteHandler) writePausedError(w http.ResponseWriter) bool {
+	if !h.Paused() {
+		return false
+	}
+
+	retryAfter := defaultPausedRetryAfter
+	if v := h.handlerOpts.PausedRetryAfter; v > 0 {
+		retryAfter = v
+	}
+
+	h.metrics.writePaused.Inc(1)
+	w.Header().Set(retryAfterHeader, strconv.Itoa(int(retryAfter.Seconds())))
+	err := xhttp.NewError(errWritesPaused, http.StatusServiceUnavailable)
+	writeError(w, withErrorCode(err, PromWriteErrorCodeWritesPaused))
+	return true
+}
+
+// PauseHandler returns the admin handler to read and set the paused state.
+func (h *PromWriteHandler) PauseHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.WithContext(r.Context(), h.instrumentOpts)
+		if r.Method == http.MethodPost {
+			var req PromWritePauseRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				xhttp.WriteError(w, xerrors.NewInvalidParamsError(err))
+				return
+			}
+			h.SetPaused(req.Paused)
+			logger.Info("prom remote write paused state set",
+				zap.Bool("paused", req.Paused))
+		}
+
+		xhttp.WriteJSONResponse(w, PromWritePauseResponse{Paused: h.Paused()}, logger)
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+type failOnReadBody struct {
+	t *testing.T
+}
+
+func (b failOnReadBody) Read([]byte) (int, error) {
+	require.FailNow(b.t, "body should not be read")
+	return 0, nil
+}
+
+func TestPromWritePaused(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	scope := tally.NewTestScope("", map[string]string{"test": "paused-test"})
+	iopts := instrument.NewOptions().SetMetricsScope(scope)
+	handler, err := NewPromWriteHandler(makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(iopts))
+	require.NoError(t, err)
+	writeHandler := handler.(*PromWriteHandler)
+
+	writeHandler.SetPaused(true)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, failOnReadBody{t: t})
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	resp := writer.Result()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "30", resp.Header.Get(retryAfterHeader))
+
+	paused, ok := scope.Snapshot().Counters()["write.paused+handler=remote-write,test=paused-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), paused.Value())
+
+	writeHandler.SetPaused(false)
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req = httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer = httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+}
+
+func TestPromWritePausedBeforeAuthentication(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.JWT = &handleroptions.PromWriteHandlerJWTOptions{
+			HMACSecret: testJWTSecret,
+			Issuer:     testJWTIssuer,
+		}
+		cfg.PromRemoteWrite.DebugResponseDelay = &handleroptions.PromWriteHandlerDebugResponseDelayOptions{
+			Enabled: true,
+			Delay:   time.Minute,
+		}
+		cfg.PromRemoteWrite.AuditLog = &handleroptions.PromWriteHandlerAuditLogOptions{Size: 1}
+	})
+	handler.SetPaused(true)
+
+	// Paused writes are rejected without authenticating or delaying them.
+	start := time.Now()
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, failOnReadBody{t: t})
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusServiceUnavailable, writer.Result().StatusCode)
+	require.True(t, time.Since(start) < 10*time.Second)
+
+	// Paused writes are still audited.
+	req = httptest.NewRequest(PromWriteAuditLogHTTPMethod, PromWriteAuditLogURL, nil)
+	writer = httptest.NewRecorder()
+	handler.AuditLogHandler().ServeHTTP(writer, req)
+	var resp PromWriteAuditLogResponse
+	require.NoError(t, json.NewDecoder(writer.Result().Body).Decode(&resp))
+	require.Len(t, resp.Records, 1)
+	require.Equal(t, http.StatusServiceUnavailable, resp.Records[0].Status)
+}
+
+func TestPromWritePauseHandler(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, err := NewPromWriteHandler(makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)))
+	require.NoError(t, err)
+	writeHandler := handler.(*PromWriteHandler)
+	pauseHandler := writeHandler.PauseHandler()
+
+	readPaused := func(resp *http.Response) bool {
+		var result PromWritePauseResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result.Paused
+	}
+
+	writer := httptest.NewRecorder()
+	pauseHandler.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, PromWritePauseURL, nil))
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	require.False(t, readPaused(writer.Result()))
+
+	writer = httptest.NewRecorder()
+	pauseHandler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, PromWritePauseURL,
+		strings.NewReader(`{"paused":true}`)))
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	require.True(t, readPaused(writer.Result()))
+	require.True(t, writeHandler.Paused())
+
+	writer = httptest.NewRecorder()
+	pauseHandler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, PromWritePauseURL,
+		strings.NewReader(`{"paused":false}`)))
+	require.False(t, readPaused(writer.Result()))
+	require.False(t, writeHandler.Paused())
+
+	writer = httptest.NewRecorder()
+	pauseHandler.ServeHTTP(writer, httptest.NewRequest(http.MethodPost, PromWritePauseURL,
+		strings.NewReader(`not json`)))
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/headers"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+
+	"github.com/uber-go/tally"
+)
+
+// defaultPriorityPoolName is the name of the pool admitting requests that
+// select no priority pool.
+const defaultPriorityPoolName = "default"
+
+// priorityPool bounds the number of concurrent requests it admits, a nil
+// tokens channel admits every request.
+type priorityPool struct {
+	name     string
+	tokens   chan struct{}
+	admitted tally.Counter
+	rejected tally.Counter
+}
+
+func newPriorityPool(name string, maxConcurrency int, scope tally.Scope) *priorityPool {
+	scope = scope.SubScope("write-priority-pool").
+		Tagged(map[string]string{"pool": name})
+	p := &priorityPool{
+		name:     name,
+		admitted: scope.Counter("admitted"),
+		rejected: scope.Counter("rejected"),
+	}
+	if maxConcurrency > 0 {
+		p.tokens = make(chan struct{}, maxConcurrency)
+	}
+	return p
+}
+
+// priorityPools admits requests by the pool selected with the write priority
+// header.
+type priorityPools struct {
+	byName   map[string]*priorityPool
+	fallback *priorityPool
+}
+
+func newPriorityPools(
+	opts handleroptions.PromWriteHandlerPriorityPoolsOptions,
+	scope tally.Scope,
+) (*priorityPools, error) {
+	pools := &priorityPools{
+		byName: make(map[string]*priorityPool, len(opts.Pools)),
+		fallback: newPriorityPool(defaultPriorityPoolName,
+			opts.DefaultMaxConcurrency, scope),
+	}
+	for _, poolOpts := range opts.Pools {
+		if poolOpts.Name == "" || poolOpts.Name == defaultPriorityPoolName {
+			return nil, fmt.Errorf("invalid priority pool name: %q", poolOpts.Name)
+		}
+		if _, ok := pools.byName[poolOpts.Name]; ok {
+			return nil, fmt.Errorf("duplicate priority pool name: %s", poolOpts.Name)
+		}
+		if poolOpts.MaxConcurrency <= 0 {
+			return nil, fmt.Errorf("priority pool %s max concurrency must be positive: %d",
+				poolOpts.Name, poolOpts.MaxConcurrency)
+		}
+		pools.byName[poolOpts.Name] = newPriorityPool(poolOpts.Name,
+			poolOpts.MaxConcurrency, scope)
+	}
+	return pools, nil
+}
+
+// admit admits the request by the pool it selects, returning a function to
+// release it once responded to. An invalid params error is returned if the
+// request selects an unknown pool and a 429 error if the pool is saturated.
+func (p *priorityPools) admit(header http.Header) (func(), error) {
+	pool := p.fallback
+	if v := strings.TrimSpace(header.Get(headers.WritePriorityHeader)); v != "" {
+		var ok bool
+		pool, ok = p.byName[v]
+		if !ok {
+			err := xerrors.NewInvalidParamsError(
+				fmt.Errorf("unknown write priority: %s", v))
+			return nil, withErrorCode(err, PromWriteErrorCodeInvalidHeader)
+		}
+	}
+
+	if pool.tokens == nil {
+		pool.admitted.Inc(1)
+		return func() {}, nil
+	}
+
+	select {
+	case pool.tokens <- struct{}{}:
+	default:
+		pool.rejected.Inc(1)
+		err := xhttp.NewError(fmt.Errorf("write priority pool %s saturated: "+
+			"maxConcurrency=%d", pool.name, cap(pool.tokens)),
+			http.StatusTooManyRequests)
+		return nil, withErrorCode(err, PromWriteErrorCodeOverloaded)
+	}
+	pool.admitted.Inc(1)
+	return func() {
+		<-pool.tokens
+	}, nil
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	"github.com/m3db/m3/src/x/headers"
+	"github.com/m3db/m3/src/x/instrument"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func newPriorityPoolsTestHeader(priority string) http.Header {
+	header := make(http.Header)
+	if priority != "" {
+		header.Set(headers.WritePriorityHeader, priority)
+	}
+	return header
+}
+
+func TestPriorityPoolsAdmit(t *testing.T) {
+	pools, err := newPriorityPools(handleroptions.PromWriteHandlerPriorityPoolsOptions{
+		Pools: []handleroptions.PromWriteHandlerPriorityPoolOptions{
+			{Name: "high", MaxConcurrency: 1},
+			{Name: "low", MaxConcurrency: 1},
+		},
+	}, tally.NoopScope)
+	require.NoError(t, err)
+
+	releaseLow, err := pools.admit(newPriorityPoolsTestHeader("low"))
+	require.NoError(t, err)
+
+	// The saturated low pool rejects further requests.
+	_, err = pools.admit(newPriorityPoolsTestHeader("low"))
+	require.Error(t, err)
+	require.True(t, xhttp.IsClientError(err))
+	require.False(t, xerrors.IsInvalidParams(err))
+
+	// Other pools are not affected, the default pool is unlimited.
+	releaseHigh, err := pools.admit(newPriorityPoolsTestHeader("high"))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		release, err := pools.admit(newPriorityPoolsTestHeader(""))
+		require.NoError(t, err)
+		defer release()
+	}
+
+	_, err = pools.admit(newPriorityPoolsTestHeader("urgent"))
+	require.True(t, xerrors.IsInvalidParams(err))
+
+	releaseLow()
+	releaseHigh()
+	releaseLow, err = pools.admit(newPriorityPoolsTestHeader("low"))
+	require.NoError(t, err)
+	releaseLow()
+}
+
+func TestNewPriorityPoolsInvalid(t *testing.T) {
+	for _, pools := range [][]handleroptions.PromWriteHandlerPriorityPoolOptions{
+		{{Name: "", MaxConcurrency: 1}},
+		{{Name: defaultPriorityPoolName, MaxConcurrency: 1}},
+		{{Name: "high", MaxConcurrency: 1}, {Name: "high", MaxConcurrency: 1}},
+		{{Name: "high"}},
+	} {
+		_, err := newPriorityPools(handleroptions.PromWriteHandlerPriorityPoolsOptions{
+			Pools: pools,
+		}, tally.NoopScope)
+		require.Error(t, err)
+	}
+}
+
+func TestPromWritePriorityPools(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	// The first low priority request blocks being written until released.
+	var (
+		startedCh = make(chan struct{})
+		releaseCh = make(chan struct{})
+		numWrites int32
+	)
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, _ ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			if atomic.AddInt32(&numWrites, 1) == 1 {
+				close(startedCh)
+				<-releaseCh
+			}
+			return nil
+		}).
+		Times(3)
+
+	scope := tally.NewTestScope("", map[string]string{"test": "priority-pools"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.PriorityPools = &handleroptions.PromWriteHandlerPriorityPoolsOptions{
+			Pools: []handleroptions.PromWriteHandlerPriorityPoolOptions{
+				{Name: "high", MaxConcurrency: 1},
+				{Name: "low", MaxConcurrency: 1},
+			},
+		}
+	})
+
+	write := func(priority string) int {
+		body := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+		if priority != "" {
+			req.Header.Set(headers.WritePriorityHeader, priority)
+		}
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		return writer.Result().StatusCode
+	}
+
+	lowDoneCh := make(chan int, 1)
+	go func() {
+		lowDoneCh <- write("low")
+	}()
+	select {
+	case <-startedCh:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for low priority write")
+	}
+
+	// The saturated low priority pool rejects bulk writes while high
+	// priority writes are still admitted.
+	require.Equal(t, http.StatusTooManyRequests, write("low"))
+	require.Equal(t, http.StatusOK, write("high"))
+	require.Equal(t, http.StatusBadRequest, write("urgent"))
+
+	close(releaseCh)
+	require.Equal(t, http.StatusOK, <-lowDoneCh)
+	require.Equal(t, http.StatusOK, write(""))
+
+	rejected, ok := scope.Snapshot().Counters()["write-priority-pool.rejected+handler=remote-write,pool=low,test=priority-pools"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), rejected.Value())
+}
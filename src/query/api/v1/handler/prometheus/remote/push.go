@@ -0,0 +1,197 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+
+	prom "github.com/m3db/prometheus_client_golang/prometheus"
+	"github.com/m3db/prometheus_client_golang/prometheus/push"
+	"github.com/uber-go/tally"
+	"github.com/uber-go/tally/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMetricsPushJob     = "m3coordinator"
+	defaultMetricsPushTimeout = 10 * time.Second
+)
+
+var errNoMetricsPushURL = errors.New("metrics push url must be set")
+
+// metricsPusher owns a dedicated metrics scope whose metrics are reported
+// to a pushgateway once the scope is closed.
+type metricsPusher struct {
+	scope  tally.Scope
+	closer io.Closer
+	pusher *push.Pusher
+}
+
+func newMetricsPusher(
+	opts handleroptions.PromWriteHandlerMetricsPushOptions,
+	logger *zap.Logger,
+) (*metricsPusher, error) {
+	if opts.URL == "" {
+		return nil, errNoMetricsPushURL
+	}
+
+	job := defaultMetricsPushJob
+	if opts.Job != "" {
+		job = opts.Job
+	}
+
+	timeout := defaultMetricsPushTimeout
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+
+	registry := prom.NewRegistry()
+	reporter := prometheus.NewReporter(prometheus.Options{
+		Registerer: registry,
+		OnRegisterError: func(err error) {
+			logger.Error("register metrics push metric error", zap.Error(err))
+		},
+	})
+
+	// NB: A zero report interval means metrics are only reported when the
+	// scope is closed.
+	scope, closer := tally.NewRootScope(tally.ScopeOptions{
+		CachedReporter:  reporter,
+		Separator:       prometheus.DefaultSeparator,
+		SanitizeOptions: &prometheus.DefaultSanitizerOpts,
+	}, 0)
+
+	return &metricsPusher{
+		scope:  scope,
+		closer: closer,
+		pusher: push.New(opts.URL, job).
+			Gatherer(registry).
+			Client(&http.Client{Timeout: timeout}),
+	}, nil
+}
+
+// Close reports the final metric values and pushes them to the pushgateway.
+func (p *metricsPusher) Close() error {
+	if err := p.closer.Close(); err != nil {
+		return fmt.Errorf("failed to report metrics to push: %w", err)
+	}
+	if err := p.pusher.Push(); err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	return nil
+}
+
+// teeScope reports metrics to both of its scopes, so that the handler
+// metrics are pushed while still being reported to the handler scope.
+type teeScope struct {
+	primary   tally.Scope
+	secondary tally.Scope
+}
+
+func newTeeScope(primary, secondary tally.Scope) tally.Scope {
+	return teeScope{primary: primary, secondary: secondary}
+}
+
+func (s teeScope) Counter(name string) tally.Counter {
+	return teeCounter{s.primary.Counter(name), s.secondary.Counter(name)}
+}
+
+func (s teeScope) Gauge(name string) tally.Gauge {
+	return teeGauge{s.primary.Gauge(name), s.secondary.Gauge(name)}
+}
+
+func (s teeScope) Timer(name string) tally.Timer {
+	return teeTimer{s.primary.Timer(name), s.secondary.Timer(name)}
+}
+
+func (s teeScope) Histogram(name string, buckets tally.Buckets) tally.Histogram {
+	return teeHistogram{
+		s.primary.Histogram(name, buckets),
+		s.secondary.Histogram(name, buckets),
+	}
+}
+
+func (s teeScope) Tagged(tags map[string]string) tally.Scope {
+	return teeScope{s.primary.Tagged(tags), s.secondary.Tagged(tags)}
+}
+
+func (s teeScope) SubScope(name string) tally.Scope {
+	return teeScope{s.primary.SubScope(name), s.secondary.SubScope(name)}
+}
+
+func (s teeScope) Capabilities() tally.Capabilities {
+	return s.primary.Capabilities()
+}
+
+type teeCounter [2]tally.Counter
+
+func (c teeCounter) Inc(delta int64) {
+	c[0].Inc(delta)
+	c[1].Inc(delta)
+}
+
+type teeGauge [2]tally.Gauge
+
+func (g teeGauge) Update(value float64) {
+	g[0].Update(value)
+	g[1].Update(value)
+}
+
+type teeTimer [2]tally.Timer
+
+func (t teeTimer) Record(value time.Duration) {
+	t[0].Record(value)
+	t[1].Record(value)
+}
+
+func (t teeTimer) Start() tally.Stopwatch {
+	return tally.NewStopwatch(time.Now(), t)
+}
+
+func (t teeTimer) RecordStopwatch(start time.Time) {
+	t.Record(time.Since(start))
+}
+
+type teeHistogram [2]tally.Histogram
+
+func (h teeHistogram) RecordValue(value float64) {
+	h[0].RecordValue(value)
+	h[1].RecordValue(value)
+}
+
+func (h teeHistogram) RecordDuration(value time.Duration) {
+	h[0].RecordDuration(value)
+	h[1].RecordDuration(value)
+}
+
+func (h teeHistogram) Start() tally.Stopwatch {
+	return tally.NewStopwatch(time.Now(), h)
+}
+
+func (h teeHistogram) RecordStopwatch(start time.Time) {
+	h.RecordDuration(time.Since(start))
+}
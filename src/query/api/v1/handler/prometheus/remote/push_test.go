@@ -0,0 +1,179 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	dto "github.com/m3db/prometheus_client_model/go"
+	"github.com/m3db/prometheus_common/expfmt"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestPromWriteMetricsPushOnClose(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	type pushed struct {
+		method   string
+		path     string
+		families map[string]*dto.MetricFamily
+	}
+	pushedCh := make(chan pushed, 1)
+	pushgateway := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			families := make(map[string]*dto.MetricFamily)
+			decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+			for {
+				var family dto.MetricFamily
+				if err := decoder.Decode(&family); err != nil {
+					break
+				}
+				families[family.GetName()] = &family
+			}
+			pushedCh <- pushed{method: r.Method, path: r.URL.Path, families: families}
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer pushgateway.Close()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(3)
+
+	scope := tally.NewTestScope("", map[string]string{"test": "metrics-push-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.MetricsPush = &handleroptions.PromWriteHandlerMetricsPushOptions{
+			URL: pushgateway.URL,
+			Job: "test-job",
+		}
+	})
+
+	for i := 0; i < 3; i++ {
+		promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	}
+
+	// Metrics are still reported to the handler scope.
+	success, ok := scope.Snapshot().Counters()["write.success+handler=remote-write,path=rules,test=metrics-push-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(3), success.Value())
+
+	// Nothing is pushed until the handler is closed.
+	require.Len(t, pushedCh, 0)
+	require.NoError(t, handler.Close())
+
+	result := <-pushedCh
+	require.Equal(t, http.MethodPut, result.method)
+	require.Equal(t, "/metrics/job/test-job", result.path)
+
+	family, ok := result.families["write_success"]
+	require.True(t, ok)
+
+	// NB: A success counter is pushed for each write path.
+	var found bool
+	for _, metric := range family.GetMetric() {
+		labels := make(map[string]string)
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["path"] != string(promWritePathRules) {
+			continue
+		}
+		found = true
+		require.Equal(t, float64(3), metric.GetCounter().GetValue())
+		// NB: Tag values are sanitized for Prometheus.
+		require.Equal(t, "remote_write", labels["handler"])
+	}
+	require.True(t, found)
+}
+
+func TestPromWriteMetricsPushNoURL(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.MetricsPush = &handleroptions.PromWriteHandlerMetricsPushOptions{}
+	opts = opts.SetConfig(cfg)
+
+	_, err := NewPromWriteHandler(opts)
+	require.Equal(t, errNoMetricsPushURL, err)
+}
+
+func TestPromWriteCloseWithoutMetricsPush(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, err := NewPromWriteHandler(makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)))
+	require.NoError(t, err)
+	require.NoError(t, handler.(*PromWriteHandler).Close())
+}
+
+func TestTeeScope(t *testing.T) {
+	primary := tally.NewTestScope("", nil)
+	secondary := tally.NewTestScope("", nil)
+	scope := newTeeScope(primary, secondary).
+		SubScope("write").
+		Tagged(map[string]string{"handler": "remote-write"})
+
+	scope.Counter("success").Inc(2)
+	scope.Gauge("queued").Update(3)
+	scope.Timer("latency").Record(time.Second)
+	scope.Histogram("batch-size", tally.ValueBuckets{1, 10}).RecordValue(5)
+
+	for _, s := range []tally.TestScope{primary, secondary} {
+		snapshot := s.Snapshot()
+		counter, ok := snapshot.Counters()["write.success+handler=remote-write"]
+		require.True(t, ok)
+		require.Equal(t, int64(2), counter.Value())
+
+		gauge, ok := snapshot.Gauges()["write.queued+handler=remote-write"]
+		require.True(t, ok)
+		require.Equal(t, float64(3), gauge.Value())
+
+		timer, ok := snapshot.Timers()["write.latency+handler=remote-write"]
+		require.True(t, ok)
+		require.Equal(t, []time.Duration{time.Second}, timer.Values())
+
+		histogram, ok := snapshot.Histograms()["write.batch-size+handler=remote-write"]
+		require.True(t, ok)
+		require.Equal(t, int64(1), histogram.Values()[10])
+	}
+}
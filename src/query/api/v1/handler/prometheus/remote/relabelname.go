@@ -0,0 +1,92 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+)
+
+// namedSeries returns whether each series carries a non-empty metric name
+// before relabeling, or nil if names emptied by relabeling are not checked.
+func (h *PromWriteHandler) namedSeries(series []prompb.TimeSeries) []bool {
+	if h.handlerOpts.RelabeledEmptyName == "" {
+		return nil
+	}
+	named := make([]bool, len(series))
+	for i := range series {
+		named[i] = hasLabel(series[i].Labels, promMetricNameLabel)
+	}
+	return named
+}
+
+// checkRelabeledNames verifies that the relabeling did not empty or remove
+// the metric name of series that carried one before it, as returned by
+// namedSeries, either rejecting the request or dropping the series. Series
+// the client sent without a metric name are left to the missing name check.
+// Returns the number of series dropped.
+func (h *PromWriteHandler) checkRelabeledNames(
+	req *prompb.WriteRequest,
+	named []bool,
+	relabeling string,
+) (int, error) {
+	if named == nil {
+		return 0, nil
+	}
+
+	var (
+		kept       = req.Timeseries[:0]
+		numDropped int
+	)
+	for i, ts := range req.Timeseries {
+		if !named[i] || hasLabel(ts.Labels, promMetricNameLabel) {
+			kept = append(kept, ts)
+			continue
+		}
+
+		h.metrics.seriesRelabeledEmptyName.Inc(1)
+		if h.handlerOpts.RelabeledEmptyName != handleroptions.PromWriteHandlerRelabeledEmptyNameModeDrop {
+			return 0, fmt.Errorf("series metric name emptied by server side %s, "+
+				"check the relabeling configuration: labels=%s",
+				relabeling, formatPromLabels(ts.Labels))
+		}
+		numDropped++
+	}
+
+	req.Timeseries = kept
+	return numDropped, nil
+}
+
+func formatPromLabels(labels []prompb.Label) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", l.Name, l.Value)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
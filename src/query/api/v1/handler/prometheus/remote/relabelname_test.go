@@ -0,0 +1,179 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/headers"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+type relabeledNameTestWrite struct {
+	series  []prompb.TimeSeries
+	mapTags string
+}
+
+// newRelabeledNameTestHandler returns a handler splitting the metric name on
+// colons into the name and a subsystem label, along with a function writing
+// series that returns the response code and body.
+func newRelabeledNameTestHandler(
+	t *testing.T,
+	ctrl *gomock.Controller,
+	mode handleroptions.PromWriteHandlerRelabeledEmptyNameMode,
+	written *[][]prompb.Label,
+) func(relabeledNameTestWrite) (int, string) {
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			for iter.Next() {
+				var labels []prompb.Label
+				for _, tag := range iter.Current().Tags.Tags {
+					labels = append(labels, prompb.Label{Name: tag.Name, Value: tag.Value})
+				}
+				*written = append(*written, labels)
+			}
+			return nil
+		}).
+		AnyTimes()
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.RelabeledEmptyName = mode
+		cfg.PromRemoteWrite.MissingName = handleroptions.PromWriteHandlerMissingNameModeReject
+		cfg.PromRemoteWrite.LabelSplits = []handleroptions.PromWriteHandlerLabelSplitOptions{
+			{Label: "__name__", Delimiter: ":", TargetLabels: []string{"__name__", "subsystem"}},
+		}
+	})
+
+	return func(w relabeledNameTestWrite) (int, string) {
+		promReqBody := test.GeneratePromWriteRequestBody(t, &prompb.WriteRequest{
+			Timeseries: w.series,
+		})
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		if w.mapTags != "" {
+			req.Header.Set(headers.MapTagsByJSONHeader, w.mapTags)
+		}
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		resp := writer.Result()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+}
+
+func relabeledNameTestSeries(labels ...string) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels:  testLabels(labels...),
+		Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+	}
+}
+
+func TestPromWriteRelabeledEmptyNameReject(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var written [][]prompb.Label
+	write := newRelabeledNameTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerRelabeledEmptyNameModeReject, &written)
+
+	// A split preserving the name is written.
+	code, _ := write(relabeledNameTestWrite{
+		series: []prompb.TimeSeries{relabeledNameTestSeries("__name__", "api:requests")},
+	})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, [][]prompb.Label{
+		testLabels("__name__", "api", "subsystem", "requests"),
+	}, written)
+
+	// A split emptying the name is rejected.
+	code, body := write(relabeledNameTestWrite{
+		series: []prompb.TimeSeries{relabeledNameTestSeries("__name__", ":requests")},
+	})
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Contains(t, body, "series metric name emptied by server side label split")
+	require.Contains(t, body, `subsystem=\"requests\"`)
+
+	// A tag mapping emptying the name is rejected.
+	code, body = write(relabeledNameTestWrite{
+		series:  []prompb.TimeSeries{relabeledNameTestSeries("__name__", "requests")},
+		mapTags: `{"tagMappers":[{"write":{"tag":"__name__","value":""}}]}`,
+	})
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Contains(t, body, "series metric name emptied by server side tag mapping")
+
+	// A series sent without a name is left to the missing name check.
+	code, body = write(relabeledNameTestWrite{
+		series: []prompb.TimeSeries{relabeledNameTestSeries("job", "api")},
+	})
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Contains(t, body, "series has no metric name label")
+	require.Len(t, written, 1)
+}
+
+func TestPromWriteRelabeledEmptyNameDrop(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var written [][]prompb.Label
+	write := newRelabeledNameTestHandler(t, ctrl,
+		handleroptions.PromWriteHandlerRelabeledEmptyNameModeDrop, &written)
+
+	code, _ := write(relabeledNameTestWrite{
+		series: []prompb.TimeSeries{
+			relabeledNameTestSeries("__name__", ":requests"),
+			relabeledNameTestSeries("__name__", "api:requests"),
+		},
+	})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, [][]prompb.Label{
+		testLabels("__name__", "api", "subsystem", "requests"),
+	}, written)
+}
+
+func TestPromWriteRelabeledEmptyNameUnknownMode(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.RelabeledEmptyName = "ignore"
+	opts = opts.SetConfig(cfg)
+
+	_, err := NewPromWriteHandler(opts)
+	require.EqualError(t, err, "unknown relabeled empty name mode: ignore")
+}
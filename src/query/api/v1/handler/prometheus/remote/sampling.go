@@ -0,0 +1,84 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xerrors "github.com/m3db/m3/src/x/errors"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// samplingRange is the range series hashes are reduced to when sampling, to
+// allow fractions down to 0.01% having an effect.
+const samplingRange = 10000
+
+// samplePromSeries returns whether the series is kept by the fraction set
+// by its sampling label, along with its labels without the sampling label.
+// Series without the sampling label are always kept. The labels of the
+// series are not modified since they are shared with forwarding.
+//
+// The decision is made by hashing the pseudo ID of the remaining labels so
+// that the same series are kept across requests and retries, and raising
+// the fraction only keeps additional series.
+func samplePromSeries(
+	labels []prompb.Label,
+	samplingLabel []byte,
+) ([]prompb.Label, bool, error) {
+	if len(samplingLabel) == 0 {
+		return labels, true, nil
+	}
+
+	idx := -1
+	for i, l := range labels {
+		if bytes.Equal(l.Name, samplingLabel) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return labels, true, nil
+	}
+
+	fraction, err := strconv.ParseFloat(string(labels[idx].Value), 64)
+	if err != nil || !(fraction >= 0 && fraction <= 1) {
+		return nil, false, xerrors.NewInvalidParamsError(fmt.Errorf(
+			"invalid sampling label %s value, must be in range [0,1]: %s",
+			samplingLabel, labels[idx].Value))
+	}
+
+	stripped := make([]prompb.Label, 0, len(labels)-1)
+	stripped = append(stripped, labels[:idx]...)
+	stripped = append(stripped, labels[idx+1:]...)
+
+	// NB: Hash a copy of the labels since building the pseudo ID may sort
+	// them, the stored labels keep the order sent by the client.
+	sorted := append([]prompb.Label(nil), stripped...)
+	hash := xxhash.Sum64(buildPseudoIDWithLabelsLikelySorted(sorted, nil))
+	if hash%samplingRange >= uint64(fraction*samplingRange) {
+		return nil, false, nil
+	}
+	return stripped, true, nil
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/query/models"
+	xerrors "github.com/m3db/m3/src/x/errors"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromTSIterSampling(t *testing.T) {
+	const numSeries = 1000
+	newSeries := func(fraction string) []prompb.TimeSeries {
+		series := make([]prompb.TimeSeries, 0, numSeries+1)
+		for i := 0; i < numSeries; i++ {
+			series = append(series, prompb.TimeSeries{
+				Labels: testLabels("__name__", "sampled", "__m3_sample__", fraction,
+					"id", strconv.Itoa(i)),
+				Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+			})
+		}
+		// Series without the sampling label are always kept.
+		return append(series, prompb.TimeSeries{
+			Labels:  testLabels("__name__", "unsampled"),
+			Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+		})
+	}
+
+	tests := []struct {
+		fraction string
+		min, max int
+	}{
+		{fraction: "0", min: 0, max: 0},
+		{fraction: "1", min: numSeries, max: numSeries},
+		{fraction: "0.5", min: 400, max: 600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fraction, func(t *testing.T) {
+			var (
+				series = newSeries(tt.fraction)
+				kept   []string
+			)
+			for i := 0; i < 2; i++ {
+				iter, err := newPromTSIter(series, nil, models.NewTagOptions(), false, false,
+					false, []byte("__m3_sample__"), nil)
+				require.NoError(t, err)
+
+				var ids []string
+				for iter.Next() {
+					tags := iter.Current().Tags
+					_, ok := tags.Get([]byte("__m3_sample__"))
+					require.False(t, ok, "sampling label not removed")
+					if id, ok := tags.Get([]byte("id")); ok {
+						ids = append(ids, string(id))
+					}
+				}
+				require.NoError(t, iter.Error())
+
+				// The same series are kept each time.
+				if i > 0 {
+					require.Equal(t, kept, ids)
+				}
+				kept = ids
+			}
+
+			require.True(t, len(kept) >= tt.min && len(kept) <= tt.max,
+				fmt.Sprintf("kept %d series, expected [%d,%d]", len(kept), tt.min, tt.max))
+
+			// The labels of the request are not modified.
+			require.Equal(t, testLabels("__name__", "sampled", "__m3_sample__",
+				tt.fraction, "id", "0"), series[0].Labels)
+		})
+	}
+}
+
+func TestPromTSIterSamplingSingleSeries(t *testing.T) {
+	for _, tt := range []struct {
+		fraction string
+		kept     bool
+	}{
+		{fraction: "0", kept: false},
+		{fraction: "1", kept: true},
+	} {
+		t.Run(tt.fraction, func(t *testing.T) {
+			series := []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "sampled", "__m3_sample__", tt.fraction),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+				},
+			}
+			iter, err := newPromTSIter(series, nil, models.NewTagOptions(), false, false,
+				false, []byte("__m3_sample__"), nil)
+			require.NoError(t, err)
+			require.Equal(t, tt.kept, iter.Next())
+			if tt.kept {
+				require.Equal(t, 1, iter.Current().Tags.Len())
+				require.False(t, iter.Next())
+			}
+			require.NoError(t, iter.Error())
+		})
+	}
+}
+
+func TestPromTSIterSamplingInvalidFraction(t *testing.T) {
+	for _, fraction := range []string{"abc", "-0.1", "1.5", "NaN"} {
+		t.Run(fraction, func(t *testing.T) {
+			series := []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "sampled", "__m3_sample__", fraction),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+				},
+			}
+			_, err := newPromTSIter(series, nil, models.NewTagOptions(), false, false,
+				false, []byte("__m3_sample__"), nil)
+			require.Error(t, err)
+			require.True(t, xerrors.IsInvalidParams(err))
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+)
+
+const (
+	forwardScheduleTimeOfDayLayout = "15:04"
+	minutesPerDay                  = 24 * 60
+)
+
+var weekdaysByName = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// forwardSchedule is a parsed recurring forwarding time window.
+type forwardSchedule struct {
+	days         [7]bool
+	startMinutes int
+	endMinutes   int
+	location     *time.Location
+}
+
+func newForwardSchedule(
+	opts handleroptions.PromWriteHandlerForwardScheduleOptions,
+) (*forwardSchedule, error) {
+	s := &forwardSchedule{
+		endMinutes: minutesPerDay,
+		location:   time.UTC,
+	}
+
+	if len(opts.Days) == 0 {
+		for i := range s.days {
+			s.days[i] = true
+		}
+	}
+	for _, name := range opts.Days {
+		day, ok := weekdaysByName[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown forwarding schedule day: %s", name)
+		}
+		s.days[day] = true
+	}
+
+	var err error
+	if opts.Start != "" {
+		if s.startMinutes, err = parseForwardScheduleTimeOfDay(opts.Start); err != nil {
+			return nil, err
+		}
+	}
+	if opts.End != "" {
+		if s.endMinutes, err = parseForwardScheduleTimeOfDay(opts.End); err != nil {
+			return nil, err
+		}
+	}
+	if s.startMinutes == s.endMinutes {
+		return nil, fmt.Errorf("forwarding schedule start and end must differ: %s", opts.Start)
+	}
+
+	if opts.Timezone != "" {
+		if s.location, err = time.LoadLocation(opts.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid forwarding schedule timezone: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+func parseForwardScheduleTimeOfDay(value string) (int, error) {
+	t, err := time.Parse(forwardScheduleTimeOfDayLayout, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid forwarding schedule time of day: %s", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains returns whether the time falls within the window.
+func (s *forwardSchedule) contains(t time.Time) bool {
+	t = t.In(s.location)
+	minutes := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if s.startMinutes < s.endMinutes {
+		return s.days[day] && minutes >= s.startMinutes && minutes < s.endMinutes
+	}
+
+	// The window spans midnight, so the early hours belong to the window
+	// that started the previous day.
+	if minutes >= s.startMinutes {
+		return s.days[day]
+	}
+	return minutes < s.endMinutes && s.days[(day+6)%7]
+}
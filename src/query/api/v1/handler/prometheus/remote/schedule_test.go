@@ -0,0 +1,233 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestForwardScheduleContains(t *testing.T) {
+	// 2023-01-02 is a Monday.
+	monday := func(hour, minute int) time.Time {
+		return time.Date(2023, 1, 2, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		opts     handleroptions.PromWriteHandlerForwardScheduleOptions
+		now      time.Time
+		expected bool
+	}{
+		{
+			name:     "business hours inside",
+			opts:     handleroptions.PromWriteHandlerForwardScheduleOptions{Start: "09:00", End: "17:00"},
+			now:      monday(9, 0),
+			expected: true,
+		},
+		{
+			name: "business hours at end",
+			opts: handleroptions.PromWriteHandlerForwardScheduleOptions{Start: "09:00", End: "17:00"},
+			now:  monday(17, 0),
+		},
+		{
+			name: "business hours before start",
+			opts: handleroptions.PromWriteHandlerForwardScheduleOptions{Start: "09:00", End: "17:00"},
+			now:  monday(8, 59),
+		},
+		{
+			name:     "weekday",
+			opts:     handleroptions.PromWriteHandlerForwardScheduleOptions{Days: []string{"Monday"}},
+			now:      monday(23, 59),
+			expected: true,
+		},
+		{
+			name: "other day",
+			opts: handleroptions.PromWriteHandlerForwardScheduleOptions{Days: []string{"tuesday"}},
+			now:  monday(12, 0),
+		},
+		{
+			name: "overnight after midnight",
+			opts: handleroptions.PromWriteHandlerForwardScheduleOptions{
+				Days:  []string{"sunday"},
+				Start: "22:00",
+				End:   "02:00",
+			},
+			now:      monday(1, 0),
+			expected: true,
+		},
+		{
+			name: "overnight after midnight of unscheduled day",
+			opts: handleroptions.PromWriteHandlerForwardScheduleOptions{
+				Days:  []string{"monday"},
+				Start: "22:00",
+				End:   "02:00",
+			},
+			now: monday(1, 0),
+		},
+		{
+			name: "overnight before midnight",
+			opts: handleroptions.PromWriteHandlerForwardScheduleOptions{
+				Days:  []string{"monday"},
+				Start: "22:00",
+				End:   "02:00",
+			},
+			now:      monday(23, 0),
+			expected: true,
+		},
+		{
+			name: "timezone",
+			opts: handleroptions.PromWriteHandlerForwardScheduleOptions{
+				Start:    "09:00",
+				End:      "17:00",
+				Timezone: "America/New_York",
+			},
+			now:      monday(15, 0),
+			expected: true,
+		},
+		{
+			name: "timezone outside",
+			opts: handleroptions.PromWriteHandlerForwardScheduleOptions{
+				Start:    "09:00",
+				End:      "17:00",
+				Timezone: "America/New_York",
+			},
+			now: monday(9, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := newForwardSchedule(tt.opts)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, schedule.contains(tt.now))
+		})
+	}
+}
+
+func TestNewForwardScheduleInvalid(t *testing.T) {
+	tests := []struct {
+		opts        handleroptions.PromWriteHandlerForwardScheduleOptions
+		expectedErr string
+	}{
+		{
+			opts:        handleroptions.PromWriteHandlerForwardScheduleOptions{Days: []string{"someday"}},
+			expectedErr: "unknown forwarding schedule day: someday",
+		},
+		{
+			opts:        handleroptions.PromWriteHandlerForwardScheduleOptions{Start: "9am"},
+			expectedErr: "invalid forwarding schedule time of day: 9am",
+		},
+		{
+			opts:        handleroptions.PromWriteHandlerForwardScheduleOptions{Start: "09:00", End: "09:00"},
+			expectedErr: "forwarding schedule start and end must differ: 09:00",
+		},
+		{
+			opts:        handleroptions.PromWriteHandlerForwardScheduleOptions{Timezone: "Mars/Olympus"},
+			expectedErr: "invalid forwarding schedule timezone: unknown time zone Mars/Olympus",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expectedErr, func(t *testing.T) {
+			_, err := newForwardSchedule(tt.opts)
+			require.EqualError(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestPromWriteForwardSchedule(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(2)
+
+	// 2023-01-02 is a Monday.
+	now := time.Date(2023, 1, 2, 8, 0, 0, 0, time.UTC)
+	scope := tally.NewTestScope("", map[string]string{"test": "forward-schedule-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetNowFn(func() time.Time { return now }).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{
+				URL:     "http://staging",
+				NoRetry: true,
+				Schedule: &handleroptions.PromWriteHandlerForwardScheduleOptions{
+					Days:  []string{"monday", "tuesday", "wednesday", "thursday", "friday"},
+					Start: "09:00",
+					End:   "17:00",
+				},
+			},
+		}
+	})
+
+	forwardedCh := make(chan *http.Request, 2)
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			forwardedCh <- r
+			return newOKResponse(r), nil
+		}),
+	}
+
+	write := func() {
+		promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	}
+
+	// Outside of the window the request is not forwarded.
+	write()
+	skipped, ok := scope.Snapshot().Counters()["forward.window-skipped+handler=remote-write,test=forward-schedule-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), skipped.Value())
+	require.Len(t, forwardedCh, 0)
+
+	// Inside of the window the request is forwarded.
+	now = now.Add(2 * time.Hour)
+	write()
+	select {
+	case <-forwardedCh:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for fwd request")
+	}
+	skipped = scope.Snapshot().Counters()["forward.window-skipped+handler=remote-write,test=forward-schedule-test"]
+	require.Equal(t, int64(1), skipped.Value())
+}
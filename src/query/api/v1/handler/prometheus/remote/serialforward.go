@@ -0,0 +1,126 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"sync"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+)
+
+const defaultForwardSerialQueueSize = 1000
+
+// forwardSerialQueue forwards to a single target by a dedicated goroutine,
+// so that forwards to the target never overlap and are delivered in the
+// order they were queued.
+type forwardSerialQueue struct {
+	sync.RWMutex
+
+	queue  chan func()
+	closed bool
+	done   chan struct{}
+}
+
+func newForwardSerialQueue(size int) *forwardSerialQueue {
+	q := &forwardSerialQueue{
+		queue: make(chan func(), size),
+		done:  make(chan struct{}),
+	}
+	go q.work()
+	return q
+}
+
+func (q *forwardSerialQueue) work() {
+	defer close(q.done)
+	for forward := range q.queue {
+		forward()
+	}
+}
+
+// enqueue queues the forward, returning false if the queue is full or
+// closed.
+func (q *forwardSerialQueue) enqueue(forward func()) bool {
+	q.RLock()
+	defer q.RUnlock()
+
+	if q.closed {
+		return false
+	}
+
+	select {
+	case q.queue <- forward:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close waits for the queued forwards to complete, further forwards are
+// rejected.
+func (q *forwardSerialQueue) Close() {
+	q.Lock()
+	if q.closed {
+		q.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.queue)
+	q.Unlock()
+
+	<-q.done
+}
+
+// forwardSerialQueues are the serial queues indexed by target, nil for
+// targets that are not serial.
+type forwardSerialQueues []*forwardSerialQueue
+
+func newForwardSerialQueues(
+	targets []handleroptions.PromWriteHandlerForwardTargetOptions,
+) forwardSerialQueues {
+	var queues forwardSerialQueues
+	for i, target := range targets {
+		if !target.Serial {
+			continue
+		}
+		if queues == nil {
+			queues = make(forwardSerialQueues, len(targets))
+		}
+		queues[i] = newForwardSerialQueue(defaultForwardSerialQueueSize)
+	}
+	return queues
+}
+
+// get returns the serial queue of the target, or nil if it is not serial.
+func (q forwardSerialQueues) get(targetIdx int) *forwardSerialQueue {
+	if targetIdx < len(q) {
+		return q[targetIdx]
+	}
+	return nil
+}
+
+// Close waits for the forwards queued to every serial target to complete.
+func (q forwardSerialQueues) Close() {
+	for _, queue := range q {
+		if queue != nil {
+			queue.Close()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromWriteForwardSerialNeverOverlaps(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	const numRequests = 20
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(numRequests)
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: "http://serial", NoRetry: true, Serial: true},
+			{URL: "http://concurrent", NoRetry: true},
+		}
+	})
+
+	var (
+		inFlight    = make(map[string]*int64)
+		maxInFlight = make(map[string]*int64)
+		forwarded   = make(map[string]*int64)
+	)
+	for _, host := range []string{"serial", "concurrent"} {
+		inFlight[host] = new(int64)
+		maxInFlight[host] = new(int64)
+		forwarded[host] = new(int64)
+	}
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			host := r.URL.Host
+			n := atomic.AddInt64(inFlight[host], 1)
+			for {
+				max := atomic.LoadInt64(maxInFlight[host])
+				if n <= max || atomic.CompareAndSwapInt64(maxInFlight[host], max, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt64(inFlight[host], -1)
+			atomic.AddInt64(forwarded[host], 1)
+			return newOKResponse(r), nil
+		}),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < numRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+		}()
+	}
+	wg.Wait()
+
+	// Closing waits for the queued serial forwards to complete.
+	require.NoError(t, handler.Close())
+	require.Equal(t, int64(numRequests), atomic.LoadInt64(forwarded["serial"]))
+	require.Equal(t, int64(1), atomic.LoadInt64(maxInFlight["serial"]))
+}
+
+func TestForwardSerialQueueOrderAndClose(t *testing.T) {
+	q := newForwardSerialQueue(10)
+
+	var order []int
+	for i := 0; i < 5; i++ {
+		i := i
+		require.True(t, q.enqueue(func() {
+			order = append(order, i)
+		}))
+	}
+	q.Close()
+
+	require.Equal(t, []int{0, 1, 2, 3, 4}, order)
+	require.False(t, q.enqueue(func() {}))
+}
+
+func TestForwardSerialQueueFull(t *testing.T) {
+	q := newForwardSerialQueue(1)
+	defer q.Close()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	require.True(t, q.enqueue(func() {
+		close(started)
+		<-release
+	}))
+	<-started
+
+	require.True(t, q.enqueue(func() {}))
+	require.False(t, q.enqueue(func() {}))
+	close(release)
+}
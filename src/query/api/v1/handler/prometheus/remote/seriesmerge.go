@@ -0,0 +1,126 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+
+	"github.com/uber-go/tally"
+)
+
+// seriesMerger merges entries of a request with the same labels, for
+// clients that split a series across multiple entries of a request.
+type seriesMerger struct {
+	keepLast          bool
+	merged            tally.Counter
+	duplicatesDropped tally.Counter
+}
+
+func newSeriesMerger(
+	opts handleroptions.PromWriteHandlerSeriesMergingOptions,
+	scope tally.Scope,
+) (*seriesMerger, error) {
+	switch opts.Duplicates {
+	case "", handleroptions.PromWriteHandlerDuplicateSampleModeKeepFirst,
+		handleroptions.PromWriteHandlerDuplicateSampleModeKeepLast:
+	default:
+		return nil, fmt.Errorf("unknown duplicate sample mode: %s", opts.Duplicates)
+	}
+
+	writeScope := scope.SubScope("write")
+	return &seriesMerger{
+		keepLast:          opts.Duplicates == handleroptions.PromWriteHandlerDuplicateSampleModeKeepLast,
+		merged:            writeScope.Counter("series-merged"),
+		duplicatesDropped: writeScope.Counter("duplicate-samples-dropped"),
+	}, nil
+}
+
+// merge groups the entries of the request by series ID, appending the
+// samples and exemplars of later entries to the first entry of the series.
+// The samples of merged series are sorted by timestamp, keeping a single
+// sample per timestamp. Entries that are not merged are left as is.
+func (m *seriesMerger) merge(req *prompb.WriteRequest) {
+	var (
+		kept   = req.Timeseries[:0]
+		byID   = make(map[string]int, len(req.Timeseries))
+		merged map[int]struct{}
+		buffer []prompb.Label
+		id     []byte
+	)
+	for _, ts := range req.Timeseries {
+		buffer = append(buffer[:0], ts.Labels...)
+		id = buildPseudoIDWithLabelsLikelySorted(buffer, id[:0])
+		idx, ok := byID[string(id)]
+		if !ok {
+			byID[string(id)] = len(kept)
+			kept = append(kept, ts)
+			continue
+		}
+
+		if merged == nil {
+			merged = make(map[int]struct{})
+		}
+		into := &kept[idx]
+		if _, ok := merged[idx]; !ok {
+			// NB: Copy the samples and exemplars of the first entry before
+			// appending so the merged series never writes into the spare
+			// capacity of a slice it does not own.
+			merged[idx] = struct{}{}
+			into.Samples = append([]prompb.Sample(nil), into.Samples...)
+			into.Exemplars = append([]prompb.Exemplar(nil), into.Exemplars...)
+		}
+		into.Samples = append(into.Samples, ts.Samples...)
+		into.Exemplars = append(into.Exemplars, ts.Exemplars...)
+	}
+
+	if len(merged) == 0 {
+		return
+	}
+
+	var numDropped int
+	for idx := range merged {
+		samples := kept[idx].Samples
+		sort.SliceStable(samples, func(i, j int) bool {
+			return samples[i].Timestamp < samples[j].Timestamp
+		})
+		deduped := samples[:0]
+		for _, sample := range samples {
+			n := len(deduped)
+			if n == 0 || deduped[n-1].Timestamp != sample.Timestamp {
+				deduped = append(deduped, sample)
+				continue
+			}
+			if m.keepLast {
+				deduped[n-1] = sample
+			}
+			numDropped++
+		}
+		kept[idx].Samples = deduped
+	}
+
+	m.merged.Inc(int64(len(req.Timeseries) - len(kept)))
+	m.duplicatesDropped.Inc(int64(numDropped))
+	req.Timeseries = kept
+}
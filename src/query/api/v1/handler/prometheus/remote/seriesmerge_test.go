@@ -0,0 +1,232 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestSeriesMerger(t *testing.T) {
+	tests := []struct {
+		name       string
+		duplicates handleroptions.PromWriteHandlerDuplicateSampleMode
+		series     []prompb.TimeSeries
+		expected   []prompb.TimeSeries
+	}{
+		{
+			name: "same labels in two entries",
+			series: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "up", "job", "a"),
+					Samples: []prompb.Sample{{Timestamp: 3, Value: 3}},
+				},
+				{
+					Labels:  testLabels("__name__", "up", "job", "b"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+				},
+				{
+					Labels:  testLabels("__name__", "up", "job", "a"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}},
+				},
+			},
+			expected: []prompb.TimeSeries{
+				{
+					Labels: testLabels("__name__", "up", "job", "a"),
+					Samples: []prompb.Sample{
+						{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}, {Timestamp: 3, Value: 3},
+					},
+				},
+				{
+					Labels:  testLabels("__name__", "up", "job", "b"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+				},
+			},
+		},
+		{
+			name: "different label order",
+			series: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "up", "job", "a"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+				},
+				{
+					Labels:  testLabels("job", "a", "__name__", "up"),
+					Samples: []prompb.Sample{{Timestamp: 2, Value: 2}},
+				},
+			},
+			expected: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "up", "job", "a"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}},
+				},
+			},
+		},
+		{
+			name: "conflicting timestamp keep first",
+			series: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "up"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}},
+				},
+				{
+					Labels:  testLabels("__name__", "up"),
+					Samples: []prompb.Sample{{Timestamp: 2, Value: 20}},
+				},
+			},
+			expected: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "up"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}},
+				},
+			},
+		},
+		{
+			name:       "conflicting timestamp keep last",
+			duplicates: handleroptions.PromWriteHandlerDuplicateSampleModeKeepLast,
+			series: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "up"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}},
+				},
+				{
+					Labels:  testLabels("__name__", "up"),
+					Samples: []prompb.Sample{{Timestamp: 2, Value: 20}},
+				},
+			},
+			expected: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "up"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 20}},
+				},
+			},
+		},
+		{
+			name: "unmerged entries untouched",
+			series: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "up"),
+					Samples: []prompb.Sample{{Timestamp: 2, Value: 2}, {Timestamp: 1, Value: 1}},
+				},
+			},
+			expected: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "up"),
+					Samples: []prompb.Sample{{Timestamp: 2, Value: 2}, {Timestamp: 1, Value: 1}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merger, err := newSeriesMerger(handleroptions.PromWriteHandlerSeriesMergingOptions{
+				Duplicates: tt.duplicates,
+			}, tally.NoopScope)
+			require.NoError(t, err)
+
+			req := &prompb.WriteRequest{Timeseries: tt.series}
+			merger.merge(req)
+			require.Equal(t, tt.expected, req.Timeseries)
+		})
+	}
+}
+
+func TestSeriesMergerUnknownDuplicates(t *testing.T) {
+	_, err := newSeriesMerger(handleroptions.PromWriteHandlerSeriesMergingOptions{
+		Duplicates: "keep-middle",
+	}, tally.NoopScope)
+	require.EqualError(t, err, "unknown duplicate sample mode: keep-middle")
+}
+
+func TestPromWriteSeriesMerging(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		numSeries int
+		written   []float64
+	)
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			for iter.Next() {
+				numSeries++
+				for _, dp := range iter.Current().Datapoints {
+					written = append(written, dp.Value)
+				}
+			}
+			return nil
+		})
+
+	scope := tally.NewTestScope("", map[string]string{"test": "series-merging-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.MalformedSeries = handleroptions.PromWriteHandlerMalformedSeriesModeReject
+		cfg.PromRemoteWrite.SeriesMerging = &handleroptions.PromWriteHandlerSeriesMergingOptions{}
+	})
+
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  testLabels("__name__", "up", "job", "a"),
+				Samples: []prompb.Sample{{Timestamp: 2000, Value: 2}},
+			},
+			{
+				Labels:  testLabels("__name__", "up", "job", "a"),
+				Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 20}},
+			},
+		},
+	}
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	require.Equal(t, 1, numSeries)
+	require.Equal(t, []float64{1, 2}, written)
+
+	counters := scope.Snapshot().Counters()
+	merged, ok := counters["write.series-merged+handler=remote-write,test=series-merging-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), merged.Value())
+	dropped, ok := counters["write.duplicate-samples-dropped+handler=remote-write,test=series-merging-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), dropped.Value())
+}
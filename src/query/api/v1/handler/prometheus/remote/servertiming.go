@@ -0,0 +1,55 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	serverTimingHeader = "Server-Timing"
+
+	serverTimingParse   = "parse"
+	serverTimingForward = "forward"
+	serverTimingWrite   = "write"
+)
+
+// addServerTiming adds an entry for the duration of the stage since start to
+// the Server-Timing response header if enabled, each stage is added as soon
+// as it completes so that entries are present even for stages that failed.
+func (h *PromWriteHandler) addServerTiming(
+	w http.ResponseWriter,
+	stage string,
+	start time.Time,
+) {
+	if !h.handlerOpts.ServerTiming {
+		return
+	}
+	w.Header().Add(serverTimingHeader, formatServerTiming(stage, time.Since(start)))
+}
+
+// formatServerTiming formats the duration in milliseconds as required by the
+// Server-Timing header.
+func formatServerTiming(stage string, d time.Duration) string {
+	return fmt.Sprintf("%s;dur=%.3f", stage, float64(d)/float64(time.Millisecond))
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatServerTiming(t *testing.T) {
+	require.Equal(t, "write;dur=12.346",
+		formatServerTiming("write", 12345678*time.Nanosecond))
+	require.Equal(t, "parse;dur=0.000", formatServerTiming("parse", 0))
+}
+
+// parseServerTimings returns the durations of the Server-Timing header values
+// keyed by stage.
+func parseServerTimings(t *testing.T, values []string) map[string]time.Duration {
+	timings := make(map[string]time.Duration, len(values))
+	for _, v := range values {
+		parts := strings.Split(v, ";dur=")
+		require.Len(t, parts, 2, v)
+		ms, err := strconv.ParseFloat(parts[1], 64)
+		require.NoError(t, err)
+		timings[parts[0]] = time.Duration(ms * float64(time.Millisecond))
+	}
+	return timings
+}
+
+func TestPromWriteServerTiming(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	const writeDelay = 20 * time.Millisecond
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, ingest.DownsampleAndWriteIter, ingest.WriteOptions) ingest.BatchError {
+			time.Sleep(writeDelay)
+			return nil
+		})
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.ServerTiming = true
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: "http://target", NoRetry: true},
+		}
+	})
+
+	forwardedCh := make(chan struct{}, 1)
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			forwardedCh <- struct{}{}
+			return newOKResponse(r), nil
+		}),
+	}
+
+	start := time.Now()
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	elapsed := time.Since(start)
+
+	resp := writer.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	values := resp.Header.Values(serverTimingHeader)
+	require.Len(t, values, 3)
+	timings := parseServerTimings(t, values)
+	for _, stage := range []string{
+		serverTimingParse, serverTimingForward, serverTimingWrite,
+	} {
+		d, ok := timings[stage]
+		require.True(t, ok, stage)
+		require.True(t, d >= 0 && d <= elapsed, "%s: %s", stage, d)
+	}
+	require.True(t, timings[serverTimingWrite] >= writeDelay)
+
+	select {
+	case <-forwardedCh:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for fwd request")
+	}
+}
+
+func TestPromWriteServerTimingDisabled(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	handler, err := NewPromWriteHandler(makeOptions(mockDownsamplerAndWriter))
+	require.NoError(t, err)
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+
+	resp := writer.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.Header.Values(serverTimingHeader))
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+const defaultForwardSigV4Service = "aps"
+
+var (
+	errForwardSigV4NoRegion = errors.New("forwarding sigv4 must have a region")
+	errForwardSigV4NoSecret = errors.New(
+		"forwarding sigv4 must have a secret access key with an access key ID")
+	errForwardSigV4WithOAuth2 = errors.New(
+		"forwarding target cannot use both sigv4 and oauth2")
+)
+
+// forwardSigner signs the requests forwarded to a target with AWS Signature
+// Version 4.
+type forwardSigner struct {
+	signer  *v4.Signer
+	region  string
+	service string
+}
+
+// forwardSigners is the signers of the forwarding targets signed with SigV4,
+// keyed by the target SigV4 options which are shared by every copy of the
+// target.
+type forwardSigners map[*handleroptions.PromWriteHandlerForwardSigV4Options]forwardSigner
+
+func newForwardSigners(
+	targets []handleroptions.PromWriteHandlerForwardTargetOptions,
+) (forwardSigners, error) {
+	var signers forwardSigners
+	for _, target := range targets {
+		opts := target.SigV4
+		if opts == nil {
+			continue
+		}
+		if target.OAuth2 != nil {
+			return nil, errForwardSigV4WithOAuth2
+		}
+		if opts.Region == "" {
+			return nil, errForwardSigV4NoRegion
+		}
+
+		var creds *credentials.Credentials
+		switch {
+		case opts.AccessKeyID != "":
+			if opts.SecretAccessKey == "" {
+				return nil, errForwardSigV4NoSecret
+			}
+			creds = credentials.NewStaticCredentials(opts.AccessKeyID,
+				opts.SecretAccessKey, opts.SessionToken)
+		default:
+			creds = credentials.NewChainCredentials([]credentials.Provider{
+				&credentials.EnvProvider{},
+				&credentials.SharedCredentialsProvider{Profile: opts.Profile},
+			})
+		}
+
+		service := opts.Service
+		if service == "" {
+			service = defaultForwardSigV4Service
+		}
+
+		if signers == nil {
+			signers = make(forwardSigners)
+		}
+		signers[opts] = forwardSigner{
+			signer:  v4.NewSigner(creds),
+			region:  opts.Region,
+			service: service,
+		}
+	}
+	return signers, nil
+}
+
+// sign signs the request forwarded to the target if it is signed with
+// SigV4, which must be done once every other header is set since the
+// signature covers the headers.
+func (s forwardSigners) sign(
+	req *http.Request,
+	body io.Reader,
+	target handleroptions.PromWriteHandlerForwardTargetOptions,
+	now time.Time,
+) error {
+	signer, ok := s[target.SigV4]
+	if !ok {
+		return nil
+	}
+
+	// NB: The signature covers a hash of the body so it must be seekable.
+	seeker, ok := body.(io.ReadSeeker)
+	if !ok {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		seeker = bytes.NewReader(data)
+	}
+	_, err := signer.signer.Sign(req, seeker, signer.service, signer.region, now)
+	return err
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromWriteForwardSigV4(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	type signedRequest struct {
+		authorization string
+		date          string
+		body          string
+	}
+	var received []signedRequest
+	targetSvr := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body bytes.Buffer
+			_, err := body.ReadFrom(r.Body)
+			require.NoError(t, err)
+			received = append(received, signedRequest{
+				authorization: r.Header.Get("Authorization"),
+				date:          r.Header.Get("X-Amz-Date"),
+				body:          body.String(),
+			})
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer targetSvr.Close()
+
+	now := time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)).
+		SetNowFn(func() time.Time { return now })
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{
+				URL: targetSvr.URL,
+				SigV4: &handleroptions.PromWriteHandlerForwardSigV4Options{
+					Region:          "us-east-1",
+					AccessKeyID:     "AKIDEXAMPLE",
+					SecretAccessKey: "secret",
+				},
+			},
+		}
+	})
+
+	target := handler.forwarding.Targets[0]
+	for _, payload := range []string{"payload", "payload", "other payload"} {
+		err := handler.forwardBody(context.Background(), strings.NewReader(payload), nil, target)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, received, 3)
+	for i, payload := range []string{"payload", "payload", "other payload"} {
+		require.True(t, strings.HasPrefix(received[i].authorization,
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20210601/us-east-1/aps/aws4_request, "),
+			received[i].authorization)
+		require.Equal(t, "20210601T123000Z", received[i].date)
+		require.Equal(t, payload, received[i].body)
+	}
+	// The signature is deterministic for a fixed payload and time.
+	require.Equal(t, received[0], received[1])
+	require.NotEqual(t, received[0].authorization, received[2].authorization)
+}
+
+func TestPromWriteForwardSigV4Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      handleroptions.PromWriteHandlerForwardTargetOptions
+		expectedErr error
+	}{
+		{
+			name: "no region",
+			target: handleroptions.PromWriteHandlerForwardTargetOptions{
+				SigV4: &handleroptions.PromWriteHandlerForwardSigV4Options{},
+			},
+			expectedErr: errForwardSigV4NoRegion,
+		},
+		{
+			name: "no secret access key",
+			target: handleroptions.PromWriteHandlerForwardTargetOptions{
+				SigV4: &handleroptions.PromWriteHandlerForwardSigV4Options{
+					Region:      "us-east-1",
+					AccessKeyID: "AKIDEXAMPLE",
+				},
+			},
+			expectedErr: errForwardSigV4NoSecret,
+		},
+		{
+			name: "with oauth2",
+			target: handleroptions.PromWriteHandlerForwardTargetOptions{
+				SigV4: &handleroptions.PromWriteHandlerForwardSigV4Options{
+					Region: "us-east-1",
+				},
+				OAuth2: &handleroptions.PromWriteHandlerForwardOAuth2Options{
+					TokenURL: "http://token",
+					ClientID: "client",
+				},
+			},
+			expectedErr: errForwardSigV4WithOAuth2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			target := tt.target
+			target.URL = "http://target"
+			opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+			cfg := opts.Config()
+			cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{target}
+			_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+			require.Equal(t, tt.expectedErr, err)
+		})
+	}
+}
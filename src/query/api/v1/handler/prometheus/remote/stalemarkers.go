@@ -0,0 +1,126 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+
+	"github.com/prometheus/prometheus/model/value"
+)
+
+// handleStaleMarkers applies the stale markers mode to the Prometheus stale
+// marker samples of the request, which are told apart from ordinary NaN
+// values by their exact bit pattern.
+func (h *PromWriteHandler) handleStaleMarkers(req *prompb.WriteRequest) {
+	switch h.handlerOpts.StaleMarkers {
+	case handleroptions.PromWriteHandlerStaleMarkersModeDrop:
+		h.dropStaleMarkers(req)
+	case handleroptions.PromWriteHandlerStaleMarkersModeAnnotate:
+		h.annotateStaleMarkers(req)
+	}
+}
+
+// dropStaleMarkers removes stale markers, dropping the series left without
+// samples.
+func (h *PromWriteHandler) dropStaleMarkers(req *prompb.WriteRequest) {
+	kept := req.Timeseries[:0]
+	for _, series := range req.Timeseries {
+		if !hasStaleMarker(series.Samples) {
+			kept = append(kept, series)
+			continue
+		}
+
+		samples := series.Samples[:0]
+		for _, sample := range series.Samples {
+			if value.IsStaleNaN(sample.Value) {
+				h.metrics.staleMarkersDropped.Inc(1)
+				continue
+			}
+			samples = append(samples, sample)
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		series.Samples = samples
+		kept = append(kept, series)
+	}
+	req.Timeseries = kept
+}
+
+// annotateStaleMarkers moves the stale markers of each series to a copy of
+// the series, since annotations apply to every sample of a series. The series
+// left with only stale markers are annotated and written with ordinary NaN
+// values, see seriesMarkers.
+func (h *PromWriteHandler) annotateStaleMarkers(req *prompb.WriteRequest) {
+	n := len(req.Timeseries)
+	for i := 0; i < n; i++ {
+		series := &req.Timeseries[i]
+		if !hasStaleMarker(series.Samples) {
+			continue
+		}
+
+		var (
+			samples = make([]prompb.Sample, 0, len(series.Samples))
+			stale   []prompb.Sample
+		)
+		for _, sample := range series.Samples {
+			if value.IsStaleNaN(sample.Value) {
+				h.metrics.staleMarkersAnnotated.Inc(1)
+				stale = append(stale, sample)
+				continue
+			}
+			samples = append(samples, sample)
+		}
+
+		if len(samples) == 0 {
+			continue
+		}
+
+		staleSeries := *series
+		staleSeries.Samples = stale
+		staleSeries.Exemplars = nil
+		series.Samples = samples
+		// NB: Appending may reallocate the series so the pointer to the
+		// current series must not be used after this.
+		req.Timeseries = append(req.Timeseries, staleSeries)
+	}
+}
+
+// onlyStaleMarkers returns whether the series has samples and all of them are
+// stale markers.
+func onlyStaleMarkers(samples []prompb.Sample) bool {
+	for _, sample := range samples {
+		if !value.IsStaleNaN(sample.Value) {
+			return false
+		}
+	}
+	return len(samples) > 0
+}
+
+func hasStaleMarker(samples []prompb.Sample) bool {
+	for _, sample := range samples {
+		if value.IsStaleNaN(sample.Value) {
+			return true
+		}
+	}
+	return false
+}
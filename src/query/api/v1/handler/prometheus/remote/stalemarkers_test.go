@@ -0,0 +1,170 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"math"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/query/models"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/stretchr/testify/require"
+)
+
+// testStaleMarkerSample is a sample in a stale markers test, whose value is
+// compared by bit pattern to tell NaN values apart.
+type testStaleMarkerSample struct {
+	timestamp int64
+	bits      uint64
+}
+
+func TestPromWriteStaleMarkers(t *testing.T) {
+	var (
+		staleNaN   = math.Float64frombits(value.StaleNaN)
+		regularNaN = math.NaN()
+		staleBits  = value.StaleNaN
+		nanBits    = math.Float64bits(regularNaN)
+		oneBits    = math.Float64bits(1)
+	)
+	newRequest := func() *prompb.WriteRequest {
+		return &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "mixed"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: staleNaN}},
+				},
+				{
+					Labels:  testLabels("__name__", "stale"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: staleNaN}},
+				},
+				{
+					Labels:  testLabels("__name__", "nan"),
+					Samples: []prompb.Sample{{Timestamp: 1, Value: regularNaN}},
+				},
+			},
+		}
+	}
+
+	type expectedSeries struct {
+		name        string
+		samples     []testStaleMarkerSample
+		staleMarker bool
+	}
+	tests := []struct {
+		mode     handleroptions.PromWriteHandlerStaleMarkersMode
+		expected []expectedSeries
+	}{
+		{
+			mode: handleroptions.PromWriteHandlerStaleMarkersModePassthrough,
+			expected: []expectedSeries{
+				{name: "mixed", samples: []testStaleMarkerSample{{1, oneBits}, {2, staleBits}}},
+				{name: "stale", samples: []testStaleMarkerSample{{1, staleBits}}},
+				{name: "nan", samples: []testStaleMarkerSample{{1, nanBits}}},
+			},
+		},
+		{
+			mode: handleroptions.PromWriteHandlerStaleMarkersModeDrop,
+			expected: []expectedSeries{
+				{name: "mixed", samples: []testStaleMarkerSample{{1, oneBits}}},
+				{name: "nan", samples: []testStaleMarkerSample{{1, nanBits}}},
+			},
+		},
+		{
+			mode: handleroptions.PromWriteHandlerStaleMarkersModeAnnotate,
+			expected: []expectedSeries{
+				{name: "mixed", samples: []testStaleMarkerSample{{1, oneBits}}},
+				{name: "stale", samples: []testStaleMarkerSample{{1, staleBits}}, staleMarker: true},
+				{name: "nan", samples: []testStaleMarkerSample{{1, nanBits}}},
+				{name: "mixed", samples: []testStaleMarkerSample{{2, staleBits}}, staleMarker: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+			h := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.StaleMarkers = tt.mode
+			})
+
+			req := newRequest()
+			h.handleStaleMarkers(req)
+
+			require.Equal(t, len(tt.expected), len(req.Timeseries))
+			for i, expected := range tt.expected {
+				series := req.Timeseries[i]
+				require.Equal(t, testLabels("__name__", expected.name), series.Labels)
+
+				samples := make([]testStaleMarkerSample, 0, len(series.Samples))
+				for _, sample := range series.Samples {
+					samples = append(samples, testStaleMarkerSample{
+						timestamp: sample.Timestamp,
+						bits:      math.Float64bits(sample.Value),
+					})
+				}
+				require.Equal(t, expected.samples, samples)
+			}
+
+			if tt.mode != handleroptions.PromWriteHandlerStaleMarkersModeAnnotate {
+				return
+			}
+
+			iter, err := newPromTSIter(req.Timeseries, h.seriesMarkers(req.Timeseries),
+				models.NewTagOptions(), false, false, false, nil, nil)
+			require.NoError(t, err)
+			for _, expected := range tt.expected {
+				require.True(t, iter.Next())
+				value := iter.Current()
+				if !expected.staleMarker {
+					require.Nil(t, value.Annotation)
+					continue
+				}
+				require.True(t, unmarshalAnnotation(t, value.Annotation).StaleMarker)
+				// NB: Annotated stale markers are written as ordinary NaN values.
+				for _, datapoint := range value.Datapoints {
+					require.Equal(t, nanBits, math.Float64bits(datapoint.Value))
+				}
+			}
+			require.False(t, iter.Next())
+			require.NoError(t, iter.Error())
+		})
+	}
+}
+
+func TestPromWriteStaleMarkersInvalidMode(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.StaleMarkers = "convert"
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.EqualError(t, err, "unknown stale markers mode: convert")
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/uber-go/tally"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+)
+
+const (
+	defaultTenantMetricsMaxTenants = 100
+
+	// tenantMetricsOtherTenant is the tenant tag value of requests from
+	// tenants beyond the bound of distinct tenants tracked.
+	tenantMetricsOtherTenant = "other"
+)
+
+// promWriteResultMetrics are the success and error counters of write
+// requests.
+type promWriteResultMetrics struct {
+	writeSuccess      map[promWritePath]tally.Counter
+	writeErrorsServer tally.Counter
+	writeErrorsClient tally.Counter
+}
+
+func newPromWriteResultMetrics(scope tally.Scope) promWriteResultMetrics {
+	writeSuccess := make(map[promWritePath]tally.Counter)
+	for _, path := range []promWritePath{
+		promWritePathRules,
+		promWritePathOverride,
+		promWritePathAggregateWriteType,
+	} {
+		writeSuccess[path] = scope.SubScope("write").
+			Tagged(map[string]string{"path": string(path)}).
+			Counter("success")
+	}
+	return promWriteResultMetrics{
+		writeSuccess:      writeSuccess,
+		writeErrorsServer: scope.SubScope("write").Tagged(map[string]string{"code": "5XX"}).Counter("errors"),
+		writeErrorsClient: scope.SubScope("write").Tagged(map[string]string{"code": "4XX"}).Counter("errors"),
+	}
+}
+
+// fanout returns result metrics incrementing both the receiver and other.
+func (m promWriteResultMetrics) fanout(other promWriteResultMetrics) promWriteResultMetrics {
+	writeSuccess := make(map[promWritePath]tally.Counter, len(m.writeSuccess))
+	for path, counter := range m.writeSuccess {
+		writeSuccess[path] = fanoutCounter{counter, other.writeSuccess[path]}
+	}
+	return promWriteResultMetrics{
+		writeSuccess:      writeSuccess,
+		writeErrorsServer: fanoutCounter{m.writeErrorsServer, other.writeErrorsServer},
+		writeErrorsClient: fanoutCounter{m.writeErrorsClient, other.writeErrorsClient},
+	}
+}
+
+// fanoutCounter is a counter incrementing each of its counters.
+type fanoutCounter []tally.Counter
+
+var _ tally.Counter = fanoutCounter(nil)
+
+func (c fanoutCounter) Inc(delta int64) {
+	for _, counter := range c {
+		counter.Inc(delta)
+	}
+}
+
+// promWriteTenantMetrics are the result metrics of each tenant, tagged with
+// the tenant and fanned out to the global result metrics. The number of
+// distinct tenants is bounded to bound the cardinality of the metrics, the
+// requests of tenants beyond the bound are all tagged as other.
+type promWriteTenantMetrics struct {
+	sync.RWMutex
+
+	header     string
+	maxTenants int
+	scope      tally.Scope
+	global     promWriteResultMetrics
+	other      promWriteResultMetrics
+	tenants    map[string]promWriteResultMetrics
+}
+
+func newPromWriteTenantMetrics(
+	opts handleroptions.PromWriteHandlerTenantMetricsOptions,
+	scope tally.Scope,
+	global promWriteResultMetrics,
+) *promWriteTenantMetrics {
+	maxTenants := opts.MaxTenants
+	if maxTenants <= 0 {
+		maxTenants = defaultTenantMetricsMaxTenants
+	}
+	return &promWriteTenantMetrics{
+		header:     opts.Header,
+		maxTenants: maxTenants,
+		scope:      scope,
+		global:     global,
+		other:      global.fanout(newTenantResultMetrics(scope, tenantMetricsOtherTenant)),
+		tenants:    make(map[string]promWriteResultMetrics),
+	}
+}
+
+func newTenantResultMetrics(scope tally.Scope, tenant string) promWriteResultMetrics {
+	return newPromWriteResultMetrics(scope.Tagged(map[string]string{"tenant": tenant}))
+}
+
+// forRequest returns the result metrics of the tenant of the request,
+// requests without a tenant only increment the global result metrics.
+func (m *promWriteTenantMetrics) forRequest(r *http.Request) promWriteResultMetrics {
+	tenant := r.Header.Get(m.header)
+	if tenant == "" {
+		return m.global
+	}
+
+	m.RLock()
+	metrics, ok := m.tenants[tenant]
+	m.RUnlock()
+	if ok {
+		return metrics
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if metrics, ok := m.tenants[tenant]; ok {
+		return metrics
+	}
+	if len(m.tenants) >= m.maxTenants {
+		return m.other
+	}
+	metrics = m.global.fanout(newTenantResultMetrics(m.scope, tenant))
+	m.tenants[tenant] = metrics
+	return metrics
+}
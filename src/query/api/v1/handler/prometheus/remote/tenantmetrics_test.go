@@ -0,0 +1,141 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const testTenantMetricsHeader = "X-Tenant"
+
+func TestPromWriteTenantMetrics(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes()
+
+	scope := tally.NewTestScope("", map[string]string{"test": "tenant-metrics-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.TenantMetrics = &handleroptions.PromWriteHandlerTenantMetricsOptions{
+			Header:     testTenantMetricsHeader,
+			MaxTenants: 1,
+		}
+	})
+
+	write := func(tenant string, valid bool) int {
+		body := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+		if !valid {
+			body = strings.NewReader("invalid")
+		}
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+		if tenant != "" {
+			req.Header.Set(testTenantMetricsHeader, tenant)
+		}
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		return writer.Result().StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, write("a", true))
+	require.Equal(t, http.StatusOK, write("a", true))
+	require.Equal(t, http.StatusBadRequest, write("a", false))
+	require.Equal(t, http.StatusOK, write("", true))
+	// Tenants beyond the max tenants are tagged as other.
+	require.Equal(t, http.StatusOK, write("b", true))
+
+	counters := scope.Snapshot().Counters()
+	for _, tt := range []struct {
+		key      string
+		expected int64
+	}{
+		{key: "write.success+handler=remote-write,path=rules,test=tenant-metrics-test", expected: 4},
+		{key: "write.errors+code=4XX,handler=remote-write,test=tenant-metrics-test", expected: 1},
+		{key: "write.success+handler=remote-write,path=rules,tenant=a,test=tenant-metrics-test", expected: 2},
+		{key: "write.errors+code=4XX,handler=remote-write,tenant=a,test=tenant-metrics-test", expected: 1},
+		{key: "write.success+handler=remote-write,path=rules,tenant=other,test=tenant-metrics-test", expected: 1},
+	} {
+		counter, ok := counters[tt.key]
+		require.True(t, ok, tt.key)
+		require.Equal(t, tt.expected, counter.Value(), tt.key)
+	}
+	require.NotContains(t, counters,
+		"write.success+handler=remote-write,path=rules,tenant=b,test=tenant-metrics-test")
+}
+
+func TestPromWriteTenantMetricsDisabled(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	scope := tally.NewTestScope("", map[string]string{"test": "tenant-metrics-test"})
+	handler, err := NewPromWriteHandler(makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope)))
+	require.NoError(t, err)
+
+	body := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+	req.Header.Set(testTenantMetricsHeader, "a")
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	counters := scope.Snapshot().Counters()
+	success, ok := counters["write.success+handler=remote-write,path=rules,test=tenant-metrics-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), success.Value())
+	require.NotContains(t, counters,
+		"write.success+handler=remote-write,path=rules,tenant=a,test=tenant-metrics-test")
+}
+
+func TestPromWriteTenantMetricsNoHeader(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.TenantMetrics = &handleroptions.PromWriteHandlerTenantMetricsOptions{}
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.Error(t, err)
+}
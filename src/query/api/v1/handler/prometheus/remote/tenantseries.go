@@ -0,0 +1,234 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/clock"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/uber-go/tally"
+)
+
+const (
+	defaultTenantSeriesLimitTTL        = time.Hour
+	defaultTenantSeriesLimitMaxTenants = 1000
+)
+
+var (
+	errNoTenantSeriesLimitHeader = errors.New("tenant series limit header must be set")
+	errNoTenantSeriesLimit       = errors.New("tenant series limit must be positive")
+)
+
+// tenantSeriesLimiter caps the active series of each tenant, tracking the
+// series approximately by the hash of their labels along with when they
+// were last written.
+type tenantSeriesLimiter struct {
+	sync.Mutex
+
+	header     string
+	limit      int
+	limits     map[string]int
+	ttl        time.Duration
+	maxTenants int
+	nowFn      clock.NowFn
+	tenants    map[string]*tenantSeries
+
+	exceeded        tally.Counter
+	tenantsExceeded tally.Counter
+}
+
+// tenantSeries are the active series of a tenant.
+type tenantSeries struct {
+	lastWritten map[uint64]time.Time
+	// nextExpiry is no later than the earliest expiry of the series, so that
+	// expired series are only looked for once some may have expired.
+	nextExpiry time.Time
+}
+
+func newTenantSeriesLimiter(
+	opts handleroptions.PromWriteHandlerTenantSeriesLimitOptions,
+	nowFn clock.NowFn,
+	scope tally.Scope,
+) (*tenantSeriesLimiter, error) {
+	if opts.Header == "" {
+		return nil, errNoTenantSeriesLimitHeader
+	}
+	if opts.Limit <= 0 {
+		return nil, errNoTenantSeriesLimit
+	}
+	for tenant, limit := range opts.Limits {
+		if limit <= 0 {
+			return nil, fmt.Errorf("tenant series limit must be positive: "+
+				"tenant=%s, limit=%d", tenant, limit)
+		}
+	}
+
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = defaultTenantSeriesLimitTTL
+	}
+	maxTenants := opts.MaxTenants
+	if maxTenants <= 0 {
+		maxTenants = defaultTenantSeriesLimitMaxTenants
+	}
+
+	scope = scope.SubScope("write")
+	return &tenantSeriesLimiter{
+		header:          opts.Header,
+		limit:           opts.Limit,
+		limits:          opts.Limits,
+		ttl:             ttl,
+		maxTenants:      maxTenants,
+		nowFn:           nowFn,
+		tenants:         make(map[string]*tenantSeries),
+		exceeded:        scope.Counter("tenant-series-limit-exceeded"),
+		tenantsExceeded: scope.Counter("tenant-series-limit-max-tenants-exceeded"),
+	}, nil
+}
+
+// admit marks the series as written by the tenant of the request and
+// returns the series admitted. The active series of the tenant are always
+// admitted while new series are admitted up to the limit of the tenant,
+// with a 429 error returned alongside the admitted series if any new series
+// were not. Requests without a tenant are rejected since they cannot be
+// limited.
+func (l *tenantSeriesLimiter) admit(
+	header http.Header,
+	series []prompb.TimeSeries,
+) ([]prompb.TimeSeries, error) {
+	tenant := header.Get(l.header)
+	if tenant == "" {
+		return nil, withErrorCode(xhttp.NewError(fmt.Errorf("tenant series "+
+			"limit header missing: header=%s", l.header), http.StatusBadRequest),
+			PromWriteErrorCodeInvalidHeader)
+	}
+
+	// NB: Hash a copy of the labels since building the pseudo ID may sort
+	// them, the written labels keep their order.
+	var (
+		hashes = make([]uint64, 0, len(series))
+		sorted []prompb.Label
+		id     []byte
+	)
+	for i := range series {
+		sorted = append(sorted[:0], series[i].Labels...)
+		id = buildPseudoIDWithLabelsLikelySorted(sorted, id[:0])
+		hashes = append(hashes, xxhash.Sum64(id))
+	}
+
+	now := l.nowFn()
+
+	l.Lock()
+	defer l.Unlock()
+
+	t, ok := l.tenants[tenant]
+	if !ok {
+		if len(l.tenants) >= l.maxTenants {
+			l.expireTenants(now)
+		}
+		if len(l.tenants) >= l.maxTenants {
+			l.tenantsExceeded.Inc(1)
+			return nil, withErrorCode(xhttp.NewError(fmt.Errorf("tenant series "+
+				"limit max tenants exceeded: tenant=%s, max=%d", tenant, l.maxTenants),
+				http.StatusTooManyRequests), PromWriteErrorCodeTooManySeries)
+		}
+		t = &tenantSeries{
+			lastWritten: make(map[uint64]time.Time),
+			nextExpiry:  now.Add(l.ttl),
+		}
+		l.tenants[tenant] = t
+	}
+
+	t.expire(now, l.ttl)
+
+	limit := l.limit
+	if v, ok := l.limits[tenant]; ok {
+		limit = v
+	}
+
+	var (
+		active   = len(t.lastWritten)
+		admitted []prompb.TimeSeries
+		rejected int
+	)
+	for i, hash := range hashes {
+		if _, ok := t.lastWritten[hash]; !ok && len(t.lastWritten) >= limit {
+			if admitted == nil {
+				admitted = append(make([]prompb.TimeSeries, 0, len(series)), series[:i]...)
+			}
+			rejected++
+			continue
+		}
+		t.lastWritten[hash] = now
+		if admitted != nil {
+			admitted = append(admitted, series[i])
+		}
+	}
+	if rejected == 0 {
+		return series, nil
+	}
+
+	l.exceeded.Inc(1)
+	return admitted, withErrorCode(xhttp.NewError(fmt.Errorf("tenant series "+
+		"limit exceeded: tenant=%s, active=%d, rejected=%d, limit=%d",
+		tenant, active, rejected, limit), http.StatusTooManyRequests),
+		PromWriteErrorCodeTooManySeries)
+}
+
+// expireTenants removes the tenants without any active series.
+func (l *tenantSeriesLimiter) expireTenants(now time.Time) {
+	for tenant, t := range l.tenants {
+		t.expire(now, l.ttl)
+		if len(t.lastWritten) == 0 {
+			delete(l.tenants, tenant)
+		}
+	}
+}
+
+// expire removes the series not written for the TTL, if any may have
+// expired.
+func (t *tenantSeries) expire(now time.Time, ttl time.Duration) {
+	if now.Before(t.nextExpiry) {
+		return
+	}
+
+	nextExpiry := now.Add(ttl)
+	for hash, lastWritten := range t.lastWritten {
+		expiry := lastWritten.Add(ttl)
+		if !now.Before(expiry) {
+			delete(t.lastWritten, hash)
+			continue
+		}
+		if expiry.Before(nextExpiry) {
+			nextExpiry = expiry
+		}
+	}
+	t.nextExpiry = nextExpiry
+}
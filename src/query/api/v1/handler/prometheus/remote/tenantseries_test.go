@@ -0,0 +1,273 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const tenantSeriesTestHeader = "X-Tenant"
+
+func newTenantSeriesTestSeries(names ...string) []prompb.TimeSeries {
+	series := make([]prompb.TimeSeries, 0, len(names))
+	for _, name := range names {
+		series = append(series, prompb.TimeSeries{
+			Labels:  testLabels("__name__", name, "foo", "bar"),
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		})
+	}
+	return series
+}
+
+func newTenantSeriesTestHeader(tenant string) http.Header {
+	header := make(http.Header)
+	if tenant != "" {
+		header.Set(tenantSeriesTestHeader, tenant)
+	}
+	return header
+}
+
+func tenantSeriesTestNames(series []prompb.TimeSeries) []string {
+	names := make([]string, 0, len(series))
+	for _, ts := range series {
+		names = append(names, string(ts.Labels[0].Value))
+	}
+	return names
+}
+
+func TestTenantSeriesLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter, err := newTenantSeriesLimiter(handleroptions.PromWriteHandlerTenantSeriesLimitOptions{
+		Header: tenantSeriesTestHeader,
+		Limit:  3,
+		Limits: map[string]int{"large": 5},
+		TTL:    time.Minute,
+	}, func() time.Time { return now }, tally.NoopScope)
+	require.NoError(t, err)
+
+	admit := func(tenant string, names ...string) ([]string, error) {
+		admitted, err := limiter.admit(newTenantSeriesTestHeader(tenant),
+			newTenantSeriesTestSeries(names...))
+		return tenantSeriesTestNames(admitted), err
+	}
+	requireAdmitted := func(tenant string, names ...string) {
+		admitted, err := admit(tenant, names...)
+		require.NoError(t, err)
+		require.Equal(t, names, admitted)
+	}
+
+	requireAdmitted("a", "a", "b")
+	requireAdmitted("a", "b", "c")
+
+	// New series over the limit are not admitted while active series are.
+	admitted, err := admit("a", "d", "a", "e", "b")
+	require.Error(t, err)
+	httpErr, ok := err.(xhttp.Error) //nolint:errorlint
+	require.True(t, ok)
+	require.Equal(t, http.StatusTooManyRequests, httpErr.Code())
+	require.Contains(t, err.Error(), "tenant=a, active=3, rejected=2, limit=3")
+	require.Equal(t, []string{"a", "b"}, admitted)
+
+	// Active series are still admitted.
+	requireAdmitted("a", "a", "b", "c")
+
+	// Other tenants are not affected.
+	requireAdmitted("b", "d", "e", "f")
+	requireAdmitted("large", "a", "b", "c", "d", "e")
+	admitted, err = admit("large", "f")
+	require.Error(t, err)
+	require.Empty(t, admitted)
+
+	// Series expire once not written for the TTL.
+	now = now.Add(30 * time.Second)
+	requireAdmitted("a", "a")
+	now = now.Add(45 * time.Second)
+	requireAdmitted("a", "d", "e")
+	admitted, err = admit("a", "f", "a")
+	require.Error(t, err)
+	require.Equal(t, []string{"a"}, admitted)
+}
+
+func TestTenantSeriesLimiterMissingTenant(t *testing.T) {
+	limiter, err := newTenantSeriesLimiter(handleroptions.PromWriteHandlerTenantSeriesLimitOptions{
+		Header: tenantSeriesTestHeader,
+		Limit:  3,
+	}, time.Now, tally.NoopScope)
+	require.NoError(t, err)
+
+	admitted, err := limiter.admit(newTenantSeriesTestHeader(""),
+		newTenantSeriesTestSeries("a"))
+	require.Error(t, err)
+	require.Empty(t, admitted)
+	httpErr, ok := err.(xhttp.Error) //nolint:errorlint
+	require.True(t, ok)
+	require.Equal(t, http.StatusBadRequest, httpErr.Code())
+	code, ok := errorCode(err)
+	require.True(t, ok)
+	require.Equal(t, PromWriteErrorCodeInvalidHeader, code)
+}
+
+func TestTenantSeriesLimiterMaxTenants(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter, err := newTenantSeriesLimiter(handleroptions.PromWriteHandlerTenantSeriesLimitOptions{
+		Header:     tenantSeriesTestHeader,
+		Limit:      10,
+		TTL:        time.Minute,
+		MaxTenants: 2,
+	}, func() time.Time { return now }, tally.NoopScope)
+	require.NoError(t, err)
+
+	admit := func(tenant string) error {
+		_, err := limiter.admit(newTenantSeriesTestHeader(tenant),
+			newTenantSeriesTestSeries("a"))
+		return err
+	}
+	require.NoError(t, admit("a"))
+	require.NoError(t, admit("b"))
+	require.Error(t, admit("c"))
+
+	// Tenants without active series are no longer tracked.
+	now = now.Add(time.Minute)
+	require.NoError(t, admit("c"))
+}
+
+func TestNewTenantSeriesLimiterInvalid(t *testing.T) {
+	for _, opts := range []handleroptions.PromWriteHandlerTenantSeriesLimitOptions{
+		{Limit: 1},
+		{Header: tenantSeriesTestHeader},
+		{Header: tenantSeriesTestHeader, Limit: 1, Limits: map[string]int{"a": 0}},
+	} {
+		_, err := newTenantSeriesLimiter(opts, time.Now, tally.NoopScope)
+		require.Error(t, err, fmt.Sprintf("%+v", opts))
+	}
+}
+
+func TestPromWriteTenantSeriesLimit(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var written [][]string
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			var names []string
+			for iter.Next() {
+				name, _ := iter.Current().Tags.Name()
+				names = append(names, string(name))
+			}
+			written = append(written, names)
+			return nil
+		}).
+		Times(3)
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.TenantSeriesLimit = &handleroptions.PromWriteHandlerTenantSeriesLimitOptions{
+			Header: tenantSeriesTestHeader,
+			Limit:  2,
+		}
+	})
+
+	write := func(tenant string, names ...string) int {
+		req := &prompb.WriteRequest{Timeseries: newTenantSeriesTestSeries(names...)}
+		body := test.GeneratePromWriteRequestBody(t, req)
+		httpReq := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+		if tenant != "" {
+			httpReq.Header.Set(tenantSeriesTestHeader, tenant)
+		}
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, httpReq)
+		return writer.Result().StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, write("tenant", "a", "b"))
+	// The active series are written while the new series are rejected.
+	require.Equal(t, http.StatusTooManyRequests, write("tenant", "b", "c"))
+	require.Equal(t, http.StatusOK, write("tenant", "a", "b"))
+	// Only new series over the limit are rejected without writing.
+	require.Equal(t, http.StatusTooManyRequests, write("tenant", "c"))
+	// Requests without a tenant cannot be limited so they are rejected.
+	require.Equal(t, http.StatusBadRequest, write("", "a"))
+
+	require.Equal(t, [][]string{{"a", "b"}, {"b"}, {"a", "b"}}, written)
+}
+
+func TestPromWriteTenantSeriesLimitJWT(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(1)
+
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetNowFn(func() time.Time { return testJWTNow })
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.JWT = &handleroptions.PromWriteHandlerJWTOptions{
+			HMACSecret: testJWTSecret,
+			Issuer:     testJWTIssuer,
+		}
+		cfg.PromRemoteWrite.TenantSeriesLimit = &handleroptions.PromWriteHandlerTenantSeriesLimitOptions{
+			Header: tenantSeriesTestHeader,
+			Limit:  1,
+		}
+	})
+
+	write := func(claims jwt.MapClaims, tenant string, names ...string) int {
+		req := &prompb.WriteRequest{Timeseries: newTenantSeriesTestSeries(names...)}
+		body := test.GeneratePromWriteRequestBody(t, req)
+		httpReq := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, body)
+		httpReq.Header.Set("Authorization", "Bearer "+newTestJWT(t, newTestJWTClaims(claims)))
+		httpReq.Header.Set(tenantSeriesTestHeader, tenant)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, httpReq)
+		return writer.Result().StatusCode
+	}
+
+	// The tenant is taken from the claims rather than the spoofed header.
+	tenantClaims := jwt.MapClaims{"tenant": "a"}
+	require.Equal(t, http.StatusOK, write(tenantClaims, "spoofed", "a"))
+	require.Equal(t, http.StatusTooManyRequests, write(tenantClaims, "other", "b"))
+	// Tokens without a tenant claim cannot be limited so they are rejected.
+	require.Equal(t, http.StatusBadRequest, write(nil, "spoofed", "b"))
+}
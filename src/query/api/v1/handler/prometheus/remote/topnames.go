@@ -0,0 +1,233 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/query/util/logging"
+	xerrors "github.com/m3db/m3/src/x/errors"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+)
+
+const (
+	// PromWriteTopMetricNamesURL is the url for the prom write top metric
+	// names handler.
+	PromWriteTopMetricNamesURL = PromWriteURL + "/top-metric-names"
+
+	// PromWriteTopMetricNamesHTTPMethod is the HTTP method used with this
+	// resource.
+	PromWriteTopMetricNamesHTTPMethod = http.MethodGet
+
+	defaultTopMetricNamesCapacity = 1000
+	defaultTopMetricNamesLimit    = 10
+	topMetricNamesLimitParam      = "limit"
+)
+
+var errTopMetricNamesNotEnabled = xhttp.NewError(
+	errors.New("top metric names tracking not enabled"), http.StatusNotFound)
+
+// PromWriteTopMetricNamesResponse is the response listing the metric names
+// written with the most samples, heaviest first.
+type PromWriteTopMetricNamesResponse struct {
+	MetricNames []PromWriteTopMetricName `json:"metricNames"`
+}
+
+// PromWriteTopMetricName is the approximate number of samples written for a
+// metric name, which overestimates the actual number by at most the max
+// overestimate.
+type PromWriteTopMetricName struct {
+	Name            string `json:"name"`
+	Samples         int64  `json:"samples"`
+	MaxOverestimate int64  `json:"maxOverestimate"`
+}
+
+// topMetricNames tracks the metric names written with the most samples with
+// bounded memory using the Space-Saving algorithm: once the capacity is
+// reached an untracked name replaces the tracked name with the fewest
+// samples and inherits its count as the bound of its overestimate. Any name
+// written with more than 1/capacity of all samples is guaranteed to be
+// tracked.
+type topMetricNames struct {
+	sync.Mutex
+
+	capacity int
+	names    map[string]*topMetricNameEntry
+	heap     topMetricNameHeap
+}
+
+type topMetricNameEntry struct {
+	name         string
+	samples      int64
+	overestimate int64
+	// index is the index of the entry in the heap.
+	index int
+}
+
+func newTopMetricNames(
+	opts handleroptions.PromWriteHandlerTopMetricNamesOptions,
+) (*topMetricNames, error) {
+	if opts.Capacity < 0 {
+		return nil, fmt.Errorf("top metric names capacity must not be negative: %d",
+			opts.Capacity)
+	}
+
+	capacity := defaultTopMetricNamesCapacity
+	if opts.Capacity > 0 {
+		capacity = opts.Capacity
+	}
+
+	return &topMetricNames{
+		capacity: capacity,
+		names:    make(map[string]*topMetricNameEntry, capacity),
+		heap:     make(topMetricNameHeap, 0, capacity),
+	}, nil
+}
+
+// observe adds the samples of each series to the count of its metric name.
+func (t *topMetricNames) observe(series []prompb.TimeSeries) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, s := range series {
+		if len(s.Samples) == 0 {
+			continue
+		}
+		for _, l := range s.Labels {
+			if bytes.Equal(l.Name, promMetricNameLabel) {
+				t.add(l.Value, int64(len(s.Samples)))
+				break
+			}
+		}
+	}
+}
+
+func (t *topMetricNames) add(name []byte, samples int64) {
+	if entry, ok := t.names[string(name)]; ok {
+		entry.samples += samples
+		heap.Fix(&t.heap, entry.index)
+		return
+	}
+
+	if len(t.heap) < t.capacity {
+		entry := &topMetricNameEntry{name: string(name), samples: samples}
+		t.names[entry.name] = entry
+		heap.Push(&t.heap, entry)
+		return
+	}
+
+	// Replace the name with the fewest samples.
+	entry := t.heap[0]
+	delete(t.names, entry.name)
+	entry.name = string(name)
+	entry.overestimate = entry.samples
+	entry.samples += samples
+	t.names[entry.name] = entry
+	heap.Fix(&t.heap, 0)
+}
+
+// top returns up to limit metric names with the most samples, heaviest
+// first.
+func (t *topMetricNames) top(limit int) []PromWriteTopMetricName {
+	t.Lock()
+	result := make([]PromWriteTopMetricName, 0, len(t.heap))
+	for _, entry := range t.heap {
+		result = append(result, PromWriteTopMetricName{
+			Name:            entry.name,
+			Samples:         entry.samples,
+			MaxOverestimate: entry.overestimate,
+		})
+	}
+	t.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Samples != result[j].Samples {
+			return result[i].Samples > result[j].Samples
+		}
+		return result[i].Name < result[j].Name
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// topMetricNameHeap is a min heap of entries by samples.
+type topMetricNameHeap []*topMetricNameEntry
+
+func (h topMetricNameHeap) Len() int           { return len(h) }
+func (h topMetricNameHeap) Less(i, j int) bool { return h[i].samples < h[j].samples }
+
+func (h topMetricNameHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topMetricNameHeap) Push(x interface{}) {
+	entry := x.(*topMetricNameEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *topMetricNameHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// TopMetricNamesHandler returns the admin handler listing the metric names
+// written with the most samples, the number listed is set by the limit
+// query parameter.
+func (h *PromWriteHandler) TopMetricNamesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.topMetricNames == nil {
+			xhttp.WriteError(w, errTopMetricNamesNotEnabled)
+			return
+		}
+
+		limit := defaultTopMetricNamesLimit
+		if v := r.URL.Query().Get(topMetricNamesLimitParam); v != "" {
+			var err error
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit <= 0 {
+				xhttp.WriteError(w, xerrors.NewInvalidParamsError(
+					fmt.Errorf("invalid %s param: %s", topMetricNamesLimitParam, v)))
+				return
+			}
+		}
+
+		logger := logging.WithContext(r.Context(), h.instrumentOpts)
+		xhttp.WriteJSONResponse(w, PromWriteTopMetricNamesResponse{
+			MetricNames: h.topMetricNames.top(limit),
+		}, logger)
+	})
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopMetricNamesSkewedDistribution(t *testing.T) {
+	tracker, err := newTopMetricNames(
+		handleroptions.PromWriteHandlerTopMetricNamesOptions{Capacity: 20})
+	require.NoError(t, err)
+
+	// Metric names follow a Zipf distribution over far more names than are
+	// tracked, with lower numbered names being heavier.
+	var (
+		rng    = rand.New(rand.NewSource(42)) //nolint:gosec
+		zipf   = rand.NewZipf(rng, 1.5, 1, 999)
+		actual = make(map[string]int64)
+	)
+	for i := 0; i < 1000; i++ {
+		series := make([]prompb.TimeSeries, 0, 100)
+		for j := 0; j < 100; j++ {
+			name := fmt.Sprintf("metric_%d", zipf.Uint64())
+			numSamples := 1 + rng.Intn(3)
+			actual[name] += int64(numSamples)
+			series = append(series, prompb.TimeSeries{
+				Labels:  testLabels("__name__", name, "instance", "a"),
+				Samples: make([]prompb.Sample, numSamples),
+			})
+		}
+		tracker.observe(series)
+	}
+
+	top := tracker.top(5)
+	require.Len(t, top, 5)
+	for i, name := range top {
+		require.Equal(t, fmt.Sprintf("metric_%d", i), name.Name)
+		// The count only ever overestimates, by at most the max overestimate.
+		require.True(t, name.Samples >= actual[name.Name])
+		require.True(t, name.Samples-name.MaxOverestimate <= actual[name.Name])
+	}
+
+	require.Len(t, tracker.top(100), 20)
+}
+
+func TestTopMetricNamesIgnoresSeriesWithoutName(t *testing.T) {
+	tracker, err := newTopMetricNames(handleroptions.PromWriteHandlerTopMetricNamesOptions{})
+	require.NoError(t, err)
+
+	tracker.observe([]prompb.TimeSeries{
+		{Labels: testLabels("job", "a"), Samples: make([]prompb.Sample, 3)},
+		{Labels: testLabels("__name__", "empty")},
+		{Labels: testLabels("__name__", "up"), Samples: make([]prompb.Sample, 2)},
+	})
+	require.Equal(t, []PromWriteTopMetricName{{Name: "up", Samples: 2}}, tracker.top(10))
+}
+
+func TestPromWriteTopMetricNamesHandler(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.TopMetricNames = &handleroptions.PromWriteHandlerTopMetricNamesOptions{}
+	})
+
+	promReq := test.GeneratePromWriteRequest()
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+		test.GeneratePromWriteRequestBody(t, promReq))
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	topHandler := handler.TopMetricNamesHandler()
+	req = httptest.NewRequest(PromWriteTopMetricNamesHTTPMethod,
+		PromWriteTopMetricNamesURL+"?limit=1", nil)
+	writer = httptest.NewRecorder()
+	topHandler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	var resp PromWriteTopMetricNamesResponse
+	require.NoError(t, json.NewDecoder(writer.Result().Body).Decode(&resp))
+	require.Len(t, resp.MetricNames, 1)
+
+	req = httptest.NewRequest(PromWriteTopMetricNamesHTTPMethod,
+		PromWriteTopMetricNamesURL+"?limit=-1", nil)
+	writer = httptest.NewRecorder()
+	topHandler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+}
+
+func TestPromWriteTopMetricNamesHandlerNotEnabled(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	handler, err := NewPromWriteHandler(makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(PromWriteTopMetricNamesHTTPMethod, PromWriteTopMetricNamesURL, nil)
+	writer := httptest.NewRecorder()
+	handler.(*PromWriteHandler).TopMetricNamesHandler().ServeHTTP(writer, req)
+	require.Equal(t, http.StatusNotFound, writer.Result().StatusCode)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+)
+
+var defaultTypeInferenceRules = []handleroptions.PromWriteHandlerTypeInferenceRule{
+	{Suffix: "_total", Type: "counter"},
+	{Suffix: "_bucket", Type: "histogram"},
+	{Suffix: "_count", Type: "counter"},
+}
+
+// promTypeInference infers the type of series without one from the suffix
+// of their metric name.
+type promTypeInference struct {
+	rules       []promTypeInferenceRule
+	defaultType prompb.MetricType
+}
+
+type promTypeInferenceRule struct {
+	suffix []byte
+	tp     prompb.MetricType
+}
+
+func newPromTypeInference(
+	opts handleroptions.PromWriteHandlerTypeInferenceOptions,
+) (*promTypeInference, error) {
+	rules := opts.Rules
+	if len(rules) == 0 {
+		rules = defaultTypeInferenceRules
+	}
+
+	inference := &promTypeInference{
+		rules: make([]promTypeInferenceRule, 0, len(rules)),
+	}
+	for _, rule := range rules {
+		if rule.Suffix == "" {
+			return nil, errors.New("type inference rule has no suffix")
+		}
+		tp, err := parseInferredPromType(rule.Type)
+		if err != nil {
+			return nil, err
+		}
+		inference.rules = append(inference.rules, promTypeInferenceRule{
+			suffix: []byte(rule.Suffix),
+			tp:     tp,
+		})
+	}
+
+	if opts.Default != "" {
+		tp, err := parseInferredPromType(opts.Default)
+		if err != nil {
+			return nil, err
+		}
+		inference.defaultType = tp
+	}
+	return inference, nil
+}
+
+func parseInferredPromType(v string) (prompb.MetricType, error) {
+	tp, ok := headerToMetricType[strings.ToLower(v)]
+	if !ok {
+		return prompb.MetricType_UNKNOWN, fmt.Errorf("unknown type inference type: %s", v)
+	}
+	return tp, nil
+}
+
+// infer returns the type of the series, inferred from its metric name if it
+// has none. A nil inference never infers a type.
+func (i *promTypeInference) infer(series prompb.TimeSeries) prompb.MetricType {
+	if i == nil || series.Type != prompb.MetricType_UNKNOWN {
+		return series.Type
+	}
+
+	for _, l := range series.Labels {
+		if !bytes.Equal(l.Name, promMetricNameLabel) {
+			continue
+		}
+		for _, rule := range i.rules {
+			if bytes.HasSuffix(l.Value, rule.suffix) {
+				return rule.tp
+			}
+		}
+		break
+	}
+	return i.defaultType
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/query/models"
+	"github.com/m3db/m3/src/query/ts"
+	"github.com/m3db/m3/src/x/headers"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromTypeInferenceIter(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        handleroptions.PromWriteHandlerTypeInferenceOptions
+		metricName  string
+		seriesType  prompb.MetricType
+		expected    ts.PromMetricType
+		valueResets bool
+	}{
+		{
+			name:        "total",
+			metricName:  "http_requests_total",
+			expected:    ts.PromMetricTypeCounter,
+			valueResets: true,
+		},
+		{
+			name:        "bucket",
+			metricName:  "http_request_duration_seconds_bucket",
+			expected:    ts.PromMetricTypeHistogram,
+			valueResets: true,
+		},
+		{
+			name:        "count",
+			metricName:  "http_request_duration_seconds_count",
+			expected:    ts.PromMetricTypeCounter,
+			valueResets: true,
+		},
+		{
+			name:       "plain gauge name",
+			metricName: "memory_usage_bytes",
+			expected:   ts.PromMetricTypeUnknown,
+		},
+		{
+			name:       "plain gauge name with default",
+			opts:       handleroptions.PromWriteHandlerTypeInferenceOptions{Default: "gauge"},
+			metricName: "memory_usage_bytes",
+			expected:   ts.PromMetricTypeGauge,
+		},
+		{
+			name:       "explicit type kept",
+			metricName: "queue_depth_total",
+			seriesType: prompb.MetricType_GAUGE,
+			expected:   ts.PromMetricTypeGauge,
+		},
+		{
+			name: "custom rules",
+			opts: handleroptions.PromWriteHandlerTypeInferenceOptions{
+				Rules: []handleroptions.PromWriteHandlerTypeInferenceRule{
+					{Suffix: "_info", Type: "info"},
+				},
+			},
+			metricName: "build_info",
+			expected:   ts.PromMetricTypeInfo,
+		},
+		{
+			name: "custom rules replace defaults",
+			opts: handleroptions.PromWriteHandlerTypeInferenceOptions{
+				Rules: []handleroptions.PromWriteHandlerTypeInferenceRule{
+					{Suffix: "_info", Type: "info"},
+				},
+			},
+			metricName: "http_requests_total",
+			expected:   ts.PromMetricTypeUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inference, err := newPromTypeInference(tt.opts)
+			require.NoError(t, err)
+
+			series := prompb.TimeSeries{
+				Labels:  testLabels("__name__", tt.metricName),
+				Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+				Type:    tt.seriesType,
+			}
+			// NB: Both the single and multi series iterators infer types.
+			for _, timeseries := range [][]prompb.TimeSeries{
+				{series},
+				{series, series},
+			} {
+				iter, err := newPromTSIter(timeseries, nil, models.NewTagOptions(), false,
+					false, false, nil, inference)
+				require.NoError(t, err)
+				for iter.Next() {
+					attributes := iter.Current().Attributes
+					require.Equal(t, tt.expected, attributes.PromType)
+					require.Equal(t, tt.valueResets, attributes.HandleValueResets)
+				}
+			}
+		})
+	}
+}
+
+func TestPromTypeInferenceNil(t *testing.T) {
+	var inference *promTypeInference
+	require.Equal(t, prompb.MetricType_UNKNOWN, inference.infer(prompb.TimeSeries{
+		Labels: testLabels("__name__", "http_requests_total"),
+	}))
+}
+
+func TestNewPromTypeInferenceInvalid(t *testing.T) {
+	_, err := newPromTypeInference(handleroptions.PromWriteHandlerTypeInferenceOptions{
+		Rules: []handleroptions.PromWriteHandlerTypeInferenceRule{{Suffix: "_total", Type: "tally"}},
+	})
+	require.EqualError(t, err, "unknown type inference type: tally")
+
+	_, err = newPromTypeInference(handleroptions.PromWriteHandlerTypeInferenceOptions{
+		Rules: []handleroptions.PromWriteHandlerTypeInferenceRule{{Type: "counter"}},
+	})
+	require.EqualError(t, err, "type inference rule has no suffix")
+
+	_, err = newPromTypeInference(handleroptions.PromWriteHandlerTypeInferenceOptions{
+		Default: "tally",
+	})
+	require.EqualError(t, err, "unknown type inference type: tally")
+}
+
+func TestPromWriteTypeInference(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var written []ts.PromMetricType
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			for iter.Next() {
+				written = append(written, iter.Current().Attributes.PromType)
+			}
+			return nil
+		}).
+		Times(2)
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.TypeInference = &handleroptions.PromWriteHandlerTypeInferenceOptions{}
+	})
+
+	write := func(promType string) {
+		now := time.Now().UnixMilli()
+		promReq := &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels:  testLabels("__name__", "http_requests_total"),
+					Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+				},
+				{
+					Labels:  testLabels("__name__", "memory_usage_bytes"),
+					Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+				},
+			},
+		}
+		promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		if promType != "" {
+			req.Header.Set(headers.PromTypeHeader, promType)
+		}
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	}
+
+	write("")
+	require.Equal(t, []ts.PromMetricType{ts.PromMetricTypeCounter, ts.PromMetricTypeUnknown}, written)
+
+	// The type header is explicit so nothing is inferred.
+	written = nil
+	write("gauge")
+	require.Equal(t, []ts.PromMetricType{ts.PromMetricTypeGauge, ts.PromMetricTypeGauge}, written)
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/query/storage"
+
+	"github.com/uber-go/tally"
+)
+
+// The reasons a series is unsupported.
+const (
+	unsupportedSeriesReasonSource           = "source"
+	unsupportedSeriesReasonMetricType       = "metric_type"
+	unsupportedSeriesReasonM3Type           = "m3_type"
+	unsupportedSeriesReasonPackedHistograms = "packed_histograms"
+)
+
+var unsupportedSeriesReasons = []string{
+	unsupportedSeriesReasonSource,
+	unsupportedSeriesReasonMetricType,
+	unsupportedSeriesReasonM3Type,
+	unsupportedSeriesReasonPackedHistograms,
+}
+
+// PromWriteUnsupportedSeriesResponse is the response returned when the
+// supported series of a request were written while its unsupported series
+// were not.
+type PromWriteUnsupportedSeriesResponse struct {
+	Status             string         `json:"status"`
+	NumSeries          int            `json:"numSeries"`
+	NumUnsupported     int            `json:"numUnsupported"`
+	Unsupported        map[string]int `json:"unsupported"`
+	LastUnsupportedErr string         `json:"lastUnsupportedErr"`
+}
+
+// promWriteUnsupportedSeries is the number of series of a request that were
+// not written for being unsupported, by reason.
+type promWriteUnsupportedSeries struct {
+	byReason map[string]int
+	total    int
+	lastErr  error
+}
+
+func newSeriesUnsupportedCounters(scope tally.Scope) map[string]tally.Counter {
+	counters := make(map[string]tally.Counter, len(unsupportedSeriesReasons))
+	for _, reason := range unsupportedSeriesReasons {
+		counters[reason] = scope.SubScope("write").
+			Tagged(map[string]string{"reason": reason}).
+			Counter("series-unsupported")
+	}
+	return counters
+}
+
+// response returns the response of the request with the number of series
+// written.
+func (u promWriteUnsupportedSeries) response(numSeries int) PromWriteUnsupportedSeriesResponse {
+	return PromWriteUnsupportedSeriesResponse{
+		Status:             partialSuccessStatus,
+		NumSeries:          numSeries,
+		NumUnsupported:     u.total,
+		Unsupported:        u.byReason,
+		LastUnsupportedErr: u.lastErr.Error(),
+	}
+}
+
+// checkUnsupportedSeries applies the unsupported series mode to series that
+// cannot be written as is, such as series with unknown types or carrying
+// packed histograms that were not expanded.
+func (h *PromWriteHandler) checkUnsupportedSeries(
+	req *prompb.WriteRequest,
+) (promWriteUnsupportedSeries, error) {
+	mode := h.handlerOpts.UnsupportedSeries
+	if mode == "" {
+		return promWriteUnsupportedSeries{}, nil
+	}
+
+	var (
+		kept        = req.Timeseries[:0]
+		unsupported promWriteUnsupportedSeries
+	)
+	for _, ts := range req.Timeseries {
+		reason, err := unsupportedSeriesReason(ts)
+		if err == nil {
+			kept = append(kept, ts)
+			continue
+		}
+
+		h.metrics.seriesUnsupported[reason].Inc(1)
+		if mode != handleroptions.PromWriteHandlerUnsupportedSeriesModePartial {
+			return promWriteUnsupportedSeries{}, err
+		}
+
+		if unsupported.byReason == nil {
+			unsupported.byReason = make(map[string]int, len(unsupportedSeriesReasons))
+		}
+		unsupported.byReason[reason]++
+		unsupported.total++
+		unsupported.lastErr = err
+	}
+
+	if unsupported.total > 0 {
+		req.Timeseries = kept
+	}
+	return unsupported, nil
+}
+
+// unsupportedSeriesReason returns the reason the series is unsupported along
+// with an error describing it, or a nil error if it is supported.
+func unsupportedSeriesReason(ts prompb.TimeSeries) (string, error) {
+	if len(ts.PackedHistograms) > 0 {
+		return unsupportedSeriesReasonPackedHistograms,
+			errors.New("unsupported series: packed histograms not expanded")
+	}
+
+	_, err := storage.PromTimeSeriesToSeriesAttributes(ts)
+	if err == nil {
+		return "", nil
+	}
+
+	reason := unsupportedSeriesReasonMetricType
+	if _, ok := prompb.Source_name[int32(ts.Source)]; !ok {
+		reason = unsupportedSeriesReasonSource
+	} else if _, ok := prompb.M3Type_name[int32(ts.M3Type)]; !ok {
+		reason = unsupportedSeriesReasonM3Type
+	}
+	return reason, fmt.Errorf("unsupported series: %w", err)
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestPromWriteUnsupportedSeries(t *testing.T) {
+	var (
+		now     = time.Now().UnixMilli()
+		samples = []prompb.Sample{{Timestamp: now, Value: 1}}
+		series  = []prompb.TimeSeries{
+			{Labels: testLabels("__name__", "gauge"), Samples: samples, Type: prompb.MetricType_GAUGE},
+			{Labels: testLabels("__name__", "unknown_type"), Samples: samples, Type: prompb.MetricType(100)},
+			{Labels: testLabels("__name__", "counter"), Samples: samples, Type: prompb.MetricType_COUNTER},
+			{Labels: testLabels("__name__", "unknown_source"), Samples: samples, Source: prompb.Source(100)},
+			{Labels: testLabels("__name__", "unknown_m3_type"), Samples: samples, M3Type: prompb.M3Type(100)},
+			{
+				Labels: testLabels("__name__", "packed"),
+				PackedHistograms: []prompb.PackedHistogram{
+					{Timestamp: now, Sum: 1, Count: 1},
+				},
+			},
+		}
+	)
+
+	tests := []struct {
+		name                string
+		mode                handleroptions.PromWriteHandlerUnsupportedSeriesMode
+		expectedCode        int
+		expectedWritten     []string
+		expectedUnsupported map[string]int
+	}{
+		{
+			name:         "reject",
+			mode:         handleroptions.PromWriteHandlerUnsupportedSeriesModeReject,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:            "partial",
+			mode:            handleroptions.PromWriteHandlerUnsupportedSeriesModePartial,
+			expectedCode:    http.StatusOK,
+			expectedWritten: []string{"gauge", "counter"},
+			expectedUnsupported: map[string]int{
+				unsupportedSeriesReasonMetricType:       1,
+				unsupportedSeriesReasonSource:           1,
+				unsupportedSeriesReasonM3Type:           1,
+				unsupportedSeriesReasonPackedHistograms: 1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			var written []string
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedCode == http.StatusOK {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+						for iter.Next() {
+							name, _ := iter.Current().Tags.Name()
+							written = append(written, string(name))
+						}
+						return nil
+					})
+			}
+
+			scope := tally.NewTestScope("", nil)
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.UnsupportedSeries = tt.mode
+			})
+
+			promReq := &prompb.WriteRequest{Timeseries: series}
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+				test.GeneratePromWriteRequestBody(t, promReq))
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+
+			if tt.expectedCode != http.StatusOK {
+				body, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Contains(t, string(body), "unsupported series")
+				return
+			}
+
+			require.Equal(t, tt.expectedWritten, written)
+
+			var result PromWriteUnsupportedSeriesResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			require.Equal(t, partialSuccessStatus, result.Status)
+			require.Equal(t, len(tt.expectedWritten), result.NumSeries)
+			require.Equal(t, 4, result.NumUnsupported)
+			require.Equal(t, tt.expectedUnsupported, result.Unsupported)
+			require.NotEmpty(t, result.LastUnsupportedErr)
+
+			counters := scope.Snapshot().Counters()
+			for reason, expected := range tt.expectedUnsupported {
+				counter, ok := counters["write.series-unsupported+handler=remote-write,reason="+reason]
+				require.True(t, ok)
+				require.Equal(t, int64(expected), counter.Value())
+			}
+		})
+	}
+}
+
+func TestPromWriteUnsupportedSeriesAllSupported(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.UnsupportedSeries = handleroptions.PromWriteHandlerUnsupportedSeriesModePartial
+	})
+
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+		test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest()))
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	resp := writer.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Empty(t, body)
+}
+
+func TestPromWriteUnsupportedSeriesInvalidMode(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.UnsupportedSeries = "invalid"
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.EqualError(t, err, "unknown unsupported series mode: invalid")
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+
+	"github.com/uber-go/tally"
+)
+
+var utf8ReplacementChar = []byte(string(utf8.RuneError))
+
+// utf8Validator validates that label names and values are valid UTF-8,
+// optionally sanitizing invalid ones.
+type utf8Validator struct {
+	sanitize  bool
+	invalid   tally.Counter
+	sanitized tally.Counter
+}
+
+func newUTF8Validator(
+	mode handleroptions.PromWriteHandlerUTF8ValidationMode,
+	scope tally.Scope,
+) (*utf8Validator, error) {
+	switch mode {
+	case handleroptions.PromWriteHandlerUTF8ValidationModeReject,
+		handleroptions.PromWriteHandlerUTF8ValidationModeSanitize:
+	default:
+		return nil, fmt.Errorf("unknown utf8 validation mode: %s", mode)
+	}
+
+	writeScope := scope.SubScope("write")
+	return &utf8Validator{
+		sanitize:  mode == handleroptions.PromWriteHandlerUTF8ValidationModeSanitize,
+		invalid:   writeScope.Counter("invalid-utf8-labels"),
+		sanitized: writeScope.Counter("sanitized-utf8-labels"),
+	}, nil
+}
+
+// validate checks the labels of each series, returning an error for a label
+// name or value that is not valid UTF-8 unless it can be sanitized without a
+// collision.
+func (v *utf8Validator) validate(series []prompb.TimeSeries) error {
+	for i := range series {
+		if err := v.validateLabels(series[i].Labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *utf8Validator) validateLabels(labels []prompb.Label) error {
+	var (
+		sanitizedNames []int
+		numSanitized   int64
+	)
+	for i := range labels {
+		l := &labels[i]
+		nameValid, valueValid := utf8.Valid(l.Name), utf8.Valid(l.Value)
+		if nameValid && valueValid {
+			continue
+		}
+
+		v.invalid.Inc(1)
+		if !v.sanitize {
+			return fmt.Errorf("invalid utf8 label: name=%q, value=%q",
+				l.Name, l.Value)
+		}
+
+		// NB: ToValidUTF8 allocates so the sanitized label does not alias
+		// the request body.
+		if !nameValid {
+			l.Name = bytes.ToValidUTF8(l.Name, utf8ReplacementChar)
+			sanitizedNames = append(sanitizedNames, i)
+		}
+		if !valueValid {
+			l.Value = bytes.ToValidUTF8(l.Value, utf8ReplacementChar)
+		}
+		numSanitized++
+	}
+
+	for _, i := range sanitizedNames {
+		for j := range labels {
+			if i != j && bytes.Equal(labels[i].Name, labels[j].Name) {
+				return fmt.Errorf("sanitized label name collides with another label: "+
+					"sanitized=%s", labels[i].Name)
+			}
+		}
+	}
+
+	v.sanitized.Inc(numSanitized)
+	return nil
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestUTF8Validator(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        handleroptions.PromWriteHandlerUTF8ValidationMode
+		labels      []prompb.Label
+		expected    []prompb.Label
+		expectedErr string
+	}{
+		{
+			name:     "valid",
+			mode:     handleroptions.PromWriteHandlerUTF8ValidationModeReject,
+			labels:   testLabels("__name__", "up", "city", "zürich", "emoji", "🔥"),
+			expected: testLabels("__name__", "up", "city", "zürich", "emoji", "🔥"),
+		},
+		{
+			name:        "invalid value reject",
+			mode:        handleroptions.PromWriteHandlerUTF8ValidationModeReject,
+			labels:      testLabels("__name__", "up", "job", "a\xffb"),
+			expectedErr: `invalid utf8 label: name="job", value="a\xffb"`,
+		},
+		{
+			name:        "invalid name reject",
+			mode:        handleroptions.PromWriteHandlerUTF8ValidationModeReject,
+			labels:      testLabels("__name__", "up", "jo\xc3", "a"),
+			expectedErr: `invalid utf8 label: name="jo\xc3", value="a"`,
+		},
+		{
+			name:     "invalid sanitize",
+			mode:     handleroptions.PromWriteHandlerUTF8ValidationModeSanitize,
+			labels:   testLabels("__name__", "up", "jo\xc3", "a", "job", "a\xff\xfeb\xe2\x82"),
+			expected: testLabels("__name__", "up", "jo�", "a", "job", "a�b�"),
+		},
+		{
+			name:        "sanitize collision",
+			mode:        handleroptions.PromWriteHandlerUTF8ValidationModeSanitize,
+			labels:      testLabels("__name__", "up", "job�", "a", "job\xff", "b"),
+			expectedErr: "sanitized label name collides with another label: sanitized=job�",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator, err := newUTF8Validator(tt.mode, tally.NoopScope)
+			require.NoError(t, err)
+
+			series := []prompb.TimeSeries{{Labels: tt.labels}}
+			err = validator.validate(series)
+			if tt.expectedErr != "" {
+				require.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, series[0].Labels)
+		})
+	}
+}
+
+func TestUTF8ValidatorInvalidMode(t *testing.T) {
+	_, err := newUTF8Validator("fix", tally.NoopScope)
+	require.EqualError(t, err, "unknown utf8 validation mode: fix")
+}
+
+func TestPromWriteUTF8Validation(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var written []prompb.Label
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			require.True(t, iter.Next())
+			for _, tag := range iter.Current().Tags.Tags {
+				written = append(written, prompb.Label{Name: tag.Name, Value: tag.Value})
+			}
+			require.False(t, iter.Next())
+			return nil
+		})
+
+	scope := tally.NewTestScope("", map[string]string{"test": "utf8-validation-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.UTF8Validation = handleroptions.PromWriteHandlerUTF8ValidationModeSanitize
+	})
+
+	writeLabels := func(labels []prompb.Label) int {
+		promReq := &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels:  labels,
+					Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+				},
+			},
+		}
+		promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		return writer.Result().StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, writeLabels(testLabels("__name__", "up", "job", "a\xffb")))
+	require.Equal(t, testLabels("__name__", "up", "job", "a�b"), written)
+
+	require.Equal(t, http.StatusBadRequest,
+		writeLabels(testLabels("__name__", "up", "job�", "a", "job\xff", "b")))
+
+	counters := scope.Snapshot().Counters()
+	invalid, ok := counters["write.invalid-utf8-labels+handler=remote-write,test=utf8-validation-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(2), invalid.Value())
+	sanitized, ok := counters["write.sanitized-utf8-labels+handler=remote-write,test=utf8-validation-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), sanitized.Value())
+}
+
+func TestPromWriteUTF8ValidationReject(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.UTF8Validation = handleroptions.PromWriteHandlerUTF8ValidationModeReject
+	})
+
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  testLabels("__name__", "up", "job", "a\xffb"),
+				Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+			},
+		},
+	}
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+}
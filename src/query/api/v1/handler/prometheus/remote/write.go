@@ -26,15 +26,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"mime"
 	"net/http"
 	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
 	"github.com/m3db/m3/src/dbnode/client"
+	"github.com/m3db/m3/src/dbnode/generated/proto/annotation"
 	"github.com/m3db/m3/src/metrics/policy"
 	"github.com/m3db/m3/src/query/api/v1/handler/prometheus"
 	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
@@ -46,9 +51,11 @@ import (
 	"github.com/m3db/m3/src/query/storage/m3/storagemetadata"
 	"github.com/m3db/m3/src/query/ts"
 	"github.com/m3db/m3/src/query/util/logging"
+	"github.com/m3db/m3/src/x/cache"
 	"github.com/m3db/m3/src/x/clock"
 	xerrors "github.com/m3db/m3/src/x/errors"
 	"github.com/m3db/m3/src/x/headers"
+	xhttpstatus "github.com/m3db/m3/src/x/http"
 	"github.com/m3db/m3/src/x/instrument"
 	xhttp "github.com/m3db/m3/src/x/net/http"
 	"github.com/m3db/m3/src/x/retry"
@@ -59,6 +66,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/snappy"
 	murmur3 "github.com/m3db/stackmurmur3/v2"
+	"github.com/prometheus/common/model"
 	"github.com/uber-go/tally"
 	"go.uber.org/zap"
 )
@@ -82,13 +90,42 @@ const (
 	// literalPrefixLength is the length of the label literal prefix that is logged upon
 	// "literal is too long" error.
 	literalPrefixLength = 100
+
+	// defaultMinTimestampMillis is the default timestamp floor, any sample
+	// with a timestamp below this is likely expressed in seconds.
+	defaultMinTimestampMillis = int64(1e12)
+	// maxTimestampBelowFloorLogCount is the number of times a sample below
+	// the timestamp floor should be logged when running in warn mode.
+	maxTimestampBelowFloorLogCount = 10
+	// maxDeprecatedHeaderLogCount is the number of times a request using a
+	// deprecated header should be logged.
+	maxDeprecatedHeaderLogCount = 10
+	// maxInvalidStoragePolicyLogCount is the number of times a request with
+	// an invalid storage policy header should be logged when falling back to
+	// the default rules.
+	maxInvalidStoragePolicyLogCount = 10
+
+	// maxRequestIDLength is the max length of a client provided request ID,
+	// longer IDs are replaced with a generated ID.
+	maxRequestIDLength = 128
+
+	// partialSuccessStatus is the status of a partially successful write.
+	partialSuccessStatus = "partial_success"
 )
 
 var (
-	errNoDownsamplerAndWriter       = errors.New("no downsampler and writer set")
-	errNoTagOptions                 = errors.New("no tag options set")
-	errNoNowFn                      = errors.New("no now fn set")
-	errUnaggregatedStoragePolicySet = errors.New("storage policy should not be set for unaggregated metrics")
+	errNoDownsamplerAndWriter          = errors.New("no downsampler and writer set")
+	errNoTagOptions                    = errors.New("no tag options set")
+	errNoNowFn                         = errors.New("no now fn set")
+	errNoSecondaryDownsamplerAndWriter = errors.New("no secondary downsampler and writer set")
+	errWriteRetryForever               = errors.New("write retry must not retry forever")
+	errNoMetadataStore                 = errors.New("no metadata store set")
+	errUnaggregatedStoragePolicySet    = errors.New("storage policy should not be set for unaggregated metrics")
+	errForwardDropped                  = errors.New("forward dropped, no forwarding worker available")
+
+	// promMetricNameLabel is the Prometheus metric name label, which is
+	// remapped to the configured metric name tag when converted to tags.
+	promMetricNameLabel = []byte(model.MetricNameLabel)
 
 	defaultForwardingRetryForever = false
 	defaultForwardingRetryJitter  = true
@@ -115,11 +152,59 @@ var (
 		"stateset":        prompb.MetricType_STATESET,
 		"summary":         prompb.MetricType_SUMMARY,
 	}
+
+	// forwardProtocolHeaders are the remote write protocol headers copied
+	// from the incoming request when forwarding, since some targets validate
+	// them strictly, along with the default used when not set by the client.
+	forwardProtocolHeaders = []struct {
+		name         string
+		defaultValue string
+	}{
+		{name: xhttp.HeaderContentType, defaultValue: xhttp.ContentTypeProtobuf},
+		{name: "Content-Encoding", defaultValue: "snappy"},
+		{name: headers.PromRemoteWriteVersionHeader, defaultValue: "0.1.0"},
+	}
+
+	// knownM3Headers is the set of M3 headers recognized by the write handler
+	// and the middleware applied to it, keyed by canonical header key. New
+	// M3 headers read by the write path must be added here so they are not
+	// rejected when strict M3 header validation is enabled.
+	knownM3Headers = newCanonicalHeaderSet(
+		headers.MetricsTypeHeader,
+		headers.MetricsStoragePolicyHeader,
+		headers.WriteTypeHeader,
+		headers.MapTagsByJSONHeader,
+		headers.SourceHeader,
+		headers.CustomResponseMetricsType,
+		headers.DebugResponseDelayHeader,
+		headers.DebugTextExpositionHeader,
+		headers.DebugDropCountsHeader,
+		headers.AsyncWriteHeader,
+		headers.ForwardTargetsHeader,
+		headers.WritePriorityHeader,
+	)
+
+	forwardTargetsHeaderKey = http.CanonicalHeaderKey(headers.ForwardTargetsHeader)
 )
 
+func newCanonicalHeaderSet(names ...string) map[string]struct{} {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[http.CanonicalHeaderKey(name)] = struct{}{}
+	}
+	return set
+}
+
 // PromWriteHandler represents a handler for prometheus write endpoint.
 type PromWriteHandler struct {
 	downsamplerAndWriter   ingest.DownsamplerAndWriter
+	secondaryWriter        ingest.DownsamplerAndWriter
+	writeRetrier           retry.Retrier
+	accessLogger           *promWriteAccessLogger
+	deprecatedHeaders      []promWriteDeprecatedHeader
+	remoteWriteVersions    map[string]struct{}
+	metadataStore          options.PromWriteMetadataStore
+	requestValidator       options.PromWriteRequestValidator
 	tagOptions             models.TagOptions
 	storeMetricsType       bool
 	forwarding             handleroptions.PromWriteHandlerForwardingOptions
@@ -128,12 +213,68 @@ type PromWriteHandler struct {
 	forwardingBoundWorkers xsync.WorkerPool
 	forwardContext         context.Context
 	forwardRetrier         retry.Retrier
+	forwardRetriers        forwardRetriers
+	forwardTransforms      map[string]options.PromWriteForwardTransform
+	forwardSchedules       []*forwardSchedule
+	forwardTokenSources    forwardTokenSources
+	forwardSigners         forwardSigners
+	forwardCompressors     forwardCompressors
+	forwardSerialQueues    forwardSerialQueues
+	forwardSelectable      forwardSelectableTargets
+	forwardShards          forwardShards
+	forwardRateLimiters    forwardRateLimiters
 	nowFn                  clock.NowFn
 	instrumentOpts         instrument.Options
 	metrics                promWriteMetrics
+	handlerOpts            handleroptions.PromWriteHandlerOptions
+	labelCardinality       *labelCardinalityGuard
+	utf8Validator          *utf8Validator
+	labelNameValidator     *labelNameValidator
+	labelTrimmer           *labelTrimmer
+	seriesMerger           *seriesMerger
+	histogramValidator     *histogramValidator
+	seriesDenylist         *seriesDenylist
+	labelCounts            *labelCountRecorder
+	typeInference          *promTypeInference
+	priorityPools          *priorityPools
+	auditLog               *auditLog
+	labelSplits            []labelSplit
+	allowedClientCNs       map[string]struct{}
+	statusCodes            promWriteStatusCodes
+	idempotencyKeys        *cache.LRU
+	monotonicTimestamps    *monotonicTimestamps
+	packedHistograms       *packedHistograms
+	metricsPusher          *metricsPusher
+	writePools             []*writePool
+	deadLetter             *deadLetterPoster
+	coalescer              *writeCoalescer
+	asyncWriter            *asyncWriter
+	heartbeatWriter        *heartbeatWriter
+	inFlightBytes          *inFlightBytes
+	tenantSeriesLimiter    *tenantSeriesLimiter
+	newMetricNames         *newMetricNameNotifier
+	topMetricNames         *topMetricNames
+	jwtVerifier            *jwtVerifier
+	samplingLabel          []byte
+
+	// paused is set to 1 when writes are paused.
+	paused int32
+	// numActiveForwards is the number of forwards currently in flight, it is
+	// guarded by a lock so the gauge is never updated out of order.
+	numActiveForwardsLock sync.Mutex
+	numActiveForwards     int
 
 	// Counting the number of times of "literal is too long" error for log sampling purposes.
 	numLiteralIsTooLong uint32
+	// Counting the number of times a sample was below the timestamp floor
+	// for log sampling purposes.
+	numTimestampBelowFloor uint32
+	// Counting the number of times a deprecated header was used for log
+	// sampling purposes.
+	numDeprecatedHeaderUsed uint32
+	// Counting the number of times a request fell back to the default rules
+	// for an invalid storage policy for log sampling purposes.
+	numInvalidStoragePolicy uint32
 }
 
 // NewPromWriteHandler returns a new instance of handler.
@@ -143,6 +284,7 @@ func NewPromWriteHandler(options options.HandlerOptions) (http.Handler, error) {
 		tagOptions           = options.TagOptions()
 		nowFn                = options.NowFn()
 		forwarding           = options.Config().WriteForwarding.PromRemoteWrite
+		handlerOpts          = options.Config().PromRemoteWrite
 		instrumentOpts       = options.InstrumentOpts()
 	)
 
@@ -158,395 +300,2405 @@ func NewPromWriteHandler(options options.HandlerOptions) (http.Handler, error) {
 		return nil, errNoNowFn
 	}
 
-	scope := options.InstrumentOpts().
-		MetricsScope().
-		Tagged(map[string]string{"handler": "remote-write"})
-	metrics, err := newPromWriteMetrics(scope)
-	if err != nil {
-		return nil, err
+	if v := handlerOpts.TimestampFloor; v != nil {
+		switch v.Mode {
+		case "", handleroptions.PromWriteHandlerTimestampFloorModeReject,
+			handleroptions.PromWriteHandlerTimestampFloorModeWarn:
+		default:
+			return nil, fmt.Errorf("unknown timestamp floor mode: %s", v.Mode)
+		}
 	}
 
-	// Only use a forwarding worker pool if concurrency is bound, otherwise
-	// if unlimited we just spin up a goroutine for each incoming write.
-	var forwardingBoundWorkers xsync.WorkerPool
-	if v := forwarding.MaxConcurrency; v > 0 {
-		forwardingBoundWorkers = xsync.NewWorkerPool(v)
-		forwardingBoundWorkers.Init()
+	if v := handlerOpts.MaxSamplesPerRequest; v != nil {
+		switch v.Mode {
+		case "", handleroptions.PromWriteHandlerMaxSamplesModeReject,
+			handleroptions.PromWriteHandlerMaxSamplesModeTruncate:
+		default:
+			return nil, fmt.Errorf("unknown max samples per request mode: %s", v.Mode)
+		}
 	}
 
-	forwardTimeout := defaultForwardingTimeout
-	if v := forwarding.Timeout; v > 0 {
-		forwardTimeout = v
+	if v := handlerOpts.MaxDecompressionRatio; v < 0 {
+		return nil, fmt.Errorf("max decompression ratio must not be negative: %f", v)
 	}
 
-	forwardHTTPOpts := xhttp.DefaultHTTPClientOptions()
-	forwardHTTPOpts.DisableCompression = true // Already snappy compressed.
-	forwardHTTPOpts.RequestTimeout = forwardTimeout
+	if v := handlerOpts.MaxInFlightBytes; v < 0 {
+		return nil, fmt.Errorf("max in-flight bytes must not be negative: %d", v)
+	}
 
-	forwardRetryConfig := defaultForwardRetryConfig
-	if forwarding.Retry != nil {
-		forwardRetryConfig = *forwarding.Retry
+	if v := handlerOpts.ResourceExhaustedPartialSuccess; v != nil {
+		if v.MaxFraction < 0 || v.MaxFraction > 1 {
+			return nil, fmt.Errorf("resource exhausted partial success max fraction "+
+				"out of range [0,1]: %f", v.MaxFraction)
+		}
 	}
-	forwardRetryOpts := forwardRetryConfig.NewOptions(
-		scope.SubScope("forwarding-retry"),
-	)
 
-	return &PromWriteHandler{
-		downsamplerAndWriter:   downsamplerAndWriter,
-		tagOptions:             tagOptions,
-		storeMetricsType:       options.StoreMetricsType(),
-		forwarding:             forwarding,
-		forwardTimeout:         forwardTimeout,
-		forwardHTTPClient:      xhttp.NewHTTPClient(forwardHTTPOpts),
-		forwardingBoundWorkers: forwardingBoundWorkers,
-		forwardContext:         context.Background(),
-		forwardRetrier:         retry.NewRetrier(forwardRetryOpts),
-		nowFn:                  nowFn,
-		metrics:                metrics,
-		instrumentOpts:         instrumentOpts,
-	}, nil
-}
+	if v := handlerOpts.LabelNormalization; v != nil {
+		switch v.Duplicates {
+		case "", handleroptions.PromWriteHandlerDuplicateLabelModeKeepFirst,
+			handleroptions.PromWriteHandlerDuplicateLabelModeKeepLast:
+		default:
+			return nil, fmt.Errorf("unknown duplicate label mode: %s", v.Duplicates)
+		}
+	}
 
-type promWriteMetrics struct {
-	writeSuccess             tally.Counter
-	writeErrorsServer        tally.Counter
-	writeErrorsClient        tally.Counter
-	writeBatchLatency        tally.Histogram
-	writeBatchLatencyBuckets tally.DurationBuckets
-	ingestLatency            tally.Histogram
-	ingestLatencyBuckets     tally.DurationBuckets
-	forwardSuccess           tally.Counter
-	forwardErrors            tally.Counter
-	forwardDropped           tally.Counter
-	forwardLatency           tally.Histogram
-	forwardShadowKeep        tally.Counter
-	forwardShadowDrop        tally.Counter
-}
+	switch handlerOpts.DuplicateLabelNames {
+	case "", handleroptions.PromWriteHandlerDuplicateLabelNamesModeReject,
+		handleroptions.PromWriteHandlerDuplicateLabelNamesModeDedupe:
+	default:
+		return nil, fmt.Errorf("unknown duplicate label names mode: %s",
+			handlerOpts.DuplicateLabelNames)
+	}
 
-func (m *promWriteMetrics) incError(err error) {
-	if xhttp.IsClientError(err) {
-		m.writeErrorsClient.Inc(1)
-	} else {
-		m.writeErrorsServer.Inc(1)
+	switch handlerOpts.InvalidStoragePolicy {
+	case "", handleroptions.PromWriteHandlerInvalidStoragePolicyModeReject,
+		handleroptions.PromWriteHandlerInvalidStoragePolicyModeFallback:
+	default:
+		return nil, fmt.Errorf("unknown invalid storage policy mode: %s",
+			handlerOpts.InvalidStoragePolicy)
 	}
-}
 
-func newPromWriteMetrics(scope tally.Scope) (promWriteMetrics, error) {
-	buckets, err := ingest.NewLatencyBuckets()
-	if err != nil {
-		return promWriteMetrics{}, err
+	switch handlerOpts.TruncatedBody {
+	case "", handleroptions.PromWriteHandlerTruncatedBodyModeReject,
+		handleroptions.PromWriteHandlerTruncatedBodyModeRetry:
+	default:
+		return nil, fmt.Errorf("unknown truncated body mode: %s",
+			handlerOpts.TruncatedBody)
 	}
-	return promWriteMetrics{
-		writeSuccess:             scope.SubScope("write").Counter("success"),
-		writeErrorsServer:        scope.SubScope("write").Tagged(map[string]string{"code": "5XX"}).Counter("errors"),
-		writeErrorsClient:        scope.SubScope("write").Tagged(map[string]string{"code": "4XX"}).Counter("errors"),
-		writeBatchLatency:        scope.SubScope("write").Histogram("batch-latency", buckets.WriteLatencyBuckets),
-		writeBatchLatencyBuckets: buckets.WriteLatencyBuckets,
-		ingestLatency:            scope.SubScope("ingest").Histogram("latency", buckets.IngestLatencyBuckets),
-		ingestLatencyBuckets:     buckets.IngestLatencyBuckets,
-		forwardSuccess:           scope.SubScope("forward").Counter("success"),
-		forwardErrors:            scope.SubScope("forward").Counter("errors"),
-		forwardDropped:           scope.SubScope("forward").Counter("dropped"),
-		forwardLatency:           scope.SubScope("forward").Histogram("latency", buckets.WriteLatencyBuckets),
-		forwardShadowKeep:        scope.SubScope("forward").SubScope("shadow").Counter("keep"),
-		forwardShadowDrop:        scope.SubScope("forward").SubScope("shadow").Counter("drop"),
-	}, nil
-}
 
-func (h *PromWriteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	batchRequestStopwatch := h.metrics.writeBatchLatency.Start()
-	defer batchRequestStopwatch.Stop()
+	switch handlerOpts.RelabeledEmptyName {
+	case "", handleroptions.PromWriteHandlerRelabeledEmptyNameModeReject,
+		handleroptions.PromWriteHandlerRelabeledEmptyNameModeDrop:
+	default:
+		return nil, fmt.Errorf("unknown relabeled empty name mode: %s",
+			handlerOpts.RelabeledEmptyName)
+	}
 
-	checkedReq, err := h.checkedParseRequest(r)
-	if err != nil {
-		h.metrics.incError(err)
-		xhttp.WriteError(w, err)
-		return
+	switch handlerOpts.MissingName {
+	case "", handleroptions.PromWriteHandlerMissingNameModeReject,
+		handleroptions.PromWriteHandlerMissingNameModeDrop:
+	default:
+		return nil, fmt.Errorf("unknown missing name mode: %s", handlerOpts.MissingName)
 	}
 
 	var (
-		req  = checkedReq.Request
-		opts = checkedReq.Options
+		scope         = options.InstrumentOpts().MetricsScope()
+		metricsPusher *metricsPusher
 	)
-	// Begin async forwarding.
-	// NB(r): Be careful about not returning buffers to pool
-	// if the request bodies ever get pooled until after
-	// forwarding completes.
-	if targets := h.forwarding.Targets; len(targets) > 0 {
-		for _, target := range targets {
-			target := target // Capture for lambda.
-			forward := func() {
-				now := h.nowFn()
-
-				var (
-					attempt = func() error {
-						// Consider propagating baggage without tying
-						// context to request context in future.
-						ctx, cancel := context.WithTimeout(h.forwardContext, h.forwardTimeout)
-						defer cancel()
-						return h.forward(ctx, checkedReq, r.Header, target)
-					}
-					err error
-				)
-				if target.NoRetry {
-					err = attempt()
-				} else {
-					err = h.forwardRetrier.Attempt(attempt)
-				}
+	if v := handlerOpts.MetricsPush; v != nil {
+		var err error
+		metricsPusher, err = newMetricsPusher(*v, instrumentOpts.Logger())
+		if err != nil {
+			return nil, err
+		}
+		// NB: Keep reporting to the handler scope so that the metrics are
+		// still scraped along with the other metrics of the process.
+		scope = newTeeScope(scope, metricsPusher.scope)
+	}
 
-				// Record forward ingestion delay.
-				// NB: this includes any time for retries.
-				for _, series := range req.Timeseries {
-					for _, sample := range series.Samples {
-						age := now.Sub(storage.PromTimestampToTime(sample.Timestamp))
-						h.metrics.forwardLatency.RecordDuration(age)
-					}
-				}
+	if v := handlerOpts.TenantMetrics; v != nil && v.Header == "" {
+		return nil, errors.New("tenant metrics header required")
+	}
 
-				if err != nil {
-					h.metrics.forwardErrors.Inc(1)
-					logger := logging.WithContext(h.forwardContext, h.instrumentOpts)
-					logger.Error("forward error", zap.Error(err))
-					return
-				}
+	scope = scope.Tagged(map[string]string{"handler": "remote-write"})
+	metrics, err := newPromWriteMetrics(scope, handlerOpts.TenantMetrics)
+	if err != nil {
+		return nil, err
+	}
 
-				h.metrics.forwardSuccess.Inc(1)
-			}
+	if v := handlerOpts.DebugResponseDelay; v != nil && v.Enabled {
+		instrumentOpts.Logger().Warn("prom remote write debug response delay "+
+			"enabled, this must not be used in production",
+			zap.Duration("delay", v.Delay),
+			zap.Duration("maxDelay", v.MaxDelay))
+	}
 
-			spawned := false
-			if h.forwarding.MaxConcurrency > 0 {
-				spawned = h.forwardingBoundWorkers.GoIfAvailable(forward)
-			} else {
-				go forward()
-				spawned = true
-			}
-			if !spawned {
-				h.metrics.forwardDropped.Inc(1)
-			}
+	var labelCardinality *labelCardinalityGuard
+	if v := handlerOpts.LabelCardinality; v != nil {
+		labelCardinality, err = newLabelCardinalityGuard(*v,
+			options.PromWriteLabelCardinalityBackend(), nowFn, scope,
+			instrumentOpts.Logger())
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	batchErr := h.write(r.Context(), req, opts)
+	labelSplits, err := newLabelSplits(handlerOpts.LabelSplits)
+	if err != nil {
+		return nil, err
+	}
 
-	// Record ingestion delay latency
-	now := h.nowFn()
-	for _, series := range req.Timeseries {
-		for _, sample := range series.Samples {
-			age := now.Sub(storage.PromTimestampToTime(sample.Timestamp))
-			h.metrics.ingestLatency.RecordDuration(age)
+	var utf8Validator *utf8Validator
+	if v := handlerOpts.UTF8Validation; v != "" {
+		utf8Validator, err = newUTF8Validator(v, scope)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	if batchErr != nil {
-		var (
-			errs                 = batchErr.Errors()
-			lastRegularErr       string
-			lastBadRequestErr    string
-			numRegular           int
-			numBadRequest        int
-			numResourceExhausted int
-		)
-		for _, err := range errs {
-			switch {
-			case client.IsResourceExhaustedError(err):
-				numResourceExhausted++
-				lastBadRequestErr = err.Error()
-			case client.IsBadRequestError(err):
-				numBadRequest++
-				lastBadRequestErr = err.Error()
-			case xerrors.IsInvalidParams(err):
-				numBadRequest++
-				lastBadRequestErr = err.Error()
-			default:
-				numRegular++
-				lastRegularErr = err.Error()
-			}
+	var labelNameValidator *labelNameValidator
+	if v := handlerOpts.LabelNameValidation; v != nil {
+		labelNameValidator, err = newLabelNameValidator(*v, scope)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		var status int
-		switch {
-		case numBadRequest == len(errs):
-			status = http.StatusBadRequest
-		case numResourceExhausted > 0:
-			status = http.StatusTooManyRequests
-		default:
-			status = http.StatusInternalServerError
+	var allowedClientCNs map[string]struct{}
+	if v := handlerOpts.ClientCertificate; v != nil {
+		allowedClientCNs, err = newAllowedClientCommonNames(*v)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		logger := logging.WithContext(r.Context(), h.instrumentOpts)
-		logger.Error("write error",
-			zap.String("remoteAddr", r.RemoteAddr),
-			zap.Int("httpResponseStatusCode", status),
-			zap.Int("numResourceExhaustedErrors", numResourceExhausted),
-			zap.Int("numRegularErrors", numRegular),
-			zap.Int("numBadRequestErrors", numBadRequest),
-			zap.String("lastRegularError", lastRegularErr),
-			zap.String("lastBadRequestErr", lastBadRequestErr))
+	maxTagLiteralLength := int(tagOptions.MaxTagLiteralLength())
+	for _, limit := range []struct {
+		name  string
+		value int
+	}{
+		{name: "max label name length", value: handlerOpts.MaxLabelNameLength},
+		{name: "max label value length", value: handlerOpts.MaxLabelValueLength},
+	} {
+		if limit.value > maxTagLiteralLength {
+			return nil, fmt.Errorf("%s exceeds the max tag literal length: %d > %d",
+				limit.name, limit.value, maxTagLiteralLength)
+		}
+	}
 
-		var resultErrMessage string
-		if lastRegularErr != "" {
-			resultErrMessage = fmt.Sprintf("retryable_errors: count=%d, last=%s",
-				numRegular, lastRegularErr)
+	var secondaryWriter ingest.DownsamplerAndWriter
+	if handlerOpts.SecondaryWrite != nil {
+		secondaryWriter = options.PromWriteSecondaryDownsamplerAndWriter()
+		if secondaryWriter == nil {
+			return nil, errNoSecondaryDownsamplerAndWriter
 		}
-		if lastBadRequestErr != "" {
-			var sep string
-			if lastRegularErr != "" {
-				sep = ", "
-			}
-			resultErrMessage = fmt.Sprintf("%s%sbad_request_errors: count=%d, last=%s",
-				resultErrMessage, sep, numBadRequest, lastBadRequestErr)
+	}
+
+	var writeRetrier retry.Retrier
+	if writeRetry := handlerOpts.WriteRetry; writeRetry != nil {
+		if writeRetry.Forever != nil && *writeRetry.Forever {
+			return nil, errWriteRetryForever
 		}
+		writeRetrier = writeRetry.NewRetrier(scope.SubScope("write-retry"))
+	}
 
-		resultError := xhttp.NewError(errors.New(resultErrMessage), status)
-		h.metrics.incError(resultError)
-		xhttp.WriteError(w, resultError)
-		return
+	switch handlerOpts.MalformedSeries {
+	case "", handleroptions.PromWriteHandlerMalformedSeriesModeReject,
+		handleroptions.PromWriteHandlerMalformedSeriesModeDrop:
+	default:
+		return nil, fmt.Errorf("unknown malformed series mode: %s",
+			handlerOpts.MalformedSeries)
 	}
 
-	// NB(schallert): this is frustrating but if we don't explicitly write an HTTP
-	// status code (or via Write()), OpenTracing middleware reports code=0 and
-	// shows up as error.
-	w.WriteHeader(200)
-	h.metrics.writeSuccess.Inc(1)
-}
+	switch handlerOpts.UnsupportedSeries {
+	case "", handleroptions.PromWriteHandlerUnsupportedSeriesModeReject,
+		handleroptions.PromWriteHandlerUnsupportedSeriesModePartial:
+	default:
+		return nil, fmt.Errorf("unknown unsupported series mode: %s",
+			handlerOpts.UnsupportedSeries)
+	}
 
-type parseRequestResult struct {
-	Request        *prompb.WriteRequest
-	Options        ingest.WriteOptions
-	CompressResult prometheus.ParsePromCompressedRequestResult
-}
+	switch handlerOpts.StaleMarkers {
+	case "", handleroptions.PromWriteHandlerStaleMarkersModePassthrough,
+		handleroptions.PromWriteHandlerStaleMarkersModeDrop,
+		handleroptions.PromWriteHandlerStaleMarkersModeAnnotate:
+	default:
+		return nil, fmt.Errorf("unknown stale markers mode: %s",
+			handlerOpts.StaleMarkers)
+	}
 
-func (h *PromWriteHandler) checkedParseRequest(
-	r *http.Request,
-) (parseRequestResult, error) {
-	result, err := h.parseRequest(r)
+	metadataStore := options.PromWriteMetadataStore()
+	switch handlerOpts.Metadata {
+	case "", handleroptions.PromWriteHandlerMetadataModeNoop:
+	case handleroptions.PromWriteHandlerMetadataModeStore:
+		if metadataStore == nil {
+			return nil, errNoMetadataStore
+		}
+	default:
+		return nil, fmt.Errorf("unknown metadata mode: %s", handlerOpts.Metadata)
+	}
+
+	var remoteWriteVersions map[string]struct{}
+	if v := handlerOpts.RemoteWriteVersion; v != nil {
+		remoteWriteVersions = make(map[string]struct{}, len(v.AcceptedVersions))
+		for _, version := range v.AcceptedVersions {
+			remoteWriteVersions[version] = struct{}{}
+		}
+	}
+
+	deprecatedHeaders, err := newPromWriteDeprecatedHeaders(handlerOpts.DeprecatedHeaders)
 	if err != nil {
-		// Always invalid request if parsing fails params.
-		return parseRequestResult{}, xerrors.NewInvalidParamsError(err)
+		return nil, err
 	}
-	return result, nil
-}
 
-// parseRequest extracts the Prometheus write request from the request body and
-// headers. WARNING: it is not guaranteed that the tags returned in the request
-// body are in sorted order. It is expected that the caller ensures the tags are
-// sorted before passing them to storage, which currently happens in write() ->
-// newTSPromIter() -> storage.PromLabelsToM3Tags() -> tags.AddTags(). This is
-// the only path written metrics are processed, but future write paths must
-// uphold the same guarantees.
-func (h *PromWriteHandler) parseRequest(
-	r *http.Request,
-) (parseRequestResult, error) {
-	var opts ingest.WriteOptions
-	if v := strings.TrimSpace(r.Header.Get(headers.MetricsTypeHeader)); v != "" {
-		// Allow the metrics type and storage policies to override
-		// the default rules and policies if specified.
-		metricsType, err := storagemetadata.ParseMetricsType(v)
+	var accessLogger *promWriteAccessLogger
+	if v := handlerOpts.AccessLog; v != nil {
+		accessLogger, err = newPromWriteAccessLogger(*v, instrumentOpts.Logger())
 		if err != nil {
-			return parseRequestResult{}, err
+			return nil, err
 		}
+	}
 
-		// Ensure ingest options specify we are overriding the
-		// downsampling rules with zero rules to be applied (so
-		// only direct writes will be made).
-		opts.DownsampleOverride = true
-		opts.DownsampleMappingRules = nil
+	statusCodes, err := newPromWriteStatusCodes(handlerOpts.StatusCodes)
+	if err != nil {
+		return nil, err
+	}
 
-		strPolicy := strings.TrimSpace(r.Header.Get(headers.MetricsStoragePolicyHeader))
-		switch metricsType {
-		case storagemetadata.UnaggregatedMetricsType:
-			if strPolicy != emptyStoragePolicyVar {
-				return parseRequestResult{}, errUnaggregatedStoragePolicySet
-			}
-		default:
-			parsed, err := policy.ParseStoragePolicy(strPolicy)
-			if err != nil {
-				err = fmt.Errorf("could not parse storage policy: %v", err)
-				return parseRequestResult{}, err
-			}
+	writePools, err := newWritePools(handlerOpts.WritePools, scope)
+	if err != nil {
+		return nil, err
+	}
 
-			// Make sure this specific storage policy is used for the writes.
-			opts.WriteOverride = true
-			opts.WriteStoragePolicies = policy.StoragePolicies{
-				parsed,
-			}
+	var deadLetter *deadLetterPoster
+	if v := handlerOpts.DeadLetter; v != nil {
+		deadLetter, err = newDeadLetterPoster(*v)
+		if err != nil {
+			return nil, err
 		}
 	}
-	if v := strings.TrimSpace(r.Header.Get(headers.WriteTypeHeader)); v != "" {
-		switch v {
-		case headers.DefaultWriteType:
-		case headers.AggregateWriteType:
-			opts.WriteOverride = true
-			opts.WriteStoragePolicies = policy.StoragePolicies{}
+
+	var asyncWriter *asyncWriter
+	if v := handlerOpts.AsyncWrite; v != nil {
+		asyncWriter, err = newAsyncWriter(*v, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var topMetricNames *topMetricNames
+	if v := handlerOpts.TopMetricNames; v != nil {
+		topMetricNames, err = newTopMetricNames(*v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var idempotencyKeys *cache.LRU
+	if v := handlerOpts.Idempotency; v != nil {
+		idempotencyKeys = newIdempotencyKeys(*v, nowFn, scope)
+	}
+
+	var packedHistograms *packedHistograms
+	if v := handlerOpts.PackedHistograms; v != nil {
+		packedHistograms = newPackedHistograms(*v, scope)
+	}
+
+	var monotonicTimestamps *monotonicTimestamps
+	if v := handlerOpts.MonotonicTimestamps; v != nil {
+		monotonicTimestamps, err = newMonotonicTimestamps(*v, nowFn, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Only use a forwarding worker pool if concurrency is bound, otherwise
+	// if unlimited we just spin up a goroutine for each incoming write.
+	var forwardingBoundWorkers xsync.WorkerPool
+	if v := forwarding.MaxConcurrency; v > 0 {
+		forwardingBoundWorkers = xsync.NewWorkerPool(v)
+		forwardingBoundWorkers.Init()
+	}
+
+	if v := forwarding.MaxTargetsPerRequest; v < 0 {
+		return nil, fmt.Errorf("forwarding max targets per request must not be "+
+			"negative: %d", v)
+	} else if v > 0 && len(forwarding.Targets) > v {
+		instrumentOpts.Logger().Warn("prom remote write forwarding targets exceed "+
+			"the max targets per request, excess targets will be skipped",
+			zap.Int("numTargets", len(forwarding.Targets)),
+			zap.Int("maxTargetsPerRequest", v))
+	}
+
+	forwardTimeout := defaultForwardingTimeout
+	if v := forwarding.Timeout; v > 0 {
+		forwardTimeout = v
+	}
+
+	// The HTTP client timeout must accommodate the longest per-target
+	// timeout, each forward is then bound by its own context deadline.
+	forwardClientTimeout := forwardTimeout
+	for _, target := range forwarding.Targets {
+		if target.Timeout > forwardClientTimeout {
+			forwardClientTimeout = target.Timeout
+		}
+	}
+
+	forwardHTTPOpts := xhttp.DefaultHTTPClientOptions()
+	forwardHTTPOpts.DisableCompression = true // Already snappy compressed.
+	forwardHTTPOpts.RequestTimeout = forwardClientTimeout
+	if v := forwarding.Transport; v != nil {
+		if v.MaxIdleConns > 0 {
+			forwardHTTPOpts.MaxIdleConns = v.MaxIdleConns
+		}
+		if v.IdleConnTimeout > 0 {
+			forwardHTTPOpts.IdleConnTimeout = v.IdleConnTimeout
+		}
+	}
+	forwardHTTPClient := xhttp.NewHTTPClient(forwardHTTPOpts)
+	// NB: The client does not set the idle connection timeout on its
+	// transport.
+	transport := forwardHTTPClient.Transport.(*http.Transport)
+	transport.IdleConnTimeout = forwardHTTPOpts.IdleConnTimeout
+	if v := forwarding.Transport; v != nil && v.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = v.MaxConnsPerHost
+	}
+
+	forwardRetryConfig := defaultForwardRetryConfig
+	if forwarding.Retry != nil {
+		forwardRetryConfig = *forwarding.Retry
+	}
+	forwardRetryOpts := forwardRetryConfig.NewOptions(
+		scope.SubScope("forwarding-retry"),
+	)
+	forwardRetriers := newForwardRetriers(forwarding.Targets, forwardRetryConfig,
+		scope.SubScope("forwarding-retry"))
+
+	forwardTransforms := options.PromWriteForwardTransforms()
+	for _, target := range forwarding.Targets {
+		if target.Transform == "" {
+			continue
+		}
+		if _, ok := forwardTransforms[target.Transform]; !ok {
+			return nil, fmt.Errorf("unknown forwarding transform: %s", target.Transform)
+		}
+	}
+
+	// NB: Schedules are indexed by target since targets are passed by value.
+	var forwardSchedules []*forwardSchedule
+	for i, target := range forwarding.Targets {
+		if target.Schedule == nil {
+			continue
+		}
+		schedule, err := newForwardSchedule(*target.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		if forwardSchedules == nil {
+			forwardSchedules = make([]*forwardSchedule, len(forwarding.Targets))
+		}
+		forwardSchedules[i] = schedule
+	}
+
+	forwardTokenSources, err := newForwardTokenSources(forwarding.Targets,
+		forwardHTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	forwardSigners, err := newForwardSigners(forwarding.Targets)
+	if err != nil {
+		return nil, err
+	}
+
+	forwardCompressors, err := newForwardCompressors(forwarding.Targets)
+	if err != nil {
+		return nil, err
+	}
+
+	forwardSelectable, err := newForwardSelectableTargets(forwarding)
+	if err != nil {
+		return nil, err
+	}
+
+	forwardShards, err := newForwardShards(forwarding.Targets)
+	if err != nil {
+		return nil, err
+	}
+
+	forwardRateLimiters, err := newForwardRateLimiters(forwarding.Targets, nowFn)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwtVerifier *jwtVerifier
+	if v := handlerOpts.JWT; v != nil {
+		var tenantHeaders []string
+		if v := handlerOpts.TenantMetrics; v != nil {
+			tenantHeaders = append(tenantHeaders, v.Header)
+		}
+		if v := handlerOpts.AccessLog; v != nil && v.TenantHeader != "" {
+			tenantHeaders = append(tenantHeaders, v.TenantHeader)
+		}
+		if v := handlerOpts.TenantSeriesLimit; v != nil {
+			tenantHeaders = append(tenantHeaders, v.Header)
+		}
+		jwtVerifier, err = newJWTVerifier(*v, tenantHeaders, nowFn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	h := &PromWriteHandler{
+		downsamplerAndWriter:   downsamplerAndWriter,
+		secondaryWriter:        secondaryWriter,
+		writeRetrier:           writeRetrier,
+		accessLogger:           accessLogger,
+		deprecatedHeaders:      deprecatedHeaders,
+		remoteWriteVersions:    remoteWriteVersions,
+		metadataStore:          metadataStore,
+		requestValidator:       options.PromWriteRequestValidator(),
+		tagOptions:             tagOptions,
+		storeMetricsType:       options.StoreMetricsType(),
+		forwarding:             forwarding,
+		forwardTimeout:         forwardTimeout,
+		forwardHTTPClient:      forwardHTTPClient,
+		forwardingBoundWorkers: forwardingBoundWorkers,
+		forwardContext:         context.Background(),
+		forwardRetrier:         retry.NewRetrier(forwardRetryOpts),
+		forwardRetriers:        forwardRetriers,
+		forwardTransforms:      forwardTransforms,
+		forwardSchedules:       forwardSchedules,
+		forwardTokenSources:    forwardTokenSources,
+		forwardSigners:         forwardSigners,
+		forwardCompressors:     forwardCompressors,
+		forwardSerialQueues:    newForwardSerialQueues(forwarding.Targets),
+		forwardSelectable:      forwardSelectable,
+		forwardShards:          forwardShards,
+		forwardRateLimiters:    forwardRateLimiters,
+		nowFn:                  nowFn,
+		metrics:                metrics,
+		instrumentOpts:         instrumentOpts,
+		handlerOpts:            handlerOpts,
+		labelCardinality:       labelCardinality,
+		utf8Validator:          utf8Validator,
+		labelNameValidator:     labelNameValidator,
+		labelSplits:            labelSplits,
+		allowedClientCNs:       allowedClientCNs,
+		statusCodes:            statusCodes,
+		idempotencyKeys:        idempotencyKeys,
+		monotonicTimestamps:    monotonicTimestamps,
+		packedHistograms:       packedHistograms,
+		metricsPusher:          metricsPusher,
+		writePools:             writePools,
+		deadLetter:             deadLetter,
+		asyncWriter:            asyncWriter,
+		topMetricNames:         topMetricNames,
+		jwtVerifier:            jwtVerifier,
+	}
+
+	if v := handlerOpts.SamplingLabel; v != "" {
+		h.samplingLabel = []byte(v)
+	}
+
+	if v := handlerOpts.MaxInFlightBytes; v > 0 {
+		h.inFlightBytes = newInFlightBytes(v, scope)
+	}
+
+	if v := handlerOpts.TenantSeriesLimit; v != nil {
+		h.tenantSeriesLimiter, err = newTenantSeriesLimiter(*v, nowFn, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := handlerOpts.AuditLog; v != nil {
+		h.auditLog, err = newAuditLog(*v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := handlerOpts.PriorityPools; v != nil {
+		h.priorityPools, err = newPriorityPools(*v, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := handlerOpts.LabelTrimming; v != nil {
+		h.labelTrimmer = newLabelTrimmer(*v, scope)
+	}
+
+	if v := handlerOpts.TypeInference; v != nil {
+		h.typeInference, err = newPromTypeInference(*v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := handlerOpts.LabelCounts; v != nil {
+		h.labelCounts, err = newLabelCountRecorder(*v, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := handlerOpts.SeriesDenylist; v != nil {
+		h.seriesDenylist, err = newSeriesDenylist(*v, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := handlerOpts.HistogramValidation; v != nil {
+		h.histogramValidator = newHistogramValidator(*v, scope)
+	}
+
+	if v := handlerOpts.SeriesMerging; v != nil {
+		h.seriesMerger, err = newSeriesMerger(*v, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := handlerOpts.NewMetricNameWebhook; v != nil {
+		h.newMetricNames, err = newNewMetricNameNotifier(*v, nowFn,
+			instrumentOpts, scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := handlerOpts.Coalescing; v != nil {
+		h.coalescer, err = newWriteCoalescer(*v, h.writeWithRetry,
+			instrumentOpts.Logger(), scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if v := handlerOpts.Heartbeat; v != nil {
+		h.heartbeatWriter, err = newHeartbeatWriter(*v, h.writeWithRetry,
+			nowFn, instrumentOpts.Logger(), scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+// Close stops writing heartbeats, writes any series buffered for coalescing
+// or queued for async writes up to the async write drain timeout, waits for
+// queued serial forwards and pushes the handler metrics to the pushgateway if
+// metrics push is configured.
+func (h *PromWriteHandler) Close() error {
+	if h.heartbeatWriter != nil {
+		h.heartbeatWriter.Close()
+	}
+	multiErr := xerrors.NewMultiError()
+	if h.asyncWriter != nil {
+		multiErr = multiErr.Add(h.asyncWriter.Close())
+	}
+	if h.coalescer != nil {
+		h.coalescer.Close()
+	}
+	h.forwardSerialQueues.Close()
+
+	if h.metricsPusher != nil {
+		multiErr = multiErr.Add(h.metricsPusher.Close())
+	}
+	return multiErr.FinalError()
+}
+
+type promWriteMetrics struct {
+	results                  promWriteResultMetrics
+	tenants                  *promWriteTenantMetrics
+	writeTruncatedSeries     tally.Counter
+	clientCertRejected       tally.Counter
+	jwtRejected              tally.Counter
+	memoryBudgetExceeded     tally.Counter
+	storagePolicyFallback    tally.Counter
+	truncatedBodies          tally.Counter
+	decompressedBytes        tally.Counter
+	secondaryWriteSuccess    tally.Counter
+	secondaryWriteErrors     tally.Counter
+	writePaused              tally.Counter
+	seriesDroppedNoName      tally.Counter
+	seriesRelabeledEmptyName tally.Counter
+	seriesDroppedMalformed   tally.Counter
+	seriesUnsupported        map[string]tally.Counter
+	seriesDuplicateLabel     tally.Counter
+	writeIdempotentDedup     tally.Counter
+	deprecatedHeaderUsed     tally.Counter
+	writePartialSuccess      tally.Counter
+	duplicateLabelsRemoved   tally.Counter
+	defaultWritePoolWrites   tally.Counter
+	metadataOnly             tally.Counter
+	metadataStored           tally.Counter
+	metadataIgnored          tally.Counter
+	metadataErrors           tally.Counter
+	counterResets            tally.Counter
+	staleMarkersDropped      tally.Counter
+	staleMarkersAnnotated    tally.Counter
+	requestRejected          tally.Counter
+	writeIndexFull           tally.Counter
+	writeBatchLatencyBuckets tally.DurationBuckets
+	ingestLatencyBuckets     tally.DurationBuckets
+	defaultLatency           promWriteLatencyMetrics
+	unaggregatedLatency      promWriteLatencyMetrics
+	aggregatedLatency        promWriteLatencyMetrics
+	aggregateWriteLatency    promWriteLatencyMetrics
+	forwardSuccess           tally.Counter
+	forwardErrors            tally.Counter
+	forwardBuildErrors       tally.Counter
+	forwardDropped           tally.Counter
+	forwardRateLimited       tally.Counter
+	forwardSkipped           tally.Counter
+	forwardCapped            tally.Counter
+	forwardWindowSkipped     tally.Counter
+	forwardFallback          tally.Counter
+	forwardFallbackSkipped   tally.Counter
+	forwardActive            tally.Gauge
+	forwardLatency           tally.Histogram
+	forwardLatencyClamped    tally.Counter
+	forwardShadowKeep        tally.Counter
+	forwardShadowDrop        tally.Counter
+	deadLetterSuccess        tally.Counter
+	deadLetterErrors         tally.Counter
+	deadLetterDropped        tally.Counter
+}
+
+// promWritePath is how the write path of a request was resolved, either by
+// the server rules or overridden by the request headers.
+type promWritePath string
+
+const (
+	promWritePathRules              promWritePath = "rules"
+	promWritePathOverride           promWritePath = "override"
+	promWritePathAggregateWriteType promWritePath = "aggregate-write-type"
+)
+
+// writeOptionsPath returns the write path resolved for the write options.
+func writeOptionsPath(opts ingest.WriteOptions) promWritePath {
+	switch {
+	case opts.WriteOverride && len(opts.WriteStoragePolicies) == 0:
+		return promWritePathAggregateWriteType
+	case opts.WriteOverride || opts.DownsampleOverride:
+		return promWritePathOverride
+	default:
+		return promWritePathRules
+	}
+}
+
+// promWriteLatencyMetrics are the latency metrics of requests resolving to
+// the same metrics type and write path, since aggregated and unaggregated
+// writes have very different latency profiles.
+type promWriteLatencyMetrics struct {
+	writeBatchLatency tally.Histogram
+	ingestLatency     tally.Histogram
+	oldestSampleAge   tally.Histogram
+	newestSampleAge   tally.Histogram
+}
+
+func newPromWriteLatencyMetrics(
+	scope tally.Scope,
+	metricsType string,
+	path promWritePath,
+	buckets ingest.LatencyBuckets,
+) promWriteLatencyMetrics {
+	scope = scope.Tagged(map[string]string{
+		"metrics_type": metricsType,
+		"path":         string(path),
+	})
+	return promWriteLatencyMetrics{
+		writeBatchLatency: scope.SubScope("write").Histogram("batch-latency", buckets.WriteLatencyBuckets),
+		ingestLatency:     scope.SubScope("ingest").Histogram("latency", buckets.IngestLatencyBuckets),
+		oldestSampleAge:   scope.SubScope("ingest").Histogram("oldest-sample-age", buckets.IngestLatencyBuckets),
+		newestSampleAge:   scope.SubScope("ingest").Histogram("newest-sample-age", buckets.IngestLatencyBuckets),
+	}
+}
+
+// latency returns the latency metrics for the metrics type and write path
+// resolved from the write options, requests that do not override the metrics
+// type use the default latency metrics.
+func (m *promWriteMetrics) latency(opts ingest.WriteOptions) promWriteLatencyMetrics {
+	if writeOptionsPath(opts) == promWritePathAggregateWriteType {
+		return m.aggregateWriteLatency
+	}
+
+	metricsType, resolved := writeOptionsMetricsType(opts)
+	if !resolved {
+		return m.defaultLatency
+	}
+
+	switch metricsType {
+	case storagemetadata.UnaggregatedMetricsType:
+		return m.unaggregatedLatency
+	case storagemetadata.AggregatedMetricsType:
+		return m.aggregatedLatency
+	default:
+		return m.defaultLatency
+	}
+}
+
+// forRequest returns the result metrics of the request, which are also
+// tagged with the tenant of the request when tenant metrics are enabled.
+func (m *promWriteMetrics) forRequest(r *http.Request) promWriteResultMetrics {
+	if m.tenants == nil {
+		return m.results
+	}
+	return m.tenants.forRequest(r)
+}
+
+func (m *promWriteMetrics) incError(r *http.Request, err error) {
+	results := m.forRequest(r)
+	if xhttp.IsClientError(err) {
+		results.writeErrorsClient.Inc(1)
+	} else {
+		results.writeErrorsServer.Inc(1)
+	}
+}
+
+func newPromWriteMetrics(
+	scope tally.Scope,
+	tenantOpts *handleroptions.PromWriteHandlerTenantMetricsOptions,
+) (promWriteMetrics, error) {
+	buckets, err := ingest.NewLatencyBuckets()
+	if err != nil {
+		return promWriteMetrics{}, err
+	}
+
+	var (
+		defaultLatency = newPromWriteLatencyMetrics(scope, "default",
+			promWritePathRules, buckets)
+		unaggregatedLatency = newPromWriteLatencyMetrics(scope,
+			storagemetadata.UnaggregatedMetricsType.String(), promWritePathOverride, buckets)
+		aggregatedLatency = newPromWriteLatencyMetrics(scope,
+			storagemetadata.AggregatedMetricsType.String(), promWritePathOverride, buckets)
+		aggregateWriteLatency = newPromWriteLatencyMetrics(scope,
+			storagemetadata.AggregatedMetricsType.String(), promWritePathAggregateWriteType, buckets)
+		results = newPromWriteResultMetrics(scope)
+		tenants *promWriteTenantMetrics
+	)
+	if tenantOpts != nil {
+		tenants = newPromWriteTenantMetrics(*tenantOpts, scope, results)
+	}
+	return promWriteMetrics{
+		results:                  results,
+		tenants:                  tenants,
+		writeTruncatedSeries:     scope.SubScope("write").Counter("truncated-series"),
+		clientCertRejected:       scope.SubScope("write").Counter("client-cert-rejected"),
+		jwtRejected:              scope.SubScope("write").Counter("jwt-rejected"),
+		memoryBudgetExceeded:     scope.SubScope("write").Counter("memory-budget-exceeded"),
+		storagePolicyFallback:    scope.SubScope("write").Counter("storage-policy-fallback"),
+		truncatedBodies:          scope.SubScope("write").Counter("truncated-bodies"),
+		decompressedBytes:        scope.SubScope("write").Counter("decompressed-bytes"),
+		secondaryWriteSuccess:    scope.SubScope("write").SubScope("secondary").Counter("success"),
+		secondaryWriteErrors:     scope.SubScope("write").SubScope("secondary").Counter("errors"),
+		writePaused:              scope.SubScope("write").Counter("paused"),
+		seriesDroppedNoName:      scope.SubScope("write").Counter("series-dropped-no-name"),
+		seriesRelabeledEmptyName: scope.SubScope("write").Counter("series-relabeled-empty-name"),
+		seriesDroppedMalformed:   scope.SubScope("write").Counter("series-dropped-malformed"),
+		seriesUnsupported:        newSeriesUnsupportedCounters(scope),
+		seriesDuplicateLabel:     scope.SubScope("write").Counter("series-duplicate-label"),
+		writeIdempotentDedup:     scope.SubScope("write").Counter("idempotent-dedup"),
+		deprecatedHeaderUsed:     scope.SubScope("write").Counter("deprecated-header-used"),
+		writePartialSuccess:      scope.SubScope("write").Counter("partial-success"),
+		duplicateLabelsRemoved:   scope.SubScope("write").Counter("duplicate-labels-removed"),
+		defaultWritePoolWrites:   newWritePoolWritesCounter(scope, defaultWritePoolName),
+		metadataOnly:             scope.SubScope("write").Counter("metadata-only"),
+		metadataStored:           scope.SubScope("write").SubScope("metadata").Counter("stored"),
+		metadataIgnored:          scope.SubScope("write").SubScope("metadata").Counter("ignored"),
+		metadataErrors:           scope.SubScope("write").SubScope("metadata").Counter("errors"),
+		counterResets:            scope.SubScope("write").Counter("counter-resets"),
+		staleMarkersDropped:      scope.SubScope("write").SubScope("stale-markers").Counter("dropped"),
+		staleMarkersAnnotated:    scope.SubScope("write").SubScope("stale-markers").Counter("annotated"),
+		requestRejected:          scope.SubScope("write").Counter("request-rejected"),
+		writeIndexFull:           scope.SubScope("write").Counter("index-full"),
+		writeBatchLatencyBuckets: buckets.WriteLatencyBuckets,
+		ingestLatencyBuckets:     buckets.IngestLatencyBuckets,
+		defaultLatency:           defaultLatency,
+		unaggregatedLatency:      unaggregatedLatency,
+		aggregatedLatency:        aggregatedLatency,
+		aggregateWriteLatency:    aggregateWriteLatency,
+		forwardSuccess:           scope.SubScope("forward").Counter("success"),
+		forwardErrors:            scope.SubScope("forward").Counter("errors"),
+		forwardBuildErrors:       scope.SubScope("forward").Counter("build-errors"),
+		forwardDropped:           scope.SubScope("forward").Counter("dropped"),
+		forwardRateLimited:       scope.SubScope("forward").Counter("rate-limited"),
+		forwardSkipped:           scope.SubScope("forward").Counter("skipped"),
+		forwardCapped:            scope.SubScope("forward").Counter("capped"),
+		forwardWindowSkipped:     scope.SubScope("forward").Counter("window-skipped"),
+		forwardFallback:          scope.SubScope("forward").Counter("fallback"),
+		forwardFallbackSkipped:   scope.SubScope("forward").Counter("fallback-skipped"),
+		forwardActive:            scope.SubScope("forward").Gauge("active"),
+		forwardLatency:           scope.SubScope("forward").Histogram("latency", buckets.WriteLatencyBuckets),
+		forwardLatencyClamped:    scope.SubScope("forward").Counter("latency-clamped"),
+		forwardShadowKeep:        scope.SubScope("forward").SubScope("shadow").Counter("keep"),
+		forwardShadowDrop:        scope.SubScope("forward").SubScope("shadow").Counter("drop"),
+		deadLetterSuccess:        scope.SubScope("dead-letter").Counter("success"),
+		deadLetterErrors:         scope.SubScope("dead-letter").Counter("errors"),
+		deadLetterDropped:        scope.SubScope("dead-letter").Counter("dropped"),
+	}, nil
+}
+
+func (h *PromWriteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = h.withRequestID(r)
+	w.Header().Set(headers.RequestIDHeader, logging.ReadContextID(r.Context()))
+
+	accessLogged := h.accessLogger != nil && h.accessLogger.sample()
+	if accessLogged || h.auditLog != nil {
+		var (
+			start   = time.Now()
+			tracker = &xhttpstatus.StatusCodeTracker{ResponseWriter: w}
+			entry   *promWriteAccessLogEntry
+		)
+		r, entry = withAccessLogEntry(r)
+		w = tracker
+		defer func() {
+			latency := time.Since(start)
+			if accessLogged {
+				h.accessLogger.log(r, entry, tracker.Status, latency)
+			}
+			if h.auditLog != nil {
+				h.auditLog.record(r, entry, tracker.Status, h.nowFn(), latency)
+			}
+		}()
+	}
+
+	// NB: Paused writes are rejected before any other work such as
+	// authentication or debug delays, so that pausing sheds load at once,
+	// but after tracking the response so they are still access logged and
+	// audited.
+	if h.writePausedError(w) {
+		return
+	}
+
+	w, closeResponse := withResponseCompression(w, r)
+	defer closeResponse()
+
+	h.warnDeprecatedHeaders(w, r)
+
+	if err := h.checkClientCertificate(r); err != nil {
+		logger := logging.WithContext(r.Context(), h.instrumentOpts)
+		logger.Debug("client certificate rejected",
+			zap.String("remoteAddr", r.RemoteAddr), zap.Error(err))
+		h.metrics.incError(r, err)
+		writeError(w, withErrorCode(err, PromWriteErrorCodeUnauthorized))
+		return
+	}
+
+	if h.jwtVerifier != nil {
+		if err := h.jwtVerifier.verify(r); err != nil {
+			logger := logging.WithContext(r.Context(), h.instrumentOpts)
+			logger.Debug("jwt rejected",
+				zap.String("remoteAddr", r.RemoteAddr), zap.Error(err))
+			h.metrics.jwtRejected.Inc(1)
+			h.metrics.incError(r, err)
+			writeError(w, withErrorCode(err, PromWriteErrorCodeUnauthorized))
+			return
+		}
+	}
+
+	// NB: Inject any debug delay before timing the request so that latency
+	// metrics are not skewed by load tests.
+	if err := h.injectDebugResponseDelay(r); err != nil {
+		h.metrics.incError(r, err)
+		writeError(w, withErrorCode(err, PromWriteErrorCodeInvalidHeader))
+		return
+	}
+
+	if key := strings.TrimSpace(r.Header.Get(headers.IdempotencyKeyHeader)); key != "" &&
+		h.idempotencyKeys != nil {
+		h.serveIdempotentWrite(w, r, key)
+		return
+	}
+
+	h.serveWrite(w, r)
+}
+
+// serveWrite parses, forwards and writes the request.
+func (h *PromWriteHandler) serveWrite(w http.ResponseWriter, r *http.Request) {
+	// NB: The batch latency is recorded against the metrics type resolved
+	// from the request, which is only known once the request is parsed.
+	var (
+		batchRequestStart = time.Now()
+		latencyMetrics    = h.metrics.defaultLatency
+	)
+	defer func() {
+		latencyMetrics.writeBatchLatency.RecordDuration(time.Since(batchRequestStart))
+	}()
+
+	if h.handlerOpts.StrictContentType {
+		if err := checkContentType(r.Header); err != nil {
+			h.metrics.incError(r, err)
+			writeError(w, withErrorCode(err, PromWriteErrorCodeUnsupportedContentType))
+			return
+		}
+	}
+
+	if h.priorityPools != nil {
+		release, err := h.priorityPools.admit(r.Header)
+		if err != nil {
+			h.metrics.incError(r, err)
+			writeError(w, err)
+			return
+		}
+		defer release()
+	}
+
+	parseStart := time.Now()
+	checkedReq, err := h.checkedParseRequest(r)
+	h.addServerTiming(w, serverTimingParse, parseStart)
+	if err != nil {
+		logger := logging.WithContext(r.Context(), h.instrumentOpts)
+		logger.Debug("parse error", zap.Error(err))
+		h.metrics.incError(r, err)
+		writeParseError(w, err)
+		return
+	}
+	if n := checkedReq.InFlightBytes; n > 0 {
+		defer h.inFlightBytes.release(n)
+	}
+
+	if debugDrops, err := debugDropCounts(r); err != nil {
+		h.metrics.incError(r, err)
+		writeError(w, withErrorCode(err, PromWriteErrorCodeInvalidHeader))
+		return
+	} else if debugDrops {
+		checkedReq.Drops.setHeaders(w.Header())
+	}
+
+	// NB: Debug requests are responded to before any stateful limiter or
+	// observer runs, so that they leave no trace of the series they carry.
+	if checkedReq.DebugText {
+		if err := h.writeDebugTextExposition(w, checkedReq.Request); err != nil {
+			h.metrics.incError(r, err)
+			writeError(w, err)
+		}
+		return
+	}
+
+	// NB: The decompressed size is recorded so that the ingest throughput
+	// can be derived by rate, regardless of the compression of clients.
+	h.metrics.decompressedBytes.Inc(int64(len(checkedReq.CompressResult.UncompressedBody)))
+
+	var (
+		req  = checkedReq.Request
+		opts = checkedReq.Options
+	)
+	latencyMetrics = h.metrics.latency(opts)
+	setAccessLogRequest(r.Context(), req, opts)
+
+	if err := h.checkMemoryBudget(req); err != nil {
+		h.metrics.incError(r, err)
+		writeError(w, withErrorCode(err, PromWriteErrorCodeRequestTooLarge))
+		return
+	}
+
+	if err := h.validateRequest(r.Context(), req); err != nil {
+		logger := logging.WithContext(r.Context(), h.instrumentOpts)
+		logger.Debug("request rejected by validator", zap.Error(err))
+		h.metrics.requestRejected.Inc(1)
+		h.metrics.incError(r, err)
+		writeError(w, withErrorCode(err, PromWriteErrorCodeRequestRejected))
+		return
+	}
+
+	// NB: The series over the tenant series limit are not written while the
+	// rest of the series are, with the limit error returned once they are.
+	var tenantSeriesLimitErr error
+	if h.tenantSeriesLimiter != nil {
+		admitted, err := h.tenantSeriesLimiter.admit(r.Header, req.Timeseries)
+		if err != nil && len(admitted) == 0 {
+			h.metrics.incError(r, err)
+			writeError(w, err)
+			return
+		}
+		req.Timeseries = admitted
+		tenantSeriesLimitErr = err
+	}
+
+	if h.topMetricNames != nil {
+		h.topMetricNames.observe(req.Timeseries)
+	}
+
+	if h.newMetricNames != nil {
+		h.newMetricNames.observe(r, req.Timeseries)
+	}
+
+	var async bool
+	if h.asyncWriter != nil {
+		if async, err = h.asyncWriter.async(r); err != nil {
+			h.metrics.incError(r, err)
+			writeError(w, withErrorCode(err, PromWriteErrorCodeInvalidHeader))
+			return
+		}
+	}
+
+	// Begin async forwarding.
+	// NB(r): Be careful about not returning buffers to pool
+	// if the request bodies ever get pooled until after
+	// forwarding completes.
+	if len(h.forwarding.Targets) > 0 {
+		forwardStart := time.Now()
+		h.forwardRequest(r, checkedReq)
+		h.addServerTiming(w, serverTimingForward, forwardStart)
+	}
+
+	if len(checkedReq.Metadata) > 0 {
+		if err := h.writeMetadata(r.Context(), checkedReq.Metadata); err != nil {
+			logger := logging.WithContext(r.Context(), h.instrumentOpts)
+			logger.Error("write metadata error",
+				zap.String("remoteAddr", r.RemoteAddr),
+				zap.Int("numMetadata", len(checkedReq.Metadata)),
+				zap.Error(err))
+			resultError := xhttp.NewError(err, h.statusCodes.retryable)
+			h.metrics.incError(r, resultError)
+			xhttp.WriteError(w, resultError)
+			return
+		}
+		if len(req.Timeseries) == 0 {
+			// Metadata only requests have no series to write.
+			h.metrics.metadataOnly.Inc(1)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	var (
+		batchErr   ingest.BatchError
+		accepted   bool
+		writeStart = time.Now()
+	)
+	switch {
+	case async:
+		if err := h.writeAsync(r, req, opts); err != nil {
+			h.metrics.incError(r, err)
+			writeError(w, withErrorCode(err, PromWriteErrorCodeOverloaded))
+			return
+		}
+		accepted = true
+	case h.coalescer != nil && h.coalescer.coalesces(opts):
+		batchErr, accepted = h.coalescer.write(r.Context(), req)
+	default:
+		batchErr = h.writeWithRetry(r.Context(), req, opts)
+	}
+	h.addServerTiming(w, serverTimingWrite, writeStart)
+
+	// Record ingestion delay latency, along with the extremes of the request
+	// which are enough for most freshness alerting.
+	var (
+		now                  = h.nowFn()
+		oldestAge, newestAge time.Duration
+		numSamples           int
+	)
+	for _, series := range req.Timeseries {
+		for _, sample := range series.Samples {
+			age := now.Sub(storage.PromTimestampToTime(sample.Timestamp))
+			latencyMetrics.ingestLatency.RecordDuration(age)
+			if numSamples == 0 || age > oldestAge {
+				oldestAge = age
+			}
+			if numSamples == 0 || age < newestAge {
+				newestAge = age
+			}
+			numSamples++
+		}
+	}
+	if numSamples > 0 {
+		latencyMetrics.oldestSampleAge.RecordDuration(oldestAge)
+		latencyMetrics.newestSampleAge.RecordDuration(newestAge)
+	}
+
+	if batchErr != nil {
+		h.postDeadLetter(r, checkedReq, batchErr)
+
+		var (
+			errs                 = batchErr.Errors()
+			lastRegularErr       string
+			lastBadRequestErr    string
+			numRegular           int
+			numBadRequest        int
+			numResourceExhausted int
+			numIndexFull         int
+			lastIndexFullErr     string
+		)
+		for _, err := range errs {
+			switch {
+			case xerrors.IsIndexFull(err):
+				numIndexFull++
+				lastIndexFullErr = err.Error()
+			case client.IsResourceExhaustedError(err):
+				numResourceExhausted++
+				lastBadRequestErr = err.Error()
+			case client.IsBadRequestError(err):
+				numBadRequest++
+				lastBadRequestErr = err.Error()
+			case xerrors.IsInvalidParams(err):
+				numBadRequest++
+				lastBadRequestErr = err.Error()
+			default:
+				numRegular++
+				lastRegularErr = err.Error()
+			}
+		}
+
+		logger := logging.WithContext(r.Context(), h.instrumentOpts)
+		if h.isResourceExhaustedPartialSuccess(numResourceExhausted, len(errs),
+			len(req.Timeseries)) {
+			logger.Warn("write partial success",
+				zap.String("remoteAddr", r.RemoteAddr),
+				zap.Int("numSeries", len(req.Timeseries)),
+				zap.Int("numResourceExhaustedErrors", numResourceExhausted),
+				zap.String("lastResourceExhaustedErr", lastBadRequestErr))
+			h.metrics.writePartialSuccess.Inc(1)
+			xhttp.WriteJSONResponse(w, PromWritePartialSuccessResponse{
+				Status:                   partialSuccessStatus,
+				NumSeries:                len(req.Timeseries),
+				NumResourceExhausted:     numResourceExhausted,
+				LastResourceExhaustedErr: lastBadRequestErr,
+			}, logger)
+			return
+		}
+
+		var status int
+		switch {
+		case numBadRequest == len(errs):
+			status = h.statusCodes.badRequest
+		case numIndexFull > 0:
+			// NB: Clients must stop creating new series rather than retry.
+			h.metrics.writeIndexFull.Inc(1)
+			status = h.statusCodes.indexFull
+		case numResourceExhausted > 0:
+			status = h.statusCodes.resourceExhausted
+		default:
+			status = h.statusCodes.retryable
+		}
+
+		logger.Error("write error",
+			zap.String("remoteAddr", r.RemoteAddr),
+			zap.Int("httpResponseStatusCode", status),
+			zap.Int("numResourceExhaustedErrors", numResourceExhausted),
+			zap.Int("numIndexFullErrors", numIndexFull),
+			zap.Int("numRegularErrors", numRegular),
+			zap.Int("numBadRequestErrors", numBadRequest),
+			zap.String("lastRegularError", lastRegularErr),
+			zap.String("lastBadRequestErr", lastBadRequestErr))
+
+		var resultErrMessage string
+		if lastRegularErr != "" {
+			resultErrMessage = fmt.Sprintf("retryable_errors: count=%d, last=%s",
+				numRegular, lastRegularErr)
+		}
+		if lastBadRequestErr != "" {
+			var sep string
+			if lastRegularErr != "" {
+				sep = ", "
+			}
+			resultErrMessage = fmt.Sprintf("%s%sbad_request_errors: count=%d, last=%s",
+				resultErrMessage, sep, numBadRequest, lastBadRequestErr)
+		}
+		if lastIndexFullErr != "" {
+			var sep string
+			if resultErrMessage != "" {
+				sep = ", "
+			}
+			resultErrMessage = fmt.Sprintf("%s%sindex_full_errors: count=%d, last=%s, "+
+				"stop creating new series until the index has capacity",
+				resultErrMessage, sep, numIndexFull, lastIndexFullErr)
+		}
+
+		resultError := xhttp.NewError(errors.New(resultErrMessage), status)
+		h.metrics.incError(r, resultError)
+		if numBadRequest == len(errs) {
+			writeError(w, withErrorCode(resultError, PromWriteErrorCodeWriteRejected))
+		} else {
+			xhttp.WriteError(w, resultError)
+		}
+		return
+	}
+
+	if tenantSeriesLimitErr != nil {
+		if h.monotonicTimestamps != nil {
+			h.monotonicTimestamps.record(req)
+		}
+		h.metrics.incError(r, tenantSeriesLimitErr)
+		writeError(w, tenantSeriesLimitErr)
+		return
+	}
+
+	// NB(schallert): this is frustrating but if we don't explicitly write an HTTP
+	// status code (or via Write()), OpenTracing middleware reports code=0 and
+	// shows up as error.
+	status := http.StatusOK
+	if accepted {
+		status = http.StatusAccepted
+	}
+	if checkedReq.Unsupported.total > 0 {
+		// NB: The supported series were written so the request succeeds, with
+		// the unsupported series reported in the response.
+		logger := logging.WithContext(r.Context(), h.instrumentOpts)
+		w.Header().Set(xhttp.HeaderContentType, xhttp.ContentTypeJSON)
+		w.WriteHeader(status)
+		xhttp.WriteJSONResponse(w, checkedReq.Unsupported.response(len(req.Timeseries)), logger)
+	} else {
+		w.WriteHeader(status)
+	}
+	if h.monotonicTimestamps != nil {
+		h.monotonicTimestamps.record(req)
+	}
+	h.metrics.forRequest(r).writeSuccess[writeOptionsPath(opts)].Inc(1)
+}
+
+// detectCounterResets counts the counter series whose value decreased within
+// their samples in the request, which indicates the counter was reset. The
+// series are marked when written, see seriesMarkers.
+func (h *PromWriteHandler) detectCounterResets(series []prompb.TimeSeries) {
+	for _, s := range series {
+		if hasCounterReset(s) {
+			h.metrics.counterResets.Inc(1)
+		}
+	}
+}
+
+func hasCounterReset(series prompb.TimeSeries) bool {
+	if series.Type != prompb.MetricType_COUNTER {
+		return false
+	}
+	for i := 1; i < len(series.Samples); i++ {
+		if series.Samples[i].Value < series.Samples[i-1].Value {
+			return true
+		}
+	}
+	return false
+}
+
+// promSeriesMarkers are the markers set by the handler on a series, which are
+// carried alongside the series rather than in them so that clients cannot
+// set them and they are never forwarded.
+type promSeriesMarkers struct {
+	counterReset bool
+	staleMarker  bool
+}
+
+// seriesMarkers returns the markers of each series about to be written, or
+// nil if no series is marked. They are derived from the series when written
+// since the series are filtered and reordered after the request is parsed.
+func (h *PromWriteHandler) seriesMarkers(series []prompb.TimeSeries) []promSeriesMarkers {
+	var (
+		counterResets = h.handlerOpts.CounterResetDetection
+		staleMarkers  = h.handlerOpts.StaleMarkers == handleroptions.PromWriteHandlerStaleMarkersModeAnnotate
+	)
+	if !counterResets && !staleMarkers {
+		return nil
+	}
+
+	var markers []promSeriesMarkers
+	for i, s := range series {
+		marker := promSeriesMarkers{
+			counterReset: counterResets && hasCounterReset(s),
+			staleMarker:  staleMarkers && onlyStaleMarkers(s.Samples),
+		}
+		if marker == (promSeriesMarkers{}) {
+			continue
+		}
+		if markers == nil {
+			markers = make([]promSeriesMarkers, len(series))
+		}
+		markers[i] = marker
+	}
+	return markers
+}
+
+// validateRequest runs the request validator if set, returning a 400 error
+// for rejections that do not carry a status code.
+func (h *PromWriteHandler) validateRequest(
+	ctx context.Context,
+	req *prompb.WriteRequest,
+) error {
+	if h.requestValidator == nil {
+		return nil
+	}
+	err := h.requestValidator.ValidateWriteRequest(ctx, req)
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(xhttp.Error); ok { //nolint:errorlint
+		return err
+	}
+	return xerrors.NewInvalidParamsError(err)
+}
+
+// forwardRequest asynchronously forwards the request to each target that
+// accepts it, or only to the targets selected by the request if any.
+// Fallback targets are only forwarded to once every primary target the
+// request was forwarded to has failed. Targets beyond the max targets per
+// request are skipped.
+func (h *PromWriteHandler) forwardRequest(r *http.Request, checkedReq parseRequestResult) {
+	var (
+		metricsType, resolved = writeOptionsMetricsType(checkedReq.Options)
+		// NB: Targets are referenced by index since serial queues are
+		// indexed by target.
+		primaries []int
+		fallbacks []int
+	)
+	for i, target := range h.forwarding.Targets {
+		if selected := checkedReq.ForwardTargets; selected != nil {
+			if !containsInt(selected, i) {
+				continue
+			}
+		} else if target.SelectedOnly {
+			continue
+		}
+		if target.MetricsType != storagemetadata.UnknownMetricsType &&
+			(!resolved || target.MetricsType != metricsType) {
+			// Target only accepts a specific metrics type.
+			h.metrics.forwardSkipped.Inc(1)
+			continue
+		}
+		if i < len(h.forwardSchedules) && h.forwardSchedules[i] != nil &&
+			!h.forwardSchedules[i].contains(h.nowFn()) {
+			h.metrics.forwardWindowSkipped.Inc(1)
+			continue
+		}
+		if target.Fallback {
+			fallbacks = append(fallbacks, i)
+		} else {
+			primaries = append(primaries, i)
+		}
+	}
+
+	if maxTargets := h.forwarding.MaxTargetsPerRequest; maxTargets > 0 {
+		if n := len(primaries) - maxTargets; n > 0 {
+			h.metrics.forwardCapped.Inc(int64(n))
+			primaries = primaries[:maxTargets]
+		}
+		if n := len(primaries) + len(fallbacks) - maxTargets; n > 0 {
+			h.metrics.forwardCapped.Inc(int64(n))
+			fallbacks = fallbacks[:len(fallbacks)-n]
+		}
+	}
+
+	if len(fallbacks) > 0 && len(primaries) == 0 {
+		// No primary was forwarded to so none could have failed.
+		h.metrics.forwardFallbackSkipped.Inc(int64(len(fallbacks)))
+	}
+
+	var onPrimaryDone func(err error)
+	if len(fallbacks) > 0 && len(primaries) > 0 {
+		var (
+			remaining = int64(len(primaries))
+			succeeded int32
+		)
+		onPrimaryDone = func(err error) {
+			if err == nil {
+				atomic.StoreInt32(&succeeded, 1)
+			}
+			if atomic.AddInt64(&remaining, -1) > 0 {
+				return
+			}
+			if atomic.LoadInt32(&succeeded) == 1 {
+				h.metrics.forwardFallbackSkipped.Inc(int64(len(fallbacks)))
+				return
+			}
+			for _, targetIdx := range fallbacks {
+				h.metrics.forwardFallback.Inc(1)
+				h.spawnForward(r, checkedReq, targetIdx, nil)
+			}
+		}
+	}
+
+	for _, targetIdx := range primaries {
+		h.spawnForward(r, checkedReq, targetIdx, onPrimaryDone)
+	}
+}
+
+// spawnForward forwards the request to the target in the background, calling
+// onDone if set with the result of the forward. Forwards to serial targets
+// are queued to the target's dedicated goroutine.
+func (h *PromWriteHandler) spawnForward(
+	r *http.Request,
+	checkedReq parseRequestResult,
+	targetIdx int,
+	onDone func(err error),
+) {
+	target := h.forwarding.Targets[targetIdx]
+
+	var delay time.Duration
+	if limiter := h.forwardRateLimiters.get(targetIdx); limiter != nil {
+		var ok bool
+		delay, ok = limiter.reserve()
+		if !ok {
+			h.metrics.forwardRateLimited.Inc(1)
+			if onDone != nil {
+				onDone(errForwardRateLimited)
+			}
+			return
+		}
+	}
+
+	forward := func() {
+		h.addActiveForwards(1)
+		defer h.addActiveForwards(-1)
+
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-h.forwardContext.Done():
+			}
+		}
+
+		now := h.nowFn()
+
+		timeout := h.forwardTimeout
+		if target.Timeout > 0 {
+			timeout = target.Timeout
+		}
+
+		var (
+			attempt = func() error {
+				// Consider propagating baggage without tying
+				// context to request context in future.
+				ctx, cancel := context.WithTimeout(h.forwardContext, timeout)
+				defer cancel()
+				return h.forward(ctx, checkedReq, r.Header, target,
+					h.forwardShards.get(targetIdx))
+			}
+			err error
+		)
+		if target.NoRetry {
+			err = attempt()
+		} else {
+			err = h.forwardRetrierFor(targetIdx).Attempt(attempt)
+		}
+
+		// Record forward ingestion delay.
+		// NB: this includes any time for retries.
+		for _, series := range checkedReq.Request.Timeseries {
+			for _, sample := range series.Samples {
+				age := now.Sub(storage.PromTimestampToTime(sample.Timestamp))
+				h.recordForwardLatency(age)
+			}
+		}
+
+		switch {
+		case err == nil:
+			h.metrics.forwardSuccess.Inc(1)
+		case isForwardBuildError(err):
+			h.metrics.forwardBuildErrors.Inc(1)
+			logger := logging.WithContext(r.Context(), h.instrumentOpts)
+			logger.Error("forward build error",
+				zap.String("target", target.URL), zap.Error(err))
+		default:
+			h.metrics.forwardErrors.Inc(1)
+			logger := logging.WithContext(r.Context(), h.instrumentOpts)
+			logger.Error("forward error", zap.Error(err))
+		}
+
+		if onDone != nil {
+			onDone(err)
+		}
+	}
+
+	spawned := false
+	switch serial := h.forwardSerialQueues.get(targetIdx); {
+	case serial != nil:
+		spawned = serial.enqueue(forward)
+	case h.forwarding.MaxConcurrency > 0:
+		spawned = h.forwardingBoundWorkers.GoIfAvailable(forward)
+	default:
+		go forward()
+		spawned = true
+	}
+	if !spawned {
+		h.metrics.forwardDropped.Inc(1)
+		if onDone != nil {
+			onDone(errForwardDropped)
+		}
+	}
+}
+
+// recordForwardLatency records the forward latency clamped to a plausible
+// range, since clock skew between regions can otherwise produce negative
+// or absurd latencies.
+func (h *PromWriteHandler) recordForwardLatency(latency time.Duration) {
+	switch maxLatency := h.forwarding.MaxLatency; {
+	case latency < 0:
+		latency = 0
+		h.metrics.forwardLatencyClamped.Inc(1)
+	case maxLatency > 0 && latency > maxLatency:
+		latency = maxLatency
+		h.metrics.forwardLatencyClamped.Inc(1)
+	}
+	h.metrics.forwardLatency.RecordDuration(latency)
+}
+
+// PromWritePartialSuccessResponse is the response returned when a write
+// partially succeeds.
+type PromWritePartialSuccessResponse struct {
+	Status                   string `json:"status"`
+	NumSeries                int    `json:"numSeries"`
+	NumResourceExhausted     int    `json:"numResourceExhausted"`
+	LastResourceExhaustedErr string `json:"lastResourceExhaustedErr"`
+}
+
+// isResourceExhaustedPartialSuccess returns true if every error is a resource
+// exhausted error and they amount to less than the tolerated fraction of the
+// series in the request.
+func (h *PromWriteHandler) isResourceExhaustedPartialSuccess(
+	numResourceExhausted int,
+	numErrors int,
+	numSeries int,
+) bool {
+	opts := h.handlerOpts.ResourceExhaustedPartialSuccess
+	if opts == nil || numResourceExhausted == 0 || numResourceExhausted != numErrors {
+		return false
+	}
+	return float64(numResourceExhausted) < opts.MaxFraction*float64(numSeries)
+}
+
+// writeOptionsMetricsType resolves the metrics type a write will be made
+// with, returning false if the write follows the server rules instead.
+func writeOptionsMetricsType(
+	opts ingest.WriteOptions,
+) (storagemetadata.MetricsType, bool) {
+	switch {
+	case opts.WriteOverride:
+		return storagemetadata.AggregatedMetricsType, true
+	case opts.DownsampleOverride:
+		return storagemetadata.UnaggregatedMetricsType, true
+	default:
+		return storagemetadata.UnknownMetricsType, false
+	}
+}
+
+// withRequestID returns the request with a context carrying the request ID,
+// taken from the request header if set or otherwise generated if the context
+// does not already carry an ID.
+func (h *PromWriteHandler) withRequestID(r *http.Request) *http.Request {
+	id := strings.TrimSpace(r.Header.Get(headers.RequestIDHeader))
+	if id != "" && len(id) <= maxRequestIDLength {
+		return r.WithContext(logging.NewContextWithID(r.Context(), id, h.instrumentOpts))
+	}
+	if logging.HasContextID(r.Context()) {
+		return r
+	}
+	return r.WithContext(logging.NewContextWithGeneratedID(r.Context(), h.instrumentOpts))
+}
+
+// promWriteStatusCodes is the HTTP status codes responded with for each
+// class of write error.
+type promWriteStatusCodes struct {
+	badRequest        int
+	resourceExhausted int
+	indexFull         int
+	retryable         int
+}
+
+func newPromWriteStatusCodes(
+	opts *handleroptions.PromWriteHandlerStatusCodeOptions,
+) (promWriteStatusCodes, error) {
+	codes := promWriteStatusCodes{
+		badRequest:        http.StatusBadRequest,
+		resourceExhausted: http.StatusTooManyRequests,
+		indexFull:         http.StatusInsufficientStorage,
+		retryable:         http.StatusInternalServerError,
+	}
+	if opts == nil {
+		return codes, nil
+	}
+
+	for _, override := range []struct {
+		class  string
+		code   int
+		status *int
+	}{
+		{class: "bad request", code: opts.BadRequest, status: &codes.badRequest},
+		{class: "resource exhausted", code: opts.ResourceExhausted, status: &codes.resourceExhausted},
+		{class: "index full", code: opts.IndexFull, status: &codes.indexFull},
+		{class: "retryable", code: opts.Retryable, status: &codes.retryable},
+	} {
+		if override.code == 0 {
+			continue
+		}
+		if override.code < 400 || override.code > 599 {
+			return promWriteStatusCodes{}, fmt.Errorf("%s status code must be "+
+				"an error status code: %d", override.class, override.code)
+		}
+		*override.status = override.code
+	}
+	return codes, nil
+}
+
+type parseRequestResult struct {
+	Request        *prompb.WriteRequest
+	Options        ingest.WriteOptions
+	CompressResult prometheus.ParsePromCompressedRequestResult
+	Drops          promWriteDropCounts
+	Unsupported    promWriteUnsupportedSeries
+	Metadata       []options.PromWriteMetricMetadata
+	// ForwardTargets are the indexes of the forwarding targets selected by
+	// the request, nil unless the request selects targets.
+	ForwardTargets []int
+	// InFlightBytes are the bytes of the in-flight budget reserved for the
+	// request, which must be released once it is responded to.
+	InFlightBytes int64
+	// DebugText is whether the request asks for its series rendered in the
+	// text exposition format rather than written.
+	DebugText bool
+}
+
+func (h *PromWriteHandler) checkedParseRequest(
+	r *http.Request,
+) (parseRequestResult, error) {
+	result, err := h.parseRequest(r)
+	if err != nil {
+		if _, ok := errorCode(err); !ok {
+			err = withErrorCode(err, PromWriteErrorCodeInvalidRequest)
+		}
+		if _, ok := err.(xhttp.Error); ok { //nolint:errorlint
+			// Keep the status of errors that explicitly set one.
+			return parseRequestResult{}, err
+		}
+		// Otherwise always invalid request if parsing fails params.
+		return parseRequestResult{}, xerrors.NewInvalidParamsError(err)
+	}
+	return result, nil
+}
+
+// parseRequest extracts the Prometheus write request from the request body and
+// headers. WARNING: unless label normalization is enabled it is not guaranteed
+// that the tags returned in the request body are in sorted order. It is
+// expected that the caller ensures the tags are sorted before passing them to
+// storage, which currently happens in write() -> newTSPromIter() ->
+// storage.PromLabelsToM3Tags() -> tags.AddTags(). This is the only path written
+// metrics are processed, but future write paths must uphold the same
+// guarantees.
+func (h *PromWriteHandler) parseRequest(
+	r *http.Request,
+) (_ parseRequestResult, retErr error) {
+	if h.handlerOpts.StrictM3Headers {
+		if err := checkKnownM3Headers(r.Header); err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeUnknownHeader)
+		}
+	}
+
+	if h.remoteWriteVersions != nil {
+		if err := h.checkRemoteWriteVersion(r.Header); err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeUnsupportedVersion)
+		}
+	}
+
+	if err := checkHeaderConflicts(r.Header); err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeHeaderConflict)
+	}
+
+	var opts ingest.WriteOptions
+	if v := strings.TrimSpace(r.Header.Get(headers.MetricsTypeHeader)); v != "" {
+		// Allow the metrics type and storage policies to override
+		// the default rules and policies if specified.
+		metricsType, err := storagemetadata.ParseMetricsType(v)
+		if err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidHeader)
+		}
+
+		// Ensure ingest options specify we are overriding the
+		// downsampling rules with zero rules to be applied (so
+		// only direct writes will be made).
+		opts.DownsampleOverride = true
+		opts.DownsampleMappingRules = nil
+
+		strPolicy := strings.TrimSpace(r.Header.Get(headers.MetricsStoragePolicyHeader))
+		switch metricsType {
+		case storagemetadata.UnaggregatedMetricsType:
+			if strPolicy != emptyStoragePolicyVar {
+				return parseRequestResult{}, withErrorCode(errUnaggregatedStoragePolicySet,
+					PromWriteErrorCodeInvalidHeader)
+			}
+		default:
+			parsed, err := policy.ParseStoragePolicy(strPolicy)
+			if err != nil && h.handlerOpts.InvalidStoragePolicy ==
+				handleroptions.PromWriteHandlerInvalidStoragePolicyModeFallback {
+				// Write with the default rules as if no override was set.
+				h.metrics.storagePolicyFallback.Inc(1)
+				h.maybeLogInvalidStoragePolicy(r, strPolicy, err)
+				opts = ingest.WriteOptions{}
+				break
+			}
+			if err != nil {
+				err = fmt.Errorf("could not parse storage policy: %v", err)
+				return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidHeader)
+			}
+
+			minResolution := h.handlerOpts.MinStoragePolicyResolution
+			if resolution := parsed.Resolution().Window; resolution < minResolution {
+				err := fmt.Errorf("storage policy %s resolution %s is finer than "+
+					"the min allowed resolution %s", parsed, resolution, minResolution)
+				return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidHeader)
+			}
+
+			// Make sure this specific storage policy is used for the writes.
+			opts.WriteOverride = true
+			opts.WriteStoragePolicies = policy.StoragePolicies{
+				parsed,
+			}
+		}
+	}
+	if v := strings.TrimSpace(r.Header.Get(headers.WriteTypeHeader)); v != "" {
+		switch v {
+		case headers.DefaultWriteType:
+		case headers.AggregateWriteType:
+			opts.WriteOverride = true
+			opts.WriteStoragePolicies = policy.StoragePolicies{}
 		default:
 			err := fmt.Errorf("unrecognized write type: %s", v)
-			return parseRequestResult{}, err
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidHeader)
+		}
+	}
+
+	forwardTargets, err := h.forwardSelectable.parse(r.Header)
+	if err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidHeader)
+	}
+
+	debugText, err := debugTextExposition(r)
+	if err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidHeader)
+	}
+
+	parseOpts := prometheus.ParsePromCompressedRequestOptions{
+		MaxDecompressionRatio: h.handlerOpts.MaxDecompressionRatio,
+	}
+	var inFlightBytes int64
+	if h.inFlightBytes != nil {
+		// NB: The budget is reserved by the decompressed size before the
+		// body is decompressed, so that requests over the budget never
+		// allocate it.
+		parseOpts.Reserve = func(decodedLen int) error {
+			if err := h.inFlightBytes.acquire(int64(decodedLen)); err != nil {
+				return err
+			}
+			inFlightBytes = int64(decodedLen)
+			return nil
+		}
+		defer func() {
+			if retErr != nil && inFlightBytes > 0 {
+				h.inFlightBytes.release(inFlightBytes)
+			}
+		}()
+	}
+
+	result, err := prometheus.ParsePromCompressedRequestWithOptions(r, parseOpts)
+	if xerrors.Is(err, prometheus.ErrTruncatedBody) {
+		h.metrics.truncatedBodies.Inc(1)
+		if h.handlerOpts.TruncatedBody == handleroptions.PromWriteHandlerTruncatedBodyModeRetry {
+			err = xhttp.NewError(err, http.StatusServiceUnavailable)
+		}
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeTruncatedBody)
+	}
+	if err != nil {
+		if _, ok := errorCode(err); !ok {
+			err = withErrorCode(err, PromWriteErrorCodeInvalidBody)
+		}
+		return parseRequestResult{}, err
+	}
+
+	body := result.UncompressedBody
+	if h.handlerOpts.Exemplars {
+		body, err = remapPromExemplars(body)
+		if err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidBody)
+		}
+	}
+
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidBody)
+	}
+
+	metadata, err := decodePromMetadata(body)
+	if err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidBody)
+	}
+
+	var drops promWriteDropCounts
+	if mapStr := r.Header.Get(headers.MapTagsByJSONHeader); mapStr != "" {
+		var opts handleroptions.MapTagsOptions
+		if err := json.Unmarshal([]byte(mapStr), &opts); err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidHeader)
+		}
+
+		named := h.namedSeries(req.Timeseries)
+		if err := mapTags(&req, opts); err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidHeader)
+		}
+		numDropped, err := h.checkRelabeledNames(&req, named, "tag mapping")
+		if err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeMissingMetricName)
+		}
+		drops.noName += numDropped
+	}
+
+	if h.packedHistograms != nil {
+		if err := h.packedHistograms.expand(&req); err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeMalformedSeries)
+		}
+	}
+
+	if promType := r.Header.Get(headers.PromTypeHeader); promType != "" {
+		tp, ok := headerToMetricType[strings.ToLower(promType)]
+		if !ok {
+			err := fmt.Errorf("unknown prom metric type %s", promType)
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidHeader)
+		}
+		for i := range req.Timeseries {
+			req.Timeseries[i].Type = tp
+		}
+	}
+
+	if err := setPromTypesByName(r, req.Timeseries); err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidHeader)
+	}
+
+	if unit := strings.TrimSpace(r.Header.Get(headers.PromUnitHeader)); unit != "" {
+		for i := range req.Timeseries {
+			req.Timeseries[i].Unit = unit
+		}
+	}
+
+	if h.handlerOpts.CounterResetDetection && !debugText {
+		h.detectCounterResets(req.Timeseries)
+	}
+
+	if len(h.labelSplits) > 0 {
+		named := h.namedSeries(req.Timeseries)
+		if err := h.splitLabels(req.Timeseries); err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeDuplicateLabelName)
+		}
+		numDropped, err := h.checkRelabeledNames(&req, named, "label split")
+		if err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeMissingMetricName)
+		}
+		drops.noName += numDropped
+	}
+
+	if h.utf8Validator != nil {
+		if err := h.utf8Validator.validate(req.Timeseries); err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidUTF8)
+		}
+	}
+
+	if h.labelTrimmer != nil {
+		if err := h.labelTrimmer.trim(req.Timeseries); err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeDuplicateLabelName)
+		}
+	}
+
+	if h.labelNameValidator != nil {
+		if err := h.labelNameValidator.validate(req.Timeseries); err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInvalidLabelName)
+		}
+	}
+
+	if err := h.checkDuplicateLabelNames(req.Timeseries); err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeDuplicateLabelName)
+	}
+
+	if v := h.handlerOpts.LabelNormalization; v != nil {
+		drops.duplicateLabels = h.normalizeLabels(req.Timeseries, *v)
+	}
+
+	if h.seriesMerger != nil {
+		h.seriesMerger.merge(&req)
+	}
+
+	if h.seriesDenylist != nil {
+		drops.denied, err = h.seriesDenylist.apply(&req)
+		if err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeSeriesDenied)
+		}
+	}
+
+	logger := logging.WithContext(r.Context(), h.instrumentOpts)
+	if err := h.checkTimestampFloor(logger, req.Timeseries); err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeTimestampTooOld)
+	}
+
+	h.handleStaleMarkers(&req)
+
+	drops.malformed, err = h.checkMalformedSeries(&req)
+	if err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeMalformedSeries)
+	}
+
+	if h.histogramValidator != nil {
+		if err := h.histogramValidator.validate(req.Timeseries); err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeInconsistentHistogram)
+		}
+	}
+
+	unsupported, err := h.checkUnsupportedSeries(&req)
+	if err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeUnsupportedSeries)
+	}
+
+	if h.monotonicTimestamps != nil {
+		if err := h.monotonicTimestamps.check(&req); err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeNonMonotonicTimestamp)
+		}
+	}
+
+	numNoName, err := h.checkMetricName(&req)
+	if err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeMissingMetricName)
+	}
+	drops.noName += numNoName
+
+	drops.truncated, err = h.checkMaxSamplesPerRequest(&req)
+	if err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeTooManySamples)
+	}
+
+	// Check if any of the labels exceed literal length limits and occasionally print them
+	// in a log message for debugging purposes.
+	if err := h.checkLabelLiteralLengths(logger, req.Timeseries); err != nil {
+		return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeLabelTooLong)
+	}
+
+	// NB: Debug requests are not written, so they are not observed.
+	if h.labelCardinality != nil && !debugText {
+		if err := h.labelCardinality.observe(r.Context(), req.Timeseries); err != nil {
+			return parseRequestResult{}, withErrorCode(err, PromWriteErrorCodeCardinalityLimitExceeded)
+		}
+	}
+
+	if h.labelCounts != nil && !debugText {
+		h.labelCounts.record(req.Timeseries)
+	}
+
+	return parseRequestResult{
+		Request:        &req,
+		Options:        opts,
+		CompressResult: result,
+		Drops:          drops,
+		ForwardTargets: forwardTargets,
+		Unsupported:    unsupported,
+		Metadata:       metadata,
+		InFlightBytes:  inFlightBytes,
+		DebugText:      debugText,
+	}, nil
+}
+
+// checkLabelLiteralLengths verifies no label name or value exceeds its
+// length limit, which both default to the max tag literal length.
+func (h *PromWriteHandler) checkLabelLiteralLengths(
+	logger *zap.Logger,
+	series []prompb.TimeSeries,
+) error {
+	var (
+		maxTagLiteralLength = int(h.tagOptions.MaxTagLiteralLength())
+		maxNameLength       = h.handlerOpts.MaxLabelNameLength
+		maxValueLength      = h.handlerOpts.MaxLabelValueLength
+	)
+	if maxNameLength <= 0 && maxValueLength <= 0 {
+		for _, ts := range series {
+			for _, l := range ts.Labels {
+				if len(l.Name) > maxTagLiteralLength || len(l.Value) > maxTagLiteralLength {
+					h.maybeLogLabelsWithTooLongLiterals(logger, l)
+					return fmt.Errorf("label literal is too long: nameLength=%d, valueLength=%d, maxLength=%d",
+						len(l.Name), len(l.Value), maxTagLiteralLength)
+				}
+			}
+		}
+		return nil
+	}
+
+	if maxNameLength <= 0 {
+		maxNameLength = maxTagLiteralLength
+	}
+	if maxValueLength <= 0 {
+		maxValueLength = maxTagLiteralLength
+	}
+	for _, ts := range series {
+		for _, l := range ts.Labels {
+			if len(l.Name) > maxNameLength {
+				h.maybeLogLabelsWithTooLongLiterals(logger, l)
+				return fmt.Errorf("label name is too long: nameLength=%d, maxNameLength=%d",
+					len(l.Name), maxNameLength)
+			}
+			if len(l.Value) > maxValueLength {
+				h.maybeLogLabelsWithTooLongLiterals(logger, l)
+				return fmt.Errorf("label value is too long: valueLength=%d, maxValueLength=%d",
+					len(l.Value), maxValueLength)
+			}
+		}
+	}
+	return nil
+}
+
+// checkKnownM3Headers returns an error if any M3 header is not recognized.
+func checkKnownM3Headers(header http.Header) error {
+	for name := range header {
+		if !strings.HasPrefix(name, headers.M3HeaderPrefix) {
+			continue
+		}
+		if _, ok := knownM3Headers[http.CanonicalHeaderKey(name)]; !ok {
+			return fmt.Errorf("unrecognized M3 header: %s", name)
+		}
+	}
+	return nil
+}
+
+// checkContentType returns an unsupported media type error unless the
+// request declares a protobuf body, ignoring any media type parameters.
+func checkContentType(header http.Header) error {
+	v := header.Get(xhttp.HeaderContentType)
+	if v == "" {
+		return xhttp.NewError(fmt.Errorf("missing required header: %s",
+			xhttp.HeaderContentType), http.StatusUnsupportedMediaType)
+	}
+	mediaType, _, err := mime.ParseMediaType(v)
+	if err != nil || mediaType != xhttp.ContentTypeProtobuf {
+		return xhttp.NewError(fmt.Errorf("unsupported content type: %s", v),
+			http.StatusUnsupportedMediaType)
+	}
+	return nil
+}
+
+// checkRemoteWriteVersion verifies the request declares an accepted remote
+// write protocol version.
+func (h *PromWriteHandler) checkRemoteWriteVersion(header http.Header) error {
+	version := strings.TrimSpace(header.Get(headers.PromRemoteWriteVersionHeader))
+	if version == "" {
+		return fmt.Errorf("missing required header: %s",
+			headers.PromRemoteWriteVersionHeader)
+	}
+	if len(h.remoteWriteVersions) == 0 {
+		return nil
+	}
+	if _, ok := h.remoteWriteVersions[version]; !ok {
+		return fmt.Errorf("unsupported remote write version: %s", version)
+	}
+	return nil
+}
+
+// checkTimestampFloor verifies that sample timestamps are plausibly in
+// milliseconds, since the iterator assumes millisecond precision and clients
+// occasionally send Unix seconds which land the samples in 1970.
+func (h *PromWriteHandler) checkTimestampFloor(
+	logger *zap.Logger,
+	series []prompb.TimeSeries,
+) error {
+	floorOpts := h.handlerOpts.TimestampFloor
+	if floorOpts == nil {
+		return nil
+	}
+
+	floor := floorOpts.MinTimestampMillis
+	if floor <= 0 {
+		floor = defaultMinTimestampMillis
+	}
+
+	for _, ts := range series {
+		for _, sample := range ts.Samples {
+			if sample.Timestamp >= floor {
+				continue
+			}
+
+			if floorOpts.Mode == handleroptions.PromWriteHandlerTimestampFloorModeWarn {
+				// Only warn once per request.
+				h.maybeLogTimestampBelowFloor(logger, sample.Timestamp, floor)
+				return nil
+			}
+
+			return fmt.Errorf("sample timestamp below floor, likely seconds "+
+				"instead of milliseconds: timestamp=%d, floor=%d", sample.Timestamp, floor)
+		}
+	}
+
+	return nil
+}
+
+// checkMetricName verifies every series carries a metric name label, since a
+// missing one usually indicates corruption or a misbehaving client, either
+// rejecting the request or dropping the series. Graphite series are exempt
+// as they do not carry a metric name label. Returns the number of series
+// dropped.
+func (h *PromWriteHandler) checkMetricName(req *prompb.WriteRequest) (int, error) {
+	mode := h.handlerOpts.MissingName
+	if mode == "" {
+		return 0, nil
+	}
+
+	var (
+		kept       = req.Timeseries[:0]
+		numDropped int
+	)
+	for _, ts := range req.Timeseries {
+		if ts.Source == prompb.Source_GRAPHITE || hasLabel(ts.Labels, promMetricNameLabel) {
+			kept = append(kept, ts)
+			continue
+		}
+
+		if mode != handleroptions.PromWriteHandlerMissingNameModeDrop {
+			h.metrics.seriesDroppedNoName.Inc(1)
+			return 0, fmt.Errorf("series has no metric name label: name=%s",
+				promMetricNameLabel)
+		}
+		numDropped++
+	}
+
+	if numDropped > 0 {
+		h.metrics.seriesDroppedNoName.Inc(int64(numDropped))
+		req.Timeseries = kept
+	}
+	return numDropped, nil
+}
+
+// checkMalformedSeries verifies the integrity of every series, which partial
+// or corrupt payloads can break while still decoding, either rejecting the
+// request or dropping the series. Returns the number of series dropped.
+func (h *PromWriteHandler) checkMalformedSeries(req *prompb.WriteRequest) (int, error) {
+	mode := h.handlerOpts.MalformedSeries
+	if mode == "" {
+		return 0, nil
+	}
+
+	var (
+		kept       = req.Timeseries[:0]
+		numDropped int
+	)
+	for _, ts := range req.Timeseries {
+		err := malformedSeriesError(ts, h.handlerOpts.StrictSampleOrder)
+		if err == nil {
+			kept = append(kept, ts)
+			continue
+		}
+
+		if mode != handleroptions.PromWriteHandlerMalformedSeriesModeDrop {
+			h.metrics.seriesDroppedMalformed.Inc(1)
+			return 0, err
+		}
+		numDropped++
+	}
+
+	if numDropped > 0 {
+		h.metrics.seriesDroppedMalformed.Inc(int64(numDropped))
+		req.Timeseries = kept
+	}
+	return numDropped, nil
+}
+
+// malformedSeriesError returns an error describing why the series is
+// malformed, or nil if it is not. Series without samples or with samples out
+// of order are valid remote write input, so the sample order is only checked
+// if strict.
+func malformedSeriesError(ts prompb.TimeSeries, strictOrder bool) error {
+	if len(ts.Labels) == 0 {
+		return errors.New("malformed series: no labels")
+	}
+	if name, ok := duplicateLabelName(ts.Labels); ok {
+		return fmt.Errorf("malformed series: duplicate label name: name=%s", name)
+	}
+	if !strictOrder {
+		return nil
+	}
+	for i := 1; i < len(ts.Samples); i++ {
+		if ts.Samples[i].Timestamp <= ts.Samples[i-1].Timestamp {
+			return fmt.Errorf("malformed series: sample timestamps not increasing: "+
+				"index=%d, timestamp=%d, previous=%d", i, ts.Samples[i].Timestamp,
+				ts.Samples[i-1].Timestamp)
+		}
+	}
+	return nil
+}
+
+// setPromTypesByName sets the type of each series whose metric name is
+// present in the per-name types header, overriding any request-wide type.
+func setPromTypesByName(r *http.Request, series []prompb.TimeSeries) error {
+	str := r.Header.Get(headers.PromTypesHeader)
+	if str == "" {
+		return nil
+	}
+
+	var typesByName map[string]string
+	if err := json.Unmarshal([]byte(str), &typesByName); err != nil {
+		return fmt.Errorf("invalid %s header: %w", headers.PromTypesHeader, err)
+	}
+
+	types := make(map[string]prompb.MetricType, len(typesByName))
+	for name, promType := range typesByName {
+		tp, ok := headerToMetricType[strings.ToLower(promType)]
+		if !ok {
+			return fmt.Errorf("unknown prom metric type %s for metric %s",
+				promType, name)
+		}
+		types[name] = tp
+	}
+
+	for i := range series {
+		for _, l := range series[i].Labels {
+			if !bytes.Equal(l.Name, promMetricNameLabel) {
+				continue
+			}
+			if tp, ok := types[string(l.Value)]; ok {
+				series[i].Type = tp
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+func hasLabel(labels []prompb.Label, name []byte) bool {
+	for _, l := range labels {
+		if bytes.Equal(l.Name, name) && len(l.Value) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMaxSamplesPerRequest enforces the max samples summed across all series
+// of the request, either rejecting the request or truncating the tail series.
+// Returns the number of series truncated.
+func (h *PromWriteHandler) checkMaxSamplesPerRequest(req *prompb.WriteRequest) (int, error) {
+	limitOpts := h.handlerOpts.MaxSamplesPerRequest
+	if limitOpts == nil || limitOpts.Limit <= 0 {
+		return 0, nil
+	}
+
+	numSamples := 0
+	for i, ts := range req.Timeseries {
+		numSamples += len(ts.Samples)
+		if numSamples <= limitOpts.Limit {
+			continue
+		}
+
+		if limitOpts.Mode == handleroptions.PromWriteHandlerMaxSamplesModeTruncate {
+			// Keep only the series that fit entirely within the limit, unless
+			// none of the samples do in which case nothing would be written.
+			if numSamples-len(ts.Samples) == 0 {
+				return 0, fmt.Errorf("too many samples in request, no series "+
+					"fits within the limit: limit=%d, samples=%d",
+					limitOpts.Limit, len(ts.Samples))
+			}
+			numTruncated := len(req.Timeseries) - i
+			h.metrics.writeTruncatedSeries.Inc(int64(numTruncated))
+			req.Timeseries = req.Timeseries[:i]
+			return numTruncated, nil
 		}
+
+		return 0, fmt.Errorf("too many samples in request: limit=%d", limitOpts.Limit)
 	}
 
-	result, err := prometheus.ParsePromCompressedRequest(r)
-	if err != nil {
-		return parseRequestResult{}, err
+	return 0, nil
+}
+
+func (h *PromWriteHandler) addActiveForwards(delta int) {
+	h.numActiveForwardsLock.Lock()
+	h.numActiveForwards += delta
+	h.metrics.forwardActive.Update(float64(h.numActiveForwards))
+	h.numActiveForwardsLock.Unlock()
+}
+
+func (h *PromWriteHandler) write(
+	ctx context.Context,
+	r *prompb.WriteRequest,
+	opts ingest.WriteOptions,
+) ingest.BatchError {
+	if len(h.writePools) > 0 {
+		return h.writeWithPools(ctx, r, opts)
 	}
+	return h.writeSeries(ctx, r.Timeseries, opts)
+}
 
-	var req prompb.WriteRequest
-	if err := proto.Unmarshal(result.UncompressedBody, &req); err != nil {
-		return parseRequestResult{}, err
+// writeWithRetry writes the request, retrying the whole batch with the write
+// retrier while every error is transient and the request is not cancelled.
+func (h *PromWriteHandler) writeWithRetry(
+	ctx context.Context,
+	r *prompb.WriteRequest,
+	opts ingest.WriteOptions,
+) ingest.BatchError {
+	if h.writeRetrier == nil {
+		return h.write(ctx, r, opts)
 	}
 
-	if mapStr := r.Header.Get(headers.MapTagsByJSONHeader); mapStr != "" {
-		var opts handleroptions.MapTagsOptions
-		if err := json.Unmarshal([]byte(mapStr), &opts); err != nil {
-			return parseRequestResult{}, err
+	var batchErr ingest.BatchError
+	continueFn := func(int) bool {
+		return ctx.Err() == nil
+	}
+	_ = h.writeRetrier.AttemptWhile(continueFn, func() error {
+		batchErr = h.write(ctx, r, opts)
+		if batchErr == nil {
+			return nil
 		}
+		if !isTransientBatchError(batchErr) {
+			return xerrors.NewNonRetryableError(batchErr)
+		}
+		return batchErr
+	})
+	return batchErr
+}
 
-		if err := mapTags(&req, opts); err != nil {
-			return parseRequestResult{}, err
+// isTransientBatchError returns whether none of the batch errors are bad
+// request or resource exhausted errors, which retrying would not resolve.
+func isTransientBatchError(batchErr ingest.BatchError) bool {
+	for _, err := range batchErr.Errors() {
+		if client.IsResourceExhaustedError(err) ||
+			client.IsBadRequestError(err) ||
+			xerrors.IsInvalidParams(err) {
+			return false
 		}
 	}
+	return true
+}
+
+func (h *PromWriteHandler) writeSeries(
+	ctx context.Context,
+	series []prompb.TimeSeries,
+	opts ingest.WriteOptions,
+) ingest.BatchError {
+	markers := h.seriesMarkers(series)
+	if h.secondaryWriter == nil {
+		return h.writeSeriesTo(ctx, h.downsamplerAndWriter, series, markers, opts)
+	}
 
-	if promType := r.Header.Get(headers.PromTypeHeader); promType != "" {
-		tp, ok := headerToMetricType[strings.ToLower(promType)]
-		if !ok {
-			return parseRequestResult{}, fmt.Errorf("unknown prom metric type %s", promType)
-		}
-		for i := range req.Timeseries {
-			req.Timeseries[i].Type = tp
-		}
+	// Write to the secondary concurrently with the primary so that the hot
+	// standby does not add to the write latency.
+	var (
+		wg           sync.WaitGroup
+		secondaryErr ingest.BatchError
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		secondaryErr = h.writeSeriesTo(ctx, h.secondaryWriter, series, markers, opts)
+	}()
+	primaryErr := h.writeSeriesTo(ctx, h.downsamplerAndWriter, series, markers, opts)
+	wg.Wait()
+
+	if secondaryErr == nil {
+		h.metrics.secondaryWriteSuccess.Inc(1)
+		return primaryErr
+	}
+	h.metrics.secondaryWriteErrors.Inc(1)
+	if h.handlerOpts.SecondaryWrite.NonFatal {
+		return primaryErr
 	}
 
-	// Check if any of the labels exceed literal length limits and occasionally print them
-	// in a log message for debugging purposes.
-	maxTagLiteralLength := int(h.tagOptions.MaxTagLiteralLength())
-	for _, ts := range req.Timeseries {
-		for _, l := range ts.Labels {
-			if len(l.Name) > maxTagLiteralLength || len(l.Value) > maxTagLiteralLength {
-				h.maybeLogLabelsWithTooLongLiterals(h.instrumentOpts.Logger(), l)
-				err := fmt.Errorf("label literal is too long: nameLength=%d, valueLength=%d, maxLength=%d",
-					len(l.Name), len(l.Value), maxTagLiteralLength)
-				return parseRequestResult{}, err
-			}
+	multiErr := xerrors.NewMultiError()
+	if primaryErr != nil {
+		for _, err := range primaryErr.Errors() {
+			multiErr = multiErr.Add(err)
 		}
 	}
-
-	return parseRequestResult{
-		Request:        &req,
-		Options:        opts,
-		CompressResult: result,
-	}, nil
+	for _, err := range secondaryErr.Errors() {
+		multiErr = multiErr.Add(err)
+	}
+	return multiErr
 }
 
-func (h *PromWriteHandler) write(
+func (h *PromWriteHandler) writeSeriesTo(
 	ctx context.Context,
-	r *prompb.WriteRequest,
+	writer ingest.DownsamplerAndWriter,
+	series []prompb.TimeSeries,
+	markers []promSeriesMarkers,
 	opts ingest.WriteOptions,
 ) ingest.BatchError {
-	iter, err := newPromTSIter(r.Timeseries, h.tagOptions, h.storeMetricsType)
+	// NB: Each write builds its own iterator since the writer sets the
+	// metadata of the current series on the iterator.
+	iter, err := newPromTSIter(series, markers, h.tagOptions, h.storeMetricsType,
+		h.handlerOpts.Exemplars, h.handlerOpts.LabelsHash, h.samplingLabel,
+		h.typeInference)
 	if err != nil {
 		var errs xerrors.MultiError
 		return errs.Add(err)
 	}
-	return h.downsamplerAndWriter.WriteBatch(ctx, iter, opts)
+	return writer.WriteBatch(ctx, iter, opts)
 }
 
 func (h *PromWriteHandler) forward(
@@ -554,22 +2706,96 @@ func (h *PromWriteHandler) forward(
 	res parseRequestResult,
 	header http.Header,
 	target handleroptions.PromWriteHandlerForwardTargetOptions,
+	shard *forwardShard,
 ) error {
 	body := bytes.NewReader(res.CompressResult.CompressedBody)
 	if shadowOpts := target.Shadow; shadowOpts != nil {
 		// Need to send a subset of the original series to the shadow target.
 		buffer, err := h.buildForwardShadowRequestBody(res, shadowOpts)
 		if err != nil {
-			return err
+			return newForwardBuildError(err)
 		}
 		// Read the body from the shadow request body just built.
 		body.Reset(buffer)
 	}
 
+	if shard != nil {
+		buffer, numSeries, err := h.buildForwardShardRequestBody(body, shard)
+		if err != nil {
+			return newForwardBuildError(err)
+		}
+		if numSeries == 0 {
+			// No series of the request belong to this target's shard.
+			return nil
+		}
+		body.Reset(buffer)
+	}
+
+	if n := target.SampleEvery; n > 1 {
+		buffer, err := h.buildForwardTransformRequestBody(body,
+			forwardSampleEveryTransform(n))
+		if err != nil {
+			return newForwardBuildError(err)
+		}
+		body.Reset(buffer)
+	}
+
+	if name := target.Transform; name != "" {
+		buffer, err := h.buildForwardTransformRequestBody(body,
+			h.forwardTransforms[name])
+		if err != nil {
+			return newForwardBuildError(
+				fmt.Errorf("forwarding transform %s failed: %w", name, err))
+		}
+		body.Reset(buffer)
+	}
+
+	if n := target.ChunkSeries; n > 0 {
+		chunks, err := h.buildForwardChunkRequestBodies(body, n)
+		if err != nil {
+			return newForwardBuildError(err)
+		}
+		// Send the chunks sequentially, each only once the previous chunk
+		// was acknowledged, so that a struggling target is not sent the
+		// rest of the request.
+		for i, chunk := range chunks {
+			body.Reset(chunk)
+			if err := h.forwardBody(ctx, body, header, target); err != nil {
+				return fmt.Errorf("forwarding chunk %d of %d failed: %w",
+					i+1, len(chunks), err)
+			}
+		}
+		return nil
+	}
+
+	return h.forwardBody(ctx, body, header, target)
+}
+
+// forwardBody sends the body to the target, returning an error unless the
+// target acknowledged it with a 2XX.
+func (h *PromWriteHandler) forwardBody(
+	ctx context.Context,
+	body io.Reader,
+	header http.Header,
+	target handleroptions.PromWriteHandlerForwardTargetOptions,
+) error {
 	method := target.Method
 	if method == "" {
 		method = http.MethodPost
 	}
+	var (
+		encoding string
+		err      error
+	)
+	if target.Uncompressed {
+		body, encoding, err = decompressForwardBody(body)
+	} else {
+		body, encoding, err = h.forwardCompressors.compress(body, target)
+	}
+	if err != nil {
+		return newForwardBuildError(err)
+	}
+
 	url := target.URL
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
@@ -582,12 +2808,26 @@ func (h *PromWriteHandler) forward(
 	// received the request.
 	if header != nil {
 		for h := range header {
-			if strings.HasPrefix(h, headers.M3HeaderPrefix) {
+			// NB: Targets are selected only by the receiving coordinator, the
+			// names are not meaningful to the target.
+			if strings.HasPrefix(h, headers.M3HeaderPrefix) &&
+				h != forwardTargetsHeaderKey {
 				req.Header.Add(h, header.Get(h))
 			}
 		}
 	}
 
+	for _, protocolHeader := range forwardProtocolHeaders {
+		value := header.Get(protocolHeader.name)
+		if value == "" {
+			value = protocolHeader.defaultValue
+		}
+		req.Header.Set(protocolHeader.name, value)
+	}
+	if encoding != "" {
+		req.Header.Set(contentEncodingHeader, encoding)
+	}
+
 	if targetHeaders := target.Headers; targetHeaders != nil {
 		// If headers set, attach to request.
 		for name, value := range targetHeaders {
@@ -595,6 +2835,22 @@ func (h *PromWriteHandler) forward(
 		}
 	}
 
+	for name, value := range target.OverrideHeaders {
+		if value == "" {
+			req.Header.Del(name)
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+
+	if err := h.forwardTokenSources.authorize(req, target); err != nil {
+		return fmt.Errorf("forwarding oauth2 token failed: %w", err)
+	}
+
+	if err := h.forwardSigners.sign(req, body, target, h.nowFn()); err != nil {
+		return fmt.Errorf("forwarding sigv4 signing failed: %w", err)
+	}
+
 	resp, err := h.forwardHTTPClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
@@ -667,10 +2923,147 @@ func (h *PromWriteHandler) buildForwardShadowRequestBody(
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal forwarding shadow request: %w", err)
 	}
+	encoded, err = restorePromExemplars(encoded)
+	if err != nil {
+		return nil, err
+	}
 
 	return snappy.Encode(buffer[:0], encoded), nil
 }
 
+// buildForwardTransformRequestBody decodes the body that would otherwise be
+// forwarded into a fresh request, so that the transform never modifies the
+// request being written locally, and re-encodes it once transformed.
+func (h *PromWriteHandler) buildForwardTransformRequestBody(
+	body io.Reader,
+	transform options.PromWriteForwardTransform,
+) ([]byte, error) {
+	req, err := h.decodeForwardRequestBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := transform(req); err != nil {
+		return nil, err
+	}
+
+	return encodeForwardRequestBody(req)
+}
+
+// buildForwardChunkRequestBodies splits the body that would otherwise be
+// forwarded into bodies of at most chunkSeries series each, in order.
+func (h *PromWriteHandler) buildForwardChunkRequestBodies(
+	body io.Reader,
+	chunkSeries int,
+) ([][]byte, error) {
+	req, err := h.decodeForwardRequestBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		series = req.Timeseries
+		chunks = make([][]byte, 0, (len(series)+chunkSeries-1)/chunkSeries)
+	)
+	for start := 0; start < len(series); start += chunkSeries {
+		end := start + chunkSeries
+		if end > len(series) {
+			end = len(series)
+		}
+		req.Timeseries = series[start:end]
+		chunk, err := encodeForwardRequestBody(req)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// decodeForwardRequestBody decodes the body that would otherwise be
+// forwarded into a fresh request.
+func (h *PromWriteHandler) decodeForwardRequestBody(
+	body io.Reader,
+) (*prompb.WriteRequest, error) {
+	compressed, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress forwarding request: %w", err)
+	}
+
+	if h.handlerOpts.Exemplars {
+		decoded, err = remapPromExemplars(decoded)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(decoded, &req); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal forwarding request: %w", err)
+	}
+	return &req, nil
+}
+
+func encodeForwardRequestBody(req *prompb.WriteRequest) ([]byte, error) {
+	encoded, err := proto.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal forwarding request: %w", err)
+	}
+	// NB: Exemplars are decoded from a field of their own, so they are
+	// encoded back to the field of the remote write protocol.
+	encoded, err = restorePromExemplars(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return snappy.Encode(nil, encoded), nil
+}
+
+// forwardBuildError is an error building the request body forwarded to a
+// target, as opposed to an error sending it to the target.
+type forwardBuildError struct {
+	err error
+}
+
+func (e forwardBuildError) Error() string {
+	return e.err.Error()
+}
+
+func (e forwardBuildError) Unwrap() error {
+	return e.err
+}
+
+func newForwardBuildError(err error) error {
+	// Building the body is deterministic so it is not retried.
+	return xerrors.NewNonRetryableError(forwardBuildError{err: err})
+}
+
+func isForwardBuildError(err error) bool {
+	var buildErr forwardBuildError
+	return errors.As(xerrors.GetInnerNonRetryableError(err), &buildErr)
+}
+
+// forwardSampleEveryTransform returns a transform keeping only every nth
+// sample of each series, starting with the first.
+func forwardSampleEveryTransform(n int) options.PromWriteForwardTransform {
+	return func(req *prompb.WriteRequest) error {
+		for i := range req.Timeseries {
+			samples := req.Timeseries[i].Samples
+			kept := samples[:0]
+			for j := 0; j < len(samples); j += n {
+				kept = append(kept, samples[j])
+			}
+			req.Timeseries[i].Samples = kept
+		}
+		return nil
+	}
+}
+
 // buildPseudoIDWithLabelsLikelySorted will build a pseudo ID that can be
 // hashed/etc (but not used as primary key since not escaped), it expects the
 // input labels to be likely sorted (so can avoid invoking sort in the regular
@@ -717,10 +3110,117 @@ func (h *PromWriteHandler) maybeLogLabelsWithTooLongLiterals(logger *zap.Logger,
 	)
 }
 
+func (h *PromWriteHandler) maybeLogTimestampBelowFloor(logger *zap.Logger, timestamp, floor int64) {
+	if atomic.AddUint32(&h.numTimestampBelowFloor, 1) > maxTimestampBelowFloorLogCount {
+		return
+	}
+
+	logger.Warn("sample timestamp below floor, likely seconds instead of milliseconds",
+		zap.Int64("timestamp", timestamp),
+		zap.Int64("floor", floor),
+	)
+}
+
+func (h *PromWriteHandler) maybeLogInvalidStoragePolicy(
+	r *http.Request,
+	storagePolicy string,
+	err error,
+) {
+	if atomic.AddUint32(&h.numInvalidStoragePolicy, 1) > maxInvalidStoragePolicyLogCount {
+		return
+	}
+
+	logger := logging.WithContext(r.Context(), h.instrumentOpts)
+	logger.Warn("invalid storage policy header, falling back to default rules",
+		zap.String("storagePolicy", storagePolicy),
+		zap.String("remoteAddr", r.RemoteAddr),
+		zap.Error(err),
+	)
+}
+
 func newPromTSIter(
 	timeseries []prompb.TimeSeries,
+	markers []promSeriesMarkers,
+	tagOpts models.TagOptions,
+	storeMetricsType bool,
+	storeExemplars bool,
+	storeLabelsHash bool,
+	samplingLabel []byte,
+	typeInference *promTypeInference,
+) (*promTSIter, error) {
+	if len(timeseries) == 1 {
+		var marker promSeriesMarkers
+		if len(markers) > 0 {
+			marker = markers[0]
+		}
+		return newSinglePromTSIter(timeseries[0], marker, tagOpts, storeMetricsType,
+			storeExemplars, storeLabelsHash, samplingLabel, typeInference)
+	}
+	return newMultiPromTSIter(timeseries, markers, tagOpts, storeMetricsType,
+		storeExemplars, storeLabelsHash, samplingLabel, typeInference)
+}
+
+// newSinglePromTSIter builds the iterator of a single series, as sent by
+// sidecars, with the per series slices backed by arrays allocated along with
+// the iterator.
+func newSinglePromTSIter(
+	promTS prompb.TimeSeries,
+	marker promSeriesMarkers,
+	tagOpts models.TagOptions,
+	storeMetricsType bool,
+	storeExemplars bool,
+	storeLabelsHash bool,
+	samplingLabel []byte,
+	typeInference *promTypeInference,
+) (*promTSIter, error) {
+	converter := promSeriesConverter{
+		tagOpts:         tagOpts,
+		storeExemplars:  storeExemplars,
+		storeLabelsHash: storeLabelsHash,
+		samplingLabel:   samplingLabel,
+		typeInference:   typeInference,
+	}
+	series, keep, err := converter.convert(promTS, marker)
+	if err != nil {
+		return nil, err
+	}
+
+	iter := &promTSIter{
+		idx:              -1,
+		storeMetricsType: storeMetricsType,
+	}
+	if !keep {
+		return iter, nil
+	}
+
+	iter.single.attributes[0] = series.attributes
+	iter.single.tags[0] = series.tags
+	iter.single.datapoints[0] = series.datapoints
+	iter.attributes = iter.single.attributes[:]
+	iter.tags = iter.single.tags[:]
+	iter.datapoints = iter.single.datapoints[:]
+	iter.metadatas = iter.single.metadatas[:]
+	if len(series.exemplars) > 0 {
+		iter.single.exemplars[0] = series.exemplars
+		iter.exemplars = iter.single.exemplars[:]
+	}
+	if storeLabelsHash {
+		iter.single.labelsHashes[0] = series.labelsHash
+		iter.labelsHashes = iter.single.labelsHashes[:]
+	}
+	return iter, nil
+}
+
+// newMultiPromTSIter builds the iterator of any number of series.
+func newMultiPromTSIter(
+	timeseries []prompb.TimeSeries,
+	markers []promSeriesMarkers,
 	tagOpts models.TagOptions,
 	storeMetricsType bool,
+	storeExemplars bool,
+	storeLabelsHash bool,
+	samplingLabel []byte,
+	typeInference *promTypeInference,
 ) (*promTSIter, error) {
 	// Construct the tags and datapoints upfront so that if the iterator
 	// is reset, we don't have to generate them twice.
@@ -728,24 +3228,47 @@ func newPromTSIter(
 		tags             = make([]models.Tags, 0, len(timeseries))
 		datapoints       = make([]ts.Datapoints, 0, len(timeseries))
 		seriesAttributes = make([]ts.SeriesAttributes, 0, len(timeseries))
+		exemplars        [][]*annotation.Exemplar
+		labelsHashes     []uint64
 	)
+	if storeLabelsHash {
+		labelsHashes = make([]uint64, 0, len(timeseries))
+	}
 
-	graphiteTagOpts := tagOpts.SetIDSchemeType(models.TypeGraphite)
-	for _, promTS := range timeseries {
-		attributes, err := storage.PromTimeSeriesToSeriesAttributes(promTS)
+	converter := promSeriesConverter{
+		tagOpts:         tagOpts,
+		storeExemplars:  storeExemplars,
+		storeLabelsHash: storeLabelsHash,
+		samplingLabel:   samplingLabel,
+		typeInference:   typeInference,
+	}
+	for idx, promTS := range timeseries {
+		var marker promSeriesMarkers
+		if idx < len(markers) {
+			marker = markers[idx]
+		}
+		series, keep, err := converter.convert(promTS, marker)
 		if err != nil {
 			return nil, err
 		}
+		if !keep {
+			continue
+		}
+
+		seriesAttributes = append(seriesAttributes, series.attributes)
+		tags = append(tags, series.tags)
+		datapoints = append(datapoints, series.datapoints)
 
-		// Set the tag options based on the incoming source.
-		opts := tagOpts
-		if attributes.Source == ts.SourceTypeGraphite {
-			opts = graphiteTagOpts
+		if len(series.exemplars) > 0 {
+			if exemplars == nil {
+				exemplars = make([][]*annotation.Exemplar, len(timeseries))
+			}
+			exemplars[len(tags)-1] = series.exemplars
 		}
 
-		seriesAttributes = append(seriesAttributes, attributes)
-		tags = append(tags, storage.PromLabelsToM3Tags(promTS.Labels, opts))
-		datapoints = append(datapoints, storage.PromSamplesToM3Datapoints(promTS.Samples))
+		if storeLabelsHash {
+			labelsHashes = append(labelsHashes, series.labelsHash)
+		}
 	}
 
 	return &promTSIter{
@@ -753,10 +3276,97 @@ func newPromTSIter(
 		idx:              -1,
 		tags:             tags,
 		datapoints:       datapoints,
+		exemplars:        exemplars,
+		labelsHashes:     labelsHashes,
 		storeMetricsType: storeMetricsType,
 	}, nil
 }
 
+// promSeriesConverter converts series to the values written by the iterator,
+// shared by the single and multi series iterators.
+type promSeriesConverter struct {
+	tagOpts         models.TagOptions
+	graphiteTagOpts models.TagOptions
+	storeExemplars  bool
+	storeLabelsHash bool
+	samplingLabel   []byte
+	typeInference   *promTypeInference
+	labelsBuffer    []prompb.Label
+}
+
+// promConvertedSeries is a series converted to the values written by the
+// iterator.
+type promConvertedSeries struct {
+	attributes ts.SeriesAttributes
+	tags       models.Tags
+	datapoints ts.Datapoints
+	exemplars  []*annotation.Exemplar
+	labelsHash uint64
+}
+
+// convert converts the series, returning false if it is sampled out.
+func (c *promSeriesConverter) convert(
+	promTS prompb.TimeSeries,
+	marker promSeriesMarkers,
+) (promConvertedSeries, bool, error) {
+	labels, keep, err := samplePromSeries(promTS.Labels, c.samplingLabel)
+	if err != nil || !keep {
+		return promConvertedSeries{}, false, err
+	}
+
+	promTS.Type = c.typeInference.infer(promTS)
+	attributes, err := storage.PromTimeSeriesToSeriesAttributes(promTS)
+	if err != nil {
+		return promConvertedSeries{}, false, err
+	}
+	attributes.CounterReset = marker.counterReset
+	attributes.StaleMarker = marker.staleMarker
+
+	// Set the tag options based on the incoming source.
+	opts := c.tagOpts
+	if attributes.Source == ts.SourceTypeGraphite {
+		if c.graphiteTagOpts == nil {
+			c.graphiteTagOpts = c.tagOpts.SetIDSchemeType(models.TypeGraphite)
+		}
+		opts = c.graphiteTagOpts
+	}
+
+	series := promConvertedSeries{
+		attributes: attributes,
+		tags:       storage.PromLabelsToM3Tags(labels, opts),
+		datapoints: promSamplesToM3Datapoints(promTS.Samples, marker),
+	}
+	if c.storeExemplars && len(promTS.Exemplars) > 0 {
+		series.exemplars = storage.PromExemplarsToAnnotationExemplars(promTS.Exemplars)
+	}
+	if c.storeLabelsHash {
+		series.labelsHash, c.labelsBuffer = hashLabels(labels, c.labelsBuffer)
+	}
+	return series, true, nil
+}
+
+// promSamplesToM3Datapoints converts the samples of a series, writing the
+// stale markers of series marked with the stale marker annotation as ordinary
+// NaN values.
+func promSamplesToM3Datapoints(samples []prompb.Sample, marker promSeriesMarkers) ts.Datapoints {
+	datapoints := storage.PromSamplesToM3Datapoints(samples)
+	if marker.staleMarker {
+		for i := range datapoints {
+			datapoints[i].Value = math.NaN()
+		}
+	}
+	return datapoints
+}
+
+// hashLabels returns a stable hash of the label set of a series, which does
+// not depend on the order of the labels, along with the buffer used to sort
+// a copy of the labels for reuse.
+func hashLabels(labels, buffer []prompb.Label) (uint64, []prompb.Label) {
+	buffer = append(buffer[:0], labels...)
+	id := buildPseudoIDWithLabelsLikelySorted(buffer, nil)
+	return xxhash.Sum64(id), buffer
+}
+
 type promTSIter struct {
 	idx        int
 	err        error
@@ -764,7 +3374,20 @@ type promTSIter struct {
 	tags       []models.Tags
 	datapoints []ts.Datapoints
 	metadatas  []ts.Metadata
-	annotation []byte
+	exemplars  [][]*annotation.Exemplar
+	// labelsHashes is set if the labels hash of each series is stored.
+	labelsHashes []uint64
+	annotation   []byte
+
+	// single backs the per series slices of single series iterators.
+	single struct {
+		attributes   [1]ts.SeriesAttributes
+		tags         [1]models.Tags
+		datapoints   [1]ts.Datapoints
+		metadatas    [1]ts.Metadata
+		exemplars    [1][]*annotation.Exemplar
+		labelsHashes [1]uint64
+	}
 
 	storeMetricsType bool
 }
@@ -779,16 +3402,42 @@ func (i *promTSIter) Next() bool {
 		return false
 	}
 
-	if !i.storeMetricsType {
-		return true
+	var seriesExemplars []*annotation.Exemplar
+	if i.idx < len(i.exemplars) {
+		seriesExemplars = i.exemplars[i.idx]
 	}
 
-	annotationPayload, err := storage.SeriesAttributesToAnnotationPayload(i.attributes[i.idx])
-	if err != nil {
-		i.err = err
-		return false
+	var labelsHash uint64
+	if i.idx < len(i.labelsHashes) {
+		labelsHash = i.labelsHashes[i.idx]
+	}
+
+	attributes := i.attributes[i.idx]
+	if !i.storeMetricsType && len(seriesExemplars) == 0 && labelsHash == 0 &&
+		attributes.Unit == "" && !attributes.CounterReset && !attributes.StaleMarker {
+		i.annotation = nil
+		return true
 	}
 
+	var (
+		annotationPayload annotation.Payload
+		err               error
+	)
+	if i.storeMetricsType {
+		annotationPayload, err = storage.SeriesAttributesToAnnotationPayload(attributes)
+		if err != nil {
+			i.err = err
+			return false
+		}
+	}
+	annotationPayload.Unit = attributes.Unit
+	annotationPayload.CounterReset = attributes.CounterReset
+	annotationPayload.StaleMarker = attributes.StaleMarker
+	annotationPayload.LabelsHash = labelsHash
+
+	// NB: There is no dedicated exemplar write path so exemplars are carried
+	// in the series annotation.
+	annotationPayload.Exemplars = seriesExemplars
 	i.annotation, err = annotationPayload.Marshal()
 	if err != nil {
 		i.err = err
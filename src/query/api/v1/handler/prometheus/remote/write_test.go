@@ -24,13 +24,18 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -44,16 +49,23 @@ import (
 	"github.com/m3db/m3/src/query/generated/proto/prompb"
 	"github.com/m3db/m3/src/query/models"
 	"github.com/m3db/m3/src/query/storage/m3/storagemetadata"
+	"github.com/m3db/m3/src/query/ts"
 	xclock "github.com/m3db/m3/src/x/clock"
 	xerrors "github.com/m3db/m3/src/x/errors"
 	"github.com/m3db/m3/src/x/headers"
 	"github.com/m3db/m3/src/x/instrument"
+	xhttp "github.com/m3db/m3/src/x/net/http"
+	"github.com/m3db/m3/src/x/retry"
 	xtest "github.com/m3db/m3/src/x/test"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/uber-go/tally"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func makeOptions(ds ingest.DownsamplerAndWriter) options.HandlerOptions {
@@ -69,6 +81,20 @@ func makeOptions(ds ingest.DownsamplerAndWriter) options.HandlerOptions {
 		SetStoreMetricsType(true)
 }
 
+// newTestPromWriteHandler returns a write handler built from the options,
+// with the options config changed by the config function.
+func newTestPromWriteHandler(
+	t *testing.T,
+	opts options.HandlerOptions,
+	configFn func(cfg *config.Configuration),
+) *PromWriteHandler {
+	cfg := opts.Config()
+	configFn(&cfg)
+	handler, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.NoError(t, err)
+	return handler.(*PromWriteHandler)
+}
+
 func TestPromWriteParsing(t *testing.T) {
 	ctrl := xtest.NewController(t)
 	defer ctrl.Finish()
@@ -142,6 +168,644 @@ func TestPromWriteError(t *testing.T) {
 	require.True(t, bytes.Contains(body, []byte(batchErr.Error())))
 }
 
+func TestPromWriteStatusCodes(t *testing.T) {
+	overrides := &handleroptions.PromWriteHandlerStatusCodeOptions{
+		BadRequest:        http.StatusUnprocessableEntity,
+		ResourceExhausted: http.StatusServiceUnavailable,
+		IndexFull:         http.StatusForbidden,
+		Retryable:         http.StatusBadGateway,
+	}
+
+	tests := []struct {
+		name         string
+		opts         *handleroptions.PromWriteHandlerStatusCodeOptions
+		err          error
+		expectedCode int
+	}{
+		{
+			name:         "default bad request",
+			err:          xerrors.NewInvalidParamsError(errors.New("bad")),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "default resource exhausted",
+			err:          xerrors.NewResourceExhaustedError(errors.New("exhausted")),
+			expectedCode: http.StatusTooManyRequests,
+		},
+		{
+			name:         "default index full",
+			err:          xerrors.NewIndexFullError(errors.New("index full")),
+			expectedCode: http.StatusInsufficientStorage,
+		},
+		{
+			name:         "default retryable",
+			err:          errors.New("an error"),
+			expectedCode: http.StatusInternalServerError,
+		},
+		{
+			name:         "bad request",
+			opts:         overrides,
+			err:          xerrors.NewInvalidParamsError(errors.New("bad")),
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "resource exhausted",
+			opts:         overrides,
+			err:          xerrors.NewResourceExhaustedError(errors.New("exhausted")),
+			expectedCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:         "index full",
+			opts:         overrides,
+			err:          xerrors.NewIndexFullError(errors.New("index full")),
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "retryable",
+			opts:         overrides,
+			err:          errors.New("an error"),
+			expectedCode: http.StatusBadGateway,
+		},
+		{
+			name: "partial override",
+			opts: &handleroptions.PromWriteHandlerStatusCodeOptions{
+				ResourceExhausted: http.StatusServiceUnavailable,
+			},
+			err:          errors.New("an error"),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(ingest.BatchError(xerrors.NewMultiError().Add(tt.err)))
+
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.StatusCodes = tt.opts
+			})
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, tt.expectedCode, writer.Result().StatusCode)
+		})
+	}
+}
+
+func TestPromWriteIndexFull(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(ingest.BatchError(xerrors.NewMultiError().
+			Add(xerrors.NewResourceExhaustedError(errors.New("exhausted"))).
+			Add(xerrors.NewIndexFullError(errors.New("index at capacity")))))
+
+	scope := tally.NewTestScope("", map[string]string{"test": "index-full-test"})
+	handler, err := NewPromWriteHandler(makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope)))
+	require.NoError(t, err)
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+
+	resp := writer.Result()
+	require.Equal(t, http.StatusInsufficientStorage, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "index_full_errors: count=1, last=index at capacity")
+	require.Contains(t, string(body), "stop creating new series")
+
+	indexFull, ok := scope.Snapshot().Counters()["write.index-full+handler=remote-write,test=index-full-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), indexFull.Value())
+}
+
+func TestPromWriteInvalidStatusCodes(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.StatusCodes = &handleroptions.PromWriteHandlerStatusCodeOptions{
+		Retryable: http.StatusOK,
+	}
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.EqualError(t, err, "retryable status code must be an error status code: 200")
+}
+
+func TestPromWriteRetry(t *testing.T) {
+	retryConfig := &retry.Configuration{
+		InitialBackoff: time.Millisecond,
+		BackoffFactor:  2,
+		MaxRetries:     2,
+	}
+
+	tests := []struct {
+		name          string
+		retry         *retry.Configuration
+		errs          []error
+		expectedCalls int
+		expectedCode  int
+	}{
+		{
+			name:          "transient error then success",
+			retry:         retryConfig,
+			errs:          []error{errors.New("transient")},
+			expectedCalls: 2,
+			expectedCode:  http.StatusOK,
+		},
+		{
+			name:  "transient errors exhaust retries",
+			retry: retryConfig,
+			errs: []error{
+				errors.New("transient"),
+				errors.New("transient"),
+				errors.New("transient"),
+			},
+			expectedCalls: 3,
+			expectedCode:  http.StatusInternalServerError,
+		},
+		{
+			name:          "bad request not retried",
+			retry:         retryConfig,
+			errs:          []error{xerrors.NewInvalidParamsError(errors.New("bad"))},
+			expectedCalls: 1,
+			expectedCode:  http.StatusBadRequest,
+		},
+		{
+			name:          "resource exhausted not retried",
+			retry:         retryConfig,
+			errs:          []error{xerrors.NewResourceExhaustedError(errors.New("exhausted"))},
+			expectedCalls: 1,
+			expectedCode:  http.StatusTooManyRequests,
+		},
+		{
+			name:          "disabled by default",
+			errs:          []error{errors.New("transient")},
+			expectedCalls: 1,
+			expectedCode:  http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			calls := 0
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(
+					context.Context,
+					ingest.DownsampleAndWriteIter,
+					ingest.WriteOptions,
+				) ingest.BatchError {
+					calls++
+					if calls > len(tt.errs) {
+						return nil
+					}
+					return xerrors.NewMultiError().Add(tt.errs[calls-1])
+				}).
+				Times(tt.expectedCalls)
+
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.WriteRetry = tt.retry
+			})
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, tt.expectedCode, writer.Result().StatusCode)
+			require.Equal(t, tt.expectedCalls, calls)
+		})
+	}
+}
+
+func TestPromWriteRetryForever(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	forever := true
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.WriteRetry = &retry.Configuration{Forever: &forever}
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.Equal(t, errWriteRetryForever, err)
+}
+
+func TestPromWriteSecondaryWrite(t *testing.T) {
+	tests := []struct {
+		name             string
+		nonFatal         bool
+		secondaryErr     error
+		expectedCode     int
+		expectedSuccess  int64
+		expectedFailures int64
+	}{
+		{
+			name:            "both succeed",
+			expectedCode:    http.StatusOK,
+			expectedSuccess: 1,
+		},
+		{
+			name:             "secondary fails",
+			secondaryErr:     errors.New("secondary unavailable"),
+			expectedCode:     http.StatusInternalServerError,
+			expectedFailures: 1,
+		},
+		{
+			name:             "secondary fails non fatal",
+			nonFatal:         true,
+			secondaryErr:     errors.New("secondary unavailable"),
+			expectedCode:     http.StatusOK,
+			expectedFailures: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			var primaryWritten, secondaryWritten int
+			primary := ingest.NewMockDownsamplerAndWriter(ctrl)
+			primary.EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+					for iter.Next() {
+						primaryWritten++
+					}
+					return nil
+				})
+			secondary := ingest.NewMockDownsamplerAndWriter(ctrl)
+			secondary.EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+					for iter.Next() {
+						secondaryWritten++
+					}
+					if tt.secondaryErr == nil {
+						return nil
+					}
+					return xerrors.NewMultiError().Add(tt.secondaryErr)
+				})
+
+			scope := tally.NewTestScope("", map[string]string{"test": "secondary-write-test"})
+			opts := makeOptions(primary).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope)).
+				SetPromWriteSecondaryDownsamplerAndWriter(secondary)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.SecondaryWrite = &handleroptions.PromWriteHandlerSecondaryWriteOptions{
+					NonFatal: tt.nonFatal,
+				}
+			})
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedCode != http.StatusOK {
+				body, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Contains(t, string(body), tt.secondaryErr.Error())
+			}
+
+			// Both writers are written every series.
+			require.Equal(t, 2, primaryWritten)
+			require.Equal(t, 2, secondaryWritten)
+
+			counters := scope.Snapshot().Counters()
+			success, ok := counters["write.secondary.success+handler=remote-write,test=secondary-write-test"]
+			require.True(t, ok)
+			require.Equal(t, tt.expectedSuccess, success.Value())
+			failures, ok := counters["write.secondary.errors+handler=remote-write,test=secondary-write-test"]
+			require.True(t, ok)
+			require.Equal(t, tt.expectedFailures, failures.Value())
+		})
+	}
+}
+
+func TestPromWriteSecondaryWriteNoWriter(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.SecondaryWrite = &handleroptions.PromWriteHandlerSecondaryWriteOptions{}
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.Equal(t, errNoSecondaryDownsamplerAndWriter, err)
+}
+
+func TestPromWriteResourceExhaustedPartialSuccess(t *testing.T) {
+	resourceExhaustedErrs := func(n int) []error {
+		var errs []error
+		for i := 0; i < n; i++ {
+			errs = append(errs, xerrors.NewResourceExhaustedError(errors.New("exhausted")))
+		}
+		return errs
+	}
+
+	tests := []struct {
+		name          string
+		opts          *handleroptions.PromWriteHandlerResourceExhaustedPartialSuccessOptions
+		errs          []error
+		expectedCode  int
+		expectPartial bool
+	}{
+		{
+			name:          "below threshold",
+			opts:          &handleroptions.PromWriteHandlerResourceExhaustedPartialSuccessOptions{MaxFraction: 0.2},
+			errs:          resourceExhaustedErrs(1),
+			expectedCode:  http.StatusOK,
+			expectPartial: true,
+		},
+		{
+			name:         "at threshold",
+			opts:         &handleroptions.PromWriteHandlerResourceExhaustedPartialSuccessOptions{MaxFraction: 0.2},
+			errs:         resourceExhaustedErrs(2),
+			expectedCode: http.StatusTooManyRequests,
+		},
+		{
+			name:         "above threshold",
+			opts:         &handleroptions.PromWriteHandlerResourceExhaustedPartialSuccessOptions{MaxFraction: 0.2},
+			errs:         resourceExhaustedErrs(5),
+			expectedCode: http.StatusTooManyRequests,
+		},
+		{
+			name:         "below threshold with other errors",
+			opts:         &handleroptions.PromWriteHandlerResourceExhaustedPartialSuccessOptions{MaxFraction: 0.2},
+			errs:         append(resourceExhaustedErrs(1), errors.New("an error")),
+			expectedCode: http.StatusTooManyRequests,
+		},
+		{
+			name:         "strict",
+			errs:         resourceExhaustedErrs(1),
+			expectedCode: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			multiErr := xerrors.NewMultiError()
+			for _, err := range tt.errs {
+				multiErr = multiErr.Add(err)
+			}
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(ingest.BatchError(multiErr))
+
+			scope := tally.NewTestScope("", map[string]string{"test": "partial-success-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.ResourceExhaustedPartialSuccess = tt.opts
+			})
+
+			promReq := &prompb.WriteRequest{}
+			for i := 0; i < 10; i++ {
+				promReq.Timeseries = append(promReq.Timeseries, prompb.TimeSeries{
+					Labels: []prompb.Label{
+						{Name: []byte("__name__"), Value: []byte(fmt.Sprintf("name_%d", i))},
+					},
+					Samples: []prompb.Sample{
+						{Timestamp: time.Now().UnixMilli(), Value: 42},
+					},
+				})
+			}
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+
+			partial, ok := scope.Snapshot().Counters()["write.partial-success+handler=remote-write,test=partial-success-test"]
+			require.True(t, ok)
+			if !tt.expectPartial {
+				require.Equal(t, int64(0), partial.Value())
+				return
+			}
+			require.Equal(t, int64(1), partial.Value())
+
+			var result PromWritePartialSuccessResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			require.Equal(t, PromWritePartialSuccessResponse{
+				Status:                   partialSuccessStatus,
+				NumSeries:                10,
+				NumResourceExhausted:     len(tt.errs),
+				LastResourceExhaustedErr: tt.errs[len(tt.errs)-1].Error(),
+			}, result)
+		})
+	}
+}
+
+func TestPromWriteResourceExhaustedPartialSuccessInvalidFraction(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.ResourceExhaustedPartialSuccess =
+		&handleroptions.PromWriteHandlerResourceExhaustedPartialSuccessOptions{MaxFraction: 1.5}
+	opts = opts.SetConfig(cfg)
+
+	_, err := NewPromWriteHandler(opts)
+	require.Error(t, err)
+}
+
+func TestPromWriteMaxDecompressionRatio(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.MaxDecompressionRatio = 10
+	})
+
+	// A highly repetitive label value compresses far beyond the ratio.
+	highRatioReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: testLabels("__name__", "bomb",
+					"padding", strings.Repeat("a", 1<<16)),
+				Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+			},
+		},
+	}
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+		test.GeneratePromWriteRequestBody(t, highRatioReq))
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	resp := writer.Result()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "decompression ratio exceeds max")
+
+	// Regular requests are within the ratio.
+	promReq := test.GeneratePromWriteRequest()
+	req = httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+		test.GeneratePromWriteRequestBody(t, promReq))
+	writer = httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+}
+
+func TestPromWriteMaxDecompressionRatioNegative(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.MaxDecompressionRatio = -1
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.Error(t, err)
+}
+
+func TestPromWriteTruncatedBody(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           handleroptions.PromWriteHandlerTruncatedBodyMode
+		expectedStatus int
+	}{
+		{
+			name:           "default",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "reject",
+			mode:           handleroptions.PromWriteHandlerTruncatedBodyModeReject,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "retry",
+			mode:           handleroptions.PromWriteHandlerTruncatedBodyModeRetry,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			scope := tally.NewTestScope("",
+				map[string]string{"test": "truncated-body-test"})
+			iopts := instrument.NewOptions().SetMetricsScope(scope)
+			opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl)).
+				SetInstrumentOpts(iopts)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.TruncatedBody = tt.mode
+			})
+
+			body, err := ioutil.ReadAll(test.GeneratePromWriteRequestBody(t,
+				test.GeneratePromWriteRequest()))
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+				bytes.NewReader(body[:len(body)/2]))
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+			require.Equal(t, string(PromWriteErrorCodeTruncatedBody),
+				resp.Header.Get(headers.ErrorCodeHeader))
+
+			// Malformed bodies keep the generic invalid body code.
+			req = httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+				bytes.NewReader([]byte{0x01, 0x01, 0x00}))
+			writer = httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp = writer.Result()
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			require.Equal(t, string(PromWriteErrorCodeInvalidBody),
+				resp.Header.Get(headers.ErrorCodeHeader))
+
+			truncated, ok := scope.Snapshot().Counters()["write.truncated-bodies+handler=remote-write,test=truncated-body-test"]
+			require.True(t, ok)
+			require.Equal(t, int64(1), truncated.Value())
+		})
+	}
+}
+
+func TestPromWriteTruncatedBodyUnknownMode(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.TruncatedBody = "ignore"
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.EqualError(t, err, "unknown truncated body mode: ignore")
+}
+
+func TestPromWriteDecompressedBytesMetric(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(2)
+
+	scope := tally.NewTestScope("",
+		map[string]string{"test": "decompressed-bytes-test"})
+	iopts := instrument.NewOptions().SetMetricsScope(scope)
+	opts := makeOptions(mockDownsamplerAndWriter).SetInstrumentOpts(iopts)
+	handler, err := NewPromWriteHandler(opts)
+	require.NoError(t, err)
+
+	promReq := test.GeneratePromWriteRequest()
+	decompressedSize := int64(promReq.Size())
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+			test.GeneratePromWriteRequestBody(t, promReq))
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	}
+
+	// Requests failing to parse are not recorded.
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+		strings.NewReader("not snappy"))
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+
+	decompressedBytes, ok := scope.Snapshot().Counters()["write.decompressed-bytes+handler=remote-write,test=decompressed-bytes-test"]
+	require.True(t, ok)
+	require.Equal(t, 2*decompressedSize, decompressedBytes.Value())
+}
+
 func TestWriteErrorMetricCount(t *testing.T) {
 	ctrl := xtest.NewController(t)
 	defer ctrl.Finish()
@@ -210,7 +874,7 @@ func TestWriteDatapointDelayMetric(t *testing.T) {
 	handler.ServeHTTP(httptest.NewRecorder(), req)
 
 	foundMetric := xclock.WaitUntil(func() bool {
-		values, found := scope.Snapshot().Histograms()["ingest.latency+handler=remote-write,test=delay-metric-test"]
+		values, found := scope.Snapshot().Histograms()["ingest.latency+handler=remote-write,metrics_type=default,path=rules,test=delay-metric-test"]
 		if !found {
 			return false
 		}
@@ -224,44 +888,298 @@ func TestWriteDatapointDelayMetric(t *testing.T) {
 	require.True(t, foundMetric)
 }
 
-func TestPromWriteUnaggregatedMetricsWithHeader(t *testing.T) {
+func TestWriteSampleAgeExtremes(t *testing.T) {
 	ctrl := xtest.NewController(t)
 	defer ctrl.Finish()
 
-	expectedIngestWriteOptions := ingest.WriteOptions{
-		DownsampleOverride:     true,
-		DownsampleMappingRules: nil,
-		WriteOverride:          false,
-		WriteStoragePolicies:   nil,
-	}
-
 	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
 	mockDownsamplerAndWriter.
 		EXPECT().
-		WriteBatch(gomock.Any(), gomock.Any(), expectedIngestWriteOptions)
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
 
-	opts := makeOptions(mockDownsamplerAndWriter)
+	scope := tally.NewTestScope("",
+		map[string]string{"test": "sample-age-test"})
+
+	now := time.Now().Truncate(time.Millisecond)
+	iopts := instrument.NewOptions().SetMetricsScope(scope)
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(iopts).
+		SetNowFn(func() time.Time { return now })
 	handler, err := NewPromWriteHandler(opts)
 	require.NoError(t, err)
 
-	promReq := test.GeneratePromWriteRequest()
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{{Name: []byte("__name__"), Value: []byte("first")}},
+				Samples: []prompb.Sample{
+					{Timestamp: now.Add(-5 * time.Minute).UnixMilli(), Value: 1},
+					{Timestamp: now.Add(-12 * time.Second).UnixMilli(), Value: 2},
+				},
+			},
+			{
+				Labels: []prompb.Label{{Name: []byte("__name__"), Value: []byte("second")}},
+				Samples: []prompb.Sample{
+					{Timestamp: now.Add(-30 * time.Minute).UnixMilli(), Value: 3},
+					{Timestamp: now.Add(-time.Minute).UnixMilli(), Value: 4},
+				},
+			},
+		},
+	}
 	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
 	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
-	req.Header.Add(headers.MetricsTypeHeader,
-		storagemetadata.UnaggregatedMetricsType.String())
-
 	writer := httptest.NewRecorder()
 	handler.ServeHTTP(writer, req)
-	resp := writer.Result()
-	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	buckets, err := ingest.NewLatencyBuckets()
+	require.NoError(t, err)
+	bucketFor := func(d time.Duration) time.Duration {
+		for _, upper := range buckets.IngestLatencyBuckets {
+			if upper >= d {
+				return upper
+			}
+		}
+		return time.Duration(math.MaxInt64)
+	}
+
+	requireSingleValue := func(name string, expected time.Duration) {
+		key := name + "+handler=remote-write,metrics_type=default,path=rules,test=sample-age-test"
+		values, found := scope.Snapshot().Histograms()[key]
+		require.True(t, found, key)
+
+		for upper, count := range values.Durations() {
+			if upper == bucketFor(expected) {
+				require.Equal(t, int64(1), count, key)
+			} else {
+				require.Equal(t, int64(0), count, key)
+			}
+		}
+	}
+	requireSingleValue("ingest.oldest-sample-age", 30*time.Minute)
+	requireSingleValue("ingest.newest-sample-age", 12*time.Second)
 }
 
-func TestPromWriteAggregatedMetricsWithHeader(t *testing.T) {
+func TestWriteLatencyMetricsByMetricsType(t *testing.T) {
 	ctrl := xtest.NewController(t)
 	defer ctrl.Finish()
 
-	expectedIngestWriteOptions := ingest.WriteOptions{
-		DownsampleOverride:     true,
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(3)
+
+	scope := tally.NewTestScope("",
+		map[string]string{"test": "latency-metrics-type-test"})
+
+	iopts := instrument.NewOptions().SetMetricsScope(scope)
+	opts := makeOptions(mockDownsamplerAndWriter).SetInstrumentOpts(iopts)
+	handler, err := NewPromWriteHandler(opts)
+	require.NoError(t, err)
+
+	countSamples := func(name, metricsType string) int64 {
+		path := promWritePathOverride
+		if metricsType == "default" {
+			path = promWritePathRules
+		}
+		key := fmt.Sprintf("%s+handler=remote-write,metrics_type=%s,path=%s,test=latency-metrics-type-test",
+			name, metricsType, path)
+		values, found := scope.Snapshot().Histograms()[key]
+		require.True(t, found, key)
+
+		var count int64
+		for _, valuesInBucket := range values.Durations() {
+			count += valuesInBucket
+		}
+		return count
+	}
+
+	write := func(reqHeaders map[string]string) {
+		promReq := test.GeneratePromWriteRequest()
+		promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		for k, v := range reqHeaders {
+			req.Header.Add(k, v)
+		}
+
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	}
+
+	write(map[string]string{
+		headers.MetricsTypeHeader: storagemetadata.UnaggregatedMetricsType.String(),
+	})
+	assert.Equal(t, int64(1), countSamples("write.batch-latency", "unaggregated"))
+	assert.Equal(t, int64(4), countSamples("ingest.latency", "unaggregated"))
+	assert.Equal(t, int64(0), countSamples("write.batch-latency", "aggregated"))
+	assert.Equal(t, int64(0), countSamples("write.batch-latency", "default"))
+
+	write(map[string]string{
+		headers.MetricsTypeHeader:          storagemetadata.AggregatedMetricsType.String(),
+		headers.MetricsStoragePolicyHeader: "1m:21d",
+	})
+	assert.Equal(t, int64(1), countSamples("write.batch-latency", "aggregated"))
+	assert.Equal(t, int64(4), countSamples("ingest.latency", "aggregated"))
+	assert.Equal(t, int64(1), countSamples("write.batch-latency", "unaggregated"))
+	assert.Equal(t, int64(0), countSamples("write.batch-latency", "default"))
+
+	write(nil)
+	assert.Equal(t, int64(1), countSamples("write.batch-latency", "default"))
+	assert.Equal(t, int64(4), countSamples("ingest.latency", "default"))
+	assert.Equal(t, int64(1), countSamples("write.batch-latency", "aggregated"))
+	assert.Equal(t, int64(1), countSamples("write.batch-latency", "unaggregated"))
+}
+
+func TestWriteMetricsByPath(t *testing.T) {
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		expectedPath promWritePath
+		metricsType  string
+	}{
+		{
+			name:         "rules",
+			expectedPath: promWritePathRules,
+			metricsType:  "default",
+		},
+		{
+			name: "unaggregated override",
+			headers: map[string]string{
+				headers.MetricsTypeHeader: storagemetadata.UnaggregatedMetricsType.String(),
+			},
+			expectedPath: promWritePathOverride,
+			metricsType:  storagemetadata.UnaggregatedMetricsType.String(),
+		},
+		{
+			name: "aggregated override",
+			headers: map[string]string{
+				headers.MetricsTypeHeader:          storagemetadata.AggregatedMetricsType.String(),
+				headers.MetricsStoragePolicyHeader: "1m:21d",
+			},
+			expectedPath: promWritePathOverride,
+			metricsType:  storagemetadata.AggregatedMetricsType.String(),
+		},
+		{
+			name: "aggregate write type",
+			headers: map[string]string{
+				headers.WriteTypeHeader: headers.AggregateWriteType,
+			},
+			expectedPath: promWritePathAggregateWriteType,
+			metricsType:  storagemetadata.AggregatedMetricsType.String(),
+		},
+		{
+			name: "metrics type with aggregate write type",
+			headers: map[string]string{
+				headers.MetricsTypeHeader: storagemetadata.UnaggregatedMetricsType.String(),
+				headers.WriteTypeHeader:   headers.AggregateWriteType,
+			},
+			expectedPath: promWritePathAggregateWriteType,
+			metricsType:  storagemetadata.AggregatedMetricsType.String(),
+		},
+		{
+			name: "default write type",
+			headers: map[string]string{
+				headers.WriteTypeHeader: headers.DefaultWriteType,
+			},
+			expectedPath: promWritePathRules,
+			metricsType:  "default",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.
+				EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+			scope := tally.NewTestScope("", map[string]string{"test": "write-path-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler, err := NewPromWriteHandler(opts)
+			require.NoError(t, err)
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			for k, v := range tt.headers {
+				req.Header.Add(k, v)
+			}
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+			snapshot := scope.Snapshot()
+			for _, path := range []promWritePath{
+				promWritePathRules,
+				promWritePathOverride,
+				promWritePathAggregateWriteType,
+			} {
+				var expected int64
+				if path == tt.expectedPath {
+					expected = 1
+				}
+				key := fmt.Sprintf("write.success+handler=remote-write,path=%s,test=write-path-test", path)
+				success, ok := snapshot.Counters()[key]
+				require.True(t, ok, key)
+				require.Equal(t, expected, success.Value(), key)
+			}
+
+			key := fmt.Sprintf("write.batch-latency+handler=remote-write,metrics_type=%s,path=%s,test=write-path-test",
+				tt.metricsType, tt.expectedPath)
+			latency, ok := snapshot.Histograms()[key]
+			require.True(t, ok, key)
+			var count int64
+			for _, valuesInBucket := range latency.Durations() {
+				count += valuesInBucket
+			}
+			require.Equal(t, int64(1), count, key)
+		})
+	}
+}
+
+func TestPromWriteUnaggregatedMetricsWithHeader(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	expectedIngestWriteOptions := ingest.WriteOptions{
+		DownsampleOverride:     true,
+		DownsampleMappingRules: nil,
+		WriteOverride:          false,
+		WriteStoragePolicies:   nil,
+	}
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), expectedIngestWriteOptions)
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler, err := NewPromWriteHandler(opts)
+	require.NoError(t, err)
+
+	promReq := test.GeneratePromWriteRequest()
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Add(headers.MetricsTypeHeader,
+		storagemetadata.UnaggregatedMetricsType.String())
+
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	resp := writer.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestPromWriteAggregatedMetricsWithHeader(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	expectedIngestWriteOptions := ingest.WriteOptions{
+		DownsampleOverride:     true,
 		DownsampleMappingRules: nil,
 		WriteOverride:          true,
 		WriteStoragePolicies: policy.StoragePolicies{
@@ -292,6 +1210,155 @@ func TestPromWriteAggregatedMetricsWithHeader(t *testing.T) {
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
+func TestPromWriteMinStoragePolicyResolution(t *testing.T) {
+	tests := []struct {
+		name          string
+		metricsType   storagemetadata.MetricsType
+		storagePolicy string
+		expectedCode  int
+		expectedErr   string
+	}{
+		{
+			name:          "too fine",
+			metricsType:   storagemetadata.AggregatedMetricsType,
+			storagePolicy: "1s:2d",
+			expectedCode:  http.StatusBadRequest,
+			expectedErr:   "storage policy 1s:2d resolution 1s is finer than the min allowed resolution 10s",
+		},
+		{
+			name:          "allowed",
+			metricsType:   storagemetadata.AggregatedMetricsType,
+			storagePolicy: "10s:2d",
+			expectedCode:  http.StatusOK,
+		},
+		{
+			name:         "unaggregated",
+			metricsType:  storagemetadata.UnaggregatedMetricsType,
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedCode == http.StatusOK {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+			}
+
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.MinStoragePolicyResolution = 10 * time.Second
+			})
+
+			promReq := test.GeneratePromWriteRequest()
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			req.Header.Add(headers.MetricsTypeHeader, tt.metricsType.String())
+			if tt.storagePolicy != "" {
+				req.Header.Add(headers.MetricsStoragePolicyHeader, tt.storagePolicy)
+			}
+
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+
+			if tt.expectedErr != "" {
+				body, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Contains(t, string(body), tt.expectedErr)
+			}
+		})
+	}
+}
+
+func TestPromWriteInvalidStoragePolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         handleroptions.PromWriteHandlerInvalidStoragePolicyMode
+		expectedCode int
+	}{
+		{
+			name:         "default rejects",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "reject",
+			mode:         handleroptions.PromWriteHandlerInvalidStoragePolicyModeReject,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "fallback",
+			mode:         handleroptions.PromWriteHandlerInvalidStoragePolicyModeFallback,
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedCode == http.StatusOK {
+				// The request is written with the default rules.
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), ingest.WriteOptions{})
+			}
+
+			scope := tally.NewTestScope("",
+				map[string]string{"test": "invalid-storage-policy-test"})
+			iopts := instrument.NewOptions().SetMetricsScope(scope)
+			opts := makeOptions(mockDownsamplerAndWriter).SetInstrumentOpts(iopts)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.InvalidStoragePolicy = tt.mode
+			})
+
+			promReq := test.GeneratePromWriteRequest()
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			req.Header.Add(headers.MetricsTypeHeader,
+				storagemetadata.AggregatedMetricsType.String())
+			req.Header.Add(headers.MetricsStoragePolicyHeader, "not-a-policy")
+
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+
+			fallback, ok := scope.Snapshot().Counters()["write.storage-policy-fallback+handler=remote-write,test=invalid-storage-policy-test"]
+			require.True(t, ok)
+			if tt.expectedCode == http.StatusOK {
+				require.Equal(t, int64(1), fallback.Value())
+				return
+			}
+			require.Equal(t, int64(0), fallback.Value())
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), "could not parse storage policy")
+		})
+	}
+}
+
+func TestPromWriteInvalidStoragePolicyUnknownMode(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.InvalidStoragePolicy = "ignore"
+	opts = opts.SetConfig(cfg)
+
+	_, err := NewPromWriteHandler(opts)
+	require.EqualError(t, err, "unknown invalid storage policy mode: ignore")
+}
+
 func TestPromWriteOpenMetricsTypes(t *testing.T) {
 	ctrl := xtest.NewController(t)
 	defer ctrl.Finish()
@@ -348,7 +1415,47 @@ func TestPromWriteOpenMetricsTypes(t *testing.T) {
 	}, secondAnnotationPayload, "second annotation invalidated")
 }
 
-func TestPromWriteGraphiteMetricsTypes(t *testing.T) {
+func TestPromWriteUnitHeader(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var capturedIter ingest.DownsampleAndWriteIter
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			capturedIter = iter
+			return nil
+		})
+
+	handler, err := NewPromWriteHandler(makeOptions(mockDownsamplerAndWriter))
+	require.NoError(t, err)
+
+	promReq := test.GeneratePromWriteRequest()
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(headers.PromTypeHeader, "counter")
+	req.Header.Set(headers.PromUnitHeader, "bytes")
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	for range promReq.Timeseries {
+		require.True(t, capturedIter.Next())
+		value := capturedIter.Current()
+		require.Equal(t, "bytes", value.Attributes.Unit)
+		assert.Equal(t, annotation.Payload{
+			OpenMetricsFamilyType:        annotation.OpenMetricsFamilyType_COUNTER,
+			OpenMetricsHandleValueResets: true,
+			Unit:                         "bytes",
+		}, unmarshalAnnotation(t, value.Annotation))
+	}
+	require.False(t, capturedIter.Next())
+	require.NoError(t, capturedIter.Error())
+}
+
+func TestPromWriteUnitHeaderMetricsTypeNotStored(t *testing.T) {
 	ctrl := xtest.NewController(t)
 	defer ctrl.Finish()
 
@@ -362,221 +1469,2360 @@ func TestPromWriteGraphiteMetricsTypes(t *testing.T) {
 			return nil
 		})
 
+	opts := makeOptions(mockDownsamplerAndWriter).SetStoreMetricsType(false)
+	handler, err := NewPromWriteHandler(opts)
+	require.NoError(t, err)
+
+	promReq := test.GeneratePromWriteRequest()
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(headers.PromUnitHeader, "bytes")
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	// The unit is stored even though the metric type is not.
+	for range promReq.Timeseries {
+		require.True(t, capturedIter.Next())
+		value := capturedIter.Current()
+		assert.Equal(t, annotation.Payload{Unit: "bytes"},
+			unmarshalAnnotation(t, value.Annotation))
+	}
+	require.False(t, capturedIter.Next())
+	require.NoError(t, capturedIter.Error())
+}
+
+func TestPromWriteTypesHeader(t *testing.T) {
+	now := time.Now().UnixMilli()
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  testLabels("__name__", "requests_total"),
+				Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+			},
+			{
+				Labels:  testLabels("__name__", "temperature"),
+				Samples: []prompb.Sample{{Timestamp: now, Value: 2}},
+			},
+			{
+				Labels:  testLabels("__name__", "latency_seconds"),
+				Samples: []prompb.Sample{{Timestamp: now, Value: 3}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		types         string
+		defaultType   string
+		expectedCode  int
+		expectedErr   string
+		expectedTypes []annotation.OpenMetricsFamilyType
+	}{
+		{
+			name:         "per series types",
+			types:        `{"requests_total":"counter","temperature":"gauge"}`,
+			expectedCode: http.StatusOK,
+			expectedTypes: []annotation.OpenMetricsFamilyType{
+				annotation.OpenMetricsFamilyType_COUNTER,
+				annotation.OpenMetricsFamilyType_GAUGE,
+				annotation.OpenMetricsFamilyType_UNKNOWN,
+			},
+		},
+		{
+			name:         "falls back to request type",
+			types:        `{"requests_total":"Counter"}`,
+			defaultType:  "summary",
+			expectedCode: http.StatusOK,
+			expectedTypes: []annotation.OpenMetricsFamilyType{
+				annotation.OpenMetricsFamilyType_COUNTER,
+				annotation.OpenMetricsFamilyType_SUMMARY,
+				annotation.OpenMetricsFamilyType_SUMMARY,
+			},
+		},
+		{
+			name:         "invalid json",
+			types:        `{"requests_total":`,
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  "invalid Prometheus-Metric-Types header",
+		},
+		{
+			name:         "unknown type",
+			types:        `{"requests_total":"bogus"}`,
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  "unknown prom metric type bogus for metric requests_total",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			var capturedIter ingest.DownsampleAndWriteIter
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.
+				EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+					capturedIter = iter
+					return nil
+				}).
+				AnyTimes()
+
+			handler, err := NewPromWriteHandler(makeOptions(mockDownsamplerAndWriter))
+			require.NoError(t, err)
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			req.Header.Set(headers.PromTypesHeader, tt.types)
+			if tt.defaultType != "" {
+				req.Header.Set(headers.PromTypeHeader, tt.defaultType)
+			}
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedErr != "" {
+				body, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Contains(t, string(body), tt.expectedErr)
+				return
+			}
+
+			for _, expected := range tt.expectedTypes {
+				require.True(t, capturedIter.Next())
+				value := capturedIter.Current()
+				if expected == annotation.OpenMetricsFamilyType_UNKNOWN {
+					assert.Nil(t, value.Annotation)
+					continue
+				}
+				assert.Equal(t, expected,
+					unmarshalAnnotation(t, value.Annotation).OpenMetricsFamilyType)
+			}
+			require.False(t, capturedIter.Next())
+			require.NoError(t, capturedIter.Error())
+		})
+	}
+}
+
+func TestPromWriteCounterResetDetection(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			var capturedIter ingest.DownsampleAndWriteIter
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.
+				EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+				Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+					capturedIter = iter
+					return nil
+				})
+
+			scope := tally.NewTestScope("", map[string]string{"test": "counter-reset-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.CounterResetDetection = enabled
+			})
+
+			now := time.Now().UnixMilli()
+			newSeries := func(name string, tp prompb.MetricType, values ...float64) prompb.TimeSeries {
+				series := prompb.TimeSeries{
+					Labels: []prompb.Label{{Name: []byte("__name__"), Value: []byte(name)}},
+					Type:   tp,
+				}
+				for i, v := range values {
+					series.Samples = append(series.Samples,
+						prompb.Sample{Timestamp: now + int64(i), Value: v})
+				}
+				return series
+			}
+			promReq := &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{
+					newSeries("decreasing", prompb.MetricType_COUNTER, 10, 20, 5, 15),
+					newSeries("monotonic", prompb.MetricType_COUNTER, 10, 20, 20, 30),
+					newSeries("gauge", prompb.MetricType_GAUGE, 10, 20, 5),
+				},
+			}
+			expectedResets := []bool{enabled, false, false}
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+			for _, expected := range expectedResets {
+				require.True(t, capturedIter.Next())
+				value := capturedIter.Current()
+				require.Equal(t, expected, value.Attributes.CounterReset)
+				require.Equal(t, expected, unmarshalAnnotation(t, value.Annotation).CounterReset)
+			}
+			require.False(t, capturedIter.Next())
+			require.NoError(t, capturedIter.Error())
+
+			resets, ok := scope.Snapshot().Counters()["write.counter-resets+handler=remote-write,test=counter-reset-test"]
+			require.True(t, ok)
+			if enabled {
+				require.Equal(t, int64(1), resets.Value())
+			} else {
+				require.Equal(t, int64(0), resets.Value())
+			}
+		})
+	}
+}
+
+func TestPromWriteCounterResetDetectionDroppedSeries(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		names  []string
+		resets []bool
+	)
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			for iter.Next() {
+				value := iter.Current()
+				name, _ := value.Tags.Get([]byte("__name__"))
+				names = append(names, string(name))
+				resets = append(resets, value.Attributes.CounterReset)
+			}
+			return nil
+		})
+
 	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.CounterResetDetection = true
+		cfg.PromRemoteWrite.MissingName = handleroptions.PromWriteHandlerMissingNameModeDrop
+	})
 
+	now := time.Now().UnixMilli()
+	samples := []prompb.Sample{{Timestamp: now, Value: 10}, {Timestamp: now + 1, Value: 5}}
 	promReq := &prompb.WriteRequest{
 		Timeseries: []prompb.TimeSeries{
-			{Source: prompb.Source_GRAPHITE, M3Type: prompb.M3Type_M3_TIMER},
-			{Source: prompb.Source_GRAPHITE, M3Type: prompb.M3Type_M3_COUNTER},
-			{Source: prompb.Source_GRAPHITE, M3Type: prompb.M3Type_M3_GAUGE},
-			{Source: prompb.Source_GRAPHITE, M3Type: prompb.M3Type_M3_GAUGE},
-			{Source: prompb.Source_GRAPHITE, M3Type: prompb.M3Type_M3_TIMER},
-			{Source: prompb.Source_GRAPHITE, M3Type: prompb.M3Type_M3_COUNTER},
+			{Labels: testLabels("job", "api"), Samples: samples, Type: prompb.MetricType_COUNTER},
+			{Labels: testLabels("__name__", "gauge"), Samples: samples, Type: prompb.MetricType_GAUGE},
+			{Labels: testLabels("__name__", "counter"), Samples: samples, Type: prompb.MetricType_COUNTER},
 		},
 	}
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL,
+		test.GeneratePromWriteRequestBody(t, promReq))
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
 
-	executeWriteRequest(t, opts, promReq)
+	// The markers follow the series left once the unnamed series is dropped.
+	require.Equal(t, []string{"gauge", "counter"}, names)
+	require.Equal(t, []bool{false, true}, resets)
+}
 
+func TestPromWriteGraphiteMetricsTypes(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var capturedIter ingest.DownsampleAndWriteIter
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			capturedIter = iter
+			return nil
+		})
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{Source: prompb.Source_GRAPHITE, M3Type: prompb.M3Type_M3_TIMER},
+			{Source: prompb.Source_GRAPHITE, M3Type: prompb.M3Type_M3_COUNTER},
+			{Source: prompb.Source_GRAPHITE, M3Type: prompb.M3Type_M3_GAUGE},
+			{Source: prompb.Source_GRAPHITE, M3Type: prompb.M3Type_M3_GAUGE},
+			{Source: prompb.Source_GRAPHITE, M3Type: prompb.M3Type_M3_TIMER},
+			{Source: prompb.Source_GRAPHITE, M3Type: prompb.M3Type_M3_COUNTER},
+		},
+	}
+
+	executeWriteRequest(t, opts, promReq)
+
+	verifyIterValueAnnotationGraphite(t, capturedIter, annotation.GraphiteType_GRAPHITE_TIMER)
+	verifyIterValueAnnotationGraphite(t, capturedIter, annotation.GraphiteType_GRAPHITE_COUNTER)
+	verifyIterValueAnnotationGraphite(t, capturedIter, annotation.GraphiteType_GRAPHITE_GAUGE)
+	verifyIterValueAnnotationGraphite(t, capturedIter, annotation.GraphiteType_GRAPHITE_GAUGE)
 	verifyIterValueAnnotationGraphite(t, capturedIter, annotation.GraphiteType_GRAPHITE_TIMER)
 	verifyIterValueAnnotationGraphite(t, capturedIter, annotation.GraphiteType_GRAPHITE_COUNTER)
-	verifyIterValueAnnotationGraphite(t, capturedIter, annotation.GraphiteType_GRAPHITE_GAUGE)
-	verifyIterValueAnnotationGraphite(t, capturedIter, annotation.GraphiteType_GRAPHITE_GAUGE)
-	verifyIterValueAnnotationGraphite(t, capturedIter, annotation.GraphiteType_GRAPHITE_TIMER)
-	verifyIterValueAnnotationGraphite(t, capturedIter, annotation.GraphiteType_GRAPHITE_COUNTER)
 
-	require.False(t, capturedIter.Next())
-	require.NoError(t, capturedIter.Error())
+	require.False(t, capturedIter.Next())
+	require.NoError(t, capturedIter.Error())
+}
+
+func TestPromWriteDisabledMetricsTypes(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var capturedIter ingest.DownsampleAndWriteIter
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			capturedIter = iter
+			return nil
+		})
+
+	opts := makeOptions(mockDownsamplerAndWriter).SetStoreMetricsType(false)
+
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{Type: prompb.MetricType_COUNTER},
+			{},
+		},
+	}
+
+	executeWriteRequest(t, opts, promReq)
+
+	verifyIterValueNoAnnotation(t, capturedIter)
+	verifyIterValueNoAnnotation(t, capturedIter)
+
+	require.False(t, capturedIter.Next())
+	require.NoError(t, capturedIter.Error())
+}
+
+func TestPromWriteLiteralIsTooLongError(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	handler, err := NewPromWriteHandler(opts)
+	require.NoError(t, err)
+
+	veryLongLiteral := strings.Repeat("x", int(opts.TagOptions().MaxTagLiteralLength())+1)
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{
+					{Name: []byte("name1"), Value: []byte("value1")},
+					{Name: []byte("name2"), Value: []byte(veryLongLiteral)},
+				},
+			},
+		},
+	}
+
+	for i := 0; i < maxLiteralIsTooLongLogCount*2; i++ {
+		promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		resp := writer.Result()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+	}
+}
+
+func TestPromWriteLabelLiteralLengths(t *testing.T) {
+	maxTagLiteralLength := int(models.NewTagOptions().MaxTagLiteralLength())
+	tests := []struct {
+		name           string
+		maxNameLength  int
+		maxValueLength int
+		label          prompb.Label
+		expectedErr    string
+	}{
+		{
+			name:          "long value within value limit",
+			maxNameLength: 16,
+			label: prompb.Label{
+				Name:  []byte("short_name"),
+				Value: []byte(strings.Repeat("x", 100)),
+			},
+		},
+		{
+			name:          "name exceeds name limit",
+			maxNameLength: 16,
+			label: prompb.Label{
+				Name:  []byte(strings.Repeat("x", 17)),
+				Value: []byte("value"),
+			},
+			expectedErr: "label name is too long: nameLength=17, maxNameLength=16",
+		},
+		{
+			name:          "value exceeds default value limit",
+			maxNameLength: 16,
+			label: prompb.Label{
+				Name:  []byte("short_name"),
+				Value: []byte(strings.Repeat("x", maxTagLiteralLength+1)),
+			},
+			expectedErr: fmt.Sprintf("label value is too long: valueLength=%d, maxValueLength=%d",
+				maxTagLiteralLength+1, maxTagLiteralLength),
+		},
+		{
+			name:           "value exceeds value limit",
+			maxValueLength: 64,
+			label: prompb.Label{
+				Name:  []byte("short_name"),
+				Value: []byte(strings.Repeat("x", 65)),
+			},
+			expectedErr: "label value is too long: valueLength=65, maxValueLength=64",
+		},
+		{
+			name: "single limit",
+			label: prompb.Label{
+				Name:  []byte("short_name"),
+				Value: []byte(strings.Repeat("x", maxTagLiteralLength+1)),
+			},
+			expectedErr: fmt.Sprintf("label literal is too long: nameLength=10, valueLength=%d, maxLength=%d",
+				maxTagLiteralLength+1, maxTagLiteralLength),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedErr == "" {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+			}
+
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.MaxLabelNameLength = tt.maxNameLength
+				cfg.PromRemoteWrite.MaxLabelValueLength = tt.maxValueLength
+			})
+
+			promReq := &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{
+					{
+						Labels: []prompb.Label{
+							{Name: []byte("__name__"), Value: []byte("up")},
+							tt.label,
+						},
+						Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+					},
+				},
+			}
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			if tt.expectedErr == "" {
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+				return
+			}
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), tt.expectedErr)
+		})
+	}
+}
+
+func TestPromWriteLabelLiteralLengthsExceedTagLiteralLength(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	maxTagLiteralLength := int(opts.TagOptions().MaxTagLiteralLength())
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.MaxLabelValueLength = maxTagLiteralLength + 1
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.EqualError(t, err, fmt.Sprintf("max label value length exceeds the max tag "+
+		"literal length: %d > %d", maxTagLiteralLength+1, maxTagLiteralLength))
+}
+
+func TestPromWriteTimestampFloor(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name         string
+		timestamp    int64
+		mode         handleroptions.PromWriteHandlerTimestampFloorMode
+		expectedCode int
+	}{
+		{
+			name:         "plausible milliseconds",
+			timestamp:    now.UnixMilli(),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "likely seconds rejected",
+			timestamp:    now.Unix(),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "likely seconds warned",
+			timestamp:    now.Unix(),
+			mode:         handleroptions.PromWriteHandlerTimestampFloorModeWarn,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "at floor",
+			timestamp:    defaultMinTimestampMillis,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "just below floor",
+			timestamp:    defaultMinTimestampMillis - 1,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedCode == http.StatusOK {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+			}
+
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.TimestampFloor = &handleroptions.PromWriteHandlerTimestampFloorOptions{
+					Mode: tt.mode,
+				}
+			})
+
+			promReq := &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{
+					{
+						Labels: []prompb.Label{
+							{Name: []byte("__name__"), Value: []byte("foo")},
+						},
+						Samples: []prompb.Sample{
+							{Timestamp: now.UnixMilli(), Value: 1},
+							{Timestamp: tt.timestamp, Value: 2},
+						},
+					},
+				},
+			}
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			require.NoError(t, resp.Body.Close())
+		})
+	}
+}
+
+func TestPromWriteTimestampFloorInvalidMode(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.TimestampFloor = &handleroptions.PromWriteHandlerTimestampFloorOptions{
+		Mode: "unknown",
+	}
+
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.Error(t, err)
+}
+
+func TestPromWriteMaxSamplesPerRequest(t *testing.T) {
+	newSeries := func(name string, numSamples int) prompb.TimeSeries {
+		series := prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: []byte("__name__"), Value: []byte(name)},
+			},
+		}
+		for i := 0; i < numSamples; i++ {
+			series.Samples = append(series.Samples, prompb.Sample{
+				Timestamp: time.Now().UnixMilli(),
+				Value:     float64(i),
+			})
+		}
+		return series
+	}
+
+	tests := []struct {
+		name           string
+		mode           handleroptions.PromWriteHandlerMaxSamplesMode
+		series         []prompb.TimeSeries
+		expectedCode   int
+		expectedSeries int
+	}{
+		{
+			name:           "under limit",
+			series:         []prompb.TimeSeries{newSeries("a", 2), newSeries("b", 3)},
+			expectedCode:   http.StatusOK,
+			expectedSeries: 2,
+		},
+		{
+			name:         "over limit rejected",
+			series:       []prompb.TimeSeries{newSeries("a", 3), newSeries("b", 3)},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:           "over limit truncated",
+			mode:           handleroptions.PromWriteHandlerMaxSamplesModeTruncate,
+			series:         []prompb.TimeSeries{newSeries("a", 3), newSeries("b", 3), newSeries("c", 1)},
+			expectedCode:   http.StatusOK,
+			expectedSeries: 1,
+		},
+		{
+			name:         "single huge series rejected",
+			series:       []prompb.TimeSeries{newSeries("a", 10)},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "single huge series truncated to nothing rejected",
+			mode:         handleroptions.PromWriteHandlerMaxSamplesModeTruncate,
+			series:       []prompb.TimeSeries{newSeries("a", 10)},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "all samples truncated rejected",
+			mode:         handleroptions.PromWriteHandlerMaxSamplesModeTruncate,
+			series:       []prompb.TimeSeries{newSeries("a", 0), newSeries("b", 10), newSeries("c", 1)},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			numWritten := 0
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedCode == http.StatusOK {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+					Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+						for iter.Next() {
+							numWritten++
+						}
+						return nil
+					})
+			}
+
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.MaxSamplesPerRequest = &handleroptions.PromWriteHandlerMaxSamplesOptions{
+					Limit: 5,
+					Mode:  tt.mode,
+				}
+			})
+
+			promReq := &prompb.WriteRequest{Timeseries: tt.series}
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, tt.expectedSeries, numWritten)
+		})
+	}
+}
+
+func TestPromWriteMissingName(t *testing.T) {
+	var (
+		named = prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: []byte("__name__"), Value: []byte("foo")},
+				{Name: []byte("biz"), Value: []byte("baz")},
+			},
+			Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+		}
+		unnamed = prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: []byte("biz"), Value: []byte("baz")},
+			},
+			Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 2}},
+		}
+		emptyName = prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: []byte("__name__"), Value: []byte("")},
+			},
+			Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 3}},
+		}
+		graphite = prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: []byte("__g0__"), Value: []byte("foo")},
+			},
+			Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 4}},
+			Source:  prompb.Source_GRAPHITE,
+		}
+	)
+
+	tests := []struct {
+		name            string
+		mode            handleroptions.PromWriteHandlerMissingNameMode
+		series          []prompb.TimeSeries
+		expectedCode    int
+		expectedSeries  int
+		expectedDropped int64
+	}{
+		{
+			name:           "with name",
+			series:         []prompb.TimeSeries{named, graphite},
+			expectedCode:   http.StatusOK,
+			expectedSeries: 2,
+		},
+		{
+			name:            "without name rejected",
+			mode:            handleroptions.PromWriteHandlerMissingNameModeReject,
+			series:          []prompb.TimeSeries{named, unnamed},
+			expectedCode:    http.StatusBadRequest,
+			expectedDropped: 1,
+		},
+		{
+			name:            "empty name rejected",
+			mode:            handleroptions.PromWriteHandlerMissingNameModeReject,
+			series:          []prompb.TimeSeries{emptyName},
+			expectedCode:    http.StatusBadRequest,
+			expectedDropped: 1,
+		},
+		{
+			name:            "without name dropped",
+			mode:            handleroptions.PromWriteHandlerMissingNameModeDrop,
+			series:          []prompb.TimeSeries{unnamed, named, emptyName, graphite},
+			expectedCode:    http.StatusOK,
+			expectedSeries:  2,
+			expectedDropped: 2,
+		},
+		{
+			name:           "not checked by default",
+			series:         []prompb.TimeSeries{named, unnamed},
+			expectedCode:   http.StatusOK,
+			expectedSeries: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			numWritten := 0
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedCode == http.StatusOK {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+					Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+						for iter.Next() {
+							numWritten++
+						}
+						return nil
+					})
+			}
+
+			scope := tally.NewTestScope("", map[string]string{"test": "missing-name-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.MissingName = tt.mode
+			})
+
+			promReq := &prompb.WriteRequest{Timeseries: tt.series}
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, tt.expectedSeries, numWritten)
+
+			dropped, ok := scope.Snapshot().Counters()["write.series-dropped-no-name+handler=remote-write,test=missing-name-test"]
+			require.True(t, ok)
+			require.Equal(t, tt.expectedDropped, dropped.Value())
+		})
+	}
+}
+
+func TestPromWriteMissingNameInvalidMode(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.MissingName = "invalid"
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.Error(t, err)
+}
+
+func TestPromWriteMalformedSeries(t *testing.T) {
+	var (
+		now   = time.Now().UnixMilli()
+		clean = prompb.TimeSeries{
+			Labels:  testLabels("__name__", "clean"),
+			Samples: []prompb.Sample{{Timestamp: now, Value: 1}, {Timestamp: now + 1, Value: 2}},
+		}
+		noLabels = prompb.TimeSeries{
+			Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+		}
+		duplicateLabels = prompb.TimeSeries{
+			Labels:  testLabels("__name__", "duplicate_labels", "foo", "a", "foo", "b"),
+			Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+		}
+		noSamples = prompb.TimeSeries{
+			Labels: testLabels("__name__", "no_samples"),
+		}
+		unordered = prompb.TimeSeries{
+			Labels:  testLabels("__name__", "unordered"),
+			Samples: []prompb.Sample{{Timestamp: now + 1, Value: 1}, {Timestamp: now, Value: 2}},
+		}
+		duplicate = prompb.TimeSeries{
+			Labels:  testLabels("__name__", "duplicate"),
+			Samples: []prompb.Sample{{Timestamp: now, Value: 1}, {Timestamp: now, Value: 2}},
+		}
+	)
+
+	tests := []struct {
+		name            string
+		mode            handleroptions.PromWriteHandlerMalformedSeriesMode
+		strictOrder     bool
+		series          []prompb.TimeSeries
+		expectedCode    int
+		expectedErr     string
+		expectedSeries  int
+		expectedDropped int64
+	}{
+		{
+			name:           "clean",
+			mode:           handleroptions.PromWriteHandlerMalformedSeriesModeReject,
+			series:         []prompb.TimeSeries{clean, clean},
+			expectedCode:   http.StatusOK,
+			expectedSeries: 2,
+		},
+		{
+			name:            "no labels rejected",
+			mode:            handleroptions.PromWriteHandlerMalformedSeriesModeReject,
+			series:          []prompb.TimeSeries{clean, noLabels},
+			expectedCode:    http.StatusBadRequest,
+			expectedErr:     "malformed series: no labels",
+			expectedDropped: 1,
+		},
+		{
+			name:            "duplicate label names rejected",
+			mode:            handleroptions.PromWriteHandlerMalformedSeriesModeReject,
+			series:          []prompb.TimeSeries{clean, duplicateLabels},
+			expectedCode:    http.StatusBadRequest,
+			expectedErr:     "malformed series: duplicate label name: name=foo",
+			expectedDropped: 1,
+		},
+		{
+			name:           "out of order and empty accepted",
+			mode:           handleroptions.PromWriteHandlerMalformedSeriesModeReject,
+			series:         []prompb.TimeSeries{clean, noSamples, unordered, duplicate},
+			expectedCode:   http.StatusOK,
+			expectedSeries: 4,
+		},
+		{
+			name:            "unordered rejected if strict",
+			mode:            handleroptions.PromWriteHandlerMalformedSeriesModeReject,
+			strictOrder:     true,
+			series:          []prompb.TimeSeries{unordered},
+			expectedCode:    http.StatusBadRequest,
+			expectedErr:     "malformed series: sample timestamps not increasing",
+			expectedDropped: 1,
+		},
+		{
+			name:            "malformed dropped",
+			mode:            handleroptions.PromWriteHandlerMalformedSeriesModeDrop,
+			series:          []prompb.TimeSeries{noLabels, clean, unordered, duplicateLabels, duplicate},
+			expectedCode:    http.StatusOK,
+			expectedSeries:  3,
+			expectedDropped: 2,
+		},
+		{
+			name:            "malformed dropped if strict",
+			mode:            handleroptions.PromWriteHandlerMalformedSeriesModeDrop,
+			strictOrder:     true,
+			series:          []prompb.TimeSeries{noLabels, clean, unordered, duplicateLabels, duplicate},
+			expectedCode:    http.StatusOK,
+			expectedSeries:  1,
+			expectedDropped: 4,
+		},
+		{
+			name:           "not checked by default",
+			series:         []prompb.TimeSeries{clean, unordered},
+			strictOrder:    true,
+			expectedCode:   http.StatusOK,
+			expectedSeries: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			numWritten := 0
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedCode == http.StatusOK {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+					Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+						for iter.Next() {
+							numWritten++
+						}
+						return nil
+					})
+			}
+
+			scope := tally.NewTestScope("", map[string]string{"test": "malformed-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.MalformedSeries = tt.mode
+				cfg.PromRemoteWrite.StrictSampleOrder = tt.strictOrder
+			})
+
+			promReq := &prompb.WriteRequest{Timeseries: tt.series}
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			req.Header.Set(headers.DebugDropCountsHeader, "true")
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Contains(t, string(body), tt.expectedErr)
+			require.Equal(t, tt.expectedSeries, numWritten)
+			if tt.expectedCode == http.StatusOK {
+				require.Equal(t, strconv.Itoa(int(tt.expectedDropped)),
+					resp.Header.Get(headers.DroppedMalformedHeader))
+			}
+
+			dropped, ok := scope.Snapshot().Counters()["write.series-dropped-malformed+handler=remote-write,test=malformed-test"]
+			require.True(t, ok)
+			require.Equal(t, tt.expectedDropped, dropped.Value())
+		})
+	}
+}
+
+func TestPromWriteMalformedSeriesInvalidMode(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.PromRemoteWrite.MalformedSeries = "invalid"
+	_, err := NewPromWriteHandler(opts.SetConfig(cfg))
+	require.EqualError(t, err, "unknown malformed series mode: invalid")
+}
+
+func TestPromWriteStrictM3Headers(t *testing.T) {
+	tests := []struct {
+		name         string
+		strict       bool
+		header       string
+		expectedCode int
+	}{
+		{
+			name:         "typo lenient",
+			header:       "M3-Metric-Type",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "typo strict",
+			strict:       true,
+			header:       "M3-Metric-Type",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "known strict",
+			strict:       true,
+			header:       headers.MapTagsByJSONHeader,
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedCode == http.StatusOK {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+			}
+
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.StrictM3Headers = tt.strict
+			})
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			if tt.header == headers.MapTagsByJSONHeader {
+				req.Header.Set(tt.header, `{"tagMappers":[]}`)
+			} else {
+				req.Header.Set(tt.header, "aggregated")
+			}
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			if tt.expectedCode == http.StatusBadRequest {
+				require.Contains(t, string(body), "unrecognized M3 header: M3-Metric-Type")
+			}
+		})
+	}
+}
+
+func TestPromWriteStrictContentType(t *testing.T) {
+	tests := []struct {
+		name         string
+		strict       bool
+		contentType  string
+		expectedCode int
+	}{
+		{
+			name:         "correct lenient",
+			contentType:  xhttp.ContentTypeProtobuf,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "wrong lenient",
+			contentType:  xhttp.ContentTypeJSON,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "missing lenient",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "correct strict",
+			strict:       true,
+			contentType:  xhttp.ContentTypeProtobuf,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "correct with parameters strict",
+			strict:       true,
+			contentType:  xhttp.ContentTypeProtobuf + ";proto=prometheus.WriteRequest",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "wrong strict",
+			strict:       true,
+			contentType:  xhttp.ContentTypeJSON,
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:         "missing strict",
+			strict:       true,
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedCode == http.StatusOK {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+			}
+
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.StrictContentType = tt.strict
+			})
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			if tt.contentType != "" {
+				req.Header.Set(xhttp.HeaderContentType, tt.contentType)
+			}
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, tt.expectedCode, writer.Result().StatusCode)
+		})
+	}
+}
+
+func TestPromWriteRemoteWriteVersion(t *testing.T) {
+	tests := []struct {
+		name             string
+		acceptedVersions []string
+		version          string
+		expectedCode     int
+		expectedErr      string
+	}{
+		{
+			name:             "present accepted",
+			acceptedVersions: []string{"0.1.0"},
+			version:          "0.1.0",
+			expectedCode:     http.StatusOK,
+		},
+		{
+			name:             "present rejected",
+			acceptedVersions: []string{"0.1.0"},
+			version:          "2.0.0",
+			expectedCode:     http.StatusBadRequest,
+			expectedErr:      "unsupported remote write version: 2.0.0",
+		},
+		{
+			name:             "missing",
+			acceptedVersions: []string{"0.1.0"},
+			expectedCode:     http.StatusBadRequest,
+			expectedErr:      "missing required header: X-Prometheus-Remote-Write-Version",
+		},
+		{
+			name:         "any version accepted",
+			version:      "2.0.0",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "any version missing",
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  "missing required header: X-Prometheus-Remote-Write-Version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.expectedCode == http.StatusOK {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+			}
+
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.PromRemoteWrite.RemoteWriteVersion = &handleroptions.PromWriteHandlerRemoteWriteVersionOptions{
+					AcceptedVersions: tt.acceptedVersions,
+				}
+			})
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			if tt.version != "" {
+				req.Header.Set(headers.PromRemoteWriteVersionHeader, tt.version)
+			}
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			if tt.expectedErr != "" {
+				require.Contains(t, string(body), tt.expectedErr)
+			}
+		})
+	}
+}
+
+func TestPromWriteForwardWithShadowDefaultHash(t *testing.T) {
+	testPromWriteForwardWithShadow(t, testPromWriteForwardWithShadowOptions{
+		numSeries:                    10000,
+		percent:                      0.5,
+		expectedFwded:                5000,
+		expectedFwdedAllowedVariance: 0.05,
+	})
+}
+
+func TestPromWriteForwardWithShadowXXHash(t *testing.T) {
+	testPromWriteForwardWithShadow(t, testPromWriteForwardWithShadowOptions{
+		numSeries:                    10000,
+		percent:                      0.5,
+		hash:                         "xxhash",
+		expectedFwded:                5000,
+		expectedFwdedAllowedVariance: 0.05,
+	})
+}
+
+func TestPromWriteForwardWithShadowMurmur3(t *testing.T) {
+	testPromWriteForwardWithShadow(t, testPromWriteForwardWithShadowOptions{
+		numSeries:                    10000,
+		percent:                      0.5,
+		hash:                         "murmur3",
+		expectedFwded:                5000,
+		expectedFwdedAllowedVariance: 0.05,
+	})
+}
+
+type testPromWriteForwardWithShadowOptions struct {
+	numSeries                    int
+	percent                      float64
+	hash                         string
+	expectedFwded                int64
+	expectedFwdedAllowedVariance float64
+}
+
+func testPromWriteForwardWithShadow(
+	t *testing.T,
+	testOpts testPromWriteForwardWithShadowOptions,
+) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	// Create forwarding receiver.
+	forwardRecvReqCh := make(chan *prompb.WriteRequest, 1)
+	forwardRecvSvr := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwardRecvReqCh <- test.ReadPromWriteRequestBody(t, r.Body)
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer forwardRecvSvr.Close()
+
+	target := handleroptions.PromWriteHandlerForwardTargetOptions{
+		URL:     forwardRecvSvr.URL,
+		Method:  http.MethodPost,
+		NoRetry: true,
+		Shadow: &handleroptions.PromWriteHandlerForwardTargetShadowOptions{
+			Percent: testOpts.percent,
+			Hash:    testOpts.hash,
+		},
+	}
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	// Setup opts and modify config.
+	opts := makeOptions(mockDownsamplerAndWriter)
+
+	cfg := opts.Config()
+	cfg.WriteForwarding.PromRemoteWrite.Targets = append(cfg.WriteForwarding.PromRemoteWrite.Targets, target)
+
+	opts = opts.SetConfig(cfg)
+
+	handler, err := NewPromWriteHandler(opts)
+	require.NoError(t, err)
+
+	promReq := &prompb.WriteRequest{}
+	for i := 0; i < testOpts.numSeries; i++ {
+		series := prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: []byte("__name__"), Value: []byte(fmt.Sprintf("name_%d", i))},
+			},
+			Samples: []prompb.Sample{
+				{Timestamp: time.Now().UnixMilli(), Value: 42},
+			},
+		}
+
+		// Add some labels, unsorted.
+		for j := 0; j < 5; j++ {
+			label := prompb.Label{Name: make([]byte, 16), Value: make([]byte, 16)}
+			_, err = rand.Reader.Read(label.Name)
+			require.NoError(t, err)
+			_, err = rand.Reader.Read(label.Value)
+			require.NoError(t, err)
+			// Add to start or end.
+			if j%2 == 0 {
+				series.Labels = append(series.Labels, label)
+			} else {
+				series.Labels = append([]prompb.Label{label}, series.Labels...)
+			}
+		}
+
+		promReq.Timeseries = append(promReq.Timeseries, series)
+	}
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	resp := writer.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+
+	select {
+	case fwdReq := <-forwardRecvReqCh:
+		assert.InEpsilon(t, testOpts.expectedFwded, len(fwdReq.Timeseries),
+			testOpts.expectedFwdedAllowedVariance,
+			fmt.Sprintf("expected=%v, actual=%v, allowed_variance=%v",
+				testOpts.expectedFwded, len(fwdReq.Timeseries),
+				testOpts.expectedFwdedAllowedVariance))
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for fwd request")
+	}
+}
+
+type roundTripperFn func(r *http.Request) (*http.Response, error)
+
+func (fn roundTripperFn) RoundTrip(r *http.Request) (*http.Response, error) {
+	return fn(r)
+}
+
+func newOKResponse(r *http.Request) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    r,
+	}
+}
+
+func TestPromWriteForwardPerTargetTimeout(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	handler := newTestPromWriteHandler(t, makeOptions(mockDownsamplerAndWriter), func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Timeout = 10 * time.Second
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: "http://near", NoRetry: true, Timeout: 2 * time.Second},
+			{URL: "http://far", NoRetry: true, Timeout: 30 * time.Second},
+			{URL: "http://default", NoRetry: true},
+		}
+	})
+
+	type deadline struct {
+		host      string
+		remaining time.Duration
+	}
+	deadlinesCh := make(chan deadline, len(handler.forwarding.Targets))
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			d, ok := r.Context().Deadline()
+			require.True(t, ok)
+			deadlinesCh <- deadline{host: r.URL.Host, remaining: time.Until(d)}
+			return newOKResponse(r), nil
+		}),
+	}
+
+	promReq := test.GeneratePromWriteRequest()
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	expected := map[string]time.Duration{
+		"near":    2 * time.Second,
+		"far":     30 * time.Second,
+		"default": 10 * time.Second,
+	}
+	for range expected {
+		select {
+		case d := <-deadlinesCh:
+			timeout, ok := expected[d.host]
+			require.True(t, ok, d.host)
+			assert.True(t, d.remaining <= timeout, d.host)
+			assert.True(t, d.remaining > timeout-time.Second, d.host)
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "timeout waiting for fwd request")
+		}
+	}
+}
+
+func TestPromWriteForwardActiveGauge(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(2)
+
+	scope := tally.NewTestScope("", map[string]string{"test": "forward-active-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: "http://first", NoRetry: true},
+			{URL: "http://second", NoRetry: true},
+		}
+	})
+
+	unblock := make(chan struct{})
+	completed := make(chan struct{}, 4)
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			<-unblock
+			completed <- struct{}{}
+			return newOKResponse(r), nil
+		}),
+	}
+
+	activeForwards := func() float64 {
+		gauge, ok := scope.Snapshot().Gauges()["forward.active+handler=remote-write,test=forward-active-test"]
+		if !ok {
+			return 0
+		}
+		return gauge.Value()
+	}
+
+	for i := 0; i < 2; i++ {
+		promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, req)
+		require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	}
+
+	// Two requests each forwarding to two targets.
+	require.True(t, xclock.WaitUntil(func() bool {
+		return activeForwards() == 4
+	}, 10*time.Second))
+
+	close(unblock)
+	for i := 0; i < 4; i++ {
+		<-completed
+	}
+	require.True(t, xclock.WaitUntil(func() bool {
+		return activeForwards() == 0
+	}, 10*time.Second))
+}
+
+func TestPromWriteForwardFallback(t *testing.T) {
+	tests := []struct {
+		name             string
+		failingPrimaries map[string]bool
+		expectFallback   bool
+	}{
+		{
+			name:           "primaries succeed",
+			expectFallback: false,
+		},
+		{
+			name:             "one primary fails",
+			failingPrimaries: map[string]bool{"first": true},
+			expectFallback:   false,
+		},
+		{
+			name:             "all primaries fail",
+			failingPrimaries: map[string]bool{"first": true, "second": true},
+			expectFallback:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.
+				EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+			scope := tally.NewTestScope("", map[string]string{"test": "forward-fallback-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+					{URL: "http://fallback", NoRetry: true, Fallback: true},
+					{URL: "http://first", NoRetry: true},
+					{URL: "http://second", NoRetry: true},
+				}
+			})
+
+			forwardedCh := make(chan string, 3)
+			handler.forwardHTTPClient = &http.Client{
+				Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+					forwardedCh <- r.URL.Host
+					resp := newOKResponse(r)
+					if tt.failingPrimaries[r.URL.Host] {
+						resp.StatusCode = http.StatusInternalServerError
+					}
+					return resp, nil
+				}),
+			}
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+			// Wait for the fallback decision once both primaries completed.
+			counters := func() (int64, int64) {
+				snapshot := scope.Snapshot().Counters()
+				var fallback, skipped int64
+				if c, ok := snapshot["forward.fallback+handler=remote-write,test=forward-fallback-test"]; ok {
+					fallback = c.Value()
+				}
+				if c, ok := snapshot["forward.fallback-skipped+handler=remote-write,test=forward-fallback-test"]; ok {
+					skipped = c.Value()
+				}
+				return fallback, skipped
+			}
+			require.True(t, xclock.WaitUntil(func() bool {
+				fallback, skipped := counters()
+				return fallback+skipped == 1
+			}, 10*time.Second))
+
+			expectedForwarded := map[string]struct{}{"first": {}, "second": {}}
+			if tt.expectFallback {
+				expectedForwarded["fallback"] = struct{}{}
+			}
+			forwarded := make(map[string]struct{})
+			for range expectedForwarded {
+				select {
+				case host := <-forwardedCh:
+					forwarded[host] = struct{}{}
+				case <-time.After(10 * time.Second):
+					require.FailNow(t, "timeout waiting for fwd request")
+				}
+			}
+			require.Equal(t, expectedForwarded, forwarded)
+			require.Len(t, forwardedCh, 0)
+
+			fallback, skipped := counters()
+			if tt.expectFallback {
+				require.Equal(t, int64(1), fallback)
+				require.Equal(t, int64(0), skipped)
+			} else {
+				require.Equal(t, int64(0), fallback)
+				require.Equal(t, int64(1), skipped)
+			}
+		})
+	}
+}
+
+func TestPromWriteForwardLatencyClamped(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	scope := tally.NewTestScope("", map[string]string{"test": "forward-latency-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.MaxLatency = time.Minute
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: "http://target", NoRetry: true},
+		}
+	})
+
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			return newOKResponse(r), nil
+		}),
+	}
+
+	// Two samples from the future due to clock skew, one absurdly old and one
+	// within the max latency.
+	now := time.Now()
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{{Name: []byte("__name__"), Value: []byte("skewed")}},
+				Samples: []prompb.Sample{
+					{Timestamp: now.Add(time.Hour).UnixMilli(), Value: 1},
+					{Timestamp: now.Add(time.Minute).UnixMilli(), Value: 2},
+					{Timestamp: now.Add(-time.Hour).UnixMilli(), Value: 3},
+					{Timestamp: now.UnixMilli(), Value: 4},
+				},
+			},
+		},
+	}
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	require.True(t, xclock.WaitUntil(func() bool {
+		success, ok := scope.Snapshot().Counters()["forward.success+handler=remote-write,test=forward-latency-test"]
+		return ok && success.Value() == 1
+	}, 10*time.Second))
+
+	snapshot := scope.Snapshot()
+	clamped, ok := snapshot.Counters()["forward.latency-clamped+handler=remote-write,test=forward-latency-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(3), clamped.Value())
+
+	latency, ok := snapshot.Histograms()["forward.latency+handler=remote-write,test=forward-latency-test"]
+	require.True(t, ok)
+
+	// No latency is recorded above the bucket holding the max latency.
+	maxBucket := time.Duration(math.MaxInt64)
+	for upper := range latency.Durations() {
+		if upper >= time.Minute && upper < maxBucket {
+			maxBucket = upper
+		}
+	}
+	var total int64
+	for upper, count := range latency.Durations() {
+		total += count
+		if upper > maxBucket {
+			require.Equal(t, int64(0), count, upper.String())
+		}
+	}
+	require.Equal(t, int64(4), total)
+}
+
+func TestPromWriteForwardProtocolHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming map[string]string
+		expected map[string]string
+	}{
+		{
+			name: "copied",
+			incoming: map[string]string{
+				"Content-Type":                       "application/x-protobuf",
+				"Content-Encoding":                   "snappy",
+				headers.PromRemoteWriteVersionHeader: "0.1.1",
+			},
+			expected: map[string]string{
+				"Content-Type":                       "application/x-protobuf",
+				"Content-Encoding":                   "snappy",
+				headers.PromRemoteWriteVersionHeader: "0.1.1",
+			},
+		},
+		{
+			name: "defaults",
+			expected: map[string]string{
+				"Content-Type":                       "application/x-protobuf",
+				"Content-Encoding":                   "snappy",
+				headers.PromRemoteWriteVersionHeader: "0.1.0",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.
+				EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+			opts := makeOptions(mockDownsamplerAndWriter)
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+					{URL: "http://target", NoRetry: true},
+				}
+			})
+
+			forwardedCh := make(chan http.Header, 1)
+			handler.forwardHTTPClient = &http.Client{
+				Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+					forwardedCh <- r.Header
+					return newOKResponse(r), nil
+				}),
+			}
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			for k, v := range tt.incoming {
+				req.Header.Set(k, v)
+			}
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+			select {
+			case forwarded := <-forwardedCh:
+				for k, v := range tt.expected {
+					assert.Equal(t, v, forwarded.Get(k), k)
+				}
+			case <-time.After(10 * time.Second):
+				require.FailNow(t, "timeout waiting for fwd request")
+			}
+		})
+	}
+}
+
+func TestPromWriteForwardMetricsTypeFilter(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Times(2)
+
+	scope := tally.NewTestScope("",
+		map[string]string{"test": "forward-metrics-type-test"})
+	iopts := instrument.NewOptions().SetMetricsScope(scope)
+	opts := makeOptions(mockDownsamplerAndWriter).SetInstrumentOpts(iopts)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{
+				URL:         "http://aggregated",
+				NoRetry:     true,
+				MetricsType: storagemetadata.AggregatedMetricsType,
+			},
+		}
+	})
+
+	forwardedCh := make(chan *http.Request, 2)
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			forwardedCh <- r
+			return newOKResponse(r), nil
+		}),
+	}
+
+	// Unaggregated request should be skipped.
+	promReq := test.GeneratePromWriteRequest()
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Add(headers.MetricsTypeHeader,
+		storagemetadata.UnaggregatedMetricsType.String())
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	skipped, ok := scope.Snapshot().Counters()["forward.skipped+handler=remote-write,test=forward-metrics-type-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(1), skipped.Value())
+
+	// Aggregated request should be forwarded.
+	promReqBody = test.GeneratePromWriteRequestBody(t, promReq)
+	req = httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Add(headers.MetricsTypeHeader,
+		storagemetadata.AggregatedMetricsType.String())
+	req.Header.Add(headers.MetricsStoragePolicyHeader, "1m:21d")
+	writer = httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	select {
+	case r := <-forwardedCh:
+		require.Equal(t, storagemetadata.AggregatedMetricsType.String(),
+			r.Header.Get(headers.MetricsTypeHeader))
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for fwd request")
+	}
+	require.Len(t, forwardedCh, 0)
+}
+
+func TestPromWriteForwardMaxTargetsPerRequest(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+	scope := tally.NewTestScope("",
+		map[string]string{"test": "forward-max-targets-test"})
+	iopts := instrument.NewOptions().SetMetricsScope(scope)
+	opts := makeOptions(mockDownsamplerAndWriter).SetInstrumentOpts(iopts)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.MaxTargetsPerRequest = 2
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: "http://fallback", NoRetry: true, Fallback: true},
+			{URL: "http://target-0", NoRetry: true},
+			{URL: "http://target-1", NoRetry: true},
+			{URL: "http://target-2", NoRetry: true},
+			{URL: "http://target-3", NoRetry: true},
+		}
+	})
+
+	forwardedCh := make(chan string, 5)
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			forwardedCh <- r.URL.Host
+			return newOKResponse(r), nil
+		}),
+	}
+
+	promReq := test.GeneratePromWriteRequest()
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	// Only the first primary targets are forwarded to, the fallback is
+	// skipped along with the excess primaries.
+	var forwarded []string
+	for i := 0; i < 2; i++ {
+		select {
+		case host := <-forwardedCh:
+			forwarded = append(forwarded, host)
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "timeout waiting for fwd request")
+		}
+	}
+	sort.Strings(forwarded)
+	require.Equal(t, []string{"target-0", "target-1"}, forwarded)
+	require.Len(t, forwardedCh, 0)
+
+	capped, ok := scope.Snapshot().Counters()["forward.capped+handler=remote-write,test=forward-max-targets-test"]
+	require.True(t, ok)
+	require.Equal(t, int64(3), capped.Value())
+}
+
+func TestPromWriteForwardMaxTargetsPerRequestNegative(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.WriteForwarding.PromRemoteWrite.MaxTargetsPerRequest = -1
+	opts = opts.SetConfig(cfg)
+
+	_, err := NewPromWriteHandler(opts)
+	require.Error(t, err)
+}
+
+func TestPromWriteForwardTransform(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	// Create forwarding receiver.
+	forwardRecvReqCh := make(chan *prompb.WriteRequest, 1)
+	forwardRecvSvr := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwardRecvReqCh <- test.ReadPromWriteRequestBody(t, r.Body)
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer forwardRecvSvr.Close()
+
+	var written []models.Tags
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			for iter.Next() {
+				written = append(written, iter.Current().Tags.Clone())
+			}
+			return nil
+		})
+
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetPromWriteForwardTransforms(map[string]options.PromWriteForwardTransform{
+			"rename-foo": func(req *prompb.WriteRequest) error {
+				for i := range req.Timeseries {
+					for j := range req.Timeseries[i].Labels {
+						label := &req.Timeseries[i].Labels[j]
+						if string(label.Name) == "foo" {
+							label.Name = []byte("renamed_foo")
+						}
+					}
+				}
+				return nil
+			},
+		})
+	cfg := opts.Config()
+	cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+		{URL: forwardRecvSvr.URL, NoRetry: true, Transform: "rename-foo"},
+	}
+	opts = opts.SetConfig(cfg)
+
+	handler, err := NewPromWriteHandler(opts)
+	require.NoError(t, err)
+
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	var fwdReq *prompb.WriteRequest
+	select {
+	case fwdReq = <-forwardRecvReqCh:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for fwd request")
+	}
+
+	// Label is only renamed in the forwarded request.
+	require.Len(t, fwdReq.Timeseries, 2)
+	for _, series := range fwdReq.Timeseries {
+		var names []string
+		for _, label := range series.Labels {
+			names = append(names, string(label.Name))
+		}
+		assert.Contains(t, names, "renamed_foo")
+		assert.NotContains(t, names, "foo")
+	}
+
+	require.Len(t, written, 2)
+	for _, tags := range written {
+		_, ok := tags.Get([]byte("foo"))
+		assert.True(t, ok)
+		_, ok = tags.Get([]byte("renamed_foo"))
+		assert.False(t, ok)
+	}
+}
+
+func TestPromWriteForwardSampleEvery(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	// Create forwarding receiver.
+	forwardRecvReqCh := make(chan *prompb.WriteRequest, 1)
+	forwardRecvSvr := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwardRecvReqCh <- test.ReadPromWriteRequestBody(t, r.Body)
+			w.WriteHeader(http.StatusOK)
+		}))
+	defer forwardRecvSvr.Close()
+
+	var written []int
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			for iter.Next() {
+				written = append(written, len(iter.Current().Datapoints))
+			}
+			return nil
+		})
+
+	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: forwardRecvSvr.URL, NoRetry: true, SampleEvery: 3},
+		}
+	})
+
+	newSamples := func(n int) []prompb.Sample {
+		start := time.Now().Add(-time.Minute).UnixMilli()
+		samples := make([]prompb.Sample, 0, n)
+		for i := 0; i < n; i++ {
+			samples = append(samples, prompb.Sample{Timestamp: start + int64(i), Value: float64(i)})
+		}
+		return samples
+	}
+	promReq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{Labels: testLabels("__name__", "first"), Samples: newSamples(7)},
+			{Labels: testLabels("__name__", "second"), Samples: newSamples(2)},
+		},
+	}
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	var fwdReq *prompb.WriteRequest
+	select {
+	case fwdReq = <-forwardRecvReqCh:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timeout waiting for fwd request")
+	}
+
+	// Only every third sample is forwarded, starting with the first.
+	require.Len(t, fwdReq.Timeseries, 2)
+	var values []float64
+	for _, sample := range fwdReq.Timeseries[0].Samples {
+		values = append(values, sample.Value)
+	}
+	require.Equal(t, []float64{0, 3, 6}, values)
+	require.Len(t, fwdReq.Timeseries[1].Samples, 1)
+
+	// All samples are written locally.
+	require.Equal(t, []int{7, 2}, written)
+}
+
+func TestPromWriteForwardChunkSeries(t *testing.T) {
+	tests := []struct {
+		name           string
+		failChunk      int
+		expectedChunks []int
+		expectedErrors int64
+	}{
+		{
+			name:           "all chunks acknowledged",
+			expectedChunks: []int{2, 2, 1},
+		},
+		{
+			name:           "stops at failing chunk",
+			failChunk:      2,
+			expectedChunks: []int{2, 2},
+			expectedErrors: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			mockDownsamplerAndWriter.
+				EXPECT().
+				WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+
+			scope := tally.NewTestScope("", map[string]string{"test": "forward-chunk-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+			handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+				cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+					{URL: "http://chunked", NoRetry: true, ChunkSeries: 2},
+				}
+			})
+
+			var (
+				lock       sync.Mutex
+				inflight   int32
+				concurrent bool
+				chunks     [][]string
+			)
+			handler.forwardHTTPClient = &http.Client{
+				Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+					isConcurrent := atomic.AddInt32(&inflight, 1) != 1
+					defer atomic.AddInt32(&inflight, -1)
+
+					var names []string
+					for _, series := range test.ReadPromWriteRequestBody(t, r.Body).Timeseries {
+						names = append(names, string(series.Labels[0].Value))
+					}
+					lock.Lock()
+					concurrent = concurrent || isConcurrent
+					chunks = append(chunks, names)
+					numChunks := len(chunks)
+					lock.Unlock()
+
+					if numChunks == tt.failChunk {
+						return &http.Response{
+							StatusCode: http.StatusServiceUnavailable,
+							Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+							Request:    r,
+						}, nil
+					}
+					return newOKResponse(r), nil
+				}),
+			}
+
+			promReq := &prompb.WriteRequest{}
+			for i := 0; i < 5; i++ {
+				promReq.Timeseries = append(promReq.Timeseries, prompb.TimeSeries{
+					Labels:  testLabels("__name__", fmt.Sprintf("series_%d", i)),
+					Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+				})
+			}
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+			require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+			counter := func(name string) int64 {
+				c, ok := scope.Snapshot().Counters()[name+"+handler=remote-write,test=forward-chunk-test"]
+				if !ok {
+					return 0
+				}
+				return c.Value()
+			}
+			require.True(t, xclock.WaitUntil(func() bool {
+				return counter("forward.success")+counter("forward.errors") == 1
+			}, 10*time.Second))
+			require.Equal(t, tt.expectedErrors, counter("forward.errors"))
+
+			// Series are forwarded in order in chunks of at most two series,
+			// each sent only once the previous was acknowledged.
+			lock.Lock()
+			defer lock.Unlock()
+			require.False(t, concurrent)
+			require.Len(t, chunks, len(tt.expectedChunks))
+			next := 0
+			for i, chunk := range chunks {
+				require.Len(t, chunk, tt.expectedChunks[i])
+				for _, name := range chunk {
+					require.Equal(t, fmt.Sprintf("series_%d", next), name)
+					next++
+				}
+			}
+		})
+	}
 }
 
-func TestPromWriteDisabledMetricsTypes(t *testing.T) {
+func TestPromWriteForwardBuildErrors(t *testing.T) {
 	ctrl := xtest.NewController(t)
 	defer ctrl.Finish()
 
-	var capturedIter ingest.DownsampleAndWriteIter
 	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
 	mockDownsamplerAndWriter.
 		EXPECT().
-		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
-		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
-			capturedIter = iter
-			return nil
-		})
-
-	opts := makeOptions(mockDownsamplerAndWriter).SetStoreMetricsType(false)
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
 
-	promReq := &prompb.WriteRequest{
-		Timeseries: []prompb.TimeSeries{
-			{Type: prompb.MetricType_COUNTER},
-			{},
+	var numTransforms int32
+	scope := tally.NewTestScope("", map[string]string{"test": "forward-build-errors-test"})
+	opts := makeOptions(mockDownsamplerAndWriter).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope)).
+		SetPromWriteForwardTransforms(map[string]options.PromWriteForwardTransform{
+			"failing": func(*prompb.WriteRequest) error {
+				atomic.AddInt32(&numTransforms, 1)
+				return errors.New("transform failed")
+			},
+		})
+	cfg := opts.Config()
+	cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+		{URL: "http://transform", Transform: "failing"},
+		{
+			URL:    "http://shadow",
+			Shadow: &handleroptions.PromWriteHandlerForwardTargetShadowOptions{Percent: 2},
 		},
+		{URL: "http://unreachable", NoRetry: true},
 	}
+	opts = opts.SetConfig(cfg)
 
-	executeWriteRequest(t, opts, promReq)
-
-	verifyIterValueNoAnnotation(t, capturedIter)
-	verifyIterValueNoAnnotation(t, capturedIter)
-
-	require.False(t, capturedIter.Next())
-	require.NoError(t, capturedIter.Error())
-}
-
-func TestPromWriteLiteralIsTooLongError(t *testing.T) {
-	ctrl := xtest.NewController(t)
-	defer ctrl.Finish()
-
-	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
 	handler, err := NewPromWriteHandler(opts)
 	require.NoError(t, err)
+	writeHandler := handler.(*PromWriteHandler)
 
-	veryLongLiteral := strings.Repeat("x", int(opts.TagOptions().MaxTagLiteralLength())+1)
-	promReq := &prompb.WriteRequest{
-		Timeseries: []prompb.TimeSeries{
-			{
-				Labels: []prompb.Label{
-					{Name: []byte("name1"), Value: []byte("value1")},
-					{Name: []byte("name2"), Value: []byte(veryLongLiteral)},
-				},
-			},
-		},
+	var sent sync.Map
+	writeHandler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			sent.Store(r.URL.Host, true)
+			return nil, errors.New("connection refused")
+		}),
 	}
 
-	for i := 0; i < maxLiteralIsTooLongLogCount*2; i++ {
-		promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
-		req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
-		writer := httptest.NewRecorder()
-		handler.ServeHTTP(writer, req)
-		resp := writer.Result()
-		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
-		require.NoError(t, resp.Body.Close())
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	counter := func(name string) int64 {
+		c, ok := scope.Snapshot().Counters()[name+"+handler=remote-write,test=forward-build-errors-test"]
+		if !ok {
+			return 0
+		}
+		return c.Value()
 	}
-}
+	require.True(t, xclock.WaitUntil(func() bool {
+		return counter("forward.build-errors") == 2 && counter("forward.errors") == 1
+	}, 10*time.Second))
 
-func TestPromWriteForwardWithShadowDefaultHash(t *testing.T) {
-	testPromWriteForwardWithShadow(t, testPromWriteForwardWithShadowOptions{
-		numSeries:                    10000,
-		percent:                      0.5,
-		expectedFwded:                5000,
-		expectedFwdedAllowedVariance: 0.05,
-	})
+	// Build errors are not retried and nothing is sent to their targets.
+	require.Equal(t, int32(1), atomic.LoadInt32(&numTransforms))
+	_, ok := sent.Load("transform")
+	require.False(t, ok)
+	_, ok = sent.Load("shadow")
+	require.False(t, ok)
+	_, ok = sent.Load("unreachable")
+	require.True(t, ok)
 }
 
-func TestPromWriteForwardWithShadowXXHash(t *testing.T) {
-	testPromWriteForwardWithShadow(t, testPromWriteForwardWithShadowOptions{
-		numSeries:                    10000,
-		percent:                      0.5,
-		hash:                         "xxhash",
-		expectedFwded:                5000,
-		expectedFwdedAllowedVariance: 0.05,
-	})
-}
+func TestPromWriteForwardUnknownTransform(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
 
-func TestPromWriteForwardWithShadowMurmur3(t *testing.T) {
-	testPromWriteForwardWithShadow(t, testPromWriteForwardWithShadowOptions{
-		numSeries:                    10000,
-		percent:                      0.5,
-		hash:                         "murmur3",
-		expectedFwded:                5000,
-		expectedFwdedAllowedVariance: 0.05,
-	})
-}
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+	cfg := opts.Config()
+	cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+		{URL: "http://target", Transform: "missing"},
+	}
+	opts = opts.SetConfig(cfg)
 
-type testPromWriteForwardWithShadowOptions struct {
-	numSeries                    int
-	percent                      float64
-	hash                         string
-	expectedFwded                int64
-	expectedFwdedAllowedVariance float64
+	_, err := NewPromWriteHandler(opts)
+	require.Error(t, err)
 }
 
-func testPromWriteForwardWithShadow(
-	t *testing.T,
-	testOpts testPromWriteForwardWithShadowOptions,
-) {
+func TestPromWriteForwardOverrideHeaders(t *testing.T) {
 	ctrl := xtest.NewController(t)
 	defer ctrl.Finish()
 
-	// Create forwarding receiver.
-	forwardRecvReqCh := make(chan *prompb.WriteRequest, 1)
+	forwardedHeaderCh := make(chan http.Header, 1)
 	forwardRecvSvr := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			forwardRecvReqCh <- test.ReadPromWriteRequestBody(t, r.Body)
+			forwardedHeaderCh <- r.Header.Clone()
 			w.WriteHeader(http.StatusOK)
 		}))
 	defer forwardRecvSvr.Close()
 
-	target := handleroptions.PromWriteHandlerForwardTargetOptions{
-		URL:     forwardRecvSvr.URL,
-		Method:  http.MethodPost,
-		NoRetry: true,
-		Shadow: &handleroptions.PromWriteHandlerForwardTargetShadowOptions{
-			Percent: testOpts.percent,
-			Hash:    testOpts.hash,
-		},
-	}
-
+	var writeOpts ingest.WriteOptions
 	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
 	mockDownsamplerAndWriter.
 		EXPECT().
-		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, _ ingest.DownsampleAndWriteIter, opts ingest.WriteOptions) ingest.BatchError {
+			writeOpts = opts
+			return nil
+		})
 
-	// Setup opts and modify config.
 	opts := makeOptions(mockDownsamplerAndWriter)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{
+				URL:     forwardRecvSvr.URL,
+				NoRetry: true,
+				OverrideHeaders: map[string]string{
+					headers.MetricsTypeHeader:          storagemetadata.AggregatedMetricsType.String(),
+					headers.MetricsStoragePolicyHeader: "1m:40d",
+					headers.MapTagsByJSONHeader:        "",
+				},
+			},
+		}
+	})
 
-	cfg := opts.Config()
-	cfg.WriteForwarding.PromRemoteWrite.Targets = append(cfg.WriteForwarding.PromRemoteWrite.Targets, target)
+	promReqBody := test.GeneratePromWriteRequestBody(t, test.GeneratePromWriteRequest())
+	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(headers.MetricsTypeHeader, storagemetadata.UnaggregatedMetricsType.String())
+	req.Header.Set(headers.MapTagsByJSONHeader, `{"tagMappers":[{"write":{"tag":"foo","value":"bar"}}]}`)
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
 
-	opts = opts.SetConfig(cfg)
+	metricsType, ok := writeOptionsMetricsType(writeOpts)
+	require.True(t, ok)
+	require.Equal(t, storagemetadata.UnaggregatedMetricsType, metricsType)
+
+	select {
+	case forwarded := <-forwardedHeaderCh:
+		require.Equal(t, []string{storagemetadata.AggregatedMetricsType.String()},
+			forwarded.Values(headers.MetricsTypeHeader))
+		require.Equal(t, []string{"1m:40d"},
+			forwarded.Values(headers.MetricsStoragePolicyHeader))
+		require.Empty(t, forwarded.Values(headers.MapTagsByJSONHeader))
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timed out waiting for forwarded request")
+	}
+}
+
+func TestPromWriteForwardTransport(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
 
+	opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
 	handler, err := NewPromWriteHandler(opts)
 	require.NoError(t, err)
+	transport := handler.(*PromWriteHandler).forwardHTTPClient.Transport.(*http.Transport)
+	require.Equal(t, 100, transport.MaxIdleConns)
+	require.Equal(t, 100, transport.MaxIdleConnsPerHost)
+	require.Equal(t, 60*time.Second, transport.IdleConnTimeout)
+	require.Equal(t, 0, transport.MaxConnsPerHost)
 
-	promReq := &prompb.WriteRequest{}
-	for i := 0; i < testOpts.numSeries; i++ {
-		series := prompb.TimeSeries{
-			Labels: []prompb.Label{
-				{Name: []byte("__name__"), Value: []byte(fmt.Sprintf("name_%d", i))},
-			},
-			Samples: []prompb.Sample{
-				{Timestamp: time.Now().UnixMilli(), Value: 42},
-			},
-		}
+	// Options left unset keep the default.
+	cfg := opts.Config()
+	cfg.WriteForwarding.PromRemoteWrite.Transport = &handleroptions.PromWriteHandlerForwardTransportOptions{
+		MaxIdleConns: 20,
+	}
+	handler, err = NewPromWriteHandler(opts.SetConfig(cfg))
+	require.NoError(t, err)
+	transport = handler.(*PromWriteHandler).forwardHTTPClient.Transport.(*http.Transport)
+	require.Equal(t, 20, transport.MaxIdleConns)
+	require.Equal(t, 60*time.Second, transport.IdleConnTimeout)
+	require.Equal(t, 0, transport.MaxConnsPerHost)
 
-		// Add some labels, unsorted.
-		for j := 0; j < 5; j++ {
-			label := prompb.Label{Name: make([]byte, 16), Value: make([]byte, 16)}
-			_, err = rand.Reader.Read(label.Name)
-			require.NoError(t, err)
-			_, err = rand.Reader.Read(label.Value)
-			require.NoError(t, err)
-			// Add to start or end.
-			if j%2 == 0 {
-				series.Labels = append(series.Labels, label)
-			} else {
-				series.Labels = append([]prompb.Label{label}, series.Labels...)
-			}
+	cfg.WriteForwarding.PromRemoteWrite.Transport = &handleroptions.PromWriteHandlerForwardTransportOptions{
+		MaxIdleConns:    20,
+		IdleConnTimeout: 90 * time.Second,
+		MaxConnsPerHost: 50,
+	}
+	handler, err = NewPromWriteHandler(opts.SetConfig(cfg))
+	require.NoError(t, err)
+	transport = handler.(*PromWriteHandler).forwardHTTPClient.Transport.(*http.Transport)
+	require.Equal(t, 20, transport.MaxIdleConns)
+	require.Equal(t, 20, transport.MaxIdleConnsPerHost)
+	require.Equal(t, 90*time.Second, transport.IdleConnTimeout)
+	require.Equal(t, 50, transport.MaxConnsPerHost)
+}
+
+func TestPromWriteRequestID(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	multiErr := xerrors.NewMultiError().Add(errors.New("an error"))
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(ingest.BatchError(multiErr)).
+		Times(2)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	iopts := instrument.NewOptions().SetLogger(zap.New(core))
+	opts := makeOptions(mockDownsamplerAndWriter).SetInstrumentOpts(iopts)
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.WriteForwarding.PromRemoteWrite.Targets = []handleroptions.PromWriteHandlerForwardTargetOptions{
+			{URL: "http://target", NoRetry: true},
 		}
+	})
 
-		promReq.Timeseries = append(promReq.Timeseries, series)
+	forwardedCh := make(chan struct{}, 2)
+	handler.forwardHTTPClient = &http.Client{
+		Transport: roundTripperFn(func(r *http.Request) (*http.Response, error) {
+			defer func() { forwardedCh <- struct{}{} }()
+			return nil, errors.New("forward failed")
+		}),
 	}
 
+	promReq := test.GeneratePromWriteRequest()
 	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
 	req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	req.Header.Set(headers.RequestIDHeader, "test-request-id")
 	writer := httptest.NewRecorder()
 	handler.ServeHTTP(writer, req)
 	resp := writer.Result()
-	require.Equal(t, http.StatusOK, resp.StatusCode)
-	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Equal(t, "test-request-id", resp.Header.Get(headers.RequestIDHeader))
 
-	select {
-	case fwdReq := <-forwardRecvReqCh:
-		assert.InEpsilon(t, testOpts.expectedFwded, len(fwdReq.Timeseries),
-			testOpts.expectedFwdedAllowedVariance,
-			fmt.Sprintf("expected=%v, actual=%v, allowed_variance=%v",
-				testOpts.expectedFwded, len(fwdReq.Timeseries),
-				testOpts.expectedFwdedAllowedVariance))
-	case <-time.After(10 * time.Second):
-		require.FailNow(t, "timeout waiting for fwd request")
+	<-forwardedCh
+	require.True(t, xclock.WaitUntil(func() bool {
+		return logs.FilterMessage("forward error").Len() == 1
+	}, 5*time.Second))
+
+	for _, msg := range []string{"write error", "forward error"} {
+		entries := logs.FilterMessage(msg).All()
+		require.Len(t, entries, 1, msg)
+		require.Equal(t, "test-request-id", entries[0].ContextMap()["rqID"], msg)
 	}
+
+	// Request ID is generated when not provided.
+	promReqBody = test.GeneratePromWriteRequestBody(t, promReq)
+	req = httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+	writer = httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	resp = writer.Result()
+	generated := resp.Header.Get(headers.RequestIDHeader)
+	require.NotEmpty(t, generated)
+	require.NotEqual(t, "test-request-id", generated)
+
+	entries := logs.FilterMessage("write error").All()
+	require.Len(t, entries, 2)
+	require.Equal(t, generated, entries[1].ContextMap()["rqID"])
+
+	// Parse errors are logged with the request ID too.
+	req = httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, nil)
+	req.Header.Set(headers.RequestIDHeader, "parse-request-id")
+	writer = httptest.NewRecorder()
+	handler.ServeHTTP(writer, req)
+	require.Equal(t, http.StatusBadRequest, writer.Result().StatusCode)
+	entries = logs.FilterMessage("parse error").All()
+	require.Len(t, entries, 1)
+	require.Equal(t, "parse-request-id", entries[0].ContextMap()["rqID"])
 }
 
 func BenchmarkWriteDatapoints(b *testing.B) {
@@ -604,6 +3850,149 @@ func BenchmarkWriteDatapoints(b *testing.B) {
 	}
 }
 
+func TestPromTSIterSingleSeries(t *testing.T) {
+	now := time.Now().UnixMilli()
+	tests := []struct {
+		name   string
+		series prompb.TimeSeries
+	}{
+		{
+			name: "prometheus",
+			series: prompb.TimeSeries{
+				Labels:  testLabels("__name__", "up", "job", "test"),
+				Samples: []prompb.Sample{{Timestamp: now, Value: 1}, {Timestamp: now + 1, Value: 2}},
+				Type:    prompb.MetricType_COUNTER,
+				Unit:    "seconds",
+			},
+		},
+		{
+			name: "graphite",
+			series: prompb.TimeSeries{
+				Labels:  testLabels("__g0__", "foo", "__g1__", "bar"),
+				Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+				Source:  prompb.Source_GRAPHITE,
+				M3Type:  prompb.M3Type_M3_COUNTER,
+			},
+		},
+		{
+			name: "exemplars",
+			series: prompb.TimeSeries{
+				Labels:  testLabels("__name__", "up"),
+				Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+				Exemplars: []prompb.Exemplar{
+					{Labels: testLabels("trace_id", "abc"), Value: 1, Timestamp: now},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, storeMetricsType := range []bool{true, false} {
+				series := []prompb.TimeSeries{tt.series}
+				single, err := newPromTSIter(series, nil, models.NewTagOptions(), storeMetricsType, true, true, nil, nil)
+				require.NoError(t, err)
+				multi, err := newMultiPromTSIter(series, nil, models.NewTagOptions(), storeMetricsType, true, true, nil, nil)
+				require.NoError(t, err)
+				require.True(t, &single.tags[0] == &single.single.tags[0], "fast path not used")
+
+				// Iterate twice to verify resets behave the same.
+				for i := 0; i < 2; i++ {
+					require.Equal(t, multi.Current(), single.Current())
+					require.Equal(t, multi.Next(), single.Next())
+					metadata := ts.Metadata{DropUnaggregated: i == 0}
+					multi.SetCurrentMetadata(metadata)
+					single.SetCurrentMetadata(metadata)
+					require.Equal(t, multi.Current(), single.Current())
+					require.Equal(t, multi.Next(), single.Next())
+					require.Equal(t, multi.Current(), single.Current())
+					require.Equal(t, multi.Error(), single.Error())
+					require.NoError(t, multi.Reset())
+					require.NoError(t, single.Reset())
+				}
+			}
+		})
+	}
+}
+
+func TestPromTSIterLabelsHash(t *testing.T) {
+	now := time.Now().UnixMilli()
+	series := []prompb.TimeSeries{
+		{
+			// NB: Labels are unsorted to verify the hash does not depend on
+			// their order.
+			Labels:  testLabels("job", "test", "__name__", "up"),
+			Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+			Type:    prompb.MetricType_GAUGE,
+		},
+		{
+			Labels:  testLabels("__name__", "down"),
+			Samples: []prompb.Sample{{Timestamp: now, Value: 1}},
+			Type:    prompb.MetricType_GAUGE,
+		},
+	}
+	expectedHashes := []uint64{
+		xxhash.Sum64String("__name__=up,job=test"),
+		xxhash.Sum64String("__name__=down"),
+	}
+
+	for _, numSeries := range []int{1, 2} {
+		for _, storeMetricsType := range []bool{true, false} {
+			name := fmt.Sprintf("series=%d,storeMetricsType=%v", numSeries, storeMetricsType)
+			t.Run(name, func(t *testing.T) {
+				iter, err := newPromTSIter(series[:numSeries], nil, models.NewTagOptions(),
+					storeMetricsType, false, true, nil, nil)
+				require.NoError(t, err)
+
+				for i := 0; i < numSeries; i++ {
+					require.True(t, iter.Next())
+					expected := annotation.Payload{LabelsHash: expectedHashes[i]}
+					if storeMetricsType {
+						expected.OpenMetricsFamilyType = annotation.OpenMetricsFamilyType_GAUGE
+					}
+					assert.Equal(t, expected, unmarshalAnnotation(t, iter.Current().Annotation))
+				}
+				require.False(t, iter.Next())
+				require.NoError(t, iter.Error())
+				require.Equal(t, testLabels("job", "test", "__name__", "up"), series[0].Labels)
+			})
+		}
+	}
+}
+
+func BenchmarkNewPromTSIterSingleSeries(b *testing.B) {
+	series := []prompb.TimeSeries{
+		{
+			Labels:  testLabels("__name__", "up", "job", "test"),
+			Samples: []prompb.Sample{{Timestamp: time.Now().UnixMilli(), Value: 1}},
+			Type:    prompb.MetricType_COUNTER,
+		},
+	}
+	tagOpts := models.NewTagOptions()
+
+	for _, bb := range []struct {
+		name  string
+		newFn func([]prompb.TimeSeries, []promSeriesMarkers, models.TagOptions, bool, bool,
+			bool, []byte, *promTypeInference) (*promTSIter, error)
+	}{
+		{name: "single", newFn: newPromTSIter},
+		{name: "multi", newFn: newMultiPromTSIter},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				iter, err := bb.newFn(series, nil, tagOpts, true, false, false, nil, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for iter.Next() {
+					iter.SetCurrentMetadata(ts.Metadata{})
+				}
+			}
+		})
+	}
+}
+
 func verifyIterValueAnnotation(
 	t *testing.T,
 	iter ingest.DownsampleAndWriteIter,
@@ -662,3 +4051,83 @@ func executeWriteRequest(t *testing.T, handlerOpts options.HandlerOptions, promR
 	resp := writer.Result()
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 }
+
+type promWriteRequestValidatorFn func(ctx context.Context, req *prompb.WriteRequest) error
+
+func (fn promWriteRequestValidatorFn) ValidateWriteRequest(
+	ctx context.Context,
+	req *prompb.WriteRequest,
+) error {
+	return fn(ctx, req)
+}
+
+func TestPromWriteRequestValidator(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{
+			name:         "accepted",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "rejected",
+			err:          errors.New("forbidden label combination"),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "rejected with status code",
+			err:          xhttp.NewError(errors.New("forbidden tenant"), http.StatusForbidden),
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+			if tt.err == nil {
+				mockDownsamplerAndWriter.
+					EXPECT().
+					WriteBatch(gomock.Any(), gomock.Any(), gomock.Any())
+			}
+
+			promReq := test.GeneratePromWriteRequest()
+			var validated *prompb.WriteRequest
+			scope := tally.NewTestScope("", map[string]string{"test": "request-validator-test"})
+			opts := makeOptions(mockDownsamplerAndWriter).
+				SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope)).
+				SetPromWriteRequestValidator(promWriteRequestValidatorFn(
+					func(_ context.Context, req *prompb.WriteRequest) error {
+						validated = req
+						return tt.err
+					}))
+			handler, err := NewPromWriteHandler(opts)
+			require.NoError(t, err)
+
+			promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+			req := httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+			writer := httptest.NewRecorder()
+			handler.ServeHTTP(writer, req)
+
+			resp := writer.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			require.NotNil(t, validated)
+			require.Equal(t, len(promReq.Timeseries), len(validated.Timeseries))
+
+			rejected, ok := scope.Snapshot().Counters()["write.request-rejected+handler=remote-write,test=request-validator-test"]
+			require.True(t, ok)
+			if tt.err == nil {
+				require.Equal(t, int64(0), rejected.Value())
+				return
+			}
+			require.Equal(t, int64(1), rejected.Value())
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), tt.err.Error())
+		})
+	}
+}
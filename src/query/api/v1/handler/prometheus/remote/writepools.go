@@ -0,0 +1,190 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	xerrors "github.com/m3db/m3/src/x/errors"
+
+	"github.com/uber-go/tally"
+)
+
+// defaultWritePoolName is the name of the default shared write path used
+// for series that match no write pool.
+const defaultWritePoolName = "default"
+
+// writePool isolates the writes of series matching its labels, bounding the
+// number of concurrent writes made by the pool.
+type writePool struct {
+	name        string
+	matchLabels []prompb.Label
+	tokens      chan struct{}
+	writes      tally.Counter
+}
+
+func newWritePools(
+	opts []handleroptions.PromWriteHandlerWritePoolOptions,
+	scope tally.Scope,
+) ([]*writePool, error) {
+	var (
+		pools = make([]*writePool, 0, len(opts))
+		names = make(map[string]struct{}, len(opts))
+	)
+	for _, poolOpts := range opts {
+		if poolOpts.Name == "" || poolOpts.Name == defaultWritePoolName {
+			return nil, fmt.Errorf("invalid write pool name: %q", poolOpts.Name)
+		}
+		if _, ok := names[poolOpts.Name]; ok {
+			return nil, fmt.Errorf("duplicate write pool name: %s", poolOpts.Name)
+		}
+		names[poolOpts.Name] = struct{}{}
+		if len(poolOpts.MatchLabels) == 0 {
+			return nil, fmt.Errorf("write pool %s has no match labels", poolOpts.Name)
+		}
+		if poolOpts.MaxConcurrency <= 0 {
+			return nil, fmt.Errorf("write pool %s max concurrency must be positive: %d",
+				poolOpts.Name, poolOpts.MaxConcurrency)
+		}
+
+		pools = append(pools, &writePool{
+			name:        poolOpts.Name,
+			matchLabels: newMatchLabels(poolOpts.MatchLabels),
+			tokens:      make(chan struct{}, poolOpts.MaxConcurrency),
+			writes:      newWritePoolWritesCounter(scope, poolOpts.Name),
+		})
+	}
+	return pools, nil
+}
+
+func newWritePoolWritesCounter(scope tally.Scope, name string) tally.Counter {
+	return scope.SubScope("write-pool").
+		Tagged(map[string]string{"pool": name}).
+		Counter("writes")
+}
+
+// newMatchLabels returns the labels to match series against, sorted for a
+// deterministic match order.
+func newMatchLabels(labels map[string]string) []prompb.Label {
+	matchLabels := make([]prompb.Label, 0, len(labels))
+	for name, value := range labels {
+		matchLabels = append(matchLabels, prompb.Label{
+			Name:  []byte(name),
+			Value: []byte(value),
+		})
+	}
+	sort.Sort(sortableLabels(matchLabels))
+	return matchLabels
+}
+
+// matches returns true if the series carries every label of the pool.
+func (p *writePool) matches(labels []prompb.Label) bool {
+	return matchesLabels(labels, p.matchLabels)
+}
+
+// matchesLabels returns true if the labels include every match label.
+func matchesLabels(labels, matchLabels []prompb.Label) bool {
+	for _, match := range matchLabels {
+		found := false
+		for _, l := range labels {
+			if bytes.Equal(l.Name, match.Name) && bytes.Equal(l.Value, match.Value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// writeWithPools writes the series matching a write pool by that pool and
+// the remaining series by the default shared write path.
+func (h *PromWriteHandler) writeWithPools(
+	ctx context.Context,
+	r *prompb.WriteRequest,
+	opts ingest.WriteOptions,
+) ingest.BatchError {
+	var (
+		unmatched []prompb.TimeSeries
+		matched   = make([][]prompb.TimeSeries, len(h.writePools))
+	)
+	for _, series := range r.Timeseries {
+		idx := -1
+		for i, pool := range h.writePools {
+			if pool.matches(series.Labels) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			unmatched = append(unmatched, series)
+			continue
+		}
+		matched[idx] = append(matched[idx], series)
+	}
+
+	var multiErr xerrors.MultiError
+	addErrs := func(batchErr ingest.BatchError) {
+		if batchErr == nil {
+			return
+		}
+		for _, err := range batchErr.Errors() {
+			multiErr = multiErr.Add(err)
+		}
+	}
+
+	if len(unmatched) > 0 {
+		h.metrics.defaultWritePoolWrites.Inc(1)
+		addErrs(h.writeSeries(ctx, unmatched, opts))
+	}
+
+	for i, series := range matched {
+		if len(series) == 0 {
+			continue
+		}
+
+		pool := h.writePools[i]
+		select {
+		case pool.tokens <- struct{}{}:
+		case <-ctx.Done():
+			multiErr = multiErr.Add(fmt.Errorf("write pool %s unavailable: %w",
+				pool.name, ctx.Err()))
+			continue
+		}
+
+		pool.writes.Inc(1)
+		addErrs(h.writeSeries(ctx, series, opts))
+		<-pool.tokens
+	}
+
+	if multiErr.NumErrors() == 0 {
+		return nil
+	}
+	return multiErr
+}
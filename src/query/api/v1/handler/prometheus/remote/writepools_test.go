@@ -0,0 +1,225 @@
+// Copyright (c) 2023 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remote
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/cmd/services/m3coordinator/ingest"
+	"github.com/m3db/m3/src/cmd/services/m3query/config"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/handleroptions"
+	"github.com/m3db/m3/src/query/api/v1/handler/prometheus/remote/test"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
+	"github.com/m3db/m3/src/x/instrument"
+	xtest "github.com/m3db/m3/src/x/test"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func newWritePoolsTestRequest(t *testing.T, tenants ...string) *http.Request {
+	promReq := &prompb.WriteRequest{}
+	for _, tenant := range tenants {
+		promReq.Timeseries = append(promReq.Timeseries, prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: []byte("__name__"), Value: []byte("requests")},
+				{Name: []byte("tenant"), Value: []byte(tenant)},
+			},
+			Samples: []prompb.Sample{
+				{Timestamp: time.Now().UnixMilli(), Value: 42},
+			},
+		})
+	}
+	promReqBody := test.GeneratePromWriteRequestBody(t, promReq)
+	return httptest.NewRequest(PromWriteHTTPMethod, PromWriteURL, promReqBody)
+}
+
+func newWritePoolsTestHandler(
+	t *testing.T,
+	ds ingest.DownsamplerAndWriter,
+	scope tally.Scope,
+) http.Handler {
+	opts := makeOptions(ds).
+		SetInstrumentOpts(instrument.NewOptions().SetMetricsScope(scope))
+	handler := newTestPromWriteHandler(t, opts, func(cfg *config.Configuration) {
+		cfg.PromRemoteWrite.WritePools = []handleroptions.PromWriteHandlerWritePoolOptions{
+			{
+				Name:           "hot",
+				MatchLabels:    map[string]string{"tenant": "hot"},
+				MaxConcurrency: 1,
+			},
+		}
+	})
+	return handler
+}
+
+func writtenTenants(iter ingest.DownsampleAndWriteIter) []string {
+	var tenants []string
+	for iter.Next() {
+		tenant, _ := iter.Current().Tags.Get([]byte("tenant"))
+		tenants = append(tenants, string(tenant))
+	}
+	return tenants
+}
+
+func TestPromWriteWritePoolsRouting(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var written [][]string
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			written = append(written, writtenTenants(iter))
+			return nil
+		}).
+		Times(2)
+
+	scope := tally.NewTestScope("", map[string]string{"test": "write-pools-test"})
+	handler := newWritePoolsTestHandler(t, mockDownsamplerAndWriter, scope)
+
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, newWritePoolsTestRequest(t, "hot", "cold", "hot", "other"))
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	// Unmatched series are written by the default pool first.
+	require.Equal(t, [][]string{{"cold", "other"}, {"hot", "hot"}}, written)
+
+	counters := scope.Snapshot().Counters()
+	for _, pool := range []string{"default", "hot"} {
+		writes, ok := counters["write-pool.writes+handler=remote-write,pool="+pool+",test=write-pools-test"]
+		require.True(t, ok, pool)
+		require.Equal(t, int64(1), writes.Value(), pool)
+	}
+}
+
+func TestPromWriteWritePoolsIsolation(t *testing.T) {
+	ctrl := xtest.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		hotStarted = make(chan struct{})
+		unblockHot = make(chan struct{})
+	)
+	mockDownsamplerAndWriter := ingest.NewMockDownsamplerAndWriter(ctrl)
+	mockDownsamplerAndWriter.
+		EXPECT().
+		WriteBatch(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, iter ingest.DownsampleAndWriteIter, _ ingest.WriteOptions) ingest.BatchError {
+			if tenants := writtenTenants(iter); tenants[0] == "hot" {
+				close(hotStarted)
+				<-unblockHot
+			}
+			return nil
+		}).
+		Times(2)
+
+	scope := tally.NewTestScope("", map[string]string{"test": "write-pools-test"})
+	handler := newWritePoolsTestHandler(t, mockDownsamplerAndWriter, scope)
+
+	// Occupy the only worker of the hot pool.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, newWritePoolsTestRequest(t, "hot"))
+		require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+	}()
+	<-hotStarted
+
+	// Other tenants are not blocked by the hot pool.
+	writer := httptest.NewRecorder()
+	handler.ServeHTTP(writer, newWritePoolsTestRequest(t, "cold"))
+	require.Equal(t, http.StatusOK, writer.Result().StatusCode)
+
+	// The hot tenant is confined to its pool.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	writer = httptest.NewRecorder()
+	handler.ServeHTTP(writer, newWritePoolsTestRequest(t, "hot").WithContext(ctx))
+	require.Equal(t, http.StatusInternalServerError, writer.Result().StatusCode)
+
+	close(unblockHot)
+	wg.Wait()
+}
+
+func TestPromWriteWritePoolsInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		pools []handleroptions.PromWriteHandlerWritePoolOptions
+	}{
+		{
+			name: "no name",
+			pools: []handleroptions.PromWriteHandlerWritePoolOptions{
+				{MatchLabels: map[string]string{"tenant": "hot"}, MaxConcurrency: 1},
+			},
+		},
+		{
+			name: "default name",
+			pools: []handleroptions.PromWriteHandlerWritePoolOptions{
+				{Name: "default", MatchLabels: map[string]string{"tenant": "hot"}, MaxConcurrency: 1},
+			},
+		},
+		{
+			name: "duplicate name",
+			pools: []handleroptions.PromWriteHandlerWritePoolOptions{
+				{Name: "hot", MatchLabels: map[string]string{"tenant": "hot"}, MaxConcurrency: 1},
+				{Name: "hot", MatchLabels: map[string]string{"tenant": "warm"}, MaxConcurrency: 1},
+			},
+		},
+		{
+			name: "no match labels",
+			pools: []handleroptions.PromWriteHandlerWritePoolOptions{
+				{Name: "hot", MaxConcurrency: 1},
+			},
+		},
+		{
+			name: "no max concurrency",
+			pools: []handleroptions.PromWriteHandlerWritePoolOptions{
+				{Name: "hot", MatchLabels: map[string]string{"tenant": "hot"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := xtest.NewController(t)
+			defer ctrl.Finish()
+
+			opts := makeOptions(ingest.NewMockDownsamplerAndWriter(ctrl))
+			cfg := opts.Config()
+			cfg.PromRemoteWrite.WritePools = tt.pools
+			opts = opts.SetConfig(cfg)
+
+			_, err := NewPromWriteHandler(opts)
+			require.Error(t, err)
+		})
+	}
+}
@@ -23,6 +23,7 @@ package httpd
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	// needed for pprof handler registration
 	_ "net/http/pprof"
@@ -48,6 +49,7 @@ import (
 	"github.com/m3db/m3/src/query/parser/promql"
 	"github.com/m3db/m3/src/query/util/queryhttp"
 	xdebug "github.com/m3db/m3/src/x/debug"
+	xerrors "github.com/m3db/m3/src/x/errors"
 	xhttp "github.com/m3db/m3/src/x/net/http"
 
 	"github.com/gorilla/mux"
@@ -79,6 +81,7 @@ type Handler struct {
 	customHandlers   []options.CustomHandler
 	logger           *zap.Logger
 	middlewareConfig config.MiddlewareConfiguration
+	closers          []io.Closer
 }
 
 // Router returns the http handler registered with all relevant routes for query.
@@ -86,6 +89,18 @@ func (h *Handler) Router() http.Handler {
 	return h.handler
 }
 
+// Close closes the registered handlers that hold resources, such as queued
+// writes that must be written before shutdown. It must be called once the
+// server stops serving requests.
+func (h *Handler) Close() error {
+	multiErr := xerrors.NewMultiError()
+	for _, closer := range h.closers {
+		multiErr = multiErr.Add(closer.Close())
+	}
+	h.closers = nil
+	return multiErr.FinalError()
+}
+
 // NewHandler returns a new instance of handler with routes.
 func NewHandler(
 	handlerOptions options.HandlerOptions,
@@ -138,6 +153,9 @@ func (h *Handler) RegisterRoutes() error {
 	if err != nil {
 		return err
 	}
+	if closer, ok := promRemoteWriteHandler.(io.Closer); ok {
+		h.closers = append(h.closers, closer)
+	}
 
 	nativeSourceOpts := h.options.SetInstrumentOpts(instrumentOpts.
 		SetMetricsScope(instrumentOpts.MetricsScope().
@@ -241,6 +259,29 @@ func (h *Handler) RegisterRoutes() error {
 	}); err != nil {
 		return err
 	}
+	if writeHandler, ok := promRemoteWriteHandler.(*remote.PromWriteHandler); ok {
+		if err := h.registry.Register(queryhttp.RegisterOptions{
+			Path:    remote.PromWritePauseURL,
+			Handler: writeHandler.PauseHandler(),
+			Methods: remote.PromWritePauseHTTPMethods,
+		}); err != nil {
+			return err
+		}
+		if err := h.registry.Register(queryhttp.RegisterOptions{
+			Path:    remote.PromWriteTopMetricNamesURL,
+			Handler: writeHandler.TopMetricNamesHandler(),
+			Methods: methods(remote.PromWriteTopMetricNamesHTTPMethod),
+		}); err != nil {
+			return err
+		}
+		if err := h.registry.Register(queryhttp.RegisterOptions{
+			Path:    remote.PromWriteAuditLogURL,
+			Handler: writeHandler.AuditLogHandler(),
+			Methods: methods(remote.PromWriteAuditLogHTTPMethod),
+		}); err != nil {
+			return err
+		}
+	}
 
 	// InfluxDB write endpoint.
 	if err := h.registry.Register(queryhttp.RegisterOptions{
@@ -161,6 +161,22 @@ func TestPromRemoteReadGet(t *testing.T) {
 	require.Equal(t, http.StatusBadRequest, res.Code)
 }
 
+func TestHandlerCloseClosesRemoteWrite(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	storage, _ := m3.NewStorageAndSession(t, ctrl)
+
+	h, err := setupHandler(storage)
+	require.NoError(t, err, "unable to setup handler")
+	require.NoError(t, h.RegisterRoutes(), "unable to register routes")
+
+	require.Len(t, h.closers, 1)
+	_, ok := h.closers[0].(*remote.PromWriteHandler)
+	require.True(t, ok)
+
+	require.NoError(t, h.Close())
+	require.Empty(t, h.closers)
+}
+
 func TestPromRemoteReadPost(t *testing.T) {
 	req := httptest.NewRequest("POST", remote.PromReadURL, nil)
 	res := httptest.NewRecorder()
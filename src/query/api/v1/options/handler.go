@@ -22,6 +22,7 @@
 package options
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"strings"
@@ -38,6 +39,7 @@ import (
 	"github.com/m3db/m3/src/query/api/v1/middleware"
 	"github.com/m3db/m3/src/query/api/v1/validators"
 	"github.com/m3db/m3/src/query/executor"
+	"github.com/m3db/m3/src/query/generated/proto/prompb"
 	graphite "github.com/m3db/m3/src/query/graphite/storage"
 	"github.com/m3db/m3/src/query/models"
 	"github.com/m3db/m3/src/query/storage"
@@ -282,6 +284,41 @@ type HandlerOptions interface {
 	DefaultLookback() time.Duration
 	// SetDefaultLookback sets the default value of lookback duration.
 	SetDefaultLookback(value time.Duration) HandlerOptions
+
+	// SetPromWriteForwardTransforms sets the named transforms that prom
+	// remote write forwarding targets can reference.
+	SetPromWriteForwardTransforms(value map[string]PromWriteForwardTransform) HandlerOptions
+	// PromWriteForwardTransforms returns the named transforms that prom
+	// remote write forwarding targets can reference.
+	PromWriteForwardTransforms() map[string]PromWriteForwardTransform
+
+	// SetPromWriteLabelCardinalityBackend sets the backend that prom remote
+	// write label cardinality limiting shares across coordinators.
+	SetPromWriteLabelCardinalityBackend(value PromWriteLabelCardinalityBackend) HandlerOptions
+	// PromWriteLabelCardinalityBackend returns the backend that prom remote
+	// write label cardinality limiting shares across coordinators.
+	PromWriteLabelCardinalityBackend() PromWriteLabelCardinalityBackend
+
+	// SetPromWriteSecondaryDownsamplerAndWriter sets the downsampler and
+	// writer that prom remote writes are also written to as a hot standby.
+	SetPromWriteSecondaryDownsamplerAndWriter(value ingest.DownsamplerAndWriter) HandlerOptions
+	// PromWriteSecondaryDownsamplerAndWriter returns the downsampler and
+	// writer that prom remote writes are also written to as a hot standby.
+	PromWriteSecondaryDownsamplerAndWriter() ingest.DownsamplerAndWriter
+
+	// SetPromWriteMetadataStore sets the store that metric metadata sent with
+	// prom remote writes is written to.
+	SetPromWriteMetadataStore(value PromWriteMetadataStore) HandlerOptions
+	// PromWriteMetadataStore returns the store that metric metadata sent with
+	// prom remote writes is written to.
+	PromWriteMetadataStore() PromWriteMetadataStore
+
+	// SetPromWriteRequestValidator sets the validator that prom remote write
+	// requests must pass before they are written.
+	SetPromWriteRequestValidator(value PromWriteRequestValidator) HandlerOptions
+	// PromWriteRequestValidator returns the validator that prom remote write
+	// requests must pass before they are written.
+	PromWriteRequestValidator() PromWriteRequestValidator
 }
 
 // HandlerOptions represents handler options.
@@ -316,6 +353,11 @@ type handlerOptions struct {
 	graphiteRenderRouter              GraphiteRenderRouter
 	graphiteFindRouter                GraphiteFindRouter
 	defaultLookback                   time.Duration
+	promWriteForwardTransforms        map[string]PromWriteForwardTransform
+	promWriteLabelCardinalityBackend  PromWriteLabelCardinalityBackend
+	promWriteSecondaryWriter          ingest.DownsamplerAndWriter
+	promWriteMetadataStore            PromWriteMetadataStore
+	promWriteRequestValidator         PromWriteRequestValidator
 }
 
 // EmptyHandlerOptions returns  default handler options.
@@ -713,5 +755,112 @@ func (o *handlerOptions) SetDefaultLookback(value time.Duration) HandlerOptions
 	return &opts
 }
 
+func (o *handlerOptions) SetPromWriteForwardTransforms(
+	value map[string]PromWriteForwardTransform,
+) HandlerOptions {
+	opts := *o
+	opts.promWriteForwardTransforms = value
+	return &opts
+}
+
+func (o *handlerOptions) PromWriteForwardTransforms() map[string]PromWriteForwardTransform {
+	return o.promWriteForwardTransforms
+}
+
+func (o *handlerOptions) SetPromWriteLabelCardinalityBackend(
+	value PromWriteLabelCardinalityBackend,
+) HandlerOptions {
+	opts := *o
+	opts.promWriteLabelCardinalityBackend = value
+	return &opts
+}
+
+func (o *handlerOptions) PromWriteLabelCardinalityBackend() PromWriteLabelCardinalityBackend {
+	return o.promWriteLabelCardinalityBackend
+}
+
+func (o *handlerOptions) SetPromWriteSecondaryDownsamplerAndWriter(
+	value ingest.DownsamplerAndWriter,
+) HandlerOptions {
+	opts := *o
+	opts.promWriteSecondaryWriter = value
+	return &opts
+}
+
+func (o *handlerOptions) PromWriteSecondaryDownsamplerAndWriter() ingest.DownsamplerAndWriter {
+	return o.promWriteSecondaryWriter
+}
+
+func (o *handlerOptions) SetPromWriteMetadataStore(
+	value PromWriteMetadataStore,
+) HandlerOptions {
+	opts := *o
+	opts.promWriteMetadataStore = value
+	return &opts
+}
+
+func (o *handlerOptions) PromWriteMetadataStore() PromWriteMetadataStore {
+	return o.promWriteMetadataStore
+}
+
+func (o *handlerOptions) SetPromWriteRequestValidator(
+	value PromWriteRequestValidator,
+) HandlerOptions {
+	opts := *o
+	opts.promWriteRequestValidator = value
+	return &opts
+}
+
+func (o *handlerOptions) PromWriteRequestValidator() PromWriteRequestValidator {
+	return o.promWriteRequestValidator
+}
+
 // KVStoreProtoParser parses protobuf messages based off specific keys.
 type KVStoreProtoParser func(key string) (protoiface.MessageV1, error)
+
+// PromWriteForwardTransform transforms a decoded prom remote write request
+// before it is re-encoded and forwarded to a target, the request is a copy
+// so changes do not affect the locally written data.
+type PromWriteForwardTransform func(req *prompb.WriteRequest) error
+
+// PromWriteLabelCardinalityBackend tracks the distinct values of watched
+// label names in a store shared across coordinators, so that prom remote
+// write label cardinality is limited globally rather than per process.
+type PromWriteLabelCardinalityBackend interface {
+	// Admit tracks the values of each label name in a single call, returning
+	// for each value whether it was admitted. A value is admitted if it is
+	// already tracked or if fewer than limit distinct values are tracked for
+	// its label name.
+	Admit(
+		ctx context.Context,
+		values map[string][][]byte,
+		limit uint64,
+	) (map[string][]bool, error)
+}
+
+// PromWriteMetricMetadata is the metadata of a metric family sent with a
+// prom remote write request.
+type PromWriteMetricMetadata struct {
+	Type             prompb.MetricType
+	MetricFamilyName string
+	Help             string
+	Unit             string
+}
+
+// PromWriteMetadataStore stores the metric metadata sent with prom remote
+// write requests.
+type PromWriteMetadataStore interface {
+	// WriteMetadata writes the metadata sent with a single request.
+	WriteMetadata(ctx context.Context, metadata []PromWriteMetricMetadata) error
+}
+
+// PromWriteRequestValidator validates prom remote write requests once parsed
+// and before they are forwarded or written, for rules that the built in
+// validation does not cover.
+type PromWriteRequestValidator interface {
+	// ValidateWriteRequest returns an error to reject the request, which is
+	// responded to with a 400 unless the error is an xhttp.Error, whose
+	// status code is used instead, e.g. a 403 returned with
+	// xhttp.NewError(err, http.StatusForbidden).
+	ValidateWriteRequest(ctx context.Context, req *prompb.WriteRequest) error
+}
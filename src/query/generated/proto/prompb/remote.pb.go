@@ -35,7 +35,10 @@
 		Query
 		QueryResult
 		Sample
+		Exemplar
 		TimeSeries
+		PackedHistogram
+		HistogramBucket
 		Label
 		Labels
 		LabelMatcher
@@ -149,7 +149,7 @@ var LabelMatcher_Type_value = map[string]int32{
 func (x LabelMatcher_Type) String() string {
 	return proto.EnumName(LabelMatcher_Type_name, int32(x))
 }
-func (LabelMatcher_Type) EnumDescriptor() ([]byte, []int) { return fileDescriptorTypes, []int{4, 0} }
+func (LabelMatcher_Type) EnumDescriptor() ([]byte, []int) { return fileDescriptorTypes, []int{7, 0} }
 
 type Sample struct {
 	Value     float64 `protobuf:"fixed64,1,opt,name=value,proto3" json:"value,omitempty"`
@@ -175,6 +175,38 @@ func (m *Sample) GetTimestamp() int64 {
 	return 0
 }
 
+type Exemplar struct {
+	Labels    []Label `protobuf:"bytes,1,rep,name=labels" json:"labels"`
+	Value     float64 `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	Timestamp int64   `protobuf:"varint,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+}
+
+func (m *Exemplar) Reset()                    { *m = Exemplar{} }
+func (m *Exemplar) String() string            { return proto.CompactTextString(m) }
+func (*Exemplar) ProtoMessage()               {}
+func (*Exemplar) Descriptor() ([]byte, []int) { return fileDescriptorTypes, []int{1} }
+
+func (m *Exemplar) GetLabels() []Label {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+func (m *Exemplar) GetValue() float64 {
+	if m != nil {
+		return m.Value
+	}
+	return 0
+}
+
+func (m *Exemplar) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
 type TimeSeries struct {
 	Labels  []Label    `protobuf:"bytes,1,rep,name=labels" json:"labels"`
 	Samples []Sample   `protobuf:"bytes,2,rep,name=samples" json:"samples"`
@@ -185,12 +217,21 @@ type TimeSeries struct {
 	// should never clash with prometheus fields.
 	M3Type M3Type `protobuf:"varint,101,opt,name=m3_type,json=m3Type,proto3,enum=m3prometheus.M3Type" json:"m3_type,omitempty"`
 	Source Source `protobuf:"varint,102,opt,name=source,proto3,enum=m3prometheus.Source" json:"source,omitempty"`
+	// NB: Prometheus sends exemplars as field 3 which clashes with the type
+	// field above, so the write handler remaps them to this field before
+	// decoding when exemplar ingestion is enabled.
+	Exemplars []Exemplar `protobuf:"bytes,103,rep,name=exemplars" json:"exemplars"`
+	// NB: Set by sources that pack the buckets of a histogram into the series
+	// rather than writing separate bucket series, the write handler expands
+	// them into bucket, sum and count series when packed histograms are
+	// enabled.
+	PackedHistograms []PackedHistogram `protobuf:"bytes,106,rep,name=packed_histograms,json=packedHistograms" json:"packed_histograms"`
 }
 
 func (m *TimeSeries) Reset()                    { *m = TimeSeries{} }
 func (m *TimeSeries) String() string            { return proto.CompactTextString(m) }
 func (*TimeSeries) ProtoMessage()               {}
-func (*TimeSeries) Descriptor() ([]byte, []int) { return fileDescriptorTypes, []int{1} }
+func (*TimeSeries) Descriptor() ([]byte, []int) { return fileDescriptorTypes, []int{2} }
 
 func (m *TimeSeries) GetLabels() []Label {
 	if m != nil {
@@ -241,6 +282,86 @@ func (m *TimeSeries) GetSource() Source {
 	return Source_PROMETHEUS
 }
 
+func (m *TimeSeries) GetExemplars() []Exemplar {
+	if m != nil {
+		return m.Exemplars
+	}
+	return nil
+}
+
+func (m *TimeSeries) GetPackedHistograms() []PackedHistogram {
+	if m != nil {
+		return m.PackedHistograms
+	}
+	return nil
+}
+
+type PackedHistogram struct {
+	Timestamp int64             `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Sum       float64           `protobuf:"fixed64,2,opt,name=sum,proto3" json:"sum,omitempty"`
+	Count     float64           `protobuf:"fixed64,3,opt,name=count,proto3" json:"count,omitempty"`
+	Buckets   []HistogramBucket `protobuf:"bytes,4,rep,name=buckets" json:"buckets"`
+}
+
+func (m *PackedHistogram) Reset()                    { *m = PackedHistogram{} }
+func (m *PackedHistogram) String() string            { return proto.CompactTextString(m) }
+func (*PackedHistogram) ProtoMessage()               {}
+func (*PackedHistogram) Descriptor() ([]byte, []int) { return fileDescriptorTypes, []int{3} }
+
+func (m *PackedHistogram) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *PackedHistogram) GetSum() float64 {
+	if m != nil {
+		return m.Sum
+	}
+	return 0
+}
+
+func (m *PackedHistogram) GetCount() float64 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+func (m *PackedHistogram) GetBuckets() []HistogramBucket {
+	if m != nil {
+		return m.Buckets
+	}
+	return nil
+}
+
+type HistogramBucket struct {
+	// NB: The count is cumulative, i.e. the number of observations less than
+	// or equal to the upper bound.
+	UpperBound float64 `protobuf:"fixed64,1,opt,name=upper_bound,json=upperBound,proto3" json:"upper_bound,omitempty"`
+	Count      float64 `protobuf:"fixed64,2,opt,name=count,proto3" json:"count,omitempty"`
+}
+
+func (m *HistogramBucket) Reset()                    { *m = HistogramBucket{} }
+func (m *HistogramBucket) String() string            { return proto.CompactTextString(m) }
+func (*HistogramBucket) ProtoMessage()               {}
+func (*HistogramBucket) Descriptor() ([]byte, []int) { return fileDescriptorTypes, []int{4} }
+
+func (m *HistogramBucket) GetUpperBound() float64 {
+	if m != nil {
+		return m.UpperBound
+	}
+	return 0
+}
+
+func (m *HistogramBucket) GetCount() float64 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
 type Label struct {
 	Name  []byte `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
 	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
@@ -249,7 +370,7 @@ type Label struct {
 func (m *Label) Reset()                    { *m = Label{} }
 func (m *Label) String() string            { return proto.CompactTextString(m) }
 func (*Label) ProtoMessage()               {}
-func (*Label) Descriptor() ([]byte, []int) { return fileDescriptorTypes, []int{2} }
+func (*Label) Descriptor() ([]byte, []int) { return fileDescriptorTypes, []int{5} }
 
 func (m *Label) GetName() []byte {
 	if m != nil {
@@ -272,7 +393,7 @@ type Labels struct {
 func (m *Labels) Reset()                    { *m = Labels{} }
 func (m *Labels) String() string            { return proto.CompactTextString(m) }
 func (*Labels) ProtoMessage()               {}
-func (*Labels) Descriptor() ([]byte, []int) { return fileDescriptorTypes, []int{3} }
+func (*Labels) Descriptor() ([]byte, []int) { return fileDescriptorTypes, []int{6} }
 
 func (m *Labels) GetLabels() []Label {
 	if m != nil {
@@ -291,7 +412,7 @@ type LabelMatcher struct {
 func (m *LabelMatcher) Reset()                    { *m = LabelMatcher{} }
 func (m *LabelMatcher) String() string            { return proto.CompactTextString(m) }
 func (*LabelMatcher) ProtoMessage()               {}
-func (*LabelMatcher) Descriptor() ([]byte, []int) { return fileDescriptorTypes, []int{4} }
+func (*LabelMatcher) Descriptor() ([]byte, []int) { return fileDescriptorTypes, []int{7} }
 
 func (m *LabelMatcher) GetType() LabelMatcher_Type {
 	if m != nil {
@@ -316,7 +437,10 @@ func (m *LabelMatcher) GetValue() []byte {
 
 func init() {
 	proto.RegisterType((*Sample)(nil), "m3prometheus.Sample")
+	proto.RegisterType((*Exemplar)(nil), "m3prometheus.Exemplar")
 	proto.RegisterType((*TimeSeries)(nil), "m3prometheus.TimeSeries")
+	proto.RegisterType((*PackedHistogram)(nil), "m3prometheus.PackedHistogram")
+	proto.RegisterType((*HistogramBucket)(nil), "m3prometheus.HistogramBucket")
 	proto.RegisterType((*Label)(nil), "m3prometheus.Label")
 	proto.RegisterType((*Labels)(nil), "m3prometheus.Labels")
 	proto.RegisterType((*LabelMatcher)(nil), "m3prometheus.LabelMatcher")
@@ -354,6 +478,47 @@ func (m *Sample) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
+func (m *Exemplar) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Exemplar) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Labels) > 0 {
+		for _, msg := range m.Labels {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintTypes(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if m.Value != 0 {
+		dAtA[i] = 0x11
+		i++
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Value))))
+		i += 8
+	}
+	if m.Timestamp != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintTypes(dAtA, i, uint64(m.Timestamp))
+	}
+	return i, nil
+}
+
 func (m *TimeSeries) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -424,6 +589,111 @@ func (m *TimeSeries) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintTypes(dAtA, i, uint64(m.Source))
 	}
+	if len(m.Exemplars) > 0 {
+		for _, msg := range m.Exemplars {
+			dAtA[i] = 0xba
+			i++
+			dAtA[i] = 0x6
+			i++
+			i = encodeVarintTypes(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.PackedHistograms) > 0 {
+		for _, msg := range m.PackedHistograms {
+			dAtA[i] = 0xd2
+			i++
+			dAtA[i] = 0x6
+			i++
+			i = encodeVarintTypes(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return i, nil
+}
+
+func (m *PackedHistogram) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *PackedHistogram) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Timestamp != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintTypes(dAtA, i, uint64(m.Timestamp))
+	}
+	if m.Sum != 0 {
+		dAtA[i] = 0x11
+		i++
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Sum))))
+		i += 8
+	}
+	if m.Count != 0 {
+		dAtA[i] = 0x19
+		i++
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Count))))
+		i += 8
+	}
+	if len(m.Buckets) > 0 {
+		for _, msg := range m.Buckets {
+			dAtA[i] = 0x22
+			i++
+			i = encodeVarintTypes(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return i, nil
+}
+
+func (m *HistogramBucket) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *HistogramBucket) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.UpperBound != 0 {
+		dAtA[i] = 0x9
+		i++
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.UpperBound))))
+		i += 8
+	}
+	if m.Count != 0 {
+		dAtA[i] = 0x11
+		i++
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Count))))
+		i += 8
+	}
 	return i, nil
 }
 
@@ -543,6 +813,24 @@ func (m *Sample) Size() (n int) {
 	return n
 }
 
+func (m *Exemplar) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Labels) > 0 {
+		for _, e := range m.Labels {
+			l = e.Size()
+			n += 1 + l + sovTypes(uint64(l))
+		}
+	}
+	if m.Value != 0 {
+		n += 9
+	}
+	if m.Timestamp != 0 {
+		n += 1 + sovTypes(uint64(m.Timestamp))
+	}
+	return n
+}
+
 func (m *TimeSeries) Size() (n int) {
 	var l int
 	_ = l
@@ -575,6 +863,51 @@ func (m *TimeSeries) Size() (n int) {
 	if m.Source != 0 {
 		n += 2 + sovTypes(uint64(m.Source))
 	}
+	if len(m.Exemplars) > 0 {
+		for _, e := range m.Exemplars {
+			l = e.Size()
+			n += 2 + l + sovTypes(uint64(l))
+		}
+	}
+	if len(m.PackedHistograms) > 0 {
+		for _, e := range m.PackedHistograms {
+			l = e.Size()
+			n += 2 + l + sovTypes(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *PackedHistogram) Size() (n int) {
+	var l int
+	_ = l
+	if m.Timestamp != 0 {
+		n += 1 + sovTypes(uint64(m.Timestamp))
+	}
+	if m.Sum != 0 {
+		n += 9
+	}
+	if m.Count != 0 {
+		n += 9
+	}
+	if len(m.Buckets) > 0 {
+		for _, e := range m.Buckets {
+			l = e.Size()
+			n += 1 + l + sovTypes(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *HistogramBucket) Size() (n int) {
+	var l int
+	_ = l
+	if m.UpperBound != 0 {
+		n += 9
+	}
+	if m.Count != 0 {
+		n += 9
+	}
 	return n
 }
 
@@ -714,7 +1047,7 @@ func (m *Sample) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *TimeSeries) Unmarshal(dAtA []byte) error {
+func (m *Exemplar) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -737,10 +1070,10 @@ func (m *TimeSeries) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: TimeSeries: wiretype end group for non-group")
+			return fmt.Errorf("proto: Exemplar: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: TimeSeries: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: Exemplar: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
@@ -775,10 +1108,21 @@ func (m *TimeSeries) Unmarshal(dAtA []byte) error {
 			}
 			iNdEx = postIndex
 		case 2:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Samples", wireType)
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
 			}
-			var msglen int
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.Value = float64(math.Float64frombits(v))
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Timestamp", wireType)
+			}
+			m.Timestamp = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowTypes
@@ -788,12 +1132,112 @@ func (m *TimeSeries) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= (int(b) & 0x7F) << shift
+				m.Timestamp |= (int64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+		default:
+			iNdEx = preIndex
+			skippy, err := skipTypes(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthTypes
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *TimeSeries) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowTypes
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: TimeSeries: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: TimeSeries: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTypes
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthTypes
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Labels = append(m.Labels, Label{})
+			if err := m.Labels[len(m.Labels)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Samples", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTypes
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
 				return ErrInvalidLengthTypes
 			}
 			postIndex := iNdEx + msglen
@@ -920,6 +1364,262 @@ func (m *TimeSeries) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 103:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Exemplars", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTypes
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthTypes
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Exemplars = append(m.Exemplars, Exemplar{})
+			if err := m.Exemplars[len(m.Exemplars)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 106:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PackedHistograms", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTypes
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthTypes
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PackedHistograms = append(m.PackedHistograms, PackedHistogram{})
+			if err := m.PackedHistograms[len(m.PackedHistograms)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipTypes(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthTypes
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *PackedHistogram) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowTypes
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: PackedHistogram: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: PackedHistogram: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Timestamp", wireType)
+			}
+			m.Timestamp = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTypes
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Timestamp |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Sum", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.Sum = float64(math.Float64frombits(v))
+		case 3:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Count", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.Count = float64(math.Float64frombits(v))
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Buckets", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTypes
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthTypes
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Buckets = append(m.Buckets, HistogramBucket{})
+			if err := m.Buckets[len(m.Buckets)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipTypes(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthTypes
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *HistogramBucket) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowTypes
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: HistogramBucket: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: HistogramBucket: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field UpperBound", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.UpperBound = float64(math.Float64frombits(v))
+		case 2:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Count", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.Count = float64(math.Float64frombits(v))
 		default:
 			iNdEx = preIndex
 			skippy, err := skipTypes(dAtA[iNdEx:])
@@ -1375,43 +2075,53 @@ func init() {
 }
 
 var fileDescriptorTypes = []byte{
-	// 605 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x53, 0xcd, 0x6a, 0xdb, 0x4c,
-	0x14, 0xf5, 0x48, 0xb2, 0x1c, 0xdf, 0xf8, 0xcb, 0x37, 0x4c, 0xb2, 0x10, 0xa5, 0x38, 0xc6, 0x2b,
-	0x13, 0x12, 0x8b, 0x44, 0x59, 0x14, 0x5a, 0x28, 0x4e, 0x98, 0x3a, 0xa6, 0x91, 0x9c, 0x8c, 0x64,
-	0x4a, 0xbb, 0x31, 0xb2, 0x33, 0xb1, 0x0d, 0x9e, 0x48, 0xd5, 0x4f, 0x21, 0x7d, 0x8a, 0xee, 0xfa,
-	0x0a, 0x7d, 0x94, 0x2c, 0xfb, 0x04, 0xa5, 0xa4, 0x2f, 0x52, 0x66, 0xc6, 0xc5, 0x49, 0xc9, 0xa6,
-	0x1b, 0x7b, 0xee, 0xb9, 0xe7, 0xdc, 0x7b, 0x74, 0x86, 0x81, 0xd7, 0xb3, 0x45, 0x31, 0x2f, 0x27,
-	0xdd, 0x69, 0x22, 0x5c, 0xe1, 0x5d, 0x4d, 0x5c, 0xe1, 0xb9, 0x79, 0x36, 0x75, 0x3f, 0x96, 0x3c,
-	0xbb, 0x75, 0x67, 0xfc, 0x86, 0x67, 0x71, 0xc1, 0xaf, 0xdc, 0x34, 0x4b, 0x8a, 0x44, 0xfe, 0x8a,
-	0x74, 0xe2, 0x16, 0xb7, 0x29, 0xcf, 0xbb, 0x0a, 0x22, 0x0d, 0xe1, 0x49, 0x94, 0x17, 0x73, 0x5e,
-	0xe6, 0xcf, 0x0e, 0x1e, 0x8c, 0x9b, 0x25, 0xb3, 0x44, 0xeb, 0x26, 0xe5, 0xb5, 0xaa, 0xf4, 0x10,
-	0x79, 0xd2, 0xe2, 0xf6, 0x2b, 0xb0, 0xc3, 0x58, 0xa4, 0x4b, 0x4e, 0x76, 0xa0, 0xfa, 0x29, 0x5e,
-	0x96, 0xdc, 0x41, 0x2d, 0xd4, 0x41, 0x4c, 0x17, 0xe4, 0x39, 0xd4, 0x8b, 0x85, 0xe0, 0x79, 0x11,
-	0x8b, 0xd4, 0x31, 0x5a, 0xa8, 0x63, 0xb2, 0x35, 0xd0, 0xfe, 0x66, 0x00, 0x44, 0x0b, 0xc1, 0x43,
-	0x9e, 0x2d, 0x78, 0x4e, 0x0e, 0xc1, 0x5e, 0xc6, 0x13, 0xbe, 0xcc, 0x1d, 0xd4, 0x32, 0x3b, 0x9b,
-	0x47, 0xdb, 0xdd, 0x87, 0xd6, 0xba, 0xe7, 0xb2, 0x77, 0x62, 0xdd, 0xfd, 0xd8, 0xad, 0xb0, 0x15,
-	0x91, 0x1c, 0x43, 0x2d, 0x57, 0xfb, 0x73, 0xc7, 0x50, 0x9a, 0x9d, 0xc7, 0x1a, 0x6d, 0x6e, 0x25,
-	0xfa, 0x43, 0x25, 0xfb, 0x60, 0xc9, 0x04, 0x1c, 0xb3, 0x85, 0x3a, 0x5b, 0x47, 0xce, 0x63, 0x89,
-	0xcf, 0x8b, 0x6c, 0x31, 0x8d, 0x6e, 0x53, 0xce, 0x14, 0x8b, 0x10, 0xb0, 0xca, 0x9b, 0x45, 0xe1,
-	0x58, 0x2d, 0xd4, 0xa9, 0x33, 0x75, 0x96, 0xd8, 0x9c, 0x2f, 0x53, 0xa7, 0xaa, 0x31, 0x79, 0x26,
-	0x07, 0x50, 0x13, 0xde, 0x58, 0x0d, 0xe6, 0x6a, 0xf0, 0x5f, 0x5e, 0x7c, 0x4f, 0x0d, 0xb5, 0x85,
-	0xfa, 0x27, 0xfb, 0x60, 0xe7, 0x49, 0x99, 0x4d, 0xb9, 0x73, 0xfd, 0x14, 0x3b, 0x54, 0x3d, 0xb6,
-	0xe2, 0xb4, 0x0f, 0xa1, 0xaa, 0xbe, 0x5f, 0x6e, 0xbe, 0x89, 0x85, 0x8e, 0xb9, 0xc1, 0xd4, 0x79,
-	0x9d, 0xbd, 0xa1, 0x40, 0x5d, 0xb4, 0x5f, 0x82, 0x7d, 0xae, 0x53, 0xfa, 0xf7, 0x60, 0xdb, 0x5f,
-	0x11, 0x34, 0x14, 0xee, 0xc7, 0xc5, 0x74, 0xce, 0x33, 0xe2, 0xad, 0x32, 0x43, 0xca, 0xec, 0xee,
-	0x13, 0x13, 0x56, 0xcc, 0xee, 0xe3, 0xe8, 0x94, 0x59, 0xe3, 0x29, 0xb3, 0xe6, 0x43, 0xb3, 0x1d,
-	0xb0, 0x54, 0x2a, 0x36, 0x18, 0xf4, 0x12, 0x57, 0x48, 0x0d, 0xcc, 0x80, 0x5e, 0x62, 0x24, 0x01,
-	0x46, 0xb1, 0xa1, 0x00, 0x46, 0xb1, 0xb9, 0xf7, 0x19, 0x60, 0x7d, 0x45, 0x64, 0x13, 0x6a, 0xa3,
-	0xe0, 0x6d, 0x30, 0x7c, 0x17, 0xe0, 0x8a, 0x2c, 0x4e, 0x87, 0xa3, 0x20, 0xa2, 0x0c, 0x23, 0x52,
-	0x87, 0x6a, 0xbf, 0x37, 0xea, 0x4b, 0xed, 0x7f, 0x50, 0x3f, 0x1b, 0x84, 0xd1, 0xb0, 0xcf, 0x7a,
-	0x3e, 0x36, 0xc9, 0x36, 0xfc, 0xaf, 0x3a, 0xe3, 0x35, 0x68, 0x49, 0x6d, 0x38, 0xf2, 0xfd, 0x1e,
-	0x7b, 0x8f, 0xab, 0x64, 0x03, 0xac, 0x41, 0xf0, 0x66, 0x88, 0x6d, 0xd2, 0x80, 0x8d, 0x30, 0xea,
-	0x45, 0x34, 0xa4, 0x11, 0xae, 0xed, 0x1d, 0x83, 0xad, 0x6f, 0x51, 0xe2, 0xbe, 0x37, 0xd6, 0x0b,
-	0x2a, 0x64, 0x0b, 0xc0, 0xf7, 0xc6, 0xeb, 0xdd, 0xba, 0x1b, 0x0d, 0x7c, 0xca, 0xb0, 0xb1, 0xf7,
-	0x02, 0x6c, 0x7d, 0x9b, 0x92, 0x77, 0xc1, 0x86, 0x3e, 0x8d, 0xce, 0xe8, 0x28, 0xc4, 0x15, 0xc9,
-	0xeb, 0xb3, 0xde, 0xc5, 0xd9, 0x20, 0xa2, 0x18, 0x11, 0x0c, 0x8d, 0xe1, 0x05, 0x0d, 0xc6, 0x3e,
-	0x8d, 0xd8, 0xe0, 0x34, 0xc4, 0xc6, 0x89, 0x73, 0x77, 0xdf, 0x44, 0xdf, 0xef, 0x9b, 0xe8, 0xe7,
-	0x7d, 0x13, 0x7d, 0xf9, 0xd5, 0xac, 0x7c, 0xb0, 0xf5, 0x0b, 0x9e, 0xd8, 0xea, 0xfd, 0x79, 0xbf,
-	0x03, 0x00, 0x00, 0xff, 0xff, 0x3e, 0xb3, 0xe8, 0x2d, 0xff, 0x03, 0x00, 0x00,
+	// 762 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0xdd, 0x6e, 0xe2, 0x46,
+	0x18, 0x65, 0x6c, 0x63, 0xc2, 0x17, 0xba, 0x99, 0xce, 0xae, 0x2a, 0xab, 0x6a, 0x09, 0xe2, 0x0a,
+	0x45, 0xbb, 0xa0, 0x5d, 0xe7, 0xa2, 0xea, 0x8f, 0x2a, 0x12, 0xb9, 0x80, 0x1a, 0x03, 0x19, 0x1b,
+	0x55, 0xed, 0x0d, 0xb2, 0xcd, 0x04, 0x68, 0x30, 0x76, 0xfc, 0x53, 0x35, 0x7d, 0x8a, 0x5e, 0xb5,
+	0x6f, 0xd2, 0x67, 0xc8, 0x65, 0x9f, 0xa0, 0xaa, 0xd2, 0x17, 0xa9, 0x66, 0x6c, 0x64, 0x8c, 0xe8,
+	0x45, 0xf7, 0x06, 0x66, 0xce, 0x9c, 0xf3, 0x7d, 0xc7, 0xfe, 0xce, 0x18, 0xbe, 0x5e, 0xae, 0x93,
+	0x55, 0xea, 0x76, 0xbd, 0xc0, 0xef, 0xf9, 0xfa, 0xc2, 0xed, 0xf9, 0x7a, 0x2f, 0x8e, 0xbc, 0xde,
+	0x43, 0xca, 0xa2, 0xc7, 0xde, 0x92, 0x6d, 0x59, 0xe4, 0x24, 0x6c, 0xd1, 0x0b, 0xa3, 0x20, 0x09,
+	0xf8, 0xaf, 0x1f, 0xba, 0xbd, 0xe4, 0x31, 0x64, 0x71, 0x57, 0x40, 0xa4, 0xe1, 0xeb, 0x1c, 0x65,
+	0xc9, 0x8a, 0xa5, 0xf1, 0xc7, 0x6f, 0xf6, 0xca, 0x2d, 0x83, 0x65, 0x90, 0xe9, 0xdc, 0xf4, 0x4e,
+	0xec, 0xb2, 0x22, 0x7c, 0x95, 0x89, 0xdb, 0x5f, 0x82, 0x6a, 0x39, 0x7e, 0xb8, 0x61, 0xe4, 0x15,
+	0x54, 0x7f, 0x72, 0x36, 0x29, 0xd3, 0x50, 0x0b, 0x75, 0x10, 0xcd, 0x36, 0xe4, 0x13, 0xa8, 0x27,
+	0x6b, 0x9f, 0xc5, 0x89, 0xe3, 0x87, 0x9a, 0xd4, 0x42, 0x1d, 0x99, 0x16, 0x40, 0xfb, 0x01, 0x4e,
+	0x8c, 0x9f, 0x99, 0x1f, 0x6e, 0x9c, 0x88, 0xbc, 0x05, 0x75, 0xe3, 0xb8, 0x6c, 0x13, 0x6b, 0xa8,
+	0x25, 0x77, 0x4e, 0xdf, 0xbd, 0xec, 0xee, 0xfb, 0xea, 0xde, 0xf0, 0xb3, 0x2b, 0xe5, 0xe9, 0xaf,
+	0xf3, 0x0a, 0xcd, 0x89, 0x45, 0x4b, 0xe9, 0x3f, 0x5b, 0xca, 0x87, 0x2d, 0xff, 0x90, 0x01, 0xec,
+	0xb5, 0xcf, 0x2c, 0x16, 0xad, 0x59, 0xfc, 0x3e, 0x5d, 0x2f, 0xa1, 0x16, 0x8b, 0x47, 0x8e, 0x35,
+	0x49, 0x68, 0x5e, 0x95, 0x35, 0xd9, 0xfb, 0xc8, 0x45, 0x3b, 0x2a, 0x79, 0x0d, 0x0a, 0x7f, 0xe9,
+	0xc2, 0xd0, 0x8b, 0x77, 0x5a, 0x59, 0x62, 0xb2, 0x24, 0x5a, 0x7b, 0xf6, 0x63, 0xc8, 0xa8, 0x60,
+	0x11, 0x02, 0x4a, 0xba, 0x5d, 0x27, 0x9a, 0xd2, 0x42, 0x9d, 0x3a, 0x15, 0x6b, 0x8e, 0xad, 0xd8,
+	0x26, 0xd4, 0xaa, 0x19, 0xc6, 0xd7, 0xe4, 0x0d, 0xd4, 0x7c, 0x7d, 0x2e, 0x0a, 0x33, 0x51, 0xf8,
+	0xc0, 0x8b, 0xa9, 0x8b, 0xa2, 0xaa, 0x2f, 0xfe, 0xc9, 0x6b, 0x50, 0xe3, 0x20, 0x8d, 0x3c, 0xa6,
+	0xdd, 0x1d, 0x63, 0x5b, 0xe2, 0x8c, 0xe6, 0x1c, 0xf2, 0x39, 0xd4, 0x59, 0x3e, 0x9d, 0x58, 0x5b,
+	0x8a, 0x47, 0xfd, 0xa8, 0x2c, 0xd8, 0x0d, 0x2f, 0x7f, 0xd8, 0x82, 0x4e, 0xa6, 0xf0, 0x61, 0xe8,
+	0x78, 0xf7, 0x6c, 0x31, 0x5f, 0xad, 0xe3, 0x24, 0x58, 0x46, 0x8e, 0x1f, 0x6b, 0x3f, 0x8a, 0x1a,
+	0x9f, 0x96, 0x6b, 0x4c, 0x05, 0x6d, 0xb8, 0x63, 0xe5, 0xa5, 0x70, 0x58, 0x86, 0xe3, 0xf6, 0x6f,
+	0x08, 0xce, 0x0e, 0xb8, 0xe5, 0x51, 0xa3, 0x83, 0x51, 0x13, 0x0c, 0x72, 0x9c, 0xfa, 0x79, 0x38,
+	0xf8, 0x92, 0x07, 0xc6, 0x0b, 0xd2, 0x6d, 0x22, 0xa6, 0x80, 0x68, 0xb6, 0x21, 0x5f, 0x41, 0xcd,
+	0x4d, 0xbd, 0x7b, 0x96, 0xc4, 0x9a, 0x72, 0xcc, 0x61, 0xe1, 0x4d, 0xb0, 0x76, 0x93, 0xcd, 0x35,
+	0xed, 0x21, 0x9c, 0x1d, 0x30, 0xc8, 0x39, 0x9c, 0xa6, 0x61, 0xc8, 0xa2, 0xb9, 0x1b, 0xa4, 0xdb,
+	0x45, 0x7e, 0x23, 0x40, 0x40, 0x57, 0x1c, 0x29, 0x8c, 0x48, 0x7b, 0x46, 0xda, 0x6f, 0xa1, 0x2a,
+	0x02, 0xc7, 0x47, 0xbd, 0x75, 0xfc, 0xec, 0x2a, 0x35, 0xa8, 0x58, 0x97, 0xc3, 0xde, 0xc8, 0xc3,
+	0xde, 0xfe, 0x02, 0xd4, 0x9b, 0x2c, 0x96, 0xff, 0x3f, 0xc9, 0xed, 0xdf, 0x11, 0x34, 0x04, 0x6e,
+	0x3a, 0x89, 0xb7, 0x62, 0x11, 0xd1, 0xf3, 0x90, 0x22, 0x91, 0x8e, 0xf3, 0x23, 0x15, 0x72, 0x66,
+	0xb7, 0x9c, 0x55, 0x61, 0x56, 0x3a, 0x66, 0x56, 0xde, 0x37, 0xdb, 0x01, 0x45, 0xc4, 0x50, 0x05,
+	0xc9, 0xb8, 0xc5, 0x15, 0x52, 0x03, 0x79, 0x6c, 0xdc, 0x62, 0xc4, 0x01, 0x6a, 0x60, 0x49, 0x00,
+	0xd4, 0xc0, 0xf2, 0xc5, 0x2f, 0x00, 0xc5, 0x9d, 0x20, 0xa7, 0x50, 0x9b, 0x8d, 0xbf, 0x1d, 0x4f,
+	0xbe, 0x1b, 0xe3, 0x0a, 0xdf, 0x5c, 0x4f, 0x66, 0x63, 0xdb, 0xa0, 0x18, 0x91, 0x3a, 0x54, 0x07,
+	0xfd, 0xd9, 0x80, 0x6b, 0x3f, 0x80, 0xfa, 0x70, 0x64, 0xd9, 0x93, 0x01, 0xed, 0x9b, 0x58, 0x26,
+	0x2f, 0xe1, 0x4c, 0x9c, 0xcc, 0x0b, 0x50, 0xe1, 0x5a, 0x6b, 0x66, 0x9a, 0x7d, 0xfa, 0x3d, 0xae,
+	0x92, 0x13, 0x50, 0x46, 0xe3, 0x6f, 0x26, 0x58, 0x25, 0x0d, 0x38, 0xb1, 0xec, 0xbe, 0x6d, 0x58,
+	0x86, 0x8d, 0x6b, 0x17, 0x97, 0xa0, 0x66, 0xd7, 0x86, 0xe3, 0xa6, 0x3e, 0xcf, 0x1a, 0x54, 0xc8,
+	0x0b, 0x00, 0x53, 0x9f, 0x17, 0xbd, 0xb3, 0x53, 0x7b, 0x64, 0x1a, 0x14, 0x4b, 0x17, 0x9f, 0x81,
+	0x9a, 0x5d, 0x1f, 0xce, 0x9b, 0xd2, 0x89, 0x69, 0xd8, 0x43, 0x63, 0x66, 0xe1, 0x0a, 0xe7, 0x0d,
+	0x68, 0x7f, 0x3a, 0x1c, 0xd9, 0x06, 0x46, 0x04, 0x43, 0x63, 0x32, 0x35, 0xc6, 0x73, 0xd3, 0xb0,
+	0xe9, 0xe8, 0xda, 0xc2, 0xd2, 0x95, 0xf6, 0xf4, 0xdc, 0x44, 0x7f, 0x3e, 0x37, 0xd1, 0xdf, 0xcf,
+	0x4d, 0xf4, 0xeb, 0x3f, 0xcd, 0xca, 0x0f, 0x6a, 0xf6, 0x95, 0x76, 0x55, 0xf1, 0x8d, 0xd5, 0xff,
+	0x1d, 0x00, 0x23, 0x19, 0x24, 0x74, 0xe3, 0x05, 0x00, 0x00,
 }
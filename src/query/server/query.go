@@ -697,6 +697,14 @@ func Run(runOpts RunOptions) RunResult {
 	if err := handler.RegisterRoutes(); err != nil {
 		logger.Fatal("unable to register routes", zap.Error(err))
 	}
+	// NB: Deferred before the server shutdown so that handlers are closed
+	// once in-flight requests complete.
+	defer func() {
+		logger.Info("closing handlers")
+		if err := handler.Close(); err != nil {
+			logger.Error("error closing handlers", zap.Error(err))
+		}
+	}()
 
 	listenAddress := cfg.ListenAddressOrDefault()
 	srvHandler := handler.Router()
@@ -85,19 +85,29 @@ func PromLabelsToM3Tags(
 // PromTimeSeriesToSeriesAttributes extracts the series info from a prometheus
 // timeseries.
 func PromTimeSeriesToSeriesAttributes(series prompb.TimeSeries) (ts.SeriesAttributes, error) {
+	var (
+		attributes ts.SeriesAttributes
+		err        error
+	)
 	switch series.Source {
 	case prompb.Source_PROMETHEUS:
-		return seriesAttributesForPrometheusSource(series)
+		attributes, err = seriesAttributesForPrometheusSource(series)
 
 	case prompb.Source_OPEN_METRICS:
-		return seriesAttributesForOpenMetricsSource(series)
+		attributes, err = seriesAttributesForOpenMetricsSource(series)
 
 	case prompb.Source_GRAPHITE:
-		return seriesAttributesForGraphiteSource(series)
+		attributes, err = seriesAttributesForGraphiteSource(series)
 
 	default:
 		return ts.SeriesAttributes{}, fmt.Errorf("invalid source type %s", series.Source)
 	}
+	if err != nil {
+		return ts.SeriesAttributes{}, err
+	}
+
+	attributes.Unit = series.Unit
+	return attributes, nil
 }
 
 func seriesAttributesForPrometheusSource(series prompb.TimeSeries) (ts.SeriesAttributes, error) {
@@ -285,6 +295,8 @@ func SeriesAttributesToAnnotationPayload(seriesAttributes ts.SeriesAttributes) (
 		return annotation.Payload{
 			SourceFormat: annotation.SourceFormat_GRAPHITE,
 			GraphiteType: metricType,
+			Unit:         seriesAttributes.Unit,
+			StaleMarker:  seriesAttributes.StaleMarker,
 		}, nil
 	}
 
@@ -298,9 +310,39 @@ func SeriesAttributesToAnnotationPayload(seriesAttributes ts.SeriesAttributes) (
 		SourceFormat:                 annotation.SourceFormat_OPEN_METRICS,
 		OpenMetricsFamilyType:        metricType,
 		OpenMetricsHandleValueResets: seriesAttributes.HandleValueResets,
+		Unit:                         seriesAttributes.Unit,
+		CounterReset:                 seriesAttributes.CounterReset,
+		StaleMarker:                  seriesAttributes.StaleMarker,
 	}, nil
 }
 
+// PromExemplarsToAnnotationExemplars converts Prometheus exemplars into
+// annotation exemplars.
+func PromExemplarsToAnnotationExemplars(exemplars []prompb.Exemplar) []*annotation.Exemplar {
+	if len(exemplars) == 0 {
+		return nil
+	}
+
+	result := make([]*annotation.Exemplar, 0, len(exemplars))
+	for _, exemplar := range exemplars {
+		labels := make([]*annotation.ExemplarLabel, 0, len(exemplar.Labels))
+		for _, label := range exemplar.Labels {
+			labels = append(labels, &annotation.ExemplarLabel{
+				Name:  label.Name,
+				Value: label.Value,
+			})
+		}
+
+		result = append(result, &annotation.Exemplar{
+			Labels:         labels,
+			Value:          exemplar.Value,
+			TimestampNanos: int64(promTimestampToUnixNanos(exemplar.Timestamp)),
+		})
+	}
+
+	return result
+}
+
 // PromSamplesToM3Datapoints converts Prometheus samples to M3 datapoints
 func PromSamplesToM3Datapoints(samples []prompb.Sample) ts.Datapoints {
 	datapoints := make(ts.Datapoints, 0, len(samples))
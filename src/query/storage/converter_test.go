@@ -299,6 +299,21 @@ func TestPromTimeSeriesToSeriesAttributesSource(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestPromTimeSeriesToSeriesAttributesUnit(t *testing.T) {
+	for _, source := range []prompb.Source{
+		prompb.Source_PROMETHEUS,
+		prompb.Source_OPEN_METRICS,
+		prompb.Source_GRAPHITE,
+	} {
+		attributes, err := PromTimeSeriesToSeriesAttributes(prompb.TimeSeries{
+			Source: source,
+			Unit:   "seconds",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "seconds", attributes.Unit)
+	}
+}
+
 func TestPromTimeSeriesToSeriesAttributesPromMetricsTypeFromPrometheus(t *testing.T) {
 	mapping := map[prompbMetricTypeWithNameSuffix]promMetricTypeWithBool{
 		{metricType: prompb.MetricType_UNKNOWN}:  {metricType: ts.PromMetricTypeUnknown},
@@ -522,6 +537,21 @@ func testSeriesAttributesToAnnotationPayload(t *testing.T, source ts.SourceType)
 	})
 	require.NoError(t, err)
 	assert.False(t, payload.OpenMetricsHandleValueResets)
+
+	payload, err = SeriesAttributesToAnnotationPayload(ts.SeriesAttributes{
+		Source: source,
+		Unit:   "bytes",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "bytes", payload.Unit)
+
+	payload, err = SeriesAttributesToAnnotationPayload(ts.SeriesAttributes{
+		Source:       source,
+		PromType:     ts.PromMetricTypeCounter,
+		CounterReset: true,
+	})
+	require.NoError(t, err)
+	assert.True(t, payload.CounterReset)
 }
 
 func TestGraphiteSeriesAttributesToAnnotationPayload(t *testing.T) {
@@ -576,3 +606,22 @@ type promMetricTypeWithBool struct {
 	metricType        ts.PromMetricType
 	handleValueResets bool
 }
+
+func TestPromExemplarsToAnnotationExemplars(t *testing.T) {
+	assert.Nil(t, PromExemplarsToAnnotationExemplars(nil))
+
+	exemplars := PromExemplarsToAnnotationExemplars([]prompb.Exemplar{
+		{
+			Labels:    []prompb.Label{{Name: []byte("trace_id"), Value: []byte("abc")}},
+			Value:     42,
+			Timestamp: 1000,
+		},
+	})
+	assert.Equal(t, []*annotation.Exemplar{
+		{
+			Labels:         []*annotation.ExemplarLabel{{Name: []byte("trace_id"), Value: []byte("abc")}},
+			Value:          42,
+			TimestampNanos: int64(time.Second),
+		},
+	}, exemplars)
+}
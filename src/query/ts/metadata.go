@@ -85,6 +85,9 @@ type SeriesAttributes struct {
 	PromType          PromMetricType
 	Source            SourceType
 	HandleValueResets bool
+	Unit              string
+	CounterReset      bool
+	StaleMarker       bool
 }
 
 // DefaultSeriesAttributes returns a default series attributes.
@@ -84,6 +84,12 @@ func ReadContextID(ctx context.Context) string {
 	return undefinedID
 }
 
+// HasContextID returns whether the context has an id.
+func HasContextID(ctx context.Context) bool {
+	_, ok := ctx.Value(rqIDKey).(string)
+	return ok
+}
+
 // WithContext returns a zap logger with as much context as possible.
 func WithContext(ctx context.Context, instrumentOpts instrument.Options) *zap.Logger {
 	if ctx == nil {
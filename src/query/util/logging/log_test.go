@@ -32,8 +32,10 @@ import (
 func TestContextWithID(t *testing.T) {
 	ctx := context.TODO()
 	assert.Equal(t, undefinedID, ReadContextID(ctx))
+	assert.False(t, HasContextID(ctx))
 
 	id := "cool id"
 	ctx = NewContextWithID(ctx, id, instrument.NewOptions())
 	assert.Equal(t, id, ReadContextID(ctx))
+	assert.True(t, HasContextID(ctx))
 }
@@ -181,6 +181,50 @@ func GetInnerResourceExhaustedError(err error) error {
 	return nil
 }
 
+type indexFullError struct {
+	containedError
+}
+
+// NewIndexFullError creates a new index full error, returned when a new
+// series cannot be inserted because the index is at capacity.
+func NewIndexFullError(inner error) error {
+	return indexFullError{containedError{inner}}
+}
+
+func (e indexFullError) Error() string {
+	return e.inner.Error()
+}
+
+func (e indexFullError) InnerError() error {
+	return e.inner
+}
+
+// IsIndexFull returns true if this is an index full error.
+func IsIndexFull(err error) bool {
+	return GetInnerIndexFullError(err) != nil
+}
+
+// GetInnerIndexFullError returns an inner index full error if contained by
+// this error, nil otherwise.
+func GetInnerIndexFullError(err error) error {
+	for err != nil {
+		// nolint:errorlint
+		if _, ok := err.(indexFullError); ok {
+			return InnerError(err)
+		}
+		// nolint:errorlint
+		if multiErr, ok := err.(MultiError); ok {
+			for _, e := range multiErr.Errors() {
+				if inner := GetInnerIndexFullError(e); inner != nil {
+					return inner
+				}
+			}
+		}
+		err = InnerError(err)
+	}
+	return nil
+}
+
 // Is checks if the error is or contains the corresponding target error.
 // It's intended to mimic the errors.Is functionality, but also consider xerrors' MultiError / InnerError
 // wrapping functionality.
@@ -43,6 +43,13 @@ func TestWrap(t *testing.T) {
 	assert.Equal(t, "context about resource exhausted error: detailed error message", wrappedErr.Error())
 	assert.True(t, IsResourceExhausted(wrappedErr))
 
+	err = NewIndexFullError(inner)
+	wrappedErr = Wrap(err, "context about index full error")
+	assert.Error(t, wrappedErr)
+	assert.Equal(t, "context about index full error: detailed error message", wrappedErr.Error())
+	assert.True(t, IsIndexFull(wrappedErr))
+	assert.False(t, IsResourceExhausted(wrappedErr))
+
 	err = NewRetryableError(inner)
 	wrappedErr = Wrap(err, "context about retryable error")
 	assert.Error(t, wrappedErr)
@@ -70,6 +77,13 @@ func TestWrapf(t *testing.T) {
 	assert.Equal(t, "context about resource exhausted error: detailed error message", wrappedErr.Error())
 	assert.True(t, IsResourceExhausted(wrappedErr))
 
+	err = NewIndexFullError(inner)
+	wrappedErr = Wrapf(err, "context about %s error", "index full")
+	assert.Error(t, wrappedErr)
+	assert.Equal(t, "context about index full error: detailed error message", wrappedErr.Error())
+	assert.True(t, IsIndexFull(wrappedErr))
+	assert.False(t, IsResourceExhausted(wrappedErr))
+
 	err = NewRetryableError(inner)
 	wrappedErr = Wrapf(err, "context about %s error", "retryable")
 	assert.Error(t, wrappedErr)
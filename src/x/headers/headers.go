@@ -52,6 +52,17 @@ const (
 	// field `headerToMetricType`)
 	PromTypeHeader = "Prometheus-Metric-Type"
 
+	// PromTypesHeader sets the prometheus metric type per metric name as a
+	// JSON object mapping metric names to types, e.g.
+	// {"http_requests_total":"counter"}. Series whose name is not in the map
+	// fall back to the type set by PromTypeHeader, if any.
+	PromTypesHeader = "Prometheus-Metric-Types"
+
+	// PromUnitHeader sets the unit of the prometheus metric, e.g. "bytes" or
+	// "seconds", stored in the series annotation when metrics types are
+	// stored.
+	PromUnitHeader = "Prometheus-Metric-Unit"
+
 	// WriteTypeHeader is a header that controls if default
 	// writes should be written to both unaggregated and aggregated
 	// namespaces, or if unaggregated values are skipped and
@@ -234,4 +245,75 @@ const (
 	// RelatedQueriesHeader headers may NOT be sent. When multiple values are required, they can be separated
 	// by a semicolons (e.g. startTs:endTs;startTs:endTs).
 	RelatedQueriesHeader = M3HeaderPrefix + "Related-Queries"
+
+	// DebugResponseDelayHeader is a header that, if set, delays the response
+	// of a remote write by the given duration (e.g. "500ms") for load testing
+	// client backoff. It is ignored unless debug response delays are enabled
+	// by the server.
+	DebugResponseDelayHeader = M3HeaderPrefix + "Debug-Response-Delay"
+
+	// DebugTextExpositionHeader is a header that, if set to true, responds to
+	// a remote write with the decoded series rendered in the Prometheus text
+	// exposition format instead of writing them, for debugging client
+	// payloads.
+	DebugTextExpositionHeader = M3HeaderPrefix + "Debug-Text-Exposition"
+
+	// DebugDropCountsHeader is a header that, if set to true, returns the
+	// number of series or labels dropped from a remote write for each reason
+	// in the response headers.
+	DebugDropCountsHeader = M3HeaderPrefix + "Debug-Drop-Counts"
+
+	// DroppedNoNameHeader is the response header with the number of series
+	// dropped from a remote write for missing a metric name.
+	DroppedNoNameHeader = M3HeaderPrefix + "Dropped-No-Name"
+
+	// DroppedTruncatedHeader is the response header with the number of series
+	// dropped from a remote write by truncation to the max samples per request.
+	DroppedTruncatedHeader = M3HeaderPrefix + "Dropped-Truncated"
+
+	// DroppedDuplicateLabelsHeader is the response header with the number of
+	// duplicate labels dropped from the series of a remote write.
+	DroppedDuplicateLabelsHeader = M3HeaderPrefix + "Dropped-Duplicate-Labels"
+
+	// DroppedMalformedHeader is the response header with the number of
+	// malformed series dropped from a remote write.
+	DroppedMalformedHeader = M3HeaderPrefix + "Dropped-Malformed"
+
+	// DroppedDeniedHeader is the response header with the number of series
+	// dropped from a remote write for matching the series denylist.
+	DroppedDeniedHeader = M3HeaderPrefix + "Dropped-Denied"
+
+	// AsyncWriteHeader is a header that, if set to true, responds to a remote
+	// write with a 202 as soon as it is queued rather than once it is
+	// written, or if set to false writes it before responding. It is ignored
+	// unless async writes are enabled by the server.
+	AsyncWriteHeader = M3HeaderPrefix + "Async-Write"
+
+	// ForwardTargetsHeader is a header that selects, by a comma separated
+	// list of names, the forwarding targets a remote write is forwarded to
+	// instead of the configured targets. Only targets the server allows to be
+	// selected can be named.
+	ForwardTargetsHeader = M3HeaderPrefix + "Forward-Targets"
+
+	// WritePriorityHeader is a header that selects, by name, the priority
+	// pool a remote write is admitted by, so that latency critical writes
+	// are not starved by bulk writes. Requests without it are admitted by
+	// the default pool.
+	WritePriorityHeader = M3HeaderPrefix + "Write-Priority"
+
+	// ErrorCodeHeader is the response header carrying the machine readable
+	// code of the reason a write request was rejected.
+	ErrorCodeHeader = M3HeaderPrefix + "Error-Code"
+
+	// IdempotencyKeyHeader is the header used by clients to identify a write
+	// so that retries of the same write are only written once.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// PromRemoteWriteVersionHeader is the header used by Prometheus remote
+	// write clients to specify the remote write protocol version.
+	PromRemoteWriteVersionHeader = "X-Prometheus-Remote-Write-Version"
+
+	// RequestIDHeader is the header used to correlate a request end-to-end,
+	// if not set by the client one is generated and returned in the response.
+	RequestIDHeader = "X-Request-ID"
 )